@@ -1,87 +1,430 @@
 package api
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/archive"
+	"github.com/kdrake/nearestdots/cluster"
+	"github.com/kdrake/nearestdots/geofence"
+	"github.com/kdrake/nearestdots/graph"
+	"github.com/kdrake/nearestdots/ingest"
+	"github.com/kdrake/nearestdots/orders"
+	"github.com/kdrake/nearestdots/replication"
+	"github.com/kdrake/nearestdots/routing"
 	"github.com/kdrake/nearestdots/storage"
+	"github.com/kdrake/nearestdots/stream"
+	"github.com/kdrake/nearestdots/tracing"
+	"github.com/kdrake/nearestdots/webhook"
 	"github.com/labstack/echo"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
+// defaultListLimit is number of drivers in a page if limit is not set
+const defaultListLimit = 100
+
+// defaultHeatmapPrecision is geohash precision of heatmap cells if precision is not set
+const defaultHeatmapPrecision = 6
+
+// defaultReserveTTL is how long drivers are reserved if ttl is not set
+const defaultReserveTTL = 30 * time.Second
+
+// defaultNearestCount is number of nearest drivers returned if count is not set
+const defaultNearestCount = 10
+
+// namespaceHeader selects namespace if it's not in the URL path
+const namespaceHeader = "X-Namespace"
+
+// storageKey is a context key of the storage of the request namespace
+const storageKey = "storage"
+
+// defaultAverageSpeed is speed in meters per second for ETA of standing drivers
+const defaultAverageSpeed = 8.3
+
 // API top level api instance
 type API struct {
-	database  *storage.DriverStorage
-	waitGroup sync.WaitGroup
-	echo      *echo.Echo
-	bindAddr  string
+	namespaces     *storage.Manager
+	averageSpeed   float64
+	router         routing.Router
+	rerankDepth    int
+	weights        storage.Weights
+	nearestCache   *nearestCache
+	idempotency    *idempotency
+	demand         *demandTracker
+	h3Resolutions  []int
+	archive        *archive.Archive
+	fences         *geofence.Manager
+	webhooks       *webhook.Manager
+	udp            *ingest.UDP
+	graph          *graph.Schema
+	stream         *stream.Hub
+	keys           Keys
+	jwtSecret      []byte
+	limiter        *limiter
+	tls            *tls.Config
+	maxConnections int
+	janitor        *storage.Janitor
+	tracer         trace.Tracer
+	logger         *zap.Logger
+	spec           map[string]interface{}
+	docs           bool
+	metrics        bool
+	orders         *orders.Service
+	cluster        *cluster.Cluster
+	replication    *replication.Log
+	waitGroup      sync.WaitGroup
+	done           chan struct{}
+	echo           *echo.Echo
+	bindAddrs      []string
 }
 
-// New get new API instance.
-func New(bindAddr string, lruSize int) *API {
+// New get new API instance backed by storages of namespaces, it serves comma separated
+// TCP addresses and unix sockets of the bind address.
+// Namespace is taken from /api/ns/:namespace path or X-Namespace header,
+// requests without it use the default namespace.
+func New(bindAddr string, namespaces *storage.Manager, fences *geofence.Manager, opts ...Option) *API {
 	a := &API{}
-	a.database = storage.New(lruSize)
+	a.namespaces = namespaces
+	a.averageSpeed = defaultAverageSpeed
+	a.fences = fences
+	a.orders = orders.New()
 	a.echo = echo.New()
-	a.bindAddr = bindAddr
+	// start is logged by Start
+	a.echo.HideBanner = true
+	a.echo.HidePort = true
+	a.echo.HTTPErrorHandler = a.httpError
+	a.bindAddrs = bindAddrs(bindAddr)
+	a.done = make(chan struct{})
+	a.logger = zap.L()
+	for _, opt := range opts {
+		opt(a)
+	}
 
-	g := a.echo.Group("/api")
-	g.POST("/driver/", a.addDriver)
-	g.GET("/driver/:id", a.getDriver)
-	g.DELETE("/driver/:id", a.deleteDriver)
-	g.GET("/driver/:lat/:lon/nearest", a.addDriver)
+	// rejected requests are logged and traced too
+	a.echo.Use(a.logRequests)
+	if a.tracer != nil {
+		a.echo.Use(a.traceRequests)
+	}
+	if a.limiter != nil {
+		a.echo.Use(a.rateLimit)
+	}
+
+	a.graph = graph.New(a.averageSpeed)
+	// namespace of GraphQL queries is taken from X-Namespace header
+	a.echo.POST("/graphql", a.graphQL, a.authorize(RoleDispatcher), a.namespace(false))
+
+	// /api is v1 of clients predating versioned routes
+	a.routes(a.echo.Group("/api", versioned(v1)))
+	a.routes(a.echo.Group("/v1", versioned(v1)))
+	a.routes(a.echo.Group("/v2", versioned(v2)))
+
+	// probes are not authorized, kubelet has no keys
+	a.echo.GET("/healthz", a.health)
+	a.echo.GET("/readyz", a.ready)
+	// scrapers have no keys like probes, metrics are served only if enabled
+	if a.metrics {
+		a.echo.GET("/metrics", a.metricsHandler())
+	}
+
+	// the spec is generated from routes registered above
+	a.echo.GET("/openapi.json", a.openAPI)
+	if a.docs {
+		a.echo.GET("/docs", a.swaggerUI)
+	}
+	a.spec = a.openAPISpec()
 
 	return a
 }
 
-func (a *API) WaitStop() {
-	a.waitGroup.Wait()
+// routes registers routes of an API version in the group
+func (a *API) routes(g *echo.Group) {
+	a.driverRoutes(g)
+	a.driverRoutes(g.Group("/ns/:namespace"))
+	// geofences are disabled if there is no manager
+	dispatcher := a.authorize(RoleDispatcher)
+	if a.fences != nil {
+		g.POST("/geofences", a.addFence, dispatcher)
+		g.GET("/geofences", a.listFences, dispatcher)
+		g.DELETE("/geofences/:name", a.removeFence, dispatcher)
+		g.GET("/geofences/events", a.fenceEvents, dispatcher)
+	}
+	// webhooks are disabled if there is no manager
+	if a.webhooks != nil {
+		g.POST("/webhooks", a.addWebhook, dispatcher)
+		g.GET("/webhooks", a.listWebhooks, dispatcher)
+		g.DELETE("/webhooks/:id", a.removeWebhook, dispatcher)
+		g.GET("/webhooks/dead", a.deadLetters, dispatcher)
+	}
+	// streaming is disabled if there is no hub
+	if a.stream != nil {
+		g.GET("/ws", a.streamUpdates, dispatcher)
+		g.GET("/driver/:lat/:lon/nearest/events", a.nearestEvents, dispatcher)
+	}
+	// members are listed by cluster nodes only
+	if a.cluster != nil {
+		g.GET("/cluster/members", a.clusterMembers, dispatcher)
+	}
+	// replicas follow the primary if it has a replication log
+	if a.replication != nil {
+		g.GET("/replication/changes", a.replicationChanges, dispatcher)
+	}
 }
 
-func (a *API) removeExpired() {
-	for range time.Tick(1) {
-		a.database.DeleteExpired()
+// Option configures API
+type Option func(*API)
+
+// WithAverageSpeed sets speed in meters per second used for ETA of standing drivers
+func WithAverageSpeed(speed float64) Option {
+	return func(a *API) {
+		a.averageSpeed = speed
 	}
 }
 
+// WithRouter re-ranks depth nearest drivers by road travel time from the router,
+// ETA of nearest drivers is the travel time then
+func WithRouter(router routing.Router, depth int) Option {
+	return func(a *API) {
+		a.router = router
+		a.rerankDepth = depth
+	}
+}
+
+// WithStream pushes location updates of the hub to websocket clients of /api/ws
+// and nearest drivers to server-sent events clients
+func WithStream(hub *stream.Hub) Option {
+	return func(a *API) {
+		a.stream = hub
+	}
+}
+
+// WithUDP reports packet counters of the UDP receiver in stats
+func WithUDP(u *ingest.UDP) Option {
+	return func(a *API) {
+		a.udp = u
+	}
+}
+
+// WithCluster serves requests of peers of the cluster node,
+// they are authorized by the cluster key and bypass middlewares of the API
+func WithCluster(c *cluster.Cluster) Option {
+	return func(a *API) {
+		a.cluster = c
+	}
+}
+
+// WithReplication streams changes of the log of the default namespace to replicas
+func WithReplication(log *replication.Log) Option {
+	return func(a *API) {
+		a.replication = log
+	}
+}
+
+// WithSwaggerUI serves Swagger UI of the OpenAPI specification at /docs
+func WithSwaggerUI() Option {
+	return func(a *API) {
+		a.docs = true
+	}
+}
+
+func (a *API) driverRoutes(g *echo.Group) {
+	driver, dispatcher := a.authorize(RoleDriver), a.authorize(RoleDispatcher)
+	// dispatchers change and remove drivers too, e.g. taking them off duty
+	both := a.authorize(RoleDriver, RoleDispatcher)
+	// namespaces are created by routes adding drivers once they're authorized, other routes of unknown ones fail
+	create, lookup := a.namespace(true), a.namespace(false)
+	g.POST("/driver/", a.addDriver, driver, create, a.idempotent)
+	g.POST("/drivers/batch", a.batchDrivers, driver, create, a.idempotent)
+	g.POST("/drivers/locations", a.updateLocations, driver, create, a.idempotent)
+	g.GET("/driver/:id", a.getDriver, dispatcher, lookup)
+	g.GET("/drivers", a.listDrivers, dispatcher, lookup)
+	g.GET("/drivers/idle", a.idleDrivers, dispatcher, lookup)
+	g.GET("/drivers/flagged", a.flaggedDrivers, dispatcher, lookup)
+	g.DELETE("/driver/:id", a.deleteDriver, both, lookup)
+	g.PUT("/driver/:id/status", a.setDriverStatus, both, lookup)
+	g.PUT("/driver/:id/attributes", a.setDriverAttributes, both, lookup)
+	g.GET("/driver/:id/locations", a.driverLocations, dispatcher, lookup)
+	g.GET("/driver/:id/history/stats", a.driverHistoryStats, dispatcher, lookup)
+	g.GET("/driver/:id/track.gpx", a.driverTrackGPX, dispatcher, lookup)
+	g.GET("/driver/:id/track.geojson", a.driverTrackGeoJSON, dispatcher, lookup)
+	g.POST("/driver/:id/shift/start", a.startShift, driver, lookup)
+	g.POST("/driver/:id/shift/stop", a.stopShift, driver, lookup)
+	g.GET("/driver/:id/shifts", a.driverShifts, dispatcher, lookup)
+	g.GET("/driver/:lat/:lon/nearest", a.nearestDrivers, dispatcher, lookup)
+	g.POST("/drivers/reserve", a.reserveDrivers, dispatcher, lookup)
+	g.POST("/eta-matrix", a.etaMatrix, dispatcher, lookup)
+	g.GET("/drivers/bbox", a.boundingBoxDrivers, dispatcher, lookup)
+	g.GET("/drivers/clusters", a.clusterDrivers, dispatcher, lookup)
+	g.POST("/drivers/polygon", a.polygonDrivers, dispatcher, lookup)
+	g.POST("/drivers/route", a.routeDrivers, dispatcher, lookup)
+	g.GET("/stats", a.stats, dispatcher, lookup)
+	g.GET("/snapshot", a.getSnapshot, dispatcher, lookup)
+	g.PUT("/snapshot", a.restoreSnapshot, dispatcher, create)
+	g.GET("/heatmap", a.heatmap, dispatcher, lookup)
+	g.GET("/playback", a.playback, dispatcher, lookup)
+	g.GET("/tiles/:z/:x/:y", a.vectorTile, dispatcher, lookup)
+	// zones are served if demand is tracked
+	if a.demand != nil {
+		g.GET("/zones", a.zones, dispatcher, lookup)
+	}
+	// H3 cells are served at configured resolutions
+	if len(a.h3Resolutions) > 0 {
+		g.GET("/h3", a.h3Cells, dispatcher, lookup)
+		g.GET("/driver/:id/h3", a.driverH3, dispatcher, lookup)
+	}
+	g.POST("/orders", a.createOrder, dispatcher, lookup)
+	g.GET("/orders/:id", a.getOrder, dispatcher, lookup)
+	g.POST("/orders/:id/assign", a.assignOrder, dispatcher, lookup)
+	g.POST("/orders/:id/complete", a.completeOrder, dispatcher, lookup)
+	g.POST("/orders/:id/cancel", a.cancelOrder, dispatcher, lookup)
+}
+
+// namespace is a middleware resolving storage of the request namespace,
+// it's created on first use if create is true and requests of unknown namespaces fail otherwise
+func (a *API) namespace(create bool) echo.MiddlewareFunc {
+	resolve := a.namespaces.Lookup
+	if create {
+		resolve = a.namespaces.Namespace
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			s, err := resolve(namespaceName(c))
+			if err != nil {
+				return fail(c, err)
+			}
+			if a.tracer != nil {
+				s = tracing.Wrap(c.Request().Context(), a.tracer, s)
+			}
+			c.Set(storageKey, s)
+			return next(c)
+		}
+	}
+}
+
+// namespaceName returns namespace of the path or the header, the default one is empty
+func namespaceName(c echo.Context) string {
+	if name := c.Param("namespace"); name != "" {
+		return name
+	}
+	return c.Request().Header.Get(namespaceHeader)
+}
+
+// database returns storage of the request namespace
+func database(c echo.Context) storage.Storage {
+	return c.Get(storageKey).(storage.Storage)
+}
+
+// ServeHTTP serves API requests, so the API may be mounted into another server
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.cluster != nil && strings.HasPrefix(r.URL.Path, cluster.Path) {
+		a.cluster.ServeHTTP(w, r)
+		return
+	}
+	a.echo.ServeHTTP(w, r)
+}
+
+func (a *API) WaitStop() {
+	a.waitGroup.Wait()
+}
+
 // Start starts an HTTP server.
 func (a *API) Start() {
 	a.waitGroup.Add(1)
+	a.logger.Info("serving HTTP", zap.Strings("addrs", a.bindAddrs), zap.Bool("tls", a.tls != nil))
 	go func() {
-		a.echo.Start(a.bindAddr)
+		if err := a.serve(); err != http.ErrServerClosed {
+			a.logger.Error("HTTP server stopped", zap.Error(err))
+		}
 		a.waitGroup.Done()
 	}()
+}
 
-	a.waitGroup.Add(1)
-	go a.removeExpired()
+// Shutdown stops accepting connections, ends streams and waits for in-flight requests until ctx is done
+func (a *API) Shutdown(ctx context.Context) error {
+	close(a.done)
+	return a.echo.Shutdown(ctx)
+}
+
+func (a *API) clusterMembers(c echo.Context) error {
+	return c.JSON(http.StatusOK, &MembersResponse{
+		Success: true,
+		Message: "found",
+		Members: a.cluster.Members(),
+	})
 }
 
 func (a *API) addDriver(c echo.Context) error {
 	p := &Payload{}
+	if err := bindPayload(c, p); err != nil {
+		return failWith(c, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Set content-type application/json, application/x-protobuf or application/msgpack or check your payload data")
+	}
+	var errs fieldErrors
+	if errs.payload("", p); errs != nil {
+		return invalid(c, errs)
+	}
+	if !ownDriver(c, p.DriverID) {
+		return forbidDriver(c, p.DriverID)
+	}
+
+	if err := database(c).Set(p.Driver()); err != nil {
+		return fail(c, err)
+	}
+
+	return respond(c, http.StatusOK, &DefaultResponse{
+		Success: true,
+		Message: "Added",
+	})
+}
+
+func (a *API) batchDrivers(c echo.Context) error {
+	p := &BatchPayload{}
 	if err := c.Bind(p); err != nil {
-		return c.JSON(http.StatusUnsupportedMediaType, &DefaultResponse{
-			Success: false,
-			Message: "Set content-type application/json or check your payload data",
-		})
+		return failWith(c, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Set content-type application/json or check your payload data")
 	}
 
-	driver := &storage.Driver{}
-	driver.ID = p.DriverID
-	driver.LastLocation = storage.Location{
-		Lat: p.Location.Latitude,
-		Lon: p.Location.Longitude,
+	var errs fieldErrors
+	for i := range p.Set {
+		errs.payload("set."+strconv.Itoa(i)+".", &p.Set[i])
 	}
-	if err := a.database.Set(driver); err != nil {
-		return c.JSON(http.StatusBadRequest, &DefaultResponse{
-			Success: false,
-			Message: err.Error(),
-		})
+	for i, id := range p.Delete {
+		if id <= 0 {
+			errs.add("delete."+strconv.Itoa(i), "must be a positive integer")
+		}
+	}
+	if errs != nil {
+		return invalid(c, errs)
+	}
+
+	drivers := make([]*storage.Driver, 0, len(p.Set))
+	for i := range p.Set {
+		if !ownDriver(c, p.Set[i].DriverID) {
+			return forbidDriver(c, p.Set[i].DriverID)
+		}
+		drivers = append(drivers, p.Set[i].Driver())
+	}
+	for _, id := range p.Delete {
+		if !ownDriver(c, id) {
+			return forbidDriver(c, id)
+		}
+	}
+	if err := database(c).SetMany(drivers); err != nil {
+		return fail(c, err)
+	}
+	if err := database(c).DeleteMany(p.Delete); err != nil {
+		return fail(c, err)
 	}
 
 	return c.JSON(http.StatusOK, &DefaultResponse{
 		Success: true,
-		Message: "Added",
+		Message: "Applied",
 	})
 }
 
@@ -89,18 +432,16 @@ func (a *API) getDriver(c echo.Context) error {
 	driverID := c.Param("id")
 	id, err := strconv.Atoi(driverID)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, &DefaultResponse{
-			Success: false,
-			Message: "could not convert string to integer",
-		})
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "could not convert string to integer")
 	}
 
-	d, err := a.database.Get(id)
+	d, err := database(c).Get(id)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, &DefaultResponse{
-			Success: false,
-			Message: err.Error(),
-		})
+		return fail(c, err)
+	}
+	// conditional updates of the driver send the version back in If-Match
+	if d.Version != 0 {
+		c.Response().Header().Set(etagHeader, etag(d.Version))
 	}
 
 	return c.JSON(http.StatusOK, &DriverResponse{
@@ -110,21 +451,56 @@ func (a *API) getDriver(c echo.Context) error {
 	})
 }
 
+func (a *API) listDrivers(c echo.Context) error {
+	// cursor is next of the previous page, after is its older name
+	after := 0
+	v := c.QueryParam("cursor")
+	if v == "" {
+		v = c.QueryParam("after")
+	}
+	if v != "" {
+		var err error
+		after, err = strconv.Atoi(v)
+		if err != nil {
+			return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "cursor must be an integer")
+		}
+	}
+
+	limit := defaultListLimit
+	if v := c.QueryParam("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "limit must be a positive integer")
+		}
+	}
+
+	drivers := database(c).List(after, limit)
+	resp := &ListResponse{
+		Success: true,
+		Message: "found",
+		Drivers: drivers,
+	}
+	if len(drivers) == limit {
+		next := drivers[len(drivers)-1].ID
+		resp.Next = &next
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
 func (a *API) deleteDriver(c echo.Context) error {
 	driverID := c.Param("id")
 	id, err := strconv.Atoi(driverID)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, &DefaultResponse{
-			Success: false,
-			Message: "could not convert string to integer",
-		})
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "could not convert string to integer")
 	}
 
-	if err := a.database.Delete(id); err != nil {
-		return c.JSON(http.StatusBadRequest, &DefaultResponse{
-			Success: false,
-			Message: err.Error(),
-		})
+	if !ownDriver(c, id) {
+		return forbidDriver(c, id)
+	}
+
+	if err := database(c).Delete(id); err != nil {
+		return fail(c, err)
 	}
 
 	return c.JSON(http.StatusOK, &DefaultResponse{
@@ -133,38 +509,380 @@ func (a *API) deleteDriver(c echo.Context) error {
 	})
 }
 
-func (a *API) nearestDrivers(c echo.Context) error {
-	lat := c.Param("lat")
-	lon := c.Param("lon")
+func (a *API) setDriverStatus(c echo.Context) error {
+	driverID := c.Param("id")
+	id, err := strconv.Atoi(driverID)
+	if err != nil {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "could not convert string to integer")
+	}
 
-	if lat == "" || lon == "" {
-		return c.JSON(http.StatusBadRequest, &DefaultResponse{
-			Success: false,
-			Message: "empty coordinates",
+	if !ownDriver(c, id) {
+		return forbidDriver(c, id)
+	}
+	version, ok := ifMatch(c)
+	if !ok {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "If-Match must be a quoted driver version")
+	}
+
+	p := &StatusPayload{}
+	if err := c.Bind(p); err != nil {
+		return failWith(c, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Set content-type application/json or check your payload data")
+	}
+
+	// storages without versions change status unconditionally
+	if version == 0 {
+		err = database(c).SetStatus(id, p.Status)
+	} else {
+		err = a.updateIf(c, func(v versioner) (uint64, error) {
+			return v.SetStatusIf(id, p.Status, version)
 		})
 	}
+	if err != nil {
+		return fail(c, err)
+	}
 
-	lt, err := strconv.ParseFloat(lat, 64)
+	return c.JSON(http.StatusOK, &DefaultResponse{
+		Success: true,
+		Message: "updated",
+	})
+}
+
+func (a *API) driverLocations(c echo.Context) error {
+	_, points, err := driverHistory(c)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &HistoryResponse{
+		Success:   true,
+		Message:   "found",
+		Locations: points,
+	})
+}
+
+func (a *API) nearestDrivers(c echo.Context) error {
+	origin, errs := pathLocation(c)
+	if errs != nil {
+		return invalid(c, errs)
+	}
+
+	count, filters, err := nearestQuery(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	// drivers as they were at a past time are played back from the archive
+	if c.QueryParam("at") != "" {
+		return a.nearestAt(c, origin, count, filters)
+	}
+	weights, err := a.scoreWeights(c)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, &DefaultResponse{
-			Success: false,
-			Message: "failed convert float",
+		return fail(c, err)
+	}
+	a.recordDemand(c, origin)
+
+	if a.router == nil && weights.Zero() {
+		drivers := a.findNearest(c, origin, count, filters)
+		return respond(c, http.StatusOK, &NearestDriverResponse{
+			Success: true,
+			Message: "found",
+			Drivers: verbose(c, a.nearest(origin, drivers)),
 		})
 	}
 
-	ln, err := strconv.ParseFloat(lon, 64)
+	depth := count
+	if a.router != nil && a.rerankDepth > depth {
+		depth = a.rerankDepth
+	}
+	if !weights.Zero() && count*scoreCandidatesFactor > depth {
+		depth = count * scoreCandidatesFactor
+	}
+	drivers := a.findNearest(c, origin, depth, filters)
+	var nearest []*NearestDriver
+	if a.router != nil {
+		nearest = a.byTravelTime(c, origin, drivers)
+	} else {
+		nearest = a.nearest(origin, drivers)
+	}
+	if !weights.Zero() {
+		nearest = byScore(origin, nearest, weights)
+	}
+	if len(nearest) > count {
+		nearest = nearest[:count]
+	}
+	return respond(c, http.StatusOK, &NearestDriverResponse{
+		Success: true,
+		Message: "found",
+		Drivers: verbose(c, nearest),
+	})
+}
+
+// nearestQuery parses count, include_unavailable, attr, exclude_ids and only_ids query parameters of nearest drivers
+func nearestQuery(c echo.Context) (int, []storage.Filter, error) {
+	count := defaultNearestCount
+	if v := c.QueryParam("count"); v != "" {
+		var err error
+		count, err = strconv.Atoi(v)
+		if err != nil || count <= 0 {
+			return 0, nil, errors.New("count must be a positive integer")
+		}
+	}
+
+	// only available drivers are returned unless include_unavailable=true
+	var filters []storage.Filter
+	if include, _ := strconv.ParseBool(c.QueryParam("include_unavailable")); !include {
+		filters = append(filters, storage.Available())
+	}
+
+	// attr=key:value query parameters, all of them must match
+	if attrs := c.QueryParams()["attr"]; len(attrs) > 0 {
+		attributes := make(map[string]string, len(attrs))
+		for _, attr := range attrs {
+			kv := strings.SplitN(attr, ":", 2)
+			if len(kv) != 2 {
+				return 0, nil, errors.New("attr must be in key:value format")
+			}
+			attributes[kv[0]] = kv[1]
+		}
+		filters = append(filters, storage.AttributesFilter(attributes))
+	}
+
+	// exclude_ids skips drivers who declined, only_ids restricts the search to a fleet
+	exclude, err := queryIDs(c, "exclude_ids")
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(exclude) > 0 {
+		filters = append(filters, storage.ExcludeIDsFilter(exclude))
+	}
+	only, err := queryIDs(c, "only_ids")
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(only) > 0 {
+		filters = append(filters, storage.OnlyIDsFilter(only))
+	}
+	return count, filters, nil
+}
+
+// queryIDs parses comma separated ids of the query parameter, nil if it's empty
+func queryIDs(c echo.Context, name string) ([]int, error) {
+	v := c.QueryParam(name)
+	if v == "" {
+		return nil, nil
+	}
+	var ids []int
+	for _, s := range strings.Split(v, ",") {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, errors.New(name + " must be comma separated integers")
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// byTravelTime ranks drivers by road travel time to the origin, unreachable drivers
+// are dropped. Drivers stay ranked by distance if the routing engine fails.
+func (a *API) byTravelTime(c echo.Context, origin storage.Location, drivers []*storage.Driver) []*NearestDriver {
+	nearest := a.nearest(origin, drivers)
+	origins := make([]storage.Location, len(drivers))
+	for i, d := range drivers {
+		origins[i] = d.LastLocation
+	}
+	times, err := a.router.TravelTimes(origins, origin)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, &DefaultResponse{
-			Success: false,
-			Message: "failed convert float",
+		requestLogger(c).Warn("could not rank drivers by travel time", zap.Error(err))
+		return nearest
+	}
+
+	reachable := nearest[:0]
+	for i, n := range nearest {
+		if times[i] >= 0 {
+			n.ETASeconds = times[i].Seconds()
+			reachable = append(reachable, n)
+		}
+	}
+	sort.SliceStable(reachable, func(i, j int) bool {
+		return reachable[i].ETASeconds < reachable[j].ETASeconds
+	})
+	return reachable
+}
+
+// boundingBox parses min_lat, min_lon, max_lat and max_lon query parameters
+func boundingBox(c echo.Context) ([4]float64, error) {
+	var box [4]float64
+	for i, name := range []string{"min_lat", "min_lon", "max_lat", "max_lon"} {
+		v, err := strconv.ParseFloat(c.QueryParam(name), 64)
+		if err != nil {
+			return box, errors.New("failed convert float " + name)
+		}
+		limit := 90.0
+		if i%2 == 1 {
+			// longitude
+			limit = 180
+		}
+		if !(v >= -limit && v <= limit) {
+			return box, errors.New(name + " is out of range")
+		}
+		box[i] = v
+	}
+	return box, nil
+}
+
+// nearest adds distance and ETA to the origin to drivers
+func (a *API) nearest(origin storage.Location, drivers []*storage.Driver) []*NearestDriver {
+	nearest := make([]*NearestDriver, 0, len(drivers))
+	for _, d := range drivers {
+		nearest = append(nearest, &NearestDriver{
+			Driver:     d,
+			Distance:   storage.Distance(origin, d.LastLocation),
+			ETASeconds: storage.ETA(d, origin, a.averageSpeed).Seconds(),
 		})
 	}
+	return nearest
+}
+
+// verbose adds age of locations to nearest drivers of v2 and ?verbose=true requests
+func verbose(c echo.Context, nearest []*NearestDriver) []*NearestDriver {
+	if apiVersion(c) < v2 && c.QueryParam("verbose") != "true" {
+		return nearest
+	}
+	now := time.Now()
+	for _, n := range nearest {
+		age := now.Sub(time.Unix(0, n.Timestamp)).Seconds()
+		n.AgeSeconds = &age
+	}
+	return nearest
+}
 
-	drivers := a.database.Nearest(rtreego.Point{lt, ln}, 10)
+func (a *API) reserveDrivers(c echo.Context) error {
+	p := &ReservePayload{}
+	if err := c.Bind(p); err != nil {
+		return failWith(c, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Set content-type application/json or check your payload data")
+	}
+	var errs fieldErrors
+	if errs.location("location", p.Location); errs != nil {
+		return invalid(c, errs)
+	}
+	if p.Count == 0 {
+		p.Count = 1
+	}
+	if p.Count < 0 || p.TTL < 0 {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "count and ttl must be positive")
+	}
+	ttl := defaultReserveTTL
+	if p.TTL > 0 {
+		ttl = time.Duration(p.TTL) * time.Second
+	}
+
+	var filters []storage.Filter
+	if len(p.Attributes) > 0 {
+		filters = append(filters, storage.AttributesFilter(p.Attributes))
+	}
+	point := rtreego.Point{p.Location.Latitude, p.Location.Longitude}
+	drivers := database(c).NearestAndLock(point, p.Count, ttl, filters...)
 
 	return c.JSON(http.StatusOK, &NearestDriverResponse{
-		Success: false,
+		Success: true,
+		Message: "reserved",
+		Drivers: a.nearest(storage.Location{Lat: p.Location.Latitude, Lon: p.Location.Longitude}, drivers),
+	})
+}
+
+func (a *API) boundingBoxDrivers(c echo.Context) error {
+	box, err := boundingBox(c)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	drivers, err := database(c).InBoundingBox(box[0], box[1], box[2], box[3])
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &DriversResponse{
+		Success: true,
+		Message: "found",
+		Drivers: drivers,
+	})
+}
+
+func (a *API) clusterDrivers(c echo.Context) error {
+	box, err := boundingBox(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	zoom, err := strconv.Atoi(c.QueryParam("zoom"))
+	if err != nil || zoom < 0 || zoom > storage.MaxZoom {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "zoom must be an integer from 0 to "+strconv.Itoa(storage.MaxZoom))
+	}
+
+	drivers, err := database(c).InBoundingBox(box[0], box[1], box[2], box[3])
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &ClustersResponse{
+		Success:  true,
+		Message:  "found",
+		Clusters: storage.ClusterDrivers(drivers, zoom),
+	})
+}
+
+func (a *API) polygonDrivers(c echo.Context) error {
+	g := &GeoJSON{}
+	if err := c.Bind(g); err != nil {
+		return failWith(c, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Set content-type application/json or check your GeoJSON")
+	}
+	polygon, err := g.Polygon()
+	if err != nil {
+		return fail(c, err)
+	}
+
+	drivers, err := database(c).InPolygon(polygon)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &DriversResponse{
+		Success: true,
 		Message: "found",
 		Drivers: drivers,
 	})
 }
+
+func (a *API) stats(c echo.Context) error {
+	resp := &StatsResponse{
+		Success: true,
+		Message: "found",
+		Stats:   database(c).Stats(),
+	}
+	// fixes are written to the default namespace
+	if def, _ := a.namespaces.Namespace(""); a.udp != nil && database(c) == def {
+		stats := a.udp.Stats()
+		resp.UDP = &stats
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (a *API) heatmap(c echo.Context) error {
+	precision := defaultHeatmapPrecision
+	if v := c.QueryParam("precision"); v != "" {
+		var err error
+		precision, err = strconv.Atoi(v)
+		if err != nil || precision < 1 || precision > 12 {
+			return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "precision must be an integer from 1 to 12")
+		}
+	}
+
+	cells, err := database(c).Heatmap(precision)
+	if err != nil {
+		return failWith(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, &HeatmapResponse{
+		Success: true,
+		Message: "found",
+		Cells:   cells,
+	})
+}
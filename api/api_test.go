@@ -0,0 +1,1025 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/archive"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/kdrake/nearestdots/stream"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func newTestAPI(t *testing.T, drivers ...int) (*API, storage.Storage) {
+	db := storage.New(10)
+	for _, id := range drivers {
+		assert.NoError(t, db.Set(&storage.Driver{ID: id, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	}
+	return New(":0", storage.NewManager(db, nil), nil), db
+}
+
+func doRequest(a *API, method, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	a.echo.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+func TestDeleteDriver(t *testing.T) {
+	a, db := newTestAPI(t, 1, 2)
+
+	w := doRequest(a, http.MethodDelete, "/v1/driver/1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"success": true, "message": "removed"}`, w.Body.String())
+	_, err := db.Get(1)
+	assert.Equal(t, storage.ErrDriverDoesNotExist, err)
+
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodDelete, "/v1/driver/1").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(a, http.MethodDelete, "/v2/driver/1").Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodDelete, "/v2/driver/x").Code)
+	assert.Equal(t, http.StatusOK, doRequest(a, http.MethodDelete, "/api/driver/2").Code)
+	assert.Equal(t, 0, db.Len())
+}
+
+func TestListDrivers(t *testing.T) {
+	a, _ := newTestAPI(t, 3, 1, 2)
+
+	var page ListResponse
+	w := doRequest(a, http.MethodGet, "/v1/drivers?limit=2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	if assert.Len(t, page.Drivers, 2) && assert.NotNil(t, page.Next) {
+		assert.Equal(t, 1, page.Drivers[0].ID)
+		assert.Equal(t, 2, *page.Next)
+	}
+
+	page = ListResponse{}
+	w = doRequest(a, http.MethodGet, "/v1/drivers?limit=2&cursor=2")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	if assert.Len(t, page.Drivers, 1) {
+		assert.Equal(t, 3, page.Drivers[0].ID)
+	}
+	assert.Nil(t, page.Next)
+
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodGet, "/v1/drivers?cursor=x").Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodGet, "/v1/drivers?limit=0").Code)
+}
+
+func TestErrorResponse(t *testing.T) {
+	a, _ := newTestAPI(t, 1)
+
+	var resp ErrorResponse
+	w := doRequest(a, http.MethodGet, "/v2/driver/42")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, CodeDriverNotFound, resp.Code)
+	assert.Equal(t, storage.ErrDriverDoesNotExist.Error(), resp.Message)
+	assert.Equal(t, w.Header().Get("X-Request-ID"), resp.RequestID)
+	assert.NotEmpty(t, resp.RequestID)
+
+	resp = ErrorResponse{}
+	w = doRequest(a, http.MethodGet, "/v1/driver/91/1/nearest")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, CodeInvalidCoordinates, resp.Code)
+	assert.Equal(t, []FieldError{{Field: "lat", Message: "must be a number in [-90, 90]"}}, resp.Details)
+
+	resp = ErrorResponse{}
+	w = doRequest(a, http.MethodGet, "/v2/missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, CodeNotFound, resp.Code)
+}
+
+func TestNearestCache(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	a := New(":0", storage.NewManager(db, nil), nil, WithNearestCache(time.Minute, 3))
+	nearest := func(path string) []*NearestDriver {
+		var resp NearestDriverResponse
+		w := doRequest(a, http.MethodGet, path)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Drivers
+	}
+	assert.Len(t, nearest("/v1/driver/1.0001/1/nearest?count=2"), 1)
+
+	// the same rounded point is served from the cache, distances are to the requested point
+	assert.NoError(t, db.Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: 1.0002, Lon: 1}}))
+	drivers := nearest("/v1/driver/1.0002/1/nearest?count=2")
+	if assert.Len(t, drivers, 1) {
+		assert.InDelta(t, 22.2, drivers[0].Distance, 0.1)
+	}
+
+	// other count, filters and points are queried
+	drivers = nearest("/v1/driver/1.0002/1/nearest?count=3")
+	if assert.Len(t, drivers, 2) {
+		assert.Equal(t, 2, drivers[0].ID)
+	}
+	assert.Len(t, nearest("/v1/driver/1.0001/1/nearest?count=2&include_unavailable=true"), 2)
+	assert.Len(t, nearest("/v1/driver/1.002/1/nearest?count=2"), 2)
+}
+
+func TestIdempotency(t *testing.T) {
+	db := storage.New(10)
+	a := New(":0", storage.NewManager(db, nil), nil, WithIdempotency(time.Minute, 100))
+	update := func(apiKey, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/v1/driver/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(idempotencyHeader, key)
+		if apiKey != "" {
+			r.Header.Set(apiKeyHeader, apiKey)
+		}
+		a.echo.ServeHTTP(w, r)
+		return w
+	}
+	lat := func() float64 {
+		d, err := db.Get(1)
+		assert.NoError(t, err)
+		return d.LastLocation.Lat
+	}
+	w := update("k1", "a", `{"driver_id": 1, "location": {"lat": 1, "lon": 1}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get(replayedHeader))
+
+	// the retry gets the same response without being applied
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 2, Lon: 1}}))
+	retry := update("k1", "a", `{"driver_id": 1, "location": {"lat": 1, "lon": 1}}`)
+	assert.Equal(t, http.StatusOK, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get(replayedHeader))
+	assert.Equal(t, w.Body.String(), retry.Body.String())
+	assert.Equal(t, 2.0, lat())
+
+	// the key can't be reused for another request, other clients have their own keys
+	assert.Equal(t, http.StatusUnprocessableEntity, update("k1", "a", `{"driver_id": 1, "location": {"lat": 3, "lon": 1}}`).Code)
+	assert.Equal(t, http.StatusOK, update("k2", "a", `{"driver_id": 1, "location": {"lat": 3, "lon": 1}}`).Code)
+	assert.Equal(t, 3.0, lat())
+
+	// keys of clients without credentials are scoped by the request
+	assert.Equal(t, http.StatusOK, update("", "b", `{"driver_id": 1, "location": {"lat": 4, "lon": 1}}`).Code)
+	assert.Equal(t, http.StatusOK, update("", "b", `{"driver_id": 1, "location": {"lat": 5, "lon": 1}}`).Code)
+	assert.Equal(t, 5.0, lat())
+
+	// failed requests are not replayed
+	assert.Equal(t, http.StatusBadRequest, update("k1", "c", `{"driver_id": 1, "location": {"lat": 91, "lon": 1}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, update("k1", "c", `{"driver_id": 1, "location": {"lat": 91, "lon": 1}}`).Code)
+
+	assert.Equal(t, http.StatusBadRequest, update("k1", strings.Repeat("k", 256), `{"driver_id": 1, "location": {"lat": 1, "lon": 1}}`).Code)
+}
+
+func TestIdempotencyEviction(t *testing.T) {
+	i := &idempotency{ttl: time.Minute, max: 2, responses: make(map[string]keptResponse)}
+	now := time.Now()
+	i.put("a", keptResponse{status: 1}, now)
+	i.put("b", keptResponse{status: 2}, now.Add(time.Second))
+	// the oldest response is dropped above the max
+	i.put("c", keptResponse{status: 3}, now.Add(2*time.Second))
+	_, ok := i.get("a", now.Add(2*time.Second))
+	assert.False(t, ok)
+	r, ok := i.get("c", now.Add(2*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 3, r.status)
+
+	// expired responses are dropped
+	i.put("d", keptResponse{status: 4}, now.Add(2*time.Minute))
+	assert.Len(t, i.responses, 1)
+	assert.Len(t, i.order, 1)
+}
+
+func TestNamespaces(t *testing.T) {
+	db := storage.New(10)
+	namespaces := storage.NewManager(db, func(string) (storage.Storage, error) { return storage.New(10), nil }, storage.WithMaxNamespaces(1))
+	a := New(":0", namespaces, nil)
+	add := func(path string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"driver_id": 1, "location": {"lat": 1, "lon": 1}}`))
+		r.Header.Set("Content-Type", "application/json")
+		a.echo.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// queries of unknown namespaces don't create them
+	w := doRequest(a, http.MethodGet, "/v2/ns/bishkek/drivers")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	var resp ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, CodeNamespaceNotFound, resp.Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodGet, "/v1/ns/bishkek/driver/1").Code)
+	assert.Empty(t, namespaces.Namespaces())
+
+	assert.Equal(t, http.StatusOK, add("/v1/ns/bishkek/driver/"))
+	assert.Equal(t, http.StatusOK, doRequest(a, http.MethodGet, "/v2/ns/bishkek/driver/1").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(a, http.MethodGet, "/v2/driver/1").Code)
+
+	// writes don't create namespaces above the limit
+	assert.Equal(t, http.StatusInsufficientStorage, add("/v1/ns/almaty/driver/"))
+	assert.Equal(t, []string{"bishkek"}, namespaces.Namespaces())
+}
+
+func TestConditionalUpdates(t *testing.T) {
+	a, _ := newTestAPI(t, 1)
+	update := func(path, version, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPut, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if version != "" {
+			r.Header.Set(ifMatchHeader, version)
+		}
+		a.echo.ServeHTTP(w, r)
+		return w
+	}
+	w := doRequest(a, http.MethodGet, "/v1/driver/1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get(etagHeader))
+
+	w = update("/v1/driver/1/status", `"1"`, `{"status": "busy"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get(etagHeader))
+
+	// the other dispatcher edited the first version, it gets the current one
+	w = update("/v1/driver/1/attributes", `"1"`, `{"attributes": {"class": "comfort"}}`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get(etagHeader))
+	var resp ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, CodeVersionMismatch, resp.Code)
+
+	w = update("/v1/driver/1/attributes", `"2"`, `{"attributes": {"class": "comfort"}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"3"`, w.Header().Get(etagHeader))
+	var driver DriverResponse
+	assert.NoError(t, json.Unmarshal(doRequest(a, http.MethodGet, "/v1/driver/1").Body.Bytes(), &driver))
+	assert.Equal(t, map[string]string{"class": "comfort"}, driver.Driver.Attributes)
+	assert.Equal(t, storage.StatusBusy, driver.Driver.Status)
+	assert.Equal(t, uint64(3), driver.Driver.Version)
+
+	// updates without If-Match are not conditional
+	assert.Equal(t, http.StatusOK, update("/v1/driver/1/status", "", `{"status": "available"}`).Code)
+	assert.Equal(t, http.StatusOK, update("/v1/driver/1/attributes", "*", `{"attributes": {}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, update("/v1/driver/1/status", "abc", `{"status": "busy"}`).Code)
+	assert.Equal(t, http.StatusNotFound, update("/v2/driver/2/attributes", `"1"`, `{"attributes": {}}`).Code)
+
+	// storages without versions update attributes unconditionally only
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}, Attributes: map[string]string{"class": "comfort"}}))
+	a = New(":0", storage.NewManager(unversioned{db}, nil), nil)
+	assert.Equal(t, http.StatusOK, update("/v1/driver/1/attributes", "", `{"attributes": {"class": "van"}}`).Code)
+	d, err := db.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"class": "van"}, d.Attributes)
+	assert.Equal(t, storage.Location{Lat: 1, Lon: 1}, d.LastLocation)
+	w = update("/v1/driver/1/attributes", `"2"`, `{"attributes": {}}`)
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, CodeVersionsUnsupported, resp.Code)
+	assert.Equal(t, http.StatusBadRequest, update("/v1/driver/2/attributes", "", `{"attributes": {}}`).Code)
+}
+
+// unversioned hides conditional updates of the storage
+type unversioned struct {
+	storage.Storage
+}
+
+func TestZones(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	a := New(":0", storage.NewManager(db, nil), nil, WithDemand(time.Minute))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, doRequest(a, http.MethodGet, "/v1/driver/1/1/nearest").Code)
+	}
+	assert.Equal(t, http.StatusOK, doRequest(a, http.MethodGet, "/v1/driver/10/10/nearest").Code)
+
+	var resp ZonesResponse
+	w := doRequest(a, http.MethodGet, "/v1/zones?precision=4")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, float64(60), resp.WindowSeconds)
+	assert.Equal(t, []storage.Zone{
+		{Geohash: "s00t", Supply: 1, Demand: 3, Ratio: 3},
+		{Geohash: "s1z0", Supply: 0, Demand: 1, Ratio: 1},
+	}, resp.Zones)
+
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodGet, "/v1/zones?precision=13").Code)
+	// zones are not served without demand tracking
+	b, _ := newTestAPI(t)
+	assert.Equal(t, http.StatusNotFound, doRequest(b, http.MethodGet, "/v1/zones").Code)
+}
+
+func TestHistoryStats(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	a := New(":0", storage.NewManager(db, nil), nil, WithMetrics())
+
+	var resp HistoryStatsResponse
+	w := doRequest(a, http.MethodGet, "/v1/driver/1/history/stats")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.History.Locations)
+	assert.Equal(t, 10, resp.History.Capacity)
+	assert.Equal(t, http.StatusNotFound, doRequest(a, http.MethodGet, "/v2/driver/2/history/stats").Code)
+
+	w = doRequest(a, http.MethodGet, "/metrics")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `nearestdots_drivers{namespace=""} 1`)
+	assert.Contains(t, w.Body.String(), `nearestdots_history_capacity{namespace=""} 10`)
+	assert.Contains(t, w.Body.String(), `nearestdots_history_evictions_total{namespace=""} 0`)
+
+	// metrics are not served unless enabled
+	b, _ := newTestAPI(t)
+	assert.Equal(t, http.StatusNotFound, doRequest(b, http.MethodGet, "/metrics").Code)
+}
+
+func TestH3(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 37.775938728915946, Lon: -122.41795063018799}}))
+	a := New(":0", storage.NewManager(db, nil), nil, WithH3Resolutions([]int{9, 7}))
+
+	var resp H3Response
+	w := doRequest(a, http.MethodGet, "/v1/h3")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 9, resp.Resolution)
+	assert.Equal(t, []storage.H3Cell{{Cell: "8928308280fffff", Count: 1, Available: 1}}, resp.Cells)
+	assert.Equal(t, http.StatusOK, doRequest(a, http.MethodGet, "/v1/h3?resolution=7").Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodGet, "/v1/h3?resolution=8").Code)
+
+	var driver DriverH3Response
+	w = doRequest(a, http.MethodGet, "/v1/driver/1/h3")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &driver))
+	assert.Equal(t, "8928308280fffff", driver.Cells[9])
+	assert.Len(t, driver.Cells, 2)
+	assert.Equal(t, http.StatusNotFound, doRequest(a, http.MethodGet, "/v2/driver/2/h3").Code)
+
+	// H3 cells are not served without resolutions
+	b, _ := newTestAPI(t)
+	assert.Equal(t, http.StatusNotFound, doRequest(b, http.MethodGet, "/v1/h3").Code)
+}
+
+// protoFields decodes a protobuf message to bytes and varint fields by number
+func protoFields(t *testing.T, b []byte) (map[protowire.Number][][]byte, map[protowire.Number][]uint64) {
+	messages, varints := make(map[protowire.Number][][]byte), make(map[protowire.Number][]uint64)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		assert.True(t, n > 0)
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			assert.True(t, n > 0)
+			messages[num] = append(messages[num], v)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			assert.True(t, n > 0)
+			varints[num] = append(varints[num], v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+	}
+	return messages, varints
+}
+
+func TestVectorTile(t *testing.T) {
+	a, db := newTestAPI(t, 1)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: -10, Lon: -10}}))
+
+	w := doRequest(a, http.MethodGet, "/v1/tiles/1/1/0.mvt")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, mimeVectorTile, w.Header().Get("Content-Type"))
+	tile, _ := protoFields(t, w.Body.Bytes())
+	if !assert.Len(t, tile[3], 1) {
+		return
+	}
+	layer, layerVarints := protoFields(t, tile[3][0])
+	assert.Equal(t, "drivers", string(layer[1][0]))
+	assert.Equal(t, []uint64{2}, layerVarints[15])
+	assert.Equal(t, []uint64{storage.TileExtent}, layerVarints[5])
+	assert.Equal(t, "status", string(layer[3][0]))
+	if assert.Len(t, layer[2], 1) {
+		feature, featureVarints := protoFields(t, layer[2][0])
+		assert.Equal(t, []uint64{1}, featureVarints[1])
+		assert.Equal(t, []uint64{1}, featureVarints[3])
+		// MoveTo (22, 4073) of the driver at (1, 1) in the north east quarter
+		assert.Equal(t, protowire.AppendVarint(protowire.AppendVarint(protowire.AppendVarint(nil, 9), 44), 8146), feature[4][0])
+	}
+
+	w = doRequest(a, http.MethodGet, "/v1/tiles/2/0/0.mvt")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Body.Bytes())
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodGet, "/v1/tiles/1/2/0.mvt").Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodGet, "/v1/tiles/1/1/0.png").Code)
+}
+
+func TestDriverTrack(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 2}, Timestamp: 1e9}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1.001, Lon: 2.001}, Timestamp: 2e9}))
+	a := New(":0", storage.NewManager(db, nil), nil)
+
+	w := doRequest(a, http.MethodGet, "/v1/driver/1/track.geojson")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, mimeGeoJSON, w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="driver-1.geojson"`, w.Header().Get("Content-Disposition"))
+	var track TrackFeature
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &track))
+	assert.Equal(t, "LineString", track.Geometry.Type)
+	assert.Equal(t, [][2]float64{{2, 1}, {2.001, 1.001}}, track.Geometry.Coordinates)
+	assert.Equal(t, []int64{1e9, 2e9}, track.Properties.Timestamps)
+
+	w = doRequest(a, http.MethodGet, "/v1/driver/1/track.gpx?from=1500000000")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, mimeGPX, w.Header().Get("Content-Type"))
+	var doc gpx
+	assert.NoError(t, xml.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, "driver 1", doc.Track.Name)
+	assert.Equal(t, []gpxPoint{{Lat: 1.001, Lon: 2.001, Time: "1970-01-01T00:00:02Z"}}, doc.Track.Segment)
+
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodGet, "/v1/driver/1/track.gpx?from=x").Code)
+	assert.Equal(t, http.StatusNotFound, doRequest(a, http.MethodGet, "/v2/driver/2/track.geojson").Code)
+}
+
+func TestPlayback(t *testing.T) {
+	ar, err := archive.Open(t.TempDir(), archive.Options{Retention: time.Hour, Window: 10 * time.Minute})
+	assert.NoError(t, err)
+	defer ar.Close()
+	db := storage.New(10, storage.WithObserver(ar))
+	now := time.Now()
+	past := now.Add(-20 * time.Minute)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1.002, Lon: 1}, Timestamp: past.Add(-time.Minute).UnixNano()}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: 1.001, Lon: 1}, Timestamp: past.Add(-time.Minute).UnixNano()}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: 5, Lon: 5}, Timestamp: now.UnixNano()}))
+	a := New(":0", storage.NewManager(db, nil), nil, WithArchive(ar))
+
+	var resp NearestDriverResponse
+	w := doRequest(a, http.MethodGet, "/v1/driver/1/1/nearest?at="+strconv.FormatInt(past.UnixNano(), 10))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	if assert.Len(t, resp.Drivers, 2) {
+		assert.Equal(t, 2, resp.Drivers[0].ID)
+		assert.InDelta(t, 111.2, resp.Drivers[0].Distance, 0.1)
+	}
+
+	var fleet DriversResponse
+	w = doRequest(a, http.MethodGet, "/v1/playback?at="+past.UTC().Format(time.RFC3339Nano))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &fleet))
+	assert.Len(t, fleet.Drivers, 2)
+
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodGet, "/v1/playback?at=yesterday").Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodGet, "/v1/playback?at="+now.Add(-2*time.Hour).Format(time.RFC3339)).Code)
+	// drivers are not played back without the archive
+	b, _ := newTestAPI(t)
+	assert.Equal(t, http.StatusBadRequest, doRequest(b, http.MethodGet, "/v1/playback?at=1").Code)
+}
+
+// fixedRouter returns travel times of the origins by their latitude
+type fixedRouter map[float64]time.Duration
+
+func (r fixedRouter) TravelTimes(origins []storage.Location, _ storage.Location) ([]time.Duration, error) {
+	times := make([]time.Duration, len(origins))
+	for i, o := range origins {
+		times[i] = r[o.Lat]
+	}
+	return times, nil
+}
+
+func TestETAMatrix(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1.001, Lon: 1}}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: 1.002, Lon: 1}}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 3, LastLocation: storage.Location{Lat: 1.003, Lon: 1}, Status: storage.StatusBusy}))
+	matrix := func(a *API, body string) (int, []*NearestDriver) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/v1/eta-matrix", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		a.echo.ServeHTTP(w, r)
+		var resp NearestDriverResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp.Drivers
+	}
+
+	a := New(":0", storage.NewManager(db, nil), nil)
+	code, drivers := matrix(a, `{"pickup": {"lat": 1, "lon": 1}, "count": 5}`)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, drivers, 2) {
+		assert.Equal(t, 1, drivers[0].ID)
+		assert.InDelta(t, 111.2, drivers[0].Distance, 0.1)
+		assert.InDelta(t, 111.2/defaultAverageSpeed, drivers[0].ETASeconds, 0.1)
+	}
+	_, drivers = matrix(a, `{"pickup": {"lat": 1, "lon": 1}, "count": 5, "include_unavailable": true}`)
+	assert.Len(t, drivers, 3)
+	code, _ = matrix(a, `{"pickup": {"lat": 91, "lon": 1}}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = matrix(a, `{"pickup": {"lat": 1, "lon": 1}, "routing": true}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// routed drivers are ordered by travel time
+	router := fixedRouter{1.001: time.Minute, 1.002: time.Second}
+	a = New(":0", storage.NewManager(db, nil), nil, WithRouter(router, 20))
+	_, drivers = matrix(a, `{"pickup": {"lat": 1, "lon": 1}, "routing": true}`)
+	if assert.Len(t, drivers, 2) {
+		assert.Equal(t, 2, drivers[0].ID)
+		assert.Equal(t, float64(1), drivers[0].ETASeconds)
+	}
+}
+
+func TestNearestVerbose(t *testing.T) {
+	db := storage.New(10)
+	updated := time.Now().Add(-10 * time.Second)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1.01, Lon: 1}, Status: storage.StatusAvailable, Timestamp: updated.UnixNano()}))
+	a := New(":0", storage.NewManager(db, nil), nil)
+
+	for path, aged := range map[string]bool{
+		"/api/driver/1/1/nearest":             false,
+		"/v1/driver/1/1/nearest":              false,
+		"/v1/driver/1/1/nearest?verbose=true": true,
+		"/v2/driver/1/1/nearest":              true,
+	} {
+		w := doRequest(a, http.MethodGet, path)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		var r NearestDriverResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &r))
+		if !assert.Len(t, r.Drivers, 1, path) {
+			continue
+		}
+		d := r.Drivers[0]
+		assert.Equal(t, 1, d.ID, path)
+		assert.Equal(t, storage.Location{Lat: 1.01, Lon: 1}, d.LastLocation, path)
+		assert.Equal(t, storage.StatusAvailable, d.Status, path)
+		assert.Equal(t, updated.UnixNano(), d.Timestamp, path)
+		assert.InDelta(t, 1112, d.Distance, 1, path)
+		if !aged {
+			assert.Nil(t, d.AgeSeconds, path)
+			assert.NotContains(t, w.Body.String(), "age_seconds", path)
+			continue
+		}
+		if assert.NotNil(t, d.AgeSeconds, path) {
+			assert.InDelta(t, 10, *d.AgeSeconds, 1, path)
+		}
+	}
+}
+
+func TestNearestScore(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1.001, Lon: 1}, Attributes: map[string]string{"rating": "3"}}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: 1.002, Lon: 1}, Attributes: map[string]string{"rating": "5"}}))
+	nearest := func(a *API, path string) []*NearestDriver {
+		var resp NearestDriverResponse
+		w := doRequest(a, http.MethodGet, path)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Drivers
+	}
+
+	a := New(":0", storage.NewManager(db, nil), nil)
+	drivers := nearest(a, "/v1/driver/1/1/nearest?count=1")
+	if assert.Len(t, drivers, 1) {
+		assert.Equal(t, 1, drivers[0].ID)
+		assert.Nil(t, drivers[0].Score)
+	}
+	// better rated drivers further away are ranked first
+	drivers = nearest(a, "/v1/driver/1/1/nearest?count=1&weights=distance:1,rating:1")
+	if assert.Len(t, drivers, 1) && assert.NotNil(t, drivers[0].Score) {
+		assert.Equal(t, 2, drivers[0].ID)
+		assert.InDelta(t, 5-0.222, *drivers[0].Score, 0.001)
+	}
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodGet, "/v1/driver/1/1/nearest?weights=speed:1").Code)
+
+	// configured weights are used unless requests have their own
+	a = New(":0", storage.NewManager(db, nil), nil, WithScoreWeights(storage.Weights{Rating: 1}))
+	drivers = nearest(a, "/v1/driver/1/1/nearest?count=2")
+	if assert.Len(t, drivers, 2) {
+		assert.Equal(t, 2, drivers[0].ID)
+	}
+	drivers = nearest(a, "/v1/driver/1/1/nearest?count=2&weights=distance:1")
+	if assert.Len(t, drivers, 2) {
+		assert.Equal(t, 1, drivers[0].ID)
+	}
+}
+
+func TestRouteDrivers(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1.002, Lon: 1.05}}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: 1.001, Lon: 1.09}}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 3, LastLocation: storage.Location{Lat: 1.001, Lon: 1.02}, Status: storage.StatusBusy}))
+	route := func(body string) (int, []*NearestDriver) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/v1/drivers/route", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		a := New(":0", storage.NewManager(db, nil), nil)
+		a.echo.ServeHTTP(w, r)
+		var resp NearestDriverResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp.Drivers
+	}
+
+	// the route from (1, 1) to (1, 1.1)
+	code, drivers := route(`{"polyline": "_ibE_ibE?_pR", "count": 5}`)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, drivers, 2) {
+		assert.Equal(t, 2, drivers[0].ID)
+		assert.InDelta(t, 111.2, drivers[0].Distance, 0.1)
+		assert.Equal(t, 1, drivers[1].ID)
+	}
+	_, drivers = route(`{"polyline": "_ibE_ibE?_pR", "include_unavailable": true}`)
+	assert.Len(t, drivers, 3)
+	code, _ = route(`{"polyline": "_ibE_"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = route(`{"count": 5}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestNearestIDs(t *testing.T) {
+	a, _ := newTestAPI(t, 1, 2, 3)
+	ids := func(path string) []int {
+		var resp NearestDriverResponse
+		w := doRequest(a, http.MethodGet, path)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		var ids []int
+		for _, d := range resp.Drivers {
+			ids = append(ids, d.ID)
+		}
+		return ids
+	}
+	assert.ElementsMatch(t, []int{2}, ids("/v1/driver/1/1/nearest?exclude_ids=1,3"))
+	assert.ElementsMatch(t, []int{1, 3}, ids("/v1/driver/1/1/nearest?only_ids=1,3,42"))
+	assert.ElementsMatch(t, []int{3}, ids("/v1/driver/1/1/nearest?only_ids=1,3&exclude_ids=1"))
+	assert.Equal(t, http.StatusBadRequest, doRequest(a, http.MethodGet, "/v1/driver/1/1/nearest?exclude_ids=x").Code)
+}
+
+func TestSetRateLimit(t *testing.T) {
+	a := New(":0", storage.NewManager(storage.New(10), nil), nil, WithRateLimit(0, 1))
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, doRequest(a, http.MethodGet, "/v1/drivers").Code)
+	}
+
+	a.SetRateLimit(0.001, 1)
+	assert.Equal(t, http.StatusOK, doRequest(a, http.MethodGet, "/v1/drivers").Code)
+	w := doRequest(a, http.MethodGet, "/v1/drivers")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	a.SetRateLimit(0, 1)
+	assert.Equal(t, http.StatusOK, doRequest(a, http.MethodGet, "/v1/drivers").Code)
+}
+
+func TestNearestEvents(t *testing.T) {
+	hub := stream.NewHub(10)
+	db := storage.New(10, storage.WithObserver(hub))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	a := New(":0", storage.NewManager(db, nil), nil, WithStream(hub))
+	server := httptest.NewServer(a.echo)
+	defer server.Close()
+
+	w := doRequest(a, http.MethodGet, "/v1/driver/91/1/nearest/events")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	var failed ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &failed))
+	assert.Equal(t, CodeInvalidCoordinates, failed.Code)
+
+	r, err := http.NewRequest(http.MethodGet, server.URL+"/v1/driver/1/1/nearest/events?count=2", nil)
+	assert.NoError(t, err)
+	r.Header.Set("Last-Event-ID", "5")
+	resp, err := http.DefaultClient.Do(r)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get(echo.HeaderContentType))
+
+	events := bufio.NewReader(resp.Body)
+	// next reads lines of the next event, its data is decoded into nearest
+	next := func(nearest *NearestDriverResponse) []string {
+		var lines []string
+		for {
+			line, err := events.ReadString('\n')
+			if !assert.NoError(t, err) || line == "\n" {
+				return lines
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if data := strings.TrimPrefix(line, "data: "); data != line {
+				assert.NoError(t, json.Unmarshal([]byte(data), nearest))
+				continue
+			}
+			lines = append(lines, line)
+		}
+	}
+	var nearest NearestDriverResponse
+	assert.Equal(t, []string{"retry: 3000"}, next(&nearest))
+	// ids continue from the last event of the reconnecting client
+	assert.Equal(t, []string{"id: 6", "event: nearest"}, next(&nearest))
+	assert.Len(t, nearest.Drivers, 1)
+
+	// the event is sent when nearest drivers change
+	assert.NoError(t, db.Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: 1.001, Lon: 1}}))
+	assert.Equal(t, []string{"id: 7", "event: nearest"}, next(&nearest))
+	if assert.Len(t, nearest.Drivers, 2) {
+		assert.Equal(t, 1, nearest.Drivers[0].ID)
+		assert.Equal(t, 2, nearest.Drivers[1].ID)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	a := New(":0", storage.NewManager(storage.New(10), nil), nil, WithAPIKeys(Keys{"secret": RoleDispatcher}))
+	w := doRequest(a, http.MethodGet, "/openapi.json")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var spec struct {
+		OpenAPI  string                                       `json:"openapi"`
+		Paths    map[string]map[string]map[string]interface{} `json:"paths"`
+		Security []map[string][]string                        `json:"security"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &spec))
+	assert.Equal(t, "3.0.3", spec.OpenAPI)
+	assert.Equal(t, []map[string][]string{{"apiKey": {}}}, spec.Security)
+
+	// every handler of registered routes is documented, so the spec is in sync with routes
+	for _, r := range a.echo.Routes() {
+		if m := handlerMethod.FindStringSubmatch(r.Name); m != nil {
+			_, ok := operations[m[1]]
+			assert.True(t, ok, "%s %s of %s is not documented", r.Method, r.Path, m[1])
+		}
+	}
+
+	assert.Equal(t, "getDriverV1", spec.Paths["/v1/driver/{id}"]["get"]["operationId"])
+	assert.Equal(t, "getDriverInNamespaceV2", spec.Paths["/v2/ns/{namespace}/driver/{id}"]["get"]["operationId"])
+	assert.Equal(t, "getDriver", spec.Paths["/api/driver/{id}"]["get"]["operationId"])
+	add := spec.Paths["/v1/driver/"]["post"]
+	assert.Contains(t, add, "requestBody")
+	var params []string
+	for _, p := range add["parameters"].([]interface{}) {
+		params = append(params, p.(map[string]interface{})["name"].(string))
+	}
+	assert.Equal(t, []string{namespaceHeader, idempotencyHeader}, params)
+	assert.NotContains(t, spec.Paths, "/v1/geofences")
+
+	// Swagger UI is served if it's enabled
+	assert.Equal(t, http.StatusNotFound, doRequest(a, http.MethodGet, "/docs").Code)
+	a = New(":0", storage.NewManager(storage.New(10), nil), nil, WithSwaggerUI())
+	w = doRequest(a, http.MethodGet, "/docs")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `url: "/openapi.json"`)
+}
+
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "api.sock")
+
+	// a socket of a crashed instance is replaced
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	tcpAddr := freeAddr(t)
+
+	a := New(tcpAddr+", unix://"+socket, storage.NewManager(storage.New(10), nil), nil)
+	assert.Equal(t, []string{tcpAddr, "unix://" + socket}, a.bindAddrs)
+	a.Start()
+	unix := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+	}}}
+	for client, url := range map[*http.Client]string{http.DefaultClient: "http://" + tcpAddr, unix: "http://unix"} {
+		assert.Eventually(t, func() bool {
+			resp, err := client.Get(url + "/healthz")
+			if err != nil {
+				return false
+			}
+			resp.Body.Close()
+			return resp.StatusCode == http.StatusOK
+		}, time.Second, 10*time.Millisecond, url)
+	}
+
+	_, err = listen("unix://" + socket)
+	assert.EqualError(t, err, "unix://"+socket+" is in use")
+
+	assert.NoError(t, a.Shutdown(context.Background()))
+	a.WaitStop()
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestShutdown(t *testing.T) {
+	hub := stream.NewHub(10)
+	start := func() (*API, string) {
+		return startAPI(t, storage.New(10), WithStream(hub))
+	}
+	// an update is in flight once the handler asks for its body
+	body := `{"driver_id": 1, "location": {"lat": 1, "lon": 1}}`
+	update := func(addr string) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = conn.Write([]byte("POST /v2/driver/ HTTP/1.1\r\nHost: api\r\nContent-Type: application/json\r\n" +
+			"Content-Length: " + strconv.Itoa(len(body)) + "\r\nExpect: 100-continue\r\n\r\n"))
+		assert.NoError(t, err)
+		r := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(r, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusContinue, resp.StatusCode)
+		return conn, r
+	}
+
+	// in-flight updates are drained and event streams end
+	a, addr := start()
+	events, err := http.Get("http://" + addr + "/v2/driver/1/1/nearest/events")
+	assert.NoError(t, err)
+	defer events.Body.Close()
+	conn, r := update(addr)
+	defer conn.Close()
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- a.Shutdown(context.Background())
+	}()
+	_, err = conn.Write([]byte(body))
+	assert.NoError(t, err)
+	resp, err := http.ReadResponse(r, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, <-shutdown)
+	a.WaitStop()
+	_, err = ioutil.ReadAll(events.Body)
+	assert.NoError(t, err)
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+
+	// updates still in flight at the deadline fail shutdown
+	a, addr = start()
+	conn, _ = update(addr)
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, a.Shutdown(ctx))
+	a.WaitStop()
+}
+
+// startAPI serves the storage at a free TCP address until the API is shut down
+func startAPI(t *testing.T, db storage.Storage, opts ...Option) (*API, string) {
+	addr := freeAddr(t)
+	a := New(addr, storage.NewManager(db, nil), nil, opts...)
+	a.Start()
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+	return a, addr
+}
+
+// freeAddr returns a TCP address nobody listens to
+func freeAddr(t *testing.T) string {
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer free.Close()
+	return free.Addr().String()
+}
+
+func TestAppendJSON(t *testing.T) {
+	age, score := 1.5, -0.25
+	responses := []jsonAppender{
+		&DefaultResponse{Success: true, Message: "Added <b>&</b> \"quoted\"\n\t\x01   \xff é"},
+		&NearestDriverResponse{Success: true, Message: "found"},
+		&NearestDriverResponse{Drivers: []*NearestDriver{}},
+		&NearestDriverResponse{Success: true, Message: "found", Drivers: []*NearestDriver{
+			{Driver: &storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 42.875799, Lon: -74.588279}, Status: storage.StatusAvailable,
+				Speed: 1e-7, Heading: 1e21, Timestamp: 1600000000000000000}, Distance: 123.456, ETASeconds: 0},
+			{Driver: &storage.Driver{ID: -2, Attributes: map[string]string{"z": "1", "a": "<2>", "m": "\x00"}, ReservedUntil: 5, IdleSince: 6, OffShift: true, Anomalies: 2, AnomalyAt: 7, Version: 3},
+				Distance: 0.1, ETASeconds: 1e-10, AgeSeconds: &age, Score: &score},
+			{Distance: 1},
+			nil,
+		}},
+	}
+	for _, r := range responses {
+		expected, err := json.Marshal(r)
+		assert.NoError(t, err)
+		b, ok := r.appendJSON(nil)
+		assert.True(t, ok)
+		assert.Equal(t, string(expected), string(b))
+	}
+
+	_, ok := (&NearestDriverResponse{Drivers: []*NearestDriver{{Driver: &storage.Driver{}, Distance: math.NaN()}}}).appendJSON(nil)
+	assert.False(t, ok)
+}
+
+func TestDecodePayload(t *testing.T) {
+	payloads := []string{
+		`{"driver_id":1,"location":{"lat":42.875799,"lon":74.588279}}`,
+		` { "driver_id" : 2 , "location" : { "lat" : -1.5e-3 , "lon" : 0 } , "timestamp" : 1600000000000000000 , "ttl" : 60 ,
+			"status" : "busy" , "attributes" : { "class" : "comfort" , "é" : "" } } `,
+		`{"driver_id":3,"attributes":{}}`,
+		`{}`,
+		// decoded by encoding/json
+		`{"Driver_ID":4}`,
+		`{"driver_id":5,"status":"busy","unknown":[1,{"a":null}]}`,
+		`{"driver_id":6,"attributes":{"a":"1"},"attributes":{"b":"2"}}`,
+		`{"driver_id":7,"location":null,"timestamp":null}`,
+		`{"driver_id":8} trailing`,
+		// invalid
+		`{"driver_id":1.5}`,
+		`{"driver_id":01}`,
+		`{"driver_id":"1"}`,
+		`{"location":{"lat":1.}}`,
+		`{"driver_id":1`,
+		`{"driver_id":99999999999999999999}`,
+		`[]`,
+		``,
+	}
+	for _, data := range payloads {
+		var expected, p Payload
+		expectedErr := json.NewDecoder(strings.NewReader(data)).Decode(&expected)
+		err := decodePayload([]byte(data), &p)
+		assert.Equal(t, expectedErr == nil, err == nil, data)
+		if err == nil {
+			assert.Equal(t, expected, p, data)
+		}
+	}
+
+	payloads = []string{
+		`[{"driver_id":1,"location":{"lat":1,"lon":2}},{"driver_id":2,"status":"busy"}]`,
+		`[]`,
+		`null`,
+		`[{"driver_id":1,"extra":true}]`,
+		`[{"driver_id":1},]`,
+	}
+	for _, data := range payloads {
+		var expected []Payload
+		expectedErr := json.NewDecoder(strings.NewReader(data)).Decode(&expected)
+		p, err := decodePayloadArray([]byte(data))
+		assert.Equal(t, expectedErr == nil, err == nil, data)
+		if err == nil {
+			assert.Equal(t, expected, p, data)
+		}
+	}
+}
+
+func BenchmarkNearestJSON(b *testing.B) {
+	r := &NearestDriverResponse{Success: true, Message: "found"}
+	for i := 0; i < 10; i++ {
+		r.Drivers = append(r.Drivers, &NearestDriver{
+			Driver: &storage.Driver{ID: i, LastLocation: storage.Location{Lat: 42.875799, Lon: 74.588279}, Status: storage.StatusAvailable,
+				Attributes: map[string]string{"class": "comfort"}, Speed: 8.3, Heading: 90, Timestamp: 1600000000000000000},
+			Distance:   123.456,
+			ETASeconds: 14.8,
+		})
+	}
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			json.Marshal(r)
+		}
+	})
+	b.Run("append", func(b *testing.B) {
+		b.ReportAllocs()
+		buf := make([]byte, 0, 4096)
+		for i := 0; i < b.N; i++ {
+			buf, _ = r.appendJSON(buf[:0])
+		}
+	})
+}
+
+func BenchmarkDecodePayload(b *testing.B) {
+	data := []byte(`{"driver_id":1,"location":{"lat":42.875799,"lon":74.588279},"timestamp":1600000000000000000,"status":"available"}`)
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var p Payload
+			json.Unmarshal(data, &p)
+		}
+	})
+	b.Run("decoder", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var p Payload
+			decodePayload(data, &p)
+		}
+	})
+}
@@ -0,0 +1,226 @@
+package api
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// Roles of API keys
+const (
+	// RoleDriver writes locations and statuses of drivers
+	RoleDriver = "driver"
+	// RoleDispatcher reads, changes and removes drivers and manages orders, reservations and geofences
+	RoleDispatcher = "dispatcher"
+)
+
+// apiKeyHeader carries the API key of a request
+const apiKeyHeader = "X-API-Key"
+
+// apiKeyParam carries the API key of browser websocket and event stream clients unable to set headers
+const apiKeyParam = "api_key"
+
+// driverIDKey is a context key of the driver id of driver tokens
+const driverIDKey = "driver_id"
+
+var (
+	// ErrInvalidKeys sign what API keys are not role:key pairs of known roles
+	ErrInvalidKeys = errors.New("Invalid API keys")
+	// ErrUnauthenticated sign what request has neither a valid API key nor a valid bearer token
+	ErrUnauthenticated = errors.New("Set a valid API key in X-API-Key header or a bearer token")
+	// ErrInvalidToken sign what bearer token is not signed with the secret, expired or has invalid claims
+	ErrInvalidToken = errors.New("Invalid token")
+)
+
+type (
+	// Keys maps API keys to their roles
+	Keys map[string]string
+
+	// Claims of JWT bearer tokens, driver tokens must have DriverID
+	// and may only write the driver
+	Claims struct {
+		Role     string `json:"role"`
+		DriverID *int   `json:"driver_id,omitempty"`
+		jwt.RegisteredClaims
+	}
+)
+
+// ParseKeys parses comma separated role:key pairs, e.g. from an environment variable
+func ParseKeys(s string) (Keys, error) {
+	keys := Keys{}
+	for _, pair := range strings.Split(s, ",") {
+		if err := keys.add(pair); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// LoadKeys reads role:key pairs from the file, one per line. Empty lines and lines starting with # are skipped.
+func LoadKeys(path string) (Keys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open API keys")
+	}
+	defer f.Close()
+	return readKeys(f)
+}
+
+func readKeys(r io.Reader) (Keys, error) {
+	keys := Keys{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := keys.add(line); err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read API keys")
+	}
+	return keys, nil
+}
+
+func (k Keys) add(pair string) error {
+	kv := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+	if len(kv) != 2 || kv[1] == "" || (kv[0] != RoleDriver && kv[0] != RoleDispatcher) {
+		return ErrInvalidKeys
+	}
+	k[kv[1]] = kv[0]
+	return nil
+}
+
+// WithAPIKeys requires X-API-Key header with a key of the role needed by the route.
+// Driver keys may only write drivers, dispatcher keys may do everything else
+// including changes of statuses and attributes and removal of drivers.
+func WithAPIKeys(keys Keys) Option {
+	return func(a *API) {
+		a.keys = keys
+	}
+}
+
+// WithJWT accepts HS256 bearer tokens signed with the secret, role claim is driver or dispatcher.
+// Driver tokens may only write the driver of driver_id claim.
+func WithJWT(secret []byte) Option {
+	return func(a *API) {
+		a.jwtSecret = secret
+	}
+}
+
+// authorize is a middleware allowing requests with a key or a token of one of the roles,
+// all requests are allowed if neither keys nor tokens are enabled
+func (a *API) authorize(roles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if a.keys == nil && a.jwtSecret == nil {
+				return next(c)
+			}
+			r, err := a.authenticate(c)
+			if err != nil {
+				return fail(c, err)
+			}
+			if !allowed(r, roles) {
+				return failWith(c, http.StatusForbidden, CodeForbidden, "Role "+r+" is not allowed to "+c.Request().Method+" "+c.Path())
+			}
+			return next(c)
+		}
+	}
+}
+
+func allowed(role string, roles []string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate returns role of the bearer token or the API key
+func (a *API) authenticate(c echo.Context) (string, error) {
+	var token string
+	if auth := c.Request().Header.Get(echo.HeaderAuthorization); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	key := c.Request().Header.Get(apiKeyHeader)
+	if key == "" {
+		key = c.QueryParam(apiKeyParam)
+	}
+	role, driverID, err := Credentials{Keys: a.keys, JWTSecret: a.jwtSecret}.Authenticate(key, token)
+	if err != nil {
+		return "", err
+	}
+	if driverID != nil {
+		c.Set(driverIDKey, *driverID)
+	}
+	return role, nil
+}
+
+// Credentials are API keys and the JWT secret of the API, other protocols check them too
+type Credentials struct {
+	Keys      Keys
+	JWTSecret []byte
+}
+
+// Enabled returns true if keys or tokens are required
+func (cr Credentials) Enabled() bool {
+	return cr.Keys != nil || cr.JWTSecret != nil
+}
+
+// Authenticate returns role of the bearer token or the API key,
+// driverID is set for driver tokens that may only write the driver
+func (cr Credentials) Authenticate(key, token string) (role string, driverID *int, err error) {
+	if cr.JWTSecret != nil && token != "" {
+		claims, err := parseToken(cr.JWTSecret, token)
+		if err != nil {
+			return "", nil, err
+		}
+		if claims.Role == RoleDriver {
+			driverID = claims.DriverID
+		}
+		return claims.Role, driverID, nil
+	}
+	if r, ok := cr.Keys[key]; ok {
+		return r, nil, nil
+	}
+	return "", nil, ErrUnauthenticated
+}
+
+func parseToken(secret []byte, token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	switch {
+	case claims.Role == RoleDispatcher:
+	case claims.Role == RoleDriver && claims.DriverID != nil:
+	default:
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// ownDriver returns true if the request may write the driver,
+// driver tokens may only write their own driver
+func ownDriver(c echo.Context, id int) bool {
+	own, ok := c.Get(driverIDKey).(int)
+	return !ok || own == id
+}
+
+// forbidDriver replies that the token may not write the driver
+func forbidDriver(c echo.Context, id int) error {
+	return failWith(c, http.StatusForbidden, CodeForbidden, "Token is not allowed to write driver "+strconv.Itoa(id))
+}
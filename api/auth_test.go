@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/kdrake/nearestdots/geofence"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys("driver:d1, dispatcher:x1")
+	assert.NoError(t, err)
+	assert.Equal(t, Keys{"d1": RoleDriver, "x1": RoleDispatcher}, keys)
+
+	keys, err = readKeys(strings.NewReader("# comment\n\ndriver:d1\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, Keys{"d1": RoleDriver}, keys)
+
+	for _, s := range []string{"d1", "driver:", "admin:a1"} {
+		_, err := ParseKeys(s)
+		assert.Equal(t, ErrInvalidKeys, err, s)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	a := New(":0", storage.NewManager(db, nil), geofence.New(10),
+		WithAPIKeys(Keys{"d1": RoleDriver, "x1": RoleDispatcher}))
+	request := func(method, path, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			r.Header.Set(apiKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		a.echo.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request(http.MethodGet, "/v2/driver/1", "", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	var e ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+	assert.Equal(t, CodeUnauthenticated, e.Code)
+	assert.Equal(t, http.StatusUnauthorized, request(http.MethodGet, "/v2/driver/1", "unknown", "").Code)
+
+	w = request(http.MethodGet, "/v2/driver/1", "d1", "")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+	assert.Equal(t, CodeForbidden, e.Code)
+
+	// browser clients unable to set headers send the key as a query param
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/v2/driver/1?api_key=x1", "", "").Code)
+	// probes and the spec are served without keys
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/healthz", "", "").Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/openapi.json", "", "").Code)
+
+	location := `{"driver_id": 2, "location": {"lat": 1, "lon": 1}}`
+	fence := `{"name": "airport", "center": {"lat": 1, "lon": 1}, "radius": 100}`
+	for _, c := range []struct {
+		method, path, body string
+		role               string
+	}{
+		{http.MethodPost, "/v2/driver/", location, RoleDriver},
+		{http.MethodPost, "/v2/drivers/locations", "[" + location + "]", RoleDriver},
+		{http.MethodGet, "/v2/driver/1", "", RoleDispatcher},
+		{http.MethodGet, "/v2/driver/1/1/nearest", "", RoleDispatcher},
+		{http.MethodGet, "/v2/stats", "", RoleDispatcher},
+		{http.MethodPost, "/v2/geofences", fence, RoleDispatcher},
+		{http.MethodGet, "/v2/geofences", "", RoleDispatcher},
+		{http.MethodPost, "/graphql", `{"query": "{ driver(id: 1) { id } }"}`, RoleDispatcher},
+	} {
+		allowed, denied := "x1", "d1"
+		if c.role == RoleDriver {
+			allowed, denied = denied, allowed
+		}
+		assert.Equal(t, http.StatusForbidden, request(c.method, c.path, denied, c.body).Code, c.method+" "+c.path)
+		w := request(c.method, c.path, allowed, c.body)
+		assert.Less(t, w.Code, http.StatusBadRequest, c.method+" "+c.path+": "+w.Body.String())
+	}
+
+	// statuses and attributes are changed by drivers and dispatchers
+	for key, status := range map[string]storage.Status{"d1": storage.StatusBusy, "x1": storage.StatusOffline} {
+		w := request(http.MethodPut, "/v2/driver/1/status", key, `{"status": "`+string(status)+`"}`)
+		assert.Equal(t, http.StatusOK, w.Code, key)
+		d, err := db.Get(1)
+		assert.NoError(t, err)
+		assert.Equal(t, status, d.Status, key)
+		w = request(http.MethodPut, "/v2/driver/1/attributes", key, `{"attributes": {"set_by": "`+key+`"}}`)
+		assert.Equal(t, http.StatusOK, w.Code, key)
+		d, err = db.Get(1)
+		assert.NoError(t, err)
+		assert.Equal(t, key, d.Attributes["set_by"], key)
+	}
+	for id, key := range map[int]string{1: "d1", 2: "x1"} {
+		w := request(http.MethodDelete, "/v2/driver/"+strconv.Itoa(id), key, "")
+		assert.Equal(t, http.StatusOK, w.Code, key)
+	}
+	assert.Equal(t, 0, db.Len())
+}
+
+func TestJWT(t *testing.T) {
+	secret := []byte("secret")
+	a := New(":0", storage.NewManager(storage.New(10), nil), nil, WithJWT(secret))
+	sign := func(method jwt.SigningMethod, key interface{}, claims *Claims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		assert.NoError(t, err)
+		return token
+	}
+	driver := func(id int) *Claims {
+		return &Claims{Role: RoleDriver, DriverID: &id}
+	}
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		a.echo.ServeHTTP(w, r)
+		return w
+	}
+	location := `{"driver_id": 1, "location": {"lat": 1, "lon": 1}}`
+
+	token := sign(jwt.SigningMethodHS256, secret, driver(1))
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/v2/driver/", token, location).Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodPut, "/v2/driver/1/status", token, `{"status": "busy"}`).Code)
+	// driver tokens may only write their own driver
+	other := sign(jwt.SigningMethodHS256, secret, driver(2))
+	w := request(http.MethodPost, "/v2/driver/", other, location)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	var e ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+	assert.Equal(t, CodeForbidden, e.Code)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodPut, "/v2/driver/1/status", other, `{"status": "free"}`).Code)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodDelete, "/v2/driver/1", other, "").Code)
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/v2/driver/1", token, "").Code)
+
+	dispatcher := sign(jwt.SigningMethodHS256, secret, &Claims{Role: RoleDispatcher})
+	w = request(http.MethodGet, "/v2/driver/1", dispatcher, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"busy"`)
+
+	expired := driver(1)
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	unsigned := sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, &Claims{Role: RoleDispatcher})
+	for name, token := range map[string]string{
+		"tampered":     dispatcher[:len(dispatcher)-2] + "xx",
+		"wrong secret": sign(jwt.SigningMethodHS256, []byte("other"), &Claims{Role: RoleDispatcher}),
+		"expired":      sign(jwt.SigningMethodHS256, secret, expired),
+		"alg none":     unsigned,
+		"no driver id": sign(jwt.SigningMethodHS256, secret, &Claims{Role: RoleDriver}),
+		"unknown role": sign(jwt.SigningMethodHS256, secret, &Claims{Role: "admin"}),
+	} {
+		w := request(http.MethodGet, "/v2/driver/1", token, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code, name)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+		assert.Equal(t, CodeUnauthenticated, e.Code, name)
+	}
+}
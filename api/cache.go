@@ -0,0 +1,114 @@
+package api
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+)
+
+type (
+	// nearestCache keeps drivers found by nearest queries for a short time, riders waiting
+	// for a match poll nearest drivers with nearly the same coordinates many times a second
+	nearestCache struct {
+		mu        sync.Mutex
+		ttl       time.Duration
+		precision int
+		entries   map[string]cachedNearest
+		swept     time.Time
+	}
+
+	cachedNearest struct {
+		drivers []*storage.Driver
+		expires time.Time
+	}
+)
+
+// WithNearestCache caches drivers found by nearest queries for ttl, queries are keyed by the namespace,
+// the point rounded to precision decimal places, count and filters. Distances and ETAs are computed
+// from the requested point, so cached drivers are ranked for every query anew.
+// Lists of excluded and only ids are keyed as they are, ids in another order are queried again.
+func WithNearestCache(ttl time.Duration, precision int) Option {
+	return func(a *API) {
+		if ttl > 0 {
+			a.nearestCache = &nearestCache{
+				ttl:       ttl,
+				precision: precision,
+				entries:   make(map[string]cachedNearest),
+			}
+		}
+	}
+}
+
+// key of the nearest query of the request, attributes are sorted so their order doesn't matter
+func (n *nearestCache) key(c echo.Context, origin storage.Location, count int) string {
+	attrs := append([]string(nil), c.QueryParams()["attr"]...)
+	sort.Strings(attrs)
+	include, _ := strconv.ParseBool(c.QueryParam("include_unavailable"))
+	return strings.Join([]string{
+		namespaceName(c),
+		strconv.FormatFloat(origin.Lat, 'f', n.precision, 64),
+		strconv.FormatFloat(origin.Lon, 'f', n.precision, 64),
+		strconv.Itoa(count),
+		strconv.FormatBool(include),
+		strings.Join(attrs, ","),
+		c.QueryParam("exclude_ids"),
+		c.QueryParam("only_ids"),
+	}, "|")
+}
+
+// get returns cached drivers, the slice must not be modified
+func (n *nearestCache) get(key string, now time.Time) ([]*storage.Driver, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	e, ok := n.entries[key]
+	if !ok || now.After(e.expires) {
+		return nil, false
+	}
+	return e.drivers, true
+}
+
+func (n *nearestCache) put(key string, drivers []*storage.Driver, now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if now.Sub(n.swept) > n.ttl {
+		n.sweep(now)
+	}
+	n.entries[key] = cachedNearest{drivers: drivers, expires: now.Add(n.ttl)}
+}
+
+// sweep removes expired entries, call it under the lock
+func (n *nearestCache) sweep(now time.Time) {
+	for key, e := range n.entries {
+		if now.After(e.expires) {
+			delete(n.entries, key)
+		}
+	}
+	n.swept = now
+}
+
+// findNearest queries the storage of the request or takes drivers of the same query from the cache,
+// cached drivers are sorted by distance to the origin
+func (a *API) findNearest(c echo.Context, origin storage.Location, count int, filters []storage.Filter) []*storage.Driver {
+	query := func() []*storage.Driver {
+		return database(c).Nearest(rtreego.Point{origin.Lat, origin.Lon}, count, filters...)
+	}
+	if a.nearestCache == nil {
+		return query()
+	}
+	now := time.Now()
+	key := a.nearestCache.key(c, origin, count)
+	cached, ok := a.nearestCache.get(key, now)
+	if !ok {
+		cached = query()
+		a.nearestCache.put(key, cached, now)
+	}
+	drivers := append([]*storage.Driver(nil), cached...)
+	storage.SortByDistance(origin, drivers)
+	return drivers
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime"
+	"strings"
+
+	"github.com/kdrake/nearestdots/rpc"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Content types besides JSON, protobuf messages are those of the gRPC API
+const (
+	mimeProtobuf = "application/x-protobuf"
+	mimeMsgpack  = "application/msgpack"
+	mimeXMsgpack = "application/x-msgpack"
+)
+
+// bindPayload decodes JSON, protobuf LocationUpdate or MessagePack of the payload.
+// MessagePack maps have JSON field names.
+func bindPayload(c echo.Context, p *Payload) error {
+	switch mediaType(c.Request().Header.Get(echo.HeaderContentType)) {
+	case mimeProtobuf:
+		body, err := ioutil.ReadAll(c.Request().Body)
+		if err != nil {
+			return err
+		}
+		u := &rpc.LocationUpdate{}
+		if err := proto.Unmarshal(body, u); err != nil {
+			return err
+		}
+		*p = Payload{
+			DriverID:   int(u.Id),
+			Attributes: u.Attributes,
+			Status:     storage.Status(u.Status),
+		}
+		if u.Location != nil {
+			p.Location = Location{Latitude: u.Location.Lat, Longitude: u.Location.Lon}
+		}
+		if u.Timestamp != 0 {
+			p.Timestamp = &u.Timestamp
+		}
+		return nil
+	case mimeMsgpack, mimeXMsgpack:
+		dec := msgpack.NewDecoder(c.Request().Body)
+		dec.SetCustomStructTag("json")
+		return dec.Decode(p)
+	case echo.MIMEApplicationJSON:
+		// empty bodies are rejected by Bind
+		if c.Request().ContentLength == 0 {
+			break
+		}
+		buf := jsonBuffers.Get().(*bytes.Buffer)
+		defer jsonBuffers.Put(buf)
+		buf.Reset()
+		if _, err := buf.ReadFrom(c.Request().Body); err != nil {
+			return err
+		}
+		return decodePayload(buf.Bytes(), p)
+	}
+	return c.Bind(p)
+}
+
+// respond encodes the response in the first of JSON, MessagePack or protobuf accepted by the client.
+// Only nearest drivers have a protobuf message, other responses are JSON then.
+func respond(c echo.Context, code int, v interface{}) error {
+	c.Response().Header().Add("Vary", echo.HeaderAccept)
+	switch accepted(c.Request().Header.Get(echo.HeaderAccept)) {
+	case mimeProtobuf:
+		if r, ok := v.(*NearestDriverResponse); ok {
+			body, err := proto.Marshal(nearestMessage(r))
+			if err != nil {
+				return err
+			}
+			return c.Blob(code, mimeProtobuf, body)
+		}
+	case mimeMsgpack, mimeXMsgpack:
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return c.Blob(code, mimeMsgpack, buf.Bytes())
+	}
+	// pretty JSON is left to echo
+	if _, pretty := c.QueryParams()["pretty"]; !pretty && !c.Echo().Debug {
+		if r, ok := v.(jsonAppender); ok {
+			buf := jsonResponses.Get().(*[]byte)
+			defer jsonResponses.Put(buf)
+			b, ok := r.appendJSON((*buf)[:0])
+			*buf = b
+			if ok {
+				// a newline ends values written by encoding/json Encoder
+				*buf = append(b, '\n')
+				return c.Blob(code, echo.MIMEApplicationJSONCharsetUTF8, *buf)
+			}
+		}
+	}
+	return c.JSON(code, v)
+}
+
+// accepted returns the first supported media type of the Accept header, JSON by default.
+// Quality values are ignored, clients list preferred types first.
+func accepted(accept string) string {
+	for _, t := range strings.Split(accept, ",") {
+		switch t = mediaType(t); t {
+		case echo.MIMEApplicationJSON, mimeProtobuf, mimeMsgpack, mimeXMsgpack:
+			return t
+		}
+	}
+	return echo.MIMEApplicationJSON
+}
+
+func mediaType(header string) string {
+	t, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+	return t
+}
+
+func nearestMessage(r *NearestDriverResponse) *rpc.NearestResponse {
+	resp := &rpc.NearestResponse{Drivers: make([]*rpc.NearestDriver, len(r.Drivers))}
+	for i, n := range r.Drivers {
+		resp.Drivers[i] = &rpc.NearestDriver{
+			Driver: &rpc.Driver{
+				Id:         int64(n.ID),
+				Location:   &rpc.Location{Lat: n.LastLocation.Lat, Lon: n.LastLocation.Lon},
+				Attributes: n.Attributes,
+				Status:     string(n.Status),
+				Speed:      n.Speed,
+				Heading:    n.Heading,
+				Timestamp:  n.Timestamp,
+			},
+			Distance:   n.Distance,
+			EtaSeconds: n.ETASeconds,
+		}
+	}
+	return resp
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kdrake/nearestdots/rpc"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestContentNegotiation(t *testing.T) {
+	a, db := newTestAPI(t)
+	request := func(method, path, contentType, accept string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if contentType != "" {
+			r.Header.Set(echo.HeaderContentType, contentType)
+		}
+		if accept != "" {
+			r.Header.Set(echo.HeaderAccept, accept)
+		}
+		w := httptest.NewRecorder()
+		a.echo.ServeHTTP(w, r)
+		return w
+	}
+
+	update, err := proto.Marshal(&rpc.LocationUpdate{Id: 1, Location: &rpc.Location{Lat: 1, Lon: 1}, Status: "busy", Timestamp: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/v2/driver/", mimeProtobuf, "", update).Code)
+	d, err := db.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Location{Lat: 1, Lon: 1}, d.LastLocation)
+	assert.Equal(t, storage.Status("busy"), d.Status)
+	assert.Equal(t, int64(2), d.Timestamp)
+
+	// MessagePack maps have JSON field names
+	update, err = msgpack.Marshal(map[string]interface{}{
+		"driver_id":  2,
+		"location":   map[string]float64{"lat": 1.01, "lon": 1},
+		"attributes": map[string]string{"car": "van"},
+	})
+	assert.NoError(t, err)
+	for _, mime := range []string{mimeMsgpack, mimeXMsgpack} {
+		assert.Equal(t, http.StatusOK, request(http.MethodPost, "/v2/driver/", mime, "", update).Code, mime)
+	}
+	d, err = db.Get(2)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Location{Lat: 1.01, Lon: 1}, d.LastLocation)
+	assert.Equal(t, map[string]string{"car": "van"}, d.Attributes)
+
+	w := request(http.MethodPost, "/v2/driver/", mimeProtobuf, "", []byte{0xff, 0xff})
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	w = request(http.MethodPost, "/v2/driver/", mimeMsgpack, "", []byte{0xc1})
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	// payloads in other encodings are validated like JSON ones
+	update, err = proto.Marshal(&rpc.LocationUpdate{Id: 3, Location: &rpc.Location{Lat: 91}})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/v2/driver/", mimeProtobuf, "", update).Code)
+
+	nearest := "/v2/driver/1/1/nearest?count=1"
+	w = request(http.MethodGet, nearest, "", mimeProtobuf, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, mimeProtobuf, w.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, echo.HeaderAccept, w.Header().Get("Vary"))
+	resp := &rpc.NearestResponse{}
+	assert.NoError(t, proto.Unmarshal(w.Body.Bytes(), resp))
+	// busy drivers are not nearest
+	if assert.Len(t, resp.Drivers, 1) {
+		assert.Equal(t, int64(2), resp.Drivers[0].Driver.Id)
+		assert.Equal(t, map[string]string{"car": "van"}, resp.Drivers[0].Driver.Attributes)
+		assert.InDelta(t, 1112, resp.Drivers[0].Distance, 1)
+	}
+
+	// the first supported type is chosen
+	w = request(http.MethodGet, nearest, "", "text/html, application/x-msgpack;q=0.5, application/json", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, mimeMsgpack, w.Header().Get(echo.HeaderContentType))
+	var m map[string]interface{}
+	assert.NoError(t, msgpack.Unmarshal(w.Body.Bytes(), &m))
+	assert.Equal(t, true, m["success"])
+	if drivers, ok := m["drivers"].([]interface{}); assert.True(t, ok) && assert.Len(t, drivers, 1) {
+		assert.EqualValues(t, 2, drivers[0].(map[string]interface{})["id"])
+	}
+
+	// responses without a protobuf message are JSON
+	w = request(http.MethodGet, "/v2/driver/1", "", mimeProtobuf, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get(echo.HeaderContentType), echo.MIMEApplicationJSON)
+	w = request(http.MethodGet, nearest, "", "text/html", nil)
+	assert.Contains(t, w.Header().Get(echo.HeaderContentType), echo.MIMEApplicationJSON)
+}
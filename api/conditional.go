@@ -0,0 +1,110 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ifMatchHeader has the version of the driver an update is conditional on
+	ifMatchHeader = "If-Match"
+	// etagHeader has the version of the driver after a read or an update
+	etagHeader = "ETag"
+)
+
+// ErrVersionsUnsupported sign what storage of the namespace doesn't keep versions of drivers
+var ErrVersionsUnsupported = errors.New("Storage does not support conditional updates")
+
+// versioner is a storage updating status and attributes of drivers if they are of the expected version
+type versioner interface {
+	SetStatusIf(id int, status storage.Status, version uint64) (uint64, error)
+	SetAttributesIf(id int, attributes map[string]string, version uint64) (uint64, error)
+}
+
+func (a *API) setDriverAttributes(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "could not convert string to integer")
+	}
+	if !ownDriver(c, id) {
+		return forbidDriver(c, id)
+	}
+	version, ok := ifMatch(c)
+	if !ok {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "If-Match must be a quoted driver version")
+	}
+
+	p := &AttributesPayload{}
+	if err := c.Bind(p); err != nil {
+		return failWith(c, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Set content-type application/json or check your payload data")
+	}
+
+	if p.Attributes == nil {
+		p.Attributes = map[string]string{}
+	}
+	err = a.updateIf(c, func(v versioner) (uint64, error) {
+		return v.SetAttributesIf(id, p.Attributes, version)
+	})
+	if err == ErrVersionsUnsupported && version == 0 {
+		err = setAttributes(database(c), id, p.Attributes)
+	}
+	if err != nil {
+		return fail(c, err)
+	}
+	return c.JSON(http.StatusOK, &DefaultResponse{
+		Success: true,
+		Message: "updated",
+	})
+}
+
+// updateIf makes the conditional update with the storage of the request namespace
+// and replies with the version of the driver in ETag, also if the update fails for another version.
+// The storage is resolved again as the tracing wrapper has no versions.
+func (a *API) updateIf(c echo.Context, update func(v versioner) (uint64, error)) error {
+	s, err := a.namespaces.Namespace(namespaceName(c))
+	if err != nil {
+		return err
+	}
+	v, ok := s.(versioner)
+	if !ok {
+		return ErrVersionsUnsupported
+	}
+	version, err := update(v)
+	if version != 0 {
+		c.Response().Header().Set(etagHeader, etag(version))
+	}
+	return err
+}
+
+// setAttributes replaces attributes of the driver with its last location,
+// storages without versions change attributes with locations only.
+func setAttributes(s storage.Storage, id int, attributes map[string]string) error {
+	d, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	return s.Set(&storage.Driver{ID: id, LastLocation: d.LastLocation, Attributes: attributes, Timestamp: d.Timestamp})
+}
+
+// ifMatch returns the version of the If-Match header, 0 if it's not set or matches any version.
+// Returns false if the header isn't a version.
+func ifMatch(c echo.Context) (uint64, bool) {
+	v := strings.TrimSpace(c.Request().Header.Get(ifMatchHeader))
+	if v == "" || v == "*" {
+		return 0, true
+	}
+	version, err := strconv.ParseUint(strings.Trim(strings.TrimPrefix(v, "W/"), `"`), 10, 64)
+	if err != nil || version == 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+func etag(version uint64) string {
+	return `"` + strconv.FormatUint(version, 10) + `"`
+}
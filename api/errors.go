@@ -0,0 +1,172 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kdrake/nearestdots/consensus"
+	"github.com/kdrake/nearestdots/geofence"
+	"github.com/kdrake/nearestdots/orders"
+	"github.com/kdrake/nearestdots/replication"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/kdrake/nearestdots/webhook"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Codes of error responses, clients branch on them instead of messages
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeInvalidCoordinates   = "invalid_coordinates"
+	CodeInvalidNamespace     = "invalid_namespace"
+	CodeNamespaceNotFound    = "namespace_not_found"
+	CodeTooManyNamespaces    = "too_many_namespaces"
+	CodeOutOfRegions         = "out_of_regions"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeUnauthenticated      = "unauthenticated"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeDriverNotFound       = "driver_not_found"
+	CodeOrderNotFound        = "order_not_found"
+	CodeFenceNotFound        = "fence_not_found"
+	CodeWebhookNotFound      = "webhook_not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeStaleLocation        = "stale_location"
+	CodeVersionMismatch      = "version_mismatch"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeVersionsUnsupported  = "versions_unsupported"
+	CodeNoDriverAvailable    = "no_driver_available"
+	CodeInvalidTransition    = "invalid_transition"
+	CodeThrottled            = "throttled"
+	CodeImpossibleSpeed      = "impossible_speed"
+	CodeRateLimited          = "rate_limited"
+	CodeReadOnly             = "read_only"
+	CodePositionLost         = "position_lost"
+	CodeNotLeader            = "not_leader"
+	CodeUnavailable          = "unavailable"
+	CodeInternal             = "internal"
+)
+
+// errorCode is the status and the code of an error
+type errorCode struct {
+	status int
+	code   string
+}
+
+// errorCodes map known errors, other errors of handlers are invalid requests
+var errorCodes = map[error]errorCode{
+	storage.ErrDriverDoesNotExist:   {http.StatusNotFound, CodeDriverNotFound},
+	storage.ErrInvalidLocation:      {http.StatusBadRequest, CodeInvalidCoordinates},
+	storage.ErrInvalidBoundingBox:   {http.StatusBadRequest, CodeInvalidCoordinates},
+	storage.ErrInvalidRoute:         {http.StatusBadRequest, CodeInvalidCoordinates},
+	storage.ErrInvalidPolyline:      {http.StatusBadRequest, CodeInvalidCoordinates},
+	storage.ErrStaleLocation:        {http.StatusConflict, CodeStaleLocation},
+	storage.ErrVersionMismatch:      {http.StatusPreconditionFailed, CodeVersionMismatch},
+	ErrVersionsUnsupported:          {http.StatusNotImplemented, CodeVersionsUnsupported},
+	storage.ErrThrottled:            {http.StatusTooManyRequests, CodeThrottled},
+	storage.ErrImpossibleSpeed:      {http.StatusUnprocessableEntity, CodeImpossibleSpeed},
+	storage.ErrOutOfRegions:         {http.StatusUnprocessableEntity, CodeOutOfRegions},
+	storage.ErrInvalidNamespace:     {http.StatusBadRequest, CodeInvalidNamespace},
+	storage.ErrNamespacesDisabled:   {http.StatusBadRequest, CodeInvalidNamespace},
+	storage.ErrNamespaceNotFound:    {http.StatusNotFound, CodeNamespaceNotFound},
+	storage.ErrTooManyNamespaces:    {http.StatusInsufficientStorage, CodeTooManyNamespaces},
+	orders.ErrOrderDoesNotExist:     {http.StatusNotFound, CodeOrderNotFound},
+	orders.ErrNoDriverAvailable:     {http.StatusConflict, CodeNoDriverAvailable},
+	orders.ErrInvalidTransition:     {http.StatusConflict, CodeInvalidTransition},
+	geofence.ErrFenceDoesNotExist:   {http.StatusNotFound, CodeFenceNotFound},
+	webhook.ErrEndpointDoesNotExist: {http.StatusNotFound, CodeWebhookNotFound},
+	ErrUnauthenticated:              {http.StatusUnauthorized, CodeUnauthenticated},
+	ErrInvalidToken:                 {http.StatusUnauthorized, CodeUnauthenticated},
+	ErrNotInitialized:               {http.StatusServiceUnavailable, CodeUnavailable},
+	ErrShuttingDown:                 {http.StatusServiceUnavailable, CodeUnavailable},
+	storage.ErrJanitorStopped:       {http.StatusServiceUnavailable, CodeUnavailable},
+	storage.ErrJanitorStuck:         {http.StatusServiceUnavailable, CodeUnavailable},
+	replication.ErrReadOnly:         {http.StatusForbidden, CodeReadOnly},
+	replication.ErrNotSynced:        {http.StatusServiceUnavailable, CodeUnavailable},
+	replication.ErrPositionLost:     {http.StatusGone, CodePositionLost},
+	consensus.ErrNotLeader:          {http.StatusServiceUnavailable, CodeNotLeader},
+	consensus.ErrNoLeader:           {http.StatusServiceUnavailable, CodeUnavailable},
+}
+
+// statusCodes are codes of echo errors and unmatched routes
+var statusCodes = map[int]string{
+	http.StatusBadRequest:           CodeInvalidRequest,
+	http.StatusUnauthorized:         CodeUnauthenticated,
+	http.StatusForbidden:            CodeForbidden,
+	http.StatusNotFound:             CodeNotFound,
+	http.StatusMethodNotAllowed:     CodeMethodNotAllowed,
+	http.StatusUnsupportedMediaType: CodeUnsupportedMediaType,
+	http.StatusTooManyRequests:      CodeRateLimited,
+	http.StatusServiceUnavailable:   CodeUnavailable,
+}
+
+// fail replies with the status and the code of the error.
+// v1 replies 400 to missing resources as it did before error codes.
+func fail(c echo.Context, err error) error {
+	e, ok := errorCodes[errors.Cause(err)]
+	if !ok {
+		e = errorCode{http.StatusBadRequest, CodeInvalidRequest}
+	}
+	if e.status == http.StatusNotFound && apiVersion(c) < v2 {
+		e.status = http.StatusBadRequest
+	}
+	return failWith(c, e.status, e.code, err.Error())
+}
+
+// failWith replies with ErrorResponse, request id is set by logRequests
+func failWith(c echo.Context, status int, code, message string, details ...FieldError) error {
+	return c.JSON(status, &ErrorResponse{
+		Success:   false,
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+	})
+}
+
+// invalid replies with the invalid fields, the code is invalid_coordinates if only coordinates are invalid
+func invalid(c echo.Context, errs fieldErrors) error {
+	code := CodeInvalidCoordinates
+	for _, e := range errs {
+		if !coordinate(e.Field) {
+			code = CodeInvalidRequest
+			break
+		}
+	}
+	return failWith(c, http.StatusBadRequest, code, "Invalid request", errs...)
+}
+
+func coordinate(field string) bool {
+	field = field[strings.LastIndex(field, ".")+1:]
+	return field == "lat" || field == "lon"
+}
+
+// httpError replies to errors of echo and unmatched routes with ErrorResponse,
+// other errors are internal and their messages are only logged
+func (a *API) httpError(err error, c echo.Context) {
+	status, message := http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError)
+	if he, ok := err.(*echo.HTTPError); ok {
+		status, message = he.Code, fmt.Sprint(he.Message)
+	}
+	code, ok := statusCodes[status]
+	if !ok {
+		code = CodeInternal
+	}
+	if status >= http.StatusInternalServerError {
+		requestLogger(c).Error("request failed", zap.Error(err))
+	}
+
+	if c.Response().Committed {
+		return
+	}
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = failWith(c, status, code, message)
+	}
+	if err != nil {
+		requestLogger(c).Error("could not reply error", zap.Error(err))
+	}
+}
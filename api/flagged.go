@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// ErrFlaggedUnsupported sign what storage of the namespace doesn't flag drivers
+var ErrFlaggedUnsupported = errors.New("Storage does not flag drivers")
+
+// flaggedLister is a storage listing drivers flagged for impossible speed
+type flaggedLister interface {
+	Flagged(limit int) []*storage.Driver
+}
+
+func (a *API) flaggedDrivers(c echo.Context) error {
+	limit := defaultListLimit
+	if v := c.QueryParam("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "limit must be a positive integer")
+		}
+	}
+
+	// the storage is resolved again as the tracing wrapper doesn't list flagged drivers
+	s, err := a.namespaces.Namespace(namespaceName(c))
+	if err != nil {
+		return fail(c, err)
+	}
+	l, ok := s.(flaggedLister)
+	if !ok {
+		return fail(c, ErrFlaggedUnsupported)
+	}
+
+	return c.JSON(http.StatusOK, &FlaggedResponse{
+		Success: true,
+		Message: "found",
+		Drivers: l.Flagged(limit),
+	})
+}
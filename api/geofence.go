@@ -0,0 +1,86 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// defaultEventsLimit is number of geofence events returned if limit is not set
+const defaultEventsLimit = 100
+
+func (a *API) addFence(c echo.Context) error {
+	p := &FencePayload{}
+	if err := c.Bind(p); err != nil {
+		return failWith(c, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Set content-type application/json or check your payload data")
+	}
+
+	fence, err := p.Fence()
+	if err == nil {
+		err = a.fences.Add(fence)
+	}
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{
+		Success: true,
+		Message: "Added",
+	})
+}
+
+func (a *API) listFences(c echo.Context) error {
+	return c.JSON(http.StatusOK, &FencesResponse{
+		Success: true,
+		Message: "found",
+		Fences:  a.fences.Fences(),
+	})
+}
+
+func (a *API) removeFence(c echo.Context) error {
+	if err := a.fences.Remove(c.Param("name")); err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{
+		Success: true,
+		Message: "removed",
+	})
+}
+
+func (a *API) fenceEvents(c echo.Context) error {
+	after, limit, err := eventsQuery(c)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &EventsResponse{
+		Success: true,
+		Message: "found",
+		Events:  a.fences.Events(after, limit),
+	})
+}
+
+// eventsQuery parses after and limit query parameters of event lists
+func eventsQuery(c echo.Context) (uint64, int, error) {
+	var after uint64
+	if v := c.QueryParam("after"); v != "" {
+		var err error
+		after, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, 0, errors.New("after must be a non-negative integer")
+		}
+	}
+
+	limit := defaultEventsLimit
+	if v := c.QueryParam("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return 0, 0, errors.New("limit must be a positive integer")
+		}
+	}
+	return after, limit, nil
+}
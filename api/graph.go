@@ -0,0 +1,21 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kdrake/nearestdots/graph"
+	"github.com/labstack/echo"
+)
+
+func (a *API) graphQL(c echo.Context) error {
+	r := graph.Request{}
+	// numbers of variables are kept exact for Int64
+	dec := json.NewDecoder(c.Request().Body)
+	dec.UseNumber()
+	if err := dec.Decode(&r); err != nil {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "Check your GraphQL request")
+	}
+
+	return c.JSON(http.StatusOK, a.graph.Exec(c.Request().Context(), database(c), r))
+}
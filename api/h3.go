@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// ErrH3Unsupported sign what storage of the namespace doesn't aggregate drivers by H3 cells
+var ErrH3Unsupported = errors.New("Storage does not aggregate drivers by H3 cells")
+
+// h3Aggregator is a storage counting drivers per H3 cell
+type h3Aggregator interface {
+	H3(resolution int) ([]storage.H3Cell, error)
+}
+
+// WithH3Resolutions serves drivers aggregated by H3 cells of the resolutions at /h3,
+// the first resolution is served by default
+func WithH3Resolutions(resolutions []int) Option {
+	return func(a *API) {
+		a.h3Resolutions = resolutions
+	}
+}
+
+func (a *API) h3Cells(c echo.Context) error {
+	resolution := a.h3Resolutions[0]
+	if v := c.QueryParam("resolution"); v != "" {
+		var err error
+		resolution, err = strconv.Atoi(v)
+		if err != nil || !a.h3Resolution(resolution) {
+			return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "resolution must be one of configured H3 resolutions")
+		}
+	}
+
+	// the storage is resolved again as the tracing wrapper doesn't aggregate by H3 cells
+	s, err := a.namespaces.Namespace(namespaceName(c))
+	if err != nil {
+		return fail(c, err)
+	}
+	agg, ok := s.(h3Aggregator)
+	if !ok {
+		return fail(c, ErrH3Unsupported)
+	}
+	cells, err := agg.H3(resolution)
+	if err != nil {
+		return failWith(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, &H3Response{
+		Success:    true,
+		Message:    "found",
+		Resolution: resolution,
+		Cells:      cells,
+	})
+}
+
+// driverH3 returns H3 indexes of the driver location at all configured resolutions
+func (a *API) driverH3(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "could not convert string to integer")
+	}
+	d, err := database(c).Get(id)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	cells := make(map[int]string, len(a.h3Resolutions))
+	for _, r := range a.h3Resolutions {
+		if cells[r], err = storage.H3Index(d.LastLocation, r); err != nil {
+			return failWith(c, http.StatusInternalServerError, CodeInternal, err.Error())
+		}
+	}
+	return c.JSON(http.StatusOK, &DriverH3Response{
+		Success: true,
+		Message: "found",
+		Cells:   cells,
+	})
+}
+
+func (a *API) h3Resolution(resolution int) bool {
+	for _, r := range a.h3Resolutions {
+		if r == resolution {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// readyTimeout limits how long backends of namespaces are pinged by /readyz
+const readyTimeout = 2 * time.Second
+
+var (
+	// ErrNotInitialized sign what default namespace has no storage
+	ErrNotInitialized = errors.New("Storage is not initialized")
+	// ErrShuttingDown sign what server is draining requests before exit
+	ErrShuttingDown = errors.New("Server is shutting down")
+)
+
+// WithJanitor fails health checks if the janitor stops removing expired drivers
+func WithJanitor(janitor *storage.Janitor) Option {
+	return func(a *API) {
+		a.janitor = janitor
+	}
+}
+
+// health replies whether the process works: the storage is initialized and the janitor is running.
+// Backends are not pinged, restarting the process doesn't fix an unreachable database.
+func (a *API) health(c echo.Context) error {
+	if err := a.check(); err != nil {
+		return unavailable(c, err)
+	}
+	return c.JSON(http.StatusOK, &DefaultResponse{
+		Success: true,
+		Message: "ok",
+	})
+}
+
+// ready replies whether the server may receive requests:
+// it's healthy, backends are reachable and it's not shutting down
+func (a *API) ready(c echo.Context) error {
+	select {
+	case <-a.done:
+		return unavailable(c, ErrShuttingDown)
+	default:
+	}
+	if err := a.check(); err != nil {
+		return unavailable(c, err)
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), readyTimeout)
+	defer cancel()
+	if err := a.namespaces.Ping(ctx); err != nil {
+		return unavailable(c, err)
+	}
+	return c.JSON(http.StatusOK, &DefaultResponse{
+		Success: true,
+		Message: "ready",
+	})
+}
+
+func (a *API) check() error {
+	if db, err := a.namespaces.Namespace(""); err != nil || db == nil {
+		return ErrNotInitialized
+	}
+	if a.janitor != nil {
+		return a.janitor.Check()
+	}
+	return nil
+}
+
+func unavailable(c echo.Context, err error) error {
+	return failWith(c, http.StatusServiceUnavailable, CodeUnavailable, err.Error())
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+const (
+	// idempotencyHeader carries a key of the request, retries of the request have the same key
+	idempotencyHeader = "Idempotency-Key"
+	// replayedHeader is set on responses replayed for retries
+	replayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength limits keys set by clients
+	maxIdempotencyKeyLength = 255
+)
+
+type (
+	// idempotency keeps successful responses of requests with idempotency keys for a while,
+	// so retries of updates from flaky mobile networks get them without being applied again
+	idempotency struct {
+		mu        sync.Mutex
+		ttl       time.Duration
+		max       int
+		responses map[string]keptResponse
+		// order has keys in order they are kept, which is the order they expire in
+		order []keptKey
+	}
+
+	keptResponse struct {
+		// request is the hash of the request body, the key can't be reused for another request
+		request     [sha256.Size]byte
+		status      int
+		contentType string
+		body        []byte
+		expires     time.Time
+	}
+
+	keptKey struct {
+		key     string
+		expires time.Time
+	}
+
+	// recorder copies the body written to the response
+	recorder struct {
+		http.ResponseWriter
+		body bytes.Buffer
+	}
+)
+
+// WithIdempotency replays successful responses of updates with an Idempotency-Key header to retries
+// with the same key for ttl, up to max responses are kept, the oldest ones are dropped above it.
+// Keys are scoped by the namespace, the API key or token and the path of the request, keys of clients
+// without credentials are scoped by the request body too, as clients behind the same NAT share IPs.
+// Retries made while the request is served are applied again, locations of the same time are deduplicated by storages.
+func WithIdempotency(ttl time.Duration, max int) Option {
+	return func(a *API) {
+		if ttl > 0 && max > 0 {
+			a.idempotency = &idempotency{
+				ttl:       ttl,
+				max:       max,
+				responses: make(map[string]keptResponse),
+			}
+		}
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotent is a middleware of updates replaying responses to retries of requests with the same idempotency key
+func (a *API) idempotent(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(idempotencyHeader)
+		if a.idempotency == nil || key == "" {
+			return next(c)
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return failWith(c, http.StatusBadRequest, CodeInvalidRequest, idempotencyHeader+" must be at most 255 characters")
+		}
+		body, err := ioutil.ReadAll(c.Request().Body)
+		if err != nil {
+			return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "could not read request body")
+		}
+		c.Request().Body = ioutil.NopCloser(bytes.NewReader(body))
+		request := sha256.Sum256(body)
+		scope := credentials(c)
+		if scope == "" {
+			scope = hex.EncodeToString(request[:])
+		}
+		key = strings.Join([]string{namespaceName(c), scope, c.Request().URL.Path, key}, "|")
+
+		now := time.Now()
+		if r, ok := a.idempotency.get(key, now); ok {
+			if r.request != request {
+				return failWith(c, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, idempotencyHeader+" was used for another request")
+			}
+			c.Response().Header().Set(replayedHeader, "true")
+			return c.Blob(r.status, r.contentType, r.body)
+		}
+		rec := &recorder{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = rec
+		err = next(c)
+		c.Response().Writer = rec.ResponseWriter
+		// failed requests may be retried
+		if status := c.Response().Status; err == nil && status >= 200 && status < 300 {
+			a.idempotency.put(key, keptResponse{
+				request:     request,
+				status:      status,
+				contentType: c.Response().Header().Get(echo.HeaderContentType),
+				body:        rec.body.Bytes(),
+			}, now)
+		}
+		return err
+	}
+}
+
+func (i *idempotency) get(key string, now time.Time) (keptResponse, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	r, ok := i.responses[key]
+	if !ok || now.After(r.expires) {
+		return keptResponse{}, false
+	}
+	return r, true
+}
+
+func (i *idempotency) put(key string, r keptResponse, now time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.evict(now)
+	r.expires = now.Add(i.ttl)
+	i.responses[key] = r
+	i.order = append(i.order, keptKey{key: key, expires: r.expires})
+}
+
+// evict removes expired responses and the oldest ones above the max, so a new one fits. Call it under the lock.
+func (i *idempotency) evict(now time.Time) {
+	n := 0
+	for ; n < len(i.order); n++ {
+		k := i.order[n]
+		if !now.After(k.expires) && len(i.responses) < i.max {
+			break
+		}
+		// the key is kept again if it expired meanwhile
+		if r, ok := i.responses[k.key]; ok && r.expires.Equal(k.expires) {
+			delete(i.responses, k.key)
+		}
+	}
+	i.order = i.order[n:]
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// ErrIdleUnsupported sign what storage of the namespace doesn't track idle time of drivers
+var ErrIdleUnsupported = errors.New("Storage does not track idle drivers")
+
+// idleLister is a storage listing drivers idle for the longest time
+type idleLister interface {
+	Idle(limit int) []*storage.Driver
+}
+
+func (a *API) idleDrivers(c echo.Context) error {
+	limit := defaultListLimit
+	if v := c.QueryParam("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "limit must be a positive integer")
+		}
+	}
+
+	// the storage is resolved again as the tracing wrapper doesn't list idle drivers
+	s, err := a.namespaces.Namespace(namespaceName(c))
+	if err != nil {
+		return fail(c, err)
+	}
+	l, ok := s.(idleLister)
+	if !ok {
+		return fail(c, ErrIdleUnsupported)
+	}
+
+	now := time.Now()
+	drivers := l.Idle(limit)
+	idle := make([]*IdleDriver, len(drivers))
+	for i, d := range drivers {
+		idle[i] = &IdleDriver{Driver: d, IdleSeconds: d.IdleFor(now).Seconds()}
+	}
+	return c.JSON(http.StatusOK, &IdleResponse{
+		Success: true,
+		Message: "found",
+		Drivers: idle,
+	})
+}
@@ -0,0 +1,472 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/kdrake/nearestdots/storage"
+)
+
+// errSlowPath sign what JSON must be decoded by encoding/json
+var errSlowPath = errors.New("JSON is not handled by the fast decoder")
+
+// jsonAppender is a response of a hot path with hand written JSON, it's the same as of encoding/json.
+// False is returned if it can't be encoded, encoding/json fails on it too.
+type jsonAppender interface {
+	appendJSON(b []byte) ([]byte, bool)
+}
+
+var (
+	// jsonBuffers keeps buffers of request bodies between requests
+	jsonBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+	// jsonResponses keeps buffers of encoded responses between requests
+	jsonResponses = sync.Pool{New: func() interface{} { b := make([]byte, 0, 1024); return &b }}
+)
+
+func (r *DefaultResponse) appendJSON(b []byte) ([]byte, bool) {
+	b = append(b, `{"success":`...)
+	b = strconv.AppendBool(b, r.Success)
+	b = append(b, `,"message":`...)
+	b = appendJSONString(b, r.Message)
+	return append(b, '}'), true
+}
+
+func (r *NearestDriverResponse) appendJSON(b []byte) ([]byte, bool) {
+	b = append(b, `{"success":`...)
+	b = strconv.AppendBool(b, r.Success)
+	b = append(b, `,"message":`...)
+	b = appendJSONString(b, r.Message)
+	b = append(b, `,"drivers":`...)
+	if r.Drivers == nil {
+		b = append(b, "null"...)
+	} else {
+		b = append(b, '[')
+		for i, n := range r.Drivers {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			var ok bool
+			if b, ok = n.appendJSON(b); !ok {
+				return b, false
+			}
+		}
+		b = append(b, ']')
+	}
+	return append(b, '}'), true
+}
+
+func (n *NearestDriver) appendJSON(b []byte) ([]byte, bool) {
+	if n == nil {
+		return append(b, "null"...), true
+	}
+	ok := true
+	b = append(b, '{')
+	if d := n.Driver; d != nil {
+		b = append(b, `"id":`...)
+		b = strconv.AppendInt(b, int64(d.ID), 10)
+		b = append(b, `,"location":{"lat":`...)
+		b = appendJSONFloat(b, d.LastLocation.Lat, &ok)
+		b = append(b, `,"lon":`...)
+		b = appendJSONFloat(b, d.LastLocation.Lon, &ok)
+		b = append(b, '}')
+		if len(d.Attributes) > 0 {
+			b = append(b, `,"attributes":`...)
+			b = appendJSONMap(b, d.Attributes)
+		}
+		b = append(b, `,"status":`...)
+		b = appendJSONString(b, string(d.Status))
+		b = append(b, `,"speed":`...)
+		b = appendJSONFloat(b, d.Speed, &ok)
+		b = append(b, `,"heading":`...)
+		b = appendJSONFloat(b, d.Heading, &ok)
+		b = append(b, `,"timestamp":`...)
+		b = strconv.AppendInt(b, d.Timestamp, 10)
+		if d.ReservedUntil != 0 {
+			b = append(b, `,"reserved_until":`...)
+			b = strconv.AppendInt(b, d.ReservedUntil, 10)
+		}
+		if d.IdleSince != 0 {
+			b = append(b, `,"idle_since":`...)
+			b = strconv.AppendInt(b, d.IdleSince, 10)
+		}
+		if d.OffShift {
+			b = append(b, `,"off_shift":true`...)
+		}
+		if d.Anomalies != 0 {
+			b = append(b, `,"anomalies":`...)
+			b = strconv.AppendInt(b, int64(d.Anomalies), 10)
+		}
+		if d.AnomalyAt != 0 {
+			b = append(b, `,"anomaly_at":`...)
+			b = strconv.AppendInt(b, d.AnomalyAt, 10)
+		}
+		if d.Version != 0 {
+			b = append(b, `,"version":`...)
+			b = strconv.AppendUint(b, d.Version, 10)
+		}
+		b = append(b, ',')
+	}
+	b = append(b, `"distance":`...)
+	b = appendJSONFloat(b, n.Distance, &ok)
+	b = append(b, `,"eta_seconds":`...)
+	b = appendJSONFloat(b, n.ETASeconds, &ok)
+	if n.AgeSeconds != nil {
+		b = append(b, `,"age_seconds":`...)
+		b = appendJSONFloat(b, *n.AgeSeconds, &ok)
+	}
+	if n.Score != nil {
+		b = append(b, `,"score":`...)
+		b = appendJSONFloat(b, *n.Score, &ok)
+	}
+	return append(b, '}'), ok
+}
+
+// appendJSONFloat formats like encoding/json, ok is cleared for NaN and infinities it can't encode
+func appendJSONFloat(b []byte, f float64, ok *bool) []byte {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		*ok = false
+		return b
+	}
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+// appendJSONMap writes the map with sorted keys like encoding/json
+func appendJSONMap(b []byte, m map[string]string) []byte {
+	var buf [16]string
+	keys := buf[:0]
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b = append(b, '{')
+	for i, k := range keys {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendJSONString(b, k)
+		b = append(b, ':')
+		b = appendJSONString(b, m[k])
+	}
+	return append(b, '}')
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString quotes the string like encoding/json with HTML escaping
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		// line and paragraph separators break JavaScript parsers
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
+
+// decodePayload decodes JSON of a payload, the ones the fast decoder doesn't handle,
+// like escaped strings or keys in other case, are decoded by encoding/json
+func decodePayload(data []byte, p *Payload) error {
+	d := jsonDecoder{data: data}
+	if err := d.payload(p); err == nil && d.end() {
+		return nil
+	}
+	*p = Payload{}
+	return json.NewDecoder(bytes.NewReader(data)).Decode(p)
+}
+
+// decodePayloadArray decodes a JSON array of payloads
+func decodePayloadArray(data []byte) ([]Payload, error) {
+	d := jsonDecoder{data: data}
+	if payloads, err := d.payloads(); err == nil && d.end() {
+		return payloads, nil
+	}
+	var payloads []Payload
+	err := json.NewDecoder(bytes.NewReader(data)).Decode(&payloads)
+	return payloads, err
+}
+
+// jsonDecoder reads JSON of payloads in the form clients send them, anything else is errSlowPath
+type jsonDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *jsonDecoder) skipSpace() {
+	for d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case ' ', '\t', '\n', '\r':
+			d.pos++
+		default:
+			return
+		}
+	}
+}
+
+// end reports whether only whitespace is left
+func (d *jsonDecoder) end() bool {
+	d.skipSpace()
+	return d.pos == len(d.data)
+}
+
+// consume skips whitespace and the byte c if it's next
+func (d *jsonDecoder) consume(c byte) bool {
+	d.skipSpace()
+	if d.pos < len(d.data) && d.data[d.pos] == c {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+// object calls field for every key of an object
+func (d *jsonDecoder) object(field func(key []byte) error) error {
+	if !d.consume('{') {
+		return errSlowPath
+	}
+	if d.consume('}') {
+		return nil
+	}
+	for {
+		key, err := d.str()
+		if err != nil {
+			return err
+		}
+		if !d.consume(':') {
+			return errSlowPath
+		}
+		if err := field(key); err != nil {
+			return err
+		}
+		if d.consume('}') {
+			return nil
+		}
+		if !d.consume(',') {
+			return errSlowPath
+		}
+	}
+}
+
+// str returns the unescaped string without copying, escaped strings are errSlowPath
+func (d *jsonDecoder) str() ([]byte, error) {
+	if !d.consume('"') {
+		return nil, errSlowPath
+	}
+	start := d.pos
+	for d.pos < len(d.data) {
+		c := d.data[d.pos]
+		switch {
+		case c == '"':
+			s := d.data[start:d.pos]
+			d.pos++
+			if !utf8.Valid(s) {
+				return nil, errSlowPath
+			}
+			return s, nil
+		case c == '\\' || c < 0x20:
+			return nil, errSlowPath
+		}
+		d.pos++
+	}
+	return nil, errSlowPath
+}
+
+// number returns the token of a JSON number
+func (d *jsonDecoder) number(integer bool) ([]byte, error) {
+	d.skipSpace()
+	start := d.pos
+	if d.pos < len(d.data) && d.data[d.pos] == '-' {
+		d.pos++
+	}
+	digits := d.digits()
+	if digits == 0 || digits > 1 && d.data[d.pos-digits] == '0' {
+		return nil, errSlowPath
+	}
+	if !integer && d.pos < len(d.data) && d.data[d.pos] == '.' {
+		d.pos++
+		if d.digits() == 0 {
+			return nil, errSlowPath
+		}
+	}
+	if !integer && d.pos < len(d.data) && (d.data[d.pos] == 'e' || d.data[d.pos] == 'E') {
+		d.pos++
+		if d.pos < len(d.data) && (d.data[d.pos] == '+' || d.data[d.pos] == '-') {
+			d.pos++
+		}
+		if d.digits() == 0 {
+			return nil, errSlowPath
+		}
+	}
+	return d.data[start:d.pos], nil
+}
+
+func (d *jsonDecoder) digits() int {
+	n := 0
+	for d.pos < len(d.data) && d.data[d.pos] >= '0' && d.data[d.pos] <= '9' {
+		d.pos++
+		n++
+	}
+	return n
+}
+
+func (d *jsonDecoder) float() (float64, error) {
+	token, err := d.number(false)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(string(token), 64)
+	if err != nil {
+		return 0, errSlowPath
+	}
+	return f, nil
+}
+
+func (d *jsonDecoder) int() (int64, error) {
+	token, err := d.number(true)
+	if err != nil {
+		return 0, err
+	}
+	i, err := strconv.ParseInt(string(token), 10, 64)
+	if err != nil {
+		return 0, errSlowPath
+	}
+	return i, nil
+}
+
+func (d *jsonDecoder) payload(p *Payload) error {
+	return d.object(func(key []byte) error {
+		var err error
+		switch string(key) {
+		case "driver_id":
+			var id int64
+			id, err = d.int()
+			p.DriverID = int(id)
+			if int64(p.DriverID) != id {
+				return errSlowPath
+			}
+		case "timestamp":
+			var ts int64
+			if ts, err = d.int(); err == nil {
+				p.Timestamp = &ts
+			}
+		case "ttl":
+			p.TTL, err = d.int()
+		case "status":
+			var s []byte
+			if s, err = d.str(); err == nil {
+				p.Status = storage.Status(s)
+			}
+		case "location":
+			err = d.object(func(key []byte) error {
+				var err error
+				switch string(key) {
+				case "lat":
+					p.Location.Latitude, err = d.float()
+				case "lon":
+					p.Location.Longitude, err = d.float()
+				default:
+					err = errSlowPath
+				}
+				return err
+			})
+		case "attributes":
+			// encoding/json merges repeated objects into the map
+			if p.Attributes != nil {
+				return errSlowPath
+			}
+			p.Attributes = make(map[string]string)
+			err = d.object(func(key []byte) error {
+				v, err := d.str()
+				p.Attributes[string(key)] = string(v)
+				return err
+			})
+		default:
+			// unknown and differently cased keys are left to encoding/json
+			err = errSlowPath
+		}
+		return err
+	})
+}
+
+func (d *jsonDecoder) payloads() ([]Payload, error) {
+	if !d.consume('[') {
+		return nil, errSlowPath
+	}
+	payloads := []Payload{}
+	if d.consume(']') {
+		return payloads, nil
+	}
+	for {
+		payloads = append(payloads, Payload{})
+		if err := d.payload(&payloads[len(payloads)-1]); err != nil {
+			return nil, err
+		}
+		if d.consume(']') {
+			return payloads, nil
+		}
+		if !d.consume(',') {
+			return nil, errSlowPath
+		}
+	}
+}
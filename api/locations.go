@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// mimeNDJSON is the content type of newline delimited JSON payloads
+const mimeNDJSON = "application/x-ndjson"
+
+// maxLocationsBatch limits number of payloads in a batch of locations
+const maxLocationsBatch = 10000
+
+var (
+	// ErrInvalidBatch sign what body is neither a JSON array of payloads nor NDJSON of them
+	ErrInvalidBatch = errors.New("Send a JSON array of payloads or application/x-ndjson with a payload per line")
+	// ErrBatchTooLarge sign what batch has more than maxLocationsBatch payloads
+	ErrBatchTooLarge = errors.New("Batch has more than 10000 locations")
+)
+
+// updateLocations sets locations of a JSON array or newline delimited JSON payloads.
+// NDJSON is decoded while it's uploaded. Payloads are validated together and
+// applied in one storage batch, stale, throttled and impossible locations are skipped.
+func (a *API) updateLocations(c echo.Context) error {
+	payloads, err := decodePayloads(c.Request())
+	if err != nil {
+		return fail(c, err)
+	}
+
+	var errs fieldErrors
+	for i := range payloads {
+		errs.payload(strconv.Itoa(i)+".", &payloads[i])
+	}
+	if errs != nil {
+		return invalid(c, errs)
+	}
+	drivers := make([]*storage.Driver, len(payloads))
+	for i := range payloads {
+		if !ownDriver(c, payloads[i].DriverID) {
+			return forbidDriver(c, payloads[i].DriverID)
+		}
+		drivers[i] = payloads[i].Driver()
+	}
+
+	if err := database(c).SetMany(drivers); err != nil {
+		return fail(c, err)
+	}
+	return c.JSON(http.StatusOK, &DefaultResponse{
+		Success: true,
+		Message: "Applied " + strconv.Itoa(len(drivers)) + " locations",
+	})
+}
+
+// decodePayloads decodes NDJSON or a JSON array of payloads
+func decodePayloads(r *http.Request) ([]Payload, error) {
+	if !strings.HasPrefix(r.Header.Get(echo.HeaderContentType), mimeNDJSON) {
+		buf := jsonBuffers.Get().(*bytes.Buffer)
+		defer jsonBuffers.Put(buf)
+		buf.Reset()
+		if _, err := buf.ReadFrom(r.Body); err != nil {
+			return nil, ErrInvalidBatch
+		}
+		payloads, err := decodePayloadArray(buf.Bytes())
+		if err != nil {
+			return nil, ErrInvalidBatch
+		}
+		if len(payloads) > maxLocationsBatch {
+			return nil, ErrBatchTooLarge
+		}
+		return payloads, nil
+	}
+
+	dec := json.NewDecoder(r.Body)
+	var payloads []Payload
+	for {
+		var p Payload
+		err := dec.Decode(&p)
+		if err == io.EOF {
+			return payloads, nil
+		}
+		if err != nil {
+			return nil, ErrInvalidBatch
+		}
+		if len(payloads) == maxLocationsBatch {
+			return nil, ErrBatchTooLarge
+		}
+		payloads = append(payloads, p)
+	}
+}
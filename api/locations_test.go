@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateLocations(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}, Timestamp: 5}))
+	a := New(":0", storage.NewManager(db, nil), nil)
+	post := func(contentType, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/v2/drivers/locations", strings.NewReader(body))
+		r.Header.Set(echo.HeaderContentType, contentType)
+		w := httptest.NewRecorder()
+		a.echo.ServeHTTP(w, r)
+		return w
+	}
+	errorResponse := func(w *httptest.ResponseRecorder) ErrorResponse {
+		var e ErrorResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+		return e
+	}
+
+	// stale locations are skipped
+	w := post(echo.MIMEApplicationJSON, `[
+		{"driver_id": 1, "location": {"lat": 2, "lon": 2}, "timestamp": 3},
+		{"driver_id": 2, "location": {"lat": 2, "lon": 2}, "timestamp": 3},
+		{"driver_id": 2, "location": {"lat": 3, "lon": 3}, "timestamp": 4}
+	]`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"success": true, "message": "Applied 3 locations"}`, w.Body.String())
+	d, err := db.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Location{Lat: 1, Lon: 1}, d.LastLocation)
+	d, err = db.Get(2)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Location{Lat: 3, Lon: 3}, d.LastLocation)
+
+	w = post(mimeNDJSON, `{"driver_id": 3, "location": {"lat": 4, "lon": 4}}
+{"driver_id": 1, "location": {"lat": 5, "lon": 5}, "timestamp": 6}
+`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"success": true, "message": "Applied 2 locations"}`, w.Body.String())
+	assert.Equal(t, 3, db.Len())
+	d, err = db.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Location{Lat: 5, Lon: 5}, d.LastLocation)
+
+	// nothing is applied if a payload is invalid
+	w = post(echo.MIMEApplicationJSON, `[
+		{"driver_id": 4, "location": {"lat": 1, "lon": 1}},
+		{"driver_id": 5, "location": {"lat": 91, "lon": 1}}
+	]`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	e := errorResponse(w)
+	assert.Equal(t, CodeInvalidCoordinates, e.Code)
+	assert.Equal(t, []FieldError{{Field: "1.location.lat", Message: "must be in [-90, 90]"}}, e.Details)
+	assert.Equal(t, 3, db.Len())
+
+	for contentType, body := range map[string]string{
+		echo.MIMEApplicationJSON: `{"driver_id": 4}`,
+		mimeNDJSON:               `{"driver_id": 4} [`,
+	} {
+		w = post(contentType, body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, contentType)
+		assert.Equal(t, ErrInvalidBatch.Error(), errorResponse(w).Message, contentType)
+	}
+
+	line := `{"driver_id": 4, "location": {"lat": 1, "lon": 1}}` + "\n"
+	w = post(mimeNDJSON, strings.Repeat(line, maxLocationsBatch+1))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrBatchTooLarge.Error(), errorResponse(w).Message)
+	w = post(echo.MIMEApplicationJSON, "["+strings.Repeat(line+",", maxLocationsBatch)+line+"]")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ErrBatchTooLarge.Error(), errorResponse(w).Message)
+	assert.Equal(t, 3, db.Len())
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/labstack/echo"
+	"go.uber.org/zap"
+)
+
+// loggerKey is a context key of the logger of the request
+const loggerKey = "logger"
+
+// maxRequestIDLength limits request ids set by clients
+const maxRequestIDLength = 64
+
+// WithLogger logs requests and errors to the logger instead of the global one
+func WithLogger(logger *zap.Logger) Option {
+	return func(a *API) {
+		a.logger = logger
+	}
+}
+
+// logRequests is a middleware logging method, path, status and latency of requests.
+// X-Request-ID of the request is kept or generated, returned in the response and logged.
+func (a *API) logRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		req := c.Request()
+		id := req.Header.Get(echo.HeaderXRequestID)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = requestID()
+		}
+		c.Response().Header().Set(echo.HeaderXRequestID, id)
+		logger := a.logger.With(zap.String("request_id", id))
+		c.Set(loggerKey, logger)
+
+		// errors are handled here so the log gets their status
+		if err := next(c); err != nil {
+			logger.Warn("request failed", zap.Error(err))
+			c.Error(err)
+		}
+		logger.Info("request",
+			zap.String("method", req.Method),
+			zap.String("path", req.URL.Path),
+			zap.String("route", c.Path()),
+			zap.Int("status", c.Response().Status),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("remote_ip", c.RealIP()),
+		)
+		return nil
+	}
+}
+
+// requestLogger returns logger of the request with its id
+func requestLogger(c echo.Context) *zap.Logger {
+	if logger, ok := c.Get(loggerKey).(*zap.Logger); ok {
+		return logger
+	}
+	return zap.L()
+}
+
+func requestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogRequests(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	a := New(":0", storage.NewManager(db, nil), nil, WithLogger(zap.New(core)))
+	request := func(path, id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if id != "" {
+			r.Header.Set(echo.HeaderXRequestID, id)
+		}
+		w := httptest.NewRecorder()
+		a.echo.ServeHTTP(w, r)
+		return w
+	}
+
+	// ids of clients are kept
+	w := request("/v2/driver/1", "client-id")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "client-id", w.Header().Get(echo.HeaderXRequestID))
+	entries := logs.TakeAll()
+	if assert.Len(t, entries, 1) {
+		fields := entries[0].ContextMap()
+		assert.Equal(t, "request", entries[0].Message)
+		assert.Equal(t, "client-id", fields["request_id"])
+		assert.Equal(t, http.MethodGet, fields["method"])
+		assert.Equal(t, "/v2/driver/1", fields["path"])
+		assert.Equal(t, "/v2/driver/:id", fields["route"])
+		assert.EqualValues(t, http.StatusOK, fields["status"])
+		assert.Contains(t, fields, "latency")
+	}
+
+	// invalid requests are logged with their status, the error response has the id too
+	w = request("/v2/driver/91/1/nearest", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	id := w.Header().Get(echo.HeaderXRequestID)
+	assert.Len(t, id, 32)
+	var e ErrorResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+	assert.Equal(t, id, e.RequestID)
+	entries = logs.TakeAll()
+	if assert.Len(t, entries, 1) {
+		fields := entries[0].ContextMap()
+		assert.Equal(t, id, fields["request_id"])
+		assert.EqualValues(t, http.StatusBadRequest, fields["status"])
+	}
+
+	// too long ids are replaced
+	w = request("/v2/driver/1", strings.Repeat("x", maxRequestIDLength+1))
+	assert.Len(t, w.Header().Get(echo.HeaderXRequestID), 32)
+	assert.Len(t, logs.TakeAll(), 1)
+}
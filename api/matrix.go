@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+)
+
+// etaMatrix returns distances and ETAs of count nearest drivers to the pickup in one call,
+// they are road travel times if routing is requested and the router is set
+func (a *API) etaMatrix(c echo.Context) error {
+	p := &ETAMatrixPayload{}
+	if err := c.Bind(p); err != nil {
+		return failWith(c, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Set content-type application/json or check your payload data")
+	}
+	var errs fieldErrors
+	if errs.location("pickup", p.Pickup); p.Count < 0 {
+		errs.add("count", "must be a positive integer")
+	}
+	if errs != nil {
+		return invalid(c, errs)
+	}
+	if p.Routing && a.router == nil {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "routing is not configured")
+	}
+	if p.Count == 0 {
+		p.Count = defaultNearestCount
+	}
+
+	var filters []storage.Filter
+	if !p.IncludeUnavailable {
+		filters = append(filters, storage.Available())
+	}
+	if len(p.Attributes) > 0 {
+		filters = append(filters, storage.AttributesFilter(p.Attributes))
+	}
+	pickup := storage.Location{Lat: p.Pickup.Latitude, Lon: p.Pickup.Longitude}
+	drivers := a.findNearest(c, pickup, p.Count, filters)
+
+	var entries []*NearestDriver
+	if p.Routing {
+		entries = a.byTravelTime(c, pickup, drivers)
+	} else {
+		entries = a.nearest(pickup, drivers)
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].ETASeconds < entries[j].ETASeconds
+		})
+	}
+	return c.JSON(http.StatusOK, &NearestDriverResponse{
+		Success: true,
+		Message: "found",
+		Drivers: entries,
+	})
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// ErrHistoryStatsUnsupported sign what storage of the namespace doesn't describe histories of drivers
+var ErrHistoryStatsUnsupported = errors.New("Storage does not describe histories of drivers")
+
+// historyStater is a storage describing histories of drivers
+type historyStater interface {
+	HistoryStats(id int) (storage.HistoryStats, error)
+}
+
+// WithMetrics serves stats of storages of all namespaces to Prometheus at /metrics
+func WithMetrics() Option {
+	return func(a *API) {
+		a.metrics = true
+	}
+}
+
+func (a *API) driverHistoryStats(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "could not convert string to integer")
+	}
+
+	// the storage is resolved again as the tracing wrapper doesn't describe histories
+	s, err := a.namespaces.Namespace(namespaceName(c))
+	if err != nil {
+		return fail(c, err)
+	}
+	stater, ok := s.(historyStater)
+	if !ok {
+		return fail(c, ErrHistoryStatsUnsupported)
+	}
+	stats, err := stater.HistoryStats(id)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &HistoryStatsResponse{
+		Success: true,
+		Message: "found",
+		History: stats,
+	})
+}
+
+// metricsHandler serves metrics of the collector of storage stats in the Prometheus format
+func (a *API) metricsHandler() echo.HandlerFunc {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newStatsCollector(a.namespaces))
+	return echo.WrapHandler(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+}
+
+// statsCollector reads stats of storages of all namespaces on every scrape,
+// the default namespace has an empty namespace label
+type statsCollector struct {
+	namespaces       *storage.Manager
+	drivers          *prometheus.Desc
+	historyLocations *prometheus.Desc
+	historyCapacity  *prometheus.Desc
+	historyEvictions *prometheus.Desc
+	historyOldestAge *prometheus.Desc
+}
+
+func newStatsCollector(namespaces *storage.Manager) *statsCollector {
+	labels := []string{"namespace"}
+	return &statsCollector{
+		namespaces:       namespaces,
+		drivers:          prometheus.NewDesc("nearestdots_drivers", "Number of drivers in the storage", labels, nil),
+		historyLocations: prometheus.NewDesc("nearestdots_history_locations", "Number of locations kept in histories of drivers", labels, nil),
+		historyCapacity:  prometheus.NewDesc("nearestdots_history_capacity", "Number of locations histories of drivers fit", labels, nil),
+		historyEvictions: prometheus.NewDesc("nearestdots_history_evictions_total", "Locations dropped or downsampled from histories of drivers", labels, nil),
+		historyOldestAge: prometheus.NewDesc("nearestdots_history_oldest_age_seconds", "Age of the oldest location kept in histories of drivers", labels, nil),
+	}
+}
+
+func (s *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.drivers
+	ch <- s.historyLocations
+	ch <- s.historyCapacity
+	ch <- s.historyEvictions
+	ch <- s.historyOldestAge
+}
+
+func (s *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, name := range append([]string{""}, s.namespaces.Namespaces()...) {
+		db, err := s.namespaces.Namespace(name)
+		if err != nil {
+			zap.L().Warn("could not collect namespace stats", zap.String("namespace", name), zap.Error(err))
+			continue
+		}
+		st := db.Stats()
+		ch <- prometheus.MustNewConstMetric(s.drivers, prometheus.GaugeValue, float64(st.Drivers), name)
+		ch <- prometheus.MustNewConstMetric(s.historyLocations, prometheus.GaugeValue, float64(st.History.Locations), name)
+		ch <- prometheus.MustNewConstMetric(s.historyCapacity, prometheus.GaugeValue, float64(st.History.Capacity), name)
+		ch <- prometheus.MustNewConstMetric(s.historyEvictions, prometheus.CounterValue, float64(st.History.Evictions), name)
+		ch <- prometheus.MustNewConstMetric(s.historyOldestAge, prometheus.GaugeValue, st.History.OldestAge, name)
+	}
+}
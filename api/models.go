@@ -1,6 +1,16 @@
 package api
 
-import "github.com/kdrake/nearestdots/storage"
+import (
+	"errors"
+	"time"
+
+	"github.com/kdrake/nearestdots/cluster"
+	"github.com/kdrake/nearestdots/geofence"
+	"github.com/kdrake/nearestdots/ingest"
+	"github.com/kdrake/nearestdots/orders"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/kdrake/nearestdots/webhook"
+)
 
 type (
 	Location struct {
@@ -8,22 +18,307 @@ type (
 		Longitude float64 `json:"lon"`
 	}
 	Payload struct {
-		Timestamp int64    `json:"timestamp"`
+		// Timestamp in unix nanoseconds is when the location was taken, now if not set
+		Timestamp *int64   `json:"timestamp,omitempty"`
 		DriverID  int      `json:"driver_id"`
 		Location  Location `json:"location"`
+		// TTL in seconds overrides the default driver expiration
+		TTL int64 `json:"ttl"`
+		// Attributes replace driver's attributes if set
+		Attributes map[string]string `json:"attributes"`
+		// Status changes driver's status if set
+		Status storage.Status `json:"status"`
+	}
+	StatusPayload struct {
+		Status storage.Status `json:"status"`
+	}
+	// AttributesPayload replaces all attributes of the driver
+	AttributesPayload struct {
+		Attributes map[string]string `json:"attributes"`
+	}
+	// ReservePayload selects drivers to reserve near the location, TTL is in seconds
+	ReservePayload struct {
+		Location   Location          `json:"location"`
+		Count      int               `json:"count"`
+		TTL        int64             `json:"ttl"`
+		Attributes map[string]string `json:"attributes"`
+	}
+	// ETAMatrixPayload selects count nearest drivers to the pickup, only available ones unless IncludeUnavailable.
+	// ETAs are road travel times if Routing is set.
+	ETAMatrixPayload struct {
+		Pickup             Location          `json:"pickup"`
+		Count              int               `json:"count"`
+		IncludeUnavailable bool              `json:"include_unavailable"`
+		Attributes         map[string]string `json:"attributes"`
+		Routing            bool              `json:"routing"`
+	}
+	// RoutePayload selects count drivers nearest to the route encoded as a polyline of precision 5,
+	// only available ones unless IncludeUnavailable
+	RoutePayload struct {
+		Polyline           string            `json:"polyline"`
+		Count              int               `json:"count"`
+		IncludeUnavailable bool              `json:"include_unavailable"`
+		Attributes         map[string]string `json:"attributes"`
+	}
+	OrderPayload struct {
+		Pickup     Location          `json:"pickup"`
+		Attributes map[string]string `json:"attributes"`
+	}
+	BatchPayload struct {
+		Set    []Payload `json:"set"`
+		Delete []int     `json:"delete"`
+	}
+	// GeoJSON is a Polygon geometry or a Feature with Polygon geometry,
+	// positions are [lon, lat]
+	GeoJSON struct {
+		Type        string        `json:"type"`
+		Coordinates [][][]float64 `json:"coordinates"`
+		Geometry    *GeoJSON      `json:"geometry"`
+	}
+	// FencePayload is a named GeoJSON polygon or a circle with radius in meters
+	FencePayload struct {
+		Name    string    `json:"name"`
+		Polygon *GeoJSON  `json:"polygon"`
+		Center  *Location `json:"center"`
+		Radius  float64   `json:"radius"`
+	}
+	// WebhookPayload registers the URL for callbacks of the event types, all types if Events is empty
+	WebhookPayload struct {
+		URL    string   `json:"url"`
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
 	}
 	DefaultResponse struct {
 		Success bool   `json:"success"`
 		Message string `json:"message"`
 	}
+	// FieldError tells why a field of the request is invalid, nested fields are dot separated
+	FieldError struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	}
+	// ErrorResponse is the body of failed requests, Code is one of Code constants
+	// and Details has invalid fields of the request
+	ErrorResponse struct {
+		Success   bool         `json:"success"`
+		Code      string       `json:"code"`
+		Message   string       `json:"message"`
+		Details   []FieldError `json:"details,omitempty"`
+		RequestID string       `json:"request_id,omitempty"`
+	}
 	DriverResponse struct {
 		Success bool            `json:"success"`
 		Message string          `json:"message"`
 		Driver  *storage.Driver `json:"driver"`
 	}
-	NearestDriverResponse struct {
+	DriversResponse struct {
 		Success bool              `json:"success"`
 		Message string            `json:"message"`
 		Drivers []*storage.Driver `json:"drivers"`
 	}
+	// ListResponse is a page of drivers, Next is the cursor of the next page if there may be one
+	ListResponse struct {
+		Success bool              `json:"success"`
+		Message string            `json:"message"`
+		Drivers []*storage.Driver `json:"drivers"`
+		Next    *int              `json:"next,omitempty"`
+	}
+	// NearestDriver is a driver with great-circle distance in meters to the requested point
+	// and estimated time to reach it
+	NearestDriver struct {
+		*storage.Driver
+		Distance   float64 `json:"distance"`
+		ETASeconds float64 `json:"eta_seconds"`
+		// AgeSeconds is time since the last location, set in verbose responses
+		AgeSeconds *float64 `json:"age_seconds,omitempty"`
+		// Score is set if drivers are ranked by score
+		Score *float64 `json:"score,omitempty"`
+	}
+	ShiftsResponse struct {
+		Success bool            `json:"success"`
+		Message string          `json:"message"`
+		Shifts  []storage.Shift `json:"shifts"`
+	}
+	// IdleDriver is a driver with time it has been available and standing
+	IdleDriver struct {
+		*storage.Driver
+		IdleSeconds float64 `json:"idle_seconds"`
+	}
+	FlaggedResponse struct {
+		Success bool              `json:"success"`
+		Message string            `json:"message"`
+		Drivers []*storage.Driver `json:"drivers"`
+	}
+	IdleResponse struct {
+		Success bool          `json:"success"`
+		Message string        `json:"message"`
+		Drivers []*IdleDriver `json:"drivers"`
+	}
+	NearestDriverResponse struct {
+		Success bool             `json:"success"`
+		Message string           `json:"message"`
+		Drivers []*NearestDriver `json:"drivers"`
+	}
+	// TrackFeature is a GeoJSON Feature of the driver track, a LineString of [lon, lat] positions.
+	// Properties have the driver id and times of positions in unix nanoseconds.
+	TrackFeature struct {
+		Type       string          `json:"type"`
+		Geometry   TrackGeometry   `json:"geometry"`
+		Properties TrackProperties `json:"properties"`
+	}
+	TrackGeometry struct {
+		Type        string       `json:"type"`
+		Coordinates [][2]float64 `json:"coordinates"`
+	}
+	TrackProperties struct {
+		DriverID   int     `json:"driver_id"`
+		Timestamps []int64 `json:"timestamps"`
+	}
+	HistoryResponse struct {
+		Success   bool                   `json:"success"`
+		Message   string                 `json:"message"`
+		Locations []storage.HistoryPoint `json:"locations"`
+	}
+	FencesResponse struct {
+		Success bool             `json:"success"`
+		Message string           `json:"message"`
+		Fences  []geofence.Fence `json:"fences"`
+	}
+	EventsResponse struct {
+		Success bool             `json:"success"`
+		Message string           `json:"message"`
+		Events  []geofence.Event `json:"events"`
+	}
+	WebhookResponse struct {
+		Success bool              `json:"success"`
+		Message string            `json:"message"`
+		Webhook *webhook.Endpoint `json:"webhook"`
+	}
+	WebhooksResponse struct {
+		Success  bool               `json:"success"`
+		Message  string             `json:"message"`
+		Webhooks []webhook.Endpoint `json:"webhooks"`
+	}
+	MembersResponse struct {
+		Success bool             `json:"success"`
+		Message string           `json:"message"`
+		Members []cluster.Member `json:"members"`
+	}
+	DeadLettersResponse struct {
+		Success     bool                 `json:"success"`
+		Message     string               `json:"message"`
+		DeadLetters []webhook.DeadLetter `json:"dead_letters"`
+	}
+	ClustersResponse struct {
+		Success  bool              `json:"success"`
+		Message  string            `json:"message"`
+		Clusters []storage.Cluster `json:"clusters"`
+	}
+	HeatmapResponse struct {
+		Success bool                  `json:"success"`
+		Message string                `json:"message"`
+		Cells   []storage.HeatmapCell `json:"cells"`
+	}
+	H3Response struct {
+		Success    bool             `json:"success"`
+		Message    string           `json:"message"`
+		Resolution int              `json:"resolution"`
+		Cells      []storage.H3Cell `json:"cells"`
+	}
+	// DriverH3Response has H3 indexes of the last location of the driver keyed by resolution
+	DriverH3Response struct {
+		Success bool           `json:"success"`
+		Message string         `json:"message"`
+		Cells   map[int]string `json:"cells"`
+	}
+	ZonesResponse struct {
+		Success       bool           `json:"success"`
+		Message       string         `json:"message"`
+		WindowSeconds float64        `json:"window_seconds"`
+		Zones         []storage.Zone `json:"zones"`
+	}
+	// OrderResponse has Code of the error if the order is not assigned or changed
+	OrderResponse struct {
+		Success bool          `json:"success"`
+		Code    string        `json:"code,omitempty"`
+		Message string        `json:"message"`
+		Order   *orders.Order `json:"order"`
+	}
+	// HistoryStatsResponse describes the history of a driver
+	HistoryStatsResponse struct {
+		Success bool                 `json:"success"`
+		Message string               `json:"message"`
+		History storage.HistoryStats `json:"history"`
+	}
+	StatsResponse struct {
+		Success bool             `json:"success"`
+		Message string           `json:"message"`
+		Stats   storage.Stats    `json:"stats"`
+		UDP     *ingest.UDPStats `json:"udp,omitempty"`
+	}
 )
+
+// Driver converts payload to storage driver
+func (p *Payload) Driver() *storage.Driver {
+	driver := &storage.Driver{}
+	driver.ID = p.DriverID
+	if p.Timestamp != nil {
+		driver.Timestamp = *p.Timestamp
+	}
+	driver.LastLocation = storage.Location{
+		Lat: p.Location.Latitude,
+		Lon: p.Location.Longitude,
+	}
+	driver.Attributes = p.Attributes
+	driver.Status = p.Status
+	if p.TTL > 0 {
+		driver.Expiration = time.Now().Add(time.Duration(p.TTL) * time.Second).UnixNano()
+	}
+	return driver
+}
+
+// Fence converts payload to geofence
+func (p *FencePayload) Fence() (geofence.Fence, error) {
+	fence := geofence.Fence{Name: p.Name, Radius: p.Radius}
+	if p.Center != nil {
+		fence.Center = &storage.Location{Lat: p.Center.Latitude, Lon: p.Center.Longitude}
+		if !fence.Center.Valid() {
+			return fence, storage.ErrInvalidLocation
+		}
+	}
+	if p.Polygon != nil {
+		polygon, err := p.Polygon.Polygon()
+		if err != nil {
+			return fence, err
+		}
+		fence.Polygon = polygon
+	}
+	return fence, nil
+}
+
+// Polygon converts GeoJSON to storage polygon
+func (g *GeoJSON) Polygon() (storage.Polygon, error) {
+	if g.Type == "Feature" && g.Geometry != nil {
+		return g.Geometry.Polygon()
+	}
+	if g.Type != "Polygon" {
+		return nil, errors.New("geometry type must be Polygon")
+	}
+
+	polygon := make(storage.Polygon, 0, len(g.Coordinates))
+	for _, ring := range g.Coordinates {
+		r := make([]storage.Location, 0, len(ring))
+		for _, pos := range ring {
+			if len(pos) < 2 {
+				return nil, errors.New("position must have longitude and latitude")
+			}
+			l := storage.Location{Lat: pos[1], Lon: pos[0]}
+			if !l.Valid() {
+				return nil, storage.ErrInvalidLocation
+			}
+			r = append(r, l)
+		}
+		polygon = append(polygon, r)
+	}
+	return polygon, nil
+}
@@ -0,0 +1,313 @@
+package api
+
+import (
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kdrake/nearestdots/graph"
+	"github.com/labstack/echo"
+)
+
+// operation documents a handler, the spec gets paths of all its routes.
+// Query maps query parameters to their types, nil request and response are omitted.
+// Idempotent operations take the Idempotency-Key header, conditional ones take the If-Match header.
+type operation struct {
+	summary     string
+	query       map[string]string
+	request     interface{}
+	response    interface{}
+	contentType string
+	namespaced  bool
+	idempotent  bool
+	conditional bool
+}
+
+var boundingBoxQuery = map[string]string{
+	"min_lat": "number", "min_lon": "number", "max_lat": "number", "max_lon": "number",
+}
+
+// operations are keyed by handler method names
+var operations = map[string]operation{
+	"addDriver":           {summary: "Add or update driver location", request: Payload{}, response: DefaultResponse{}, namespaced: true, idempotent: true},
+	"batchDrivers":        {summary: "Set and delete drivers in one request", request: BatchPayload{}, response: DefaultResponse{}, namespaced: true, idempotent: true},
+	"updateLocations":     {summary: "Set locations of a JSON array or NDJSON of payloads in one batch", request: []Payload{}, response: DefaultResponse{}, namespaced: true, idempotent: true},
+	"getDriver":           {summary: "Get driver", response: DriverResponse{}, namespaced: true},
+	"listDrivers":         {summary: "List drivers ordered by id, cursor is next of the previous page", query: map[string]string{"cursor": "integer", "after": "integer", "limit": "integer"}, response: ListResponse{}, namespaced: true},
+	"idleDrivers":         {summary: "List available drivers standing for the longest time, longest first", query: map[string]string{"limit": "integer"}, response: IdleResponse{}, namespaced: true},
+	"flaggedDrivers":      {summary: "List drivers flagged for impossible speed, most flagged first", query: map[string]string{"limit": "integer"}, response: FlaggedResponse{}, namespaced: true},
+	"deleteDriver":        {summary: "Delete driver", response: DefaultResponse{}, namespaced: true},
+	"setDriverStatus":     {summary: "Change driver status, only if the driver is of the version of If-Match if it's set", request: StatusPayload{}, response: DefaultResponse{}, namespaced: true, conditional: true},
+	"setDriverAttributes": {summary: "Replace driver attributes, only if the driver is of the version of If-Match if it's set", request: AttributesPayload{}, response: DefaultResponse{}, namespaced: true, conditional: true},
+	"driverTrackGPX":      {summary: "Export driver location history as a GPX track", query: map[string]string{"from": "integer", "to": "integer"}, contentType: mimeGPX, namespaced: true},
+	"driverTrackGeoJSON":  {summary: "Export driver location history as a GeoJSON LineString feature", query: map[string]string{"from": "integer", "to": "integer"}, response: TrackFeature{}, namespaced: true},
+	"driverLocations":     {summary: "Get driver location history", query: map[string]string{"from": "integer", "to": "integer"}, response: HistoryResponse{}, namespaced: true},
+	"startShift":          {summary: "Start shift of driver, drivers start a shift with their first location", response: DefaultResponse{}, namespaced: true},
+	"stopShift":           {summary: "Stop shift of driver, it keeps its location but is not available until the next shift", response: DefaultResponse{}, namespaced: true},
+	"driverShifts":        {summary: "Get latest shifts of driver", response: ShiftsResponse{}, namespaced: true},
+	"playback":            {summary: "Get drivers of the default namespace as they were at a past time, unix nanoseconds or RFC 3339", query: map[string]string{"at": "string"}, response: DriversResponse{}, namespaced: true},
+	"nearestDrivers":      {summary: "Find nearest drivers, verbose and v2 responses have age of locations. Weights of distance, rating, idle and heading rank them by score. At plays back drivers as they were at a past time", query: map[string]string{"count": "integer", "include_unavailable": "boolean", "attr": "string", "exclude_ids": "string", "only_ids": "string", "verbose": "boolean", "weights": "string", "at": "string"}, response: NearestDriverResponse{}, namespaced: true},
+	"reserveDrivers":      {summary: "Reserve nearest available drivers", request: ReservePayload{}, response: NearestDriverResponse{}, namespaced: true},
+	"routeDrivers":        {summary: "Get drivers nearest to a route encoded as a polyline ordered by distance to it", request: RoutePayload{}, response: NearestDriverResponse{}, namespaced: true},
+	"etaMatrix":           {summary: "Get distances and ETAs of nearest drivers to a pickup ordered by ETA, road travel times if routing is set", request: ETAMatrixPayload{}, response: NearestDriverResponse{}, namespaced: true},
+	"boundingBoxDrivers":  {summary: "Find drivers in bounding box, it crosses the antimeridian if min_lon is greater than max_lon", query: boundingBoxQuery, response: DriversResponse{}, namespaced: true},
+	"clusterDrivers":      {summary: "Cluster drivers in bounding box", query: withQuery(boundingBoxQuery, "zoom", "integer"), response: ClustersResponse{}, namespaced: true},
+	"polygonDrivers":      {summary: "Find drivers in GeoJSON polygon", request: GeoJSON{}, response: DriversResponse{}, namespaced: true},
+	"stats":               {summary: "Get storage statistics", response: StatsResponse{}, namespaced: true},
+	"driverHistoryStats":  {summary: "Get size, capacity, evictions and the oldest location age of the driver history", response: HistoryStatsResponse{}, namespaced: true},
+	"heatmap":             {summary: "Count drivers per geohash cell", query: map[string]string{"precision": "integer"}, response: HeatmapResponse{}, namespaced: true},
+	"h3Cells":             {summary: "Count drivers and available drivers per H3 cell of a configured resolution, the first one by default", query: map[string]string{"resolution": "integer"}, response: H3Response{}, namespaced: true},
+	"driverH3":            {summary: "Get H3 indexes of the driver location at configured resolutions", response: DriverH3Response{}, namespaced: true},
+	"vectorTile":          {summary: "Get drivers of a web mercator tile as a Mapbox vector tile with the drivers layer, y has the .mvt suffix", contentType: mimeVectorTile, namespaced: true},
+	"zones":               {summary: "Get supply, demand of nearest queries within the window and their ratio per geohash cell", query: map[string]string{"precision": "integer"}, response: ZonesResponse{}, namespaced: true},
+	"createOrder":         {summary: "Create order and assign nearest driver", request: OrderPayload{}, response: OrderResponse{}, namespaced: true},
+	"getOrder":            {summary: "Get order", response: OrderResponse{}, namespaced: true},
+	"assignOrder":         {summary: "Retry assignment of unassigned order", response: OrderResponse{}, namespaced: true},
+	"completeOrder":       {summary: "Complete order", response: OrderResponse{}, namespaced: true},
+	"cancelOrder":         {summary: "Cancel order", response: OrderResponse{}, namespaced: true},
+	"addFence":            {summary: "Add or replace geofence", request: FencePayload{}, response: DefaultResponse{}},
+	"listFences":          {summary: "List geofences", response: FencesResponse{}},
+	"removeFence":         {summary: "Remove geofence", response: DefaultResponse{}},
+	"fenceEvents":         {summary: "List geofence events", query: map[string]string{"after": "integer", "limit": "integer"}, response: EventsResponse{}},
+	"addWebhook":          {summary: "Register webhook endpoint of driver lifecycle events", request: WebhookPayload{}, response: WebhookResponse{}},
+	"listWebhooks":        {summary: "List webhook endpoints", response: WebhooksResponse{}},
+	"removeWebhook":       {summary: "Remove webhook endpoint", response: DefaultResponse{}},
+	"deadLetters":         {summary: "List webhook events not delivered after all attempts", query: map[string]string{"after": "integer", "limit": "integer"}, response: DeadLettersResponse{}},
+	"streamUpdates":       {summary: "Stream driver location updates over WebSocket, the box crosses the antimeridian if min_lon is greater than max_lon", query: withQuery(boundingBoxQuery, "ids", "string")},
+	"nearestEvents":       {summary: "Stream nearest drivers as server-sent events", query: map[string]string{"count": "integer", "include_unavailable": "boolean", "attr": "string", "exclude_ids": "string", "only_ids": "string"}, contentType: "text/event-stream"},
+	"clusterMembers":      {summary: "List cluster nodes with their health and number of drivers", response: MembersResponse{}},
+	"replicationChanges":  {summary: "Stream gob batches of changes of the default namespace to replicas, a snapshot first if there is no position", query: map[string]string{"epoch": "integer", "after": "integer"}, contentType: mimeSnapshot},
+	"graphQL":             {summary: "Execute GraphQL query", request: graph.Request{}, response: map[string]interface{}{}, namespaced: true},
+	"health":              {summary: "Check storage is initialized and janitor is running", response: DefaultResponse{}},
+	"ready":               {summary: "Check server is healthy, backends are reachable and it's not shutting down", response: DefaultResponse{}},
+	"openAPI":             {summary: "Get OpenAPI specification", response: map[string]interface{}{}},
+	"getSnapshot":         {summary: "Download snapshot of drivers in the format of snapshot files", contentType: mimeSnapshot, namespaced: true},
+	"restoreSnapshot":     {summary: "Replace drivers by the uploaded snapshot, they are persisted by the next snapshot", response: DefaultResponse{}, namespaced: true},
+}
+
+var (
+	handlerMethod = regexp.MustCompile(`\.([A-Za-z0-9_]+)-fm$`)
+	pathParam     = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+	versionPrefix = regexp.MustCompile(`^/v([0-9]+)/`)
+)
+
+func withQuery(query map[string]string, name, typ string) map[string]string {
+	q := map[string]string{name: typ}
+	for k, v := range query {
+		q[k] = v
+	}
+	return q
+}
+
+func (a *API) openAPI(c echo.Context) error {
+	return c.JSON(http.StatusOK, a.spec)
+}
+
+// swaggerUI serves Swagger UI of /openapi.json
+func (a *API) swaggerUI(c echo.Context) error {
+	return c.HTML(http.StatusOK, swaggerPage)
+}
+
+const swaggerPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>nearestdots API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>`
+
+// openAPISpec generates OpenAPI 3 specification of registered routes
+func (a *API) openAPISpec() map[string]interface{} {
+	schemas := schemas{}
+	paths := map[string]map[string]interface{}{}
+	for _, r := range a.echo.Routes() {
+		m := handlerMethod.FindStringSubmatch(r.Name)
+		if m == nil {
+			continue
+		}
+		op, ok := operations[m[1]]
+		if !ok {
+			continue
+		}
+
+		p := r.Path
+		id := m[1]
+		if strings.Contains(p, ":namespace") {
+			id += "InNamespace"
+		}
+		// operations of versioned routes are suffixed by the version, /api ones are not
+		if v := versionPrefix.FindStringSubmatch(p); v != nil {
+			id += "V" + v[1]
+		}
+		var params []interface{}
+		for _, name := range pathParam.FindAllStringSubmatch(p, -1) {
+			params = append(params, parameter(name[1], "path", "string", true))
+		}
+		names := make([]string, 0, len(op.query))
+		for name := range op.query {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			params = append(params, parameter(name, "query", op.query[name], false))
+		}
+		if op.namespaced && !strings.Contains(p, ":namespace") {
+			params = append(params, parameter(namespaceHeader, "header", "string", false))
+		}
+		if op.idempotent {
+			params = append(params, parameter(idempotencyHeader, "header", "string", false))
+		}
+		if op.conditional {
+			params = append(params, parameter(ifMatchHeader, "header", "string", false))
+		}
+
+		spec := map[string]interface{}{
+			"operationId": id,
+			"summary":     op.summary,
+			"responses": map[string]interface{}{
+				"200": schemas.response(op),
+				"default": map[string]interface{}{
+					"description": "Error",
+					"content":     content(echo.MIMEApplicationJSON, schemas.of(reflect.TypeOf(ErrorResponse{}))),
+				},
+			},
+		}
+		if len(params) > 0 {
+			spec["parameters"] = params
+		}
+		if op.request != nil {
+			spec["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  content(echo.MIMEApplicationJSON, schemas.of(reflect.TypeOf(op.request))),
+			}
+		}
+
+		p = pathParam.ReplaceAllString(p, "{$1}")
+		if paths[p] == nil {
+			paths[p] = map[string]interface{}{}
+		}
+		paths[p][strings.ToLower(r.Method)] = spec
+	}
+
+	spec := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "nearestdots",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+	var security []interface{}
+	if a.keys != nil {
+		security = append(security, map[string]interface{}{"apiKey": []string{}})
+	}
+	if a.jwtSecret != nil {
+		security = append(security, map[string]interface{}{"bearer": []string{}})
+	}
+	if security != nil {
+		spec["security"] = security
+	}
+	return spec
+}
+
+func parameter(name, in, typ string, required bool) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"in":       in,
+		"required": required,
+		"schema":   map[string]interface{}{"type": typ},
+	}
+}
+
+func content(contentType string, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{contentType: map[string]interface{}{"schema": schema}}
+}
+
+// schemas are named schemas of structs keyed by package and type name
+type schemas map[string]interface{}
+
+func (s schemas) response(op operation) map[string]interface{} {
+	resp := map[string]interface{}{"description": "OK"}
+	switch {
+	case op.contentType != "":
+		resp["content"] = content(op.contentType, map[string]interface{}{"type": "string"})
+	case op.response != nil:
+		resp["content"] = content(echo.MIMEApplicationJSON, s.of(reflect.TypeOf(op.response)))
+	}
+	return resp
+}
+
+// of returns schema of the type, structs are referenced
+func (s schemas) of(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.of(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		name := path.Base(t.PkgPath()) + "." + t.Name()
+		if _, ok := s[name]; !ok {
+			// placeholder stops recursion of self-referencing types
+			s[name] = nil
+			props := map[string]interface{}{}
+			s.properties(t, props)
+			s[name] = map[string]interface{}{"type": "object", "properties": props}
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// properties collects JSON fields of the struct including embedded ones
+func (s schemas) properties(t reflect.Type, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.properties(ft, props)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		props[tag] = s.of(f.Type)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kdrake/nearestdots/orders"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+)
+
+func (a *API) createOrder(c echo.Context) error {
+	p := &OrderPayload{}
+	if err := c.Bind(p); err != nil {
+		return failWith(c, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Set content-type application/json or check your payload data")
+	}
+
+	var errs fieldErrors
+	if errs.location("pickup", p.Pickup); errs != nil {
+		return invalid(c, errs)
+	}
+	pickup := storage.Location{Lat: p.Pickup.Latitude, Lon: p.Pickup.Longitude}
+	o, err := a.orders.Create(database(c), pickup, p.Attributes)
+	return orderResponse(c, o, err)
+}
+
+func (a *API) getOrder(c echo.Context) error {
+	return a.orderAction(c, a.orders.Get)
+}
+
+func (a *API) assignOrder(c echo.Context) error {
+	return a.orderAction(c, a.orders.Assign)
+}
+
+func (a *API) completeOrder(c echo.Context) error {
+	return a.orderAction(c, a.orders.Complete)
+}
+
+func (a *API) cancelOrder(c echo.Context) error {
+	return a.orderAction(c, a.orders.Cancel)
+}
+
+func (a *API) orderAction(c echo.Context, action func(id int64) (orders.Order, error)) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "could not convert string to integer")
+	}
+	o, err := action(id)
+	return orderResponse(c, o, err)
+}
+
+// orderResponse returns the order along with the error message,
+// an unassigned order is still created if there is no driver
+func orderResponse(c echo.Context, o orders.Order, err error) error {
+	switch err {
+	case nil:
+		return c.JSON(http.StatusOK, &OrderResponse{Success: true, Message: o.Status, Order: &o})
+	case orders.ErrNoDriverAvailable, orders.ErrInvalidTransition:
+		return c.JSON(http.StatusConflict, &OrderResponse{Success: false, Code: errorCodes[err].code, Message: err.Error(), Order: &o})
+	default:
+		return fail(c, err)
+	}
+}
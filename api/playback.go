@@ -0,0 +1,96 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kdrake/nearestdots/archive"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrPlaybackUnsupported sign what drivers of the namespace are not archived
+	ErrPlaybackUnsupported = errors.New("Drivers are archived in the default namespace only if the archive is enabled")
+	// ErrInvalidPlaybackTime sign what at is neither unix nanoseconds nor RFC 3339 time
+	ErrInvalidPlaybackTime = errors.New("at must be unix nanoseconds or RFC 3339 time")
+)
+
+// WithArchive plays back drivers of the default namespace at /playback and in nearest queries with at
+func WithArchive(ar *archive.Archive) Option {
+	return func(a *API) {
+		a.archive = ar
+	}
+}
+
+// playbackTime parses the at query parameter
+func playbackTime(c echo.Context) (time.Time, error) {
+	v := c.QueryParam("at")
+	if ns, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(0, ns), nil
+	}
+	at, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, ErrInvalidPlaybackTime
+	}
+	return at, nil
+}
+
+// fleetAt returns archived drivers of the namespace of the request at the time of the at query parameter
+func (a *API) fleetAt(c echo.Context) ([]*storage.Driver, error) {
+	if def, _ := a.namespaces.Namespace(""); a.archive == nil || database(c) != def {
+		return nil, ErrPlaybackUnsupported
+	}
+	at, err := playbackTime(c)
+	if err != nil {
+		return nil, err
+	}
+	return a.archive.At(at)
+}
+
+// playback returns drivers as they were at the time for incident analysis
+func (a *API) playback(c echo.Context) error {
+	drivers, err := a.fleetAt(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	return c.JSON(http.StatusOK, &DriversResponse{
+		Success: true,
+		Message: "found",
+		Drivers: drivers,
+	})
+}
+
+// nearestAt returns drivers nearest to the origin as they were at the time, they are not ranked by routes and scores
+func (a *API) nearestAt(c echo.Context, origin storage.Location, count int, filters []storage.Filter) error {
+	fleet, err := a.fleetAt(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	var drivers []*storage.Driver
+	for _, d := range fleet {
+		if matches(d, filters) {
+			drivers = append(drivers, d)
+		}
+	}
+	storage.SortByDistance(origin, drivers)
+	if len(drivers) > count {
+		drivers = drivers[:count]
+	}
+	return respond(c, http.StatusOK, &NearestDriverResponse{
+		Success: true,
+		Message: "found",
+		Drivers: a.nearest(origin, drivers),
+	})
+}
+
+func matches(d *storage.Driver, filters []storage.Filter) bool {
+	for _, f := range filters {
+		if !f(d) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo"
+)
+
+// limiterSweepInterval is how often buckets of idle clients are removed
+const limiterSweepInterval = time.Minute
+
+type (
+	// limiter is a token bucket per client
+	limiter struct {
+		mu      sync.Mutex
+		rate    float64
+		burst   float64
+		buckets map[string]*bucket
+		swept   time.Time
+	}
+
+	bucket struct {
+		tokens float64
+		last   time.Time
+	}
+)
+
+// WithRateLimit limits every client to rate requests per second with bursts of burst requests,
+// rate 0 does not limit until SetRateLimit. Clients are told apart by API key or bearer token and by IP without them.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(a *API) {
+		a.limiter = &limiter{
+			rate:    rate,
+			burst:   float64(burst),
+			buckets: make(map[string]*bucket),
+		}
+	}
+}
+
+// SetRateLimit changes the limit of API created WithRateLimit, rate 0 disables it.
+// Clients keep their tokens up to the new burst.
+func (a *API) SetRateLimit(rate float64, burst int) {
+	if a.limiter == nil {
+		return
+	}
+	a.limiter.mu.Lock()
+	defer a.limiter.mu.Unlock()
+	a.limiter.rate = rate
+	a.limiter.burst = float64(burst)
+}
+
+// allow takes a token of the client, wait is how long until the next token if there is none
+func (l *limiter) allow(client string, now time.Time) (ok bool, wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate <= 0 {
+		return true, 0
+	}
+	if now.Sub(l.swept) > limiterSweepInterval {
+		l.sweep(now)
+	}
+	b, exists := l.buckets[client]
+	if !exists {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep removes buckets refilled up to the burst, they are the same as new ones
+func (l *limiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.swept = now
+}
+
+// rateLimit is a middleware rejecting requests of clients exceeding the rate
+func (a *API) rateLimit(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ok, wait := a.limiter.allow(client(c), time.Now())
+		if !ok {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			return failWith(c, http.StatusTooManyRequests, CodeRateLimited, "Rate limit exceeded")
+		}
+		return next(c)
+	}
+}
+
+// client of the request is told apart by API key or bearer token and by IP without them
+func client(c echo.Context) string {
+	if key := credentials(c); key != "" {
+		return key
+	}
+	return c.RealIP()
+}
+
+// credentials returns the API key or the bearer token of the request, empty if it has none
+func credentials(c echo.Context) string {
+	if key := c.Request().Header.Get(apiKeyHeader); key != "" {
+		return key
+	}
+	return c.Request().Header.Get(echo.HeaderAuthorization)
+}
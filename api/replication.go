@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/gob"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kdrake/nearestdots/replication"
+	"github.com/labstack/echo"
+)
+
+// replicationSnapshotTimeout is how long a replica may take to receive the snapshot
+const replicationSnapshotTimeout = 5 * time.Minute
+
+// replicationChanges streams changes of the default namespace after the epoch and after position,
+// replicas without position get the position and a snapshot taken after it first.
+// Changes repeated by the snapshot are skipped by replicas as stale.
+func (a *API) replicationChanges(c echo.Context) error {
+	var (
+		epoch int64
+		after uint64
+		snap  snapshotter
+	)
+	if v := c.QueryParam("epoch"); v != "" {
+		var (
+			errs fieldErrors
+			err  error
+		)
+		if epoch, err = strconv.ParseInt(v, 10, 64); err != nil {
+			errs.add("epoch", "must be an integer")
+		}
+		if after, err = strconv.ParseUint(c.QueryParam("after"), 10, 64); err != nil {
+			errs.add("after", "must be a non-negative integer")
+		}
+		if errs != nil {
+			return invalid(c, errs)
+		}
+	} else {
+		s, err := a.namespaces.Namespace("")
+		if err != nil {
+			return fail(c, err)
+		}
+		var ok bool
+		if snap, ok = s.(snapshotter); !ok {
+			return fail(c, ErrSnapshotsUnsupported)
+		}
+		epoch, after = a.replication.Position()
+	}
+	changes, appended, err := a.replication.Since(epoch, after)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	w := c.Response()
+	// timeouts of the server are for requests, the stream has its own write deadlines
+	rc := http.NewResponseController(w.Writer)
+	rc.SetReadDeadline(time.Time{})
+	w.Header().Set(echo.HeaderContentType, mimeSnapshot)
+	w.Header().Set("X-Replication-Epoch", strconv.FormatInt(epoch, 10))
+	w.Header().Set("X-Replication-Seq", strconv.FormatUint(after, 10))
+	w.WriteHeader(http.StatusOK)
+	if snap != nil {
+		rc.SetWriteDeadline(time.Now().Add(replicationSnapshotTimeout))
+		if err := snap.WriteSnapshot(w); err != nil {
+			return nil
+		}
+	}
+
+	enc := gob.NewEncoder(w)
+	heartbeat := time.NewTicker(replication.HeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		// the first batch tells the replica the stream is ready even if it's empty
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if err := enc.Encode(&replication.Batch{Changes: changes}); err != nil {
+			return nil
+		}
+		w.Flush()
+		if len(changes) > 0 {
+			after = changes[len(changes)-1].Seq
+		}
+
+		select {
+		case <-appended:
+			// the replica reconnects and gets position_lost if it fell behind the log
+			if changes, appended, err = a.replication.Since(epoch, after); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			changes = nil
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
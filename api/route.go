@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// ErrRouteUnsupported sign what storage of the namespace doesn't search drivers along routes
+var ErrRouteUnsupported = errors.New("Storage does not search drivers along routes")
+
+// routeSearcher is a storage searching drivers nearest to a route
+type routeSearcher interface {
+	NearestToRoute(route []storage.Location, count int, filters ...storage.Filter) ([]*storage.Driver, error)
+}
+
+// routeDrivers returns drivers nearest to any point of the route for en-route pickups and pooled rides,
+// distances and ETAs are to the closest point of the route
+func (a *API) routeDrivers(c echo.Context) error {
+	p := &RoutePayload{}
+	if err := c.Bind(p); err != nil {
+		return failWith(c, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Set content-type application/json or check your payload data")
+	}
+	var errs fieldErrors
+	if p.Count < 0 {
+		errs.add("count", "must be a positive integer")
+	}
+	if p.Polyline == "" {
+		errs.add("polyline", "must be an encoded route")
+	}
+	if errs != nil {
+		return invalid(c, errs)
+	}
+	if p.Count == 0 {
+		p.Count = defaultNearestCount
+	}
+	route, err := storage.DecodePolyline(p.Polyline)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	// the storage is resolved again as the tracing wrapper doesn't search along routes
+	s, err := a.namespaces.Namespace(namespaceName(c))
+	if err != nil {
+		return fail(c, err)
+	}
+	r, ok := s.(routeSearcher)
+	if !ok {
+		return fail(c, ErrRouteUnsupported)
+	}
+
+	var filters []storage.Filter
+	if !p.IncludeUnavailable {
+		filters = append(filters, storage.Available())
+	}
+	if len(p.Attributes) > 0 {
+		filters = append(filters, storage.AttributesFilter(p.Attributes))
+	}
+	drivers, err := r.NearestToRoute(route, p.Count, filters...)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	nearest := make([]*NearestDriver, 0, len(drivers))
+	for _, d := range drivers {
+		closest, distance := storage.ClosestOnRoute(route, d.LastLocation)
+		nearest = append(nearest, &NearestDriver{
+			Driver:     d,
+			Distance:   distance,
+			ETASeconds: storage.ETA(d, closest, a.averageSpeed).Seconds(),
+		})
+	}
+	return c.JSON(http.StatusOK, &NearestDriverResponse{
+		Success: true,
+		Message: "found",
+		Drivers: verbose(c, nearest),
+	})
+}
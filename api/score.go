@@ -0,0 +1,41 @@
+package api
+
+import (
+	"sort"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+)
+
+// scoreCandidatesFactor is how many times more nearest drivers than requested are ranked by score
+const scoreCandidatesFactor = 4
+
+// WithScoreWeights ranks nearest drivers by the weighted score instead of distance
+// unless requests have their own weights
+func WithScoreWeights(w storage.Weights) Option {
+	return func(a *API) {
+		a.weights = w
+	}
+}
+
+// scoreWeights returns weights of the weights query parameter or the configured ones
+func (a *API) scoreWeights(c echo.Context) (storage.Weights, error) {
+	if v := c.QueryParam("weights"); v != "" {
+		return storage.ParseWeights(v)
+	}
+	return a.weights, nil
+}
+
+// byScore sets scores of nearest drivers and orders them by score descending
+func byScore(origin storage.Location, nearest []*NearestDriver, w storage.Weights) []*NearestDriver {
+	now := time.Now()
+	for _, n := range nearest {
+		score := w.Score(n.Driver, origin, now)
+		n.Score = &score
+	}
+	sort.SliceStable(nearest, func(i, j int) bool {
+		return *nearest[i].Score > *nearest[j].Score
+	})
+	return nearest
+}
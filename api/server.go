@@ -0,0 +1,119 @@
+package api
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/netutil"
+)
+
+// WithTimeouts limits reading requests, writing responses and keeping idle keep-alive connections,
+// zero disables a timeout. Reading headers gets the read timeout, so slow clients can't hold connections.
+// Streams lift read and write timeouts once they start.
+func WithTimeouts(read, write, idle time.Duration) Option {
+	return func(a *API) {
+		for _, s := range []*http.Server{a.echo.Server, a.echo.TLSServer} {
+			s.ReadTimeout = read
+			s.WriteTimeout = write
+			s.IdleTimeout = idle
+		}
+	}
+}
+
+// WithMaxHeaderBytes limits size of request headers, http.DefaultMaxHeaderBytes if not set
+func WithMaxHeaderBytes(n int) Option {
+	return func(a *API) {
+		a.echo.Server.MaxHeaderBytes = n
+		a.echo.TLSServer.MaxHeaderBytes = n
+	}
+}
+
+// WithMaxConnections limits number of concurrent connections,
+// new connections wait in the listen backlog until others close
+func WithMaxConnections(n int) Option {
+	return func(a *API) {
+		a.maxConnections = n
+	}
+}
+
+// unixScheme prefixes bind addresses of unix domain sockets, e.g. unix:///var/run/nearestdots.sock
+const unixScheme = "unix://"
+
+// bindAddrs splits comma separated bind addresses
+func bindAddrs(bindAddr string) []string {
+	var addrs []string
+	for _, addr := range strings.Split(bindAddr, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// listen listens the TCP address or the unix socket, a socket left by a crashed instance is replaced
+func listen(addr string) (net.Listener, error) {
+	if !strings.HasPrefix(addr, unixScheme) {
+		return net.Listen("tcp", addr)
+	}
+	path := strings.TrimPrefix(addr, unixScheme)
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, errors.Errorf("%s is in use", addr)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, errors.Wrap(err, "could not remove stale socket")
+		}
+	}
+	return net.Listen("unix", path)
+}
+
+// serve listens all bind addresses and serves HTTP or HTTPS on them until shutdown,
+// nothing is served if any address can't be listened. Max connections are limited per listener.
+func (a *API) serve() error {
+	s := a.echo.Server
+	if a.tls != nil {
+		s = a.echo.TLSServer
+		s.TLSConfig = a.tls
+	}
+	s.Handler = a
+	s.ErrorLog = a.echo.StdLogger
+
+	listeners := make([]net.Listener, 0, len(a.bindAddrs))
+	for _, addr := range a.bindAddrs {
+		l, err := listen(addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return errors.Wrapf(err, "could not listen %s", addr)
+		}
+		if a.maxConnections > 0 {
+			l = netutil.LimitListener(l, a.maxConnections)
+		}
+		if a.tls != nil {
+			l = tls.NewListener(l, a.tls)
+		}
+		listeners = append(listeners, l)
+	}
+
+	// shutdown closes all listeners, so every Serve returns
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errs <- s.Serve(l)
+		}(l)
+	}
+	var err error
+	for range listeners {
+		if e := <-errs; err == nil || err == http.ErrServerClosed {
+			err = e
+		}
+	}
+	return err
+}
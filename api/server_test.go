@@ -0,0 +1,115 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/kdrake/nearestdots/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerTimeouts(t *testing.T) {
+	hub := stream.NewHub(10)
+	db := storage.New(10, storage.WithObserver(hub))
+	timeout := 100 * time.Millisecond
+	a, addr := startAPI(t, db, WithStream(hub), WithTimeouts(timeout, timeout, timeout), WithMaxHeaderBytes(1024))
+	defer a.Shutdown(context.Background())
+
+	// slow clients are disconnected before they finish headers
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /healthz HTTP/1.1\r\nHost: api\r\n"))
+	assert.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(10 * timeout))
+	_, err = conn.Read(make([]byte, 1))
+	if assert.Error(t, err) {
+		ne, ok := err.(net.Error)
+		assert.False(t, ok && ne.Timeout(), "connection is not closed by the server")
+	}
+
+	// idle keep-alive connections are closed too
+	conn, err = net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /healthz HTTP/1.1\r\nHost: api\r\n\r\n"))
+	assert.NoError(t, err)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp.Body.Close()
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * timeout))
+	_, err = r.ReadByte()
+	if assert.Error(t, err) {
+		ne, ok := err.(net.Error)
+		assert.False(t, ok && ne.Timeout(), "idle connection is not closed by the server")
+	}
+
+	// http adds 4096 bytes to the header limit
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/healthz", nil)
+	assert.NoError(t, err)
+	req.Header.Set("X-Padding", strings.Repeat("x", 1024+4096))
+	resp, err = http.DefaultClient.Do(req)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	// streams outlive read and write timeouts
+	resp, err = http.Get("http://" + addr + "/v2/driver/1/1/nearest/events")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	events := bufio.NewReader(resp.Body)
+	line, err := events.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "retry: 3000\n", line)
+	time.Sleep(3 * timeout)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	// the event of the driver is sent after the timeouts
+	for !strings.Contains(line, `"id":1`) && err == nil {
+		line, err = events.ReadString('\n')
+	}
+	assert.NoError(t, err)
+}
+
+func TestMaxConnections(t *testing.T) {
+	a, addr := startAPI(t, storage.New(10), WithMaxConnections(1))
+	defer a.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Write([]byte("GET /healthz HTTP/1.1\r\nHost: api\r\n\r\n"))
+	assert.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// other connections wait while the keep-alive one is open
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 200 * time.Millisecond}
+	_, err = client.Get("http://" + addr + "/healthz")
+	assert.Error(t, err)
+	conn.Close()
+	client.Timeout = time.Second
+	resp, err = client.Get("http://" + addr + "/healthz")
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		resp.Body.Close()
+	}
+}
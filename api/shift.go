@@ -0,0 +1,83 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// ErrShiftsUnsupported sign what storage of the namespace doesn't keep shifts of drivers
+var ErrShiftsUnsupported = errors.New("Storage does not support shifts")
+
+// shifter is a storage starting and stopping shifts of drivers
+type shifter interface {
+	StartShift(id int) error
+	StopShift(id int) error
+	Shifts(id int) ([]storage.Shift, error)
+}
+
+func (a *API) startShift(c echo.Context) error {
+	return a.changeShift(c, shifter.StartShift, "started")
+}
+
+func (a *API) stopShift(c echo.Context) error {
+	return a.changeShift(c, shifter.StopShift, "stopped")
+}
+
+// changeShift starts or stops the shift of the driver of the path
+func (a *API) changeShift(c echo.Context, change func(s shifter, id int) error, message string) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "could not convert string to integer")
+	}
+	if !ownDriver(c, id) {
+		return forbidDriver(c, id)
+	}
+	s, err := a.shifter(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	if err := change(s, id); err != nil {
+		return fail(c, err)
+	}
+	return c.JSON(http.StatusOK, &DefaultResponse{
+		Success: true,
+		Message: message,
+	})
+}
+
+func (a *API) driverShifts(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "could not convert string to integer")
+	}
+	s, err := a.shifter(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	shifts, err := s.Shifts(id)
+	if err != nil {
+		return fail(c, err)
+	}
+	return c.JSON(http.StatusOK, &ShiftsResponse{
+		Success: true,
+		Message: "found",
+		Shifts:  shifts,
+	})
+}
+
+// shifter returns storage of the request namespace, it's resolved again as the tracing wrapper has no shifts
+func (a *API) shifter(c echo.Context) (shifter, error) {
+	s, err := a.namespaces.Namespace(namespaceName(c))
+	if err != nil {
+		return nil, err
+	}
+	sh, ok := s.(shifter)
+	if !ok {
+		return nil, ErrShiftsUnsupported
+	}
+	return sh, nil
+}
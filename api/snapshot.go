@@ -0,0 +1,62 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// mimeSnapshot is the content type of gob snapshots of the storage
+const mimeSnapshot = "application/octet-stream"
+
+// ErrSnapshotsUnsupported sign what storage of the namespace has no snapshots
+var ErrSnapshotsUnsupported = errors.New("Storage does not support snapshots")
+
+// snapshotter is a storage reading and writing snapshots in the format of its snapshot files
+type snapshotter interface {
+	WriteSnapshot(w io.Writer) error
+	ReadSnapshot(r io.Reader) error
+}
+
+func (a *API) getSnapshot(c echo.Context) error {
+	s, err := a.snapshotter(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	// the snapshot is buffered, so a failure is replied instead of a truncated body
+	var buf bytes.Buffer
+	if err := s.WriteSnapshot(&buf); err != nil {
+		return failWith(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+	return c.Blob(http.StatusOK, mimeSnapshot, buf.Bytes())
+}
+
+func (a *API) restoreSnapshot(c echo.Context) error {
+	s, err := a.snapshotter(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	if err := s.ReadSnapshot(c.Request().Body); err != nil {
+		return fail(c, err)
+	}
+	return c.JSON(http.StatusOK, &DefaultResponse{
+		Success: true,
+		Message: "restored",
+	})
+}
+
+// snapshotter returns storage of the request namespace, it's resolved again as the tracing wrapper has no snapshots
+func (a *API) snapshotter(c echo.Context) (snapshotter, error) {
+	s, err := a.namespaces.Namespace(namespaceName(c))
+	if err != nil {
+		return nil, err
+	}
+	snap, ok := s.(snapshotter)
+	if !ok {
+		return nil, ErrSnapshotsUnsupported
+	}
+	return snap, nil
+}
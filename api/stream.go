@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/gorilla/websocket"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/kdrake/nearestdots/stream"
+	"github.com/labstack/echo"
+)
+
+// streamWriteTimeout is how long a websocket client may take to receive an update
+const streamWriteTimeout = 10 * time.Second
+
+// nearestEventsInterval is the minimal interval between nearest drivers recalculations
+const nearestEventsInterval = time.Second
+
+// heartbeatInterval is how often an idle event stream gets a comment keeping it alive
+const heartbeatInterval = 15 * time.Second
+
+// reconnectDelay is how long event stream clients wait before reconnecting, in milliseconds
+const reconnectDelay = 3000
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// streamUpdates pushes location updates of drivers selected by the bounding box
+// (min_lat, min_lon, max_lat, max_lon) and/or comma separated ids to the websocket
+func (a *API) streamUpdates(c echo.Context) error {
+	filter, err := streamFilter(c)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// upgrader has already replied with an error
+		return nil
+	}
+	defer conn.Close()
+	// the read timeout of the server is for the handshake, writes have streamWriteTimeout
+	conn.SetReadDeadline(time.Time{})
+
+	sub := a.stream.Subscribe(filter)
+	defer sub.Close()
+
+	// clients don't send anything, reading detects closed connections
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case u := <-sub.Updates():
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteJSON(u); err != nil {
+				return nil
+			}
+		case <-closed:
+			return nil
+		case <-a.done:
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown"),
+				time.Now().Add(streamWriteTimeout))
+			return nil
+		}
+	}
+}
+
+func streamFilter(c echo.Context) (stream.Filter, error) {
+	var f stream.Filter
+	if c.QueryParam("min_lat") != "" {
+		box, err := boundingBox(c)
+		if err != nil {
+			return f, err
+		}
+		if !storage.ValidBoundingBox(box[0], box[1], box[2], box[3]) {
+			return f, storage.ErrInvalidBoundingBox
+		}
+		f.Box = &box
+	}
+	ids, err := queryIDs(c, "ids")
+	if err != nil {
+		return f, err
+	}
+	f.IDs = ids
+	return f, nil
+}
+
+// nearestEvents streams nearest drivers to the point as server-sent events.
+// An event is sent on connect and then whenever nearest drivers or their locations change.
+// Event ids continue from Last-Event-ID of reconnecting clients. Drivers are ranked by distance.
+func (a *API) nearestEvents(c echo.Context) error {
+	origin, errs := pathLocation(c)
+	if errs != nil {
+		return invalid(c, errs)
+	}
+	lat, lon := origin.Lat, origin.Lon
+	count, filters, err := nearestQuery(c)
+	if err != nil {
+		return fail(c, err)
+	}
+	var id uint64
+	if v := c.Request().Header.Get("Last-Event-ID"); v != "" {
+		// unknown ids of other servers restart numbering
+		id, _ = strconv.ParseUint(v, 10, 64)
+	}
+
+	// streams observe the default namespace only
+	db, err := a.namespaces.Namespace("")
+	if err != nil {
+		return err
+	}
+	sub := a.stream.Subscribe(stream.Filter{})
+	defer sub.Close()
+
+	w := c.Response()
+	// timeouts of the server are for requests, events have streamWriteTimeout instead
+	rc := http.NewResponseController(w.Writer)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", reconnectDelay); err != nil {
+		return nil
+	}
+
+	var last []storage.Driver
+	changed := true
+	ticker := time.NewTicker(nearestEventsInterval)
+	defer ticker.Stop()
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	closed := w.CloseNotify()
+	for {
+		if changed {
+			drivers := db.Nearest(rtreego.Point{lat, lon}, count, filters...)
+			if last == nil || !sameDrivers(last, drivers) {
+				id++
+				data, err := json.Marshal(&NearestDriverResponse{
+					Success: true,
+					Message: "found",
+					Drivers: a.nearest(origin, drivers),
+				})
+				if err != nil {
+					return err
+				}
+				rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: nearest\ndata: %s\n\n", id, data); err != nil {
+					return nil
+				}
+				w.Flush()
+				last = positions(drivers)
+			}
+			changed = false
+		}
+
+		select {
+		case <-ticker.C:
+			// drain updates since the last recalculation
+			for len(sub.Updates()) > 0 {
+				<-sub.Updates()
+				changed = true
+			}
+		case <-heartbeat.C:
+			rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+		case <-closed:
+			return nil
+		case <-a.done:
+			// clients reconnect to another server after the retry delay
+			return nil
+		}
+	}
+}
+
+// positions copies ids and locations of drivers, stored drivers keep changing
+func positions(drivers []*storage.Driver) []storage.Driver {
+	p := make([]storage.Driver, len(drivers))
+	for i, d := range drivers {
+		p[i] = storage.Driver{ID: d.ID, LastLocation: d.LastLocation}
+	}
+	return p
+}
+
+// sameDrivers returns true if drivers are the same and in the same locations and order as before
+func sameDrivers(before []storage.Driver, drivers []*storage.Driver) bool {
+	if len(before) != len(drivers) {
+		return false
+	}
+	for i, d := range drivers {
+		if before[i].ID != d.ID || before[i].LastLocation != d.LastLocation {
+			return false
+		}
+	}
+	return true
+}
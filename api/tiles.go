@@ -0,0 +1,97 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// mimeVectorTile is the content type of Mapbox vector tiles
+	mimeVectorTile = "application/vnd.mapbox-vector-tile"
+	// tileLayer is the name of the layer of drivers in vector tiles
+	tileLayer = "drivers"
+	// tileBuffer is how many tile coordinates around a tile are drawn, so icons on edges aren't cut
+	tileBuffer = 64
+)
+
+// vectorTile serves drivers of the web mercator tile as a Mapbox vector tile with one layer of points,
+// features have ids of drivers and their status
+func (a *API) vectorTile(c echo.Context) error {
+	z, errZ := strconv.Atoi(c.Param("z"))
+	x, errX := strconv.Atoi(c.Param("x"))
+	name := c.Param("y")
+	y, errY := strconv.Atoi(strings.TrimSuffix(name, ".mvt"))
+	if errZ != nil || errX != nil || errY != nil || !strings.HasSuffix(name, ".mvt") || !storage.ValidTile(z, x, y) {
+		return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "tile must be /tiles/{z}/{x}/{y}.mvt of zoom from 0 to "+strconv.Itoa(storage.MaxZoom))
+	}
+
+	minLat, minLon, maxLat, maxLon := storage.TileBounds(z, x, y, tileBuffer)
+	drivers, err := database(c).InBoundingBox(minLat, minLon, maxLat, maxLon)
+	if err != nil {
+		return fail(c, err)
+	}
+	return c.Blob(http.StatusOK, mimeVectorTile, encodeTile(drivers, z, x, y))
+}
+
+// encodeTile encodes drivers as points of the tile layer in the vector tile protobuf format,
+// a tile without drivers is empty
+func encodeTile(drivers []*storage.Driver, z, x, y int) []byte {
+	if len(drivers) == 0 {
+		return nil
+	}
+	// values are shared by features, they are indexed by their order
+	values := make(map[storage.Status]uint64)
+	var layer []byte
+	layer = protowire.AppendTag(layer, 15, protowire.VarintType)
+	layer = protowire.AppendVarint(layer, 2)
+	layer = protowire.AppendTag(layer, 1, protowire.BytesType)
+	layer = protowire.AppendString(layer, tileLayer)
+	for _, d := range drivers {
+		v, ok := values[d.Status]
+		if !ok {
+			v = uint64(len(values))
+			values[d.Status] = v
+		}
+		px, py := storage.TilePoint(d.LastLocation, z, x, y)
+		var feature []byte
+		feature = protowire.AppendTag(feature, 1, protowire.VarintType)
+		feature = protowire.AppendVarint(feature, uint64(d.ID))
+		// the status key is the only key, its values are indexed
+		feature = protowire.AppendTag(feature, 2, protowire.BytesType)
+		feature = protowire.AppendBytes(feature, protowire.AppendVarint(protowire.AppendVarint(nil, 0), v))
+		feature = protowire.AppendTag(feature, 3, protowire.VarintType)
+		feature = protowire.AppendVarint(feature, 1)
+		// MoveTo of one point and its zigzag encoded coordinates
+		var geometry []byte
+		geometry = protowire.AppendVarint(geometry, 1|1<<3)
+		geometry = protowire.AppendVarint(geometry, protowire.EncodeZigZag(int64(px)))
+		geometry = protowire.AppendVarint(geometry, protowire.EncodeZigZag(int64(py)))
+		feature = protowire.AppendTag(feature, 4, protowire.BytesType)
+		feature = protowire.AppendBytes(feature, geometry)
+
+		layer = protowire.AppendTag(layer, 2, protowire.BytesType)
+		layer = protowire.AppendBytes(layer, feature)
+	}
+	layer = protowire.AppendTag(layer, 3, protowire.BytesType)
+	layer = protowire.AppendString(layer, "status")
+	statuses := make([]storage.Status, len(values))
+	for status, i := range values {
+		statuses[i] = status
+	}
+	for _, status := range statuses {
+		value := protowire.AppendTag(nil, 1, protowire.BytesType)
+		value = protowire.AppendString(value, string(status))
+		layer = protowire.AppendTag(layer, 4, protowire.BytesType)
+		layer = protowire.AppendBytes(layer, value)
+	}
+	layer = protowire.AppendTag(layer, 5, protowire.VarintType)
+	layer = protowire.AppendVarint(layer, storage.TileExtent)
+
+	tile := protowire.AppendTag(nil, 3, protowire.BytesType)
+	return protowire.AppendBytes(tile, layer)
+}
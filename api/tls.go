@@ -0,0 +1,48 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidClientCA sign what client CA file has no PEM certificates
+var ErrInvalidClientCA = errors.New("Invalid client CA certificates")
+
+// LoadTLSConfig loads the certificate and the key of the server.
+// Clients must present certificates signed by clientCAFile if it's not empty.
+func LoadTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load TLS certificate")
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if clientCAFile == "" {
+		return config, nil
+	}
+
+	pem, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read client CA")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, ErrInvalidClientCA
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+// WithTLS serves HTTPS with the config instead of plain HTTP
+func WithTLS(config *tls.Config) Option {
+	return func(a *API) {
+		a.tls = config
+	}
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTracer records a span of every request with child spans of its storage operations.
+// Spans continue traces of traceparent headers.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(a *API) {
+		a.tracer = tracer
+	}
+}
+
+// traceRequests is a middleware starting a server span of the request
+func (a *API) traceRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := a.tracer.Start(ctx, req.Method+" "+c.Path(),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(req.Method),
+				semconv.HTTPRoute(c.Path()),
+				semconv.URLPath(req.URL.Path),
+				attribute.String("request_id", c.Response().Header().Get(echo.HeaderXRequestID)),
+			))
+		defer span.End()
+		c.SetRequest(req.WithContext(ctx))
+
+		// errors are handled here so the span gets their status
+		err := next(c)
+		if err != nil {
+			span.RecordError(err)
+			c.Error(err)
+		}
+		status := c.Response().Status
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+const (
+	// mimeGPX is the content type of GPX tracks
+	mimeGPX = "application/gpx+xml"
+	// mimeGeoJSON is the content type of GeoJSON tracks
+	mimeGeoJSON = "application/geo+json"
+)
+
+type (
+	gpx struct {
+		XMLName xml.Name `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+		Version string   `xml:"version,attr"`
+		Creator string   `xml:"creator,attr"`
+		Track   gpxTrack `xml:"trk"`
+	}
+	gpxTrack struct {
+		Name    string     `xml:"name"`
+		Segment []gpxPoint `xml:"trkseg>trkpt"`
+	}
+	gpxPoint struct {
+		Lat  float64 `xml:"lat,attr"`
+		Lon  float64 `xml:"lon,attr"`
+		Time string  `xml:"time"`
+	}
+)
+
+// driverHistory returns locations of the driver of the request between from and to query parameters
+// in unix nanoseconds
+func driverHistory(c echo.Context) (int, []storage.HistoryPoint, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, nil, errors.New("could not convert string to integer")
+	}
+	var from, to int64
+	for name, v := range map[string]*int64{"from": &from, "to": &to} {
+		if q := c.QueryParam(name); q != "" {
+			if *v, err = strconv.ParseInt(q, 10, 64); err != nil {
+				return 0, nil, errors.New(name + " must be an integer")
+			}
+		}
+	}
+	points, err := database(c).History(id, from, to)
+	return id, points, err
+}
+
+// driverTrackGPX exports the driver history as a GPX track for support investigations
+func (a *API) driverTrackGPX(c echo.Context) error {
+	id, points, err := driverHistory(c)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	doc := gpx{
+		Version: "1.1",
+		Creator: "nearestdots",
+		Track:   gpxTrack{Name: "driver " + strconv.Itoa(id), Segment: make([]gpxPoint, len(points))},
+	}
+	for i, p := range points {
+		doc.Track.Segment[i] = gpxPoint{
+			Lat:  p.Location.Lat,
+			Lon:  p.Location.Lon,
+			Time: time.Unix(0, p.Timestamp).UTC().Format(time.RFC3339Nano),
+		}
+	}
+	body, err := xml.Marshal(doc)
+	if err != nil {
+		return failWith(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+	attachment(c, id, "gpx")
+	return c.Blob(http.StatusOK, mimeGPX, append([]byte(xml.Header), body...))
+}
+
+// driverTrackGeoJSON exports the driver history as a GeoJSON LineString feature for trip reconstruction
+func (a *API) driverTrackGeoJSON(c echo.Context) error {
+	id, points, err := driverHistory(c)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	track := TrackFeature{
+		Type: "Feature",
+		Geometry: TrackGeometry{
+			Type:        "LineString",
+			Coordinates: make([][2]float64, len(points)),
+		},
+		Properties: TrackProperties{DriverID: id, Timestamps: make([]int64, len(points))},
+	}
+	for i, p := range points {
+		track.Geometry.Coordinates[i] = [2]float64{p.Location.Lon, p.Location.Lat}
+		track.Properties.Timestamps[i] = p.Timestamp
+	}
+	body, err := json.Marshal(track)
+	if err != nil {
+		return failWith(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+	attachment(c, id, "geojson")
+	return c.Blob(http.StatusOK, mimeGeoJSON, body)
+}
+
+// attachment names the downloaded track of the driver
+func attachment(c echo.Context, id int, ext string) {
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="driver-`+strconv.Itoa(id)+"."+ext+`"`)
+}
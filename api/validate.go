@@ -0,0 +1,56 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+)
+
+// fieldErrors collects invalid fields of a request
+type fieldErrors []FieldError
+
+func (e *fieldErrors) add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// location checks latitude and longitude ranges, NaN and infinities are out of them
+func (e *fieldErrors) location(field string, l Location) {
+	if !(l.Latitude >= -90 && l.Latitude <= 90) {
+		e.add(field+".lat", "must be in [-90, 90]")
+	}
+	if !(l.Longitude >= -180 && l.Longitude <= 180) {
+		e.add(field+".lon", "must be in [-180, 180]")
+	}
+}
+
+// payload checks the location update, prefix is the path of the payload in the request
+func (e *fieldErrors) payload(prefix string, p *Payload) {
+	if p.DriverID <= 0 {
+		e.add(prefix+"driver_id", "must be a positive integer")
+	}
+	e.location(prefix+"location", p.Location)
+	if p.Timestamp != nil && *p.Timestamp <= 0 {
+		e.add(prefix+"timestamp", "must be positive unix nanoseconds, omit it to use the current time")
+	}
+	if p.TTL < 0 {
+		e.add(prefix+"ttl", "must not be negative")
+	}
+	if p.Status != "" && !p.Status.Valid() {
+		e.add(prefix+"status", storage.ErrInvalidStatus.Error())
+	}
+}
+
+// pathLocation parses lat and lon path parameters
+func pathLocation(c echo.Context) (storage.Location, fieldErrors) {
+	var errs fieldErrors
+	lat, err := strconv.ParseFloat(c.Param("lat"), 64)
+	if err != nil || !(lat >= -90 && lat <= 90) {
+		errs.add("lat", "must be a number in [-90, 90]")
+	}
+	lon, err := strconv.ParseFloat(c.Param("lon"), 64)
+	if err != nil || !(lon >= -180 && lon <= 180) {
+		errs.add("lon", "must be a number in [-180, 180]")
+	}
+	return storage.Location{Lat: lat, Lon: lon}, errs
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"strconv"
+
+	"github.com/labstack/echo"
+)
+
+// API versions. Handlers build the model of the request version, so routes of
+// older versions keep their responses while newer versions evolve.
+const (
+	v1 = iota + 1
+	v2
+)
+
+// versionKey is a context key of the API version of the request
+const versionKey = "version"
+
+// versionHeader tells clients the API version of the response
+const versionHeader = "API-Version"
+
+// versioned is a middleware setting API version of requests
+func versioned(version int) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(versionKey, version)
+			c.Response().Header().Set(versionHeader, strconv.Itoa(version))
+			return next(c)
+		}
+	}
+}
+
+// apiVersion returns API version of the request, v1 for unversioned routes
+func apiVersion(c echo.Context) int {
+	if v, ok := c.Get(versionKey).(int); ok {
+		return v
+	}
+	return v1
+}
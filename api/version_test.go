@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersions(t *testing.T) {
+	a, _ := newTestAPI(t, 1)
+
+	for prefix, version := range map[string]string{"/api": "1", "/v1": "1", "/v2": "2"} {
+		w := doRequest(a, http.MethodGet, prefix+"/driver/1")
+		assert.Equal(t, http.StatusOK, w.Code, prefix)
+		assert.Equal(t, version, w.Header().Get(versionHeader), prefix)
+		var r DriverResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &r))
+		assert.Equal(t, 1, r.Driver.ID)
+
+		// v1 keeps replying 400 to missing drivers
+		w = doRequest(a, http.MethodGet, prefix+"/driver/2")
+		status := http.StatusNotFound
+		if version == "1" {
+			status = http.StatusBadRequest
+		}
+		assert.Equal(t, status, w.Code, prefix)
+		assert.Equal(t, version, w.Header().Get(versionHeader), prefix)
+		var e ErrorResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+		assert.Equal(t, CodeDriverNotFound, e.Code, prefix)
+	}
+	w := doRequest(a, http.MethodGet, "/v3/driver/1")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, w.Header().Get(versionHeader))
+	// probes have no version
+	assert.Empty(t, doRequest(a, http.MethodGet, "/healthz").Header().Get(versionHeader))
+
+	// every version has the same routes
+	routes := map[string][]string{}
+	for _, r := range a.echo.Routes() {
+		for _, prefix := range []string{"/api", "/v1", "/v2"} {
+			if strings.HasPrefix(r.Path, prefix+"/") {
+				routes[prefix] = append(routes[prefix], r.Method+" "+strings.TrimPrefix(r.Path, prefix))
+			}
+		}
+	}
+	assert.NotEmpty(t, routes["/v2"])
+	assert.ElementsMatch(t, routes["/v2"], routes["/v1"])
+	assert.ElementsMatch(t, routes["/v2"], routes["/api"])
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/kdrake/nearestdots/webhook"
+	"github.com/labstack/echo"
+)
+
+// WithWebhooks serves registration of webhook endpoints and dead letters of the manager
+func WithWebhooks(m *webhook.Manager) Option {
+	return func(a *API) {
+		a.webhooks = m
+	}
+}
+
+func (a *API) addWebhook(c echo.Context) error {
+	p := &WebhookPayload{}
+	if err := c.Bind(p); err != nil {
+		return failWith(c, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Set content-type application/json or check your payload data")
+	}
+
+	e, err := a.webhooks.Register(webhook.Endpoint{URL: p.URL, Secret: p.Secret, Events: p.Events})
+	if err != nil {
+		return fail(c, err)
+	}
+	e.Secret = ""
+
+	return c.JSON(http.StatusCreated, &WebhookResponse{
+		Success: true,
+		Message: "Added",
+		Webhook: &e,
+	})
+}
+
+func (a *API) listWebhooks(c echo.Context) error {
+	return c.JSON(http.StatusOK, &WebhooksResponse{
+		Success:  true,
+		Message:  "found",
+		Webhooks: a.webhooks.Endpoints(),
+	})
+}
+
+func (a *API) removeWebhook(c echo.Context) error {
+	if err := a.webhooks.Unregister(c.Param("id")); err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &DefaultResponse{
+		Success: true,
+		Message: "removed",
+	})
+}
+
+func (a *API) deadLetters(c echo.Context) error {
+	after, limit, err := eventsQuery(c)
+	if err != nil {
+		return fail(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &DeadLettersResponse{
+		Success:     true,
+		Message:     "found",
+		DeadLetters: a.webhooks.DeadLetters(after, limit),
+	})
+}
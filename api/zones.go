@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/labstack/echo"
+)
+
+// demandTracker keeps demand of nearest queries per namespace
+type demandTracker struct {
+	mu         sync.Mutex
+	window     time.Duration
+	namespaces map[string]*storage.Demand
+}
+
+// WithDemand records points of nearest queries for window and serves supply and demand
+// of geohash zones at /zones, so pricing services can compute surge
+func WithDemand(window time.Duration) Option {
+	return func(a *API) {
+		if window > 0 {
+			a.demand = &demandTracker{window: window, namespaces: make(map[string]*storage.Demand)}
+		}
+	}
+}
+
+// namespace returns demand of the namespace of the request
+func (t *demandTracker) namespace(c echo.Context) *storage.Demand {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	name := namespaceName(c)
+	d, ok := t.namespaces[name]
+	if !ok {
+		d = storage.NewDemand(t.window)
+		t.namespaces[name] = d
+	}
+	return d
+}
+
+// recordDemand records the origin of a nearest query if demand is tracked
+func (a *API) recordDemand(c echo.Context, origin storage.Location) {
+	if a.demand != nil {
+		a.demand.namespace(c).Record(origin, time.Now())
+	}
+}
+
+func (a *API) zones(c echo.Context) error {
+	precision := defaultHeatmapPrecision
+	if v := c.QueryParam("precision"); v != "" {
+		var err error
+		precision, err = strconv.Atoi(v)
+		if err != nil || precision < 1 || precision > 12 {
+			return failWith(c, http.StatusBadRequest, CodeInvalidRequest, "precision must be an integer from 1 to 12")
+		}
+	}
+
+	supply, err := database(c).Heatmap(precision)
+	if err != nil {
+		return failWith(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+	demand := a.demand.namespace(c).Heatmap(precision, time.Now())
+
+	return c.JSON(http.StatusOK, &ZonesResponse{
+		Success:       true,
+		Message:       "found",
+		WindowSeconds: a.demand.window.Seconds(),
+		Zones:         storage.Zones(supply, demand),
+	})
+}
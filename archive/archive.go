@@ -0,0 +1,338 @@
+// Package archive keeps locations of drivers of the default namespace beyond their in-memory history
+// in segment files, so the fleet can be played back as it was at a past time
+package archive
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	segmentExt = ".arc"
+	// segmentDuration is how long changes are appended to a segment before a new one is started
+	segmentDuration = time.Hour
+	// sweepInterval is how often segments older than the retention are removed
+	sweepInterval = time.Minute
+	// bufferSize of writes, changes are appended under the storage lock, so they are flushed in bulk
+	bufferSize = 64 << 10
+	// flushInterval is how often buffered changes are written to the segment
+	flushInterval = time.Second
+)
+
+// Operations of archived changes
+const (
+	opSet uint8 = iota + 1
+	opRemove
+	opStatus
+)
+
+// ErrInvalidTime sign what playback time is before the retention or in the future
+var ErrInvalidTime = errors.New("Playback time must be within the archive retention")
+
+type (
+	// record is an archived change, Timestamp is of the location or of the change
+	// if the driver has no new location
+	record struct {
+		Op         uint8
+		ID         int
+		Location   storage.Location
+		Attributes map[string]string
+		Status     storage.Status
+		Timestamp  int64
+	}
+
+	// Options of the archive. Drivers are in the fleet at a time if their last location
+	// is at most Window older than it, segments older than Retention are removed.
+	Options struct {
+		Retention time.Duration
+		Window    time.Duration
+	}
+
+	// Archive observes the storage and appends its changes to segments named by unix nanoseconds
+	// they are started at. Changes are buffered and flushed every second and before playbacks.
+	Archive struct {
+		mu       sync.Mutex
+		dir      string
+		opts     Options
+		file     *os.File
+		buf      *bufio.Writer
+		enc      *gob.Encoder
+		started  time.Time
+		stopped  chan struct{}
+		finished chan struct{}
+	}
+)
+
+var (
+	_ storage.StateObserver     = (*Archive)(nil)
+	_ storage.LifecycleObserver = (*Archive)(nil)
+)
+
+// Open starts a new segment in dir, segments of previous runs are kept until they are older than the retention
+func Open(dir string, opts Options) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "could not create archive directory")
+	}
+	a := &Archive{
+		dir:      dir,
+		opts:     opts,
+		stopped:  make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	if err := a.rotate(time.Now()); err != nil {
+		return nil, err
+	}
+	go a.run()
+	return a, nil
+}
+
+// Close flushes buffered changes and closes the segment
+func (a *Archive) Close() error {
+	close(a.stopped)
+	<-a.finished
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.buf.Flush()
+	if e := a.file.Close(); err == nil {
+		err = e
+	}
+	return errors.Wrap(err, "could not close archive")
+}
+
+// DriverChanged archives locations with status and attributes of drivers
+func (a *Archive) DriverChanged(d *storage.Driver) {
+	a.append(record{
+		Op:         opSet,
+		ID:         d.ID,
+		Location:   d.LastLocation,
+		Attributes: d.Attributes,
+		Status:     d.Status,
+		Timestamp:  d.Timestamp,
+	})
+}
+
+// DriverMoved does nothing, the whole driver is archived by DriverChanged
+func (a *Archive) DriverMoved(int, storage.Location, int64) {}
+
+// DriverAppeared does nothing, the driver is archived by DriverChanged
+func (a *Archive) DriverAppeared(int, storage.Location, int64) {}
+
+// DriverRemoved archives removals of drivers
+func (a *Archive) DriverRemoved(id int) {
+	a.append(record{Op: opRemove, ID: id, Timestamp: time.Now().UnixNano()})
+}
+
+// DriverExpired archives expired drivers as removed
+func (a *Archive) DriverExpired(id int) {
+	a.DriverRemoved(id)
+}
+
+// DriverStatusChanged archives statuses of drivers
+func (a *Archive) DriverStatusChanged(id int, status storage.Status) {
+	a.append(record{Op: opStatus, ID: id, Status: status, Timestamp: time.Now().UnixNano()})
+}
+
+// append is called under the storage lock, so changes are archived in order they are made
+func (a *Archive) append(r record) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(&r); err != nil {
+		zap.L().Warn("could not archive change", zap.Int("driver_id", r.ID), zap.Error(err))
+	}
+}
+
+// run flushes changes, starts new segments and removes old ones until the archive is closed
+func (a *Archive) run() {
+	defer close(a.finished)
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+	sweep := time.NewTicker(sweepInterval)
+	defer sweep.Stop()
+	a.sweep(time.Now())
+	for {
+		select {
+		case <-a.stopped:
+			return
+		case now := <-flush.C:
+			a.mu.Lock()
+			err := a.buf.Flush()
+			if err == nil && now.Sub(a.started) >= segmentDuration {
+				err = a.rotate(now)
+			}
+			a.mu.Unlock()
+			if err != nil {
+				zap.L().Warn("could not write archive", zap.Error(err))
+			}
+		case now := <-sweep.C:
+			a.sweep(now)
+		}
+	}
+}
+
+// rotate closes the segment and starts a new one, call it under the lock
+func (a *Archive) rotate(now time.Time) error {
+	if a.file != nil {
+		if err := a.file.Close(); err != nil {
+			return errors.Wrap(err, "could not close archive segment")
+		}
+	}
+	f, err := os.OpenFile(filepath.Join(a.dir, segmentName(now.UnixNano())), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not create archive segment")
+	}
+	a.file, a.started = f, now
+	a.buf = bufio.NewWriterSize(f, bufferSize)
+	// every segment is a gob stream with its own types
+	a.enc = gob.NewEncoder(a.buf)
+	return nil
+}
+
+// sweep removes segments having changes older than the retention only
+func (a *Archive) sweep(now time.Time) {
+	segments, err := segments(a.dir)
+	if err != nil {
+		zap.L().Warn("could not list archive segments", zap.Error(err))
+		return
+	}
+	expired := now.Add(-a.opts.Retention).UnixNano()
+	// a segment ends when the next one starts, the last one is being appended
+	for i := 0; i+1 < len(segments) && segments[i+1] < expired; i++ {
+		if err := os.Remove(filepath.Join(a.dir, segmentName(segments[i]))); err != nil {
+			zap.L().Warn("could not remove archive segment", zap.Error(err))
+		}
+	}
+}
+
+// At returns drivers of the fleet at the time, they are drivers with a location at most Window older than it.
+// Changes made in the segment after the time are read too, so late locations of drivers are played back.
+func (a *Archive) At(at time.Time) ([]*storage.Driver, error) {
+	now := time.Now()
+	if at.After(now) || at.Before(now.Add(-a.opts.Retention)) {
+		return nil, ErrInvalidTime
+	}
+	a.mu.Lock()
+	err := a.buf.Flush()
+	a.mu.Unlock()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not flush archive")
+	}
+
+	segments, err := segments(a.dir)
+	if err != nil {
+		return nil, err
+	}
+	from, to := at.Add(-a.opts.Window).UnixNano(), at.UnixNano()
+	drivers := make(map[int]*storage.Driver)
+	for i, start := range segments {
+		if start > to+int64(segmentDuration) {
+			break
+		}
+		if i+1 < len(segments) && segments[i+1] < from {
+			continue
+		}
+		if err := play(filepath.Join(a.dir, segmentName(start)), to, drivers); err != nil {
+			return nil, err
+		}
+	}
+
+	fleet := make([]*storage.Driver, 0, len(drivers))
+	for _, d := range drivers {
+		if d.Timestamp >= from {
+			fleet = append(fleet, d)
+		}
+	}
+	sort.Slice(fleet, func(i, j int) bool { return fleet[i].ID < fleet[j].ID })
+	return fleet, nil
+}
+
+// play applies changes of the segment made until the time to drivers
+func play(path string, to int64, drivers map[int]*storage.Driver) error {
+	f, err := os.Open(path)
+	if err != nil {
+		// the segment is removed by the retention meanwhile
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrap(err, "could not open archive segment")
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	for {
+		var r record
+		err := dec.Decode(&r)
+		// the tail of the segment being appended or torn by a crash
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "could not decode archive segment %s", path)
+		}
+		if r.Timestamp > to {
+			continue
+		}
+
+		switch r.Op {
+		case opSet:
+			// late locations don't replace newer ones
+			if d, ok := drivers[r.ID]; !ok || d.Timestamp <= r.Timestamp {
+				drivers[r.ID] = &storage.Driver{
+					ID:           r.ID,
+					LastLocation: r.Location,
+					Attributes:   r.Attributes,
+					Status:       r.Status,
+					Timestamp:    r.Timestamp,
+				}
+			}
+		case opRemove:
+			if d, ok := drivers[r.ID]; ok && d.Timestamp <= r.Timestamp {
+				delete(drivers, r.ID)
+			}
+		case opStatus:
+			if d, ok := drivers[r.ID]; ok {
+				d.Status = r.Status
+			}
+		}
+	}
+}
+
+func segmentName(start int64) string {
+	return fmt.Sprintf("%020d%s", start, segmentExt)
+}
+
+// segments returns starts of segments in dir in ascending order
+func segments(dir string) ([]int64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read archive directory")
+	}
+	var starts []int64
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		start, err := strconv.ParseInt(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	return starts, nil
+}
@@ -0,0 +1,61 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	a, err := Open(dir, Options{Retention: time.Hour, Window: 10 * time.Minute})
+	assert.NoError(t, err)
+	db := storage.New(10, storage.WithObserver(a))
+
+	now := time.Now()
+	ts := func(ago time.Duration) int64 { return now.Add(-ago).UnixNano() }
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}, Timestamp: ts(30 * time.Minute)}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 2}, Timestamp: ts(20 * time.Minute)}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: 2, Lon: 2}, Timestamp: ts(25 * time.Minute)}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 3, LastLocation: storage.Location{Lat: 3, Lon: 3}, Timestamp: ts(time.Minute)}))
+	assert.NoError(t, db.SetStatus(3, storage.StatusBusy))
+	assert.NoError(t, db.Delete(2))
+
+	// locations later than the time are not played back
+	fleet, err := a.At(now.Add(-22 * time.Minute))
+	assert.NoError(t, err)
+	if assert.Len(t, fleet, 2) {
+		assert.Equal(t, storage.Location{Lat: 1, Lon: 1}, fleet[0].LastLocation)
+		assert.Equal(t, 2, fleet[1].ID)
+	}
+	// drivers without locations within the window are not in the fleet, removals are played back
+	fleet, err = a.At(time.Now())
+	assert.NoError(t, err)
+	if assert.Len(t, fleet, 1) {
+		assert.Equal(t, 3, fleet[0].ID)
+		assert.Equal(t, storage.StatusBusy, fleet[0].Status)
+	}
+
+	_, err = a.At(now.Add(2 * time.Hour))
+	assert.Equal(t, ErrInvalidTime, err)
+	_, err = a.At(now.Add(-2 * time.Hour))
+	assert.Equal(t, ErrInvalidTime, err)
+	assert.NoError(t, a.Close())
+
+	// segments of previous runs are played back and removed after the retention
+	a, err = Open(dir, Options{Retention: time.Hour, Window: 10 * time.Minute})
+	assert.NoError(t, err)
+	fleet, err = a.At(now.Add(-22 * time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, fleet, 2)
+	a.sweep(time.Now().Add(2 * time.Hour))
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	assert.Len(t, files, 1)
+	assert.NoError(t, a.Close())
+	_, err = os.Stat(files[0])
+	assert.NoError(t, err)
+}
@@ -0,0 +1,281 @@
+// Package backup keeps snapshots in an S3-compatible bucket, so new instances restore the latest ones
+package backup
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// emptyHash is SHA-256 of an empty payload
+const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// ErrObjectDoesNotExist sign what there is no object of the key
+var ErrObjectDoesNotExist = errors.New("Object does not exist")
+
+type (
+	// S3Config selects the bucket. Endpoint is the URL of the service, e.g. https://s3.eu-west-1.amazonaws.com
+	// or http://localhost:9000 of MinIO, buckets are addressed by path. SessionToken is set for temporary credentials.
+	S3Config struct {
+		Endpoint     string
+		Region       string
+		Bucket       string
+		AccessKey    string
+		SecretKey    string
+		SessionToken string
+	}
+
+	// S3 calls the object API signing requests with AWS Signature Version 4
+	S3 struct {
+		config S3Config
+		client *http.Client
+		now    func() time.Time
+	}
+
+	listResult struct {
+		Contents []struct {
+			Key string
+		}
+		CommonPrefixes []struct {
+			Prefix string
+		}
+		IsTruncated           bool
+		NextContinuationToken string
+	}
+)
+
+// NewS3 creates client of the bucket
+func NewS3(config S3Config) *S3 {
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &S3{config: config, client: http.DefaultClient, now: time.Now}
+}
+
+// Put uploads the object of size bytes, hash is hex SHA-256 of the body
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, hash string) error {
+	req, err := s.request(ctx, http.MethodPut, key, nil, body, hash)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get returns body of the object, the caller closes it
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil, nil, emptyHash)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes the object
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil, nil, emptyHash)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// List returns keys of objects with the prefix in order, and prefixes up to the next delimiter
+// if delimiter is not empty
+func (s *S3) List(ctx context.Context, prefix, delimiter string) (keys, prefixes []string, err error) {
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if delimiter != "" {
+			query.Set("delimiter", delimiter)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := s.request(ctx, http.MethodGet, "", query, nil, emptyHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		var result listResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "could not decode object list")
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		for _, p := range result.CommonPrefixes {
+			prefixes = append(prefixes, p.Prefix)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Strings(keys)
+	sort.Strings(prefixes)
+	return keys, prefixes, nil
+}
+
+// request creates signed request of the object of the key, the bucket if the key is empty
+func (s *S3) request(ctx context.Context, method, key string, query url.Values, body io.Reader, hash string) (*http.Request, error) {
+	u, err := url.Parse(s.config.Endpoint + "/" + s.config.Bucket)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid S3 endpoint")
+	}
+	if key != "" {
+		u.Path += "/" + key
+	}
+	// the path is sent as it's signed
+	u.RawPath = escapePath(u.Path)
+	u.RawQuery = encodeQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, hash)
+	return req, nil
+}
+
+func (s *S3) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound && req.Method == http.MethodGet && req.URL.RawQuery == "" {
+		resp.Body.Close()
+		return nil, ErrObjectDoesNotExist
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var e struct {
+			Code    string
+			Message string
+		}
+		xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&e)
+		return nil, errors.Errorf("S3 replied %s: %s %s", resp.Status, e.Code, e.Message)
+	}
+	return resp, nil
+}
+
+// sign sets the authorization header signing the host and all headers of the request
+func (s *S3) sign(req *http.Request, hash string) {
+	now := s.now().UTC()
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", hash)
+	if s.config.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.config.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signed,
+		hash,
+	}, "\n")
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.config.AccessKey+"/"+scope+
+		", SignedHeaders="+signed+", Signature="+signature)
+}
+
+// encodeQuery encodes the query sorted by key, as the canonical request needs
+func encodeQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// escapePath escapes the path with / kept
+func escapePath(path string) string {
+	return uriEncode(path, false)
+}
+
+// uriEncode escapes all bytes except unreserved characters, and slashes unless slash is set
+func uriEncode(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Snapshots uploads snapshot files under <prefix>/default/<unix milliseconds> for the default namespace
+// and <prefix>/ns/<namespace>/<unix milliseconds> for others, the latest keep of every namespace are kept.
+// The default namespace has an empty name.
+type Snapshots struct {
+	bucket *S3
+	prefix string
+	keep   int
+	now    func() time.Time
+}
+
+// NewSnapshots creates Snapshots stored under the prefix of the bucket
+func NewSnapshots(bucket *S3, prefix string, keep int) *Snapshots {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &Snapshots{bucket: bucket, prefix: prefix, keep: keep, now: time.Now}
+}
+
+// Upload uploads the snapshot file of the namespace and removes its old snapshots
+func (s *Snapshots) Upload(ctx context.Context, namespace, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "could not open snapshot")
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return errors.Wrap(err, "could not read snapshot")
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "could not read snapshot")
+	}
+
+	// zero padded, so keys are ordered by time
+	key := fmt.Sprintf("%s%020d", s.dir(namespace), s.now().UnixNano()/int64(time.Millisecond))
+	if err := s.bucket.Put(ctx, key, f, size, hex.EncodeToString(h.Sum(nil))); err != nil {
+		return errors.Wrap(err, "could not upload snapshot")
+	}
+
+	keys, _, err := s.bucket.List(ctx, s.dir(namespace), "")
+	if err != nil {
+		return errors.Wrap(err, "could not list snapshots")
+	}
+	for len(keys) > s.keep {
+		if err := s.bucket.Delete(ctx, keys[0]); err != nil {
+			return errors.Wrap(err, "could not remove old snapshot")
+		}
+		keys = keys[1:]
+	}
+	return nil
+}
+
+// Restore downloads the latest snapshot of the namespace to the path,
+// it returns false if the namespace has no snapshots
+func (s *Snapshots) Restore(ctx context.Context, namespace, path string) (bool, error) {
+	keys, _, err := s.bucket.List(ctx, s.dir(namespace), "")
+	if err != nil {
+		return false, errors.Wrap(err, "could not list snapshots")
+	}
+	if len(keys) == 0 {
+		return false, nil
+	}
+	body, err := s.bucket.Get(ctx, keys[len(keys)-1])
+	if err != nil {
+		return false, errors.Wrap(err, "could not download snapshot")
+	}
+	defer body.Close()
+
+	// the file is replaced atomically, so an interrupted download is not restored by the next start
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return false, errors.Wrap(err, "could not create snapshot file")
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, body); err != nil {
+		f.Close()
+		return false, errors.Wrap(err, "could not download snapshot")
+	}
+	if err := f.Close(); err != nil {
+		return false, errors.Wrap(err, "could not close snapshot")
+	}
+	return true, errors.Wrap(os.Rename(f.Name(), path), "could not replace snapshot")
+}
+
+// Namespaces returns namespaces having snapshots except the default one
+func (s *Snapshots) Namespaces(ctx context.Context) ([]string, error) {
+	_, prefixes, err := s.bucket.List(ctx, s.prefix+"ns/", "/")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list snapshots")
+	}
+	namespaces := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		namespaces[i] = strings.TrimSuffix(strings.TrimPrefix(p, s.prefix+"ns/"), "/")
+	}
+	return namespaces, nil
+}
+
+// dir returns the prefix of snapshots of the namespace
+func (s *Snapshots) dir(namespace string) string {
+	if namespace == "" {
+		return s.prefix + "default/"
+	}
+	return s.prefix + "ns/" + namespace + "/"
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kdrake/nearestdots/bench"
+)
+
+// benchCommand runs a benchmark against an instance, it returns the exit code
+func benchCommand(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: nearestdots bench [flags]\n\nSends location updates and nearest queries and reports their throughput and latency.\nUpdated drivers are left in the instance, they expire with its TTL.")
+		fs.PrintDefaults()
+	}
+	instance := clientFlags(fs)
+	workers := fs.Int("workers", 8, "Set number of concurrent clients")
+	duration := fs.Duration("duration", 10*time.Second, "Set how long the benchmark runs")
+	drivers := fs.Int("drivers", 1000, "Set number of updated drivers")
+	firstID := fs.Int("first_id", 1000000, "Set id of the first updated driver, the others follow it")
+	batch := fs.Int("batch", 10, "Set number of updates per request")
+	queryRatio := fs.Float64("query_ratio", 0.5, "Set share of requests which are nearest queries")
+	count := fs.Int("count", 10, "Set number of drivers of a nearest query")
+	center := fs.String("center", "42.874722,74.612222", "Set lat,lon of the center of the area of locations")
+	radius := fs.Float64("radius", 5000, "Set radius in meters of the area of locations")
+	seed := fs.Int64("seed", 0, "Set seed of random locations")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	c, err := parseLocation(*center)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid center:", err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	result := bench.Run(ctx, instance(), bench.Options{
+		Workers:    *workers,
+		Duration:   *duration,
+		Drivers:    *drivers,
+		FirstID:    *firstID,
+		BatchSize:  *batch,
+		QueryRatio: *queryRatio,
+		Count:      *count,
+		Center:     c,
+		Radius:     *radius,
+		Seed:       *seed,
+	})
+	failed := false
+	for _, op := range []struct {
+		name string
+		bench.Operation
+	}{{"updates", result.Updates}, {"queries", result.Queries}} {
+		fmt.Printf("%s: %d requests, %.1f/s, %d errors, p50 %s, p95 %s, p99 %s, max %s\n", op.name, op.Requests,
+			op.Rate(result.Duration), op.Errors, op.P50, op.P95, op.P99, op.Max)
+		if op.LastError != nil {
+			fmt.Fprintf(os.Stderr, "last failure of %s: %v\n", op.name, op.LastError)
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
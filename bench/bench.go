@@ -0,0 +1,175 @@
+// Package bench measures throughput and latency of an instance under a mix of location updates and nearest queries
+package bench
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kdrake/nearestdots/client"
+	"github.com/kdrake/nearestdots/storage"
+)
+
+type (
+	// Options of a benchmark. Workers send requests one after another for Duration, QueryRatio of them
+	// are nearest queries of Count drivers and the others update BatchSize random drivers of ids from FirstID
+	// to FirstID+Drivers-1. Locations are random within Radius meters around Center.
+	Options struct {
+		Workers    int
+		Duration   time.Duration
+		Drivers    int
+		FirstID    int
+		BatchSize  int
+		QueryRatio float64
+		Count      int
+		Center     storage.Location
+		Radius     float64
+		Seed       int64
+	}
+
+	// Result of a benchmark
+	Result struct {
+		Duration time.Duration
+		Updates  Operation
+		Queries  Operation
+	}
+
+	// Operation has counters and latency percentiles of successful requests of a kind
+	Operation struct {
+		Requests  int
+		Errors    int
+		P50       time.Duration
+		P95       time.Duration
+		P99       time.Duration
+		Max       time.Duration
+		LastError error
+	}
+
+	// target is the instance under the benchmark
+	target interface {
+		UpdateLocations(ctx context.Context, updates []client.LocationUpdate) error
+		Nearest(ctx context.Context, l client.Location, q client.NearestQuery) ([]client.NearestDriver, error)
+	}
+
+	// recorder collects latencies of a kind
+	recorder struct {
+		latencies []time.Duration
+		errors    int
+		lastError error
+	}
+)
+
+// Run runs the benchmark until the duration passes or ctx is done
+func Run(ctx context.Context, t target, opts Options) Result {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	if opts.Drivers <= 0 {
+		opts.Drivers = 1
+	}
+	if opts.FirstID <= 0 {
+		opts.FirstID = 1
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	if opts.Count <= 0 {
+		opts.Count = 10
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	start := time.Now()
+	updates := make([]recorder, opts.Workers)
+	queries := make([]recorder, opts.Workers)
+	var wg sync.WaitGroup
+	wg.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			work(ctx, t, opts, rand.New(rand.NewSource(opts.Seed+int64(i))), &updates[i], &queries[i])
+		}(i)
+	}
+	wg.Wait()
+	return Result{
+		Duration: time.Since(start),
+		Updates:  merge(updates),
+		Queries:  merge(queries),
+	}
+}
+
+// work sends requests until ctx is done, requests cancelled by the end of the benchmark are not recorded
+func work(ctx context.Context, t target, opts Options, r *rand.Rand, updates, queries *recorder) {
+	for ctx.Err() == nil {
+		var (
+			err error
+			rec *recorder
+		)
+		started := time.Now()
+		if r.Float64() < opts.QueryRatio {
+			rec = queries
+			l := randomLocation(r, opts.Center, opts.Radius)
+			_, err = t.Nearest(ctx, client.Location{Lat: l.Lat, Lon: l.Lon}, client.NearestQuery{Count: opts.Count})
+		} else {
+			rec = updates
+			batch := make([]client.LocationUpdate, opts.BatchSize)
+			for i := range batch {
+				l := randomLocation(r, opts.Center, opts.Radius)
+				batch[i] = client.LocationUpdate{DriverID: opts.FirstID + r.Intn(opts.Drivers), Location: client.Location{Lat: l.Lat, Lon: l.Lon}}
+			}
+			err = t.UpdateLocations(ctx, batch)
+		}
+		elapsed := time.Since(started)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			rec.errors++
+			rec.lastError = err
+			continue
+		}
+		rec.latencies = append(rec.latencies, elapsed)
+	}
+}
+
+// randomLocation returns location uniformly distributed within the circle
+func randomLocation(r *rand.Rand, center storage.Location, radius float64) storage.Location {
+	return storage.Destination(center, r.Float64()*360, radius*math.Sqrt(r.Float64()))
+}
+
+// merge returns operation of recorders of all workers
+func merge(recorders []recorder) Operation {
+	var (
+		op        Operation
+		latencies []time.Duration
+	)
+	for _, rec := range recorders {
+		latencies = append(latencies, rec.latencies...)
+		op.Errors += rec.errors
+		if rec.lastError != nil {
+			op.LastError = rec.lastError
+		}
+	}
+	op.Requests = len(latencies) + op.Errors
+	if len(latencies) == 0 {
+		return op
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p float64) time.Duration {
+		return latencies[int(math.Ceil(p*float64(len(latencies))))-1]
+	}
+	op.P50, op.P95, op.P99 = percentile(0.5), percentile(0.95), percentile(0.99)
+	op.Max = latencies[len(latencies)-1]
+	return op
+}
+
+// Rate returns requests per second of the operation during the duration
+func (o Operation) Rate(d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(o.Requests) / d.Seconds()
+}
@@ -0,0 +1,74 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/api"
+	"github.com/kdrake/nearestdots/client"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// failing fails every other update
+type failing struct {
+	updates int32
+}
+
+func (f *failing) UpdateLocations(context.Context, []client.LocationUpdate) error {
+	if atomic.AddInt32(&f.updates, 1)%2 == 0 {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func (f *failing) Nearest(context.Context, client.Location, client.NearestQuery) ([]client.NearestDriver, error) {
+	return nil, nil
+}
+
+func TestRun(t *testing.T) {
+	db := storage.New(10)
+	server := httptest.NewServer(api.New(":0", storage.NewManager(db, nil), nil))
+	defer server.Close()
+
+	center := storage.Location{Lat: 42.874722, Lon: 74.612222}
+	result := Run(context.Background(), client.New(server.URL), Options{
+		Workers:    4,
+		Duration:   200 * time.Millisecond,
+		Drivers:    50,
+		BatchSize:  5,
+		QueryRatio: 0.5,
+		Center:     center,
+		Radius:     1000,
+	})
+	assert.True(t, result.Updates.Requests > 0)
+	assert.True(t, result.Queries.Requests > 0)
+	assert.Zero(t, result.Updates.Errors+result.Queries.Errors)
+	assert.True(t, result.Updates.P50 <= result.Updates.P99 && result.Updates.P99 <= result.Updates.Max)
+	assert.True(t, result.Duration >= 200*time.Millisecond)
+	assert.True(t, db.Len() > 0 && db.Len() <= 50)
+	for _, d := range db.List(0, 50) {
+		assert.True(t, storage.Distance(center, d.LastLocation) <= 1000.001)
+	}
+
+	f := &failing{}
+	result = Run(context.Background(), f, Options{Duration: 20 * time.Millisecond})
+	assert.Zero(t, result.Queries.Requests)
+	assert.InDelta(t, result.Updates.Requests/2, result.Updates.Errors, 1)
+	assert.EqualError(t, result.Updates.LastError, "failed")
+}
+
+func TestMerge(t *testing.T) {
+	var rec recorder
+	for i := 1; i <= 100; i++ {
+		rec.latencies = append(rec.latencies, time.Duration(i))
+	}
+	op := merge([]recorder{rec, {errors: 2}})
+	assert.Equal(t, Operation{Requests: 102, Errors: 2, P50: 50, P95: 95, P99: 99, Max: 100}, op)
+	assert.Equal(t, 51.0, op.Rate(2*time.Second))
+	assert.Equal(t, Operation{}, merge(nil))
+}
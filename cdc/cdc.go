@@ -0,0 +1,141 @@
+// Package cdc publishes changes of the storage as a feed of sequenced events
+package cdc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"go.uber.org/zap"
+)
+
+// Operations of changes
+const (
+	Set    = "set"
+	Delete = "delete"
+	Expire = "expire"
+	Status = "status"
+)
+
+// publishTimeout limits publishing of a batch
+const publishTimeout = 10 * time.Second
+
+// maxBatch is how many queued changes are published at once
+const maxBatch = 100
+
+type (
+	// Change is an accepted change of a driver. Seq increases by one with every change of the epoch,
+	// a new epoch starts when the service restarts, so consumers order changes by epoch and seq.
+	// A gap of seq means changes were dropped. Timestamp is unix nanoseconds of the location
+	// or of the change if the driver has no new location.
+	Change struct {
+		Epoch     int64             `json:"epoch"`
+		Seq       uint64            `json:"seq"`
+		Op        string            `json:"op"`
+		DriverID  int               `json:"driver_id"`
+		Location  *storage.Location `json:"location,omitempty"`
+		Status    storage.Status    `json:"status,omitempty"`
+		Timestamp int64             `json:"timestamp"`
+	}
+
+	// Sink publishes batches of changes in order
+	Sink interface {
+		Publish(ctx context.Context, changes []Change) error
+		Close() error
+	}
+
+	// Feed observes the storage and publishes its changes to the sink.
+	// Changes are queued, they are dropped while the queue is full, so the storage never waits for the sink.
+	Feed struct {
+		mu      sync.Mutex
+		epoch   int64
+		seq     uint64
+		sink    Sink
+		pending chan Change
+		done    chan struct{}
+	}
+)
+
+var _ storage.LifecycleObserver = (*Feed)(nil)
+
+// New creates Feed queueing up to buffer changes
+func New(sink Sink, buffer int) *Feed {
+	f := &Feed{
+		epoch:   time.Now().UnixNano(),
+		sink:    sink,
+		pending: make(chan Change, buffer),
+		done:    make(chan struct{}),
+	}
+	go f.publish()
+	return f
+}
+
+// Close publishes queued changes and closes the sink
+func (f *Feed) Close() error {
+	close(f.pending)
+	<-f.done
+	return f.sink.Close()
+}
+
+// DriverMoved queues set changes
+func (f *Feed) DriverMoved(id int, location storage.Location, ts int64) {
+	f.send(Change{Op: Set, DriverID: id, Location: &location, Timestamp: ts})
+}
+
+// DriverRemoved queues delete changes
+func (f *Feed) DriverRemoved(id int) {
+	f.send(Change{Op: Delete, DriverID: id, Timestamp: time.Now().UnixNano()})
+}
+
+// DriverExpired queues expire changes
+func (f *Feed) DriverExpired(id int) {
+	f.send(Change{Op: Expire, DriverID: id, Timestamp: time.Now().UnixNano()})
+}
+
+// DriverStatusChanged queues status changes
+func (f *Feed) DriverStatusChanged(id int, status storage.Status) {
+	f.send(Change{Op: Status, DriverID: id, Status: status, Timestamp: time.Now().UnixNano()})
+}
+
+// DriverAppeared does nothing, the set change follows
+func (f *Feed) DriverAppeared(int, storage.Location, int64) {}
+
+// send numbers the change, it's called under the storage lock, so changes are numbered in order they are made
+func (f *Feed) send(c Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	c.Epoch, c.Seq = f.epoch, f.seq
+	select {
+	case f.pending <- c:
+	default:
+		zap.L().Warn("change feed queue is full, change dropped", zap.Uint64("seq", c.Seq))
+	}
+}
+
+// publish sends queued changes in batches of up to maxBatch until the queue is closed
+func (f *Feed) publish() {
+	defer close(f.done)
+	batch := make([]Change, 0, maxBatch)
+	for c := range f.pending {
+		batch = append(batch[:0], c)
+	collect:
+		for len(batch) < maxBatch {
+			select {
+			case c, ok := <-f.pending:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, c)
+			default:
+				break collect
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+		if err := f.sink.Publish(ctx, batch); err != nil {
+			zap.L().Error("could not publish changes", zap.Uint64("from", batch[0].Seq), zap.Int("count", len(batch)), zap.Error(err))
+		}
+		cancel()
+	}
+}
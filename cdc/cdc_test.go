@@ -0,0 +1,61 @@
+package cdc
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+type memorySink struct {
+	mu      sync.Mutex
+	changes []Change
+	closed  bool
+}
+
+func (s *memorySink) Publish(_ context.Context, changes []Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changes = append(s.changes, changes...)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestFeed(t *testing.T) {
+	sink := &memorySink{}
+	f := New(sink, 100)
+	db := storage.New(10, storage.WithObserver(f))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 2}, Timestamp: 1}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: 1, Lon: 2}, Timestamp: 1, Expiration: 1}))
+	assert.NoError(t, db.SetStatus(1, storage.StatusBusy))
+	assert.NoError(t, db.Delete(1))
+	db.DeleteExpired()
+	assert.NoError(t, f.Close())
+
+	assert.True(t, sink.closed)
+	assert.Len(t, sink.changes, 5)
+	ops := make([]string, len(sink.changes))
+	for i, c := range sink.changes {
+		assert.Equal(t, uint64(i+1), c.Seq)
+		assert.Equal(t, f.epoch, c.Epoch)
+		ops[i] = c.Op
+	}
+	assert.Equal(t, []string{Set, Set, Status, Delete, Expire}, ops)
+	assert.Equal(t, &storage.Location{Lat: 1, Lon: 2}, sink.changes[0].Location)
+	assert.Equal(t, storage.StatusBusy, sink.changes[2].Status)
+	assert.Equal(t, 2, sink.changes[4].DriverID)
+}
+
+func TestFeedDropsWhenFull(t *testing.T) {
+	f := &Feed{pending: make(chan Change, 1)}
+	f.DriverRemoved(1)
+	f.DriverRemoved(2)
+	assert.Len(t, f.pending, 1)
+	assert.Equal(t, uint64(2), f.seq)
+}
@@ -0,0 +1,76 @@
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+type (
+	// KafkaSink writes changes to the topic keyed by driver id,
+	// so changes of a driver keep their order in its partition
+	KafkaSink struct {
+		writer *kafka.Writer
+	}
+
+	// NATSSink publishes changes to the subject
+	NATSSink struct {
+		conn    *nats.Conn
+		subject string
+	}
+)
+
+// NewKafkaSink creates sink writing to the topic of the brokers
+func NewKafkaSink(brokers []string, topic string) *KafkaSink {
+	return &KafkaSink{writer: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}}
+}
+
+// Publish writes the changes
+func (s *KafkaSink) Publish(ctx context.Context, changes []Change) error {
+	msgs := make([]kafka.Message, len(changes))
+	for i, c := range changes {
+		value, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		msgs[i] = kafka.Message{Key: []byte(strconv.Itoa(c.DriverID)), Value: value}
+	}
+	return s.writer.WriteMessages(ctx, msgs...)
+}
+
+// Close flushes and closes the writer
+func (s *KafkaSink) Close() error {
+	return s.writer.Close()
+}
+
+// NewNATSSink creates sink publishing to the subject, the connection is closed by its owner
+func NewNATSSink(conn *nats.Conn, subject string) *NATSSink {
+	return &NATSSink{conn: conn, subject: subject}
+}
+
+// Publish publishes the changes and waits until the server has processed them
+func (s *NATSSink) Publish(ctx context.Context, changes []Change) error {
+	for _, c := range changes {
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if err := s.conn.Publish(s.subject, data); err != nil {
+			return err
+		}
+	}
+	return s.conn.FlushWithContext(ctx)
+}
+
+// Close flushes published changes
+func (s *NATSSink) Close() error {
+	return s.conn.Flush()
+}
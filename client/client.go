@@ -0,0 +1,301 @@
+// Package client calls v2 routes of the nearestdots HTTP API
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Statuses of drivers
+const (
+	StatusAvailable = "available"
+	StatusBusy      = "busy"
+	StatusOffline   = "offline"
+)
+
+type (
+	// Client calls the API at the base URL, e.g. http://localhost:8080
+	Client struct {
+		baseURL   string
+		http      *http.Client
+		apiKey    string
+		token     string
+		namespace string
+	}
+
+	// Option configures Client
+	Option func(*Client)
+
+	Location struct {
+		Lat float64 `json:"lat"`
+		Lon float64 `json:"lon"`
+	}
+
+	// LocationUpdate is a location of a driver, Timestamp in unix nanoseconds is when it was taken,
+	// the server time if not set. TTL in seconds overrides the default expiration, attributes and status
+	// replace the driver's ones if set.
+	LocationUpdate struct {
+		DriverID   int               `json:"driver_id"`
+		Location   Location          `json:"location"`
+		Timestamp  *int64            `json:"timestamp,omitempty"`
+		TTL        int64             `json:"ttl,omitempty"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+		Status     string            `json:"status,omitempty"`
+	}
+
+	// Driver is the last known state of a driver, Timestamp is unix nanoseconds of the location
+	// and IdleSince is unix nanoseconds since the driver is available and standing
+	Driver struct {
+		ID            int               `json:"id"`
+		Location      Location          `json:"location"`
+		Attributes    map[string]string `json:"attributes,omitempty"`
+		Status        string            `json:"status"`
+		Speed         float64           `json:"speed"`
+		Heading       float64           `json:"heading"`
+		Timestamp     int64             `json:"timestamp"`
+		ReservedUntil int64             `json:"reserved_until,omitempty"`
+		IdleSince     int64             `json:"idle_since,omitempty"`
+		OffShift      bool              `json:"off_shift,omitempty"`
+		Anomalies     int               `json:"anomalies,omitempty"`
+		AnomalyAt     int64             `json:"anomaly_at,omitempty"`
+	}
+
+	// NearestDriver is a driver with distance in meters to the point, ETA and age of its location
+	NearestDriver struct {
+		Driver
+		Distance   float64 `json:"distance"`
+		ETASeconds float64 `json:"eta_seconds"`
+		AgeSeconds float64 `json:"age_seconds"`
+	}
+
+	// NearestQuery selects up to Count nearest drivers, the server default if not set.
+	// Only available drivers are returned unless IncludeUnavailable is set,
+	// drivers must have all the attributes.
+	NearestQuery struct {
+		Count              int
+		IncludeUnavailable bool
+		Attributes         map[string]string
+	}
+
+	// FieldError tells why a field of the request is invalid
+	FieldError struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	}
+
+	// Error is a failed request, Code is the error code of the API, e.g. driver_not_found or invalid_coordinates
+	Error struct {
+		Status    int          `json:"-"`
+		Code      string       `json:"code"`
+		Message   string       `json:"message"`
+		Details   []FieldError `json:"details"`
+		RequestID string       `json:"request_id"`
+	}
+)
+
+// New creates Client of the API at the base URL
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithHTTPClient sends requests with the client instead of http.DefaultClient
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Client) {
+		c.http = client
+	}
+}
+
+// WithAPIKey authenticates requests with the API key
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithToken authenticates requests with the JWT bearer token
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithNamespace sends requests to drivers of the namespace instead of the default one
+func WithNamespace(namespace string) Option {
+	return func(c *Client) {
+		c.namespace = namespace
+	}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("nearestdots: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// UpdateLocation sets location of the driver, the driver is added if it's new
+func (c *Client) UpdateLocation(ctx context.Context, u LocationUpdate) error {
+	return c.do(ctx, http.MethodPost, "/driver/", nil, u, nil)
+}
+
+// UpdateLocations sets locations of many drivers in one request
+func (c *Client) UpdateLocations(ctx context.Context, updates []LocationUpdate) error {
+	return c.do(ctx, http.MethodPost, "/drivers/locations", nil, updates, nil)
+}
+
+// Snapshot writes snapshot of drivers to w, it's a snapshot file of the instance
+func (c *Client) Snapshot(ctx context.Context, w io.Writer) error {
+	resp, err := c.send(ctx, http.MethodGet, "/snapshot", nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Restore replaces drivers by the snapshot read from r
+func (c *Client) Restore(ctx context.Context, r io.Reader) error {
+	resp, err := c.send(ctx, http.MethodPut, "/snapshot", nil, r, "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// GetDriver returns the driver, the error has driver_not_found code if there is no such driver
+func (c *Client) GetDriver(ctx context.Context, id int) (*Driver, error) {
+	var resp struct {
+		Driver *Driver `json:"driver"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/driver/"+strconv.Itoa(id), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Driver, nil
+}
+
+// DeleteDriver removes the driver
+func (c *Client) DeleteDriver(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/driver/"+strconv.Itoa(id), nil, nil, nil)
+}
+
+// SetStatus changes status of the driver
+func (c *Client) SetStatus(ctx context.Context, id int, status string) error {
+	body := struct {
+		Status string `json:"status"`
+	}{status}
+	return c.do(ctx, http.MethodPut, "/driver/"+strconv.Itoa(id)+"/status", nil, body, nil)
+}
+
+// Nearest returns drivers nearest to the location ordered by distance or travel time
+func (c *Client) Nearest(ctx context.Context, l Location, q NearestQuery) ([]NearestDriver, error) {
+	var resp struct {
+		Drivers []NearestDriver `json:"drivers"`
+	}
+	path := "/driver/" + formatFloat(l.Lat) + "/" + formatFloat(l.Lon) + "/nearest"
+	if err := c.do(ctx, http.MethodGet, path, q.values(), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Drivers, nil
+}
+
+func (q NearestQuery) values() url.Values {
+	v := url.Values{}
+	if q.Count > 0 {
+		v.Set("count", strconv.Itoa(q.Count))
+	}
+	if q.IncludeUnavailable {
+		v.Set("include_unavailable", "true")
+	}
+	for k, value := range q.Attributes {
+		v.Add("attr", k+":"+value)
+	}
+	return v
+}
+
+// do sends the request with JSON body if it's not nil and decodes JSON response into out if it's not nil
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var r io.Reader
+	contentType := ""
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r, contentType = bytes.NewReader(b), "application/json"
+	}
+	resp, err := c.send(ctx, method, path, query, r, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send sends the request with the body of the content type if it's not nil,
+// the caller closes body of the successful response
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := c.baseURL + "/v2" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	c.authorize(req.Header)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
+
+// authorize sets headers of the API key, the token and the namespace
+func (c *Client) authorize(h http.Header) {
+	if c.apiKey != "" {
+		h.Set("X-API-Key", c.apiKey)
+	}
+	if c.token != "" {
+		h.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.namespace != "" {
+		h.Set("X-Namespace", c.namespace)
+	}
+}
+
+// responseError decodes the error of the response, Message is the status text if it's not an API error
+func responseError(resp *http.Response) error {
+	e := &Error{Status: resp.StatusCode}
+	if err := json.NewDecoder(resp.Body).Decode(e); err != nil || e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/api"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/kdrake/nearestdots/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	hub := stream.NewHub(10)
+	db := storage.New(10, storage.WithObserver(hub))
+	keys := api.Keys{"driver-key": api.RoleDriver, "dispatcher-key": api.RoleDispatcher}
+	server := httptest.NewServer(api.New(":0", storage.NewManager(db, nil), nil, api.WithStream(hub), api.WithAPIKeys(keys)))
+	defer server.Close()
+	driver := New(server.URL, WithAPIKey("driver-key"))
+	dispatcher := New(server.URL, WithAPIKey("dispatcher-key"))
+	ctx := context.Background()
+
+	sub, err := dispatcher.Subscribe(ctx, StreamFilter{IDs: []int{2}})
+	assert.NoError(t, err)
+	defer sub.Close()
+
+	assert.NoError(t, driver.UpdateLocation(ctx, LocationUpdate{DriverID: 1, Location: Location{Lat: 1, Lon: 1}, Attributes: map[string]string{"class": "van"}}))
+	assert.NoError(t, driver.UpdateLocations(ctx, []LocationUpdate{
+		{DriverID: 2, Location: Location{Lat: 1.01, Lon: 1}},
+		{DriverID: 3, Location: Location{Lat: 1.02, Lon: 1}, Status: StatusBusy},
+	}))
+
+	d, err := dispatcher.GetDriver(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, Location{Lat: 1, Lon: 1}, d.Location)
+	assert.Equal(t, StatusAvailable, d.Status)
+
+	nearest, err := dispatcher.Nearest(ctx, Location{Lat: 1.02, Lon: 1}, NearestQuery{Count: 2})
+	assert.NoError(t, err)
+	if assert.Len(t, nearest, 2) {
+		assert.Equal(t, 2, nearest[0].ID)
+		assert.InDelta(t, 1112, nearest[0].Distance, 1)
+	}
+	nearest, err = dispatcher.Nearest(ctx, Location{Lat: 1.02, Lon: 1}, NearestQuery{IncludeUnavailable: true, Attributes: map[string]string{"class": "van"}})
+	assert.NoError(t, err)
+	if assert.Len(t, nearest, 1) {
+		assert.Equal(t, 1, nearest[0].ID)
+	}
+
+	select {
+	case u := <-sub.Updates():
+		assert.Equal(t, Moved, u.Type)
+		assert.Equal(t, 2, u.DriverID)
+	case <-time.After(time.Second):
+		t.Fatal("no update streamed")
+	}
+
+	assert.NoError(t, driver.SetStatus(ctx, 1, StatusOffline))
+	assert.NoError(t, driver.DeleteDriver(ctx, 1))
+	_, err = dispatcher.GetDriver(ctx, 1)
+	if assert.IsType(t, &Error{}, err) {
+		assert.Equal(t, 404, err.(*Error).Status)
+		assert.Equal(t, "driver_not_found", err.(*Error).Code)
+	}
+	_, err = dispatcher.Nearest(ctx, Location{Lat: 91}, NearestQuery{})
+	if assert.IsType(t, &Error{}, err) {
+		assert.Equal(t, "invalid_coordinates", err.(*Error).Code)
+		assert.NotEmpty(t, err.(*Error).Details)
+	}
+	_, err = driver.GetDriver(ctx, 2)
+	if assert.IsType(t, &Error{}, err) {
+		assert.Equal(t, "forbidden", err.(*Error).Code)
+	}
+	_, err = New(server.URL).Subscribe(ctx, StreamFilter{})
+	if assert.IsType(t, &Error{}, err) {
+		assert.Equal(t, "unauthenticated", err.(*Error).Code)
+	}
+
+	assert.NoError(t, sub.Close())
+	_, open := <-sub.Updates()
+	assert.False(t, open)
+	assert.NoError(t, sub.Err())
+}
+
+func TestSnapshot(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	server := httptest.NewServer(api.New(":0", storage.NewManager(db, nil), nil))
+	defer server.Close()
+	c := New(server.URL)
+	ctx := context.Background()
+
+	var snapshot bytes.Buffer
+	assert.NoError(t, c.Snapshot(ctx, &snapshot))
+	assert.NoError(t, db.Delete(1))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: 2, Lon: 2}}))
+
+	assert.NoError(t, c.Restore(ctx, bytes.NewReader(snapshot.Bytes())))
+	_, err := db.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, db.Len())
+
+	err = c.Restore(ctx, strings.NewReader("garbage"))
+	if assert.IsType(t, &Error{}, err) {
+		assert.Equal(t, "invalid_request", err.(*Error).Code)
+	}
+	assert.Equal(t, 1, db.Len())
+}
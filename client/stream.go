@@ -0,0 +1,172 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Update types
+const (
+	Moved   = "moved"
+	Removed = "removed"
+)
+
+type (
+	// Update is a driver location change or removal, Timestamp is unix nanoseconds of the location
+	Update struct {
+		Type      string    `json:"type"`
+		DriverID  int       `json:"driver_id"`
+		Location  *Location `json:"location,omitempty"`
+		Timestamp int64     `json:"timestamp,omitempty"`
+	}
+
+	// StreamFilter selects updates of drivers inside the bounding box (min lat, min lon, max lat, max lon)
+	// or drivers with the ids. Empty filter selects all drivers.
+	StreamFilter struct {
+		Box *[4]float64
+		IDs []int
+	}
+
+	// Subscription receives updates until it's closed or the connection is lost
+	Subscription struct {
+		conn    *websocket.Conn
+		updates chan Update
+		done    chan struct{}
+		close   sync.Once
+		err     error
+	}
+)
+
+// Subscribe streams updates of drivers selected by the filter over a websocket
+func (c *Client) Subscribe(ctx context.Context, f StreamFilter) (*Subscription, error) {
+	u, err := url.Parse(c.baseURL + "/v2/ws")
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	u.RawQuery = f.values().Encode()
+
+	h := http.Header{}
+	c.authorize(h)
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), h)
+	if err != nil {
+		if resp != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+			defer resp.Body.Close()
+			return nil, responseError(resp)
+		}
+		return nil, err
+	}
+
+	s := &Subscription{
+		conn:    conn,
+		updates: make(chan Update),
+		done:    make(chan struct{}),
+	}
+	go s.read()
+	return s, nil
+}
+
+func (f StreamFilter) values() url.Values {
+	v := url.Values{}
+	if f.Box != nil {
+		v.Set("min_lat", formatFloat(f.Box[0]))
+		v.Set("min_lon", formatFloat(f.Box[1]))
+		v.Set("max_lat", formatFloat(f.Box[2]))
+		v.Set("max_lon", formatFloat(f.Box[3]))
+	}
+	if len(f.IDs) > 0 {
+		ids := make([]string, len(f.IDs))
+		for i, id := range f.IDs {
+			ids[i] = strconv.Itoa(id)
+		}
+		v.Set("ids", strings.Join(ids, ","))
+	}
+	return v
+}
+
+// Updates returns the channel of updates, it's closed when the subscription ends
+func (s *Subscription) Updates() <-chan Update {
+	return s.updates
+}
+
+// Err returns why the subscription ended, nil if it was closed. Call it after Updates is closed.
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() error {
+	var err error
+	s.close.Do(func() {
+		close(s.done)
+		err = s.conn.Close()
+	})
+	return err
+}
+
+func (s *Subscription) read() {
+	defer close(s.updates)
+	for {
+		var u Update
+		if err := s.conn.ReadJSON(&u); err != nil {
+			select {
+			case <-s.done:
+			default:
+				s.err = err
+				s.conn.Close()
+			}
+			return
+		}
+		select {
+		case s.updates <- u:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// ChangeStream is the gob stream of replication changes after Seq of the Epoch of the primary,
+// it starts with a snapshot if it was requested without position
+type ChangeStream struct {
+	io.ReadCloser
+	Epoch int64
+	Seq   uint64
+}
+
+// Changes streams replication changes of the default namespace after the position,
+// zero epoch requests a snapshot first. The error has position_lost code if the primary
+// doesn't have the changes anymore.
+func (c *Client) Changes(ctx context.Context, epoch int64, after uint64) (*ChangeStream, error) {
+	query := url.Values{}
+	if epoch != 0 {
+		query.Set("epoch", strconv.FormatInt(epoch, 10))
+		query.Set("after", strconv.FormatUint(after, 10))
+	}
+	resp, err := c.send(ctx, http.MethodGet, "/replication/changes", query, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	s := &ChangeStream{ReadCloser: resp.Body}
+	s.Epoch, err = strconv.ParseInt(resp.Header.Get("X-Replication-Epoch"), 10, 64)
+	if err == nil {
+		s.Seq, err = strconv.ParseUint(resp.Header.Get("X-Replication-Seq"), 10, 64)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("nearestdots: invalid replication position: %v", err)
+	}
+	return s, nil
+}
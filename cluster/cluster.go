@@ -0,0 +1,305 @@
+// Package cluster partitions drivers across nodes by consistent hashing of their ids.
+// Writes are forwarded to the owner node, nearest and other spatial queries are fanned out to all nodes and merged.
+// Nodes are a static list or are discovered by gossip.
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Path prefixes requests of peers, they are served by Cluster
+const Path = "/cluster/"
+
+// keyHeader has the cluster key of a peer request
+const keyHeader = "X-Cluster-Key"
+
+// mimeGob is content type of peer requests and responses
+const mimeGob = "application/x-gob"
+
+// defaultTimeout limits a request to a peer
+const defaultTimeout = 2 * time.Second
+
+var (
+	// ErrNotInCluster sign what the node is not one of the cluster nodes
+	ErrNotInCluster = errors.New("Node is not in the cluster")
+	// ErrNoKey sign what the cluster key is empty, peer requests would be unauthorized
+	ErrNoKey = errors.New("Cluster key is not set")
+	// ErrUnknownOperation sign what a peer requested an operation this node doesn't serve
+	ErrUnknownOperation = errors.New("Unknown cluster operation")
+	// ErrReservationsUnsupported sign what storage of the node can't reserve drivers by id
+	ErrReservationsUnsupported = errors.New("Storage does not support reservations")
+	// ErrVersionsUnsupported sign what storage of the node doesn't keep versions of drivers
+	ErrVersionsUnsupported = errors.New("Storage does not support conditional updates")
+)
+
+// remoteErrors are errors restored from messages of peers, so the API replies with codes of storage errors
+var remoteErrors = []error{
+	ErrUnknownOperation,
+	ErrReservationsUnsupported,
+	ErrVersionsUnsupported,
+	storage.ErrDriverDoesNotExist,
+	storage.ErrStaleLocation,
+	storage.ErrVersionMismatch,
+	storage.ErrInvalidLocation,
+	storage.ErrInvalidBoundingBox,
+	storage.ErrInvalidPolygon,
+	storage.ErrInvalidStatus,
+	storage.ErrThrottled,
+	storage.ErrImpossibleSpeed,
+	storage.ErrInvalidNamespace,
+	storage.ErrNamespacesDisabled,
+	storage.ErrTooManyNamespaces,
+}
+
+type (
+	// Option configures Cluster
+	Option func(*Cluster)
+
+	// Cluster is a node of the cluster, it serves requests of peers with the local storages
+	// and its storages forward requests to peers
+	Cluster struct {
+		self       string
+		mu         sync.RWMutex
+		nodes      []string
+		ring       *Ring
+		gossip     *gossip
+		replicas   int
+		key        string
+		client     *http.Client
+		locals     *storage.Manager
+		namespaces *storage.Manager
+	}
+)
+
+// WithTimeout limits requests to peers, 2s if not set
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Cluster) {
+		c.client.Timeout = timeout
+	}
+}
+
+// WithReplicas sets number of points of a node on the ring, all nodes must use the same number
+func WithReplicas(replicas int) Option {
+	return func(c *Cluster) {
+		c.replicas = replicas
+	}
+}
+
+// New creates node self of the cluster of nodes, they are base URLs of their APIs and all nodes
+// must list the same ones. With gossip nodes are seeds and the node starts alone until it reaches one.
+// Drivers owned by this node are kept in storages of locals, peers authorize each other by the key.
+func New(self string, nodes []string, key string, locals *storage.Manager, opts ...Option) (*Cluster, error) {
+	c := &Cluster{
+		self:   strings.TrimSuffix(self, "/"),
+		key:    key,
+		client: &http.Client{Timeout: defaultTimeout},
+		locals: locals,
+	}
+	var seeds []string
+	for _, node := range nodes {
+		seeds = append(seeds, strings.TrimSuffix(node, "/"))
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if key == "" {
+		return nil, ErrNoKey
+	}
+	if c.gossip != nil {
+		c.setNodes([]string{c.self})
+	} else {
+		found := false
+		for _, node := range seeds {
+			found = found || node == c.self
+		}
+		if !found {
+			return nil, ErrNotInCluster
+		}
+		c.setNodes(seeds)
+	}
+
+	def, err := c.storage("")
+	if err != nil {
+		return nil, err
+	}
+	c.namespaces = storage.NewManager(def, func(namespace string) (storage.Storage, error) {
+		return c.storage(namespace)
+	})
+	// namespaces restored on start are expired by the janitor of the cluster storages too
+	for _, namespace := range locals.Namespaces() {
+		if _, err := c.namespaces.Namespace(namespace); err != nil {
+			return nil, err
+		}
+	}
+	if c.gossip != nil {
+		c.gossip.start(seeds)
+	}
+	return c, nil
+}
+
+// Close stops gossip and tells peers the node left, static clusters have nothing to close
+func (c *Cluster) Close() error {
+	if c.gossip != nil {
+		c.gossip.stop()
+	}
+	return nil
+}
+
+// Namespaces returns manager of cluster storages of namespaces
+func (c *Cluster) Namespaces() *storage.Manager {
+	return c.namespaces
+}
+
+// Owner returns node of the driver
+func (c *Cluster) Owner(id int) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.Owner(id)
+}
+
+// Nodes returns alive nodes
+func (c *Cluster) Nodes() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.nodes
+}
+
+// Members returns nodes known by gossip with their health, nodes of static clusters are always alive
+func (c *Cluster) Members() []Member {
+	if c.gossip != nil {
+		return c.gossip.list()
+	}
+	nodes := c.Nodes()
+	members := make([]Member, len(nodes))
+	for i, node := range nodes {
+		members[i] = Member{Node: node, Alive: true}
+	}
+	members[sort.SearchStrings(nodes, c.self)].Drivers = c.drivers()
+	return members
+}
+
+// setNodes rebuilds the ring if the nodes changed, drivers of other nodes are moved as they are set again
+func (c *Cluster) setNodes(nodes []string) {
+	sort.Strings(nodes)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if strings.Join(nodes, ",") == strings.Join(c.nodes, ",") {
+		return
+	}
+	if c.ring != nil {
+		zap.L().Info("cluster nodes changed", zap.Strings("nodes", nodes))
+	}
+	c.nodes = nodes
+	c.ring = NewRing(nodes, c.replicas)
+}
+
+// drivers returns number of drivers of this node
+func (c *Cluster) drivers() int {
+	n := 0
+	c.locals.Each(func(_ string, s storage.Storage) {
+		n += s.Len()
+	})
+	return n
+}
+
+// storage creates cluster storage of the namespace
+func (c *Cluster) storage(namespace string) (*Storage, error) {
+	local, err := c.locals.Namespace(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return &Storage{cluster: c, namespace: namespace, local: local}, nil
+}
+
+// ServeHTTP serves requests of peers with the local storages
+func (c *Cluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(keyHeader)), []byte(c.key)) != 1 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	var req request
+	if err := gob.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// storages of namespaces requested by peers are created by the manager, so the janitor expires them
+	res := &response{}
+	var err error
+	switch op := strings.TrimPrefix(r.URL.Path, Path); {
+	case op != opGossip:
+		var s storage.Storage
+		if s, err = c.namespaces.Namespace(req.Namespace); err == nil {
+			res, err = handle(s.(*Storage).local, op, &req)
+		}
+	case c.gossip != nil:
+		c.gossip.merge(req.Members)
+		res.Members = c.gossip.states()
+	default:
+		err = ErrUnknownOperation
+	}
+	if err != nil {
+		res = &response{Error: errors.Cause(err).Error()}
+	}
+	w.Header().Set("Content-Type", mimeGob)
+	gob.NewEncoder(w).Encode(res)
+}
+
+// call requests the operation of the peer
+func (c *Cluster) call(node, op string, req *request) (*response, error) {
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(req); err != nil {
+		return nil, errors.Wrap(err, "could not encode request")
+	}
+	r, err := http.NewRequestWithContext(context.Background(), http.MethodPost, node+Path+op, &body)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", mimeGob)
+	r.Header.Set(keyHeader, c.key)
+	resp, err := c.client.Do(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not call %s", node)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, errors.Errorf("%s replied %d: %s", node, resp.StatusCode, bytes.TrimSpace(b))
+	}
+	res := &response{}
+	if err := gob.NewDecoder(resp.Body).Decode(res); err != nil {
+		return nil, errors.Wrapf(err, "could not decode response of %s", node)
+	}
+	if res.Error != "" {
+		return nil, remoteError(res.Error)
+	}
+	return res, nil
+}
+
+// remoteError returns storage error of the message, so callers may compare it
+func remoteError(message string) error {
+	for _, err := range remoteErrors {
+		if err.Error() == message {
+			return err
+		}
+	}
+	return errors.New(message)
+}
@@ -0,0 +1,201 @@
+package cluster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+type testNode struct {
+	cluster *Cluster
+	local   *storage.DriverStorage
+	server  *httptest.Server
+}
+
+// newTestCluster starts nodes serving each other
+func newTestCluster(t *testing.T, n int) []*testNode {
+	nodes := make([]*testNode, n)
+	urls := make([]string, n)
+	for i := range nodes {
+		node := &testNode{local: storage.New(10)}
+		node.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			node.cluster.ServeHTTP(w, r)
+		}))
+		t.Cleanup(node.server.Close)
+		nodes[i], urls[i] = node, node.server.URL
+	}
+	for _, node := range nodes {
+		locals := storage.NewManager(node.local, func(string) (storage.Storage, error) { return storage.New(10), nil })
+		c, err := New(node.server.URL+"/", urls, "secret", locals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node.cluster = c
+	}
+	return nodes
+}
+
+func defaultStorage(t *testing.T, node *testNode) storage.Storage {
+	s, err := node.cluster.Namespaces().Namespace("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	s := defaultStorage(t, nodes[0])
+
+	var batch []*storage.Driver
+	for id := 1; id <= 30; id++ {
+		batch = append(batch, &storage.Driver{ID: id, LastLocation: storage.Location{Lat: 1 + float64(id)*0.001, Lon: 1}})
+	}
+	assert.NoError(t, s.SetMany(batch[:20]))
+	for _, d := range batch[20:] {
+		assert.NoError(t, s.Set(d))
+	}
+
+	// drivers are kept by their owners only
+	total := 0
+	for _, node := range nodes {
+		for _, d := range node.local.List(0, 100) {
+			assert.Equal(t, node.server.URL, node.cluster.Owner(d.ID))
+		}
+		total += node.local.Len()
+		assert.True(t, node.local.Len() > 0)
+	}
+	assert.Equal(t, 30, total)
+
+	other := defaultStorage(t, nodes[1])
+	d, err := other.Get(7)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Location{Lat: 1.007, Lon: 1}, d.LastLocation)
+	_, err = other.Get(42)
+	assert.Equal(t, storage.ErrDriverDoesNotExist, err)
+	history, err := other.History(7, 0, 0)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	page := other.List(10, 5)
+	if assert.Len(t, page, 5) {
+		assert.Equal(t, 11, page[0].ID)
+		assert.Equal(t, 15, page[4].ID)
+	}
+	found, err := other.InBoundingBox(1.0005, 0.9, 1.0105, 1.1)
+	assert.NoError(t, err)
+	assert.Len(t, found, 10)
+	cells, err := other.Heatmap(1)
+	assert.NoError(t, err)
+	assert.Equal(t, []storage.HeatmapCell{{Geohash: storage.Geohash(storage.Location{Lat: 1, Lon: 1}, 1), Count: 30}}, cells)
+
+	assert.NoError(t, other.SetStatus(3, storage.StatusBusy))
+	assert.Equal(t, storage.ErrInvalidStatus, other.SetStatus(3, "sleeping"))
+	version, err := other.(*Storage).SetAttributesIf(3, map[string]string{"car": "van"}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), version)
+	_, err = other.(*Storage).SetStatusIf(3, storage.StatusAvailable, 2)
+	assert.Equal(t, storage.ErrVersionMismatch, err)
+	assert.NoError(t, other.Delete(5))
+	assert.Equal(t, storage.ErrDriverDoesNotExist, other.Delete(5))
+	assert.NoError(t, other.DeleteMany([]int{6, 8, 42}))
+	for _, id := range []int{5, 6, 8} {
+		_, err := s.Get(id)
+		assert.Equal(t, storage.ErrDriverDoesNotExist, err)
+	}
+}
+
+func TestNearest(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	s := defaultStorage(t, nodes[2])
+	for id := 1; id <= 40; id++ {
+		class := "sedan"
+		if id%10 == 0 {
+			class = "minivan"
+		}
+		assert.NoError(t, s.Set(&storage.Driver{
+			ID:           id,
+			LastLocation: storage.Location{Lat: 1 + float64(id)*0.001, Lon: 1},
+			Attributes:   map[string]string{"class": class},
+		}))
+	}
+
+	nearest := s.Nearest(rtreego.Point{1, 1}, 3)
+	if assert.Len(t, nearest, 3) {
+		assert.Equal(t, []int{1, 2, 3}, []int{nearest[0].ID, nearest[1].ID, nearest[2].ID})
+	}
+	// peers are asked for more drivers until enough of them match
+	nearest = s.Nearest(rtreego.Point{1, 1}, 3, storage.AttributesFilter(map[string]string{"class": "minivan"}))
+	if assert.Len(t, nearest, 3) {
+		assert.Equal(t, []int{10, 20, 30}, []int{nearest[0].ID, nearest[1].ID, nearest[2].ID})
+	}
+	assert.Len(t, s.Nearest(rtreego.Point{1, 1}, 10, storage.AttributesFilter(map[string]string{"class": "minivan"})), 4)
+
+	first := s.NearestAndLock(rtreego.Point{1, 1}, 2, time.Minute)
+	if assert.Len(t, first, 2) {
+		assert.Equal(t, 1, first[0].ID)
+		assert.True(t, first[1].Reserved())
+	}
+	second := defaultStorage(t, nodes[0]).NearestAndLock(rtreego.Point{1, 1}, 1, time.Minute)
+	if assert.Len(t, second, 1) {
+		assert.Equal(t, 3, second[0].ID)
+	}
+	d, err := s.Get(2)
+	assert.NoError(t, err)
+	assert.True(t, d.Reserved())
+
+	// drivers of unreachable nodes are missing
+	nodes[1].server.Close()
+	nearest = s.Nearest(rtreego.Point{1, 1}, 40)
+	assert.True(t, len(nearest) > 0 && len(nearest) < 40)
+	_, err = s.Heatmap(1)
+	assert.Error(t, err)
+}
+
+func TestServeHTTP(t *testing.T) {
+	nodes := newTestCluster(t, 2)
+	for key, status := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "secret": http.StatusBadRequest} {
+		req, _ := http.NewRequest(http.MethodPost, nodes[0].server.URL+Path+opGet, strings.NewReader("{}"))
+		req.Header.Set(keyHeader, key)
+		resp, err := http.DefaultClient.Do(req)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, status, resp.StatusCode, key)
+		}
+	}
+
+	_, err := nodes[0].cluster.call(nodes[1].server.URL, "unknown", &request{})
+	assert.Equal(t, ErrUnknownOperation, err)
+	_, err = nodes[0].cluster.call(nodes[1].server.URL, opGossip, &request{})
+	assert.Equal(t, ErrUnknownOperation, err)
+	_, err = nodes[0].cluster.call(nodes[1].server.URL, opGet, &request{Namespace: "Invalid!"})
+	assert.Equal(t, storage.ErrInvalidNamespace, err)
+
+	// namespaces are isolated on every node
+	ns, err := nodes[0].cluster.Namespaces().Namespace("fleet")
+	assert.NoError(t, err)
+	for id := 1; id <= 10; id++ {
+		assert.NoError(t, ns.Set(&storage.Driver{ID: id, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	}
+	assert.Len(t, ns.List(0, 100), 10)
+	assert.Empty(t, defaultStorage(t, nodes[1]).List(0, 100))
+	assert.Equal(t, []string{"fleet"}, nodes[1].cluster.Namespaces().Namespaces())
+}
+
+func TestNew(t *testing.T) {
+	locals := storage.NewManager(storage.New(10), nil)
+	_, err := New("http://c", []string{"http://a", "http://b"}, "secret", locals)
+	assert.Equal(t, ErrNotInCluster, err)
+	_, err = New("http://a", []string{"http://a", "http://b"}, "", locals)
+	assert.Equal(t, ErrNoKey, err)
+	c, err := New("http://a/", []string{"http://a/", "http://b"}, "secret", locals, WithTimeout(time.Second), WithReplicas(10))
+	assert.NoError(t, err)
+	assert.Len(t, c.ring.points, 20)
+	assert.Equal(t, time.Second, c.client.Timeout)
+}
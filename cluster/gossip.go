@@ -0,0 +1,235 @@
+package cluster
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// opGossip exchanges member lists of gossiping nodes
+const opGossip = "gossip"
+
+// gossipFanout is number of random peers a node gossips with every interval
+const gossipFanout = 3
+
+// failAfter and removeAfter are numbers of gossip intervals since the heartbeat of a member last grew
+// until it's considered failed and until it's forgotten. Failed members are kept a while,
+// so peers which haven't noticed the failure yet don't bring them back.
+const (
+	failAfter   = 5
+	removeAfter = 50
+)
+
+type (
+	// Member is a node known by gossip. Nodes are alive while their heartbeats spread,
+	// drivers of failed nodes and nodes which left are owned by the rest.
+	Member struct {
+		Node     string    `json:"node"`
+		Alive    bool      `json:"alive"`
+		Drivers  int       `json:"drivers"`
+		LastSeen time.Time `json:"last_seen"`
+	}
+
+	// state is a member on the wire, Heartbeat of a node grows every interval while it runs
+	// and starts from the start time, so it grows across restarts too
+	state struct {
+		Node      string
+		Heartbeat uint64
+		Left      bool
+		Drivers   int
+	}
+
+	// gossip tracks members by exchanging states with random peers
+	gossip struct {
+		cluster  *Cluster
+		interval time.Duration
+		seeds    []string
+
+		mu      sync.Mutex
+		members map[string]*member
+		quit    chan struct{}
+		done    chan struct{}
+	}
+
+	member struct {
+		state
+		seen time.Time
+	}
+)
+
+// WithGossip discovers nodes by gossip every interval instead of the static list, nodes of New
+// are seeds the node joins through. All nodes must use the same interval.
+func WithGossip(interval time.Duration) Option {
+	return func(c *Cluster) {
+		c.gossip = &gossip{cluster: c, interval: interval}
+	}
+}
+
+// start gossips in background until stop
+func (g *gossip) start(seeds []string) {
+	g.seeds = seeds
+	g.members = map[string]*member{
+		g.cluster.self: {state: state{Node: g.cluster.self, Heartbeat: uint64(time.Now().UnixNano())}, seen: time.Now()},
+	}
+	g.quit = make(chan struct{})
+	g.done = make(chan struct{})
+	go func() {
+		defer close(g.done)
+		ticker := time.NewTicker(g.interval)
+		defer ticker.Stop()
+		g.round()
+		for {
+			select {
+			case <-ticker.C:
+				g.round()
+			case <-g.quit:
+				return
+			}
+		}
+	}()
+}
+
+// stop stops gossiping and tells peers the node left, so they take over its drivers at once
+func (g *gossip) stop() {
+	close(g.quit)
+	<-g.done
+
+	g.mu.Lock()
+	self := g.members[g.cluster.self]
+	self.Heartbeat++
+	self.Left = true
+	g.mu.Unlock()
+	g.exchange(g.targets())
+}
+
+// round beats the heart of the node and exchanges states with random peers
+func (g *gossip) round() {
+	drivers := g.cluster.drivers()
+	g.mu.Lock()
+	self := g.members[g.cluster.self]
+	self.Heartbeat++
+	self.Drivers = drivers
+	self.seen = time.Now()
+	g.mu.Unlock()
+
+	g.exchange(g.targets())
+	g.update()
+}
+
+// targets returns random alive peers, seeds if no peer is known alive
+func (g *gossip) targets() []string {
+	g.mu.Lock()
+	var peers []string
+	for node, m := range g.members {
+		if node != g.cluster.self && g.alive(m) {
+			peers = append(peers, node)
+		}
+	}
+	g.mu.Unlock()
+
+	if len(peers) == 0 {
+		for _, seed := range g.seeds {
+			if seed != g.cluster.self {
+				peers = append(peers, seed)
+			}
+		}
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > gossipFanout {
+		peers = peers[:gossipFanout]
+	}
+	return peers
+}
+
+// exchange sends states to the peers concurrently and merges their states
+func (g *gossip) exchange(peers []string) {
+	req := &request{Members: g.states()}
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			res, err := g.cluster.call(peer, opGossip, req)
+			if err != nil {
+				zap.L().Debug("could not gossip", zap.String("node", peer), zap.Error(err))
+				return
+			}
+			g.merge(res.Members)
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// states returns states of members which are not forgotten yet
+func (g *gossip) states() []state {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	states := make([]state, 0, len(g.members))
+	for _, m := range g.members {
+		states = append(states, m.state)
+	}
+	return states
+}
+
+// merge keeps states with greater heartbeats, a greater heartbeat of this node
+// is of its previous run and is outgrown
+func (g *gossip) merge(states []state) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for _, s := range states {
+		m, ok := g.members[s.Node]
+		if s.Node == g.cluster.self {
+			if s.Heartbeat >= m.Heartbeat {
+				m.Heartbeat = s.Heartbeat + 1
+			}
+			continue
+		}
+		if !ok {
+			m = &member{}
+			g.members[s.Node] = m
+		} else if s.Heartbeat <= m.Heartbeat {
+			continue
+		}
+		m.state, m.seen = s, now
+	}
+}
+
+// update forgets members silent for long and sets alive nodes of the cluster
+func (g *gossip) update() {
+	g.mu.Lock()
+	var nodes []string
+	for node, m := range g.members {
+		switch {
+		case node == g.cluster.self:
+		case time.Since(m.seen) > removeAfter*g.interval:
+			delete(g.members, node)
+			continue
+		case !g.alive(m):
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	g.mu.Unlock()
+	g.cluster.setNodes(nodes)
+}
+
+// alive returns true if the member hasn't left and its heartbeat grew recently
+func (g *gossip) alive(m *member) bool {
+	return !m.Left && time.Since(m.seen) < failAfter*g.interval
+}
+
+// list returns known members ordered by node
+func (g *gossip) list() []Member {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	members := make([]Member, 0, len(g.members))
+	for _, m := range g.members {
+		members = append(members, Member{Node: m.Node, Alive: g.alive(m), Drivers: m.Drivers, LastSeen: m.seen})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Node < members[j].Node })
+	return members
+}
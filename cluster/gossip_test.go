@@ -0,0 +1,108 @@
+package cluster
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+const testInterval = 20 * time.Millisecond
+
+// newGossipNode starts a node joining through the seed, requests wait until the node is created
+func newGossipNode(t *testing.T, seed string) *testNode {
+	node := &testNode{local: storage.New(10)}
+	ready := make(chan struct{})
+	node.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-ready
+		node.cluster.ServeHTTP(w, r)
+	}))
+	t.Cleanup(node.server.Close)
+	if seed == "" {
+		seed = node.server.URL
+	}
+	locals := storage.NewManager(node.local, func(string) (storage.Storage, error) { return storage.New(10), nil })
+	c, err := New(node.server.URL, []string{seed}, "secret", locals, WithGossip(testInterval))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.cluster = c
+	close(ready)
+	return node
+}
+
+// alive returns alive members known by the node
+func alive(node *testNode) []string {
+	var nodes []string
+	for _, m := range node.cluster.Members() {
+		if m.Alive {
+			nodes = append(nodes, m.Node)
+		}
+	}
+	return nodes
+}
+
+func TestGossip(t *testing.T) {
+	first := newGossipNode(t, "")
+	nodes := []*testNode{first, newGossipNode(t, first.server.URL), newGossipNode(t, first.server.URL)}
+	defer nodes[0].cluster.Close()
+	defer nodes[1].cluster.Close()
+
+	// nodes joined through the first one know each other
+	converged := func(n int) func() bool {
+		return func() bool {
+			for _, node := range nodes[:n] {
+				if len(alive(node)) != n || len(node.cluster.Nodes()) != n {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	assert.Eventually(t, converged(3), 2*time.Second, testInterval)
+
+	// drivers are owned by all nodes and set through any of them
+	s := defaultStorage(t, nodes[2])
+	for id := 1; id <= 30; id++ {
+		assert.NoError(t, s.Set(&storage.Driver{ID: id, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	}
+	for _, node := range nodes {
+		assert.True(t, node.local.Len() > 0)
+	}
+	assert.Eventually(t, func() bool {
+		total := 0
+		for _, m := range nodes[0].cluster.Members() {
+			total += m.Drivers
+		}
+		return total == 30
+	}, 2*time.Second, testInterval)
+
+	// the node which left is dropped at once, its drivers are owned by the rest
+	left := nodes[2].server.URL
+	assert.NoError(t, nodes[2].cluster.Close())
+	assert.Eventually(t, converged(2), 2*time.Second, testInterval)
+	for id := 1; id <= 30; id++ {
+		assert.NotEqual(t, left, nodes[0].cluster.Owner(id))
+	}
+	members := nodes[1].cluster.Members()
+	assert.Len(t, members, 3)
+	for _, m := range members {
+		assert.Equal(t, m.Node != left, m.Alive, m.Node)
+	}
+}
+
+func TestGossipFailure(t *testing.T) {
+	first := newGossipNode(t, "")
+	second := newGossipNode(t, first.server.URL)
+	defer first.cluster.Close()
+	assert.Eventually(t, func() bool { return len(first.cluster.Nodes()) == 2 }, 2*time.Second, testInterval)
+
+	// a node which stops answering fails after some intervals
+	second.cluster.gossip.quit <- struct{}{}
+	second.server.Close()
+	assert.Eventually(t, func() bool { return len(first.cluster.Nodes()) == 1 }, 2*time.Second, testInterval)
+	assert.Equal(t, []string{first.server.URL}, alive(first))
+}
@@ -0,0 +1,207 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/storage"
+)
+
+// operations of peers, they are the last element of the request path
+const (
+	opSet             = "set"
+	opSetMany         = "set_many"
+	opGet             = "get"
+	opList            = "list"
+	opHistory         = "history"
+	opDelete          = "delete"
+	opDeleteMany      = "delete_many"
+	opSetStatus       = "set_status"
+	opSetStatusIf     = "set_status_if"
+	opSetAttributesIf = "set_attributes_if"
+	opNearest         = "nearest"
+	opReserve         = "reserve"
+	opBox             = "bounding_box"
+	opPolygon         = "polygon"
+	opHeatmap         = "heatmap"
+)
+
+type (
+	// request has arguments of an operation, fields not used by it are zero
+	request struct {
+		Namespace  string
+		Drivers    drivers
+		ID         int
+		IDs        []int
+		Status     storage.Status
+		Attributes map[string]string
+		Version    uint64
+		From, To   int64
+		After      int
+		Limit      int
+		Point      rtreego.Point
+		Until      int64
+		Box        [4]float64
+		Polygon    storage.Polygon
+		Precision  int
+		Members    []state
+	}
+
+	// response has results of an operation, Error is the message of its error
+	response struct {
+		Drivers  drivers
+		History  []storage.HistoryPoint
+		Cells    []storage.HeatmapCell
+		Reserved bool
+		Version  uint64
+		Members  []state
+		Error    string
+	}
+
+	// drivers are sent without location history and internal state of storages
+	drivers []*storage.Driver
+
+	// driver is a storage.Driver on the wire
+	driver struct {
+		ID            int
+		LastLocation  storage.Location
+		Attributes    map[string]string
+		Status        storage.Status
+		Speed         float64
+		Heading       float64
+		Timestamp     int64
+		ReservedUntil int64
+		IdleSince     int64
+		OffShift      bool
+		Anomalies     int
+		AnomalyAt     int64
+		Expiration    int64
+	}
+
+	// reserver is implemented by storages reserving drivers by id
+	reserver interface {
+		Reserve(id int, until int64) bool
+	}
+
+	// versioner is implemented by storages keeping versions of drivers
+	versioner interface {
+		SetStatusIf(id int, status storage.Status, version uint64) (uint64, error)
+		SetAttributesIf(id int, attributes map[string]string, version uint64) (uint64, error)
+	}
+)
+
+// GobEncode encodes drivers as driver records
+func (ds drivers) GobEncode() ([]byte, error) {
+	records := make([]driver, len(ds))
+	for i, d := range ds {
+		records[i] = driver{
+			ID:            d.ID,
+			LastLocation:  d.LastLocation,
+			Attributes:    d.Attributes,
+			Status:        d.Status,
+			Speed:         d.Speed,
+			Heading:       d.Heading,
+			Timestamp:     d.Timestamp,
+			ReservedUntil: d.ReservedUntil,
+			IdleSince:     d.IdleSince,
+			OffShift:      d.OffShift,
+			Anomalies:     d.Anomalies,
+			AnomalyAt:     d.AnomalyAt,
+			Expiration:    d.Expiration,
+		}
+	}
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(records)
+	return b.Bytes(), err
+}
+
+// GobDecode decodes drivers of driver records
+func (ds *drivers) GobDecode(b []byte) error {
+	var records []driver
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&records); err != nil {
+		return err
+	}
+	*ds = make(drivers, len(records))
+	for i, r := range records {
+		(*ds)[i] = &storage.Driver{
+			ID:            r.ID,
+			LastLocation:  r.LastLocation,
+			Attributes:    r.Attributes,
+			Status:        r.Status,
+			Speed:         r.Speed,
+			Heading:       r.Heading,
+			Timestamp:     r.Timestamp,
+			ReservedUntil: r.ReservedUntil,
+			IdleSince:     r.IdleSince,
+			OffShift:      r.OffShift,
+			Anomalies:     r.Anomalies,
+			AnomalyAt:     r.AnomalyAt,
+			Expiration:    r.Expiration,
+		}
+	}
+	return nil
+}
+
+// handle runs the operation with the local storage, it serves peers and calls of this node alike.
+// Nearest drivers are not filtered, filters are functions which can't be sent.
+func handle(local storage.Storage, op string, req *request) (*response, error) {
+	res := &response{}
+	var err error
+	switch op {
+	case opSet:
+		// drivers are set one by one as by Set, unlike by SetMany
+		for _, d := range req.Drivers {
+			if err = local.Set(d); err != nil {
+				break
+			}
+		}
+	case opSetMany:
+		err = local.SetMany(req.Drivers)
+	case opGet:
+		var d *storage.Driver
+		if d, err = local.Get(req.ID); err == nil {
+			res.Drivers = drivers{d}
+		}
+	case opList:
+		res.Drivers = local.List(req.After, req.Limit)
+	case opHistory:
+		res.History, err = local.History(req.ID, req.From, req.To)
+	case opDelete:
+		err = local.Delete(req.ID)
+	case opDeleteMany:
+		err = local.DeleteMany(req.IDs)
+	case opSetStatus:
+		err = local.SetStatus(req.ID, req.Status)
+	case opSetStatusIf, opSetAttributesIf:
+		v, ok := local.(versioner)
+		if !ok {
+			return nil, ErrVersionsUnsupported
+		}
+		if op == opSetStatusIf {
+			res.Version, err = v.SetStatusIf(req.ID, req.Status, req.Version)
+		} else {
+			res.Version, err = v.SetAttributesIf(req.ID, req.Attributes, req.Version)
+		}
+	case opNearest:
+		res.Drivers = local.Nearest(req.Point, req.Limit)
+	case opReserve:
+		r, ok := local.(reserver)
+		if !ok {
+			return nil, ErrReservationsUnsupported
+		}
+		res.Reserved = r.Reserve(req.ID, req.Until)
+	case opBox:
+		res.Drivers, err = local.InBoundingBox(req.Box[0], req.Box[1], req.Box[2], req.Box[3])
+	case opPolygon:
+		res.Drivers, err = local.InPolygon(req.Polygon)
+	case opHeatmap:
+		res.Cells, err = local.Heatmap(req.Precision)
+	default:
+		return nil, ErrUnknownOperation
+	}
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// defaultReplicas is number of points of a node on the ring, more points spread drivers more evenly
+const defaultReplicas = 128
+
+type (
+	// Ring assigns driver ids to nodes by consistent hashing,
+	// adding or removing a node moves only drivers of its share of the ring
+	Ring struct {
+		points []point
+	}
+
+	point struct {
+		hash uint32
+		node string
+	}
+)
+
+// NewRing creates ring of the nodes with replicas points per node
+func NewRing(nodes []string, replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	r := &Ring{points: make([]point, 0, len(nodes)*replicas)}
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			r.points = append(r.points, point{hash: hash(node + "#" + strconv.Itoa(i)), node: node})
+		}
+	}
+	// ties are broken by node, so all nodes build the same ring from the same list in any order
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+		return r.points[i].node < r.points[j].node
+	})
+	return r
+}
+
+// Owner returns node of the driver, empty if the ring has no nodes
+func (r *Ring) Owner(id int) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(strconv.Itoa(id))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+// hash mixes bits of FNV-1a, so similar keys like node#1 and node#2 are spread over the ring
+func hash(s string) uint32 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return uint32(x)
+}
@@ -0,0 +1,34 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing(t *testing.T) {
+	nodes := []string{"http://a:8080", "http://b:8080", "http://c:8080"}
+	r := NewRing(nodes, 0)
+	counts := map[string]int{}
+	for id := 0; id < 30000; id++ {
+		counts[r.Owner(id)]++
+	}
+	for _, node := range nodes {
+		assert.InDelta(t, 10000, counts[node], 2500, node)
+	}
+
+	// the order of nodes doesn't matter and a new node takes drivers of others only
+	reordered := NewRing([]string{nodes[2], nodes[0], nodes[1]}, 0)
+	grown := NewRing(append(nodes, "http://d:8080"), 0)
+	moved := 0
+	for id := 0; id < 30000; id++ {
+		assert.Equal(t, r.Owner(id), reordered.Owner(id))
+		if owner := grown.Owner(id); owner != r.Owner(id) {
+			assert.Equal(t, "http://d:8080", owner)
+			moved++
+		}
+	}
+	assert.InDelta(t, 7500, moved, 2500)
+
+	assert.Equal(t, "", NewRing(nil, 0).Owner(1))
+}
@@ -0,0 +1,359 @@
+package cluster
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/storage"
+	"go.uber.org/zap"
+)
+
+// candidatesFactor widens requests of nearest drivers of peers and reservation candidates
+const candidatesFactor = 4
+
+// Storage of a namespace keeps drivers owned by this node locally and calls owners of other drivers.
+// Queries without error results return drivers of reachable nodes if some nodes fail.
+// Len, Stats and DeleteExpired are of this node.
+type Storage struct {
+	cluster   *Cluster
+	namespace string
+	local     storage.Storage
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+// exec runs the operation locally if the node is this one and calls the peer otherwise
+func (s *Storage) exec(node, op string, req *request) (*response, error) {
+	if node == s.cluster.self {
+		return handle(s.local, op, req)
+	}
+	r := *req
+	r.Namespace = s.namespace
+	return s.cluster.call(node, op, &r)
+}
+
+// fanOut runs the operation on all nodes concurrently, responses of failed nodes are nil.
+// It returns an error of a failed node.
+func (s *Storage) fanOut(op string, req *request) ([]*response, error) {
+	nodes := s.cluster.Nodes()
+	responses := make([]*response, len(nodes))
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			responses[i], errs[i] = s.exec(node, op, req)
+		}(i, node)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return responses, err
+		}
+	}
+	return responses, nil
+}
+
+// groupByOwner returns indexes of items of n ids grouped by owner nodes
+func (s *Storage) groupByOwner(n int, id func(i int) int) map[string][]int {
+	groups := make(map[string][]int)
+	for i := 0; i < n; i++ {
+		owner := s.cluster.Owner(id(i))
+		groups[owner] = append(groups[owner], i)
+	}
+	return groups
+}
+
+// Set sets the driver on its owner
+func (s *Storage) Set(driver *storage.Driver) error {
+	_, err := s.exec(s.cluster.Owner(driver.ID), opSet, &request{Drivers: drivers{driver}})
+	return err
+}
+
+// SetMany sets drivers on their owners concurrently, it returns an error of a failed owner
+func (s *Storage) SetMany(ds []*storage.Driver) error {
+	groups := s.groupByOwner(len(ds), func(i int) int { return ds[i].ID })
+	return s.each(groups, func(node string, indexes []int) error {
+		group := make(drivers, len(indexes))
+		for j, i := range indexes {
+			group[j] = ds[i]
+		}
+		_, err := s.exec(node, opSetMany, &request{Drivers: group})
+		return err
+	})
+}
+
+// Get gets the driver from its owner
+func (s *Storage) Get(id int) (*storage.Driver, error) {
+	res, err := s.exec(s.cluster.Owner(id), opGet, &request{ID: id})
+	if err != nil {
+		return nil, err
+	}
+	return res.Drivers[0], nil
+}
+
+// List merges pages of all nodes ordered by ID
+func (s *Storage) List(after, limit int) []*storage.Driver {
+	if limit <= 0 {
+		return nil
+	}
+	responses, err := s.fanOut(opList, &request{After: after, Limit: limit})
+	if err != nil {
+		zap.L().Warn("could not list drivers of all nodes", zap.Error(err))
+	}
+	ds := merge(responses)
+	sort.Slice(ds, func(i, j int) bool { return ds[i].ID < ds[j].ID })
+	if len(ds) > limit {
+		ds = ds[:limit]
+	}
+	return ds
+}
+
+// History returns locations of the driver kept by its owner
+func (s *Storage) History(id int, from, to int64) ([]storage.HistoryPoint, error) {
+	res, err := s.exec(s.cluster.Owner(id), opHistory, &request{ID: id, From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+	return res.History, nil
+}
+
+// Delete deletes the driver from its owner
+func (s *Storage) Delete(id int) error {
+	_, err := s.exec(s.cluster.Owner(id), opDelete, &request{ID: id})
+	return err
+}
+
+// DeleteMany deletes drivers from their owners concurrently, skipping missing ones
+func (s *Storage) DeleteMany(ids []int) error {
+	groups := s.groupByOwner(len(ids), func(i int) int { return ids[i] })
+	return s.each(groups, func(node string, indexes []int) error {
+		group := make([]int, len(indexes))
+		for j, i := range indexes {
+			group[j] = ids[i]
+		}
+		_, err := s.exec(node, opDeleteMany, &request{IDs: group})
+		return err
+	})
+}
+
+// SetStatus changes status of the driver on its owner
+func (s *Storage) SetStatus(id int, status storage.Status) error {
+	_, err := s.exec(s.cluster.Owner(id), opSetStatus, &request{ID: id, Status: status})
+	return err
+}
+
+// SetStatusIf changes status of the driver on its owner if it's of the version
+func (s *Storage) SetStatusIf(id int, status storage.Status, version uint64) (uint64, error) {
+	res, err := s.exec(s.cluster.Owner(id), opSetStatusIf, &request{ID: id, Status: status, Version: version})
+	if err != nil {
+		return 0, err
+	}
+	return res.Version, nil
+}
+
+// SetAttributesIf changes attributes of the driver on its owner if it's of the version
+func (s *Storage) SetAttributesIf(id int, attributes map[string]string, version uint64) (uint64, error) {
+	res, err := s.exec(s.cluster.Owner(id), opSetAttributesIf, &request{ID: id, Attributes: attributes, Version: version})
+	if err != nil {
+		return 0, err
+	}
+	return res.Version, nil
+}
+
+// Nearest queries all nodes concurrently and merges results by great-circle distance
+func (s *Storage) Nearest(point rtreego.Point, count int, filters ...storage.Filter) []*storage.Driver {
+	nodes := s.cluster.Nodes()
+	results := make([][]*storage.Driver, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i int, node string) {
+			defer wg.Done()
+			ds, err := s.nearest(node, point, count, filters)
+			if err != nil {
+				zap.L().Warn("could not query nearest drivers of a node", zap.String("node", node), zap.Error(err))
+			}
+			results[i] = ds
+		}(i, node)
+	}
+	wg.Wait()
+
+	var ds []*storage.Driver
+	for _, r := range results {
+		ds = append(ds, r...)
+	}
+	ds = unique(ds)
+	origin := storage.Location{Lat: point[0], Lon: point[1]}
+	storage.SortByDistance(origin, ds)
+	if len(ds) > count {
+		ds = ds[:count]
+	}
+	return ds
+}
+
+// nearest returns up to count nearest drivers of the node matching all filters. Filters can't be sent,
+// so peers return nearest drivers unfiltered and the request is widened until enough of them match.
+func (s *Storage) nearest(node string, point rtreego.Point, count int, filters []storage.Filter) ([]*storage.Driver, error) {
+	if node == s.cluster.self {
+		return s.local.Nearest(point, count, filters...), nil
+	}
+	for limit := count; ; limit *= candidatesFactor {
+		res, err := s.exec(node, opNearest, &request{Point: point, Limit: limit})
+		if err != nil {
+			return nil, err
+		}
+		matched := make([]*storage.Driver, 0, count)
+		for _, d := range res.Drivers {
+			if len(matched) < count && matches(d, filters) {
+				matched = append(matched, d)
+			}
+		}
+		// the node has no more drivers if it returned less than requested
+		if len(matched) == count || len(res.Drivers) < limit || limit > math.MaxInt32/candidatesFactor {
+			return matched, nil
+		}
+	}
+}
+
+// NearestAndLock reserves nearest drivers of all nodes. Candidates are found without locking
+// and reserved one by one on their owners, skipping those reserved meanwhile.
+func (s *Storage) NearestAndLock(point rtreego.Point, count int, ttl time.Duration, filters ...storage.Filter) []*storage.Driver {
+	candidates := s.Nearest(point, count*candidatesFactor, append(filters, storage.Available())...)
+	until := time.Now().Add(ttl).UnixNano()
+
+	var ds []*storage.Driver
+	for _, d := range candidates {
+		if len(ds) == count {
+			break
+		}
+		node := s.cluster.Owner(d.ID)
+		res, err := s.exec(node, opReserve, &request{ID: d.ID, Until: until})
+		if err != nil {
+			zap.L().Warn("could not reserve driver", zap.Int("driver", d.ID), zap.String("node", node), zap.Error(err))
+			continue
+		}
+		if !res.Reserved {
+			continue
+		}
+		// drivers of the local storage are never modified, the reserved one is a copy
+		reserved := *d
+		reserved.ReservedUntil = until
+		ds = append(ds, &reserved)
+	}
+	return ds
+}
+
+// InBoundingBox returns drivers of all nodes located inside the bounding box
+func (s *Storage) InBoundingBox(minLat, minLon, maxLat, maxLon float64) ([]*storage.Driver, error) {
+	responses, err := s.fanOut(opBox, &request{Box: [4]float64{minLat, minLon, maxLat, maxLon}})
+	if err != nil {
+		return nil, err
+	}
+	return merge(responses), nil
+}
+
+// InPolygon returns drivers of all nodes located inside the polygon
+func (s *Storage) InPolygon(polygon storage.Polygon) ([]*storage.Driver, error) {
+	responses, err := s.fanOut(opPolygon, &request{Polygon: polygon})
+	if err != nil {
+		return nil, err
+	}
+	return merge(responses), nil
+}
+
+// Heatmap sums counts of cells of all nodes
+func (s *Storage) Heatmap(precision int) ([]storage.HeatmapCell, error) {
+	responses, err := s.fanOut(opHeatmap, &request{Precision: precision})
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, res := range responses {
+		for _, c := range res.Cells {
+			counts[c.Geohash] += c.Count
+		}
+	}
+	cells := make([]storage.HeatmapCell, 0, len(counts))
+	for hash, n := range counts {
+		cells = append(cells, storage.HeatmapCell{Geohash: hash, Count: n})
+	}
+	storage.SortHeatmap(cells)
+	return cells, nil
+}
+
+// DeleteExpired deletes expired drivers of this node, every node runs its janitor
+func (s *Storage) DeleteExpired() {
+	s.local.DeleteExpired()
+}
+
+// Len returns number of drivers of this node
+func (s *Storage) Len() int {
+	return s.local.Len()
+}
+
+// Stats returns counters of this node
+func (s *Storage) Stats() storage.Stats {
+	return s.local.Stats()
+}
+
+// each calls fn for every group concurrently, it returns an error of a group
+func (s *Storage) each(groups map[string][]int, fn func(node string, indexes []int) error) error {
+	errs := make(chan error, len(groups))
+	for node, indexes := range groups {
+		go func(node string, indexes []int) {
+			errs <- fn(node, indexes)
+		}(node, indexes)
+	}
+	var err error
+	for range groups {
+		if e := <-errs; e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// merge returns unique drivers of responses of reachable nodes
+func merge(responses []*response) []*storage.Driver {
+	var ds []*storage.Driver
+	for _, res := range responses {
+		if res != nil {
+			ds = append(ds, res.Drivers...)
+		}
+	}
+	return unique(ds)
+}
+
+// unique keeps the latest location of drivers found on several nodes,
+// the former owner keeps a driver until it expires after the nodes change
+func unique(ds []*storage.Driver) []*storage.Driver {
+	latest := make(map[int]int, len(ds))
+	result := ds[:0]
+	for _, d := range ds {
+		i, ok := latest[d.ID]
+		if !ok {
+			latest[d.ID] = len(result)
+			result = append(result, d)
+			continue
+		}
+		if d.Timestamp > result[i].Timestamp {
+			result[i] = d
+		}
+	}
+	return result
+}
+
+// matches returns true if the driver matches all filters
+func matches(d *storage.Driver, filters []storage.Filter) bool {
+	for _, f := range filters {
+		if !f(d) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,281 @@
+// Package config sets flags from a YAML or TOML file and environment variables,
+// flags given on the command line take precedence over environment variables and both over the file
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrUnsupportedFormat sign what config file is neither YAML nor TOML
+var ErrUnsupportedFormat = errors.New("Config file must have .yaml, .yml or .toml extension")
+
+type (
+	// Option configures Loader
+	Option func(*Loader)
+
+	// Loader sets flags of the flag set
+	Loader struct {
+		flags     *flag.FlagSet
+		envPrefix string
+		noEnv     map[string]bool
+		environ   []string
+		// given are flags set on the command line
+		given map[string]bool
+	}
+
+	// Problems are invalid settings found at once, so all of them are fixed before the next start
+	Problems []string
+)
+
+// New creates loader of the flags, it's used after the flags are parsed
+func New(flags *flag.FlagSet, opts ...Option) *Loader {
+	l := &Loader{flags: flags, noEnv: map[string]bool{}, given: map[string]bool{}}
+	for _, opt := range opts {
+		opt(l)
+	}
+	flags.Visit(func(f *flag.Flag) {
+		l.given[f.Name] = true
+	})
+	return l
+}
+
+// WithEnv sets flags from variables of the environ named by the prefix and upper case name of a flag,
+// e.g. NEARESTDOTS_BIND_ADDR of bind_addr
+func WithEnv(prefix string, environ []string) Option {
+	return func(l *Loader) {
+		l.envPrefix = prefix
+		l.environ = environ
+	}
+}
+
+// WithoutEnv excludes flags whose variables have other meaning
+func WithoutEnv(names ...string) Option {
+	return func(l *Loader) {
+		for _, name := range names {
+			l.noEnv[name] = true
+		}
+	}
+}
+
+// Load sets flags not given on the command line from the environment and the file,
+// the file is skipped if path is empty. Keys of nested tables are joined with underscores,
+// so tls: {cert: x} sets tls_cert, and lists are joined with commas.
+func (l *Loader) Load(path string) error {
+	settings := map[string]string{}
+	source := map[string]string{}
+	if path != "" {
+		values, err := ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for name, v := range values {
+			settings[name] = v
+			source[name] = path + ": " + name
+		}
+	}
+	if l.environ != nil {
+		for _, kv := range l.environ {
+			i := strings.IndexByte(kv, '=')
+			if i < 0 || !strings.HasPrefix(kv[:i], l.envPrefix) {
+				continue
+			}
+			name := strings.ToLower(strings.TrimPrefix(kv[:i], l.envPrefix))
+			if l.flags.Lookup(name) == nil || l.noEnv[name] {
+				continue
+			}
+			settings[name] = kv[i+1:]
+			source[name] = kv[:i]
+		}
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if l.flags.Lookup(name) == nil {
+			return errors.Errorf("%s: unknown setting%s", source[name], l.suggest(name))
+		}
+		if l.given[name] {
+			continue
+		}
+		if err := l.flags.Set(name, settings[name]); err != nil {
+			return errors.Errorf("%s: invalid value %q: %v", source[name], settings[name], err)
+		}
+	}
+	return nil
+}
+
+// Reload loads the file and the environment again, settings removed from them are reset to defaults.
+// It returns names of changed flags, flags are kept as they were if the file is invalid or validate fails.
+func (l *Loader) Reload(path string, validate func() error) ([]string, error) {
+	previous := map[string]string{}
+	l.flags.VisitAll(func(f *flag.Flag) {
+		previous[f.Name] = f.Value.String()
+		if !l.given[f.Name] {
+			f.Value.Set(f.DefValue)
+		}
+	})
+	err := l.Load(path)
+	if err == nil {
+		err = validate()
+	}
+	if err != nil {
+		l.flags.VisitAll(func(f *flag.Flag) {
+			f.Value.Set(previous[f.Name])
+		})
+		return nil, err
+	}
+	var changed []string
+	l.flags.VisitAll(func(f *flag.Flag) {
+		if f.Value.String() != previous[f.Name] {
+			changed = append(changed, f.Name)
+		}
+	})
+	return changed, nil
+}
+
+// suggest returns hint of a flag with similar name
+func (l *Loader) suggest(name string) string {
+	best, distance := "", 3
+	l.flags.VisitAll(func(f *flag.Flag) {
+		if d := levenshtein(name, f.Name); d < distance {
+			best, distance = f.Name, d
+		}
+	})
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %s?", best)
+}
+
+// ReadFile returns settings of the YAML or TOML file by flag name
+func ReadFile(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read config")
+	}
+	doc := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &doc)
+	case ".toml":
+		err = toml.Unmarshal(b, &doc)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse %s", path)
+	}
+	settings := map[string]string{}
+	if err := flatten(path, "", doc, settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// flatten adds values of the table to settings with names prefixed by the prefix
+func flatten(path, prefix string, table map[string]interface{}, settings map[string]string) error {
+	for key, v := range table {
+		name := prefix + key
+		if nested, ok := v.(map[string]interface{}); ok {
+			if err := flatten(path, name+"_", nested, settings); err != nil {
+				return err
+			}
+			continue
+		}
+		s, err := format(v)
+		if err != nil {
+			return errors.Errorf("%s: %s: %v", path, name, err)
+		}
+		if _, ok := settings[name]; ok {
+			return errors.Errorf("%s: %s is set twice", path, name)
+		}
+		settings[name] = s
+	}
+	return nil
+}
+
+// format returns flag value of the scalar or list
+func format(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := format(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	case nil:
+		return "", errors.New("value is empty")
+	default:
+		return "", errors.Errorf("unsupported value %v", v)
+	}
+}
+
+// levenshtein returns edit distance of the strings
+func levenshtein(a, b string) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur := row[j]
+			row[j] = prev + cost
+			if row[j-1]+1 < row[j] {
+				row[j] = row[j-1] + 1
+			}
+			if cur+1 < row[j] {
+				row[j] = cur + 1
+			}
+			prev = cur
+		}
+	}
+	return row[len(b)]
+}
+
+// Require adds the problem unless ok
+func (p *Problems) Require(ok bool, format string, args ...interface{}) {
+	if !ok {
+		*p = append(*p, fmt.Sprintf(format, args...))
+	}
+}
+
+// Err returns error listing all problems, nil if there are none
+func (p Problems) Err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return errors.New("invalid configuration:\n  " + strings.Join(p, "\n  "))
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testFlags struct {
+	fs      *flag.FlagSet
+	addr    *string
+	ttl     *time.Duration
+	size    *int
+	brokers *string
+	cert    *string
+	tls     *bool
+	keys    *string
+}
+
+func newTestFlags(t *testing.T, args ...string) *testFlags {
+	f := &testFlags{fs: flag.NewFlagSet("test", flag.ContinueOnError)}
+	f.addr = f.fs.String("bind_addr", ":8080", "")
+	f.ttl = f.fs.Duration("ttl", time.Minute, "")
+	f.size = f.fs.Int("lru_size", 20, "")
+	f.brokers = f.fs.String("kafka_brokers", "localhost:9092", "")
+	f.cert = f.fs.String("tls_cert", "", "")
+	f.tls = f.fs.Bool("tls_enabled", false, "")
+	f.keys = f.fs.String("api_keys", "", "")
+	if err := f.fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	yamlPath := writeConfig(t, "config.yaml", `
+bind_addr: ":9090"
+ttl: 10m
+lru_size: 50
+kafka:
+  brokers: [a:9092, b:9092]
+tls:
+  cert: cert.pem
+  enabled: true
+`)
+	tomlPath := writeConfig(t, "config.toml", `
+bind_addr = ":9090"
+ttl = "10m"
+lru_size = 50
+
+[kafka]
+brokers = ["a:9092", "b:9092"]
+
+[tls]
+cert = "cert.pem"
+enabled = true
+`)
+	for _, path := range []string{yamlPath, tomlPath} {
+		f := newTestFlags(t, "-lru_size", "30")
+		l := New(f.fs, WithEnv("ND_", []string{"ND_TTL=1h", "ND_API_KEYS=key", "ND_UNKNOWN=1", "TTL=2h"}), WithoutEnv("api_keys"))
+		assert.NoError(t, l.Load(path))
+		assert.Equal(t, ":9090", *f.addr)
+		// the environment overrides the file and the command line overrides both
+		assert.Equal(t, time.Hour, *f.ttl)
+		assert.Equal(t, 30, *f.size)
+		assert.Equal(t, "a:9092,b:9092", *f.brokers)
+		assert.Equal(t, "cert.pem", *f.cert)
+		assert.True(t, *f.tls)
+		assert.Equal(t, "", *f.keys)
+	}
+
+	f := newTestFlags(t)
+	assert.NoError(t, New(f.fs).Load(""))
+	assert.Equal(t, ":8080", *f.addr)
+}
+
+func TestLoadErrors(t *testing.T) {
+	for content, message := range map[string]string{
+		"ttl: soon":                   `config.yaml: ttl: invalid value "soon"`,
+		"bind_adr: ':80'":             "config.yaml: bind_adr: unknown setting, did you mean bind_addr?",
+		"storage: {engine: x}":        "config.yaml: storage_engine: unknown setting",
+		"lru_size:":                   "config.yaml: lru_size: value is empty",
+		"tls_cert: a\ntls: {cert: b}": "config.yaml: tls_cert is set twice",
+		"bind_addr: [":                "could not parse",
+	} {
+		path := writeConfig(t, "config.yaml", content)
+		err := New(newTestFlags(t).fs).Load(path)
+		if assert.Error(t, err, content) {
+			assert.Contains(t, err.Error(), message)
+		}
+	}
+
+	err := New(newTestFlags(t).fs, WithEnv("ND_", []string{"ND_LRU_SIZE=many"})).Load("")
+	assert.EqualError(t, err, `ND_LRU_SIZE: invalid value "many": parse error`)
+
+	assert.Equal(t, ErrUnsupportedFormat, New(newTestFlags(t).fs).Load(writeConfig(t, "config.ini", "")))
+	assert.Error(t, New(newTestFlags(t).fs).Load("missing.yaml"))
+}
+
+func TestProblems(t *testing.T) {
+	var problems Problems
+	assert.NoError(t, problems.Err())
+	problems.Require(true, "never")
+	problems.Require(false, "lru_size must be positive")
+	problems.Require(false, "index must be rtree, not %q", "btree")
+	assert.EqualError(t, problems.Err(), "invalid configuration:\n  lru_size must be positive\n  index must be rtree, not \"btree\"")
+}
+
+func TestReload(t *testing.T) {
+	path := writeConfig(t, "config.yaml", "ttl: 10m\nlru_size: 50\nbind_addr: ':9090'\n")
+	f := newTestFlags(t, "-bind_addr", ":7070")
+	l := New(f.fs)
+	assert.NoError(t, l.Load(path))
+	assert.Equal(t, 50, *f.size)
+
+	// removed settings are reset to defaults and the command line still wins
+	assert.NoError(t, ioutil.WriteFile(path, []byte("ttl: 1h\nbind_addr: ':9191'\n"), 0644))
+	valid := func() error { return nil }
+	changed, err := l.Reload(path, valid)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"lru_size", "ttl"}, changed)
+	assert.Equal(t, time.Hour, *f.ttl)
+	assert.Equal(t, 20, *f.size)
+	assert.Equal(t, ":7070", *f.addr)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("lru_size: 10\nttl: never\n"), 0644))
+	_, err = l.Reload(path, valid)
+	assert.Error(t, err)
+	assert.Equal(t, time.Hour, *f.ttl)
+	assert.Equal(t, 20, *f.size)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("lru_size: -1\n"), 0644))
+	_, err = l.Reload(path, func() error {
+		var problems Problems
+		problems.Require(*f.size > 0, "lru_size must be positive")
+		return problems.Err()
+	})
+	assert.EqualError(t, err, "invalid configuration:\n  lru_size must be positive")
+	assert.Equal(t, 20, *f.size)
+}
@@ -0,0 +1,171 @@
+package consensus
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+
+	"github.com/hashicorp/raft"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+)
+
+// operations of commands
+const (
+	opSet        = "set"
+	opSetMany    = "set_many"
+	opDelete     = "delete"
+	opDeleteMany = "delete_many"
+	opSetStatus  = "set_status"
+	opReserve    = "reserve"
+	opRestore    = "restore"
+	// conditional updates apply if the driver is of the version on all nodes
+	opSetStatusIf     = "set_status_if"
+	opSetAttributesIf = "set_attributes_if"
+)
+
+type (
+	// Local is the in-memory storage of the node, commands are applied to it in the same order on all nodes
+	Local interface {
+		storage.Storage
+		Reserve(id int, until int64) bool
+		SetStatusIf(id int, status storage.Status, version uint64) (uint64, error)
+		SetAttributesIf(id int, attributes map[string]string, version uint64) (uint64, error)
+		WriteSnapshot(w io.Writer) error
+		ReadSnapshot(r io.Reader) error
+	}
+
+	// command is an entry of the Raft log, fields not used by its operation are zero.
+	// Timestamps are set by the leader, so all nodes apply the same locations.
+	command struct {
+		Op         string
+		Drivers    []driver
+		ID         int
+		IDs        []int
+		Status     storage.Status
+		Attributes map[string]string
+		Version    uint64
+		Until      int64
+		Snapshot   []byte
+	}
+
+	// versioned is the result of a conditional update, the version is of the driver also if the update fails
+	versioned struct {
+		version uint64
+		err     error
+	}
+
+	// driver is a storage.Driver in the log, speed and heading are computed by every node
+	driver struct {
+		ID         int
+		Location   storage.Location
+		Attributes map[string]string
+		Status     storage.Status
+		Timestamp  int64
+		Expiration int64
+	}
+
+	// fsm applies committed commands to the local storage
+	fsm struct {
+		db Local
+	}
+
+	// fsmSnapshot is a snapshot of the local storage in the format of snapshot files
+	fsmSnapshot struct {
+		data []byte
+	}
+)
+
+var _ raft.FSM = (*fsm)(nil)
+
+func newDriver(d *storage.Driver) driver {
+	return driver{
+		ID:         d.ID,
+		Location:   d.LastLocation,
+		Attributes: d.Attributes,
+		Status:     d.Status,
+		Timestamp:  d.Timestamp,
+		Expiration: d.Expiration,
+	}
+}
+
+func (d driver) driver() *storage.Driver {
+	return &storage.Driver{
+		ID:           d.ID,
+		LastLocation: d.Location,
+		Attributes:   d.Attributes,
+		Status:       d.Status,
+		Timestamp:    d.Timestamp,
+		Expiration:   d.Expiration,
+	}
+}
+
+func encode(c *command) ([]byte, error) {
+	var b bytes.Buffer
+	if err := gob.NewEncoder(&b).Encode(c); err != nil {
+		return nil, errors.Wrap(err, "could not encode command")
+	}
+	return b.Bytes(), nil
+}
+
+// Apply applies the command, it returns an error of the storage or whether a driver was reserved
+func (f *fsm) Apply(l *raft.Log) interface{} {
+	var c command
+	if err := gob.NewDecoder(bytes.NewReader(l.Data)).Decode(&c); err != nil {
+		return errors.Wrap(err, "could not decode command")
+	}
+	switch c.Op {
+	case opSet:
+		return f.db.Set(c.Drivers[0].driver())
+	case opSetMany:
+		ds := make([]*storage.Driver, len(c.Drivers))
+		for i, d := range c.Drivers {
+			ds[i] = d.driver()
+		}
+		return f.db.SetMany(ds)
+	case opDelete:
+		return f.db.Delete(c.ID)
+	case opDeleteMany:
+		return f.db.DeleteMany(c.IDs)
+	case opSetStatus:
+		return f.db.SetStatus(c.ID, c.Status)
+	case opSetStatusIf:
+		version, err := f.db.SetStatusIf(c.ID, c.Status, c.Version)
+		return versioned{version, err}
+	case opSetAttributesIf:
+		version, err := f.db.SetAttributesIf(c.ID, c.Attributes, c.Version)
+		return versioned{version, err}
+	case opReserve:
+		return f.db.Reserve(c.ID, c.Until)
+	case opRestore:
+		return f.db.ReadSnapshot(bytes.NewReader(c.Snapshot))
+	}
+	return errors.Errorf("unknown operation %q", c.Op)
+}
+
+// Snapshot captures drivers of the local storage, reservations are not kept
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	var b bytes.Buffer
+	if err := f.db.WriteSnapshot(&b); err != nil {
+		return nil, err
+	}
+	return &fsmSnapshot{data: b.Bytes()}, nil
+}
+
+// Restore replaces drivers of the local storage by the snapshot
+func (f *fsm) Restore(r io.ReadCloser) error {
+	defer r.Close()
+	return f.db.ReadSnapshot(r)
+}
+
+// Persist writes the snapshot to the sink
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s.data); err != nil {
+		sink.Cancel()
+		return errors.Wrap(err, "could not write snapshot")
+	}
+	return sink.Close()
+}
+
+// Release does nothing, the snapshot is a copy
+func (s *fsmSnapshot) Release() {}
@@ -0,0 +1,121 @@
+package consensus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// sink keeps the persisted snapshot in memory
+type sink struct {
+	bytes.Buffer
+	closed, cancelled bool
+}
+
+func (s *sink) ID() string    { return "test" }
+func (s *sink) Close() error  { s.closed = true; return nil }
+func (s *sink) Cancel() error { s.cancelled = true; return nil }
+
+// apply applies the command to the fsm as a committed log entry
+func apply(t *testing.T, f *fsm, c *command) interface{} {
+	t.Helper()
+	data, err := encode(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.Apply(&raft.Log{Data: data})
+}
+
+func TestFSM(t *testing.T) {
+	db := storage.New(5)
+	f := &fsm{db: db}
+	ts := time.Now().UnixNano()
+
+	d := driver{ID: 1, Location: storage.Location{Lat: 1, Lon: 1}, Attributes: map[string]string{"car": "van"}, Timestamp: ts}
+	assert.Nil(t, apply(t, f, &command{Op: opSet, Drivers: []driver{d}}))
+	assert.Equal(t, storage.ErrStaleLocation, apply(t, f, &command{Op: opSet, Drivers: []driver{{ID: 1, Location: storage.Location{Lat: 2, Lon: 2}, Timestamp: ts - 1}}}))
+	assert.Nil(t, apply(t, f, &command{Op: opSetMany, Drivers: []driver{
+		{ID: 2, Location: storage.Location{Lat: 2, Lon: 2}, Timestamp: ts},
+		{ID: 3, Location: storage.Location{Lat: 3, Lon: 3}, Timestamp: ts},
+	}}))
+	assert.Equal(t, 3, db.Len())
+	got, err := db.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, ts, got.Timestamp)
+	assert.Equal(t, "van", got.Attributes["car"])
+
+	assert.Nil(t, apply(t, f, &command{Op: opSetStatus, ID: 2, Status: storage.StatusBusy}))
+	got, _ = db.Get(2)
+	assert.Equal(t, storage.StatusBusy, got.Status)
+
+	assert.Equal(t, versioned{3, nil}, apply(t, f, &command{Op: opSetAttributesIf, ID: 2, Attributes: map[string]string{"car": "van"}, Version: 2}))
+	assert.Equal(t, versioned{3, storage.ErrVersionMismatch}, apply(t, f, &command{Op: opSetStatusIf, ID: 2, Status: storage.StatusAvailable, Version: 2}))
+	got, _ = db.Get(2)
+	assert.Equal(t, "van", got.Attributes["car"])
+
+	until := time.Now().Add(time.Minute).UnixNano()
+	assert.Equal(t, true, apply(t, f, &command{Op: opReserve, ID: 1, Until: until}))
+	assert.Equal(t, false, apply(t, f, &command{Op: opReserve, ID: 1, Until: until}))
+	assert.Equal(t, false, apply(t, f, &command{Op: opReserve, ID: 2, Until: until}))
+
+	assert.Nil(t, apply(t, f, &command{Op: opDelete, ID: 3}))
+	assert.Equal(t, storage.ErrDriverDoesNotExist, apply(t, f, &command{Op: opDelete, ID: 3}))
+	assert.Nil(t, apply(t, f, &command{Op: opDeleteMany, IDs: []int{2, 3}}))
+	assert.Equal(t, 1, db.Len())
+
+	assert.Error(t, apply(t, f, &command{Op: "unknown"}).(error))
+}
+
+func TestFSMSnapshot(t *testing.T) {
+	db := storage.New(5)
+	f := &fsm{db: db}
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+
+	snap, err := f.Snapshot()
+	assert.NoError(t, err)
+	var s sink
+	assert.NoError(t, snap.Persist(&s))
+	snap.Release()
+	assert.True(t, s.closed)
+	assert.False(t, s.cancelled)
+
+	// a restored follower has drivers of the snapshot only
+	other := storage.New(5)
+	assert.NoError(t, other.Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: 2, Lon: 2}}))
+	data := s.Bytes()
+	assert.NoError(t, (&fsm{db: other}).Restore(ioutil.NopCloser(bytes.NewReader(data))))
+	assert.Equal(t, 1, other.Len())
+	_, err = other.Get(1)
+	assert.NoError(t, err)
+
+	// snapshots uploaded to the leader are committed as restore commands
+	third := storage.New(5)
+	assert.Nil(t, apply(t, &fsm{db: third}, &command{Op: opRestore, Snapshot: data}))
+	assert.Equal(t, 1, third.Len())
+}
+
+func TestParsePeers(t *testing.T) {
+	peers, err := ParsePeers("n1=10.0.0.1:7000, n2=10.0.0.2:7000,")
+	assert.NoError(t, err)
+	assert.Equal(t, []Peer{{ID: "n1", Addr: "10.0.0.1:7000"}, {ID: "n2", Addr: "10.0.0.2:7000"}}, peers)
+
+	peers, err = ParsePeers("")
+	assert.NoError(t, err)
+	assert.Empty(t, peers)
+
+	for _, s := range []string{"n1", "=10.0.0.1:7000", "n1="} {
+		_, err = ParsePeers(s)
+		assert.Equal(t, ErrInvalidPeer, errors.Cause(err), s)
+	}
+}
+
+func TestNewNotInPeers(t *testing.T) {
+	_, err := New(Config{ID: "n3", Addr: "127.0.0.1:0", Dir: t.TempDir(), Peers: []Peer{{ID: "n1", Addr: "127.0.0.1:7001"}}}, storage.New(5))
+	assert.Equal(t, ErrNotInPeers, err)
+}
@@ -0,0 +1,219 @@
+// Package consensus replicates writes of the default namespace across nodes with Raft.
+// Writes are committed by the leader to a majority of nodes before they are applied,
+// so the index survives loss of a minority of nodes. Reads are served by every node from its local storage.
+package consensus
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// defaultApplyTimeout limits how long a write waits to be committed
+const defaultApplyTimeout = 5 * time.Second
+
+// retainSnapshots is number of Raft snapshots kept in the directory
+const retainSnapshots = 2
+
+// maxPool is number of connections kept to every peer
+const maxPool = 3
+
+// transportTimeout limits writes to peers
+const transportTimeout = 10 * time.Second
+
+var (
+	// ErrNotLeader sign what writes are accepted by the leader only
+	ErrNotLeader = errors.New("Node is not the leader")
+	// ErrNoLeader sign what the nodes haven't elected a leader, e.g. a majority of them is down
+	ErrNoLeader = errors.New("Raft cluster has no leader")
+	// ErrNotInPeers sign what id of the node is not one of the peers
+	ErrNotInPeers = errors.New("Node is not one of the peers")
+	// ErrInvalidPeer sign what a peer is not id=host:port
+	ErrInvalidPeer = errors.New("Invalid Raft peer, must be id=host:port")
+)
+
+type (
+	// Peer is a node of the Raft cluster, Addr is its Raft address
+	Peer struct {
+		ID   string
+		Addr string
+	}
+
+	// Config of the node. Peers bootstrap the cluster on the first start, they are ignored after it,
+	// all nodes must be started with the same peers.
+	Config struct {
+		ID           string
+		Addr         string
+		Dir          string
+		Peers        []Peer
+		ApplyTimeout time.Duration
+		// TTL is expiration of drivers set without one, it must be the TTL of the local storage
+		TTL time.Duration
+		// SnapshotInterval and SnapshotThreshold override defaults of Raft if set
+		SnapshotInterval  time.Duration
+		SnapshotThreshold uint64
+	}
+
+	// Node is a member of the Raft cluster, it's the storage of the default namespace
+	Node struct {
+		raft      *raft.Raft
+		db        Local
+		timeout   time.Duration
+		ttl       time.Duration
+		store     *raftboltdb.BoltStore
+		transport *raft.NetworkTransport
+	}
+)
+
+// ParsePeers parses comma separated id=host:port peers
+func ParsePeers(s string) ([]Peer, error) {
+	var peers []Peer
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		i := strings.Index(p, "=")
+		if i <= 0 || i == len(p)-1 {
+			return nil, errors.Wrap(ErrInvalidPeer, p)
+		}
+		peers = append(peers, Peer{ID: p[:i], Addr: p[i+1:]})
+	}
+	return peers, nil
+}
+
+// New starts the node applying committed writes to db. The Raft log, its stable state and snapshots are kept in Dir.
+func New(config Config, db Local) (*Node, error) {
+	if len(config.Peers) > 0 {
+		found := false
+		for _, p := range config.Peers {
+			found = found || p.ID == config.ID
+		}
+		if !found {
+			return nil, ErrNotInPeers
+		}
+	}
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "could not create Raft directory")
+	}
+	timeout := config.ApplyTimeout
+	if timeout <= 0 {
+		timeout = defaultApplyTimeout
+	}
+
+	// messages of Raft are logged by zap
+	logs := zap.NewStdLog(zap.L().Named("raft")).Writer()
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(config.ID)
+	conf.LogOutput = logs
+	if config.SnapshotInterval > 0 {
+		conf.SnapshotInterval = config.SnapshotInterval
+	}
+	if config.SnapshotThreshold > 0 {
+		conf.SnapshotThreshold = config.SnapshotThreshold
+	}
+
+	advertise, err := net.ResolveTCPAddr("tcp", config.Addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not resolve Raft address")
+	}
+	transport, err := raft.NewTCPTransport(config.Addr, advertise, maxPool, transportTimeout, logs)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not listen Raft address")
+	}
+	snapshots, err := raft.NewFileSnapshotStore(config.Dir, retainSnapshots, logs)
+	if err != nil {
+		transport.Close()
+		return nil, errors.Wrap(err, "could not open Raft snapshots")
+	}
+	store, err := raftboltdb.NewBoltStore(filepath.Join(config.Dir, "raft.db"))
+	if err != nil {
+		transport.Close()
+		return nil, errors.Wrap(err, "could not open Raft log")
+	}
+	n := &Node{db: db, timeout: timeout, ttl: config.TTL, store: store, transport: transport}
+	fail := func(err error) (*Node, error) {
+		n.Close()
+		return nil, err
+	}
+
+	exists, err := raft.HasExistingState(store, store, snapshots)
+	if err != nil {
+		return fail(errors.Wrap(err, "could not read Raft state"))
+	}
+	n.raft, err = raft.NewRaft(conf, &fsm{db: db}, store, store, snapshots, transport)
+	if err != nil {
+		return fail(errors.Wrap(err, "could not start Raft"))
+	}
+	if !exists && len(config.Peers) > 0 {
+		var servers []raft.Server
+		for _, p := range config.Peers {
+			servers = append(servers, raft.Server{Suffrage: raft.Voter, ID: raft.ServerID(p.ID), Address: raft.ServerAddress(p.Addr)})
+		}
+		if err := n.raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error(); err != nil {
+			return fail(errors.Wrap(err, "could not bootstrap Raft cluster"))
+		}
+	}
+	return n, nil
+}
+
+// Close leaves the cluster and closes the Raft log, the local storage is not closed
+func (n *Node) Close() error {
+	var err error
+	if n.raft != nil {
+		err = n.raft.Shutdown().Error()
+	}
+	if e := n.transport.Close(); err == nil {
+		err = e
+	}
+	if e := n.store.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// Leader returns id of the leader, empty if there is none
+func (n *Node) Leader() string {
+	_, id := n.raft.LeaderWithID()
+	return string(id)
+}
+
+// Ping fails while the cluster has no leader, writes would fail then
+func (n *Node) Ping(ctx context.Context) error {
+	if n.Leader() == "" {
+		return ErrNoLeader
+	}
+	return nil
+}
+
+// apply commits the command and returns the result of its application by the leader
+func (n *Node) apply(c *command) (interface{}, error) {
+	if n.raft.State() != raft.Leader {
+		if leader := n.Leader(); leader != "" {
+			return nil, errors.Wrapf(ErrNotLeader, "leader is %s", leader)
+		}
+		return nil, ErrNoLeader
+	}
+	data, err := encode(c)
+	if err != nil {
+		return nil, err
+	}
+	f := n.raft.Apply(data, n.timeout)
+	if err := f.Error(); err != nil {
+		if err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+			return nil, errors.Wrap(ErrNotLeader, err.Error())
+		}
+		return nil, errors.Wrap(err, "could not commit write")
+	}
+	if err, ok := f.Response().(error); ok {
+		return nil, err
+	}
+	return f.Response(), nil
+}
@@ -0,0 +1,175 @@
+package consensus
+
+import (
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// candidatesFactor widens nearest drivers found as reservation candidates
+const candidatesFactor = 4
+
+var (
+	_ storage.Storage = (*Node)(nil)
+	_ storage.Pinger  = (*Node)(nil)
+)
+
+// Set commits the driver
+func (n *Node) Set(d *storage.Driver) error {
+	_, err := n.apply(&command{Op: opSet, Drivers: []driver{n.newDriver(d, time.Now().UnixNano())}})
+	return err
+}
+
+// SetMany commits the drivers in one entry
+func (n *Node) SetMany(ds []*storage.Driver) error {
+	now := time.Now().UnixNano()
+	c := &command{Op: opSetMany, Drivers: make([]driver, len(ds))}
+	for i, d := range ds {
+		c.Drivers[i] = n.newDriver(d, now)
+	}
+	_, err := n.apply(c)
+	return err
+}
+
+// newDriver returns the driver located and expiring at the time of the leader if they are not set,
+// so all nodes apply the same timestamps and expire the driver at the same time
+func (n *Node) newDriver(d *storage.Driver, now int64) driver {
+	if d.Timestamp == 0 {
+		d.Timestamp = now
+	}
+	if d.Expiration == 0 && n.ttl > 0 {
+		d.Expiration = now + int64(n.ttl)
+	}
+	return newDriver(d)
+}
+
+// Get gets the driver of the local storage
+func (n *Node) Get(id int) (*storage.Driver, error) {
+	return n.db.Get(id)
+}
+
+// List lists drivers of the local storage
+func (n *Node) List(after, limit int) []*storage.Driver {
+	return n.db.List(after, limit)
+}
+
+// History returns locations of the driver of the local storage
+func (n *Node) History(id int, from, to int64) ([]storage.HistoryPoint, error) {
+	return n.db.History(id, from, to)
+}
+
+// Delete commits deletion of the driver, it fails if the driver is missing on the leader
+func (n *Node) Delete(id int) error {
+	_, err := n.apply(&command{Op: opDelete, ID: id})
+	return err
+}
+
+// DeleteMany commits deletion of the drivers, missing ones are skipped
+func (n *Node) DeleteMany(ids []int) error {
+	_, err := n.apply(&command{Op: opDeleteMany, IDs: ids})
+	return err
+}
+
+// SetStatus commits the status of the driver
+func (n *Node) SetStatus(id int, status storage.Status) error {
+	_, err := n.apply(&command{Op: opSetStatus, ID: id, Status: status})
+	return err
+}
+
+// SetStatusIf commits the status of the driver if it's of the version
+func (n *Node) SetStatusIf(id int, status storage.Status, version uint64) (uint64, error) {
+	return n.applyIf(&command{Op: opSetStatusIf, ID: id, Status: status, Version: version})
+}
+
+// SetAttributesIf commits attributes of the driver if it's of the version
+func (n *Node) SetAttributesIf(id int, attributes map[string]string, version uint64) (uint64, error) {
+	return n.applyIf(&command{Op: opSetAttributesIf, ID: id, Attributes: attributes, Version: version})
+}
+
+// applyIf commits the conditional update and returns the version of the driver on the leader
+func (n *Node) applyIf(c *command) (uint64, error) {
+	res, err := n.apply(c)
+	if err != nil {
+		return 0, err
+	}
+	v := res.(versioned)
+	return v.version, v.err
+}
+
+// Nearest finds nearest drivers of the local storage, followers may lag behind the leader
+func (n *Node) Nearest(point rtreego.Point, count int, filters ...storage.Filter) []*storage.Driver {
+	return n.db.Nearest(point, count, filters...)
+}
+
+// NearestAndLock reserves nearest drivers on the leader, followers reserve nothing.
+// Candidates are found without locking and reserved one by one, skipping those reserved meanwhile.
+func (n *Node) NearestAndLock(point rtreego.Point, count int, ttl time.Duration, filters ...storage.Filter) []*storage.Driver {
+	candidates := n.db.Nearest(point, count*candidatesFactor, append(filters, storage.Available())...)
+	until := time.Now().Add(ttl).UnixNano()
+
+	var ds []*storage.Driver
+	for _, d := range candidates {
+		if len(ds) == count {
+			break
+		}
+		reserved, err := n.apply(&command{Op: opReserve, ID: d.ID, Until: until})
+		if err != nil {
+			zap.L().Warn("could not reserve driver", zap.Int("driver", d.ID), zap.Error(err))
+			return ds
+		}
+		if reserved.(bool) {
+			ds = append(ds, d)
+		}
+	}
+	return ds
+}
+
+// InBoundingBox finds drivers of the local storage inside the bounding box
+func (n *Node) InBoundingBox(minLat, minLon, maxLat, maxLon float64) ([]*storage.Driver, error) {
+	return n.db.InBoundingBox(minLat, minLon, maxLat, maxLon)
+}
+
+// InPolygon finds drivers of the local storage inside the polygon
+func (n *Node) InPolygon(polygon storage.Polygon) ([]*storage.Driver, error) {
+	return n.db.InPolygon(polygon)
+}
+
+// Heatmap counts drivers of the local storage per cell
+func (n *Node) Heatmap(precision int) ([]storage.HeatmapCell, error) {
+	return n.db.Heatmap(precision)
+}
+
+// DeleteExpired deletes expired drivers of the local storage, every node runs its janitor
+func (n *Node) DeleteExpired() {
+	n.db.DeleteExpired()
+}
+
+// Len returns number of drivers of the local storage
+func (n *Node) Len() int {
+	return n.db.Len()
+}
+
+// Stats returns counters of the local storage
+func (n *Node) Stats() storage.Stats {
+	return n.db.Stats()
+}
+
+// WriteSnapshot writes snapshot of the local storage
+func (n *Node) WriteSnapshot(w io.Writer) error {
+	return n.db.WriteSnapshot(w)
+}
+
+// ReadSnapshot commits the snapshot, all nodes replace their drivers by it
+func (n *Node) ReadSnapshot(r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "could not read snapshot")
+	}
+	_, err = n.apply(&command{Op: opRestore, Snapshot: data})
+	return err
+}
@@ -0,0 +1,301 @@
+package geofence
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sync"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Event types
+const (
+	Enter = "enter"
+	Exit  = "exit"
+)
+
+// pendingEvents is how many events may wait for delivery to handlers
+const pendingEvents = 1024
+
+var (
+	// ErrInvalidFence sign what fence has no name or neither a valid polygon nor a circle
+	ErrInvalidFence = errors.New("Invalid fence")
+	// ErrFenceDoesNotExist sign what fence does not exist
+	ErrFenceDoesNotExist = errors.New("Fence does not exist")
+)
+
+type (
+	// Fence is a named polygon or a circle with center and radius in meters
+	Fence struct {
+		Name    string            `json:"name"`
+		Polygon storage.Polygon   `json:"polygon,omitempty"`
+		Center  *storage.Location `json:"center,omitempty"`
+		Radius  float64           `json:"radius,omitempty"`
+	}
+	// Event is a driver entering or exiting a fence. Seq is increasing
+	// event number, Timestamp is unix nanoseconds of the driver location.
+	Event struct {
+		Seq       uint64           `json:"seq"`
+		Type      string           `json:"type"`
+		Fence     string           `json:"fence"`
+		DriverID  int              `json:"driver_id"`
+		Location  storage.Location `json:"location"`
+		Timestamp int64            `json:"timestamp"`
+	}
+	// Handler receives events asynchronously in order
+	Handler func(e Event)
+
+	// Manager keeps fences, tracks which drivers are inside them and records events.
+	// It observes the storage, so drivers are checked against fences as they move.
+	Manager struct {
+		mu        sync.RWMutex
+		fences    map[string]*Fence
+		drivers   map[int]*driverState
+		events    []Event
+		maxEvents int
+		seq       uint64
+		handlers  []Handler
+		pending   chan Event
+		done      chan struct{}
+	}
+
+	driverState struct {
+		location storage.Location
+		inside   map[string]bool
+	}
+)
+
+var _ storage.Observer = (*Manager)(nil)
+
+// Valid returns true if fence has a name and either a valid polygon or a circle
+func (f *Fence) Valid() bool {
+	if f.Name == "" {
+		return false
+	}
+	if f.Center != nil {
+		return f.Radius > 0 && f.Polygon == nil
+	}
+	return f.Polygon.Valid()
+}
+
+// Contains returns true if location is inside the fence
+func (f *Fence) Contains(l storage.Location) bool {
+	if f.Center != nil {
+		return storage.Distance(*f.Center, l) <= f.Radius
+	}
+	return f.Polygon.Contains(l)
+}
+
+// New creates Manager keeping up to maxEvents latest events
+func New(maxEvents int) *Manager {
+	m := &Manager{
+		fences:    make(map[string]*Fence),
+		drivers:   make(map[int]*driverState),
+		maxEvents: maxEvents,
+		pending:   make(chan Event, pendingEvents),
+		done:      make(chan struct{}),
+	}
+	go m.deliver()
+	return m
+}
+
+// Close stops delivery of events to handlers
+func (m *Manager) Close() {
+	close(m.pending)
+	<-m.done
+}
+
+// Subscribe adds a handler of all future events, call it before the storage is used
+func (m *Manager) Subscribe(h Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, h)
+}
+
+// Add adds or replaces the fence. Drivers are checked against it when they move next time.
+func (m *Manager) Add(f Fence) error {
+	if !f.Valid() {
+		return ErrInvalidFence
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fences[f.Name] = &f
+	for _, d := range m.drivers {
+		delete(d.inside, f.Name)
+	}
+	return nil
+}
+
+// Remove removes the fence without emitting exit events
+func (m *Manager) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.fences[name]; !ok {
+		return ErrFenceDoesNotExist
+	}
+	delete(m.fences, name)
+	for _, d := range m.drivers {
+		delete(d.inside, name)
+	}
+	return nil
+}
+
+// Replace replaces fences of the names by the fences, other fences are kept. Drivers stay inside
+// unchanged fences, so they don't enter them again. Nothing is replaced if any of the fences is invalid.
+func (m *Manager) Replace(names []string, fences []Fence) error {
+	for i := range fences {
+		if !fences[i].Valid() {
+			return errors.Wrap(ErrInvalidFence, fences[i].Name)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := make(map[string]bool, len(fences))
+	for i := range fences {
+		f := fences[i]
+		kept[f.Name] = true
+		if old, ok := m.fences[f.Name]; ok && reflect.DeepEqual(*old, f) {
+			continue
+		}
+		m.fences[f.Name] = &f
+		for _, d := range m.drivers {
+			delete(d.inside, f.Name)
+		}
+	}
+	for _, name := range names {
+		if kept[name] {
+			continue
+		}
+		delete(m.fences, name)
+		for _, d := range m.drivers {
+			delete(d.inside, name)
+		}
+	}
+	return nil
+}
+
+// ReadFile returns fences of the JSON file of a fence array
+func ReadFile(path string) ([]Fence, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read fences")
+	}
+	var fences []Fence
+	if err := json.Unmarshal(b, &fences); err != nil {
+		return nil, errors.Wrapf(err, "could not parse %s", path)
+	}
+	return fences, nil
+}
+
+// Fences returns all fences
+func (m *Manager) Fences() []Fence {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	fences := make([]Fence, 0, len(m.fences))
+	for _, f := range m.fences {
+		fences = append(fences, *f)
+	}
+	return fences
+}
+
+// Events returns up to limit kept events with Seq greater than after
+func (m *Manager) Events(after uint64, limit int) []Event {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	events := []Event{}
+	for _, e := range m.events {
+		if len(events) >= limit {
+			break
+		}
+		if e.Seq > after {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// DriverMoved emits enter and exit events of fences the driver crossed
+func (m *Manager) DriverMoved(id int, location storage.Location, ts int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.drivers[id]
+	if !ok {
+		d = &driverState{inside: make(map[string]bool)}
+		m.drivers[id] = d
+	}
+	d.location = location
+	// exits go first, a driver moving between fences leaves one before entering another
+	var entered []string
+	for name, f := range m.fences {
+		inside := f.Contains(location)
+		if inside == d.inside[name] {
+			continue
+		}
+		if inside {
+			entered = append(entered, name)
+			continue
+		}
+		delete(d.inside, name)
+		m.emit(Event{Type: Exit, Fence: name, DriverID: id, Location: location, Timestamp: ts})
+	}
+	for _, name := range entered {
+		d.inside[name] = true
+		m.emit(Event{Type: Enter, Fence: name, DriverID: id, Location: location, Timestamp: ts})
+	}
+}
+
+// DriverRemoved emits exit events of fences the driver was inside
+func (m *Manager) DriverRemoved(id int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.drivers[id]
+	if !ok {
+		return
+	}
+	delete(m.drivers, id)
+	for name := range d.inside {
+		m.emit(Event{Type: Exit, Fence: name, DriverID: id, Location: d.location})
+	}
+}
+
+// emit records the event and queues it for handlers, it's called under the lock
+func (m *Manager) emit(e Event) {
+	m.seq++
+	e.Seq = m.seq
+	m.events = append(m.events, e)
+	if len(m.events) > m.maxEvents {
+		m.events = m.events[len(m.events)-m.maxEvents:]
+	}
+	if len(m.handlers) == 0 {
+		return
+	}
+	select {
+	case m.pending <- e:
+	default:
+		zap.L().Warn("geofence event dropped, handlers are too slow", zap.Uint64("seq", e.Seq))
+	}
+}
+
+func (m *Manager) deliver() {
+	defer close(m.done)
+	for e := range m.pending {
+		m.mu.RLock()
+		handlers := m.handlers
+		m.mu.RUnlock()
+		for _, h := range handlers {
+			h(e)
+		}
+	}
+}
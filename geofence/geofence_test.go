@@ -0,0 +1,74 @@
+package geofence
+
+import (
+	"testing"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager(t *testing.T) {
+	m := New(10)
+	defer m.Close()
+
+	square := storage.Polygon{{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 10}, {Lat: 10, Lon: 10}, {Lat: 10, Lon: 0}}}
+	assert.NoError(t, m.Add(Fence{Name: "square", Polygon: square}))
+	assert.NoError(t, m.Add(Fence{Name: "circle", Center: &storage.Location{Lat: 20, Lon: 20}, Radius: 1000}))
+	assert.Equal(t, ErrInvalidFence, m.Add(Fence{Name: "empty"}))
+	assert.Len(t, m.Fences(), 2)
+
+	delivered := make(chan Event, 10)
+	m.Subscribe(func(e Event) { delivered <- e })
+
+	s := storage.New(10, storage.WithObserver(m))
+	assert.NoError(t, s.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 5, Lon: 5}}))
+	assert.NoError(t, s.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 6, Lon: 6}}))
+	assert.NoError(t, s.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 20, Lon: 20}}))
+	assert.NoError(t, s.Delete(1))
+
+	events := m.Events(0, 10)
+	var got []string
+	for _, e := range events {
+		assert.Equal(t, 1, e.DriverID)
+		got = append(got, e.Type+" "+e.Fence)
+	}
+	assert.Equal(t, []string{"enter square", "exit square", "enter circle", "exit circle"}, got)
+	assert.Len(t, m.Events(events[1].Seq, 10), 2)
+	assert.Len(t, m.Events(0, 1), 1)
+
+	for i := range events {
+		assert.Equal(t, events[i], <-delivered)
+	}
+
+	assert.NoError(t, m.Remove("square"))
+	assert.Equal(t, ErrFenceDoesNotExist, m.Remove("square"))
+}
+
+func TestReplace(t *testing.T) {
+	m := New(10)
+	defer m.Close()
+
+	square := Fence{Name: "square", Polygon: storage.Polygon{{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 10}, {Lat: 10, Lon: 10}, {Lat: 10, Lon: 0}}}}
+	circle := Fence{Name: "circle", Center: &storage.Location{Lat: 5, Lon: 5}, Radius: 1000}
+	assert.NoError(t, m.Add(Fence{Name: "api", Center: &storage.Location{Lat: 50, Lon: 50}, Radius: 1}))
+	assert.NoError(t, m.Replace(nil, []Fence{square, circle}))
+
+	s := storage.New(10, storage.WithObserver(m))
+	assert.NoError(t, s.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 5, Lon: 5}}))
+	assert.Len(t, m.Events(0, 10), 2)
+
+	// the unchanged square is kept, the changed circle is entered again
+	bigger := circle
+	bigger.Radius = 2000
+	assert.NoError(t, m.Replace([]string{"square", "circle"}, []Fence{square, bigger}))
+	assert.NoError(t, s.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 5, Lon: 5.001}}))
+	events := m.Events(2, 10)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "circle", events[0].Fence)
+	}
+
+	assert.Error(t, m.Replace([]string{"square", "circle"}, []Fence{{Name: "invalid"}}))
+	assert.Len(t, m.Fences(), 3)
+	assert.NoError(t, m.Replace([]string{"square", "circle"}, []Fence{square}))
+	assert.Len(t, m.Fences(), 2)
+}
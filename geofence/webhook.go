@@ -0,0 +1,60 @@
+package geofence
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// webhookTimeout limits delivery of a single event
+const webhookTimeout = 5 * time.Second
+
+// Target posts events as JSON to its URL, the URL may be changed while events are delivered
+type Target struct {
+	url    atomic.Value
+	client *http.Client
+}
+
+// NewTarget creates Target of the url, events are skipped while it's empty
+func NewTarget(url string) *Target {
+	t := &Target{client: &http.Client{Timeout: webhookTimeout}}
+	t.url.Store(url)
+	return t
+}
+
+// SetURL changes URL of next events
+func (t *Target) SetURL(url string) {
+	t.url.Store(url)
+}
+
+// Handle posts the event, failed deliveries are logged and not retried
+func (t *Target) Handle(e Event) {
+	url := t.url.Load().(string)
+	if url == "" {
+		return
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		zap.L().Error("could not encode geofence event", zap.Error(err))
+		return
+	}
+	resp, err := t.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		zap.L().Warn("could not deliver geofence event", zap.Uint64("seq", e.Seq), zap.Error(err))
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		zap.L().Warn("could not deliver geofence event", zap.Uint64("seq", e.Seq), zap.Int("status", resp.StatusCode))
+	}
+}
+
+// Webhook returns a handler posting every event as JSON to the url.
+// Failed deliveries are logged and not retried.
+func Webhook(url string) Handler {
+	return NewTarget(url).Handle
+}
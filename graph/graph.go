@@ -0,0 +1,303 @@
+package graph
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/dhconnelly/rtreego"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+)
+
+const schema = `
+	schema {
+		query: Query
+	}
+
+	# 64-bit integer, e.g. unix nanoseconds. Pass it as a string in queries.
+	scalar Int64
+
+	type Query {
+		driver(id: Int!): Driver
+		drivers(after: Int = 0, limit: Int = 100): [Driver!]!
+		nearest(
+			lat: Float!
+			lon: Float!
+			count: Int = 10
+			includeUnavailable: Boolean = false
+			attributes: [AttributeInput!]
+		): [NearestDriver!]!
+	}
+
+	type Driver {
+		id: Int!
+		location: Location!
+		attributes: [Attribute!]!
+		status: String!
+		speed: Float!
+		heading: Float!
+		timestamp: Int64!
+		history(from: Int64, to: Int64): [HistoryPoint!]!
+	}
+
+	type NearestDriver {
+		driver: Driver!
+		distance: Float!
+		etaSeconds: Float!
+	}
+
+	type Location {
+		lat: Float!
+		lon: Float!
+	}
+
+	type Attribute {
+		key: String!
+		value: String!
+	}
+
+	input AttributeInput {
+		key: String!
+		value: String!
+	}
+
+	type HistoryPoint {
+		timestamp: Int64!
+		location: Location!
+	}
+`
+
+// ErrInvalidInt64 sign what Int64 value is neither an integer nor a string of it
+var ErrInvalidInt64 = errors.New("Invalid Int64")
+
+type (
+	// Schema executes GraphQL queries of drivers
+	Schema struct {
+		schema *graphql.Schema
+	}
+
+	// Request is a GraphQL request
+	Request struct {
+		Query         string                 `json:"query"`
+		OperationName string                 `json:"operationName"`
+		Variables     map[string]interface{} `json:"variables"`
+	}
+
+	storageKey struct{}
+
+	resolver struct {
+		averageSpeed float64
+	}
+
+	driverResolver struct {
+		d  *storage.Driver
+		db storage.Storage
+	}
+
+	nearestResolver struct {
+		driver     *driverResolver
+		distance   float64
+		etaSeconds float64
+	}
+
+	attribute struct {
+		Key   string
+		Value string
+	}
+
+	historyPoint struct {
+		Timestamp Int64
+		Location  storage.Location
+	}
+
+	// Int64 is a 64-bit integer scalar, GraphQL Int is 32-bit
+	Int64 int64
+)
+
+// New creates Schema, averageSpeed in meters per second is used for ETA of standing drivers
+func New(averageSpeed float64) *Schema {
+	return &Schema{
+		schema: graphql.MustParseSchema(schema, &resolver{averageSpeed: averageSpeed}, graphql.UseFieldResolvers()),
+	}
+}
+
+// Exec executes the request against the storage
+func (s *Schema) Exec(ctx context.Context, db storage.Storage, r Request) *graphql.Response {
+	ctx = context.WithValue(ctx, storageKey{}, db)
+	return s.schema.Exec(ctx, r.Query, r.OperationName, r.Variables)
+}
+
+func database(ctx context.Context) storage.Storage {
+	return ctx.Value(storageKey{}).(storage.Storage)
+}
+
+func (r *resolver) Driver(ctx context.Context, args struct{ ID int32 }) (*driverResolver, error) {
+	db := database(ctx)
+	d, err := db.Get(int(args.ID))
+	if err == storage.ErrDriverDoesNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &driverResolver{d: d, db: db}, nil
+}
+
+func (r *resolver) Drivers(ctx context.Context, args struct {
+	After int32
+	Limit int32
+}) ([]*driverResolver, error) {
+	if args.Limit <= 0 {
+		return nil, errors.New("limit must be a positive integer")
+	}
+	db := database(ctx)
+	drivers := db.List(int(args.After), int(args.Limit))
+	resolvers := make([]*driverResolver, len(drivers))
+	for i, d := range drivers {
+		resolvers[i] = &driverResolver{d: d, db: db}
+	}
+	return resolvers, nil
+}
+
+func (r *resolver) Nearest(ctx context.Context, args struct {
+	Lat                float64
+	Lon                float64
+	Count              int32
+	IncludeUnavailable bool
+	Attributes         *[]attribute
+}) ([]*nearestResolver, error) {
+	if args.Count <= 0 {
+		return nil, errors.New("count must be a positive integer")
+	}
+	origin := storage.Location{Lat: args.Lat, Lon: args.Lon}
+	if !origin.Valid() {
+		return nil, storage.ErrInvalidLocation
+	}
+
+	var filters []storage.Filter
+	if !args.IncludeUnavailable {
+		filters = append(filters, storage.Available())
+	}
+	if args.Attributes != nil && len(*args.Attributes) > 0 {
+		attributes := make(map[string]string, len(*args.Attributes))
+		for _, a := range *args.Attributes {
+			attributes[a.Key] = a.Value
+		}
+		filters = append(filters, storage.AttributesFilter(attributes))
+	}
+
+	db := database(ctx)
+	drivers := db.Nearest(rtreego.Point{args.Lat, args.Lon}, int(args.Count), filters...)
+	nearest := make([]*nearestResolver, len(drivers))
+	for i, d := range drivers {
+		nearest[i] = &nearestResolver{
+			driver:     &driverResolver{d: d, db: db},
+			distance:   storage.Distance(origin, d.LastLocation),
+			etaSeconds: storage.ETA(d, origin, r.averageSpeed).Seconds(),
+		}
+	}
+	return nearest, nil
+}
+
+func (r *driverResolver) ID() int32 {
+	return int32(r.d.ID)
+}
+
+func (r *driverResolver) Location() storage.Location {
+	return r.d.LastLocation
+}
+
+func (r *driverResolver) Attributes() []attribute {
+	attributes := make([]attribute, 0, len(r.d.Attributes))
+	for k, v := range r.d.Attributes {
+		attributes = append(attributes, attribute{Key: k, Value: v})
+	}
+	return attributes
+}
+
+func (r *driverResolver) Status() string {
+	return string(r.d.Status)
+}
+
+func (r *driverResolver) Speed() float64 {
+	return r.d.Speed
+}
+
+func (r *driverResolver) Heading() float64 {
+	return r.d.Heading
+}
+
+func (r *driverResolver) Timestamp() Int64 {
+	return Int64(r.d.Timestamp)
+}
+
+func (r *driverResolver) History(args struct{ From, To *Int64 }) ([]historyPoint, error) {
+	var from, to int64
+	if args.From != nil {
+		from = int64(*args.From)
+	}
+	if args.To != nil {
+		to = int64(*args.To)
+	}
+	points, err := r.db.History(r.d.ID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]historyPoint, len(points))
+	for i, p := range points {
+		history[i] = historyPoint{Timestamp: Int64(p.Timestamp), Location: p.Location}
+	}
+	return history, nil
+}
+
+func (r *nearestResolver) Driver() *driverResolver {
+	return r.driver
+}
+
+func (r *nearestResolver) Distance() float64 {
+	return r.distance
+}
+
+func (r *nearestResolver) EtaSeconds() float64 {
+	return r.etaSeconds
+}
+
+// ImplementsGraphQLType maps Int64 to the schema scalar
+func (Int64) ImplementsGraphQLType(name string) bool {
+	return name == "Int64"
+}
+
+// UnmarshalGraphQL parses Int64 from a string or a number
+func (i *Int64) UnmarshalGraphQL(input interface{}) error {
+	switch v := input.(type) {
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return ErrInvalidInt64
+		}
+		*i = Int64(n)
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return ErrInvalidInt64
+		}
+		*i = Int64(n)
+	case int32:
+		*i = Int64(v)
+	case float64:
+		if v != float64(int64(v)) {
+			return ErrInvalidInt64
+		}
+		*i = Int64(v)
+	default:
+		return ErrInvalidInt64
+	}
+	return nil
+}
+
+// MarshalJSON writes Int64 as a number
+func (i Int64) MarshalJSON() ([]byte, error) {
+	return strconv.AppendInt(nil, int64(i), 10), nil
+}
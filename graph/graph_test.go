@@ -0,0 +1,62 @@
+package graph
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchema(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}, Timestamp: 1792110580758249075}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1.01, Lon: 1}, Timestamp: 1792110580758249076}))
+	assert.NoError(t, db.Set(&storage.Driver{
+		ID:           2,
+		LastLocation: storage.Location{Lat: 2, Lon: 2},
+		Attributes:   map[string]string{"vehicle_class": "minivan"},
+	}))
+	s := New(8.3)
+
+	resp := s.Exec(context.Background(), db, Request{Query: `{
+		driver(id: 1) {
+			id
+			location { lat lon }
+			timestamp
+			history(from: "1792110580758249076") { timestamp location { lat } }
+		}
+		missing: driver(id: 42) { id }
+	}`})
+	assert.Empty(t, resp.Errors)
+	assert.JSONEq(t, `{
+		"driver": {
+			"id": 1,
+			"location": {"lat": 1.01, "lon": 1},
+			"timestamp": 1792110580758249076,
+			"history": [{"timestamp": 1792110580758249076, "location": {"lat": 1.01}}]
+		},
+		"missing": null
+	}`, string(resp.Data))
+
+	resp = s.Exec(context.Background(), db, Request{
+		Query: `query($attrs: [AttributeInput!]) {
+			nearest(lat: 1, lon: 1, attributes: $attrs) { driver { id attributes { key value } } distance }
+		}`,
+		Variables: map[string]interface{}{
+			"attrs": []interface{}{map[string]interface{}{"key": "vehicle_class", "value": "minivan"}},
+		},
+	})
+	assert.Empty(t, resp.Errors)
+	assert.Contains(t, string(resp.Data), `"attributes":[{"key":"vehicle_class","value":"minivan"}]`)
+	assert.NotContains(t, string(resp.Data), `"id":1`)
+
+	resp = s.Exec(context.Background(), db, Request{Query: `{ drivers(after: 1) { id } }`})
+	assert.Empty(t, resp.Errors)
+	assert.JSONEq(t, `{"drivers": [{"id": 2}]}`, string(resp.Data))
+
+	resp = s.Exec(context.Background(), db, Request{Query: `{ nearest(lat: 1, lon: 1, count: 0) { distance } }`})
+	assert.NotEmpty(t, resp.Errors)
+	resp = s.Exec(context.Background(), db, Request{Query: `{ nearest(lat: 1, lon: 181) { distance } }`})
+	assert.NotEmpty(t, resp.Errors)
+}
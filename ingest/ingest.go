@@ -0,0 +1,83 @@
+// Package ingest feeds the storage with location updates consumed from message brokers and publishes its changes to them
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// retryDelay is how long a failed update waits before it's applied again
+const retryDelay = time.Second
+
+// ErrInvalidMessage sign what message is not JSON of a location update
+var ErrInvalidMessage = errors.New("Invalid location update message")
+
+// Message is a location update, the same JSON as location payloads of the API.
+// Timestamp in unix nanoseconds is when the location was taken, the time of consumption if not set.
+type Message struct {
+	DriverID   int               `json:"driver_id"`
+	Location   storage.Location  `json:"location"`
+	Timestamp  int64             `json:"timestamp"`
+	TTL        int64             `json:"ttl"`
+	Attributes map[string]string `json:"attributes"`
+	Status     storage.Status    `json:"status"`
+}
+
+// Driver converts message to storage driver
+func (m *Message) Driver() *storage.Driver {
+	d := &storage.Driver{
+		ID:           m.DriverID,
+		LastLocation: m.Location,
+		Timestamp:    m.Timestamp,
+		Attributes:   m.Attributes,
+		Status:       m.Status,
+	}
+	if m.TTL > 0 {
+		d.Expiration = time.Now().Add(time.Duration(m.TTL) * time.Second).UnixNano()
+	}
+	return d
+}
+
+// decode parses JSON of the message
+func decode(data []byte) (*Message, error) {
+	var m Message
+	if err := json.Unmarshal(data, &m); err != nil || m.DriverID <= 0 {
+		return nil, ErrInvalidMessage
+	}
+	return &m, nil
+}
+
+// update sets the driver of the message, failures of the storage are retried after delay.
+// It returns false if ctx is done before the update is applied or rejected.
+func update(ctx context.Context, db storage.Storage, m *Message, delay time.Duration, logger *zap.Logger) bool {
+	for {
+		err := db.Set(m.Driver())
+		if err == nil {
+			return true
+		}
+		if rejected(err) {
+			logger.Debug("update rejected", zap.Error(err))
+			return true
+		}
+		logger.Warn("could not apply update, retrying", zap.Error(err))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// rejected returns true if the update can never be applied, so it's skipped instead of retried
+func rejected(err error) bool {
+	switch errors.Cause(err) {
+	case ErrInvalidMessage, storage.ErrInvalidLocation, storage.ErrInvalidStatus, storage.ErrStaleLocation, storage.ErrThrottled, storage.ErrImpossibleSpeed:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package ingest
+
+import (
+	"context"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+type (
+	// KafkaConfig selects the topic and the consumer group, partitions of the topic are balanced between
+	// members of the group
+	KafkaConfig struct {
+		Brokers []string
+		Topic   string
+		Group   string
+	}
+
+	// Kafka consumes location updates of a topic. An offset is committed after its update is applied,
+	// so updates not applied before a crash are consumed again.
+	Kafka struct {
+		reader kafkaReader
+		db     storage.Storage
+		retry  time.Duration
+	}
+
+	kafkaReader interface {
+		FetchMessage(ctx context.Context) (kafka.Message, error)
+		CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+		Close() error
+	}
+)
+
+// NewKafka creates consumer of the topic writing updates to the storage
+func NewKafka(config KafkaConfig, db storage.Storage) *Kafka {
+	return &Kafka{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: config.Brokers,
+			Topic:   config.Topic,
+			GroupID: config.Group,
+		}),
+		db:    db,
+		retry: retryDelay,
+	}
+}
+
+// Run consumes updates until ctx is done. Invalid, stale and throttled updates are skipped,
+// updates failed by the storage are retried, so partitions don't move past them.
+func (k *Kafka) Run(ctx context.Context) error {
+	for {
+		m, err := k.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		logger := zap.L().With(zap.String("consumer", "kafka"), zap.Int("partition", m.Partition), zap.Int64("offset", m.Offset))
+		if u, err := decode(m.Value); err != nil {
+			logger.Debug("update rejected", zap.Error(err))
+		} else if !update(ctx, k.db, u, k.retry, logger) {
+			return nil
+		}
+		if err := k.reader.CommitMessages(ctx, m); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Close leaves the consumer group
+func (k *Kafka) Close() error {
+	return k.reader.Close()
+}
@@ -0,0 +1,78 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeReader struct {
+	messages  chan kafka.Message
+	committed []int64
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case m := <-r.messages:
+		return m, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	for _, m := range msgs {
+		r.committed = append(r.committed, m.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	return nil
+}
+
+// flakyStorage fails the first failures sets
+type flakyStorage struct {
+	storage.Storage
+	failures int
+}
+
+func (s *flakyStorage) Set(d *storage.Driver) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("disk is full")
+	}
+	return s.Storage.Set(d)
+}
+
+func TestKafka(t *testing.T) {
+	db := &flakyStorage{Storage: storage.New(10), failures: 2}
+	r := &fakeReader{messages: make(chan kafka.Message, 10)}
+	k := &Kafka{reader: r, db: db, retry: time.Millisecond}
+
+	r.messages <- kafka.Message{Offset: 1, Value: []byte(`{"driver_id": 1, "location": {"lat": 1, "lon": 2}, "timestamp": 5}`)}
+	r.messages <- kafka.Message{Offset: 2, Value: []byte(`not json`)}
+	r.messages <- kafka.Message{Offset: 3, Value: []byte(`{"driver_id": 1, "location": {"lat": 91, "lon": 2}}`)}
+	r.messages <- kafka.Message{Offset: 4, Value: []byte(`{"driver_id": 1, "location": {"lat": 3, "lon": 2}, "timestamp": 4}`)}
+	r.messages <- kafka.Message{Offset: 5, Value: []byte(`{"driver_id": 2, "location": {"lat": 3, "lon": 2}, "status": "busy"}`)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- k.Run(ctx) }()
+	assert.Eventually(t, func() bool { return db.Len() == 2 }, time.Second, time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+
+	assert.Equal(t, []int64{1, 2, 3, 4, 5}, r.committed)
+	d, err := db.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Location{Lat: 1, Lon: 2}, d.LastLocation)
+	d, err = db.Get(2)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusBusy, d.Status)
+}
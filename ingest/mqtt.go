@@ -0,0 +1,148 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/kdrake/nearestdots/storage"
+	"go.uber.org/zap"
+)
+
+// disconnectQuiesce is how long in-flight work of the MQTT client is waited on disconnect, in milliseconds
+const disconnectQuiesce = 250
+
+type (
+	// MQTTConfig selects the broker and the topic filter. A single-level wildcard of the filter,
+	// e.g. drivers/+/location, matches the driver id, otherwise devices send driver_id in payloads.
+	// The session is kept by the broker between restarts, so QoS 1 updates sent meanwhile are not lost.
+	MQTTConfig struct {
+		Broker   string
+		Topic    string
+		ClientID string
+		Username string
+		Password string
+		QoS      byte
+	}
+
+	// MQTT subscribes to location updates of devices. A message is acknowledged after its update is applied.
+	MQTT struct {
+		client mqtt.Client
+		topic  string
+		qos    byte
+		db     storage.Storage
+		retry  time.Duration
+		ctx    context.Context
+	}
+
+	// devicePayload is a Message or a flat location, trackers often can't nest JSON objects
+	devicePayload struct {
+		Message
+		Lat *float64 `json:"lat"`
+		Lon *float64 `json:"lon"`
+	}
+)
+
+// NewMQTT creates subscriber of the topic writing updates to the storage
+func NewMQTT(config MQTTConfig, db storage.Storage) *MQTT {
+	m := &MQTT{
+		topic: config.Topic,
+		qos:   config.QoS,
+		db:    db,
+		retry: retryDelay,
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetCleanSession(false).
+		SetAutoAckDisabled(true).
+		SetOnConnectHandler(m.subscribe).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			zap.L().Warn("lost MQTT connection", zap.Error(err))
+		})
+	m.client = mqtt.NewClient(opts)
+	return m
+}
+
+// Run connects to the broker and applies updates until ctx is done, the client reconnects when
+// the connection is lost. Invalid, stale and throttled updates are skipped, updates failed by the storage
+// are retried, so later updates wait for them.
+func (m *MQTT) Run(ctx context.Context) error {
+	m.ctx = ctx
+	token := m.client.Connect()
+	select {
+	case <-token.Done():
+		if err := token.Error(); err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return nil
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// Close disconnects from the broker
+func (m *MQTT) Close() error {
+	m.client.Disconnect(disconnectQuiesce)
+	return nil
+}
+
+// subscribe is called on every connection, the broker may have lost the subscription of the session
+func (m *MQTT) subscribe(c mqtt.Client) {
+	token := c.Subscribe(m.topic, m.qos, m.handle)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		zap.L().Error("could not subscribe to MQTT topic", zap.String("topic", m.topic), zap.Error(err))
+		return
+	}
+	zap.L().Info("subscribed to MQTT topic", zap.String("topic", m.topic))
+}
+
+// handle applies the update of the message, the message is redelivered if it's not acknowledged
+func (m *MQTT) handle(_ mqtt.Client, msg mqtt.Message) {
+	logger := zap.L().With(zap.String("consumer", "mqtt"), zap.String("topic", msg.Topic()))
+	u, err := m.decode(msg)
+	if err != nil {
+		logger.Debug("update rejected", zap.Error(err))
+	} else if !update(m.ctx, m.db, u, m.retry, logger) {
+		return
+	}
+	msg.Ack()
+}
+
+// decode parses the payload, the driver id of the topic overrides the one of the payload,
+// since brokers authorize devices by topics
+func (m *MQTT) decode(msg mqtt.Message) (*Message, error) {
+	var p devicePayload
+	if err := json.Unmarshal(msg.Payload(), &p); err != nil {
+		return nil, ErrInvalidMessage
+	}
+	if p.Lat != nil && p.Lon != nil {
+		p.Location = storage.Location{Lat: *p.Lat, Lon: *p.Lon}
+	}
+	if id, ok := topicDriverID(m.topic, msg.Topic()); ok {
+		p.DriverID = id
+	}
+	if p.DriverID <= 0 {
+		return nil, ErrInvalidMessage
+	}
+	return &p.Message, nil
+}
+
+// topicDriverID returns the driver id matched by the single-level wildcard of the filter
+func topicDriverID(filter, topic string) (int, bool) {
+	levels := strings.Split(topic, "/")
+	for i, level := range strings.Split(filter, "/") {
+		if level == "+" && i < len(levels) {
+			id, err := strconv.Atoi(levels[i])
+			return id, err == nil
+		}
+	}
+	return 0, false
+}
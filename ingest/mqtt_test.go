@@ -0,0 +1,65 @@
+package ingest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeMessage struct {
+	topic   string
+	payload string
+	acked   bool
+}
+
+func (m *fakeMessage) Duplicate() bool   { return false }
+func (m *fakeMessage) Qos() byte         { return 1 }
+func (m *fakeMessage) Retained() bool    { return false }
+func (m *fakeMessage) Topic() string     { return m.topic }
+func (m *fakeMessage) MessageID() uint16 { return 1 }
+func (m *fakeMessage) Payload() []byte   { return []byte(m.payload) }
+func (m *fakeMessage) Ack()              { m.acked = true }
+
+func TestMQTT(t *testing.T) {
+	db := &flakyStorage{Storage: storage.New(10), failures: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &MQTT{topic: "drivers/+/location", db: db, retry: time.Millisecond, ctx: ctx}
+
+	messages := []*fakeMessage{
+		{topic: "drivers/1/location", payload: `{"lat": 1, "lon": 2, "timestamp": 5}`},
+		{topic: "drivers/2/location", payload: `{"driver_id": 3, "location": {"lat": 3, "lon": 4}, "status": "busy"}`},
+		{topic: "drivers/x/location", payload: `{"lat": 1, "lon": 2}`},
+		{topic: "drivers/1/location", payload: `{"lat": 91, "lon": 2}`},
+		{topic: "drivers/1/location", payload: `not json`},
+	}
+	for _, msg := range messages {
+		m.handle(nil, msg)
+		assert.True(t, msg.acked, msg.payload)
+	}
+	assert.Equal(t, 2, db.Len())
+	d, err := db.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Location{Lat: 1, Lon: 2}, d.LastLocation)
+	d, err = db.Get(2)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Location{Lat: 3, Lon: 4}, d.LastLocation)
+	assert.Equal(t, storage.StatusBusy, d.Status)
+
+	// updates not applied before shutdown are redelivered
+	db.failures = 1
+	cancel()
+	msg := &fakeMessage{topic: "drivers/4/location", payload: `{"lat": 1, "lon": 2}`}
+	m.handle(nil, msg)
+	assert.False(t, msg.acked)
+}
+
+func TestTopicDriverID(t *testing.T) {
+	id, ok := topicDriverID("fleet/+/gps", "fleet/42/gps")
+	assert.True(t, ok)
+	assert.Equal(t, 42, id)
+	_, ok = topicDriverID("fleet/locations", "fleet/locations")
+	assert.False(t, ok)
+}
@@ -0,0 +1,144 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+)
+
+// Event types published to NATS, the subject of an event is the prefix and its type, e.g. drivers.moved
+const (
+	EventMoved    = "moved"
+	EventRemoved  = "removed"
+	EventAppeared = "appeared"
+	EventExpired  = "expired"
+	EventStatus   = "status"
+)
+
+type (
+	// NATS consumes location updates of a subject. Members of the queue group share updates,
+	// every replica gets all of them if the group is empty. Core NATS doesn't redeliver,
+	// so updates sent while the service is down are lost.
+	NATS struct {
+		conn    *nats.Conn
+		subject string
+		queue   string
+		sub     *nats.Subscription
+		db      storage.Storage
+		retry   time.Duration
+		ctx     context.Context
+	}
+
+	// NATSEvent is a driver change published to NATS, Timestamp is unix nanoseconds
+	// of the location or of the change if the driver has no new location
+	NATSEvent struct {
+		Type      string            `json:"type"`
+		DriverID  int               `json:"driver_id"`
+		Location  *storage.Location `json:"location,omitempty"`
+		Status    storage.Status    `json:"status,omitempty"`
+		Timestamp int64             `json:"timestamp"`
+	}
+
+	// NATSPublisher observes the storage and publishes its changes under the subject prefix
+	NATSPublisher struct {
+		conn   publisher
+		prefix string
+	}
+
+	publisher interface {
+		Publish(subject string, data []byte) error
+	}
+)
+
+var _ storage.LifecycleObserver = (*NATSPublisher)(nil)
+
+// NewNATS creates consumer of the subject writing updates to the storage
+func NewNATS(conn *nats.Conn, subject, queue string, db storage.Storage) *NATS {
+	return &NATS{
+		conn:    conn,
+		subject: subject,
+		queue:   queue,
+		db:      db,
+		retry:   retryDelay,
+	}
+}
+
+// Run applies updates until ctx is done. Invalid, stale and throttled updates are skipped,
+// updates failed by the storage are retried, so later updates wait for them.
+func (n *NATS) Run(ctx context.Context) error {
+	n.ctx = ctx
+	var err error
+	if n.queue != "" {
+		n.sub, err = n.conn.QueueSubscribe(n.subject, n.queue, n.handle)
+	} else {
+		n.sub, err = n.conn.Subscribe(n.subject, n.handle)
+	}
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// Close unsubscribes, the connection is closed by its owner
+func (n *NATS) Close() error {
+	if n.sub == nil {
+		return nil
+	}
+	return n.sub.Unsubscribe()
+}
+
+func (n *NATS) handle(msg *nats.Msg) {
+	logger := zap.L().With(zap.String("consumer", "nats"), zap.String("subject", msg.Subject))
+	if u, err := decode(msg.Data); err != nil {
+		logger.Debug("update rejected", zap.Error(err))
+	} else {
+		update(n.ctx, n.db, u, n.retry, logger)
+	}
+}
+
+// NewNATSPublisher creates publisher of the storage changes, conn is usually *nats.Conn
+func NewNATSPublisher(conn publisher, prefix string) *NATSPublisher {
+	return &NATSPublisher{conn: conn, prefix: prefix}
+}
+
+// DriverMoved publishes moved events
+func (p *NATSPublisher) DriverMoved(id int, location storage.Location, ts int64) {
+	p.publish(NATSEvent{Type: EventMoved, DriverID: id, Location: &location, Timestamp: ts})
+}
+
+// DriverRemoved publishes removed events
+func (p *NATSPublisher) DriverRemoved(id int) {
+	p.publish(NATSEvent{Type: EventRemoved, DriverID: id, Timestamp: time.Now().UnixNano()})
+}
+
+// DriverAppeared publishes appeared events of new drivers
+func (p *NATSPublisher) DriverAppeared(id int, location storage.Location, ts int64) {
+	p.publish(NATSEvent{Type: EventAppeared, DriverID: id, Location: &location, Timestamp: ts})
+}
+
+// DriverExpired publishes expired events
+func (p *NATSPublisher) DriverExpired(id int) {
+	p.publish(NATSEvent{Type: EventExpired, DriverID: id, Timestamp: time.Now().UnixNano()})
+}
+
+// DriverStatusChanged publishes status events
+func (p *NATSPublisher) DriverStatusChanged(id int, status storage.Status) {
+	p.publish(NATSEvent{Type: EventStatus, DriverID: id, Status: status, Timestamp: time.Now().UnixNano()})
+}
+
+// publish is called under the storage lock, NATS buffers messages, so it doesn't wait for the server
+func (p *NATSPublisher) publish(e NATSEvent) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		zap.L().Error("could not encode NATS event", zap.Error(err))
+		return
+	}
+	if err := p.conn.Publish(p.prefix+"."+e.Type, data); err != nil {
+		zap.L().Warn("could not publish NATS event", zap.String("type", e.Type), zap.Int("driver", e.DriverID), zap.Error(err))
+	}
+}
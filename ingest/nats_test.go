@@ -0,0 +1,51 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakePublisher struct {
+	subjects []string
+	events   []NATSEvent
+}
+
+func (p *fakePublisher) Publish(subject string, data []byte) error {
+	var e NATSEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	p.subjects = append(p.subjects, subject)
+	p.events = append(p.events, e)
+	return nil
+}
+
+func TestNATS(t *testing.T) {
+	db := &flakyStorage{Storage: storage.New(10), failures: 1}
+	n := &NATS{db: db, retry: time.Millisecond, ctx: context.Background()}
+
+	n.handle(&nats.Msg{Subject: "drivers.locations", Data: []byte(`{"driver_id": 1, "location": {"lat": 1, "lon": 2}}`)})
+	n.handle(&nats.Msg{Subject: "drivers.locations", Data: []byte(`{"location": {"lat": 1, "lon": 2}}`)})
+	assert.Equal(t, 1, db.Len())
+	d, err := db.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Location{Lat: 1, Lon: 2}, d.LastLocation)
+}
+
+func TestNATSPublisher(t *testing.T) {
+	p := &fakePublisher{}
+	db := storage.New(10, storage.WithObserver(NewNATSPublisher(p, "drivers")))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 2}, Timestamp: 1}))
+	assert.NoError(t, db.SetStatus(1, storage.StatusBusy))
+	assert.NoError(t, db.Delete(1))
+
+	assert.Equal(t, []string{"drivers.appeared", "drivers.moved", "drivers.status", "drivers.removed"}, p.subjects)
+	assert.Equal(t, &storage.Location{Lat: 1, Lon: 2}, p.events[1].Location)
+	assert.Equal(t, storage.StatusBusy, p.events[2].Status)
+}
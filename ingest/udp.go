@@ -0,0 +1,191 @@
+package ingest
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+	"net"
+	"sync/atomic"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Layout of a fix packet, all fields are big-endian:
+//
+//	version    uint8, FixVersion
+//	driver id  uint32
+//	seq        uint16, increased by the device with every fix, wraps around
+//	lat, lon   int32, degrees multiplied by 1e7
+//	timestamp  int64, unix nanoseconds, the time of receipt if 0
+//	checksum   uint32, CRC-32 (IEEE) of the previous bytes
+//
+// A datagram carries one or more packets.
+const (
+	FixVersion = 1
+	FixSize    = 27
+)
+
+// coordinateScale is the fixed point scale of coordinates, about 1cm of latitude
+const coordinateScale = 1e7
+
+// maxDatagram is the largest UDP payload
+const maxDatagram = 65535
+
+// ErrInvalidFix sign what packet has wrong size, version or checksum
+var ErrInvalidFix = errors.New("Invalid fix packet")
+
+type (
+	// Fix is a location of a driver sent over UDP, Seq lets the receiver count lost packets
+	Fix struct {
+		DriverID  uint32
+		Seq       uint16
+		Location  storage.Location
+		Timestamp int64
+	}
+
+	// UDPStats counts packets since start. Lost is estimated from gaps of sequence numbers of drivers,
+	// late and duplicated packets are counted as Reordered and applied anyway,
+	// the storage rejects them if they are stale.
+	UDPStats struct {
+		Received  uint64 `json:"received"`
+		Invalid   uint64 `json:"invalid"`
+		Rejected  uint64 `json:"rejected"`
+		Lost      uint64 `json:"lost"`
+		Reordered uint64 `json:"reordered"`
+	}
+
+	// UDP receives fixes without acknowledgements, so devices never wait on lost or delayed packets
+	UDP struct {
+		conn net.PacketConn
+		db   storage.Storage
+		// seqs are last sequence numbers of drivers, they are used by the reading goroutine only
+		seqs  map[uint32]uint16
+		stats UDPStats
+	}
+)
+
+// EncodeFix returns the packet of the fix
+func EncodeFix(f Fix) []byte {
+	b := make([]byte, FixSize)
+	b[0] = FixVersion
+	binary.BigEndian.PutUint32(b[1:], f.DriverID)
+	binary.BigEndian.PutUint16(b[5:], f.Seq)
+	binary.BigEndian.PutUint32(b[7:], uint32(int32(math.Round(f.Location.Lat*coordinateScale))))
+	binary.BigEndian.PutUint32(b[11:], uint32(int32(math.Round(f.Location.Lon*coordinateScale))))
+	binary.BigEndian.PutUint64(b[15:], uint64(f.Timestamp))
+	binary.BigEndian.PutUint32(b[23:], crc32.ChecksumIEEE(b[:23]))
+	return b
+}
+
+// DecodeFix parses the packet
+func DecodeFix(b []byte) (Fix, error) {
+	if len(b) != FixSize || b[0] != FixVersion || binary.BigEndian.Uint32(b[23:]) != crc32.ChecksumIEEE(b[:23]) {
+		return Fix{}, ErrInvalidFix
+	}
+	return Fix{
+		DriverID: binary.BigEndian.Uint32(b[1:]),
+		Seq:      binary.BigEndian.Uint16(b[5:]),
+		Location: storage.Location{
+			Lat: float64(int32(binary.BigEndian.Uint32(b[7:]))) / coordinateScale,
+			Lon: float64(int32(binary.BigEndian.Uint32(b[11:]))) / coordinateScale,
+		},
+		Timestamp: int64(binary.BigEndian.Uint64(b[15:])),
+	}, nil
+}
+
+// ListenUDP creates receiver of fixes sent to the address writing them to the storage
+func ListenUDP(addr string, db storage.Storage) (*UDP, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &UDP{conn: conn, db: db, seqs: make(map[uint32]uint16)}, nil
+}
+
+// Addr returns the address the receiver listens on
+func (u *UDP) Addr() net.Addr {
+	return u.conn.LocalAddr()
+}
+
+// Run applies received fixes until ctx is done
+func (u *UDP) Run(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		u.conn.Close()
+	})
+	defer stop()
+
+	buf := make([]byte, maxDatagram)
+	for {
+		n, _, err := u.conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		u.receive(buf[:n])
+	}
+}
+
+// Close stops listening
+func (u *UDP) Close() error {
+	err := u.conn.Close()
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Stats returns counters of packets
+func (u *UDP) Stats() UDPStats {
+	return UDPStats{
+		Received:  atomic.LoadUint64(&u.stats.Received),
+		Invalid:   atomic.LoadUint64(&u.stats.Invalid),
+		Rejected:  atomic.LoadUint64(&u.stats.Rejected),
+		Lost:      atomic.LoadUint64(&u.stats.Lost),
+		Reordered: atomic.LoadUint64(&u.stats.Reordered),
+	}
+}
+
+// receive applies packets of the datagram, a datagram of a wrong size is invalid as a whole
+func (u *UDP) receive(datagram []byte) {
+	if len(datagram) == 0 || len(datagram)%FixSize != 0 {
+		atomic.AddUint64(&u.stats.Received, 1)
+		atomic.AddUint64(&u.stats.Invalid, 1)
+		return
+	}
+	for i := 0; i < len(datagram); i += FixSize {
+		atomic.AddUint64(&u.stats.Received, 1)
+		f, err := DecodeFix(datagram[i : i+FixSize])
+		if err != nil || f.DriverID == 0 || f.DriverID > math.MaxInt32 {
+			atomic.AddUint64(&u.stats.Invalid, 1)
+			continue
+		}
+		u.sequence(f)
+		err = u.db.Set(&storage.Driver{ID: int(f.DriverID), LastLocation: f.Location, Timestamp: f.Timestamp})
+		if err != nil {
+			atomic.AddUint64(&u.stats.Rejected, 1)
+			zap.L().Debug("UDP fix rejected", zap.Uint32("driver", f.DriverID), zap.Error(err))
+		}
+	}
+}
+
+// sequence counts packets lost or reordered before the fix, a gap of more than half
+// of the sequence space means the fix is late
+func (u *UDP) sequence(f Fix) {
+	last, ok := u.seqs[f.DriverID]
+	if !ok {
+		u.seqs[f.DriverID] = f.Seq
+		return
+	}
+	gap := f.Seq - last
+	if gap == 0 || gap > math.MaxUint16/2 {
+		atomic.AddUint64(&u.stats.Reordered, 1)
+		return
+	}
+	atomic.AddUint64(&u.stats.Lost, uint64(gap-1))
+	u.seqs[f.DriverID] = f.Seq
+}
@@ -0,0 +1,62 @@
+package ingest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFix(t *testing.T) {
+	f := Fix{DriverID: 7, Seq: 65535, Location: storage.Location{Lat: -33.8688197, Lon: 151.2092955}, Timestamp: 1e18}
+	b := EncodeFix(f)
+	assert.Len(t, b, FixSize)
+	decoded, err := DecodeFix(b)
+	assert.NoError(t, err)
+	assert.Equal(t, f, decoded)
+
+	b[10] ^= 1
+	_, err = DecodeFix(b)
+	assert.Equal(t, ErrInvalidFix, err)
+}
+
+func TestUDP(t *testing.T) {
+	db := storage.New(10)
+	u, err := ListenUDP("127.0.0.1:0", db)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- u.Run(ctx) }()
+
+	conn, err := net.Dial("udp", u.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+	send := func(b []byte) {
+		_, err := conn.Write(b)
+		assert.NoError(t, err)
+	}
+	fix := func(id uint32, seq uint16, lat float64, ts int64) []byte {
+		return EncodeFix(Fix{DriverID: id, Seq: seq, Location: storage.Location{Lat: lat, Lon: 1}, Timestamp: ts})
+	}
+	send(fix(1, 65534, 1, 1))
+	// two packets of a datagram, seq wraps around and 2 packets are lost
+	send(append(fix(1, 1, 2, 2), fix(2, 5, 1, 1)...))
+	// late packet
+	send(fix(1, 0, 3, 1))
+	send(fix(1, 2, 91, 3))
+	send([]byte("garbage"))
+
+	assert.Eventually(t, func() bool { return u.Stats().Received == 6 }, time.Second, time.Millisecond)
+	assert.Equal(t, UDPStats{Received: 6, Invalid: 1, Rejected: 2, Lost: 2, Reordered: 1}, u.Stats())
+	d, err := db.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Location{Lat: 2, Lon: 1}, d.LastLocation)
+	assert.Equal(t, 2, db.Len())
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.NoError(t, u.Close())
+}
@@ -2,16 +2,81 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
+	"strings"
 
-	"github.com/kdrake/nearestdots/api"
+	"github.com/kdrake/nearestdots/client"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
 )
 
+// command runs with arguments after its name and returns the exit code
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands are listed by usage in this order
+var commands = []command{
+	{"serve", "Serve the API, it's the default command", serveCommand},
+	{"snapshot", "Download snapshot of drivers of a running instance", snapshotCommand},
+	{"restore", "Replace drivers of a running instance by a snapshot", restoreCommand},
+	{"bench", "Measure throughput and latency of an instance", benchCommand},
+	{"simulate", "Send locations of synthetic drivers to an instance", simulateCommand},
+	{"replay", "Re-send recorded location updates to an instance", replayCommand},
+}
+
 func main() {
-	bindAddr := flag.String("bind_addr", ":8080", "Set bind address")
-	size := flag.Int("lru_size", 20, "Set lru size per driver")
-	flag.Parse()
+	args := os.Args[1:]
+	// flags without a command serve the API as before commands were added
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		os.Exit(serveCommand(args))
+	}
+	for _, c := range commands {
+		if c.name == args[0] {
+			os.Exit(c.run(args[1:]))
+		}
+	}
+	usage()
+	if args[0] == "help" {
+		os.Exit(0)
+	}
+	os.Exit(2)
+}
+
+// usage prints commands
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: nearestdots <command> [flags]\n\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun nearestdots <command> -h for flags of the command.")
+}
+
+// clientFlags defines flags of the instance a command calls, the returned func creates its client after parsing
+func clientFlags(fs *flag.FlagSet) func() *client.Client {
+	target := fs.String("target", "http://localhost:8080", "Set URL of the instance")
+	apiKey := fs.String("api_key", os.Getenv("NEARESTDOTS_API_KEY"), "Set API key of the instance")
+	namespace := fs.String("namespace", "", "Set namespace of the drivers, the default one if empty")
+	return func() *client.Client {
+		opts := []client.Option{client.WithNamespace(*namespace)}
+		if *apiKey != "" {
+			opts = append(opts, client.WithAPIKey(*apiKey))
+		}
+		return client.New(*target, opts...)
+	}
+}
 
-	a := api.New(*bindAddr, *size)
-	a.Start()
-	a.WaitStop()
+// parseLocation parses lat,lon
+func parseLocation(s string) (storage.Location, error) {
+	var l storage.Location
+	if _, err := fmt.Sscanf(s, "%f,%f", &l.Lat, &l.Lon); err != nil {
+		return l, errors.Wrapf(err, "%q is not lat,lon", s)
+	}
+	if !l.Valid() {
+		return l, storage.ErrInvalidLocation
+	}
+	return l, nil
 }
@@ -0,0 +1,164 @@
+package orders
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+)
+
+// Order statuses
+const (
+	StatusUnassigned = "unassigned"
+	StatusAssigned   = "assigned"
+	StatusCompleted  = "completed"
+	StatusCancelled  = "cancelled"
+)
+
+// reserveTTL is how long the driver is reserved while being assigned
+const reserveTTL = 10 * time.Second
+
+var (
+	// ErrOrderDoesNotExist sign what order does not exist
+	ErrOrderDoesNotExist = errors.New("Order does not exist")
+	// ErrNoDriverAvailable sign what there is no available driver for the order
+	ErrNoDriverAvailable = errors.New("No driver available")
+	// ErrInvalidTransition sign what order can't change its status this way
+	ErrInvalidTransition = errors.New("Invalid order status transition")
+)
+
+type (
+	// Order is a ride from the pickup location, Attributes are required from the driver.
+	// Times are unix nanoseconds.
+	Order struct {
+		ID         int64             `json:"id"`
+		Pickup     storage.Location  `json:"pickup"`
+		Attributes map[string]string `json:"attributes,omitempty"`
+		Status     string            `json:"status"`
+		DriverID   *int              `json:"driver_id,omitempty"`
+		Distance   float64           `json:"distance,omitempty"`
+		CreatedAt  int64             `json:"created_at"`
+		AssignedAt int64             `json:"assigned_at,omitempty"`
+		// storage of drivers the order is matched against
+		db storage.Storage
+	}
+
+	// Service matches orders with nearest available drivers and marks them busy.
+	// Orders are kept in memory only.
+	Service struct {
+		mu     sync.Mutex
+		orders map[int64]*Order
+		seq    int64
+	}
+)
+
+// New creates new orders Service
+func New() *Service {
+	return &Service{orders: make(map[int64]*Order)}
+}
+
+// Create creates order and assigns the nearest available driver of db to it.
+// The order is kept unassigned with ErrNoDriverAvailable if there is no driver.
+func (s *Service) Create(db storage.Storage, pickup storage.Location, attributes map[string]string) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	o := &Order{
+		ID:         s.seq,
+		Pickup:     pickup,
+		Attributes: attributes,
+		Status:     StatusUnassigned,
+		CreatedAt:  time.Now().UnixNano(),
+		db:         db,
+	}
+	s.orders[o.ID] = o
+	err := s.assign(o)
+	return *o, err
+}
+
+// Assign retries assignment of the unassigned order
+func (s *Service) Assign(id int64) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[id]
+	if !ok {
+		return Order{}, ErrOrderDoesNotExist
+	}
+	if o.Status != StatusUnassigned {
+		return *o, ErrInvalidTransition
+	}
+	err := s.assign(o)
+	return *o, err
+}
+
+// Get returns the order
+func (s *Service) Get(id int64) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[id]
+	if !ok {
+		return Order{}, ErrOrderDoesNotExist
+	}
+	return *o, nil
+}
+
+// Complete completes the assigned order and makes its driver available
+func (s *Service) Complete(id int64) (Order, error) {
+	return s.finish(id, StatusCompleted)
+}
+
+// Cancel cancels the order and makes its driver available if it's assigned
+func (s *Service) Cancel(id int64) (Order, error) {
+	return s.finish(id, StatusCancelled)
+}
+
+func (s *Service) finish(id int64, status string) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o, ok := s.orders[id]
+	if !ok {
+		return Order{}, ErrOrderDoesNotExist
+	}
+	switch {
+	case o.Status == StatusAssigned:
+		err := o.db.SetStatus(*o.DriverID, storage.StatusAvailable)
+		if err != nil && err != storage.ErrDriverDoesNotExist {
+			return *o, err
+		}
+	case o.Status == StatusUnassigned && status == StatusCancelled:
+	default:
+		return *o, ErrInvalidTransition
+	}
+	o.Status = status
+	return *o, nil
+}
+
+// assign reserves the nearest driver and marks it busy, it's called under the lock
+func (s *Service) assign(o *Order) error {
+	var filters []storage.Filter
+	if len(o.Attributes) > 0 {
+		filters = append(filters, storage.AttributesFilter(o.Attributes))
+	}
+	point := rtreego.Point{o.Pickup.Lat, o.Pickup.Lon}
+	drivers := o.db.NearestAndLock(point, 1, reserveTTL, filters...)
+	if len(drivers) == 0 {
+		return ErrNoDriverAvailable
+	}
+
+	d := drivers[0]
+	if err := o.db.SetStatus(d.ID, storage.StatusBusy); err != nil {
+		return errors.Wrap(err, "could not mark driver busy")
+	}
+	id := d.ID
+	o.DriverID = &id
+	o.Distance = storage.Distance(o.Pickup, d.LastLocation)
+	o.Status = StatusAssigned
+	o.AssignedAt = time.Now().UnixNano()
+	return nil
+}
@@ -0,0 +1,56 @@
+package orders
+
+import (
+	"testing"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	assert.NoError(t, db.Set(&storage.Driver{
+		ID:           2,
+		LastLocation: storage.Location{Lat: 1.1, Lon: 1},
+		Attributes:   map[string]string{"vehicle_class": "minivan"},
+	}))
+	s := New()
+
+	o, err := s.Create(db, storage.Location{Lat: 1, Lon: 1}, map[string]string{"vehicle_class": "minivan"})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusAssigned, o.Status)
+	assert.Equal(t, 2, *o.DriverID)
+	d, err := db.Get(2)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusBusy, d.Status)
+
+	o, err = s.Create(db, storage.Location{Lat: 1, Lon: 1}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, *o.DriverID)
+
+	// all drivers are busy
+	pending, err := s.Create(db, storage.Location{Lat: 1, Lon: 1}, nil)
+	assert.Equal(t, ErrNoDriverAvailable, err)
+	assert.Equal(t, StatusUnassigned, pending.Status)
+
+	o, err = s.Complete(o.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCompleted, o.Status)
+	_, err = s.Complete(o.ID)
+	assert.Equal(t, ErrInvalidTransition, err)
+
+	pending, err = s.Assign(pending.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, *pending.DriverID)
+
+	pending, err = s.Cancel(pending.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusCancelled, pending.Status)
+	d, err = db.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.StatusAvailable, d.Status)
+
+	_, err = s.Get(42)
+	assert.Equal(t, ErrOrderDoesNotExist, err)
+}
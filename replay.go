@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kdrake/nearestdots/replay"
+)
+
+// replayCommand re-sends recorded updates of the file to an instance, it returns the exit code
+func replayCommand(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: nearestdots replay [flags] file.ndjson\n\nRe-sends recorded location updates, - reads them from stdin.")
+		fs.PrintDefaults()
+	}
+	instance := clientFlags(fs)
+	speed := fs.Float64("speed", 1, "Set pace relative to the recording, e.g. 10 is ten times faster, 0 sends as fast as possible")
+	keepTimestamps := fs.Bool("keep_timestamps", false, "Set to send recorded timestamps instead of shifting them to the time of the replay")
+	batch := fs.Int("batch", 100, "Set maximal number of updates due at the same time sent in one request")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	in := os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	stats, err := replay.Run(ctx, in, instance(), replay.Options{
+		Speed:          *speed,
+		KeepTimestamps: *keepTimestamps,
+		BatchSize:      *batch,
+	})
+	fmt.Printf("sent %d, failed %d, skipped %d in %s, max lag %s\n", stats.Sent, stats.Failed, stats.Skipped, stats.Duration, stats.Lag)
+	if stats.LastError != nil {
+		fmt.Fprintln(os.Stderr, "last failure:", stats.LastError)
+	}
+	if err != nil && err != context.Canceled {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
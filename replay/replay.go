@@ -0,0 +1,131 @@
+// Package replay re-sends recorded location updates to an instance keeping their original pace
+package replay
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/kdrake/nearestdots/client"
+	"github.com/pkg/errors"
+)
+
+// maxLine limits length of a recorded update
+const maxLine = 1 << 20
+
+type (
+	// Options of a replay. Speed multiplies the original pace, 0 sends updates as fast as possible.
+	// Timestamps are shifted, so the first update is sent with the current time, unless KeepTimestamps is set.
+	// Updates due at the same time are sent in batches of up to BatchSize.
+	Options struct {
+		Speed          float64
+		KeepTimestamps bool
+		BatchSize      int
+	}
+
+	// Stats of a replay, Lag is the largest delay of a batch after its due time, it's not measured at Speed 0.
+	// LastError is why the last failed batch failed.
+	Stats struct {
+		Sent      int
+		Failed    int
+		Skipped   int
+		Duration  time.Duration
+		Lag       time.Duration
+		LastError error
+	}
+
+	// sender sends a batch of updates
+	sender interface {
+		UpdateLocations(ctx context.Context, updates []client.LocationUpdate) error
+	}
+)
+
+// Run reads NDJSON of recorded updates ordered by timestamp and sends them to the client until
+// the input ends or ctx is done. Lines without a timestamp are sent right after the previous one,
+// invalid lines are skipped. Failed batches are counted and not retried.
+func Run(ctx context.Context, r io.Reader, c sender, opts Options) (Stats, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	var stats Stats
+	start := time.Now()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxLine)
+
+	var (
+		first int64
+		batch []client.LocationUpdate
+		due   = start
+	)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if lag := time.Since(due); opts.Speed > 0 && lag > stats.Lag {
+			stats.Lag = lag
+		}
+		if err := c.UpdateLocations(ctx, batch); err != nil {
+			stats.Failed += len(batch)
+			stats.LastError = err
+		} else {
+			stats.Sent += len(batch)
+		}
+		batch = batch[:0]
+	}
+
+	for scanner.Scan() {
+		var u client.LocationUpdate
+		if err := json.Unmarshal(scanner.Bytes(), &u); err != nil || u.DriverID <= 0 {
+			stats.Skipped++
+			continue
+		}
+		at := due
+		if u.Timestamp != nil {
+			ts := *u.Timestamp
+			if first == 0 {
+				first = ts
+			}
+			at = start
+			if opts.Speed > 0 {
+				at = start.Add(time.Duration(float64(ts-first) / opts.Speed))
+			}
+			if !opts.KeepTimestamps {
+				shifted := start.UnixNano() + ts - first
+				u.Timestamp = &shifted
+			}
+		}
+		if at.After(due) || len(batch) >= opts.BatchSize {
+			flush()
+			due = at
+			if err := wait(ctx, due); err != nil {
+				stats.Duration = time.Since(start)
+				return stats, err
+			}
+		}
+		batch = append(batch, u)
+	}
+	flush()
+	stats.Duration = time.Since(start)
+	if err := scanner.Err(); err != nil {
+		return stats, errors.Wrap(err, "could not read recorded updates")
+	}
+	return stats, ctx.Err()
+}
+
+// wait sleeps until t or until ctx is done
+func wait(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
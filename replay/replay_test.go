@@ -0,0 +1,69 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/client"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSender struct {
+	batches [][]client.LocationUpdate
+	sent    []time.Time
+	fail    bool
+}
+
+func (s *fakeSender) UpdateLocations(_ context.Context, updates []client.LocationUpdate) error {
+	s.batches = append(s.batches, append([]client.LocationUpdate(nil), updates...))
+	s.sent = append(s.sent, time.Now())
+	if s.fail {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+const recorded = `{"driver_id": 1, "location": {"lat": 1, "lon": 1}, "timestamp": 1000000000}
+{"driver_id": 2, "location": {"lat": 2, "lon": 2}, "timestamp": 1000000000}
+not json
+{"driver_id": 3, "location": {"lat": 3, "lon": 3}}
+{"driver_id": 1, "location": {"lat": 1, "lon": 2}, "timestamp": 1100000000}
+`
+
+func TestRun(t *testing.T) {
+	s := &fakeSender{}
+	start := time.Now()
+	stats, err := Run(context.Background(), strings.NewReader(recorded), s, Options{Speed: 2, BatchSize: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, stats.Sent)
+	assert.Equal(t, 1, stats.Skipped)
+
+	assert.Len(t, s.batches, 2)
+	assert.Len(t, s.batches[0], 3)
+	assert.Equal(t, 3, s.batches[0][2].DriverID)
+	// 100ms of the recording at double speed
+	assert.True(t, s.sent[1].Sub(start) >= 50*time.Millisecond)
+	// timestamps are shifted to the time of the replay
+	assert.Equal(t, int64(100*time.Millisecond), *s.batches[1][0].Timestamp-*s.batches[0][0].Timestamp)
+	assert.True(t, *s.batches[0][0].Timestamp >= start.UnixNano())
+}
+
+func TestRunBatchSize(t *testing.T) {
+	s := &fakeSender{fail: true}
+	stats, err := Run(context.Background(), strings.NewReader(recorded), s, Options{KeepTimestamps: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, stats.Failed)
+	assert.EqualError(t, stats.LastError, "unavailable")
+	assert.Len(t, s.batches, 4)
+	assert.Equal(t, int64(1000000000), *s.batches[0][0].Timestamp)
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := Run(ctx, strings.NewReader(recorded), &fakeSender{}, Options{Speed: 1})
+	assert.Equal(t, context.Canceled, err)
+}
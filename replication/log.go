@@ -0,0 +1,154 @@
+// Package replication copies drivers of the default namespace of a primary to read-only replicas.
+// The primary keeps a log of recent changes, replicas of package replica load its snapshot and follow the log.
+package replication
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+)
+
+// HeartbeatInterval is how often an empty batch is sent to replicas of an idle primary
+const HeartbeatInterval = 5 * time.Second
+
+// Operations of changes
+const (
+	Set    = "set"
+	Delete = "delete"
+	Status = "status"
+)
+
+var (
+	// ErrPositionLost sign what the log doesn't have changes after the position, the replica must load a snapshot
+	ErrPositionLost = errors.New("Replication position is lost")
+	// ErrReadOnly sign what the storage is a replica, drivers are changed on the primary
+	ErrReadOnly = errors.New("Replica is read-only")
+	// ErrNotSynced sign what the replica hasn't loaded a snapshot of the primary or lost it
+	ErrNotSynced = errors.New("Replica is not synced with primary")
+)
+
+type (
+	// Change is a change of a driver numbered by Seq in the epoch of the log,
+	// a set change has the whole driver except its speed and heading, replicas compute them
+	Change struct {
+		Seq        uint64
+		Op         string
+		ID         int
+		Location   storage.Location
+		Attributes map[string]string
+		Status     storage.Status
+		Timestamp  int64
+		Expiration int64
+	}
+
+	// Batch is sent to replicas, it's empty if there were no changes during HeartbeatInterval
+	Batch struct {
+		Changes []Change
+	}
+
+	// Log observes the storage and keeps its latest changes for replicas. A new epoch starts
+	// when the primary restarts, replicas of the previous one load a snapshot then.
+	Log struct {
+		mu       sync.Mutex
+		epoch    int64
+		size     int
+		next     uint64
+		changes  []Change
+		appended chan struct{}
+	}
+)
+
+var (
+	_ storage.StateObserver     = (*Log)(nil)
+	_ storage.LifecycleObserver = (*Log)(nil)
+)
+
+// NewLog creates Log keeping at least size latest changes
+func NewLog(size int) *Log {
+	if size < 1 {
+		size = 1
+	}
+	return &Log{
+		epoch:    time.Now().UnixNano(),
+		size:     size,
+		next:     1,
+		appended: make(chan struct{}),
+	}
+}
+
+// DriverChanged appends set changes
+func (l *Log) DriverChanged(d *storage.Driver) {
+	l.append(Change{
+		Op:         Set,
+		ID:         d.ID,
+		Location:   d.LastLocation,
+		Attributes: d.Attributes,
+		Status:     d.Status,
+		Timestamp:  d.Timestamp,
+		Expiration: d.Expiration,
+	})
+}
+
+// DriverMoved does nothing, the set change has the location
+func (l *Log) DriverMoved(int, storage.Location, int64) {}
+
+// DriverAppeared does nothing, the set change follows
+func (l *Log) DriverAppeared(int, storage.Location, int64) {}
+
+// DriverRemoved appends delete changes
+func (l *Log) DriverRemoved(id int) {
+	l.append(Change{Op: Delete, ID: id})
+}
+
+// DriverExpired appends delete changes, replicas don't wait for their janitor
+func (l *Log) DriverExpired(id int) {
+	l.append(Change{Op: Delete, ID: id})
+}
+
+// DriverStatusChanged appends status changes
+func (l *Log) DriverStatusChanged(id int, status storage.Status) {
+	l.append(Change{Op: Status, ID: id, Status: status})
+}
+
+// append numbers the change and wakes up followers, it's called under the storage lock,
+// so changes are numbered in order they are made
+func (l *Log) append(c Change) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c.Seq = l.next
+	l.next++
+	l.changes = append(l.changes, c)
+	// the log is trimmed to size when it doubles, so appends don't copy it every time
+	if len(l.changes) >= 2*l.size {
+		kept := make([]Change, l.size, 2*l.size)
+		copy(kept, l.changes[len(l.changes)-l.size:])
+		l.changes = kept
+	}
+	close(l.appended)
+	l.appended = make(chan struct{})
+}
+
+// Position returns the epoch and seq of the last change
+func (l *Log) Position() (epoch int64, seq uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.epoch, l.next - 1
+}
+
+// Since returns changes after seq of the epoch and a channel closed by the next change.
+// It returns ErrPositionLost if the epoch is not current or the changes are trimmed.
+func (l *Log) Since(epoch int64, seq uint64) ([]Change, <-chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	first := l.next - uint64(len(l.changes))
+	if epoch != l.epoch || seq >= l.next || seq+1 < first {
+		return nil, nil, ErrPositionLost
+	}
+	changes := make([]Change, l.next-1-seq)
+	copy(changes, l.changes[seq+1-first:])
+	return changes, l.appended, nil
+}
@@ -0,0 +1,63 @@
+package replication
+
+import (
+	"testing"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+	log := NewLog(10)
+	db := storage.New(5, storage.WithObserver(log))
+	epoch, seq := log.Position()
+	assert.Equal(t, uint64(0), seq)
+
+	_, appended, err := log.Since(epoch, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 2}, Timestamp: 10, Attributes: map[string]string{"car": "sedan"}}))
+	select {
+	case <-appended:
+	default:
+		t.Fatal("appended is not closed")
+	}
+	assert.NoError(t, db.SetStatus(1, storage.StatusBusy))
+	assert.NoError(t, db.Delete(1))
+
+	changes, _, err := log.Since(epoch, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Seq: 1, Op: Set, ID: 1, Location: storage.Location{Lat: 1, Lon: 2}, Attributes: map[string]string{"car": "sedan"}, Status: storage.StatusAvailable, Timestamp: 10},
+		{Seq: 2, Op: Status, ID: 1, Status: storage.StatusBusy},
+		{Seq: 3, Op: Delete, ID: 1},
+	}, changes)
+	changes, _, err = log.Since(epoch, 2)
+	assert.NoError(t, err)
+	assert.Len(t, changes, 1)
+	changes, _, err = log.Since(epoch, 3)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	// positions of other epochs and in the future are lost
+	_, _, err = log.Since(epoch+1, 0)
+	assert.Equal(t, ErrPositionLost, err)
+	_, _, err = log.Since(epoch, 4)
+	assert.Equal(t, ErrPositionLost, err)
+}
+
+func TestLogTrim(t *testing.T) {
+	log := NewLog(2)
+	epoch, _ := log.Position()
+	for i := 1; i <= 5; i++ {
+		log.DriverRemoved(i)
+	}
+	_, seq := log.Position()
+	assert.Equal(t, uint64(5), seq)
+
+	// the log keeps at least 2 latest changes
+	changes, _, err := log.Since(epoch, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{{Seq: 4, Op: Delete, ID: 4}, {Seq: 5, Op: Delete, ID: 5}}, changes)
+	_, _, err = log.Since(epoch, 0)
+	assert.Equal(t, ErrPositionLost, err)
+}
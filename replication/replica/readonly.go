@@ -0,0 +1,135 @@
+package replica
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/replication"
+	"github.com/kdrake/nearestdots/storage"
+)
+
+// ReadOnly serves queries of the replica storage and rejects writes, it's ready while the replica is synced
+type ReadOnly struct {
+	replica *Replica
+	local   Snapshotter
+}
+
+var (
+	_ storage.Storage = (*ReadOnly)(nil)
+	_ storage.Pinger  = (*ReadOnly)(nil)
+)
+
+// NewReadOnly creates read-only storage of the replica
+func NewReadOnly(replica *Replica) *ReadOnly {
+	return &ReadOnly{replica: replica, local: replica.db}
+}
+
+// Ping fails until the replica loads a snapshot and while it doesn't hear of the primary
+func (s *ReadOnly) Ping(ctx context.Context) error {
+	if !s.replica.Synced() {
+		return replication.ErrNotSynced
+	}
+	return nil
+}
+
+// Set returns ErrReadOnly
+func (s *ReadOnly) Set(*storage.Driver) error {
+	return replication.ErrReadOnly
+}
+
+// SetMany returns ErrReadOnly
+func (s *ReadOnly) SetMany([]*storage.Driver) error {
+	return replication.ErrReadOnly
+}
+
+// Get gets the driver of the replica
+func (s *ReadOnly) Get(id int) (*storage.Driver, error) {
+	return s.local.Get(id)
+}
+
+// List lists drivers of the replica
+func (s *ReadOnly) List(after, limit int) []*storage.Driver {
+	return s.local.List(after, limit)
+}
+
+// History returns locations of the driver of the replica
+func (s *ReadOnly) History(id int, from, to int64) ([]storage.HistoryPoint, error) {
+	return s.local.History(id, from, to)
+}
+
+// Delete returns ErrReadOnly
+func (s *ReadOnly) Delete(int) error {
+	return replication.ErrReadOnly
+}
+
+// DeleteMany returns ErrReadOnly
+func (s *ReadOnly) DeleteMany([]int) error {
+	return replication.ErrReadOnly
+}
+
+// SetStatus returns ErrReadOnly
+func (s *ReadOnly) SetStatus(int, storage.Status) error {
+	return replication.ErrReadOnly
+}
+
+// SetStatusIf returns ErrReadOnly
+func (s *ReadOnly) SetStatusIf(int, storage.Status, uint64) (uint64, error) {
+	return 0, replication.ErrReadOnly
+}
+
+// SetAttributesIf returns ErrReadOnly
+func (s *ReadOnly) SetAttributesIf(int, map[string]string, uint64) (uint64, error) {
+	return 0, replication.ErrReadOnly
+}
+
+// Nearest finds nearest drivers of the replica
+func (s *ReadOnly) Nearest(point rtreego.Point, count int, filters ...storage.Filter) []*storage.Driver {
+	return s.local.Nearest(point, count, filters...)
+}
+
+// NearestAndLock reserves nothing, reservations are made on the primary
+func (s *ReadOnly) NearestAndLock(rtreego.Point, int, time.Duration, ...storage.Filter) []*storage.Driver {
+	return nil
+}
+
+// InBoundingBox finds drivers of the replica inside the bounding box
+func (s *ReadOnly) InBoundingBox(minLat, minLon, maxLat, maxLon float64) ([]*storage.Driver, error) {
+	return s.local.InBoundingBox(minLat, minLon, maxLat, maxLon)
+}
+
+// InPolygon finds drivers of the replica inside the polygon
+func (s *ReadOnly) InPolygon(polygon storage.Polygon) ([]*storage.Driver, error) {
+	return s.local.InPolygon(polygon)
+}
+
+// Heatmap counts drivers of the replica per cell
+func (s *ReadOnly) Heatmap(precision int) ([]storage.HeatmapCell, error) {
+	return s.local.Heatmap(precision)
+}
+
+// DeleteExpired deletes expired drivers of the replica, the primary deletes them too
+func (s *ReadOnly) DeleteExpired() {
+	s.local.DeleteExpired()
+}
+
+// Len returns number of drivers of the replica
+func (s *ReadOnly) Len() int {
+	return s.local.Len()
+}
+
+// Stats returns counters of the replica
+func (s *ReadOnly) Stats() storage.Stats {
+	return s.local.Stats()
+}
+
+// WriteSnapshot writes snapshot of the replica, e.g. to back up the primary without loading it
+func (s *ReadOnly) WriteSnapshot(w io.Writer) error {
+	return s.local.WriteSnapshot(w)
+}
+
+// ReadSnapshot returns ErrReadOnly
+func (s *ReadOnly) ReadSnapshot(io.Reader) error {
+	return replication.ErrReadOnly
+}
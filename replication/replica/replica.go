@@ -0,0 +1,205 @@
+// Package replica follows the replication log of a primary, so the replica serves its drivers read-only.
+package replica
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kdrake/nearestdots/client"
+	"github.com/kdrake/nearestdots/replication"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// staleAfter is how long a replica waits for a batch before it reconnects, the primary sends heartbeats
+const staleAfter = 3 * replication.HeartbeatInterval
+
+// defaultRetryDelay is the delay before the first reconnection, every next one waits twice as long
+const defaultRetryDelay = time.Second
+
+// maxRetryDelay limits the delay between reconnections
+const maxRetryDelay = 30 * time.Second
+
+type (
+	// Snapshotter is a storage loading snapshots of the primary
+	Snapshotter interface {
+		storage.Storage
+		WriteSnapshot(w io.Writer) error
+		ReadSnapshot(r io.Reader) error
+	}
+
+	// Option configures Replica
+	Option func(*Replica)
+
+	// Replica follows changes of the primary and applies them to its storage
+	Replica struct {
+		primary    *client.Client
+		db         Snapshotter
+		retryDelay time.Duration
+
+		mu       sync.Mutex
+		synced   bool
+		epoch    int64
+		seq      uint64
+		lastSeen time.Time
+	}
+)
+
+// WithRetryDelay sets the delay before the first reconnection to the primary, 1s if not set
+func WithRetryDelay(delay time.Duration) Option {
+	return func(r *Replica) {
+		r.retryDelay = delay
+	}
+}
+
+// New creates Replica of the primary called by the client, changes are applied to db.
+// The storage must not expire, smooth or throttle drivers, the primary does it.
+func New(primary *client.Client, db Snapshotter, opts ...Option) *Replica {
+	r := &Replica{
+		primary:    primary,
+		db:         db,
+		retryDelay: defaultRetryDelay,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run follows the primary until ctx is done, it reconnects from the last applied change
+// and loads a snapshot again if the primary lost the position
+func (r *Replica) Run(ctx context.Context) error {
+	delay := r.retryDelay
+	for {
+		applied, err := r.follow(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if applied {
+			delay = r.retryDelay
+		}
+		zap.L().Warn("replication interrupted", zap.Duration("retry", delay), zap.Error(err))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// Close does nothing, Run stops when its context is done
+func (r *Replica) Close() error {
+	return nil
+}
+
+// Synced returns true if the replica has loaded a snapshot and heard of the primary recently
+func (r *Replica) Synced() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.synced && time.Since(r.lastSeen) < staleAfter
+}
+
+// Position returns the epoch and seq of the last applied change
+func (r *Replica) Position() (epoch int64, seq uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.epoch, r.seq
+}
+
+// follow applies changes until the stream fails, it returns true if a batch was received
+func (r *Replica) follow(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	r.mu.Lock()
+	synced, epoch, seq := r.synced, r.epoch, r.seq
+	r.mu.Unlock()
+	if !synced {
+		epoch, seq = 0, 0
+	}
+	changes, err := r.primary.Changes(ctx, epoch, seq)
+	if e, ok := err.(*client.Error); ok && e.Status == http.StatusGone {
+		r.mu.Lock()
+		r.synced = false
+		r.mu.Unlock()
+		return false, errors.Wrap(err, "primary lost replication position, reloading snapshot")
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "could not follow primary")
+	}
+	defer changes.Close()
+
+	// the snapshot and batches are separate gob streams, the buffered reader doesn't read past the snapshot
+	body := bufio.NewReader(changes)
+	if !synced {
+		if err := r.db.ReadSnapshot(body); err != nil {
+			return false, errors.Wrap(err, "could not load snapshot of primary")
+		}
+		zap.L().Info("loaded snapshot of primary", zap.Int64("epoch", changes.Epoch), zap.Uint64("seq", changes.Seq))
+	}
+	r.mu.Lock()
+	r.synced, r.epoch, r.seq, r.lastSeen = true, changes.Epoch, changes.Seq, time.Now()
+	r.mu.Unlock()
+	seq = changes.Seq
+
+	// a silent primary is gone, the request is cancelled to reconnect
+	watchdog := time.AfterFunc(staleAfter, cancel)
+	defer watchdog.Stop()
+	dec := gob.NewDecoder(body)
+	applied := false
+	for {
+		var b replication.Batch
+		if err := dec.Decode(&b); err != nil {
+			return applied, errors.Wrap(err, "could not read changes")
+		}
+		watchdog.Reset(staleAfter)
+		applied = true
+		for _, c := range b.Changes {
+			if c.Seq != seq+1 {
+				return applied, errors.Errorf("missed changes %d to %d", seq+1, c.Seq-1)
+			}
+			r.apply(c)
+			seq = c.Seq
+		}
+		r.mu.Lock()
+		r.seq, r.lastSeen = seq, time.Now()
+		r.mu.Unlock()
+	}
+}
+
+// apply applies the change to the storage. Changes made before the snapshot was taken
+// may be applied again, stale locations and missing drivers are skipped then.
+func (r *Replica) apply(c replication.Change) {
+	var err error
+	switch c.Op {
+	case replication.Set:
+		err = r.db.Set(&storage.Driver{
+			ID:           c.ID,
+			LastLocation: c.Location,
+			Attributes:   c.Attributes,
+			Status:       c.Status,
+			Timestamp:    c.Timestamp,
+			Expiration:   c.Expiration,
+		})
+	case replication.Delete:
+		err = r.db.Delete(c.ID)
+	case replication.Status:
+		err = r.db.SetStatus(c.ID, c.Status)
+	default:
+		err = errors.Errorf("unknown operation %q", c.Op)
+	}
+	switch errors.Cause(err) {
+	case nil, storage.ErrStaleLocation, storage.ErrDriverDoesNotExist:
+	default:
+		zap.L().Warn("could not apply change", zap.Uint64("seq", c.Seq), zap.Int("driver", c.ID), zap.Error(err))
+	}
+}
@@ -0,0 +1,128 @@
+package replica
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/api"
+	"github.com/kdrake/nearestdots/client"
+	"github.com/kdrake/nearestdots/replication"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// primary is an API with a replication log which may be restarted
+type primary struct {
+	mu      sync.Mutex
+	db      *storage.DriverStorage
+	handler http.Handler
+}
+
+func (p *primary) start() {
+	log := replication.NewLog(100)
+	db := storage.New(5, storage.WithObserver(log))
+	a := api.New(":0", storage.NewManager(db, nil), nil, api.WithReplication(log))
+	p.mu.Lock()
+	p.db, p.handler = db, a
+	p.mu.Unlock()
+}
+
+func (p *primary) storage() *storage.DriverStorage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.db
+}
+
+func (p *primary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	h := p.handler
+	p.mu.Unlock()
+	h.ServeHTTP(w, r)
+}
+
+// eventually waits until the condition is true
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition is not met")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReplica(t *testing.T) {
+	p := &primary{}
+	p.start()
+	server := httptest.NewServer(p)
+	defer server.Close()
+	assert.NoError(t, p.storage().Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+
+	local := storage.New(5)
+	r := New(client.New(server.URL), local, WithRetryDelay(10*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	readOnly := NewReadOnly(r)
+
+	// the snapshot has drivers set before the replica started
+	eventually(t, r.Synced)
+	assert.NoError(t, readOnly.Ping(context.Background()))
+	_, err := readOnly.Get(1)
+	assert.NoError(t, err)
+
+	// changes are streamed
+	assert.NoError(t, p.storage().Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: 2, Lon: 2}, Attributes: map[string]string{"car": "van"}}))
+	assert.NoError(t, p.storage().SetStatus(2, storage.StatusBusy))
+	assert.NoError(t, p.storage().Delete(1))
+	eventually(t, func() bool {
+		_, seq := r.Position()
+		return seq == 4
+	})
+	_, err = readOnly.Get(1)
+	assert.Equal(t, storage.ErrDriverDoesNotExist, err)
+	d, err := readOnly.Get(2)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.Location{Lat: 2, Lon: 2}, d.LastLocation)
+	assert.Equal(t, storage.StatusBusy, d.Status)
+	assert.Equal(t, "van", d.Attributes["car"])
+	assert.Len(t, readOnly.Nearest(rtreego.Point{2, 2}, 10), 1)
+
+	// writes are rejected
+	assert.Equal(t, replication.ErrReadOnly, readOnly.Set(&storage.Driver{ID: 3}))
+	assert.Equal(t, replication.ErrReadOnly, readOnly.Delete(2))
+	assert.Equal(t, replication.ErrReadOnly, readOnly.SetStatus(2, storage.StatusAvailable))
+	assert.Empty(t, readOnly.NearestAndLock(rtreego.Point{2, 2}, 1, time.Second))
+
+	// a restarted primary has a new epoch, the replica loads its snapshot
+	epoch, _ := r.Position()
+	p.start()
+	assert.NoError(t, p.storage().Set(&storage.Driver{ID: 5, LastLocation: storage.Location{Lat: 5, Lon: 5}}))
+	server.CloseClientConnections()
+	eventually(t, func() bool {
+		e, _ := r.Position()
+		return e != epoch && r.Synced()
+	})
+	_, err = readOnly.Get(2)
+	assert.Equal(t, storage.ErrDriverDoesNotExist, err)
+	_, err = readOnly.Get(5)
+	assert.NoError(t, err)
+}
+
+func TestReplicaNotSynced(t *testing.T) {
+	r := New(client.New("http://127.0.0.1:0"), storage.New(5))
+	assert.Equal(t, replication.ErrNotSynced, NewReadOnly(r).Ping(context.Background()))
+}
@@ -0,0 +1,106 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxBulk limits size of a bulk string of a command
+const maxBulk = 1 << 20
+
+// maxArgs limits number of arguments of a command
+const maxArgs = 1 << 16
+
+// ErrProtocol sign what request is not a RESP command
+var ErrProtocol = errors.New("Protocol error")
+
+// readCommand reads an array of bulk strings or an inline command separated by spaces
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, ErrProtocol
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, ErrProtocol
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulk {
+			return nil, ErrProtocol
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		if b[size] != '\r' || b[size+1] != '\n' {
+			return nil, ErrProtocol
+		}
+		args = append(args, string(b[:size]))
+	}
+	return args, nil
+}
+
+// readLine reads a line without CRLF, lines longer than the reader buffer are protocol errors
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", ErrProtocol
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// writer buffers replies of a command
+type writer struct {
+	*bufio.Writer
+}
+
+func (w writer) simple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func (w writer) error(s string) {
+	w.WriteString("-" + s + "\r\n")
+}
+
+func (w writer) integer(n int) {
+	w.WriteString(":" + strconv.Itoa(n) + "\r\n")
+}
+
+func (w writer) bulk(s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (w writer) null() {
+	w.WriteString("$-1\r\n")
+}
+
+func (w writer) nullArray() {
+	w.WriteString("*-1\r\n")
+}
+
+func (w writer) array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+func (w writer) float(f float64, precision int) {
+	w.bulk(strconv.FormatFloat(f, 'f', precision, 64))
+}
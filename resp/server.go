@@ -0,0 +1,640 @@
+// Package resp serves drivers over a subset of the Redis protocol, so Redis GEO clients can query them.
+// A geo set key is a namespace: the default key is the default namespace and drivers:<name> is the namespace name.
+// Members are driver ids.
+package resp
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kdrake/nearestdots/storage"
+	"go.uber.org/zap"
+)
+
+// DefaultKey is the geo set of the default namespace
+const DefaultKey = "drivers"
+
+// bufferSize is the size of read and write buffers of a connection, it limits length of inline commands
+const bufferSize = 64 << 10
+
+// metersPerDegree is length of a degree of latitude
+const metersPerDegree = 111320
+
+// units are meters in distance units of GEO commands
+var units = map[string]float64{"m": 1, "km": 1000, "mi": 1609.34, "ft": 0.3048}
+
+type (
+	// Server handles RESP connections
+	Server struct {
+		namespaces *storage.Manager
+		password   string
+		mu         sync.Mutex
+		listener   net.Listener
+		conns      map[net.Conn]struct{}
+		closed     bool
+		wg         sync.WaitGroup
+	}
+
+	// Option configures Server
+	Option func(*Server)
+
+	// session is state of a connection
+	session struct {
+		w             writer
+		authenticated bool
+		quit          bool
+	}
+
+	// replyError is a RESP error reply, e.g. ERR syntax error
+	replyError string
+)
+
+func (e replyError) Error() string {
+	return string(e)
+}
+
+const (
+	errSyntax   = replyError("ERR syntax error")
+	errNotFloat = replyError("ERR value is not a valid float")
+	errNoAuth   = replyError("NOAUTH Authentication required.")
+	errUnit     = replyError("ERR unsupported unit provided. please use M, KM, FT, MI")
+	errMember   = replyError("ERR could not decode requested zset member")
+)
+
+// WithPassword requires AUTH with the password before other commands
+func WithPassword(password string) Option {
+	return func(s *Server) {
+		s.password = password
+	}
+}
+
+// NewServer creates Server of drivers of the namespaces
+func NewServer(namespaces *storage.Manager, opts ...Option) *Server {
+	s := &Server{namespaces: namespaces, conns: make(map[net.Conn]struct{})}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Serve accepts connections until Close is called
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.listener = l
+	s.mu.Unlock()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		if !s.track(conn) {
+			conn.Close()
+			return nil
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close stops accepting connections, closes open ones and waits for their running commands
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReaderSize(conn, bufferSize)
+	ss := &session{w: writer{bufio.NewWriterSize(conn, bufferSize)}, authenticated: s.password == ""}
+	for !ss.quit {
+		args, err := readCommand(r)
+		if err == ErrProtocol {
+			ss.w.error("ERR " + err.Error())
+			ss.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if err := s.execute(ss, args); err != nil {
+			ss.w.error(err.Error())
+		}
+		// pipelined commands are replied together
+		if r.Buffered() == 0 {
+			if err := ss.w.Flush(); err != nil {
+				zap.L().Debug("could not reply RESP command", zap.Error(err))
+				return
+			}
+		}
+	}
+	ss.w.Flush()
+}
+
+// execute runs the command, a returned error is replied instead of the result
+func (s *Server) execute(ss *session, args []string) error {
+	name := strings.ToUpper(args[0])
+	switch name {
+	case "AUTH":
+		return s.auth(ss, args[1:])
+	case "QUIT":
+		ss.quit = true
+		ss.w.simple("OK")
+		return nil
+	}
+	if !ss.authenticated {
+		return errNoAuth
+	}
+	switch name {
+	case "PING":
+		if len(args) > 1 {
+			ss.w.bulk(args[1])
+		} else {
+			ss.w.simple("PONG")
+		}
+	case "SELECT", "CLIENT":
+		ss.w.simple("OK")
+	case "COMMAND":
+		ss.w.array(0)
+	case "GEOADD":
+		return s.geoadd(ss.w, args[1:])
+	case "GEOPOS":
+		return s.geopos(ss.w, args[1:])
+	case "GEODIST":
+		return s.geodist(ss.w, args[1:])
+	case "GEOSEARCH":
+		return s.geosearch(ss.w, args[1:])
+	case "ZREM":
+		return s.zrem(ss.w, args[1:])
+	case "ZCARD":
+		return s.zcard(ss.w, args[1:])
+	default:
+		return replyError("ERR unknown command '" + args[0] + "'")
+	}
+	return nil
+}
+
+func (s *Server) auth(ss *session, args []string) error {
+	// AUTH password or AUTH username password of Redis 6
+	if len(args) == 0 || len(args) > 2 {
+		return arity("auth")
+	}
+	if s.password == "" {
+		return replyError("ERR AUTH called without any password configured")
+	}
+	if subtle.ConstantTimeCompare([]byte(args[len(args)-1]), []byte(s.password)) != 1 {
+		return replyError("WRONGPASS invalid username-password pair")
+	}
+	ss.authenticated = true
+	ss.w.simple("OK")
+	return nil
+}
+
+// GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func (s *Server) geoadd(w writer, args []string) error {
+	if len(args) < 4 {
+		return arity("geoadd")
+	}
+	db, err := s.storage(args[0])
+	if err != nil {
+		return err
+	}
+	args = args[1:]
+	var nx, xx, ch bool
+options:
+	for len(args) > 0 {
+		switch strings.ToUpper(args[0]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "CH":
+			ch = true
+		default:
+			break options
+		}
+		args = args[1:]
+	}
+	if (nx && xx) || len(args) == 0 || len(args)%3 != 0 {
+		return errSyntax
+	}
+
+	drivers := make([]*storage.Driver, 0, len(args)/3)
+	for i := 0; i < len(args); i += 3 {
+		lon, err1 := strconv.ParseFloat(args[i], 64)
+		lat, err2 := strconv.ParseFloat(args[i+1], 64)
+		if err1 != nil || err2 != nil {
+			return errNotFloat
+		}
+		location := storage.Location{Lat: lat, Lon: lon}
+		if !location.Valid() {
+			return replyError("ERR invalid longitude,latitude pair " + args[i] + "," + args[i+1])
+		}
+		id, err := member(args[i+2])
+		if err != nil {
+			return err
+		}
+		drivers = append(drivers, &storage.Driver{ID: id, LastLocation: location})
+	}
+
+	count := 0
+	for _, d := range drivers {
+		// the stored driver is updated in place, so its location is copied before the update
+		var prev storage.Location
+		existing, err := db.Get(d.ID)
+		exists := err == nil
+		if exists {
+			prev = existing.LastLocation
+		}
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		if err := db.Set(d); err != nil {
+			return replyError("ERR " + err.Error())
+		}
+		if !exists || (ch && prev != d.LastLocation) {
+			count++
+		}
+	}
+	w.integer(count)
+	return nil
+}
+
+// GEOPOS key member [member ...]
+func (s *Server) geopos(w writer, args []string) error {
+	if len(args) < 1 {
+		return arity("geopos")
+	}
+	db, err := s.storage(args[0])
+	if err != nil {
+		return err
+	}
+	w.array(len(args) - 1)
+	for _, m := range args[1:] {
+		d := get(db, m)
+		if d == nil {
+			w.nullArray()
+			continue
+		}
+		w.array(2)
+		w.float(d.LastLocation.Lon, -1)
+		w.float(d.LastLocation.Lat, -1)
+	}
+	return nil
+}
+
+// GEODIST key member1 member2 [M|KM|FT|MI]
+func (s *Server) geodist(w writer, args []string) error {
+	if len(args) != 3 && len(args) != 4 {
+		return arity("geodist")
+	}
+	db, err := s.storage(args[0])
+	if err != nil {
+		return err
+	}
+	unit := 1.0
+	if len(args) == 4 {
+		if unit, err = parseUnit(args[3]); err != nil {
+			return err
+		}
+	}
+	a, b := get(db, args[1]), get(db, args[2])
+	if a == nil || b == nil {
+		w.null()
+		return nil
+	}
+	w.float(storage.Distance(a.LastLocation, b.LastLocation)/unit, 4)
+	return nil
+}
+
+// search is a parsed GEOSEARCH
+type search struct {
+	center    storage.Location
+	radius    float64
+	width     float64
+	height    float64
+	unit      float64
+	desc      bool
+	sorted    bool
+	count     int
+	withCoord bool
+	withDist  bool
+}
+
+// GEOSEARCH key FROMMEMBER member | FROMLONLAT longitude latitude
+// BYRADIUS radius unit | BYBOX width height unit [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST]
+func (s *Server) geosearch(w writer, args []string) error {
+	if len(args) < 4 {
+		return arity("geosearch")
+	}
+	db, err := s.storage(args[0])
+	if err != nil {
+		return err
+	}
+	q, err := parseSearch(db, args[1:])
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		driver   *storage.Driver
+		distance float64
+	}
+	drivers, err := db.InBoundingBox(q.bounds())
+	if err != nil {
+		return replyError("ERR " + err.Error())
+	}
+	results := make([]result, 0, len(drivers))
+	for _, d := range drivers {
+		if q.contains(d.LastLocation) {
+			results = append(results, result{d, storage.Distance(q.center, d.LastLocation)})
+		}
+	}
+	if q.sorted || q.count > 0 {
+		sort.Slice(results, func(i, j int) bool {
+			if q.desc {
+				return results[i].distance > results[j].distance
+			}
+			return results[i].distance < results[j].distance
+		})
+	}
+	if q.count > 0 && len(results) > q.count {
+		results = results[:q.count]
+	}
+
+	w.array(len(results))
+	for _, r := range results {
+		if !q.withCoord && !q.withDist {
+			w.bulk(strconv.Itoa(r.driver.ID))
+			continue
+		}
+		n := 1
+		if q.withCoord {
+			n++
+		}
+		if q.withDist {
+			n++
+		}
+		w.array(n)
+		w.bulk(strconv.Itoa(r.driver.ID))
+		if q.withDist {
+			w.float(r.distance/q.unit, 4)
+		}
+		if q.withCoord {
+			w.array(2)
+			w.float(r.driver.LastLocation.Lon, -1)
+			w.float(r.driver.LastLocation.Lat, -1)
+		}
+	}
+	return nil
+}
+
+func parseSearch(db storage.Storage, args []string) (*search, error) {
+	q := &search{}
+	var from, by bool
+	for i := 0; i < len(args); i++ {
+		rest := len(args) - i - 1
+		switch strings.ToUpper(args[i]) {
+		case "FROMMEMBER":
+			if from || rest < 1 {
+				return nil, errSyntax
+			}
+			d := get(db, args[i+1])
+			if d == nil {
+				return nil, errMember
+			}
+			q.center, from = d.LastLocation, true
+			i++
+		case "FROMLONLAT":
+			if from || rest < 2 {
+				return nil, errSyntax
+			}
+			lon, err1 := strconv.ParseFloat(args[i+1], 64)
+			lat, err2 := strconv.ParseFloat(args[i+2], 64)
+			if err1 != nil || err2 != nil {
+				return nil, errNotFloat
+			}
+			q.center, from = storage.Location{Lat: lat, Lon: lon}, true
+			if !q.center.Valid() {
+				return nil, replyError("ERR invalid longitude,latitude pair " + args[i+1] + "," + args[i+2])
+			}
+			i += 2
+		case "BYRADIUS":
+			if by || rest < 2 {
+				return nil, errSyntax
+			}
+			radius, err := strconv.ParseFloat(args[i+1], 64)
+			if err != nil || radius < 0 {
+				return nil, replyError("ERR radius cannot be negative")
+			}
+			if q.unit, err = parseUnit(args[i+2]); err != nil {
+				return nil, err
+			}
+			q.radius, by = radius*q.unit, true
+			i += 2
+		case "BYBOX":
+			if by || rest < 3 {
+				return nil, errSyntax
+			}
+			width, err1 := strconv.ParseFloat(args[i+1], 64)
+			height, err2 := strconv.ParseFloat(args[i+2], 64)
+			if err1 != nil || err2 != nil || width < 0 || height < 0 {
+				return nil, replyError("ERR height or width cannot be negative")
+			}
+			var err error
+			if q.unit, err = parseUnit(args[i+3]); err != nil {
+				return nil, err
+			}
+			q.width, q.height, by = width*q.unit, height*q.unit, true
+			i += 3
+		case "ASC":
+			q.sorted, q.desc = true, false
+		case "DESC":
+			q.sorted, q.desc = true, true
+		case "COUNT":
+			if rest < 1 {
+				return nil, errSyntax
+			}
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil || count <= 0 {
+				return nil, replyError("ERR COUNT must be > 0")
+			}
+			q.count = count
+			i++
+			// ANY stops at the first count matches in Redis, the nearest ones are returned anyway
+			if i+1 < len(args) && strings.ToUpper(args[i+1]) == "ANY" {
+				i++
+			}
+		case "WITHCOORD":
+			q.withCoord = true
+		case "WITHDIST":
+			q.withDist = true
+		default:
+			return nil, errSyntax
+		}
+	}
+	if !from {
+		return nil, replyError("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+	}
+	if !by {
+		return nil, replyError("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+	}
+	return q, nil
+}
+
+// bounds returns the bounding box of the search area, clamped to valid coordinates
+func (q *search) bounds() (minLat, minLon, maxLat, maxLon float64) {
+	dLat, dLon := q.radius, q.radius
+	if q.radius == 0 {
+		dLat, dLon = q.height/2, q.width/2
+	}
+	dLat /= metersPerDegree
+	cos := math.Cos(q.center.Lat * math.Pi / 180)
+	if cos < 1e-9 {
+		dLon = 180
+	} else {
+		dLon /= metersPerDegree * cos
+	}
+	// the box must not be empty for the index, a zero radius still matches drivers at the center
+	const epsilon = 1e-9
+	return math.Max(q.center.Lat-dLat-epsilon, -90), math.Max(q.center.Lon-dLon-epsilon, -180),
+		math.Min(q.center.Lat+dLat+epsilon, 90), math.Min(q.center.Lon+dLon+epsilon, 180)
+}
+
+// contains returns true if the location is within the radius or the box
+func (q *search) contains(l storage.Location) bool {
+	if q.width == 0 && q.height == 0 {
+		return storage.Distance(q.center, l) <= q.radius
+	}
+	// distances along the meridian and the parallel of the center, as Redis does
+	dy := storage.Distance(q.center, storage.Location{Lat: l.Lat, Lon: q.center.Lon})
+	dx := storage.Distance(storage.Location{Lat: l.Lat, Lon: q.center.Lon}, l)
+	return dy <= q.height/2 && dx <= q.width/2
+}
+
+// ZREM key member [member ...] removes drivers
+func (s *Server) zrem(w writer, args []string) error {
+	if len(args) < 2 {
+		return arity("zrem")
+	}
+	db, err := s.storage(args[0])
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, m := range args[1:] {
+		d := get(db, m)
+		if d == nil {
+			continue
+		}
+		if err := db.Delete(d.ID); err != nil {
+			return replyError("ERR " + err.Error())
+		}
+		count++
+	}
+	w.integer(count)
+	return nil
+}
+
+// ZCARD key returns number of drivers
+func (s *Server) zcard(w writer, args []string) error {
+	if len(args) != 1 {
+		return arity("zcard")
+	}
+	db, err := s.storage(args[0])
+	if err != nil {
+		return err
+	}
+	w.integer(db.Len())
+	return nil
+}
+
+// storage returns storage of the namespace of the key
+func (s *Server) storage(key string) (storage.Storage, error) {
+	namespace := ""
+	if key != DefaultKey {
+		if !strings.HasPrefix(key, DefaultKey+":") {
+			return nil, replyError("ERR unknown key, use " + DefaultKey + " or " + DefaultKey + ":<namespace>")
+		}
+		namespace = strings.TrimPrefix(key, DefaultKey+":")
+	}
+	db, err := s.namespaces.Namespace(namespace)
+	if err != nil {
+		return nil, replyError("ERR " + err.Error())
+	}
+	return db, nil
+}
+
+// get returns the driver of the member, nil if the member is not a driver id or there is no such driver
+func get(db storage.Storage, m string) *storage.Driver {
+	id, err := strconv.Atoi(m)
+	if err != nil {
+		return nil
+	}
+	d, err := db.Get(id)
+	if err != nil {
+		return nil
+	}
+	return d
+}
+
+func member(m string) (int, error) {
+	id, err := strconv.Atoi(m)
+	if err != nil || id <= 0 {
+		return 0, replyError("ERR member must be a positive driver id")
+	}
+	return id, nil
+}
+
+func parseUnit(u string) (float64, error) {
+	unit, ok := units[strings.ToLower(u)]
+	if !ok {
+		return 0, errUnit
+	}
+	return unit, nil
+}
+
+func arity(command string) error {
+	return replyError("ERR wrong number of arguments for '" + command + "' command")
+}
@@ -0,0 +1,141 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, s *Server) *client {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends the command and returns its reply: strings, integers, nil, errors and slices of them
+func (c *client) do(args ...string) interface{} {
+	w := writer{bufio.NewWriter(c.conn)}
+	w.array(len(args))
+	for _, a := range args {
+		w.bulk(a)
+	}
+	if err := w.Flush(); err != nil {
+		c.t.Fatal(err)
+	}
+	return c.reply()
+}
+
+func (c *client) reply() interface{} {
+	line, err := readLine(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return replyError(line[1:])
+	case ':':
+		n, _ := strconv.Atoi(line[1:])
+		return n
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		b := make([]byte, n+2)
+		_, err := io.ReadFull(c.r, b)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		return string(b[:n])
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i] = c.reply()
+		}
+		return items
+	}
+	c.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+func TestGeoCommands(t *testing.T) {
+	db := storage.New(10)
+	s := NewServer(storage.NewManager(db, nil))
+	defer s.Close()
+	c := dial(t, s)
+
+	assert.Equal(t, "PONG", c.do("PING"))
+	assert.Equal(t, 2, c.do("GEOADD", "drivers", "13.361389", "38.115556", "1", "15.087269", "37.502669", "2"))
+	assert.Equal(t, 0, c.do("GEOADD", "drivers", "NX", "0", "0", "1"))
+	assert.Equal(t, 1, c.do("GEOADD", "drivers", "XX", "CH", "13.361389", "38.115556", "2", "1", "1", "3"))
+	assert.Equal(t, 2, c.do("ZCARD", "drivers"))
+	assert.Equal(t, 0, c.do("GEOADD", "drivers", "15.087269", "37.502669", "2"))
+	assert.Equal(t, replyError("ERR invalid longitude,latitude pair 200,0"), c.do("GEOADD", "drivers", "200", "0", "3"))
+	assert.Equal(t, replyError("ERR member must be a positive driver id"), c.do("GEOADD", "drivers", "1", "1", "x"))
+
+	assert.Equal(t, []interface{}{[]interface{}{"13.361389", "38.115556"}, nil}, c.do("GEOPOS", "drivers", "1", "3"))
+	assert.Equal(t, "166.2276", c.do("GEODIST", "drivers", "1", "2", "km"))
+	assert.Nil(t, c.do("GEODIST", "drivers", "1", "3"))
+
+	assert.Equal(t, []interface{}{"2", "1"}, c.do("GEOSEARCH", "drivers", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"))
+	assert.Equal(t, []interface{}{"2"}, c.do("GEOSEARCH", "drivers", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km"))
+	assert.Equal(t, []interface{}{[]interface{}{"1", "166.2276", []interface{}{"13.361389", "38.115556"}}},
+		c.do("GEOSEARCH", "drivers", "FROMMEMBER", "2", "BYRADIUS", "200", "km", "DESC", "COUNT", "1", "WITHCOORD", "WITHDIST"))
+	assert.Equal(t, []interface{}{"2", "1"}, c.do("GEOSEARCH", "drivers", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC"))
+	assert.Equal(t, []interface{}{"2"}, c.do("GEOSEARCH", "drivers", "FROMLONLAT", "15", "37", "BYBOX", "200", "400", "km"))
+	assert.Equal(t, replyError("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH"),
+		c.do("GEOSEARCH", "drivers", "FROMLONLAT", "15", "37", "ASC"))
+
+	assert.Equal(t, 1, c.do("ZREM", "drivers", "1", "3"))
+	assert.Equal(t, 1, db.Len())
+	assert.Equal(t, replyError("ERR Namespaces are disabled"), c.do("ZCARD", "drivers:fleet"))
+	assert.Equal(t, replyError("ERR unknown command 'GET'"), c.do("GET", "drivers"))
+}
+
+func TestAuth(t *testing.T) {
+	s := NewServer(storage.NewManager(storage.New(10), nil), WithPassword("secret"))
+	defer s.Close()
+	c := dial(t, s)
+
+	assert.Equal(t, errNoAuth, c.do("PING"))
+	assert.Equal(t, replyError("WRONGPASS invalid username-password pair"), c.do("AUTH", "wrong"))
+	assert.Equal(t, "OK", c.do("AUTH", "default", "secret"))
+	assert.Equal(t, "PONG", c.do("PING"))
+}
+
+func TestInlineCommands(t *testing.T) {
+	s := NewServer(storage.NewManager(storage.New(10), nil))
+	defer s.Close()
+	c := dial(t, s)
+
+	_, err := c.conn.Write([]byte("PING\r\nGEOADD drivers 1 1 1\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "PONG", c.reply())
+	assert.Equal(t, 1, c.reply())
+}
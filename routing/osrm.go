@@ -0,0 +1,62 @@
+package routing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+)
+
+// OSRM is a client of the OSRM table service with the driving profile
+type OSRM struct {
+	url    string
+	client *http.Client
+}
+
+type osrmTable struct {
+	Code      string       `json:"code"`
+	Durations [][]*float64 `json:"durations"`
+}
+
+// TravelTimes requests a table with origins as sources and the destination as the only target
+func (o *OSRM) TravelTimes(origins []storage.Location, destination storage.Location) ([]time.Duration, error) {
+	if len(origins) == 0 {
+		return nil, nil
+	}
+	coords := make([]string, 0, len(origins)+1)
+	sources := make([]string, 0, len(origins))
+	for i, l := range origins {
+		coords = append(coords, fmt.Sprintf("%f,%f", l.Lon, l.Lat))
+		sources = append(sources, fmt.Sprint(i))
+	}
+	coords = append(coords, fmt.Sprintf("%f,%f", destination.Lon, destination.Lat))
+	url := fmt.Sprintf("%s/table/v1/driving/%s?sources=%s&destinations=%d",
+		strings.TrimRight(o.url, "/"), strings.Join(coords, ";"), strings.Join(sources, ";"), len(origins))
+
+	resp, err := o.client.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not request OSRM")
+	}
+	defer resp.Body.Close()
+
+	var table osrmTable
+	if err := json.NewDecoder(resp.Body).Decode(&table); err != nil {
+		return nil, errors.Wrap(err, "could not decode OSRM response")
+	}
+	if table.Code != "Ok" || len(table.Durations) != len(origins) {
+		return nil, errors.Wrapf(ErrUnexpectedResponse, "OSRM code %s", table.Code)
+	}
+
+	times := make([]time.Duration, len(origins))
+	for i, row := range table.Durations {
+		if len(row) != 1 {
+			return nil, ErrUnexpectedResponse
+		}
+		times[i] = seconds(row[0])
+	}
+	return times, nil
+}
@@ -0,0 +1,42 @@
+package routing
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+)
+
+// requestTimeout limits a single request to the routing engine
+const requestTimeout = 2 * time.Second
+
+// ErrUnexpectedResponse sign what routing engine returned a response which can't be used
+var ErrUnexpectedResponse = errors.New("Unexpected routing response")
+
+// Router estimates road travel times, it's implemented by routing engine clients
+type Router interface {
+	// TravelTimes returns travel time from every origin to the destination,
+	// negative if the destination is unreachable from the origin
+	TravelTimes(origins []storage.Location, destination storage.Location) ([]time.Duration, error)
+}
+
+// New creates client of the routing engine kind at url, kind is osrm or valhalla
+func New(kind, url string) (Router, error) {
+	client := &http.Client{Timeout: requestTimeout}
+	switch kind {
+	case "osrm":
+		return &OSRM{url: url, client: client}, nil
+	case "valhalla":
+		return &Valhalla{url: url, client: client}, nil
+	}
+	return nil, errors.Errorf("unknown routing engine %q", kind)
+}
+
+// seconds converts travel time in seconds, nil is unreachable
+func seconds(s *float64) time.Duration {
+	if s == nil {
+		return -1
+	}
+	return time.Duration(*s * float64(time.Second))
+}
@@ -0,0 +1,52 @@
+package routing
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOSRM(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/table/v1/driving/74.000000,42.000000;74.100000,42.100000;74.500000,42.500000", r.URL.Path)
+		assert.Equal(t, "sources=0;1&destinations=2", r.URL.RawQuery)
+		w.Write([]byte(`{"code":"Ok","durations":[[120.5],[null]]}`))
+	}))
+	defer srv.Close()
+
+	router, err := New("osrm", srv.URL)
+	assert.NoError(t, err)
+	times, err := router.TravelTimes(
+		[]storage.Location{{Lat: 42, Lon: 74}, {Lat: 42.1, Lon: 74.1}},
+		storage.Location{Lat: 42.5, Lon: 74.5})
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{120500 * time.Millisecond, -1}, times)
+}
+
+func TestValhalla(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/sources_to_targets", r.URL.Path)
+		var req valhallaRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Len(t, req.Sources, 2)
+		assert.Equal(t, []valhallaLocation{{Lat: 42.5, Lon: 74.5}}, req.Targets)
+		w.Write([]byte(`{"sources_to_targets":[[{"time":60}],[{"time":30}]]}`))
+	}))
+	defer srv.Close()
+
+	router, err := New("valhalla", srv.URL)
+	assert.NoError(t, err)
+	times, err := router.TravelTimes(
+		[]storage.Location{{Lat: 42, Lon: 74}, {Lat: 42.1, Lon: 74.1}},
+		storage.Location{Lat: 42.5, Lon: 74.5})
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Minute, 30 * time.Second}, times)
+
+	_, err = New("graphhopper", srv.URL)
+	assert.Error(t, err)
+}
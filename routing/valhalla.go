@@ -0,0 +1,79 @@
+package routing
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+)
+
+// Valhalla is a client of the Valhalla matrix service with the auto costing
+type Valhalla struct {
+	url    string
+	client *http.Client
+}
+
+type (
+	valhallaLocation struct {
+		Lat float64 `json:"lat"`
+		Lon float64 `json:"lon"`
+	}
+	valhallaRequest struct {
+		Sources []valhallaLocation `json:"sources"`
+		Targets []valhallaLocation `json:"targets"`
+		Costing string             `json:"costing"`
+	}
+	valhallaMatrix struct {
+		SourcesToTargets [][]struct {
+			Time *float64 `json:"time"`
+		} `json:"sources_to_targets"`
+	}
+)
+
+// TravelTimes requests a matrix with origins as sources and the destination as the only target
+func (v *Valhalla) TravelTimes(origins []storage.Location, destination storage.Location) ([]time.Duration, error) {
+	if len(origins) == 0 {
+		return nil, nil
+	}
+	req := valhallaRequest{
+		Targets: []valhallaLocation{{Lat: destination.Lat, Lon: destination.Lon}},
+		Costing: "auto",
+	}
+	for _, l := range origins {
+		req.Sources = append(req.Sources, valhallaLocation{Lat: l.Lat, Lon: l.Lon})
+	}
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not encode Valhalla request")
+	}
+
+	resp, err := v.client.Post(strings.TrimRight(v.url, "/")+"/sources_to_targets", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not request Valhalla")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Wrapf(ErrUnexpectedResponse, "Valhalla status %d", resp.StatusCode)
+	}
+
+	var matrix valhallaMatrix
+	if err := json.NewDecoder(resp.Body).Decode(&matrix); err != nil {
+		return nil, errors.Wrap(err, "could not decode Valhalla response")
+	}
+	if len(matrix.SourcesToTargets) != len(origins) {
+		return nil, ErrUnexpectedResponse
+	}
+
+	times := make([]time.Duration, len(origins))
+	for i, row := range matrix.SourcesToTargets {
+		if len(row) != 1 {
+			return nil, ErrUnexpectedResponse
+		}
+		times[i] = seconds(row[0].Time)
+	}
+	return times, nil
+}
@@ -0,0 +1,108 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Roles of credentials, they're the same as roles of API keys
+const (
+	roleDriver     = "driver"
+	roleDispatcher = "dispatcher"
+)
+
+// apiKeyMetadata carries the API key of a call
+const apiKeyMetadata = "x-api-key"
+
+// Authenticator checks API keys and bearer tokens of calls
+type Authenticator interface {
+	// Enabled returns true if calls must have credentials
+	Enabled() bool
+	// Authenticate returns role of the bearer token or the API key,
+	// driverID is set for driver tokens that may only write the driver
+	Authenticate(key, token string) (role string, driverID *int, err error)
+}
+
+// WithAuth requires x-api-key metadata or an authorization bearer token of the role needed by the method.
+// UpdateLocation needs a driver role, other methods need a dispatcher role.
+func WithAuth(auth Authenticator) Option {
+	return func(s *Server) {
+		s.auth = auth
+	}
+}
+
+// ServerOptions returns interceptors authorizing calls, they're passed to grpc.NewServer
+func (s *Server) ServerOptions() []grpc.ServerOption {
+	if s.auth == nil || !s.auth.Enabled() {
+		return nil
+	}
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if _, err := s.authorize(ctx, info.FullMethod); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			driverID, err := s.authorize(ss.Context(), info.FullMethod)
+			if err != nil {
+				return err
+			}
+			if driverID != nil {
+				ss = &driverStream{ServerStream: ss, id: int64(*driverID)}
+			}
+			return handler(srv, ss)
+		}),
+	}
+}
+
+// authorize checks credentials of the call have the role of the method,
+// it returns the driver id of driver tokens
+func (s *Server) authorize(ctx context.Context, method string) (*int, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var key, token string
+	if v := md.Get(apiKeyMetadata); len(v) > 0 {
+		key = v[0]
+	}
+	if v := md.Get("authorization"); len(v) > 0 && strings.HasPrefix(v[0], "Bearer ") {
+		token = strings.TrimPrefix(v[0], "Bearer ")
+	}
+	role, driverID, err := s.auth.Authenticate(key, token)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if role != methodRole(method) {
+		return nil, status.Errorf(codes.PermissionDenied, "Role %s is not allowed to call %s", role, method)
+	}
+	return driverID, nil
+}
+
+// driverStream is a stream of a driver token, it may only update the driver
+type driverStream struct {
+	grpc.ServerStream
+	id int64
+}
+
+// RecvMsg fails the stream on updates of other drivers
+func (s *driverStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if u, ok := m.(*LocationUpdate); ok && u.Id != s.id {
+		return status.Errorf(codes.PermissionDenied, "Token is not allowed to write driver %d", u.Id)
+	}
+	return nil
+}
+
+// methodRole returns role allowed to call the method
+func methodRole(method string) string {
+	if method == Drivers_UpdateLocation_FullMethodName {
+		return roleDriver
+	}
+	return roleDispatcher
+}
@@ -0,0 +1,85 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// keys authenticates API keys of roles
+type keys map[string]string
+
+func (k keys) Enabled() bool {
+	return true
+}
+
+func (k keys) Authenticate(key, token string) (string, *int, error) {
+	// tokens are ids of drivers in tests
+	if id, err := strconv.Atoi(token); err == nil {
+		return roleDriver, &id, nil
+	}
+	if r, ok := k[key]; ok {
+		return r, nil, nil
+	}
+	return "", nil, errors.New("Set a valid API key")
+}
+
+func TestAuth(t *testing.T) {
+	db := storage.New(10)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	client := dial(t, NewServer(db, WithAuth(keys{"d1": roleDriver, "x1": roleDispatcher})))
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), apiKeyMetadata, key)
+	}
+
+	_, err := client.GetDriver(context.Background(), &GetDriverRequest{Id: 1})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.GetDriver(withKey("unknown"), &GetDriverRequest{Id: 1})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.GetDriver(withKey("d1"), &GetDriverRequest{Id: 1})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.GetDriver(withKey("x1"), &GetDriverRequest{Id: 1})
+	assert.NoError(t, err)
+	_, err = client.Nearest(withKey("x1"), &NearestRequest{Location: &Location{Lat: 1, Lon: 1}})
+	assert.NoError(t, err)
+
+	// streams are authorized before the first message
+	upload, err := client.UpdateLocation(withKey("x1"))
+	assert.NoError(t, err)
+	_, err = upload.CloseAndRecv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	upload, err = client.UpdateLocation(withKey("d1"))
+	assert.NoError(t, err)
+	assert.NoError(t, upload.Send(&LocationUpdate{Id: 2, Location: &Location{Lat: 1, Lon: 1}}))
+	summary, err := upload.CloseAndRecv()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), summary.Accepted)
+}
+
+func TestDriverToken(t *testing.T) {
+	db := storage.New(10)
+	client := dial(t, NewServer(db, WithAuth(keys{})))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer 1")
+
+	upload, err := client.UpdateLocation(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, upload.Send(&LocationUpdate{Id: 1, Location: &Location{Lat: 1, Lon: 1}}))
+	summary, err := upload.CloseAndRecv()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), summary.Accepted)
+
+	upload, err = client.UpdateLocation(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, upload.Send(&LocationUpdate{Id: 2, Location: &Location{Lat: 1, Lon: 1}}))
+	_, err = upload.CloseAndRecv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = db.Get(2)
+	assert.Equal(t, storage.ErrDriverDoesNotExist, err)
+}
@@ -0,0 +1,298 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             v27.2.0
+// source: nearestdots.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Drivers_UpdateLocation_FullMethodName = "/nearestdots.Drivers/UpdateLocation"
+	Drivers_GetDriver_FullMethodName      = "/nearestdots.Drivers/GetDriver"
+	Drivers_Nearest_FullMethodName        = "/nearestdots.Drivers/Nearest"
+	Drivers_StreamUpdates_FullMethodName  = "/nearestdots.Drivers/StreamUpdates"
+)
+
+// DriversClient is the client API for Drivers service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Drivers is the gRPC API of the drivers storage
+type DriversClient interface {
+	// UpdateLocation stores locations streamed by a driver app, stale ones are rejected
+	UpdateLocation(ctx context.Context, opts ...grpc.CallOption) (Drivers_UpdateLocationClient, error)
+	// GetDriver returns the driver
+	GetDriver(ctx context.Context, in *GetDriverRequest, opts ...grpc.CallOption) (*Driver, error)
+	// Nearest returns nearest drivers to the location
+	Nearest(ctx context.Context, in *NearestRequest, opts ...grpc.CallOption) (*NearestResponse, error)
+	// StreamUpdates pushes location updates of drivers selected by the bounding box and/or ids
+	StreamUpdates(ctx context.Context, in *StreamUpdatesRequest, opts ...grpc.CallOption) (Drivers_StreamUpdatesClient, error)
+}
+
+type driversClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDriversClient(cc grpc.ClientConnInterface) DriversClient {
+	return &driversClient{cc}
+}
+
+func (c *driversClient) UpdateLocation(ctx context.Context, opts ...grpc.CallOption) (Drivers_UpdateLocationClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Drivers_ServiceDesc.Streams[0], Drivers_UpdateLocation_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &driversUpdateLocationClient{ClientStream: stream}
+	return x, nil
+}
+
+type Drivers_UpdateLocationClient interface {
+	Send(*LocationUpdate) error
+	CloseAndRecv() (*UpdateLocationSummary, error)
+	grpc.ClientStream
+}
+
+type driversUpdateLocationClient struct {
+	grpc.ClientStream
+}
+
+func (x *driversUpdateLocationClient) Send(m *LocationUpdate) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *driversUpdateLocationClient) CloseAndRecv() (*UpdateLocationSummary, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UpdateLocationSummary)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *driversClient) GetDriver(ctx context.Context, in *GetDriverRequest, opts ...grpc.CallOption) (*Driver, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Driver)
+	err := c.cc.Invoke(ctx, Drivers_GetDriver_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *driversClient) Nearest(ctx context.Context, in *NearestRequest, opts ...grpc.CallOption) (*NearestResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(NearestResponse)
+	err := c.cc.Invoke(ctx, Drivers_Nearest_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *driversClient) StreamUpdates(ctx context.Context, in *StreamUpdatesRequest, opts ...grpc.CallOption) (Drivers_StreamUpdatesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Drivers_ServiceDesc.Streams[1], Drivers_StreamUpdates_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &driversStreamUpdatesClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Drivers_StreamUpdatesClient interface {
+	Recv() (*DriverUpdate, error)
+	grpc.ClientStream
+}
+
+type driversStreamUpdatesClient struct {
+	grpc.ClientStream
+}
+
+func (x *driversStreamUpdatesClient) Recv() (*DriverUpdate, error) {
+	m := new(DriverUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DriversServer is the server API for Drivers service.
+// All implementations must embed UnimplementedDriversServer
+// for forward compatibility
+//
+// Drivers is the gRPC API of the drivers storage
+type DriversServer interface {
+	// UpdateLocation stores locations streamed by a driver app, stale ones are rejected
+	UpdateLocation(Drivers_UpdateLocationServer) error
+	// GetDriver returns the driver
+	GetDriver(context.Context, *GetDriverRequest) (*Driver, error)
+	// Nearest returns nearest drivers to the location
+	Nearest(context.Context, *NearestRequest) (*NearestResponse, error)
+	// StreamUpdates pushes location updates of drivers selected by the bounding box and/or ids
+	StreamUpdates(*StreamUpdatesRequest, Drivers_StreamUpdatesServer) error
+	mustEmbedUnimplementedDriversServer()
+}
+
+// UnimplementedDriversServer must be embedded to have forward compatible implementations.
+type UnimplementedDriversServer struct {
+}
+
+func (UnimplementedDriversServer) UpdateLocation(Drivers_UpdateLocationServer) error {
+	return status.Errorf(codes.Unimplemented, "method UpdateLocation not implemented")
+}
+func (UnimplementedDriversServer) GetDriver(context.Context, *GetDriverRequest) (*Driver, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetDriver not implemented")
+}
+func (UnimplementedDriversServer) Nearest(context.Context, *NearestRequest) (*NearestResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Nearest not implemented")
+}
+func (UnimplementedDriversServer) StreamUpdates(*StreamUpdatesRequest, Drivers_StreamUpdatesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamUpdates not implemented")
+}
+func (UnimplementedDriversServer) mustEmbedUnimplementedDriversServer() {}
+
+// UnsafeDriversServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DriversServer will
+// result in compilation errors.
+type UnsafeDriversServer interface {
+	mustEmbedUnimplementedDriversServer()
+}
+
+func RegisterDriversServer(s grpc.ServiceRegistrar, srv DriversServer) {
+	s.RegisterService(&Drivers_ServiceDesc, srv)
+}
+
+func _Drivers_UpdateLocation_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DriversServer).UpdateLocation(&driversUpdateLocationServer{ServerStream: stream})
+}
+
+type Drivers_UpdateLocationServer interface {
+	SendAndClose(*UpdateLocationSummary) error
+	Recv() (*LocationUpdate, error)
+	grpc.ServerStream
+}
+
+type driversUpdateLocationServer struct {
+	grpc.ServerStream
+}
+
+func (x *driversUpdateLocationServer) SendAndClose(m *UpdateLocationSummary) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *driversUpdateLocationServer) Recv() (*LocationUpdate, error) {
+	m := new(LocationUpdate)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Drivers_GetDriver_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDriverRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriversServer).GetDriver(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Drivers_GetDriver_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriversServer).GetDriver(ctx, req.(*GetDriverRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Drivers_Nearest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NearestRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DriversServer).Nearest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Drivers_Nearest_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DriversServer).Nearest(ctx, req.(*NearestRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Drivers_StreamUpdates_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamUpdatesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DriversServer).StreamUpdates(m, &driversStreamUpdatesServer{ServerStream: stream})
+}
+
+type Drivers_StreamUpdatesServer interface {
+	Send(*DriverUpdate) error
+	grpc.ServerStream
+}
+
+type driversStreamUpdatesServer struct {
+	grpc.ServerStream
+}
+
+func (x *driversStreamUpdatesServer) Send(m *DriverUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Drivers_ServiceDesc is the grpc.ServiceDesc for Drivers service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Drivers_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "nearestdots.Drivers",
+	HandlerType: (*DriversServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetDriver",
+			Handler:    _Drivers_GetDriver_Handler,
+		},
+		{
+			MethodName: "Nearest",
+			Handler:    _Drivers_Nearest_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UpdateLocation",
+			Handler:       _Drivers_UpdateLocation_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "StreamUpdates",
+			Handler:       _Drivers_StreamUpdates_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "nearestdots.proto",
+}
@@ -0,0 +1,209 @@
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative nearestdots.proto
+
+import (
+	"context"
+	"io"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/kdrake/nearestdots/stream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultNearestCount is number of nearest drivers returned if count is not set
+const defaultNearestCount = 10
+
+// defaultAverageSpeed is speed in meters per second for ETA of standing drivers
+const defaultAverageSpeed = 8.3
+
+// Server implements Drivers service backed by the storage
+type Server struct {
+	UnimplementedDriversServer
+
+	db           storage.Storage
+	hub          *stream.Hub
+	averageSpeed float64
+	auth         Authenticator
+	done         chan struct{}
+}
+
+// Option configures Server
+type Option func(*Server)
+
+// WithStream enables StreamUpdates of the hub
+func WithStream(hub *stream.Hub) Option {
+	return func(s *Server) {
+		s.hub = hub
+	}
+}
+
+// WithAverageSpeed sets speed in meters per second used for ETA of standing drivers
+func WithAverageSpeed(speed float64) Option {
+	return func(s *Server) {
+		s.averageSpeed = speed
+	}
+}
+
+// NewServer creates Server of the storage
+func NewServer(db storage.Storage, opts ...Option) *Server {
+	s := &Server{db: db, averageSpeed: defaultAverageSpeed, done: make(chan struct{})}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Register registers the server in gRPC server g
+func (s *Server) Register(g *grpc.Server) {
+	RegisterDriversServer(g, s)
+}
+
+// Shutdown ends update streams, GracefulStop of the gRPC server waits for them otherwise
+func (s *Server) Shutdown() {
+	close(s.done)
+}
+
+// UpdateLocation stores streamed locations until the client closes the stream
+func (s *Server) UpdateLocation(updates Drivers_UpdateLocationServer) error {
+	summary := &UpdateLocationSummary{}
+	for {
+		u, err := updates.Recv()
+		if err == io.EOF {
+			return updates.SendAndClose(summary)
+		}
+		if err != nil {
+			return err
+		}
+		// ids must be positive like ids of HTTP payloads
+		if u.Id <= 0 || u.Location == nil {
+			summary.Rejected++
+			continue
+		}
+
+		err = s.db.Set(&storage.Driver{
+			ID:           int(u.Id),
+			LastLocation: location(u.Location),
+			Attributes:   u.Attributes,
+			Status:       storage.Status(u.Status),
+			Timestamp:    u.Timestamp,
+		})
+		switch err {
+		case nil:
+			summary.Accepted++
+		case storage.ErrStaleLocation, storage.ErrThrottled, storage.ErrImpossibleSpeed, storage.ErrInvalidStatus, storage.ErrInvalidLocation:
+			summary.Rejected++
+		default:
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+}
+
+// GetDriver returns the driver or NotFound error
+func (s *Server) GetDriver(_ context.Context, r *GetDriverRequest) (*Driver, error) {
+	d, err := s.db.Get(int(r.Id))
+	if err == storage.ErrDriverDoesNotExist {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return driver(d), nil
+}
+
+// Nearest returns nearest drivers, only available ones unless include_unavailable is set
+func (s *Server) Nearest(_ context.Context, r *NearestRequest) (*NearestResponse, error) {
+	if r.Location == nil {
+		return nil, status.Error(codes.InvalidArgument, "location is required")
+	}
+	if !location(r.Location).Valid() {
+		return nil, status.Error(codes.InvalidArgument, storage.ErrInvalidLocation.Error())
+	}
+	count := int(r.Count)
+	if count < 0 {
+		return nil, status.Error(codes.InvalidArgument, "count must be a positive integer")
+	}
+	if count == 0 {
+		count = defaultNearestCount
+	}
+
+	var filters []storage.Filter
+	if !r.IncludeUnavailable {
+		filters = append(filters, storage.Available())
+	}
+	if len(r.Attributes) > 0 {
+		filters = append(filters, storage.AttributesFilter(r.Attributes))
+	}
+
+	origin := location(r.Location)
+	drivers := s.db.Nearest(rtreego.Point{origin.Lat, origin.Lon}, count, filters...)
+	resp := &NearestResponse{Drivers: make([]*NearestDriver, 0, len(drivers))}
+	for _, d := range drivers {
+		resp.Drivers = append(resp.Drivers, &NearestDriver{
+			Driver:     driver(d),
+			Distance:   storage.Distance(origin, d.LastLocation),
+			EtaSeconds: storage.ETA(d, origin, s.averageSpeed).Seconds(),
+		})
+	}
+	return resp, nil
+}
+
+// StreamUpdates pushes location updates until the client cancels the call
+func (s *Server) StreamUpdates(r *StreamUpdatesRequest, updates Drivers_StreamUpdatesServer) error {
+	if s.hub == nil {
+		return status.Error(codes.Unimplemented, "streaming is disabled")
+	}
+
+	var filter stream.Filter
+	if b := r.Box; b != nil {
+		if !storage.ValidBoundingBox(b.MinLat, b.MinLon, b.MaxLat, b.MaxLon) {
+			return status.Error(codes.InvalidArgument, storage.ErrInvalidBoundingBox.Error())
+		}
+		filter.Box = &[4]float64{b.MinLat, b.MinLon, b.MaxLat, b.MaxLon}
+	}
+	for _, id := range r.Ids {
+		filter.IDs = append(filter.IDs, int(id))
+	}
+
+	sub := s.hub.Subscribe(filter)
+	defer sub.Close()
+	// headers tell the client it's subscribed
+	if err := updates.SendHeader(nil); err != nil {
+		return err
+	}
+	for {
+		select {
+		case u := <-sub.Updates():
+			msg := &DriverUpdate{Type: u.Type, DriverId: int64(u.DriverID), Timestamp: u.Timestamp}
+			if u.Location != nil {
+				msg.Location = &Location{Lat: u.Location.Lat, Lon: u.Location.Lon}
+			}
+			if err := updates.Send(msg); err != nil {
+				return err
+			}
+		case <-updates.Context().Done():
+			return nil
+		case <-s.done:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+	}
+}
+
+func location(l *Location) storage.Location {
+	return storage.Location{Lat: l.Lat, Lon: l.Lon}
+}
+
+func driver(d *storage.Driver) *Driver {
+	return &Driver{
+		Id:         int64(d.ID),
+		Location:   &Location{Lat: d.LastLocation.Lat, Lon: d.LastLocation.Lon},
+		Attributes: d.Attributes,
+		Status:     string(d.Status),
+		Speed:      d.Speed,
+		Heading:    d.Heading,
+		Timestamp:  d.Timestamp,
+	}
+}
@@ -0,0 +1,109 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/kdrake/nearestdots/stream"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestServer(t *testing.T) {
+	hub := stream.NewHub(10)
+	db := storage.New(10, storage.WithObserver(hub))
+	client := dial(t, NewServer(db, WithStream(hub)))
+	ctx := context.Background()
+
+	updates, err := client.StreamUpdates(ctx, &StreamUpdatesRequest{Ids: []int64{2}})
+	assert.NoError(t, err)
+	_, err = updates.Header()
+	assert.NoError(t, err)
+
+	upload, err := client.UpdateLocation(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, upload.Send(&LocationUpdate{Id: 1, Location: &Location{Lat: 1, Lon: 1}, Timestamp: 2}))
+	assert.NoError(t, upload.Send(&LocationUpdate{Id: 1, Location: &Location{Lat: 1.1, Lon: 1}, Timestamp: 1}))
+	assert.NoError(t, upload.Send(&LocationUpdate{Id: 2, Location: &Location{Lat: 1.2, Lon: 1}}))
+	assert.NoError(t, upload.Send(&LocationUpdate{Id: 3}))
+	assert.NoError(t, upload.Send(&LocationUpdate{Id: 4, Location: &Location{Lat: 100, Lon: 1}}))
+	assert.NoError(t, upload.Send(&LocationUpdate{Id: 0, Location: &Location{Lat: 1, Lon: 1}}))
+	assert.NoError(t, upload.Send(&LocationUpdate{Id: -6, Location: &Location{Lat: 1, Lon: 1}}))
+	summary, err := upload.CloseAndRecv()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), summary.Accepted)
+	assert.Equal(t, int64(5), summary.Rejected)
+	assert.Equal(t, 2, db.Len())
+
+	u, err := updates.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, stream.Moved, u.Type)
+	assert.Equal(t, int64(2), u.DriverId)
+	assert.Equal(t, 1.2, u.Location.Lat)
+
+	d, err := client.GetDriver(ctx, &GetDriverRequest{Id: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, d.Location.Lat)
+	assert.Equal(t, int64(2), d.Timestamp)
+	_, err = client.GetDriver(ctx, &GetDriverRequest{Id: 42})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	nearest, err := client.Nearest(ctx, &NearestRequest{Location: &Location{Lat: 1.21, Lon: 1}, Count: 1})
+	assert.NoError(t, err)
+	assert.Len(t, nearest.Drivers, 1)
+	assert.Equal(t, int64(2), nearest.Drivers[0].Driver.Id)
+	assert.InDelta(t, 1112, nearest.Drivers[0].Distance, 1)
+	_, err = client.Nearest(ctx, &NearestRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.Nearest(ctx, &NearestRequest{Location: &Location{Lat: 91}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	pacific, err := client.StreamUpdates(ctx, &StreamUpdatesRequest{Box: &BoundingBox{MinLat: -20, MinLon: 170, MaxLat: -10, MaxLon: -170}})
+	assert.NoError(t, err)
+	_, err = pacific.Header()
+	assert.NoError(t, err)
+	assert.NoError(t, db.Set(&storage.Driver{ID: 5, LastLocation: storage.Location{Lat: -15, Lon: -178}}))
+	u, err = pacific.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), u.DriverId)
+	inverted, err := client.StreamUpdates(ctx, &StreamUpdatesRequest{Box: &BoundingBox{MinLat: -10, MaxLat: -20}})
+	assert.NoError(t, err)
+	_, err = inverted.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestShutdown(t *testing.T) {
+	hub := stream.NewHub(10)
+	s := NewServer(storage.New(10, storage.WithObserver(hub)), WithStream(hub))
+	client := dial(t, s)
+
+	updates, err := client.StreamUpdates(context.Background(), &StreamUpdatesRequest{})
+	assert.NoError(t, err)
+	_, err = updates.Header()
+	assert.NoError(t, err)
+	s.Shutdown()
+	// clients reconnect to another server
+	_, err = updates.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func dial(t *testing.T, s *Server) DriversClient {
+	l := bufconn.Listen(1 << 20)
+	g := grpc.NewServer(s.ServerOptions()...)
+	s.Register(g)
+	go g.Serve(l)
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return NewDriversClient(conn)
+}
@@ -0,0 +1,1008 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/kdrake/nearestdots/api"
+	"github.com/kdrake/nearestdots/archive"
+	"github.com/kdrake/nearestdots/backup"
+	"github.com/kdrake/nearestdots/cdc"
+	"github.com/kdrake/nearestdots/client"
+	"github.com/kdrake/nearestdots/cluster"
+	"github.com/kdrake/nearestdots/config"
+	"github.com/kdrake/nearestdots/consensus"
+	"github.com/kdrake/nearestdots/geofence"
+	"github.com/kdrake/nearestdots/ingest"
+	"github.com/kdrake/nearestdots/replication"
+	"github.com/kdrake/nearestdots/replication/replica"
+	"github.com/kdrake/nearestdots/resp"
+	"github.com/kdrake/nearestdots/routing"
+	"github.com/kdrake/nearestdots/rpc"
+	"github.com/kdrake/nearestdots/simulate"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/kdrake/nearestdots/storage/postgis"
+	"github.com/kdrake/nearestdots/stream"
+	"github.com/kdrake/nearestdots/tracing"
+	"github.com/kdrake/nearestdots/webhook"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// serveCommand serves the API until SIGINT or SIGTERM, it returns the exit code
+func serveCommand(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: nearestdots [serve] [flags]\n\nServes the API, the command may be omitted.")
+		fs.PrintDefaults()
+	}
+	bindAddr := fs.String("bind_addr", ":8080", "Set comma separated bind addresses, unix:///path listens a unix socket")
+	size := fs.Int("lru_size", 20, "Set lru size per driver")
+	historyMaxAge := fs.Duration("history_max_age", 0, "Set how much older than the newest location locations of drivers are kept in their history, 0 keeps lru_size locations of any age")
+	historyRecent := fs.Duration("history_recent", 5*time.Minute, "Set how much older than the newest location locations of drivers are downsampled in their history")
+	historyEvery := fs.Int("history_every", 1, "Set every how many of downsampled locations one is kept in the history of drivers, 1 keeps all of them")
+	snapshotPath := fs.String("snapshot_path", "", "Set snapshot file to restore on start and save periodically")
+	snapshotInterval := fs.Duration("snapshot_interval", time.Minute, "Set interval between snapshots")
+	walDir := fs.String("wal_dir", "", "Set directory for write-ahead log, disabled if empty")
+	maxNamespaces := fs.Int("max_namespaces", 1000, "Set maximal number of namespaces created by writes besides the default one, 0 is unlimited")
+	archiveDir := fs.String("archive_dir", "", "Set directory archiving locations of the default namespace for playback at /playback and nearest queries with at, disabled if empty")
+	archiveRetention := fs.Duration("archive_retention", 7*24*time.Hour, "Set how long archived locations are kept")
+	archiveWindow := fs.Duration("archive_window", 5*time.Minute, "Set how old the last location of a driver may be to play it back at a time")
+	ttl := fs.Duration("ttl", 5*time.Minute, "Set default driver expiration, 0 disables it")
+	janitorInterval := fs.Duration("janitor_interval", 10*time.Second, "Set interval between removals of expired drivers")
+	indexType := fs.String("index", "rtree", "Set spatial index type: rtree, geohash or s2")
+	geohashPrecision := fs.Int("geohash_precision", 6, "Set geohash cell precision for geohash index")
+	s2Level := fs.Int("s2_level", 13, "Set S2 cell level for s2 index")
+	rtreeMinChildren := fs.Int("rtree_min_children", 25, "Set minimal number of children of rtree nodes")
+	rtreeMaxChildren := fs.Int("rtree_max_children", 50, "Set maximal number of children of rtree nodes, at least twice rtree_min_children")
+	rtreeTolerance := fs.Float64("rtree_tolerance", 1100, "Set half size in meters of the rect around a driver in the rtree, longitudes of rects are scaled by latitude")
+	readSnapshotInterval := fs.Duration("read_snapshot_interval", 0, "Set how old a copy of the index nearest and area queries are served from may get, they don't wait for writers then but lag behind them. 0 disables it")
+	shards := fs.Int("shards", 1, "Set number of storage shards partitioned by driver id")
+	regionsFile := fs.String("regions", "", "Set JSON file of regions drivers are partitioned across by location, every region has its own index and stats, drivers outside of them are rejected. Disabled if empty")
+	smoothingNoise := fs.Float64("smoothing_noise", 0, "Set expected driver speed in m/s for Kalman smoothing of locations, 0 disables it")
+	gpsAccuracy := fs.Float64("gps_accuracy", 10, "Set typical GPS error in meters for Kalman smoothing")
+	geofenceEvents := fs.Int("geofence_events", 1000, "Set number of latest geofence events kept for the API")
+	geofenceWebhook := fs.String("geofence_webhook", "", "Set URL to post geofence events to, disabled if empty")
+	geofencesFile := fs.String("geofences", "", "Set JSON file of fences loaded on start and SIGHUP, fences added by the API are kept")
+	webhookAttempts := fs.Int("webhook_attempts", 5, "Set number of delivery attempts of a webhook event before it's a dead letter")
+	webhookBackoff := fs.Duration("webhook_backoff", time.Second, "Set delay before the first webhook retry, every next one waits twice as long")
+	webhookDeadLetters := fs.Int("webhook_dead_letters", 1000, "Set number of latest undelivered webhook events kept for the API")
+	averageSpeed := fs.Float64("average_speed", 8.3, "Set speed in m/s used for ETA of standing drivers")
+	routingEngine := fs.String("routing", "", "Set routing engine to rank nearest drivers by travel time: osrm or valhalla, disabled if empty")
+	routingURL := fs.String("routing_url", "", "Set routing engine URL")
+	rerankDepth := fs.Int("rerank_depth", 20, "Set number of nearest drivers ranked by travel time")
+	scoreWeights := fs.String("score_weights", "", "Set comma separated name:weight of distance, rating, idle and heading to rank nearest drivers by score, requests may have their own weights. Ranked by distance if empty")
+	nearestCacheTTL := fs.Duration("nearest_cache_ttl", 0, "Set how long drivers found by nearest queries are cached for queries of the same rounded point, count and filters, 0 disables it")
+	nearestCachePrecision := fs.Int("nearest_cache_precision", 4, "Set decimal places the point of cached nearest queries is rounded to, 4 is about 11 meters")
+	demandWindow := fs.Duration("demand_window", 0, "Set sliding window of nearest queries counted as demand of zones served at /zones, 0 disables it")
+	streamBuffer := fs.Int("stream_buffer", 256, "Set number of location updates buffered per websocket client")
+	swaggerUI := fs.Bool("swagger_ui", false, "Set to serve Swagger UI of /openapi.json at /docs")
+	metrics := fs.Bool("metrics", false, "Set to serve storage and history stats to Prometheus at /metrics without authorization")
+	apiKeysFile := fs.String("api_keys", "", "Set file of role:key API keys per line, roles are driver and dispatcher. NEARESTDOTS_API_KEYS may have comma separated keys instead")
+	jwtSecret := fs.String("jwt_secret", os.Getenv("NEARESTDOTS_JWT_SECRET"), "Set HS256 secret of JWT bearer tokens, disabled if empty")
+	rateLimit := fs.Float64("rate_limit", 0, "Set requests per second allowed per API key, token or IP, 0 disables it")
+	rateBurst := fs.Int("rate_burst", 20, "Set burst of requests allowed above the rate limit")
+	idempotencyTTL := fs.Duration("idempotency_ttl", 5*time.Minute, "Set how long responses of updates with an Idempotency-Key header are replayed to retries with the same key, 0 disables it")
+	idempotencyMax := fs.Int("idempotency_max", 100000, "Set number of responses kept for retries with the same Idempotency-Key, the oldest ones are dropped above it")
+	minUpdateInterval := fs.Duration("min_update_interval", 0, "Set minimal interval between locations of a driver, more frequent ones are rejected, 0 disables it")
+	h3Resolutions := fs.String("h3_resolutions", "7,8,9", "Set comma separated H3 resolutions drivers are aggregated by at /h3, the first one is the default, empty disables it")
+	maxSpeed := fs.Float64("max_speed", 0, "Set speed in m/s a driver can't exceed between locations, faster ones are teleports or spoofed GPS and flag the driver, 0 disables it")
+	rejectImpossible := fs.Bool("reject_impossible_speed", false, "Set to reject locations exceeding max_speed instead of flagging the driver")
+	tlsCert := fs.String("tls_cert", "", "Set PEM certificate file to serve HTTPS and gRPC over TLS, plain HTTP and gRPC if empty")
+	tlsKey := fs.String("tls_key", "", "Set PEM private key file of the TLS certificate")
+	tlsClientCA := fs.String("tls_client_ca", "", "Set PEM CA file to require client certificates signed by it, disabled if empty")
+	readTimeout := fs.Duration("read_timeout", 30*time.Second, "Set how long reading a request may take, 0 disables it")
+	writeTimeout := fs.Duration("write_timeout", 30*time.Second, "Set how long writing a response may take, streams are exempt, 0 disables it")
+	idleTimeout := fs.Duration("idle_timeout", 2*time.Minute, "Set how long an idle keep-alive connection is kept, 0 disables it")
+	maxHeaderBytes := fs.Int("max_header_bytes", 1<<20, "Set maximal size of request headers")
+	maxConnections := fs.Int("max_connections", 0, "Set maximal number of concurrent HTTP connections, 0 disables it")
+	shutdownTimeout := fs.Duration("shutdown_timeout", 15*time.Second, "Set how long in-flight requests are drained on SIGINT or SIGTERM")
+	otlpEndpoint := fs.String("otlp_endpoint", "", "Set host:port of OTLP/HTTP collector to export traces to, disabled if empty")
+	otlpInsecure := fs.Bool("otlp_insecure", false, "Set to export traces over plain HTTP")
+	traceRatio := fs.Float64("trace_ratio", 1, "Set ratio of sampled traces")
+	grpcAddr := fs.String("grpc_addr", "", "Set gRPC bind address, disabled if empty")
+	respAddr := fs.String("resp_addr", "", "Set bind address of the Redis protocol listener serving GEO commands, disabled if empty")
+	respPassword := fs.String("resp_password", "", "Set password clients of the Redis protocol listener must AUTH with, not required if empty")
+	kafkaBrokers := fs.String("kafka_brokers", "localhost:9092", "Set comma separated host:port of Kafka brokers")
+	kafkaTopic := fs.String("kafka_topic", "", "Set Kafka topic of location updates to consume, disabled if empty")
+	kafkaGroup := fs.String("kafka_group", "nearestdots", "Set Kafka consumer group")
+	mqttBroker := fs.String("mqtt_broker", "", "Set URL of MQTT broker to subscribe to location updates, e.g. tcp://localhost:1883, disabled if empty")
+	mqttTopic := fs.String("mqtt_topic", "drivers/+/location", "Set MQTT topic filter of location updates, its + level is the driver id")
+	mqttClientID := fs.String("mqtt_client_id", "nearestdots", "Set MQTT client id, the broker keeps the session of it between restarts")
+	mqttUsername := fs.String("mqtt_username", "", "Set MQTT username")
+	mqttPassword := fs.String("mqtt_password", "", "Set MQTT password")
+	mqttQoS := fs.Int("mqtt_qos", 1, "Set QoS of the MQTT subscription: 0 or 1")
+	udpAddr := fs.String("udp_addr", "", "Set bind address of the UDP listener of binary location fixes, disabled if empty")
+	natsURL := fs.String("nats_url", "", "Set NATS server URLs, comma separated, disabled if empty")
+	natsSubject := fs.String("nats_subject", "", "Set NATS subject of location updates to consume, disabled if empty")
+	natsQueue := fs.String("nats_queue", "nearestdots", "Set NATS queue group sharing location updates between replicas, every replica gets all updates if empty")
+	natsEvents := fs.String("nats_events", "", "Set NATS subject prefix to publish driver events of the in-memory storage to, e.g. drivers for drivers.moved, disabled if empty")
+	cdcSink := fs.String("cdc_sink", "", "Set where to publish the change feed of the in-memory storage: kafka or nats, disabled if empty")
+	cdcTopic := fs.String("cdc_topic", "nearestdots.changes", "Set Kafka topic or NATS subject of the change feed")
+	cdcBuffer := fs.Int("cdc_buffer", 10000, "Set how many changes may wait to be published, newer ones are dropped while it's full")
+	s3Endpoint := fs.String("s3_endpoint", "", "Set URL of S3-compatible service to upload snapshots to and restore missing ones from, credentials are taken from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, disabled if empty")
+	s3Region := fs.String("s3_region", "us-east-1", "Set region of the S3 bucket")
+	s3Bucket := fs.String("s3_bucket", "", "Set S3 bucket of snapshots")
+	s3Prefix := fs.String("s3_prefix", "nearestdots", "Set key prefix of snapshots in the S3 bucket")
+	s3Keep := fs.Int("s3_keep", 10, "Set how many latest snapshots of a namespace are kept in the S3 bucket")
+	clusterNodes := fs.String("cluster_nodes", "", "Set comma separated base URLs of APIs of all cluster nodes, drivers are partitioned across them by id, disabled if empty")
+	clusterSeeds := fs.String("cluster_seeds", "", "Set comma separated base URLs of cluster nodes to join through, nodes are discovered by gossip instead of cluster_nodes, disabled if empty")
+	gossipInterval := fs.Duration("cluster_gossip_interval", time.Second, "Set interval between gossip rounds, nodes silent for 5 rounds are failed")
+	clusterSelf := fs.String("cluster_self", "", "Set base URL of this node, one of cluster_nodes or reachable by seeds")
+	clusterKey := fs.String("cluster_key", "", "Set key cluster nodes authorize each other by")
+	clusterTimeout := fs.Duration("cluster_timeout", 2*time.Second, "Set how long a request to another cluster node may take")
+	replicationLog := fs.Int("replication_log", 0, "Set number of latest changes of the default namespace kept for replicas, they reload the snapshot if they fall behind, 0 disables replication")
+	replicaOf := fs.String("replica_of", "", "Set base URL of the primary API to follow, its default namespace is served read-only, disabled if empty")
+	replicaAPIKey := fs.String("replica_api_key", "", "Set dispatcher API key of the primary")
+	raftID := fs.String("raft_id", "", "Set id of this node in the Raft cluster replicating writes of the default namespace, disabled if empty")
+	raftAddr := fs.String("raft_addr", "", "Set host:port of this node other Raft nodes connect to, it's listened too")
+	raftDir := fs.String("raft_dir", "", "Set directory of the Raft log and snapshots")
+	raftPeers := fs.String("raft_peers", "", "Set comma separated id=host:port of all Raft nodes to bootstrap the cluster on the first start, including this one")
+	raftTimeout := fs.Duration("raft_timeout", 5*time.Second, "Set how long a write may wait to be committed by Raft")
+	simulation := simulationFlags(fs, "simulate_", 0)
+	postgisDSN := fs.String("postgis_dsn", "", "Set PostGIS connection string to store drivers in database instead of memory")
+	logLevel := fs.String("log_level", "info", "Set minimal level of logged messages: debug, info, warn or error")
+	configPath := fs.String("config", os.Getenv("NEARESTDOTS_CONFIG"), "Set YAML or TOML file of settings named as flags, NEARESTDOTS_<FLAG> variables override it and flags override both. SIGHUP reloads rate limits, webhooks, geofences and log level")
+	fs.Parse(args)
+
+	// NEARESTDOTS_API_KEYS has keys instead of the file of them
+	loader := config.New(fs, config.WithEnv("NEARESTDOTS_", os.Environ()), config.WithoutEnv("api_keys"))
+	if err := loader.Load(*configPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	validate := func() error {
+		var problems config.Problems
+		problems.Require(strings.Trim(*bindAddr, ", ") != "", "bind_addr must not be empty")
+		problems.Require(*size > 0, "lru_size must be positive")
+		problems.Require(*historyMaxAge >= 0, "history_max_age must not be negative")
+		problems.Require(*historyRecent >= 0, "history_recent must not be negative")
+		problems.Require(*historyEvery > 0, "history_every must be greater than 0")
+		problems.Require(*snapshotInterval > 0, "snapshot_interval must be positive")
+		problems.Require(*ttl >= 0, "ttl must not be negative")
+		problems.Require(*janitorInterval > 0, "janitor_interval must be positive")
+		problems.Require(*indexType == "rtree" || *indexType == "geohash" || *indexType == "s2", "index must be rtree, geohash or s2, not %q", *indexType)
+		problems.Require(*geohashPrecision >= 1 && *geohashPrecision <= 12, "geohash_precision must be from 1 to 12")
+		problems.Require(*s2Level >= 0 && *s2Level <= 30, "s2_level must be from 0 to 30")
+		problems.Require(*rtreeMinChildren > 0, "rtree_min_children must be positive")
+		problems.Require(*rtreeMaxChildren >= 2**rtreeMinChildren, "rtree_max_children must be at least twice rtree_min_children")
+		problems.Require(*rtreeTolerance >= 1, "rtree_tolerance must be at least 1 meter")
+		problems.Require(*readSnapshotInterval >= 0, "read_snapshot_interval must not be negative")
+		problems.Require(*shards > 0, "shards must be positive")
+		problems.Require(*regionsFile == "" || *shards == 1, "regions can't be used with shards")
+		problems.Require(*regionsFile == "" || *postgisDSN == "", "regions can't be used with postgis_dsn")
+		problems.Require(*regionsFile == "" || *replicaOf == "", "regions can't be used with replica_of, replicas keep drivers of the primary")
+		problems.Require(*nearestCacheTTL >= 0, "nearest_cache_ttl must not be negative")
+		problems.Require(*maxSpeed >= 0, "max_speed must not be negative")
+		problems.Require(*nearestCachePrecision >= 0 && *nearestCachePrecision <= 8, "nearest_cache_precision must be from 0 to 8")
+		problems.Require(*demandWindow >= 0, "demand_window must not be negative")
+		problems.Require(*archiveDir == "" || (*archiveRetention > 0 && *archiveWindow > 0), "archive_retention and archive_window must be positive")
+		problems.Require(*archiveDir == "" || *postgisDSN == "", "archive_dir can't be used with postgis_dsn")
+		problems.Require(*streamBuffer > 0, "stream_buffer must be positive")
+		problems.Require(*maxNamespaces >= 0, "max_namespaces must not be negative")
+		problems.Require(*rateLimit >= 0, "rate_limit must not be negative")
+		problems.Require(*rateLimit == 0 || *rateBurst > 0, "rate_burst must be positive")
+		problems.Require(*idempotencyTTL >= 0, "idempotency_ttl must not be negative")
+		problems.Require(*idempotencyMax > 0, "idempotency_max must be positive")
+		problems.Require(*webhookAttempts > 0, "webhook_attempts must be positive")
+		problems.Require((*tlsCert == "") == (*tlsKey == ""), "tls_cert and tls_key must be set together")
+		problems.Require(*tlsClientCA == "" || *tlsCert != "", "tls_client_ca needs tls_cert")
+		problems.Require(*routingEngine == "" || *routingURL != "", "routing %s needs routing_url", *routingEngine)
+		problems.Require(*kafkaTopic == "" || *kafkaBrokers != "", "kafka_topic needs kafka_brokers")
+		problems.Require(*mqttQoS == 0 || *mqttQoS == 1, "mqtt_qos must be 0 or 1")
+		problems.Require(*natsSubject == "" || *natsURL != "", "nats_subject needs nats_url")
+		problems.Require(*natsEvents == "" || *natsURL != "", "nats_events needs nats_url")
+		problems.Require(*cdcSink == "" || *cdcSink == "kafka" || *cdcSink == "nats", "cdc_sink must be kafka or nats, not %q", *cdcSink)
+		problems.Require(*cdcSink != "nats" || *natsURL != "", "cdc_sink nats needs nats_url")
+		problems.Require(*s3Endpoint == "" || *s3Bucket != "", "s3_endpoint needs s3_bucket")
+		problems.Require(*s3Endpoint == "" || *snapshotPath != "", "s3_endpoint needs snapshot_path")
+		problems.Require(*clusterNodes == "" || *clusterSeeds == "", "cluster_nodes can't be used with cluster_seeds")
+		problems.Require(*clusterSeeds == "" || *gossipInterval > 0, "cluster_gossip_interval must be positive")
+		if *clusterSeeds != "" {
+			*clusterNodes = *clusterSeeds
+		}
+		problems.Require(*clusterNodes == "" || *clusterSelf != "", "cluster_nodes needs cluster_self")
+		problems.Require(*clusterNodes == "" || *clusterKey != "", "cluster_nodes needs cluster_key")
+		problems.Require(*clusterNodes == "" || *postgisDSN == "", "cluster_nodes can't be used with postgis_dsn, the database is shared already")
+		problems.Require(*replicationLog >= 0, "replication_log must not be negative")
+		problems.Require(*replicationLog == 0 || *postgisDSN == "", "replication_log can't be used with postgis_dsn, the database is shared already")
+		problems.Require(*replicationLog == 0 || *clusterNodes == "", "replication_log can't be used with cluster_nodes")
+		problems.Require(*replicationLog == 0 || *replicaOf == "", "replication_log can't be used with replica_of, replicas of replicas are not supported")
+		problems.Require(*replicaOf == "" || *postgisDSN == "", "replica_of can't be used with postgis_dsn")
+		problems.Require(*replicaOf == "" || *clusterNodes == "", "replica_of can't be used with cluster_nodes")
+		problems.Require(*raftID == "" || *raftAddr != "", "raft_id needs raft_addr")
+		problems.Require(*raftID == "" || *raftDir != "", "raft_id needs raft_dir")
+		_, err := consensus.ParsePeers(*raftPeers)
+		problems.Require(err == nil, "raft_peers must be comma separated id=host:port")
+		problems.Require(*raftID == "" || *postgisDSN == "", "raft_id can't be used with postgis_dsn, the database is shared already")
+		problems.Require(*raftID == "" || *clusterNodes == "", "raft_id can't be used with cluster_nodes")
+		problems.Require(*raftID == "" || *replicaOf == "", "raft_id can't be used with replica_of")
+		_, err = storage.ParseWeights(*scoreWeights)
+		problems.Require(err == nil, "score_weights must be comma separated name:weight of distance, rating, idle and heading")
+		_, err = storage.ParseH3Resolutions(*h3Resolutions)
+		problems.Require(err == nil, "h3_resolutions must be comma separated integers from 0 to %d", storage.MaxH3Resolution)
+		_, err = zapcore.ParseLevel(*logLevel)
+		problems.Require(err == nil, "log_level must be debug, info, warn or error, not %q", *logLevel)
+		return problems.Err()
+	}
+	if err := validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	// the level is changed on reload
+	level := zap.NewAtomicLevel()
+	level.UnmarshalText([]byte(*logLevel))
+	logger, err := newLogger(level)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
+
+	apiOpts := []api.Option{
+		api.WithAverageSpeed(*averageSpeed),
+		api.WithTimeouts(*readTimeout, *writeTimeout, *idleTimeout),
+		api.WithMaxHeaderBytes(*maxHeaderBytes),
+	}
+	if *maxConnections > 0 {
+		apiOpts = append(apiOpts, api.WithMaxConnections(*maxConnections))
+	}
+	keys, err := apiKeys(*apiKeysFile, os.Getenv("NEARESTDOTS_API_KEYS"))
+	if err != nil {
+		zap.L().Fatal("could not load API keys", zap.Error(err))
+	}
+	if keys != nil {
+		apiOpts = append(apiOpts, api.WithAPIKeys(keys))
+	}
+	if *jwtSecret != "" {
+		apiOpts = append(apiOpts, api.WithJWT([]byte(*jwtSecret)))
+	}
+	// gRPC checks the same keys and tokens, UDP and MQTT ingest has no credentials and is refused with them
+	auth := api.Credentials{Keys: keys}
+	if *jwtSecret != "" {
+		auth.JWTSecret = []byte(*jwtSecret)
+	}
+	if auth.Enabled() && (*udpAddr != "" || *mqttBroker != "") {
+		zap.L().Fatal("udp_addr and mqtt_broker can't be used with API keys or jwt_secret, their locations are not authenticated")
+	}
+	apiOpts = append(apiOpts, api.WithNearestCache(*nearestCacheTTL, *nearestCachePrecision))
+	apiOpts = append(apiOpts, api.WithDemand(*demandWindow))
+	resolutions, _ := storage.ParseH3Resolutions(*h3Resolutions)
+	apiOpts = append(apiOpts, api.WithH3Resolutions(resolutions))
+	// the limiter is set even without limit, so reload may enable it
+	apiOpts = append(apiOpts, api.WithRateLimit(*rateLimit, *rateBurst))
+	apiOpts = append(apiOpts, api.WithIdempotency(*idempotencyTTL, *idempotencyMax))
+	// gRPC is served with the same certificates
+	var tlsConfig *tls.Config
+	if *tlsCert != "" {
+		tlsConfig, err = api.LoadTLSConfig(*tlsCert, *tlsKey, *tlsClientCA)
+		if err != nil {
+			zap.L().Fatal("could not load TLS certificates", zap.Error(err))
+		}
+		apiOpts = append(apiOpts, api.WithTLS(tlsConfig))
+	}
+	if *otlpEndpoint != "" {
+		provider, err := tracing.Start(context.Background(), tracing.Config{
+			Endpoint: *otlpEndpoint,
+			Insecure: *otlpInsecure,
+			Ratio:    *traceRatio,
+			Service:  "nearestdots",
+		})
+		if err != nil {
+			zap.L().Fatal("could not start tracing", zap.Error(err))
+		}
+		defer flushSpans(provider, *shutdownTimeout)
+		apiOpts = append(apiOpts, api.WithTracer(provider.Tracer()))
+	}
+	if *swaggerUI {
+		apiOpts = append(apiOpts, api.WithSwaggerUI())
+	}
+	if *metrics {
+		apiOpts = append(apiOpts, api.WithMetrics())
+	}
+	if *routingEngine != "" {
+		router, err := routing.New(*routingEngine, *routingURL)
+		if err != nil {
+			zap.L().Fatal("could not create router", zap.Error(err))
+		}
+		apiOpts = append(apiOpts, api.WithRouter(router, *rerankDepth))
+	}
+	// weights are validated with other flags
+	weights, _ := storage.ParseWeights(*scoreWeights)
+	apiOpts = append(apiOpts, api.WithScoreWeights(weights))
+
+	var natsConn *nats.Conn
+	if *natsURL != "" {
+		natsConn, err = nats.Connect(*natsURL, nats.Name("nearestdots"), nats.MaxReconnects(-1))
+		if err != nil {
+			zap.L().Fatal("could not connect to NATS", zap.Error(err))
+		}
+		defer natsConn.Close()
+	}
+
+	// consumers feed the default namespace, they stop before the last snapshot is saved
+	startConsumers := func(database storage.Storage) (stop func()) {
+		var stops []func()
+		if *kafkaTopic != "" {
+			k := ingest.NewKafka(ingest.KafkaConfig{
+				Brokers: strings.Split(*kafkaBrokers, ","),
+				Topic:   *kafkaTopic,
+				Group:   *kafkaGroup,
+			}, database)
+			stops = append(stops, consume("kafka", k))
+		}
+		if *mqttBroker != "" {
+			m := ingest.NewMQTT(ingest.MQTTConfig{
+				Broker:   *mqttBroker,
+				Topic:    *mqttTopic,
+				ClientID: *mqttClientID,
+				Username: *mqttUsername,
+				Password: *mqttPassword,
+				QoS:      byte(*mqttQoS),
+			}, database)
+			stops = append(stops, consume("mqtt", m))
+		}
+		if *udpAddr != "" {
+			u, err := ingest.ListenUDP(*udpAddr, database)
+			if err != nil {
+				zap.L().Fatal("could not listen UDP", zap.Error(err))
+			}
+			// it's called before serve, so stats of the API report the packet counters
+			apiOpts = append(apiOpts, api.WithUDP(u))
+			stops = append(stops, consume("udp", u))
+		}
+		if natsConn != nil && *natsSubject != "" {
+			stops = append(stops, consume("nats", ingest.NewNATS(natsConn, *natsSubject, *natsQueue, database)))
+		}
+		config, err := simulation()
+		if err != nil {
+			zap.L().Fatal("invalid simulation", zap.Error(err))
+		}
+		if config.Drivers > 0 {
+			sim, err := simulate.New(config, simulate.NewStorage(database))
+			if err != nil {
+				zap.L().Fatal("could not create simulation", zap.Error(err))
+			}
+			stops = append(stops, consume("simulation", sim))
+		}
+		return func() {
+			for _, stop := range stops {
+				stop()
+			}
+		}
+	}
+
+	// fences and webhooks of the in-memory storage are reloaded with the rest of the safe settings
+	var (
+		fences     *geofence.Manager
+		target     *geofence.Target
+		hooks      *webhook.Manager
+		fileFences []string
+	)
+	loadFences := func() error {
+		var loaded []geofence.Fence
+		if *geofencesFile != "" {
+			var err error
+			if loaded, err = geofence.ReadFile(*geofencesFile); err != nil {
+				return err
+			}
+		}
+		if err := fences.Replace(fileFences, loaded); err != nil {
+			return err
+		}
+		fileFences = fileFences[:0]
+		for _, f := range loaded {
+			fileFences = append(fileFences, f.Name)
+		}
+		return nil
+	}
+	reloadable := map[string]bool{
+		"rate_limit": true, "rate_burst": true, "log_level": true, "geofences": true,
+		"geofence_webhook": true, "webhook_attempts": true, "webhook_backoff": true,
+	}
+	reload := func(a *api.API) {
+		changed, err := loader.Reload(*configPath, validate)
+		if err != nil {
+			zap.L().Error("could not reload configuration", zap.Error(err))
+			return
+		}
+		var restart []string
+		for _, name := range changed {
+			if !reloadable[name] {
+				restart = append(restart, name)
+			}
+		}
+		if len(restart) > 0 {
+			zap.L().Warn("changed settings take effect after restart", zap.Strings("settings", restart))
+		}
+		level.UnmarshalText([]byte(*logLevel))
+		a.SetRateLimit(*rateLimit, *rateBurst)
+		if fences != nil {
+			target.SetURL(*geofenceWebhook)
+			hooks.SetRetry(*webhookAttempts, *webhookBackoff)
+			// the file may change without its name
+			if err := loadFences(); err != nil {
+				zap.L().Error("could not reload geofences", zap.Error(err))
+			}
+		}
+		zap.L().Info("configuration reloaded", zap.Strings("changed", changed))
+	}
+
+	if *postgisDSN != "" {
+		database, err := postgis.New(*postgisDSN, *size, *ttl)
+		if err != nil {
+			zap.L().Fatal("could not connect to PostGIS", zap.Error(err))
+		}
+		defer database.Close()
+		defer startConsumers(database)()
+		var g *grpcServer
+		if *grpcAddr != "" {
+			g = serveGRPC(*grpcAddr, rpc.NewServer(database, rpc.WithAverageSpeed(*averageSpeed), rpc.WithAuth(auth)), tlsConfig)
+		}
+		namespaces := storage.NewManager(database, nil)
+		var r *resp.Server
+		if *respAddr != "" {
+			r = serveRESP(*respAddr, resp.NewServer(namespaces, resp.WithPassword(*respPassword)))
+		}
+		serve(*bindAddr, namespaces, nil, *janitorInterval, *shutdownTimeout, g, r, reload, apiOpts...)
+		return 0
+	}
+
+	// read snapshots are rtrees whatever the index is
+	indexOpts := []storage.Option{storage.WithRtree(*rtreeMinChildren, *rtreeMaxChildren, *rtreeTolerance)}
+	switch *indexType {
+	case "rtree":
+	case "geohash":
+		indexOpts = append(indexOpts, storage.WithGeohashIndex(*geohashPrecision))
+	case "s2":
+		indexOpts = append(indexOpts, storage.WithS2Index(*s2Level))
+	default:
+		zap.L().Fatal("unknown index type", zap.String("index", *indexType))
+	}
+	if *readSnapshotInterval > 0 {
+		indexOpts = append(indexOpts, storage.WithReadSnapshots(*readSnapshotInterval))
+	}
+
+	// replicas serve the default namespace of the primary read-only, the primary expires, smooths and throttles
+	// drivers and notifies geofences and webhooks, only websocket clients of the replica are streamed
+	if *replicaOf != "" {
+		hub := stream.NewHub(*streamBuffer)
+		apiOpts = append(apiOpts, api.WithStream(hub))
+		var local replica.Snapshotter
+		if *shards > 1 {
+			local = storage.NewSharded(*shards, *size, append(indexOpts, storage.WithObserver(hub))...)
+		} else {
+			local = storage.New(*size, append(indexOpts, storage.WithObserver(hub))...)
+		}
+		follower := replica.New(client.New(*replicaOf, client.WithAPIKey(*replicaAPIKey)), local)
+		defer consume("replication", follower)()
+		database := replica.NewReadOnly(follower)
+		var g *grpcServer
+		if *grpcAddr != "" {
+			g = serveGRPC(*grpcAddr, rpc.NewServer(database, rpc.WithStream(hub), rpc.WithAverageSpeed(*averageSpeed), rpc.WithAuth(auth)), tlsConfig)
+		}
+		namespaces := storage.NewManager(database, nil)
+		var r *resp.Server
+		if *respAddr != "" {
+			r = serveRESP(*respAddr, resp.NewServer(namespaces, resp.WithPassword(*respPassword)))
+		}
+		serve(*bindAddr, namespaces, nil, *janitorInterval, *shutdownTimeout, g, r, reload, apiOpts...)
+		return 0
+	}
+
+	// geofences observe the in-memory storage only
+	fences = geofence.New(*geofenceEvents)
+	defer fences.Close()
+	target = geofence.NewTarget(*geofenceWebhook)
+	fences.Subscribe(target.Handle)
+	if err := loadFences(); err != nil {
+		zap.L().Fatal("could not load geofences", zap.Error(err))
+	}
+	// endpoints are registered with the API
+	hooks = webhook.New(*webhookAttempts, *webhookBackoff, *webhookDeadLetters)
+	defer hooks.Close()
+	fences.Subscribe(hooks.FenceEvent)
+	apiOpts = append(apiOpts, api.WithWebhooks(hooks))
+
+	opts := append([]storage.Option{
+		storage.WithTTL(*ttl),
+		storage.WithKalmanFilter(*smoothingNoise, *gpsAccuracy),
+		storage.WithHistoryMaxAge(*historyMaxAge),
+		storage.WithHistoryDownsampling(*historyRecent, *historyEvery),
+		storage.WithMinUpdateInterval(*minUpdateInterval),
+		storage.WithMaxSpeed(*maxSpeed, *rejectImpossible),
+	}, indexOpts...)
+
+	var regions []storage.Region
+	if *regionsFile != "" {
+		if regions, err = storage.ReadRegions(*regionsFile); err != nil {
+			zap.L().Fatal("could not load regions", zap.Error(err))
+		}
+	}
+	open := func(snapshotPath, walDir string, opts ...storage.Option) (storage.Storage, error) {
+		var database persistentStorage
+		if regions != nil {
+			var err error
+			if database, err = storage.NewRegions(regions, *size, opts...); err != nil {
+				return nil, err
+			}
+		} else if *shards > 1 {
+			database = storage.NewSharded(*shards, *size, opts...)
+		} else {
+			database = storage.New(*size, opts...)
+		}
+		if snapshotPath != "" {
+			err := database.Load(snapshotPath)
+			if err != nil && !os.IsNotExist(errors.Cause(err)) {
+				return nil, errors.Wrap(err, "could not restore snapshot")
+			}
+		}
+		if walDir != "" {
+			if err := database.OpenWAL(walDir); err != nil {
+				return nil, errors.Wrap(err, "could not open WAL")
+			}
+		}
+		return database, nil
+	}
+
+	// websocket clients are streamed the in-memory storage only
+	hub := stream.NewHub(*streamBuffer)
+	apiOpts = append(apiOpts, api.WithStream(hub))
+
+	// only the default namespace notifies geofences, streams, webhooks and NATS, driver ids of namespaces may clash
+	defaultOpts := append(opts, storage.WithObserver(fences), storage.WithObserver(hub), storage.WithObserver(hooks))
+	if natsConn != nil && *natsEvents != "" {
+		defaultOpts = append(defaultOpts, storage.WithObserver(ingest.NewNATSPublisher(natsConn, *natsEvents)))
+	}
+	switch *cdcSink {
+	case "":
+	case "kafka":
+		feed := cdc.New(cdc.NewKafkaSink(strings.Split(*kafkaBrokers, ","), *cdcTopic), *cdcBuffer)
+		defer closeFeed(feed)
+		defaultOpts = append(defaultOpts, storage.WithObserver(feed))
+	case "nats":
+		if natsConn == nil {
+			zap.L().Fatal("NATS change feed needs nats_url")
+		}
+		feed := cdc.New(cdc.NewNATSSink(natsConn, *cdcTopic), *cdcBuffer)
+		defer closeFeed(feed)
+		defaultOpts = append(defaultOpts, storage.WithObserver(feed))
+	default:
+		zap.L().Fatal("unknown change feed sink", zap.String("sink", *cdcSink))
+	}
+	if *archiveDir != "" {
+		ar, err := archive.Open(*archiveDir, archive.Options{Retention: *archiveRetention, Window: *archiveWindow})
+		if err != nil {
+			zap.L().Fatal("could not open archive", zap.Error(err))
+		}
+		defer closeArchive(ar)
+		defaultOpts = append(defaultOpts, storage.WithObserver(ar))
+		apiOpts = append(apiOpts, api.WithArchive(ar))
+	}
+	if *replicationLog > 0 {
+		log := replication.NewLog(*replicationLog)
+		defaultOpts = append(defaultOpts, storage.WithObserver(log))
+		apiOpts = append(apiOpts, api.WithReplication(log))
+	}
+	var backups *backup.Snapshots
+	if *s3Endpoint != "" {
+		if *snapshotPath == "" {
+			zap.L().Fatal("S3 snapshots need snapshot_path")
+		}
+		backups = backup.NewSnapshots(backup.NewS3(backup.S3Config{
+			Endpoint:     *s3Endpoint,
+			Region:       *s3Region,
+			Bucket:       *s3Bucket,
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}), *s3Prefix, *s3Keep)
+		if err := restoreSnapshots(backups, *snapshotPath); err != nil {
+			zap.L().Fatal("could not restore snapshots from S3", zap.Error(err))
+		}
+	}
+	database, err := open(*snapshotPath, *walDir, defaultOpts...)
+	if err != nil {
+		zap.L().Fatal("could not open storage", zap.Error(err))
+	}
+	namespaces := storage.NewManager(database, func(namespace string) (storage.Storage, error) {
+		return open(namespaceSnapshot(*snapshotPath, namespace), namespaceWAL(*walDir, namespace), opts...)
+	}, storage.WithMaxNamespaces(*maxNamespaces))
+	defer closeAll(namespaces)
+	for _, namespace := range storedNamespaces(*snapshotPath, *walDir) {
+		if _, err := namespaces.Namespace(namespace); err != nil {
+			zap.L().Fatal("could not open namespace", zap.String("namespace", namespace), zap.Error(err))
+		}
+	}
+	if *snapshotPath != "" {
+		// the last snapshot is saved after requests are drained, before the WAL is closed
+		stop := saveSnapshots(namespaces, *snapshotPath, *snapshotInterval, backups)
+		defer stop()
+	}
+
+	// snapshots, WAL, geofences, streams and webhooks are of drivers of the local storages,
+	// the API, consumers, gRPC and RESP use storages of the cluster or the Raft node
+	served := namespaces
+
+	// writes of the default namespace are committed by Raft and applied to the local storage of all nodes
+	if *raftID != "" {
+		peers, _ := consensus.ParsePeers(*raftPeers)
+		node, err := consensus.New(consensus.Config{
+			ID:           *raftID,
+			Addr:         *raftAddr,
+			Dir:          *raftDir,
+			Peers:        peers,
+			ApplyTimeout: *raftTimeout,
+			TTL:          *ttl,
+		}, database.(consensus.Local))
+		if err != nil {
+			zap.L().Fatal("could not start Raft", zap.Error(err))
+		}
+		defer closeNode(node)
+		served = storage.NewManager(node, func(namespace string) (storage.Storage, error) {
+			return namespaces.Namespace(namespace)
+		})
+		// namespaces restored on start are expired by the janitor of the served manager
+		for _, namespace := range namespaces.Namespaces() {
+			if _, err := served.Namespace(namespace); err != nil {
+				zap.L().Fatal("could not open namespace", zap.String("namespace", namespace), zap.Error(err))
+			}
+		}
+		database = node
+	}
+	if *clusterNodes != "" {
+		clusterOpts := []cluster.Option{cluster.WithTimeout(*clusterTimeout)}
+		if *clusterSeeds != "" {
+			clusterOpts = append(clusterOpts, cluster.WithGossip(*gossipInterval))
+		}
+		c, err := cluster.New(*clusterSelf, strings.Split(*clusterNodes, ","), *clusterKey, namespaces, clusterOpts...)
+		if err != nil {
+			zap.L().Fatal("could not join cluster", zap.Error(err))
+		}
+		defer c.Close()
+		apiOpts = append(apiOpts, api.WithCluster(c))
+		served = c.Namespaces()
+		if database, err = served.Namespace(""); err != nil {
+			zap.L().Fatal("could not open cluster storage", zap.Error(err))
+		}
+	}
+	defer startConsumers(database)()
+
+	var g *grpcServer
+	if *grpcAddr != "" {
+		g = serveGRPC(*grpcAddr, rpc.NewServer(database, rpc.WithStream(hub), rpc.WithAverageSpeed(*averageSpeed), rpc.WithAuth(auth)), tlsConfig)
+	}
+	var r *resp.Server
+	if *respAddr != "" {
+		r = serveRESP(*respAddr, resp.NewServer(served, resp.WithPassword(*respPassword)))
+	}
+	serve(*bindAddr, served, fences, *janitorInterval, *shutdownTimeout, g, r, reload, apiOpts...)
+	return 0
+}
+
+// serve serves the API until SIGINT or SIGTERM, then drains HTTP and gRPC requests for up to timeout,
+// closes RESP connections and stops the janitor. A second signal kills the process. SIGHUP calls reload.
+func serve(bindAddr string, namespaces *storage.Manager, fences *geofence.Manager, janitorInterval, timeout time.Duration, g *grpcServer, r *resp.Server, reload func(*api.API), opts ...api.Option) {
+	janitor := storage.StartJanitor(namespaces, janitorInterval)
+	defer janitor.Stop()
+
+	a := api.New(bindAddr, namespaces, fences, append(opts, api.WithJanitor(janitor))...)
+	a.Start()
+
+	stopped := make(chan struct{})
+	go func() {
+		a.WaitStop()
+		close(stopped)
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+wait:
+	for {
+		select {
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				zap.L().Info("shutting down", zap.Stringer("signal", sig))
+				break wait
+			}
+			reload(a)
+		case <-stopped:
+			return
+		}
+	}
+	signal.Stop(signals)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := a.Shutdown(ctx); err != nil {
+		zap.L().Warn("could not drain HTTP requests", zap.Error(err))
+	}
+	if g != nil {
+		g.stop(ctx)
+	}
+	if r != nil {
+		if err := r.Close(); err != nil {
+			zap.L().Warn("could not close RESP listener", zap.Error(err))
+		}
+	}
+}
+
+// closeNode leaves the Raft cluster
+func closeNode(node *consensus.Node) {
+	if err := node.Close(); err != nil {
+		zap.L().Warn("could not close Raft node", zap.Error(err))
+	}
+}
+
+// closeArchive flushes archived locations
+func closeArchive(ar *archive.Archive) {
+	if err := ar.Close(); err != nil {
+		zap.L().Warn("could not close archive", zap.Error(err))
+	}
+}
+
+// closeFeed publishes queued changes of the feed
+func closeFeed(feed *cdc.Feed) {
+	if err := feed.Close(); err != nil {
+		zap.L().Warn("could not close change feed", zap.Error(err))
+	}
+}
+
+// consumer feeds the storage until ctx is done
+type consumer interface {
+	Run(ctx context.Context) error
+	Close() error
+}
+
+// consume runs the consumer until the returned stop is called
+func consume(name string, c consumer) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := c.Run(ctx); err != nil {
+			zap.L().Error("consumer stopped", zap.String("consumer", name), zap.Error(err))
+		}
+	}()
+	zap.L().Info("consuming location updates", zap.String("consumer", name))
+	return func() {
+		cancel()
+		<-done
+		if err := c.Close(); err != nil {
+			zap.L().Warn("could not close consumer", zap.String("consumer", name), zap.Error(err))
+		}
+	}
+}
+
+// newLogger returns JSON logger of messages of the level and above, the level may be changed later
+func newLogger(level zap.AtomicLevel) (*zap.Logger, error) {
+	config := zap.NewProductionConfig()
+	config.Level = level
+	return config.Build()
+}
+
+// flushSpans exports buffered spans before exit
+func flushSpans(provider *tracing.Provider, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := provider.Shutdown(ctx); err != nil {
+		zap.L().Warn("could not export spans", zap.Error(err))
+	}
+}
+
+// apiKeys loads API keys from the file or the environment variable, nil keys disable authentication
+func apiKeys(path, env string) (api.Keys, error) {
+	switch {
+	case path != "":
+		return api.LoadKeys(path)
+	case env != "":
+		return api.ParseKeys(env)
+	}
+	return nil, nil
+}
+
+// grpcServer is a running gRPC server of the API
+type grpcServer struct {
+	server *grpc.Server
+	api    *rpc.Server
+}
+
+// serveGRPC serves the default namespace over gRPC in background, over TLS if config is not nil
+func serveGRPC(addr string, s *rpc.Server, config *tls.Config) *grpcServer {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		zap.L().Fatal("could not listen gRPC", zap.Error(err))
+	}
+	opts := s.ServerOptions()
+	if config != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	g := grpc.NewServer(opts...)
+	s.Register(g)
+	go func() {
+		if err := g.Serve(l); err != nil {
+			zap.L().Error("gRPC server stopped", zap.Error(err))
+		}
+	}()
+	return &grpcServer{server: g, api: s}
+}
+
+// serveRESP serves the Redis protocol in background
+func serveRESP(addr string, s *resp.Server) *resp.Server {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		zap.L().Fatal("could not listen RESP", zap.Error(err))
+	}
+	go func() {
+		if err := s.Serve(l); err != nil {
+			zap.L().Error("RESP server stopped", zap.Error(err))
+		}
+	}()
+	return s
+}
+
+// stop ends update streams and waits for running calls until ctx is done, remaining calls are cancelled then
+func (g *grpcServer) stop(ctx context.Context) {
+	g.api.Shutdown()
+	done := make(chan struct{})
+	go func() {
+		g.server.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		zap.L().Warn("could not drain gRPC calls", zap.Error(ctx.Err()))
+		g.server.Stop()
+	}
+}
+
+// persistentStorage is an in-memory storage with snapshots and WAL
+type persistentStorage interface {
+	storage.Storage
+	Save(path string) error
+	Load(path string) error
+	OpenWAL(dir string) error
+	Close() error
+}
+
+// namespaceSnapshot returns snapshot path of the namespace, empty if snapshots are disabled
+func namespaceSnapshot(path, namespace string) string {
+	if path == "" || namespace == "" {
+		return path
+	}
+	return path + ".ns." + namespace
+}
+
+// namespaceWAL returns WAL directory of the namespace, empty if the WAL is disabled
+func namespaceWAL(dir, namespace string) string {
+	if dir == "" || namespace == "" {
+		return dir
+	}
+	return filepath.Join(dir, "namespaces", namespace)
+}
+
+// storedNamespaces returns namespaces having a snapshot or a WAL
+func storedNamespaces(snapshotPath, walDir string) []string {
+	found := make(map[string]bool)
+	if snapshotPath != "" {
+		paths, _ := filepath.Glob(namespaceSnapshot(snapshotPath, "*"))
+		for _, p := range paths {
+			found[strings.TrimPrefix(p, snapshotPath+".ns.")] = true
+		}
+	}
+	if walDir != "" {
+		dirs, _ := ioutil.ReadDir(filepath.Join(walDir, "namespaces"))
+		for _, d := range dirs {
+			if d.IsDir() {
+				found[d.Name()] = true
+			}
+		}
+	}
+
+	var namespaces []string
+	for namespace := range found {
+		// skips temporary snapshot files
+		if storage.ValidNamespace(namespace) {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	return namespaces
+}
+
+func closeAll(namespaces *storage.Manager) {
+	namespaces.Each(func(namespace string, s storage.Storage) {
+		if err := s.(persistentStorage).Close(); err != nil {
+			zap.L().Error("could not close namespace", zap.String("namespace", namespace), zap.Error(err))
+		}
+	})
+}
+
+// backupTimeout limits upload of a snapshot and restore of all snapshots
+const backupTimeout = 5 * time.Minute
+
+// saveSnapshots saves snapshots of all namespaces every interval in background and uploads them
+// if backups is not nil, stop saves the last snapshots and waits until they are written and uploaded
+func saveSnapshots(namespaces *storage.Manager, path string, interval time.Duration, backups *backup.Snapshots) (stop func()) {
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				saveAll(namespaces, path, backups)
+			case <-quit:
+				saveAll(namespaces, path, backups)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		<-done
+	}
+}
+
+func saveAll(namespaces *storage.Manager, path string, backups *backup.Snapshots) {
+	namespaces.Each(func(namespace string, s storage.Storage) {
+		snapshot := namespaceSnapshot(path, namespace)
+		if err := s.(persistentStorage).Save(snapshot); err != nil {
+			zap.L().Error("could not save snapshot", zap.String("namespace", namespace), zap.Error(err))
+			return
+		}
+		if backups == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), backupTimeout)
+		defer cancel()
+		if err := backups.Upload(ctx, namespace, snapshot); err != nil {
+			zap.L().Error("could not upload snapshot", zap.String("namespace", namespace), zap.Error(err))
+		}
+	})
+}
+
+// restoreSnapshots downloads the latest snapshots of namespaces which have no local snapshot,
+// so a new instance starts with drivers of the last saved state
+func restoreSnapshots(backups *backup.Snapshots, path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), backupTimeout)
+	defer cancel()
+	namespaces, err := backups.Namespaces(ctx)
+	if err != nil {
+		return err
+	}
+	for _, namespace := range append([]string{""}, namespaces...) {
+		if namespace != "" && !storage.ValidNamespace(namespace) {
+			continue
+		}
+		snapshot := namespaceSnapshot(path, namespace)
+		if _, err := os.Stat(snapshot); err == nil {
+			continue
+		}
+		ok, err := backups.Restore(ctx, namespace, snapshot)
+		if err != nil {
+			return errors.Wrapf(err, "could not restore namespace %q", namespace)
+		}
+		if ok {
+			zap.L().Info("restored snapshot from S3", zap.String("namespace", namespace))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kdrake/nearestdots/simulate"
+	"github.com/pkg/errors"
+)
+
+// simulationFlags defines flags of a simulation with the prefix, the returned func reads them after parsing
+func simulationFlags(fs *flag.FlagSet, prefix string, drivers int) func() (simulate.Config, error) {
+	n := fs.Int(prefix+"drivers", drivers, "Set number of simulated drivers, disabled if 0")
+	firstID := fs.Int(prefix+"first_id", 1000000, "Set id of the first simulated driver, the others follow it")
+	center := fs.String(prefix+"center", "42.874722,74.612222", "Set lat,lon of the center of the area of random walks")
+	radius := fs.Float64(prefix+"radius", 5000, "Set radius in meters of the area of random walks")
+	speed := fs.Float64(prefix+"speed", 10, "Set average speed of simulated drivers in m/s")
+	interval := fs.Duration(prefix+"interval", time.Second, "Set interval between locations of simulated drivers")
+	gpx := fs.String(prefix+"gpx", "", "Set GPX file of tracks simulated drivers follow instead of random walks")
+	seed := fs.Int64(prefix+"seed", 0, "Set seed of the random walks, so simulations are repeatable")
+	return func() (simulate.Config, error) {
+		config := simulate.Config{
+			Drivers:  *n,
+			FirstID:  *firstID,
+			Radius:   *radius,
+			Speed:    *speed,
+			Interval: *interval,
+			Seed:     *seed,
+		}
+		if config.Drivers <= 0 {
+			return config, nil
+		}
+		var err error
+		if config.Center, err = parseLocation(*center); err != nil {
+			return config, errors.Wrap(err, "invalid center of simulation")
+		}
+		if *gpx != "" {
+			f, err := os.Open(*gpx)
+			if err != nil {
+				return config, errors.Wrap(err, "could not open GPX")
+			}
+			defer f.Close()
+			if config.Tracks, err = simulate.ParseGPX(f); err != nil {
+				return config, err
+			}
+		}
+		return config, nil
+	}
+}
+
+// simulateCommand sends locations of simulated drivers to an instance, it returns the exit code
+func simulateCommand(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: nearestdots simulate [flags]\n\nMoves synthetic drivers along random walks or GPX tracks and sends their locations until interrupted.")
+		fs.PrintDefaults()
+	}
+	instance := clientFlags(fs)
+	simulation := simulationFlags(fs, "", 100)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	config, err := simulation()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	sim, err := simulate.New(config, instance())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	start := time.Now()
+	sim.Run(ctx)
+	stats := sim.Stats()
+	fmt.Printf("%d ticks, sent %d, failed %d in %s\n", stats.Ticks, stats.Sent, stats.Failed, time.Since(start).Round(time.Millisecond))
+	if stats.LastError != nil {
+		fmt.Fprintln(os.Stderr, "last failure:", stats.LastError)
+		return 1
+	}
+	return 0
+}
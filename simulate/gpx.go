@@ -0,0 +1,69 @@
+package simulate
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+)
+
+// ErrNoTracks sign what GPX has neither track segments nor routes with points
+var ErrNoTracks = errors.New("GPX has no tracks")
+
+type (
+	gpxPoint struct {
+		Lat float64 `xml:"lat,attr"`
+		Lon float64 `xml:"lon,attr"`
+	}
+
+	gpx struct {
+		Tracks []struct {
+			Segments []struct {
+				Points []gpxPoint `xml:"trkpt"`
+			} `xml:"trkseg"`
+		} `xml:"trk"`
+		Routes []struct {
+			Points []gpxPoint `xml:"rtept"`
+		} `xml:"rte"`
+	}
+)
+
+// ParseGPX returns every track segment and route of GPX as a track, waypoints are ignored
+func ParseGPX(r io.Reader) ([][]storage.Location, error) {
+	var doc gpx
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "could not parse GPX")
+	}
+	var tracks [][]storage.Location
+	add := func(points []gpxPoint) error {
+		if len(points) == 0 {
+			return nil
+		}
+		track := make([]storage.Location, len(points))
+		for i, p := range points {
+			track[i] = storage.Location{Lat: p.Lat, Lon: p.Lon}
+			if !track[i].Valid() {
+				return errors.Wrapf(storage.ErrInvalidLocation, "GPX point %v,%v", p.Lat, p.Lon)
+			}
+		}
+		tracks = append(tracks, track)
+		return nil
+	}
+	for _, t := range doc.Tracks {
+		for _, s := range t.Segments {
+			if err := add(s.Points); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, r := range doc.Routes {
+		if err := add(r.Points); err != nil {
+			return nil, err
+		}
+	}
+	if len(tracks) == 0 {
+		return nil, ErrNoTracks
+	}
+	return tracks, nil
+}
@@ -0,0 +1,249 @@
+// Package simulate moves synthetic drivers along random walks or recorded tracks,
+// so instances are demonstrated and benchmarked without real devices
+package simulate
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/kdrake/nearestdots/client"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+)
+
+// maxTurn is the standard deviation of a random walk turn per tick in degrees
+const maxTurn = 20
+
+// ErrNoDrivers sign what the simulation has no drivers
+var ErrNoDrivers = errors.New("Simulation needs at least one driver")
+
+type (
+	// Config of a simulation. Drivers get ids from FirstID on, they walk randomly within Radius meters
+	// around Center, or follow Tracks if there are any, driver i follows track i modulo their count
+	// from a random point and starts over at its end. Speed in meters per second varies by 20% between drivers.
+	// Every Interval all drivers move and their locations are sent in one batch.
+	Config struct {
+		Drivers  int
+		FirstID  int
+		Center   storage.Location
+		Radius   float64
+		Speed    float64
+		Interval time.Duration
+		Tracks   [][]storage.Location
+		Seed     int64
+	}
+
+	// Stats of a simulation
+	Stats struct {
+		Ticks     int
+		Sent      int
+		Failed    int
+		LastError error
+	}
+
+	// Simulation moves drivers and sends their locations until it's stopped
+	Simulation struct {
+		config  Config
+		sender  sender
+		rand    *rand.Rand
+		drivers []*driver
+		tracks  []*track
+
+		mu    sync.Mutex
+		stats Stats
+	}
+
+	// sender sends a batch of updates
+	sender interface {
+		UpdateLocations(ctx context.Context, updates []client.LocationUpdate) error
+	}
+
+	driver struct {
+		id       int
+		location storage.Location
+		heading  float64
+		speed    float64
+		track    *track
+		// traveled is the distance from the start of the track
+		traveled float64
+	}
+
+	// track is a polyline, lengths are cumulative distances of its points from the first one
+	track struct {
+		points  []storage.Location
+		lengths []float64
+	}
+)
+
+// New creates simulation of drivers sending their locations with the sender
+func New(config Config, s sender) (*Simulation, error) {
+	if config.Drivers <= 0 {
+		return nil, ErrNoDrivers
+	}
+	if config.FirstID <= 0 {
+		config.FirstID = 1
+	}
+	if config.Interval <= 0 {
+		config.Interval = time.Second
+	}
+	sim := &Simulation{config: config, sender: s, rand: rand.New(rand.NewSource(config.Seed))}
+	for _, points := range config.Tracks {
+		if len(points) > 0 {
+			sim.tracks = append(sim.tracks, newTrack(points))
+		}
+	}
+
+	for i := 0; i < config.Drivers; i++ {
+		d := &driver{
+			id:      config.FirstID + i,
+			heading: sim.rand.Float64() * 360,
+			speed:   config.Speed * (0.8 + 0.4*sim.rand.Float64()),
+		}
+		if len(sim.tracks) > 0 {
+			d.track = sim.tracks[i%len(sim.tracks)]
+			d.traveled = sim.rand.Float64() * d.track.length()
+			d.location = d.track.at(d.traveled)
+		} else {
+			// uniformly distributed within the circle
+			d.location = storage.Destination(config.Center, sim.rand.Float64()*360, config.Radius*math.Sqrt(sim.rand.Float64()))
+		}
+		sim.drivers = append(sim.drivers, d)
+	}
+	return sim, nil
+}
+
+// Run sends the initial locations and moves drivers every interval until ctx is done.
+// Failed batches are counted and not retried.
+func (s *Simulation) Run(ctx context.Context) error {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+	for {
+		s.send(ctx)
+		select {
+		case <-ticker.C:
+			s.move(s.config.Interval.Seconds())
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Close does nothing, drivers are left in the storage to expire
+func (s *Simulation) Close() error {
+	return nil
+}
+
+// Stats returns counters of the simulation
+func (s *Simulation) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// Updates returns current locations of drivers
+func (s *Simulation) Updates() []client.LocationUpdate {
+	ts := time.Now().UnixNano()
+	updates := make([]client.LocationUpdate, len(s.drivers))
+	for i, d := range s.drivers {
+		updates[i] = client.LocationUpdate{
+			DriverID:  d.id,
+			Location:  client.Location{Lat: d.location.Lat, Lon: d.location.Lon},
+			Timestamp: &ts,
+		}
+	}
+	return updates
+}
+
+func (s *Simulation) send(ctx context.Context) {
+	updates := s.Updates()
+	err := s.sender.UpdateLocations(ctx, updates)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Ticks++
+	if err != nil {
+		s.stats.Failed += len(updates)
+		s.stats.LastError = err
+		return
+	}
+	s.stats.Sent += len(updates)
+}
+
+// move moves every driver for the seconds
+func (s *Simulation) move(seconds float64) {
+	for _, d := range s.drivers {
+		distance := d.speed * seconds
+		if d.track != nil {
+			d.traveled = math.Mod(d.traveled+distance, math.Max(d.track.length(), 1))
+			next := d.track.at(d.traveled)
+			if next != d.location {
+				d.heading = storage.Bearing(d.location, next)
+			}
+			d.location = next
+			continue
+		}
+
+		d.heading += s.rand.NormFloat64() * maxTurn
+		// drivers leaving the area turn back to its center
+		if storage.Distance(d.location, s.config.Center) > s.config.Radius {
+			d.heading = storage.Bearing(d.location, s.config.Center) + s.rand.NormFloat64()*maxTurn
+		}
+		d.heading = math.Mod(d.heading+360, 360)
+		d.location = storage.Destination(d.location, d.heading, distance)
+	}
+}
+
+func newTrack(points []storage.Location) *track {
+	t := &track{points: points, lengths: make([]float64, len(points))}
+	for i := 1; i < len(points); i++ {
+		t.lengths[i] = t.lengths[i-1] + storage.Distance(points[i-1], points[i])
+	}
+	return t
+}
+
+// length returns distance from the first point to the last one
+func (t *track) length() float64 {
+	return t.lengths[len(t.lengths)-1]
+}
+
+// at returns location of the distance from the start, interpolated between points
+func (t *track) at(distance float64) storage.Location {
+	for i := 1; i < len(t.points); i++ {
+		if distance > t.lengths[i] {
+			continue
+		}
+		segment := t.lengths[i] - t.lengths[i-1]
+		if segment == 0 {
+			return t.points[i]
+		}
+		f := (distance - t.lengths[i-1]) / segment
+		a, b := t.points[i-1], t.points[i]
+		return storage.Location{Lat: a.Lat + (b.Lat-a.Lat)*f, Lon: a.Lon + (b.Lon-a.Lon)*f}
+	}
+	return t.points[len(t.points)-1]
+}
+
+// Storage sends updates directly to the storage
+type Storage struct {
+	db storage.Storage
+}
+
+// NewStorage creates sender of updates to the storage
+func NewStorage(db storage.Storage) *Storage {
+	return &Storage{db: db}
+}
+
+// UpdateLocations sets drivers of the updates
+func (s *Storage) UpdateLocations(_ context.Context, updates []client.LocationUpdate) error {
+	drivers := make([]*storage.Driver, len(updates))
+	for i, u := range updates {
+		drivers[i] = &storage.Driver{
+			ID:           u.DriverID,
+			LastLocation: storage.Location{Lat: u.Location.Lat, Lon: u.Location.Lon},
+			Timestamp:    *u.Timestamp,
+		}
+	}
+	return s.db.SetMany(drivers)
+}
@@ -0,0 +1,81 @@
+package simulate
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRandomWalk(t *testing.T) {
+	db := storage.New(100)
+	center := storage.Location{Lat: 42.874722, Lon: 74.612222}
+	sim, err := New(Config{Drivers: 50, FirstID: 100, Center: center, Radius: 1000, Speed: 10, Seed: 1}, NewStorage(db))
+	assert.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		sim.move(10)
+	}
+	for _, u := range sim.Updates() {
+		// a driver turns back within a tick after leaving the area
+		assert.True(t, storage.Distance(center, storage.Location{Lat: u.Location.Lat, Lon: u.Location.Lon}) < 1000+2*120)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sim.config.Interval = time.Millisecond
+	done := make(chan error)
+	go func() { done <- sim.Run(ctx) }()
+	assert.Eventually(t, func() bool { return sim.Stats().Ticks >= 3 }, time.Second, time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Equal(t, 50, db.Len())
+	_, err = db.Get(149)
+	assert.NoError(t, err)
+	stats := sim.Stats()
+	assert.Equal(t, stats.Ticks*50, stats.Sent)
+	assert.Zero(t, stats.Failed)
+
+	_, err = New(Config{}, NewStorage(db))
+	assert.Equal(t, ErrNoDrivers, err)
+}
+
+func TestTracks(t *testing.T) {
+	tracks, err := ParseGPX(strings.NewReader(`<?xml version="1.0"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+  <wpt lat="10" lon="10"/>
+  <trk><trkseg>
+    <trkpt lat="0" lon="0"><ele>1</ele></trkpt>
+    <trkpt lat="0" lon="0.01"/>
+    <trkpt lat="0.01" lon="0.01"/>
+  </trkseg></trk>
+  <rte><rtept lat="1" lon="1"/></rte>
+</gpx>`))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]storage.Location{
+		{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 0.01}, {Lat: 0.01, Lon: 0.01}},
+		{{Lat: 1, Lon: 1}},
+	}, tracks)
+
+	sim, err := New(Config{Drivers: 3, Speed: 10, Tracks: tracks}, NewStorage(storage.New(10)))
+	assert.NoError(t, err)
+	tr := sim.drivers[0].track
+	assert.InDelta(t, 2*1111.95, tr.length(), 1)
+	assert.Equal(t, storage.Location{Lat: 0, Lon: 0.005}, tr.at(tr.lengths[1]/2))
+	assert.Equal(t, storage.Location{Lat: 0.01, Lon: 0.01}, tr.at(tr.length()+1))
+	assert.Same(t, tr, sim.drivers[2].track)
+
+	// drivers start over at the end of the track, a single point track is standing
+	for i := 0; i < 100; i++ {
+		sim.move(10)
+	}
+	assert.True(t, sim.drivers[0].traveled < tr.length())
+	assert.Equal(t, storage.Location{Lat: 1, Lon: 1}, sim.drivers[1].location)
+
+	_, err = ParseGPX(strings.NewReader(`<gpx><wpt lat="10" lon="10"/></gpx>`))
+	assert.Equal(t, ErrNoTracks, err)
+	_, err = ParseGPX(strings.NewReader(`<gpx><rte><rtept lat="100" lon="10"/></rte></gpx>`))
+	assert.Error(t, err)
+}
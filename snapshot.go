@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// snapshotCommand downloads snapshot of a running instance to the file, it returns the exit code
+func snapshotCommand(args []string) int {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: nearestdots snapshot [flags] file\n\nDownloads snapshot of drivers, - writes it to stdout. The file is loaded by -snapshot_path or the restore command.")
+		fs.PrintDefaults()
+	}
+	instance := clientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	var err error
+	if fs.Arg(0) == "-" {
+		err = instance().Snapshot(ctx, os.Stdout)
+	} else {
+		err = writeFile(fs.Arg(0), func(w io.Writer) error {
+			return instance().Snapshot(ctx, w)
+		})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// restoreCommand replaces drivers of a running instance by the snapshot file, it returns the exit code
+func restoreCommand(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: nearestdots restore [flags] file\n\nReplaces drivers by the snapshot, - reads it from stdin. Restored drivers are persisted by the next snapshot of the instance.")
+		fs.PrintDefaults()
+	}
+	instance := clientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	in := os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := instance().Restore(ctx, in); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// writeFile replaces the file atomically by content written by write, the file is kept if write fails
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "could not create file")
+	}
+	defer os.Remove(f.Name())
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "could not close file")
+	}
+	return errors.Wrap(os.Rename(f.Name(), path), "could not replace file")
+}
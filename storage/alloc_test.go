@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/stretchr/testify/assert"
+)
+
+// allocDrivers is number of drivers of allocation benchmarks, they stand in a grid of 100 columns
+const allocDrivers = 10000
+
+func newAllocStorage(opts ...Option) *DriverStorage {
+	s := New(10, opts...)
+	for i := 0; i < allocDrivers; i++ {
+		s.Set(&Driver{ID: i, LastLocation: Location{Lat: float64(i%100) * 0.01, Lon: float64(i/100) * 0.01}})
+	}
+	return s
+}
+
+func TestSortByDistance(t *testing.T) {
+	origin := Location{Lat: 0, Lon: 0}
+	drivers := []*Driver{
+		{ID: 1, LastLocation: Location{Lat: 2, Lon: 0}},
+		{ID: 2, LastLocation: Location{Lat: 1, Lon: 0}},
+		{ID: 3, LastLocation: Location{Lat: 0, Lon: 2}},
+		{ID: 4, LastLocation: Location{Lat: 0, Lon: 1}},
+	}
+	SortByDistance(origin, drivers)
+	var ids []int
+	for _, d := range drivers {
+		ids = append(ids, d.ID)
+	}
+	// equally distant drivers keep their order
+	assert.Equal(t, []int{2, 4, 1, 3}, ids)
+}
+
+// BenchmarkSortByDistance sorts candidates of a nearest query, buffers of distances are reused
+func BenchmarkSortByDistance(b *testing.B) {
+	s := newAllocStorage()
+	origin := Location{Lat: 0.5, Lon: 0.5}
+	drivers := s.locations.Nearest(origin, 10, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		SortByDistance(origin, drivers)
+	}
+}
+
+// BenchmarkSetAllocs updates drivers moving far enough to be moved in the rtree
+func BenchmarkSetAllocs(b *testing.B) {
+	s := newAllocStorage()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Set(&Driver{ID: i % allocDrivers, LastLocation: Location{Lat: float64(i%100) * 0.01, Lon: float64(i%97) * 0.01}})
+	}
+}
+
+// BenchmarkSetJitterAllocs updates drivers standing still, they aren't moved in the rtree
+func BenchmarkSetJitterAllocs(b *testing.B) {
+	s := newAllocStorage()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := i % allocDrivers
+		s.Set(&Driver{ID: id, LastLocation: Location{Lat: float64(id%100) * 0.01, Lon: float64(id/100) * 0.01}})
+	}
+}
+
+func BenchmarkSetManyAllocs(b *testing.B) {
+	s := newAllocStorage()
+	batch := make([]*Driver, 100)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range batch {
+			id := (i*len(batch) + j) % allocDrivers
+			batch[j] = &Driver{ID: id, LastLocation: Location{Lat: float64(id%100) * 0.01, Lon: float64(id/100) * 0.01}}
+		}
+		s.SetMany(batch)
+	}
+}
+
+func BenchmarkNearestAllocs(b *testing.B) {
+	for name, opts := range map[string][]Option{
+		"rtree":   nil,
+		"geohash": {WithGeohashIndex(6)},
+		"s2":      {WithS2Index(13)},
+	} {
+		b.Run(name, func(b *testing.B) {
+			s := newAllocStorage(opts...)
+			point := rtreego.Point{0.5, 0.5}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.Nearest(point, 10, Available())
+			}
+		})
+	}
+}
+
+func BenchmarkInBoundingBoxAllocs(b *testing.B) {
+	s := newAllocStorage()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.InBoundingBox(0.4, 0.4, 0.6, 0.6)
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ErrImpossibleSpeed sign what driver location is too far from the previous one to be reached in time
+var ErrImpossibleSpeed = errors.New("Driver moved faster than possible")
+
+// WithMaxSpeed detects locations implying speed in meters per second above maxSpeed since the previous one,
+// they are teleports or spoofed GPS. Such locations are rejected if reject is set, otherwise drivers are flagged.
+// Zero speed disables detection.
+func WithMaxSpeed(maxSpeed float64, reject bool) Option {
+	return func(s *DriverStorage) {
+		s.maxSpeed = maxSpeed
+		s.rejectImpossible = reject
+	}
+}
+
+// impossible returns true if the driver can't get to its location from the stored one in time,
+// locations with the same timestamp are not checked. It's called under the lock.
+func (s *DriverStorage) impossible(driver *Driver) bool {
+	if s.maxSpeed <= 0 {
+		return false
+	}
+	d, ok := s.drivers[driver.ID]
+	if !ok || driver.Timestamp <= d.Timestamp {
+		return false
+	}
+	seconds := float64(driver.Timestamp-d.Timestamp) / 1e9
+	return Distance(d.LastLocation, driver.LastLocation)/seconds > s.maxSpeed
+}
+
+// Flagged returns up to limit drivers flagged for impossible speed, most flagged first
+func (s *DriverStorage) Flagged(limit int) []*Driver {
+	return flagged(s.ForEach, limit)
+}
+
+// Flagged returns up to limit flagged drivers of all shards
+func (s *ShardedStorage) Flagged(limit int) []*Driver {
+	return flagged(s.ForEach, limit)
+}
+
+// Flagged returns up to limit flagged drivers of all regions
+func (s *RegionStorage) Flagged(limit int) []*Driver {
+	return flagged(s.ForEach, limit)
+}
+
+func flagged(forEach func(fn func(d *Driver) bool), limit int) []*Driver {
+	var drivers []*Driver
+	forEach(func(d *Driver) bool {
+		if d.Anomalies > 0 {
+			drivers = append(drivers, d)
+		}
+		return true
+	})
+	sort.Slice(drivers, func(i, j int) bool {
+		a, b := drivers[i], drivers[j]
+		if a.Anomalies != b.Anomalies {
+			return a.Anomalies > b.Anomalies
+		}
+		if a.AnomalyAt != b.AnomalyAt {
+			return a.AnomalyAt > b.AnomalyAt
+		}
+		return a.ID < b.ID
+	})
+	if len(drivers) > limit {
+		drivers = drivers[:limit]
+	}
+	return drivers
+}
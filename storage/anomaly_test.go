@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImpossibleSpeed(t *testing.T) {
+	second := int64(time.Second)
+	s := New(10, WithMaxSpeed(50, false))
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Timestamp: second}))
+	assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 2, Lon: 2}, Timestamp: second}))
+	// 111 meters in 10 seconds is fine, 11 km in a second is not
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1.001, Lon: 1}, Timestamp: 11 * second}))
+	assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 2.1, Lon: 2}, Timestamp: 2 * second}))
+	assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 2, Lon: 2}, Timestamp: 3 * second}))
+
+	d, err := s.Get(1)
+	assert.NoError(t, err)
+	assert.Zero(t, d.Anomalies)
+	d, err = s.Get(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, d.Anomalies)
+	assert.Equal(t, 3*second, d.AnomalyAt)
+	assert.Equal(t, Location{Lat: 2, Lon: 2}, d.LastLocation)
+	assert.Equal(t, uint64(2), s.Stats().Anomalies)
+
+	flagged := s.Flagged(10)
+	if assert.Len(t, flagged, 1) {
+		assert.Equal(t, 2, flagged[0].ID)
+	}
+
+	s = New(10, WithMaxSpeed(50, true))
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Timestamp: second}))
+	assert.Equal(t, ErrImpossibleSpeed, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1.1, Lon: 1}, Timestamp: 2 * second}))
+	d, err = s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, Location{Lat: 1, Lon: 1}, d.LastLocation)
+	assert.Empty(t, s.Flagged(10))
+}
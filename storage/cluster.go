@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"math"
+	"sort"
+)
+
+// MaxZoom is the highest map zoom level supported by ClusterDrivers
+const MaxZoom = 22
+
+// clusterCellsPerTile is how many grid cells a map tile is split into along each side
+const clusterCellsPerTile = 4
+
+// Cluster is a group of drivers close to each other at a map zoom level,
+// DriverID is set if the cluster is a single driver
+type Cluster struct {
+	Center   Location `json:"center"`
+	Count    int      `json:"count"`
+	DriverID *int     `json:"driver_id,omitempty"`
+}
+
+// ClusterDrivers groups drivers in a grid of web mercator tiles of the zoom level,
+// every tile is split into clusterCellsPerTile^2 cells. Center is the drivers centroid.
+// Clusters are ordered by count descending.
+func ClusterDrivers(drivers []*Driver, zoom int) []Cluster {
+	if zoom < 0 {
+		zoom = 0
+	}
+	if zoom > MaxZoom {
+		zoom = MaxZoom
+	}
+	cells := float64(int(1)<<uint(zoom)) * clusterCellsPerTile
+
+	type cell struct{ x, y int }
+	type sum struct {
+		lat, lon float64
+		drivers  []*Driver
+	}
+	sums := make(map[cell]*sum)
+	for _, d := range drivers {
+		x, y := mercatorTile(d.LastLocation, cells)
+		c := cell{x, y}
+		s, ok := sums[c]
+		if !ok {
+			s = &sum{}
+			sums[c] = s
+		}
+		s.lat += d.LastLocation.Lat
+		s.lon += d.LastLocation.Lon
+		s.drivers = append(s.drivers, d)
+	}
+
+	clusters := make([]Cluster, 0, len(sums))
+	for _, s := range sums {
+		n := len(s.drivers)
+		c := Cluster{
+			Center: Location{Lat: s.lat / float64(n), Lon: s.lon / float64(n)},
+			Count:  n,
+		}
+		if n == 1 {
+			id := s.drivers[0].ID
+			c.DriverID = &id
+		}
+		clusters = append(clusters, c)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if clusters[i].Count != clusters[j].Count {
+			return clusters[i].Count > clusters[j].Count
+		}
+		if clusters[i].Center.Lat != clusters[j].Center.Lat {
+			return clusters[i].Center.Lat < clusters[j].Center.Lat
+		}
+		return clusters[i].Center.Lon < clusters[j].Center.Lon
+	})
+	return clusters
+}
+
+// mercatorTile returns x and y of the web mercator tile containing the location
+// on a map split into n by n tiles
+func mercatorTile(l Location, n float64) (x, y int) {
+	lat := math.Max(-maxMercatorLat, math.Min(maxMercatorLat, l.Lat)) * math.Pi / 180
+	fx := (l.Lon + 180) / 360 * n
+	fy := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * n
+	max := int(n) - 1
+	return clamp(int(fx), 0, max), clamp(int(fy), 0, max)
+}
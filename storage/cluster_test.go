@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterDrivers(t *testing.T) {
+	drivers := []*Driver{
+		{ID: 1, LastLocation: Location{Lat: 42.874, Lon: 74.612}},
+		{ID: 2, LastLocation: Location{Lat: 42.876, Lon: 74.614}},
+		{ID: 3, LastLocation: Location{Lat: 43.256, Lon: 76.928}},
+	}
+
+	clusters := ClusterDrivers(drivers, 8)
+	if assert.Len(t, clusters, 2) {
+		assert.Equal(t, 2, clusters[0].Count)
+		assert.InDelta(t, 42.875, clusters[0].Center.Lat, 1e-9)
+		assert.InDelta(t, 74.613, clusters[0].Center.Lon, 1e-9)
+		assert.Nil(t, clusters[0].DriverID)
+		assert.Equal(t, 1, clusters[1].Count)
+		assert.Equal(t, 3, *clusters[1].DriverID)
+	}
+
+	// whole world fits into few cells at zoom 0
+	assert.Len(t, ClusterDrivers(drivers, 0), 1)
+	// every driver is separate at the max zoom
+	assert.Len(t, ClusterDrivers(drivers, MaxZoom), 3)
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+)
+
+type (
+	// readSnapshots keeps the latest immutable rtree of copies of drivers, it's replaced
+	// by a new one built in background, so readers never wait for writers
+	readSnapshots struct {
+		interval time.Duration
+		current  atomic.Value // *readSnapshot
+		building int32
+	}
+
+	// readSnapshot is an rtree of copies of drivers built at the time
+	readSnapshot struct {
+		*rtreeIndex
+		built time.Time
+	}
+)
+
+// WithReadSnapshots serves Nearest, InBoundingBox and InPolygon from an immutable rtree of copies of drivers
+// which is rebuilt in background once it's older than interval. Queries don't lock the storage then,
+// they see drivers as of the last build and returned drivers are copies. NearestAndLock queries the storage.
+func WithReadSnapshots(interval time.Duration) Option {
+	return func(s *DriverStorage) {
+		if interval > 0 {
+			s.reads = &readSnapshots{interval: interval}
+		}
+	}
+}
+
+// readSnapshot returns the latest snapshot and starts building a new one if it's outdated,
+// the first snapshot and the one after a restore are built by the reader
+func (s *DriverStorage) readSnapshot() *readSnapshot {
+	r, _ := s.reads.current.Load().(*readSnapshot)
+	if r == nil {
+		r = s.buildReadSnapshot()
+		s.reads.current.Store(r)
+		return r
+	}
+	if time.Since(r.built) >= s.reads.interval && atomic.CompareAndSwapInt32(&s.reads.building, 0, 1) {
+		go func() {
+			s.reads.current.Store(s.buildReadSnapshot())
+			atomic.StoreInt32(&s.reads.building, 0)
+		}()
+	}
+	return r
+}
+
+// buildReadSnapshot copies drivers under the read lock and bulk loads the rtree of the copies
+func (s *DriverStorage) buildReadSnapshot() *readSnapshot {
+	s.mu.RLock()
+	built := time.Now()
+	entries := make([]rtreego.Spatial, 0, len(s.drivers))
+	for _, d := range s.drivers {
+		c := *d
+		entries = append(entries, newRtreeEntry(&c, s.rtree.tolerance))
+	}
+	s.mu.RUnlock()
+	r := &rtreeIndex{loaded: s.rtree.newTree(entries...), tree: s.rtree.newTree()}
+	return &readSnapshot{rtreeIndex: r, built: built}
+}
+
+// resetReadSnapshot drops the snapshot after the content of the storage is replaced, call it under the write lock
+func (s *DriverStorage) resetReadSnapshot() {
+	if s.reads != nil {
+		s.reads.current.Store((*readSnapshot)(nil))
+	}
+}
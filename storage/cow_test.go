@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadSnapshots(t *testing.T) {
+	s := New(10, WithReadSnapshots(50*time.Millisecond))
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}}))
+
+	// the first query builds the snapshot, later writes are seen once it's rebuilt
+	assert.Len(t, s.Nearest(rtreego.Point{1, 1}, 10), 1)
+	assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 1.001, Lon: 1}}))
+	assert.NoError(t, s.SetStatus(1, StatusBusy))
+	assert.Len(t, s.Nearest(rtreego.Point{1, 1}, 10), 1)
+	assert.Eventually(t, func() bool {
+		available := s.Nearest(rtreego.Point{1, 1}, 10, Available())
+		return len(available) == 1 && available[0].ID == 2
+	}, time.Second, 10*time.Millisecond)
+	drivers, err := s.InBoundingBox(0, 0, 2, 2)
+	assert.NoError(t, err)
+	assert.Len(t, drivers, 2)
+
+	// returned drivers are copies
+	drivers[0].Status = StatusOffline
+	d, _ := s.Get(drivers[0].ID)
+	assert.NotEqual(t, StatusOffline, d.Status)
+
+	// a restore replaces the snapshot at once
+	var b bytes.Buffer
+	assert.NoError(t, New(10).WriteSnapshot(&b))
+	assert.NoError(t, s.ReadSnapshot(&b))
+	assert.Empty(t, s.Nearest(rtreego.Point{1, 1}, 10))
+
+	// reservations are made on the storage
+	assert.NoError(t, s.Set(&Driver{ID: 3, LastLocation: Location{Lat: 1, Lon: 1}}))
+	assert.Len(t, s.NearestAndLock(rtreego.Point{1, 1}, 1, time.Minute), 1)
+	assert.Empty(t, s.NearestAndLock(rtreego.Point{1, 1}, 1, time.Minute))
+}
+
+func TestReadSnapshotsConcurrent(t *testing.T) {
+	s := New(10, WithReadSnapshots(time.Millisecond))
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				s.Set(&Driver{ID: w*1000 + i%20, LastLocation: Location{Lat: float64(i%10) * 0.01, Lon: 0}})
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				for _, d := range s.Nearest(rtreego.Point{0, 0}, 5) {
+					_ = d.LastLocation.Lat + d.Speed
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// BenchmarkNearestWhileWriting queries with a writer setting drivers all the time
+func BenchmarkNearestWhileWriting(b *testing.B) {
+	for name, opts := range map[string][]Option{
+		"locked":    nil,
+		"snapshots": {WithReadSnapshots(100 * time.Millisecond)},
+	} {
+		b.Run(name, func(b *testing.B) {
+			s := New(10, opts...)
+			for i := 0; i < 10000; i++ {
+				s.Set(&Driver{ID: i, LastLocation: Location{Lat: float64(i%100) * 0.01, Lon: float64(i/100) * 0.01}})
+			}
+			quit := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; ; i++ {
+					select {
+					case <-quit:
+						return
+					default:
+						s.Set(&Driver{ID: i % 10000, LastLocation: Location{Lat: float64(i%100) * 0.01, Lon: float64(i%97) * 0.01}})
+					}
+				}
+			}()
+			point := rtreego.Point{0.5, 0.5}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					s.Nearest(point, 10)
+				}
+			})
+			b.StopTimer()
+			close(quit)
+			<-done
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Demand keeps points of nearest queries made within the sliding window,
+	// they are the demand side of supply and demand of zones
+	Demand struct {
+		mu     sync.Mutex
+		window time.Duration
+		points []demandPoint
+	}
+
+	demandPoint struct {
+		location Location
+		at       int64
+	}
+
+	// Zone is a geohash cell with the number of drivers in it, the number of queries
+	// made from it within the window and demand per driver. Ratio equals demand if there are no drivers.
+	Zone struct {
+		Geohash string  `json:"geohash"`
+		Supply  int     `json:"supply"`
+		Demand  int     `json:"demand"`
+		Ratio   float64 `json:"ratio"`
+	}
+)
+
+// NewDemand returns demand of queries made within the window
+func NewDemand(window time.Duration) *Demand {
+	return &Demand{window: window}
+}
+
+// Record adds the point of a query made at the time
+func (d *Demand) Record(l Location, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.trim(now)
+	d.points = append(d.points, demandPoint{location: l, at: now.UnixNano()})
+}
+
+// Heatmap counts queries of the window in geohash cells of given precision,
+// cells are ordered by count descending
+func (d *Demand) Heatmap(precision int, now time.Time) []HeatmapCell {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.trim(now)
+	counts := make(map[string]int)
+	for _, p := range d.points {
+		counts[Geohash(p.location, precision)]++
+	}
+
+	cells := make([]HeatmapCell, 0, len(counts))
+	for hash, n := range counts {
+		cells = append(cells, HeatmapCell{Geohash: hash, Count: n})
+	}
+	SortHeatmap(cells)
+	return cells
+}
+
+// trim drops points older than the window, they are ordered by time.
+// Kept points are moved to the start once most of the slice is dropped, so it doesn't grow forever.
+// Call it under the lock.
+func (d *Demand) trim(now time.Time) {
+	oldest := now.Add(-d.window).UnixNano()
+	i := sort.Search(len(d.points), func(i int) bool {
+		return d.points[i].at > oldest
+	})
+	if 2*i < len(d.points) {
+		d.points = d.points[i:]
+		return
+	}
+	n := copy(d.points, d.points[i:])
+	d.points = d.points[:n]
+}
+
+// Zones joins supply and demand heatmaps of the same precision,
+// zones are ordered by ratio descending and by geohash
+func Zones(supply, demand []HeatmapCell) []Zone {
+	index := make(map[string]int, len(supply)+len(demand))
+	zones := make([]Zone, 0, len(supply)+len(demand))
+	zone := func(hash string) *Zone {
+		i, ok := index[hash]
+		if !ok {
+			i = len(zones)
+			index[hash] = i
+			zones = append(zones, Zone{Geohash: hash})
+		}
+		return &zones[i]
+	}
+	for _, c := range supply {
+		zone(c.Geohash).Supply += c.Count
+	}
+	for _, c := range demand {
+		zone(c.Geohash).Demand += c.Count
+	}
+
+	for i := range zones {
+		z := &zones[i]
+		z.Ratio = float64(z.Demand)
+		if z.Supply > 0 {
+			z.Ratio /= float64(z.Supply)
+		}
+	}
+	sort.Slice(zones, func(i, j int) bool {
+		if zones[i].Ratio != zones[j].Ratio {
+			return zones[i].Ratio > zones[j].Ratio
+		}
+		return zones[i].Geohash < zones[j].Geohash
+	})
+	return zones
+}
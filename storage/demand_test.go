@@ -0,0 +1,36 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDemand(t *testing.T) {
+	d := NewDemand(time.Minute)
+	now := time.Now()
+	d.Record(Location{Lat: 57.64911, Lon: 10.40744}, now.Add(-2*time.Minute))
+	d.Record(Location{Lat: 57.64911, Lon: 10.40744}, now.Add(-time.Second))
+	d.Record(Location{Lat: 57.649, Lon: 10.407}, now)
+	d.Record(Location{Lat: 1, Lon: 1}, now)
+
+	// the first query is out of the window
+	assert.Equal(t, []HeatmapCell{
+		{Geohash: "u4pr", Count: 2},
+		{Geohash: "s00t", Count: 1},
+	}, d.Heatmap(4, now))
+	assert.Empty(t, d.Heatmap(4, now.Add(2*time.Minute)))
+}
+
+func TestZones(t *testing.T) {
+	zones := Zones(
+		[]HeatmapCell{{Geohash: "a", Count: 4}, {Geohash: "b", Count: 1}},
+		[]HeatmapCell{{Geohash: "a", Count: 2}, {Geohash: "b", Count: 3}, {Geohash: "c", Count: 1}},
+	)
+	assert.Equal(t, []Zone{
+		{Geohash: "b", Supply: 1, Demand: 3, Ratio: 3},
+		{Geohash: "c", Supply: 0, Demand: 1, Ratio: 1},
+		{Geohash: "a", Supply: 4, Demand: 2, Ratio: 0.5},
+	}, zones)
+}
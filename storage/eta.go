@@ -0,0 +1,20 @@
+package storage
+
+import "time"
+
+// minETASpeed is the slowest driver speed in meters per second used for ETA,
+// slower drivers are probably standing and the average speed is used instead
+const minETASpeed = 1.0
+
+// ETA estimates time for the driver to reach the location along a straight line
+// with its current speed or with averageSpeed in meters per second if it's standing
+func ETA(d *Driver, to Location, averageSpeed float64) time.Duration {
+	speed := d.Speed
+	if speed < minETASpeed {
+		speed = averageSpeed
+	}
+	if speed <= 0 {
+		return 0
+	}
+	return time.Duration(Distance(d.LastLocation, to) / speed * float64(time.Second))
+}
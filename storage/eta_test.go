@@ -0,0 +1,19 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestETA(t *testing.T) {
+	d := &Driver{LastLocation: Location{Lat: 42, Lon: 74}}
+	to := Location{Lat: 43, Lon: 74}
+
+	// standing driver uses the average speed
+	assert.InDelta(t, 111195.0/10, ETA(d, to, 10).Seconds(), 1)
+	d.Speed = 20
+	assert.InDelta(t, 111195.0/20, ETA(d, to, 10).Seconds(), 1)
+	assert.Equal(t, time.Duration(0), ETA(&Driver{}, to, 0))
+}
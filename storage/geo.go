@@ -0,0 +1,178 @@
+package storage
+
+import "math"
+
+// earthRadius is the mean Earth radius in meters
+const earthRadius = 6371008.8
+
+// Distance returns great-circle distance between two locations in meters
+// using the haversine formula
+func Distance(a, b Location) float64 {
+	lat1 := a.Lat * math.Pi / 180
+	lat2 := b.Lat * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Valid returns true if latitude is in [-90, 90] and longitude is in [-180, 180],
+// NaN and infinite coordinates are invalid
+func (l Location) Valid() bool {
+	return l.Lat >= -90 && l.Lat <= 90 && l.Lon >= -180 && l.Lon <= 180
+}
+
+// ValidBoundingBox returns true if min lat is below max lat and longitudes differ
+func ValidBoundingBox(minLat, minLon, maxLat, maxLon float64) bool {
+	return minLat < maxLat && minLon != maxLon
+}
+
+// SplitBoundingBox returns the bounding box as boxes of min lat, min lon, max lat and max lon
+// not crossing the antimeridian. A box with min lon greater than max lon crosses it like GeoJSON ones do.
+func SplitBoundingBox(minLat, minLon, maxLat, maxLon float64) [][4]float64 {
+	if minLon > maxLon {
+		maxLon += 360
+	}
+	return wrapBox(minLat, minLon, maxLat, maxLon)
+}
+
+// wrapBox splits the box with longitudes beyond [-180, 180] at the antimeridian,
+// the box spans all longitudes if it's wider than 360 degrees
+func wrapBox(minLat, minLon, maxLat, maxLon float64) [][4]float64 {
+	switch {
+	case maxLon-minLon >= 360:
+		return [][4]float64{{minLat, -180, maxLat, 180}}
+	case minLon < -180:
+		return [][4]float64{{minLat, minLon + 360, maxLat, 180}, {minLat, -180, maxLat, maxLon}}
+	case maxLon > 180:
+		return [][4]float64{{minLat, minLon, maxLat, 180}, {minLat, -180, maxLat, maxLon - 360}}
+	}
+	return [][4]float64{{minLat, minLon, maxLat, maxLon}}
+}
+
+// boxesAround returns boxes including all locations within the radius in meters from the location.
+// Degrees of longitude are scaled by the latitude farthest from the equator, boxes reaching a pole
+// span all longitudes and boxes crossing the antimeridian are split.
+func boxesAround(l Location, radius float64) [][4]float64 {
+	dLat := radius / metersPerDegree
+	minLat, maxLat := l.Lat-dLat, l.Lat+dLat
+	if minLat <= -90 || maxLat >= 90 {
+		return [][4]float64{{math.Max(-90, minLat), -180, math.Min(90, maxLat), 180}}
+	}
+	dLon := dLat / math.Cos(math.Max(math.Abs(minLat), math.Abs(maxLat))*math.Pi/180)
+	return wrapBox(minLat, l.Lon-dLon, maxLat, l.Lon+dLon)
+}
+
+// Polygon is a list of linear rings, the first ring is the exterior
+// boundary and the others are holes
+type Polygon [][]Location
+
+// Valid returns true if polygon has exterior ring and every ring
+// has at least three distinct vertices
+func (p Polygon) Valid() bool {
+	if len(p) == 0 {
+		return false
+	}
+	for _, ring := range p {
+		n := len(ring)
+		if n > 0 && ring[0] == ring[n-1] {
+			n--
+		}
+		if n < 3 {
+			return false
+		}
+	}
+	return true
+}
+
+// BoundingBox returns bounding box of the exterior ring,
+// min lon is greater than max lon if the polygon crosses the antimeridian
+func (p Polygon) BoundingBox() (minLat, minLon, maxLat, maxLon float64) {
+	wrap := p.crossesAntimeridian()
+	minLat, minLon = math.Inf(1), math.Inf(1)
+	maxLat, maxLon = math.Inf(-1), math.Inf(-1)
+	for _, l := range p[0] {
+		if wrap {
+			l.Lon = unwrap(l.Lon)
+		}
+		minLat = math.Min(minLat, l.Lat)
+		minLon = math.Min(minLon, l.Lon)
+		maxLat = math.Max(maxLat, l.Lat)
+		maxLon = math.Max(maxLon, l.Lon)
+	}
+	if maxLon > 180 {
+		maxLon -= 360
+	}
+	return
+}
+
+// Contains returns true if location is inside the exterior ring and outside of all holes
+func (p Polygon) Contains(l Location) bool {
+	wrap := p.crossesAntimeridian()
+	if wrap {
+		l.Lon = unwrap(l.Lon)
+	}
+	if !ringContains(p[0], l, wrap) {
+		return false
+	}
+	for _, hole := range p[1:] {
+		if ringContains(hole, l, wrap) {
+			return false
+		}
+	}
+	return true
+}
+
+// Unwrapped returns the polygon with western longitudes shifted 360 degrees east if it crosses the antimeridian,
+// so it's a planar polygon of longitudes up to 360
+func (p Polygon) Unwrapped() Polygon {
+	if !p.crossesAntimeridian() {
+		return p
+	}
+	u := make(Polygon, len(p))
+	for i, ring := range p {
+		u[i] = make([]Location, len(ring))
+		for j, l := range ring {
+			u[i][j] = Location{Lat: l.Lat, Lon: unwrap(l.Lon)}
+		}
+	}
+	return u
+}
+
+// crossesAntimeridian returns true if an edge of the exterior ring spans more than 180 degrees of longitude,
+// such edges take the shorter way across the antimeridian
+func (p Polygon) crossesAntimeridian() bool {
+	ring := p[0]
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		if math.Abs(ring[i].Lon-ring[j].Lon) > 180 {
+			return true
+		}
+	}
+	return false
+}
+
+// unwrap shifts western longitudes east of the antimeridian
+func unwrap(lon float64) float64 {
+	if lon < 0 {
+		return lon + 360
+	}
+	return lon
+}
+
+// ringContains implements ray casting point-in-polygon test, longitudes of wrapped rings are unwrapped
+func ringContains(ring []Location, l Location, wrap bool) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if wrap {
+			a.Lon, b.Lon = unwrap(a.Lon), unwrap(b.Lon)
+		}
+		if (a.Lat > l.Lat) != (b.Lat > l.Lat) &&
+			l.Lon < (b.Lon-a.Lon)*(l.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistance(t *testing.T) {
+	bishkek := Location{Lat: 42.874722, Lon: 74.612222}
+	assert.Equal(t, 0.0, Distance(bishkek, bishkek))
+
+	// one degree along a meridian
+	assert.InDelta(t, 111195, Distance(Location{Lat: 42, Lon: 74}, Location{Lat: 43, Lon: 74}), 1)
+
+	// one degree of longitude is much shorter near the poles
+	assert.True(t, Distance(Location{Lat: 70, Lon: 0}, Location{Lat: 70, Lon: 1}) <
+		Distance(Location{Lat: 0, Lon: 0}, Location{Lat: 0, Lon: 1}))
+}
+
+func TestPolygonContains(t *testing.T) {
+	square := []Location{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 10}, {Lat: 10, Lon: 10}, {Lat: 10, Lon: 0}, {Lat: 0, Lon: 0}}
+	hole := []Location{{Lat: 4, Lon: 4}, {Lat: 4, Lon: 6}, {Lat: 6, Lon: 6}, {Lat: 6, Lon: 4}}
+	p := Polygon{square, hole}
+	assert.True(t, p.Valid())
+
+	assert.True(t, p.Contains(Location{Lat: 1, Lon: 1}))
+	assert.False(t, p.Contains(Location{Lat: 5, Lon: 5}))
+	assert.False(t, p.Contains(Location{Lat: 11, Lon: 5}))
+
+	minLat, minLon, maxLat, maxLon := p.BoundingBox()
+	assert.Equal(t, []float64{0, 0, 10, 10}, []float64{minLat, minLon, maxLat, maxLon})
+
+	assert.False(t, Polygon{}.Valid())
+	assert.False(t, Polygon{square[:2]}.Valid())
+}
+
+func TestPolygonAntimeridian(t *testing.T) {
+	// Fiji with a hole around Suva, edges longer than 180 degrees take the shorter way
+	fiji := Polygon{
+		{{Lat: -20, Lon: 176}, {Lat: -20, Lon: -178}, {Lat: -15, Lon: -178}, {Lat: -15, Lon: 176}},
+		{{Lat: -18.2, Lon: 178.3}, {Lat: -18.2, Lon: 178.6}, {Lat: -18, Lon: 178.6}, {Lat: -18, Lon: 178.3}},
+	}
+	minLat, minLon, maxLat, maxLon := fiji.BoundingBox()
+	assert.Equal(t, []float64{-20, 176, -15, -178}, []float64{minLat, minLon, maxLat, maxLon})
+
+	assert.True(t, fiji.Contains(Location{Lat: -16.8, Lon: 179.9}))
+	assert.True(t, fiji.Contains(Location{Lat: -16.8, Lon: -179.9}))
+	assert.False(t, fiji.Contains(Location{Lat: -18.1, Lon: 178.4}))
+	assert.False(t, fiji.Contains(Location{Lat: -16.8, Lon: 0}))
+	assert.False(t, fiji.Contains(Location{Lat: -16.8, Lon: -177}))
+
+	unwrapped := fiji.Unwrapped()
+	assert.Equal(t, 182.0, unwrapped[0][1].Lon)
+	assert.Equal(t, -178.0, fiji[0][1].Lon)
+	square := Polygon{{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 10}, {Lat: 10, Lon: 10}}}
+	assert.Equal(t, square, square.Unwrapped())
+}
+
+func TestSplitBoundingBox(t *testing.T) {
+	assert.Equal(t, [][4]float64{{-19, 10, -16, 20}}, SplitBoundingBox(-19, 10, -16, 20))
+	// Fiji is split at the antimeridian
+	assert.Equal(t, [][4]float64{{-19, 177, -16, 180}, {-19, -180, -16, -179}}, SplitBoundingBox(-19, 177, -16, -179))
+}
+
+func TestBoxesAround(t *testing.T) {
+	boxes := boxesAround(Location{Lat: 0, Lon: 179.5}, metersPerDegree)
+	if assert.Len(t, boxes, 2) {
+		assert.InDeltaSlice(t, []float64{-1, 178.5, 1, 180}, boxes[0][:], 0.001)
+		assert.InDeltaSlice(t, []float64{-1, -180, 1, -179.5}, boxes[1][:], 0.001)
+	}
+
+	// degrees of longitude are twice shorter at 60 degrees
+	boxes = boxesAround(Location{Lat: 59, Lon: 10}, metersPerDegree)
+	if assert.Len(t, boxes, 1) {
+		assert.InDelta(t, 8, boxes[0][1], 0.001)
+	}
+
+	// boxes reaching a pole span all longitudes
+	assert.Equal(t, [][4]float64{{88.5, -180, 90, 180}}, boxesAround(Location{Lat: 89.5, Lon: 10}, metersPerDegree))
+}
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"math"
+	"sort"
+)
+
+const geohashBase32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// Geohash returns geohash of the location with given precision in characters
+func Geohash(l Location, precision int) string {
+	g := newGeohashGrid(precision)
+	key := g.key(g.cell(l))
+	buf := make([]byte, g.precision)
+	for i := len(buf) - 1; i >= 0; i-- {
+		buf[i] = geohashBase32[key&31]
+		key >>= 5
+	}
+	return string(buf)
+}
+
+// geohashGrid is a grid of geohash cells with fixed precision.
+// Cells are addressed by x (longitude) and y (latitude) indexes.
+type geohashGrid struct {
+	precision int
+	lonBits   uint
+	latBits   uint
+}
+
+func newGeohashGrid(precision int) geohashGrid {
+	if precision < 1 {
+		precision = 1
+	}
+	// 12 characters use 60 bits, it's the most what fits into uint64 key
+	if precision > 12 {
+		precision = 12
+	}
+	bits := uint(precision * 5)
+	return geohashGrid{
+		precision: precision,
+		lonBits:   (bits + 1) / 2,
+		latBits:   bits / 2,
+	}
+}
+
+func (g geohashGrid) size() (width, height int) {
+	return 1 << g.lonBits, 1 << g.latBits
+}
+
+// cellSize returns cell size in degrees
+func (g geohashGrid) cellSize() (lat, lon float64) {
+	width, height := g.size()
+	return 180 / float64(height), 360 / float64(width)
+}
+
+func (g geohashGrid) cell(l Location) (x, y int) {
+	width, height := g.size()
+	x = int((l.Lon + 180) / 360 * float64(width))
+	y = int((l.Lat + 90) / 180 * float64(height))
+	return clamp(x, 0, width-1), clamp(y, 0, height-1)
+}
+
+// key interleaves x and y bits starting with longitude like geohash does
+func (g geohashGrid) key(x, y int) uint64 {
+	var key uint64
+	lonBit, latBit := g.lonBits, g.latBits
+	for i := uint(0); i < g.lonBits+g.latBits; i++ {
+		key <<= 1
+		if i%2 == 0 {
+			lonBit--
+			key |= uint64(x>>lonBit) & 1
+		} else {
+			latBit--
+			key |= uint64(y>>latBit) & 1
+		}
+	}
+	return key
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// geohashIndex keeps drivers in buckets by geohash cell.
+// Moving a driver is a cheap map update compared to the rtree delete/insert.
+type geohashIndex struct {
+	grid    geohashGrid
+	buckets map[uint64]map[int]*Driver
+	cells   map[int]uint64
+}
+
+// newGeohashIndex loads drivers sorted by cell, so every bucket is allocated once with its final size
+func newGeohashIndex(precision int, drivers []*Driver) index {
+	g := &geohashIndex{
+		grid:    newGeohashGrid(precision),
+		buckets: make(map[uint64]map[int]*Driver),
+		cells:   make(map[int]uint64, len(drivers)),
+	}
+	keys := make([]uint64, len(drivers))
+	order := make([]int, len(drivers))
+	for i, d := range drivers {
+		keys[i] = g.grid.key(g.grid.cell(d.LastLocation))
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+	for start := 0; start < len(order); {
+		key := keys[order[start]]
+		end := start + 1
+		for end < len(order) && keys[order[end]] == key {
+			end++
+		}
+		bucket := make(map[int]*Driver, end-start)
+		for _, i := range order[start:end] {
+			bucket[drivers[i].ID] = drivers[i]
+			g.cells[drivers[i].ID] = key
+		}
+		g.buckets[key] = bucket
+		start = end
+	}
+	return g
+}
+
+func (g *geohashIndex) Insert(d *Driver) {
+	key := g.grid.key(g.grid.cell(d.LastLocation))
+	if old, ok := g.cells[d.ID]; ok {
+		if old == key {
+			g.buckets[key][d.ID] = d
+			return
+		}
+		g.remove(old, d.ID)
+	}
+
+	bucket, ok := g.buckets[key]
+	if !ok {
+		bucket = make(map[int]*Driver)
+		g.buckets[key] = bucket
+	}
+	bucket[d.ID] = d
+	g.cells[d.ID] = key
+}
+
+func (g *geohashIndex) Delete(d *Driver) bool {
+	key, ok := g.cells[d.ID]
+	if !ok {
+		return false
+	}
+	g.remove(key, d.ID)
+	delete(g.cells, d.ID)
+	return true
+}
+
+func (g *geohashIndex) remove(key uint64, id int) {
+	bucket := g.buckets[key]
+	delete(bucket, id)
+	if len(bucket) == 0 {
+		delete(g.buckets, key)
+	}
+}
+
+// Nearest scans rings of cells around the point until the nearest count
+// drivers found are closer than any driver in the cells not scanned yet
+func (g *geohashIndex) Nearest(point Location, count int, filter Filter) []*Driver {
+	if count <= 0 {
+		return nil
+	}
+	if count >= len(g.cells) {
+		return g.all(filter)
+	}
+
+	width, height := g.grid.size()
+	cellLat, cellLon := g.grid.cellSize()
+	cx, cy := g.grid.cell(point)
+
+	var drivers []*Driver
+	for r := 0; ; r++ {
+		// scanning all buckets is cheaper than walking through empty cells
+		cells := 8 * r
+		if r == 0 {
+			cells = 1
+		}
+		if cells > len(g.buckets) || 2*r+1 >= width || 2*r+1 >= height {
+			return g.all(filter)
+		}
+
+		for y := cy - r; y <= cy+r; y++ {
+			if y < 0 || y >= height {
+				continue
+			}
+			step := 1
+			if y != cy-r && y != cy+r {
+				step = 2 * r
+			}
+			for x := cx - r; x <= cx+r; x += step {
+				drivers = g.appendBucket(drivers, (x+width)%width, y, filter)
+			}
+		}
+		if len(drivers) < count {
+			continue
+		}
+
+		// cells outside the ring are at least r cells away from the point
+		maxLat := math.Min(90, math.Abs(point.Lat)+float64(r+1)*cellLat)
+		gap := float64(r) * earthRadius * math.Pi / 180 *
+			math.Min(cellLat, cellLon*math.Cos(maxLat*math.Pi/180))
+		if kthDistance(point, drivers, count) <= gap {
+			return drivers
+		}
+	}
+}
+
+func (g *geohashIndex) Search(minLat, minLon, maxLat, maxLon float64) []*Driver {
+	x0, y0 := g.grid.cell(Location{Lat: minLat, Lon: minLon})
+	x1, y1 := g.grid.cell(Location{Lat: maxLat, Lon: maxLon})
+	if (x1-x0+1)*(y1-y0+1) > len(g.buckets) {
+		return g.all(nil)
+	}
+
+	var drivers []*Driver
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			drivers = g.appendBucket(drivers, x, y, nil)
+		}
+	}
+	return drivers
+}
+
+func (g *geohashIndex) appendBucket(drivers []*Driver, x, y int, filter Filter) []*Driver {
+	return appendMatching(drivers, g.buckets[g.grid.key(x, y)], filter)
+}
+
+func (g *geohashIndex) all(filter Filter) []*Driver {
+	drivers := make([]*Driver, 0, len(g.cells))
+	for _, bucket := range g.buckets {
+		drivers = appendMatching(drivers, bucket, filter)
+	}
+	return drivers
+}
+
+// appendMatching appends drivers from bucket matching the filter
+func appendMatching(drivers []*Driver, bucket map[int]*Driver, filter Filter) []*Driver {
+	for _, d := range bucket {
+		if filter == nil || filter(d) {
+			drivers = append(drivers, d)
+		}
+	}
+	return drivers
+}
+
+// kthDistance returns distance to the k-th nearest driver
+func kthDistance(point Location, drivers []*Driver, k int) float64 {
+	distances := make([]float64, len(drivers))
+	for i, d := range drivers {
+		distances[i] = Distance(point, d.LastLocation)
+	}
+	sort.Float64s(distances)
+	return distances[k-1]
+}
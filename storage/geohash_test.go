@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeohash(t *testing.T) {
+	assert.Equal(t, "u4pruydqqvj", Geohash(Location{Lat: 57.64911, Lon: 10.40744}, 11))
+}
+
+func TestGeohashIndexNearest(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	s := New(1, WithGeohashIndex(6))
+	var all []*Driver
+	for i := 0; i < 1000; i++ {
+		d := &Driver{
+			ID: i,
+			LastLocation: Location{
+				Lat: 42.8 + r.Float64()*0.2,
+				Lon: 74.5 + r.Float64()*0.2,
+			},
+		}
+		s.Set(d)
+		all = append(all, d)
+	}
+
+	point := Location{Lat: 42.9, Lon: 74.6}
+	sort.Slice(all, func(i, j int) bool {
+		return Distance(point, all[i].LastLocation) < Distance(point, all[j].LastLocation)
+	})
+
+	drivers := s.Nearest(rtreego.Point{point.Lat, point.Lon}, 10)
+	assert.Equal(t, 10, len(drivers))
+	for i, d := range drivers {
+		assert.Equal(t, all[i].ID, d.ID)
+	}
+
+	// point far from all drivers falls back to scanning every bucket
+	drivers = s.Nearest(rtreego.Point{0, 0}, 1)
+	assert.Equal(t, 1, len(drivers))
+}
+
+func TestGeohashIndexMoveAndDelete(t *testing.T) {
+	s := New(10, WithGeohashIndex(6))
+	s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 42.875799, Lon: 74.588279}})
+	s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 43.875799, Lon: 75.588279}})
+
+	drivers, err := s.InBoundingBox(42.87, 74.58, 42.88, 74.59)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(drivers))
+	assert.Equal(t, 1, drivers[0].ID)
+
+	assert.NoError(t, s.Delete(1))
+	drivers, err = s.InBoundingBox(42.87, 74.58, 42.88, 74.59)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(drivers))
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/uber/h3-go/v4"
+)
+
+// MaxH3Resolution is the finest resolution of H3 cells, cells of it are about a square meter
+const MaxH3Resolution = 15
+
+// ErrInvalidH3Resolution sign what H3 resolution is out of range
+var ErrInvalidH3Resolution = errors.New("H3 resolution must be from 0 to 15")
+
+// H3Cell is a number of drivers in a H3 cell, Available of them are available for orders
+type H3Cell struct {
+	Cell      string `json:"cell"`
+	Count     int    `json:"count"`
+	Available int    `json:"available"`
+}
+
+// H3Index returns the H3 index of the cell of the location at the resolution in hex, as analytics store it
+func H3Index(l Location, resolution int) (string, error) {
+	if resolution < 0 || resolution > MaxH3Resolution {
+		return "", ErrInvalidH3Resolution
+	}
+	if !l.Valid() {
+		return "", ErrInvalidLocation
+	}
+	cell, err := h3.LatLngToCell(h3.NewLatLng(l.Lat, l.Lon), resolution)
+	if err != nil {
+		return "", errors.Wrap(err, "H3 index")
+	}
+	return cell.String(), nil
+}
+
+// H3 counts drivers in H3 cells of the resolution, cells are ordered by count descending
+func (s *DriverStorage) H3(resolution int) ([]H3Cell, error) {
+	return h3Cells(s.ForEach, resolution)
+}
+
+// H3 counts drivers of all shards in H3 cells of the resolution
+func (s *ShardedStorage) H3(resolution int) ([]H3Cell, error) {
+	return h3Cells(s.ForEach, resolution)
+}
+
+// H3 counts drivers of all regions in H3 cells of the resolution
+func (s *RegionStorage) H3(resolution int) ([]H3Cell, error) {
+	return h3Cells(s.ForEach, resolution)
+}
+
+func h3Cells(forEach func(fn func(d *Driver) bool), resolution int) ([]H3Cell, error) {
+	if resolution < 0 || resolution > MaxH3Resolution {
+		return nil, ErrInvalidH3Resolution
+	}
+	available := Available()
+	counts := make(map[string]*H3Cell)
+	var err error
+	forEach(func(d *Driver) bool {
+		var index string
+		if index, err = H3Index(d.LastLocation, resolution); err != nil {
+			return false
+		}
+		c, ok := counts[index]
+		if !ok {
+			c = &H3Cell{Cell: index}
+			counts[index] = c
+		}
+		c.Count++
+		if available(d) {
+			c.Available++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cells := make([]H3Cell, 0, len(counts))
+	for _, c := range counts {
+		cells = append(cells, *c)
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Count != cells[j].Count {
+			return cells[i].Count > cells[j].Count
+		}
+		return cells[i].Cell < cells[j].Cell
+	})
+	return cells, nil
+}
+
+// ParseH3Resolutions parses comma separated H3 resolutions, duplicates are dropped
+func ParseH3Resolutions(s string) ([]int, error) {
+	var resolutions []int
+	if s == "" {
+		return resolutions, nil
+	}
+	seen := make(map[int]bool)
+	for _, v := range strings.Split(s, ",") {
+		r, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || r < 0 || r > MaxH3Resolution {
+			return nil, ErrInvalidH3Resolution
+		}
+		if !seen[r] {
+			seen[r] = true
+			resolutions = append(resolutions, r)
+		}
+	}
+	return resolutions, nil
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestH3(t *testing.T) {
+	index, err := H3Index(Location{Lat: 37.775938728915946, Lon: -122.41795063018799}, 9)
+	assert.NoError(t, err)
+	assert.Equal(t, "8928308280fffff", index)
+	_, err = H3Index(Location{Lat: 1, Lon: 1}, 16)
+	assert.Equal(t, ErrInvalidH3Resolution, err)
+	_, err = H3Index(Location{Lat: 91, Lon: 1}, 9)
+	assert.Equal(t, ErrInvalidLocation, err)
+
+	s := New(10)
+	for i, l := range []Location{
+		{Lat: 42.8746, Lon: 74.6122},
+		{Lat: 42.8747, Lon: 74.6123},
+		{Lat: 42.8745, Lon: 74.6121},
+		{Lat: 43.2567, Lon: 76.9286},
+	} {
+		assert.NoError(t, s.Set(&Driver{ID: i, LastLocation: l}))
+	}
+	assert.NoError(t, s.SetStatus(1, StatusBusy))
+
+	cells, err := s.H3(7)
+	assert.NoError(t, err)
+	if assert.Len(t, cells, 2) {
+		index, _ := H3Index(Location{Lat: 42.8746, Lon: 74.6122}, 7)
+		assert.Equal(t, H3Cell{Cell: index, Count: 3, Available: 2}, cells[0])
+		assert.Equal(t, 1, cells[1].Count)
+	}
+	_, err = s.H3(-1)
+	assert.Equal(t, ErrInvalidH3Resolution, err)
+}
+
+func TestH3Available(t *testing.T) {
+	for name, s := range map[string]Storage{
+		"single":  New(10),
+		"sharded": NewSharded(3, 10),
+	} {
+		counter := s.(interface {
+			H3(resolution int) ([]H3Cell, error)
+			StopShift(id int) error
+		})
+		for id := 1; id <= 4; id++ {
+			assert.NoError(t, s.Set(&Driver{ID: id, LastLocation: Location{Lat: 42.8746, Lon: 74.6122}}), name)
+		}
+		// busy, off shift and reserved drivers are not available
+		assert.NoError(t, s.SetStatus(1, StatusBusy), name)
+		assert.NoError(t, counter.StopShift(2), name)
+		assert.Len(t, s.NearestAndLock(rtreego.Point{42.8746, 74.6122}, 1, time.Minute), 1, name)
+
+		cells, err := counter.H3(9)
+		assert.NoError(t, err, name)
+		if assert.Len(t, cells, 1, name) {
+			assert.Equal(t, 4, cells[0].Count, name)
+			assert.Equal(t, 1, cells[0].Available, name)
+		}
+	}
+}
+
+func TestParseH3Resolutions(t *testing.T) {
+	resolutions, err := ParseH3Resolutions("7, 8,9,7")
+	assert.NoError(t, err)
+	assert.Equal(t, []int{7, 8, 9}, resolutions)
+	resolutions, err = ParseH3Resolutions("")
+	assert.NoError(t, err)
+	assert.Empty(t, resolutions)
+	_, err = ParseH3Resolutions("7,16")
+	assert.Equal(t, ErrInvalidH3Resolution, err)
+	_, err = ParseH3Resolutions("7,a")
+	assert.Equal(t, ErrInvalidH3Resolution, err)
+}
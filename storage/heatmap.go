@@ -0,0 +1,45 @@
+package storage
+
+import "sort"
+
+// HeatmapCell is a number of drivers in a geohash cell
+type HeatmapCell struct {
+	Geohash string `json:"geohash"`
+	Count   int    `json:"count"`
+}
+
+// Heatmap counts drivers in geohash cells of given precision,
+// cells are ordered by count descending
+func (s *DriverStorage) Heatmap(precision int) ([]HeatmapCell, error) {
+	return heatmap(s.ForEach, precision), nil
+}
+
+// Heatmap counts drivers of all shards in geohash cells of given precision
+func (s *ShardedStorage) Heatmap(precision int) ([]HeatmapCell, error) {
+	return heatmap(s.ForEach, precision), nil
+}
+
+func heatmap(forEach func(fn func(d *Driver) bool), precision int) []HeatmapCell {
+	counts := make(map[string]int)
+	forEach(func(d *Driver) bool {
+		counts[Geohash(d.LastLocation, precision)]++
+		return true
+	})
+
+	cells := make([]HeatmapCell, 0, len(counts))
+	for hash, n := range counts {
+		cells = append(cells, HeatmapCell{Geohash: hash, Count: n})
+	}
+	SortHeatmap(cells)
+	return cells
+}
+
+// SortHeatmap orders cells by count descending and by geohash
+func SortHeatmap(cells []HeatmapCell) {
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Count != cells[j].Count {
+			return cells[i].Count > cells[j].Count
+		}
+		return cells[i].Geohash < cells[j].Geohash
+	})
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"math"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage/ring"
+)
+
+// HistoryPoint is a location of the driver at the time in unix nanoseconds
+type HistoryPoint struct {
+	Timestamp int64    `json:"timestamp"`
+	Location  Location `json:"location"`
+}
+
+// HistoryStats describes histories of drivers to tune their size. Evictions counts locations dropped
+// or downsampled and OldestAge is the age of the oldest location in seconds. Stats of all drivers sum
+// locations, capacities and evictions since start, their OldestAge is of the oldest location of all.
+type HistoryStats struct {
+	Locations int     `json:"locations"`
+	Capacity  int     `json:"capacity"`
+	Evictions uint64  `json:"evictions"`
+	OldestAge float64 `json:"oldest_age"`
+}
+
+// WithHistoryMaxAge evicts locations of histories more than maxAge older than the newest location,
+// histories keep up to historySize locations within maxAge then. Zero keeps historySize locations of any age.
+func WithHistoryMaxAge(maxAge time.Duration) Option {
+	return func(s *DriverStorage) {
+		s.historyOpts.MaxAge = maxAge
+	}
+}
+
+// WithHistoryDownsampling keeps every Nth location more than recent older than the newest one in histories,
+// so historySize locations span a longer trajectory of the same shape. Every of 1 or less keeps all locations.
+func WithHistoryDownsampling(recent time.Duration, every int) Option {
+	return func(s *DriverStorage) {
+		s.historyOpts.Recent, s.historyOpts.Every = recent, every
+	}
+}
+
+// newHistory creates a ring buffer of locations of a driver
+func (s *DriverStorage) newHistory() (*ring.Ring, error) {
+	return ring.New(s.historySize, s.historyOpts)
+}
+
+// History returns locations of the driver kept in its history between from and to
+// inclusive ordered by time, zero to means no upper bound
+func (s *DriverStorage) History(id int, from, to int64) ([]HistoryPoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.drivers[id]
+	if !ok {
+		return nil, ErrDriverDoesNotExist
+	}
+	return history(d.history, from, to), nil
+}
+
+// HistoryStats returns stats of the driver history
+func (s *DriverStorage) HistoryStats(id int) (HistoryStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.drivers[id]
+	if !ok {
+		return HistoryStats{}, ErrDriverDoesNotExist
+	}
+	return historyStats(d.history, time.Now().UnixNano()), nil
+}
+
+// HistoryStats returns stats of the driver history kept in its shard
+func (s *ShardedStorage) HistoryStats(id int) (HistoryStats, error) {
+	return s.shard(id).HistoryStats(id)
+}
+
+// History returns locations of the driver kept in its shard
+func (s *ShardedStorage) History(id int, from, to int64) ([]HistoryPoint, error) {
+	return s.shard(id).History(id, from, to)
+}
+
+// history reads the window of the ring, it's safe under the read lock
+func history(locations *ring.Ring, from, to int64) []HistoryPoint {
+	if to == 0 {
+		to = math.MaxInt64
+	}
+	window := locations.Window(from, to)
+	points := make([]HistoryPoint, len(window))
+	for i, p := range window {
+		points[i] = HistoryPoint{Timestamp: p.Timestamp, Location: p.Value.(Location)}
+	}
+	return points
+}
+
+func historyStats(locations *ring.Ring, now int64) HistoryStats {
+	st := HistoryStats{Locations: locations.Len(), Capacity: locations.Cap(), Evictions: locations.Evicted()}
+	if oldest, ok := locations.Oldest(); ok && oldest < now {
+		st.OldestAge = time.Duration(now - oldest).Seconds()
+	}
+	return st
+}
+
+// add sums stats of histories keeping the oldest age
+func (h *HistoryStats) add(st HistoryStats) {
+	h.Locations += st.Locations
+	h.Capacity += st.Capacity
+	h.Evictions += st.Evictions
+	if st.OldestAge > h.OldestAge {
+		h.OldestAge = st.OldestAge
+	}
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"sort"
+	"time"
+)
+
+// idleDistance is a distance in meters a driver must move from where it got idle to be moving again,
+// so GPS jitter of a parked car doesn't reset its idle time
+const idleDistance = 50
+
+// IdleFor returns how long the driver has been available on shift without moving or being reserved
+func (d *Driver) IdleFor(now time.Time) time.Duration {
+	if d.IdleSince == 0 || d.Status != StatusAvailable || d.OffShift || d.ReservedUntil > now.UnixNano() {
+		return 0
+	}
+	if idle := now.UnixNano() - d.IdleSince; idle > 0 {
+		return time.Duration(idle)
+	}
+	return 0
+}
+
+// updateIdle sets idle time of the new location of the driver, it's kept while the driver
+// stays near where it got idle. A reserved driver gets idle when the reservation ends.
+func (d *Driver) updateIdle(prev *Driver) {
+	if d.Status != StatusAvailable || d.OffShift {
+		d.IdleSince, d.idleAt = 0, Location{}
+		return
+	}
+	if prev != nil && prev.IdleSince != 0 && Distance(prev.idleAt, d.LastLocation) <= idleDistance {
+		d.IdleSince, d.idleAt = prev.IdleSince, prev.idleAt
+		return
+	}
+	d.IdleSince, d.idleAt = d.Timestamp, d.LastLocation
+	if d.ReservedUntil > d.IdleSince {
+		d.IdleSince = d.ReservedUntil
+	}
+}
+
+// reserveIdle makes the driver idle from the end of its reservation
+func (d *Driver) reserveIdle() {
+	d.IdleSince, d.idleAt = d.ReservedUntil, d.LastLocation
+}
+
+// statusIdle sets idle time after the status of the driver is changed at now,
+// an available driver released from a reservation is idle from now
+func (d *Driver) statusIdle(now int64) {
+	if d.Status != StatusAvailable || d.OffShift {
+		d.IdleSince, d.idleAt = 0, Location{}
+		return
+	}
+	if d.IdleSince == 0 || d.IdleSince > now {
+		d.IdleSince, d.idleAt = now, d.LastLocation
+	}
+}
+
+// Idle returns up to limit drivers idle for the longest time, longest first
+func (s *DriverStorage) Idle(limit int) []*Driver {
+	return idle(s.ForEach, limit, time.Now())
+}
+
+// Idle returns up to limit drivers of all shards idle for the longest time
+func (s *ShardedStorage) Idle(limit int) []*Driver {
+	return idle(s.ForEach, limit, time.Now())
+}
+
+// Idle returns up to limit drivers of all regions idle for the longest time
+func (s *RegionStorage) Idle(limit int) []*Driver {
+	return idle(s.ForEach, limit, time.Now())
+}
+
+func idle(forEach func(fn func(d *Driver) bool), limit int, now time.Time) []*Driver {
+	var drivers []*Driver
+	forEach(func(d *Driver) bool {
+		if d.IdleFor(now) > 0 {
+			drivers = append(drivers, d)
+		}
+		return true
+	})
+	sort.Slice(drivers, func(i, j int) bool {
+		if drivers[i].IdleSince != drivers[j].IdleSince {
+			return drivers[i].IdleSince < drivers[j].IdleSince
+		}
+		return drivers[i].ID < drivers[j].ID
+	})
+	if len(drivers) > limit {
+		drivers = drivers[:limit]
+	}
+	return drivers
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdle(t *testing.T) {
+	s := New(10)
+	now := time.Now()
+	at := func(d time.Duration) int64 {
+		return now.Add(d).UnixNano()
+	}
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Timestamp: at(-3 * time.Minute)}))
+	assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 2, Lon: 2}, Timestamp: at(-2 * time.Minute)}))
+	assert.NoError(t, s.Set(&Driver{ID: 3, LastLocation: Location{Lat: 3, Lon: 3}, Timestamp: at(-time.Minute), Status: StatusBusy}))
+
+	// jitter keeps the idle time, moving resets it
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1.0001, Lon: 1}, Timestamp: at(-time.Minute)}))
+	assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 2.01, Lon: 2}, Timestamp: at(-time.Minute)}))
+	d, err := s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, at(-3*time.Minute), d.IdleSince)
+	assert.Equal(t, 3*time.Minute, d.IdleFor(now))
+	d, err = s.Get(2)
+	assert.NoError(t, err)
+	assert.Equal(t, at(-time.Minute), d.IdleSince)
+
+	idle := s.Idle(10)
+	if assert.Len(t, idle, 2) {
+		assert.Equal(t, 1, idle[0].ID)
+		assert.Equal(t, 2, idle[1].ID)
+	}
+	assert.Len(t, s.Idle(1), 1)
+
+	// reserved drivers are not idle until the reservation ends
+	reserved := s.NearestAndLock(rtreego.Point{1, 1}, 1, time.Minute)
+	if assert.Len(t, reserved, 1) {
+		assert.Equal(t, time.Duration(0), reserved[0].IdleFor(time.Now()))
+		assert.Equal(t, reserved[0].ReservedUntil, reserved[0].IdleSince)
+	}
+	idle = s.Idle(10)
+	if assert.Len(t, idle, 1) {
+		assert.Equal(t, 2, idle[0].ID)
+	}
+
+	// statuses stop and start idle time
+	assert.NoError(t, s.SetStatus(1, StatusAvailable))
+	assert.NoError(t, s.SetStatus(2, StatusBusy))
+	assert.NoError(t, s.SetStatus(3, StatusAvailable))
+	for id, idle := range map[int]bool{1: true, 2: false, 3: true} {
+		d, err := s.Get(id)
+		assert.NoError(t, err)
+		assert.Equal(t, idle, d.IdleSince != 0, id)
+		assert.True(t, d.IdleSince <= time.Now().UnixNano(), id)
+	}
+}
@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"math"
+
+	"github.com/dhconnelly/rtreego"
+)
+
+// index is a spatial index of drivers used by DriverStorage.
+// Query methods return candidates, callers do the exact filtering and ordering.
+type index interface {
+	// Insert adds the driver or moves it to its current location
+	Insert(d *Driver)
+	Delete(d *Driver) bool
+	// Nearest returns candidates which include at least count nearest drivers
+	// matching the filter, nil filter matches all drivers
+	Nearest(point Location, count int, filter Filter) []*Driver
+	// Search returns candidates which include all drivers inside the bounding box
+	Search(minLat, minLon, maxLat, maxLon float64) []*Driver
+	Stats() IndexStats
+}
+
+// candidatesFactor is how many times more candidates than requested are taken
+// from the rtree before ranking them by great-circle distance
+const candidatesFactor = 4
+
+// moveThreshold is a distance in meters a driver must move to be repositioned
+// in the rtree, so GPS jitter doesn't cause delete and insert on every update.
+// It's far below the rect tolerance, so the stale entry is still found by searches.
+const moveThreshold = 20
+
+// maxRectLat is the latitude rects of drivers are scaled to at most, a degree of longitude
+// is shorter than a kilometer beyond it and rects closer to the poles span too many degrees
+const maxRectLat = 89.99
+
+// metersPerDegree is length of a degree of latitude
+const metersPerDegree = earthRadius * math.Pi / 180
+
+// rtreeParams are branching factors of rtree nodes and a half size in meters of rects of drivers
+type rtreeParams struct {
+	minChildren int
+	maxChildren int
+	tolerance   float64
+}
+
+var defaultRtreeParams = rtreeParams{minChildren: 25, maxChildren: 50, tolerance: rectTolerance}
+
+func (p rtreeParams) newTree(objs ...rtreego.Spatial) *rtreego.Rtree {
+	return rtreego.NewTree(2, p.minChildren, p.maxChildren, objs...)
+}
+
+// moveThreshold returns moveThreshold or a quarter of the tolerance if it's smaller,
+// so stale entries are still found
+func (p rtreeParams) moveThreshold() float64 {
+	return math.Min(moveThreshold, p.tolerance/4)
+}
+
+// rectSize returns half sizes in degrees of the rect with the tolerance in meters at the latitude,
+// degrees of longitude are scaled by its cosine, so rects are of the same size in meters in any city
+func rectSize(lat, tolerance float64) (dLat, dLon float64) {
+	dLat = tolerance / metersPerDegree
+	return dLat, math.Min(180, dLat/math.Cos(math.Min(math.Abs(lat), maxRectLat)*math.Pi/180))
+}
+
+// toleranceRect returns the rect around the location with the tolerance in meters
+func toleranceRect(l Location, tolerance float64) *rtreego.Rect {
+	dLat, dLon := rectSize(l.Lat, tolerance)
+	rect, _ := rtreego.NewRect(rtreego.Point{l.Lat - dLat, l.Lon - dLon}, []float64{2 * dLat, 2 * dLon})
+	return rect
+}
+
+// rtreeIndex keeps drivers in rtree ordered by planar distance in degrees.
+// Drivers are bulk loaded into the loaded rtree which is never modified, since leaves
+// of bulk loaded rtreego trees share the backing array and inserts overwrite neighbours.
+// Drivers moved or deleted since are left there stale and inserted into the tree.
+type rtreeIndex struct {
+	tree      *rtreego.Rtree
+	loaded    *rtreego.Rtree
+	stale     int
+	entries   map[int]*rtreeEntry
+	params    rtreeParams
+	threshold float64
+}
+
+// rtreeEntry is a driver at the location it was inserted to the rtree with,
+// the rtree can't find entries whose bounds changed after insertion.
+// Bounds are computed once, the rtree asks for them many times per insert and delete.
+type rtreeEntry struct {
+	driver   *Driver
+	location Location
+	rect     *rtreego.Rect
+	// loaded entries are in the loaded rtree, stale ones are skipped by queries
+	loaded bool
+	stale  bool
+}
+
+func newRtreeEntry(d *Driver, tolerance float64) *rtreeEntry {
+	e := &rtreeEntry{driver: d}
+	e.move(d.LastLocation, tolerance)
+	return e
+}
+
+func (e *rtreeEntry) move(l Location, tolerance float64) {
+	e.location = l
+	e.rect = toleranceRect(l, tolerance)
+}
+
+func (e *rtreeEntry) Bounds() *rtreego.Rect {
+	return e.rect
+}
+
+// newRtreeIndex bulk loads drivers, it's much faster than inserting them one by one
+func newRtreeIndex(p rtreeParams, drivers []*Driver) index {
+	r := &rtreeIndex{
+		entries:   make(map[int]*rtreeEntry, len(drivers)),
+		params:    p,
+		threshold: p.moveThreshold(),
+	}
+	for _, d := range drivers {
+		r.entries[d.ID] = newRtreeEntry(d, p.tolerance)
+	}
+	r.reload()
+	return r
+}
+
+// reload bulk loads all entries into a new loaded rtree and empties the tree
+func (r *rtreeIndex) reload() {
+	objs := make([]rtreego.Spatial, 0, len(r.entries))
+	for _, e := range r.entries {
+		e.loaded = true
+		objs = append(objs, e)
+	}
+	r.loaded = r.params.newTree(objs...)
+	r.tree = r.params.newTree()
+	r.stale = 0
+}
+
+// unload marks the loaded entry stale and reloads the index once half of the loaded rtree is stale,
+// the entry must be removed from entries before
+func (r *rtreeIndex) unload(e *rtreeEntry) {
+	e.stale = true
+	r.stale++
+	if 2*r.stale >= r.loaded.Size() {
+		r.reload()
+	}
+}
+
+func (r *rtreeIndex) Insert(d *Driver) {
+	e, ok := r.entries[d.ID]
+	if ok && Distance(e.location, d.LastLocation) < r.threshold {
+		e.driver = d
+		return
+	}
+	if ok && !e.loaded {
+		// the entry is reused once it's out of the rtree
+		e.driver = d
+		r.tree.Delete(e)
+		e.move(d.LastLocation, r.params.tolerance)
+		r.tree.Insert(e)
+		return
+	}
+	n := newRtreeEntry(d, r.params.tolerance)
+	r.entries[d.ID] = n
+	r.tree.Insert(n)
+	if ok {
+		r.unload(e)
+	}
+}
+
+func (r *rtreeIndex) Delete(d *Driver) bool {
+	e, ok := r.entries[d.ID]
+	if !ok {
+		return false
+	}
+	delete(r.entries, d.ID)
+	if e.loaded {
+		r.unload(e)
+		return true
+	}
+	return r.tree.Delete(e)
+}
+
+// Nearest takes candidates nearest by planar distance in degrees, which is distorted at high latitudes
+// and doesn't wrap at the antimeridian. Drivers within the great-circle distance of the count-th candidate
+// are searched in boxes around the point unless candidates cover them, so the nearest drivers are among candidates.
+func (r *rtreeIndex) Nearest(point Location, count int, filter Filter) []*Driver {
+	var filters []rtreego.Filter
+	if filter != nil || r.stale > 0 {
+		filters = append(filters, func(results []rtreego.Spatial, object rtreego.Spatial) (bool, bool) {
+			e := object.(*rtreeEntry)
+			return e.stale || filter != nil && !filter(e.driver), false
+		})
+	}
+	p := rtreego.Point{point.Lat, point.Lon}
+	want := count * candidatesFactor
+	loaded := r.loaded.NearestNeighbors(want, p, filters...)
+	var results []rtreego.Spatial
+	if r.tree.Size() > 0 {
+		results = r.tree.NearestNeighbors(want, p, filters...)
+	}
+	drivers := make([]*Driver, 0, len(loaded)+len(results))
+	for _, items := range [][]rtreego.Spatial{loaded, results} {
+		for _, item := range items {
+			if item == nil {
+				continue
+			}
+			drivers = append(drivers, item.(*rtreeEntry).driver)
+		}
+	}
+	if len(drivers) < count {
+		return drivers
+	}
+
+	boxes := boxesAround(point, kthDistance(point, drivers, count))
+	if len(boxes) == 1 && r.covers(point, boxes[0], loaded, want) && r.covers(point, boxes[0], results, want) {
+		return drivers
+	}
+	seen := make(map[int]bool, len(drivers))
+	for _, d := range drivers {
+		seen[d.ID] = true
+	}
+	for _, b := range boxes {
+		for _, d := range r.Search(b[0], b[1], b[2], b[3]) {
+			if !seen[d.ID] && (filter == nil || filter(d)) {
+				seen[d.ID] = true
+				drivers = append(drivers, d)
+			}
+		}
+	}
+	return drivers
+}
+
+// covers returns true if nearest neighbors of an rtree include all its drivers in the box around the point.
+// Neighbors are nearest by planar distance to their rects, so they include all drivers closer than
+// the rect of the farthest of them, or all drivers if there are less neighbors than wanted.
+// Drivers move within the move threshold of their rects, it's below the rect size at the latitude of the box.
+func (r *rtreeIndex) covers(point Location, box [4]float64, neighbors []rtreego.Spatial, want int) bool {
+	n, farthest := 0, 0.0
+	for _, item := range neighbors {
+		if item == nil {
+			continue
+		}
+		n++
+		l := item.(*rtreeEntry).location
+		dLat, dLon := rectSize(l.Lat, r.params.tolerance)
+		farthest = math.Max(farthest, math.Hypot(
+			math.Max(0, math.Abs(l.Lat-point.Lat)-dLat),
+			math.Max(0, math.Abs(l.Lon-point.Lon)-dLon)))
+	}
+	if n < want {
+		return true
+	}
+	_, slack := rectSize(math.Max(math.Abs(box[0]), math.Abs(box[2])), r.params.tolerance)
+	corner := math.Hypot(math.Max(point.Lat-box[0], box[2]-point.Lat), math.Max(point.Lon-box[1], box[3]-point.Lon))
+	return corner+slack < farthest
+}
+
+func (r *rtreeIndex) Search(minLat, minLon, maxLat, maxLon float64) []*Driver {
+	rect, err := rtreego.NewRect(rtreego.Point{minLat, minLon}, []float64{maxLat - minLat, maxLon - minLon})
+	if err != nil {
+		return nil
+	}
+	loaded := r.loaded.SearchIntersect(rect)
+	var items []rtreego.Spatial
+	if r.tree.Size() > 0 {
+		items = r.tree.SearchIntersect(rect)
+	}
+	drivers := make([]*Driver, 0, len(loaded)+len(items))
+	for _, items := range [][]rtreego.Spatial{loaded, items} {
+		for _, item := range items {
+			if e := item.(*rtreeEntry); !e.stale {
+				drivers = append(drivers, e.driver)
+			}
+		}
+	}
+	return drivers
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithRtree(t *testing.T) {
+	s := New(10)
+	assert.Equal(t, defaultRtreeParams, s.rtree)
+
+	s = New(10, WithRtree(10, 15, 100))
+	assert.Equal(t, rtreeParams{minChildren: 10, maxChildren: 20, tolerance: 100}, s.rtree)
+
+	s = New(10, WithRtree(0, 0, 0))
+	assert.Equal(t, defaultRtreeParams, s.rtree)
+
+	// a small tolerance lowers the move threshold, so moved drivers are still found
+	s = New(10, WithRtree(4, 8, 11))
+	assert.Equal(t, 2.75, s.rtree.moveThreshold())
+	for i := 0; i < 100; i++ {
+		s.Set(&Driver{ID: i, LastLocation: Location{Lat: 42.87 + float64(i)*0.001, Lon: 74.59}})
+	}
+	for i := 0; i < 100; i++ {
+		s.Set(&Driver{ID: i, LastLocation: Location{Lat: 42.87 + float64(i)*0.001 + 0.00005, Lon: 74.59}})
+	}
+	drivers, err := s.InBoundingBox(42.9, 74.58, 42.91, 74.6)
+	assert.NoError(t, err)
+	assert.Len(t, drivers, 10)
+	nearest := s.Nearest(rtreego.Point{42.87, 74.59}, 1)
+	if assert.Len(t, nearest, 1) {
+		assert.Equal(t, 0, nearest[0].ID)
+	}
+}
+
+func TestRectSize(t *testing.T) {
+	// rects are of the same size in meters, so they span twice more degrees of longitude at 60 degrees
+	dLat, dLon := rectSize(60, metersPerDegree)
+	assert.InDelta(t, 1, dLat, 1e-9)
+	assert.InDelta(t, 2, dLon, 1e-9)
+	_, dLon = rectSize(-60, metersPerDegree)
+	assert.InDelta(t, 2, dLon, 1e-9)
+	_, dLon = rectSize(90, metersPerDegree)
+	assert.Equal(t, 180.0, dLon)
+}
+
+// indexes are options of every index type
+var indexes = map[string]Option{
+	"rtree":   WithRtree(0, 0, 0),
+	"geohash": WithGeohashIndex(6),
+	"s2":      WithS2Index(13),
+}
+
+func TestAntimeridian(t *testing.T) {
+	for name, opt := range indexes {
+		s := New(10, opt)
+		// Taveuni of Fiji lies on the antimeridian, Suva is west of it
+		s.Set(&Driver{ID: 1, LastLocation: Location{Lat: -16.8, Lon: 179.99}})
+		s.Set(&Driver{ID: 2, LastLocation: Location{Lat: -18.1416, Lon: 178.4419}})
+		for i := 3; i < 10; i++ {
+			s.Set(&Driver{ID: i, LastLocation: Location{Lat: -16.8, Lon: -179.9 + float64(i)*0.01}})
+		}
+
+		nearest := s.Nearest(rtreego.Point{-16.8, -179.99}, 1)
+		if assert.Len(t, nearest, 1, name) {
+			assert.Equal(t, 1, nearest[0].ID, name)
+		}
+		drivers, err := s.InBoundingBox(-19, 178, -16, -179)
+		assert.NoError(t, err, name)
+		assert.Len(t, drivers, 9, name)
+		drivers, err = s.InBoundingBox(-17, 179, -16, -179.95)
+		assert.NoError(t, err, name)
+		assert.Equal(t, []int{1}, ids(drivers), name)
+	}
+}
+
+func TestHighLatitudes(t *testing.T) {
+	for name, opt := range indexes {
+		s := New(10, opt)
+		// a degree of longitude at Uelen of Chukotka is 45 km, drivers north of it are farther
+		// while they are closer in degrees
+		s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 66.16, Lon: -170.81}})
+		for i := 2; i < 10; i++ {
+			s.Set(&Driver{ID: i, LastLocation: Location{Lat: 66.61 + float64(i)*0.01, Lon: -169.81}})
+		}
+		// Anadyr is across the antimeridian
+		s.Set(&Driver{ID: 10, LastLocation: Location{Lat: 64.7337, Lon: 177.5089}})
+
+		nearest := s.Nearest(rtreego.Point{66.16, -169.81}, 1)
+		if assert.Len(t, nearest, 1, name) {
+			assert.Equal(t, 1, nearest[0].ID, name)
+		}
+		drivers, err := s.InBoundingBox(64, 177, 67, -169)
+		assert.NoError(t, err, name)
+		assert.Len(t, drivers, 10, name)
+
+		// nearest drivers at the pole are found at any longitude
+		s.Set(&Driver{ID: 11, LastLocation: Location{Lat: 89.99, Lon: -90}})
+		nearest = s.Nearest(rtreego.Point{89.99, 90}, 1)
+		if assert.Len(t, nearest, 1, name) {
+			assert.Equal(t, 11, nearest[0].ID, name)
+		}
+	}
+}
+
+func TestRtreeIndexLoaded(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := func(id int) *Driver {
+		return &Driver{ID: id, LastLocation: Location{Lat: 42.78 + rnd.Float64()*0.18, Lon: 74.5 + rnd.Float64()*0.24}}
+	}
+	drivers := make(map[int]*Driver)
+	var loaded []*Driver
+	for i := 0; i < 1000; i++ {
+		drivers[i] = random(i)
+		loaded = append(loaded, drivers[i])
+	}
+	r := newRtreeIndex(defaultRtreeParams, loaded).(*rtreeIndex)
+	assert.Equal(t, 1000, r.loaded.Size())
+	assert.Equal(t, 0, r.tree.Size())
+
+	// moves and deletes leave loaded entries stale until most of them are reloaded
+	reloads := 0
+	for i := 0; i < 3000; i++ {
+		id := rnd.Intn(1200)
+		if d, ok := drivers[id]; ok && rnd.Intn(4) == 0 {
+			assert.True(t, r.Delete(d))
+			delete(drivers, id)
+		} else {
+			drivers[id] = random(id)
+			r.Insert(drivers[id])
+		}
+		if r.tree.Size() == 0 {
+			reloads++
+		}
+		if i%100 != 0 {
+			continue
+		}
+		assert.Equal(t, len(drivers), r.Stats().Size)
+		var expected []int
+		for id, d := range drivers {
+			if d.LastLocation.Lat >= 42.85 && d.LastLocation.Lat <= 42.9 && d.LastLocation.Lon >= 74.55 && d.LastLocation.Lon <= 74.65 {
+				expected = append(expected, id)
+			}
+		}
+		found := make(map[int]bool)
+		for _, d := range r.Search(42.85, 74.55, 42.9, 74.65) {
+			found[d.ID] = true
+		}
+		for _, id := range expected {
+			assert.True(t, found[id], "driver %d is not found", id)
+		}
+		for id := range found {
+			assert.Contains(t, drivers, id)
+		}
+		point := Location{Lat: 42.87, Lon: 74.6}
+		nearest := r.Nearest(point, 5, nil)
+		SortByDistance(point, nearest)
+		var closest *Driver
+		for _, d := range drivers {
+			if closest == nil || Distance(point, d.LastLocation) < Distance(point, closest.LastLocation) {
+				closest = d
+			}
+		}
+		if assert.NotEmpty(t, nearest) {
+			assert.Equal(t, closest.ID, nearest[0].ID)
+		}
+	}
+	assert.True(t, reloads > 0)
+}
+
+// rtreeSettings are compared by BenchmarkRtreeSettings, the first one is the default
+var rtreeSettings = []struct {
+	minChildren, maxChildren int
+	tolerance                float64
+}{
+	{25, 50, 1100},
+	{4, 8, 1100},
+	{10, 20, 1100},
+	{50, 100, 1100},
+	{25, 50, 110},
+	{25, 50, 11},
+}
+
+// newSampleStorage spreads drivers over about 20 by 20 km around Bishkek with a fixed seed
+func newSampleStorage(drivers int, opts ...Option) *DriverStorage {
+	rnd := rand.New(rand.NewSource(1))
+	s := New(1, opts...)
+	for i := 0; i < drivers; i++ {
+		s.Set(&Driver{ID: i, LastLocation: Location{Lat: 42.78 + rnd.Float64()*0.18, Lon: 74.5 + rnd.Float64()*0.24}})
+	}
+	return s
+}
+
+// BenchmarkRtreeSettings reports latency of queries for settings of the rtree on the sample dataset,
+// run it with -bench RtreeSettings to pick rtree flags
+func BenchmarkRtreeSettings(b *testing.B) {
+	for _, st := range rtreeSettings {
+		s := newSampleStorage(10000, WithRtree(st.minChildren, st.maxChildren, st.tolerance))
+		rnd := rand.New(rand.NewSource(2))
+		name := fmt.Sprintf("min=%d/max=%d/tolerance=%g", st.minChildren, st.maxChildren, st.tolerance)
+		b.Run(name+"/nearest", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s.Nearest(rtreego.Point{42.78 + rnd.Float64()*0.18, 74.5 + rnd.Float64()*0.24}, 10)
+			}
+		})
+		b.Run(name+"/bounding_box", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				lat, lon := 42.78+rnd.Float64()*0.16, 74.5+rnd.Float64()*0.22
+				s.InBoundingBox(lat, lon, lat+0.02, lon+0.02)
+			}
+		})
+		b.Run(name+"/set", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s.Set(&Driver{ID: i % 10000, LastLocation: Location{Lat: 42.78 + rnd.Float64()*0.18, Lon: 74.5 + rnd.Float64()*0.24}})
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// janitorStuckIntervals is how many intervals a cleanup may take before the janitor is considered stuck
+const janitorStuckIntervals = 3
+
+var (
+	// ErrJanitorStopped sign what janitor is stopped
+	ErrJanitorStopped = errors.New("Janitor is stopped")
+	// ErrJanitorStuck sign what janitor has not finished a cleanup for several intervals
+	ErrJanitorStuck = errors.New("Janitor is stuck")
+)
+
+// Expirer removes expired drivers, it's implemented by storages and Manager
+type Expirer interface {
+	DeleteExpired()
+}
+
+// Janitor periodically removes expired drivers from storage
+type Janitor struct {
+	storage  Expirer
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+	// last is unix nanoseconds of the last finished cleanup, updated atomically
+	last int64
+}
+
+// StartJanitor starts removing expired drivers from s every interval
+func StartJanitor(s Expirer, interval time.Duration) *Janitor {
+	j := &Janitor{
+		storage:  s,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		last:     time.Now().UnixNano(),
+	}
+	go j.run()
+	return j
+}
+
+func (j *Janitor) run() {
+	defer close(j.done)
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			j.storage.DeleteExpired()
+			atomic.StoreInt64(&j.last, time.Now().UnixNano())
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// Check returns ErrJanitorStopped or ErrJanitorStuck if expired drivers are not removed anymore
+func (j *Janitor) Check() error {
+	select {
+	case <-j.done:
+		return ErrJanitorStopped
+	default:
+	}
+	last := time.Unix(0, atomic.LoadInt64(&j.last))
+	if time.Since(last) > janitorStuckIntervals*j.interval {
+		return ErrJanitorStuck
+	}
+	return nil
+}
+
+// Stop stops the janitor and waits until the running cleanup is finished
+func (j *Janitor) Stop() {
+	close(j.stop)
+	<-j.done
+}
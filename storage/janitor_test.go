@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJanitor(t *testing.T) {
+	s := New(10)
+	s.Set(&Driver{
+		ID:         123,
+		Expiration: time.Now().Add(10 * time.Millisecond).UnixNano(),
+	})
+	s.Set(&Driver{ID: 321})
+
+	j := StartJanitor(s, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	j.Stop()
+
+	_, err := s.Get(123)
+	assert.Equal(t, ErrDriverDoesNotExist, err)
+	_, err = s.Get(321)
+	assert.NoError(t, err)
+}
+
+type blockingExpirer chan struct{}
+
+func (b blockingExpirer) DeleteExpired() {
+	<-b
+}
+
+func TestJanitorCheck(t *testing.T) {
+	j := StartJanitor(New(10), 5*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	assert.NoError(t, j.Check())
+	j.Stop()
+	assert.Equal(t, ErrJanitorStopped, j.Check())
+
+	block := make(blockingExpirer)
+	j = StartJanitor(block, 5*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, ErrJanitorStuck, j.Check())
+	close(block)
+	j.Stop()
+}
@@ -0,0 +1,54 @@
+package storage
+
+// kalman smooths driver's locations with a Kalman filter assuming
+// constant position with random movement, variance is in square meters
+type kalman struct {
+	noise     float64 // process noise in meters per second
+	accuracy  float64 // measurement error in meters
+	location  Location
+	variance  float64
+	timestamp int64
+}
+
+// WithKalmanFilter smooths incoming locations of every driver before they are stored.
+// Noise is how fast in meters per second drivers are expected to move,
+// accuracy is the typical GPS error in meters. It's disabled if noise is not positive.
+func WithKalmanFilter(noise, accuracy float64) Option {
+	return func(s *DriverStorage) {
+		s.kalmanNoise = noise
+		s.kalmanAccuracy = accuracy
+	}
+}
+
+// smooth returns the filtered location of the driver
+func (s *DriverStorage) smooth(driver *Driver) Location {
+	var k *kalman
+	if d, ok := s.drivers[driver.ID]; ok {
+		k = d.kalman
+	}
+	if k == nil {
+		k = &kalman{noise: s.kalmanNoise, accuracy: s.kalmanAccuracy}
+		driver.kalman = k
+	}
+	return k.update(driver.LastLocation, driver.Timestamp)
+}
+
+func (k *kalman) update(l Location, ts int64) Location {
+	accuracy := k.accuracy * k.accuracy
+	if k.variance == 0 {
+		k.location = l
+		k.variance = accuracy
+		k.timestamp = ts
+		return l
+	}
+
+	if dt := float64(ts-k.timestamp) / 1e9; dt > 0 {
+		k.variance += dt * k.noise * k.noise
+		k.timestamp = ts
+	}
+	gain := k.variance / (k.variance + accuracy)
+	k.location.Lat += gain * (l.Lat - k.location.Lat)
+	k.location.Lon += gain * (l.Lon - k.location.Lon)
+	k.variance = (1 - gain) * k.variance
+	return k.location
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKalmanFilter(t *testing.T) {
+	s := New(10, WithKalmanFilter(1, 10))
+	origin := Location{Lat: 42.87, Lon: 74.58}
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: origin, Timestamp: int64(time.Second)}))
+
+	// a jump of about 100 meters in a second moves the location about half way
+	jump := Location{Lat: 42.871, Lon: 74.58}
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: jump, Timestamp: int64(2 * time.Second)}))
+	d, err := s.Get(1)
+	assert.NoError(t, err)
+	assert.True(t, d.LastLocation.Lat > origin.Lat)
+	assert.True(t, Distance(origin, d.LastLocation) < 0.6*Distance(origin, jump))
+
+	// without smoothing the location is stored as is
+	s = New(10)
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: origin, Timestamp: int64(time.Second)}))
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: jump, Timestamp: int64(2 * time.Second)}))
+	d, err = s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, jump, d.LastLocation)
+}
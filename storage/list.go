@@ -0,0 +1,65 @@
+package storage
+
+import "sort"
+
+// ForEach calls fn for every driver until it returns false.
+// The storage is read locked during the iteration, so fn must not modify it.
+func (s *DriverStorage) ForEach(fn func(d *Driver) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, d := range s.drivers {
+		if !fn(d) {
+			return
+		}
+	}
+}
+
+// List returns up to limit drivers with ID greater than after ordered by ID.
+// ID of the last returned driver is the cursor of the next page.
+func (s *DriverStorage) List(after, limit int) []*Driver {
+	if limit <= 0 {
+		return nil
+	}
+
+	var drivers []*Driver
+	s.ForEach(func(d *Driver) bool {
+		if d.ID > after {
+			drivers = append(drivers, d)
+		}
+		return true
+	})
+	return firstByID(drivers, limit)
+}
+
+// ForEach calls fn for every driver of every shard until it returns false
+func (s *ShardedStorage) ForEach(fn func(d *Driver) bool) {
+	stopped := false
+	for _, shard := range s.shards {
+		shard.ForEach(func(d *Driver) bool {
+			stopped = !fn(d)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// List merges pages of all shards
+func (s *ShardedStorage) List(after, limit int) []*Driver {
+	var drivers []*Driver
+	for _, shard := range s.shards {
+		drivers = append(drivers, shard.List(after, limit)...)
+	}
+	return firstByID(drivers, limit)
+}
+
+// firstByID sorts drivers by ID and truncates them to limit
+func firstByID(drivers []*Driver, limit int) []*Driver {
+	sort.Slice(drivers, func(i, j int) bool { return drivers[i].ID < drivers[j].ID })
+	if len(drivers) > limit {
+		drivers = drivers[:limit]
+	}
+	return drivers
+}
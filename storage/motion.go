@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"math"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage/ring"
+)
+
+// Bearing returns initial great-circle bearing from a to b in degrees clockwise from north
+func Bearing(a, b Location) float64 {
+	lat1 := a.Lat * math.Pi / 180
+	lat2 := b.Lat * math.Pi / 180
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	bearing := math.Atan2(y, x) * 180 / math.Pi
+	return math.Mod(bearing+360, 360)
+}
+
+// Destination returns location reached from the origin after distance meters along the great circle
+// of the initial bearing in degrees clockwise from north
+func Destination(origin Location, bearing, distance float64) Location {
+	lat1 := origin.Lat * math.Pi / 180
+	lon1 := origin.Lon * math.Pi / 180
+	theta := bearing * math.Pi / 180
+	delta := distance / earthRadius
+
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(delta) + math.Cos(lat1)*math.Sin(delta)*math.Cos(theta))
+	lon2 := lon1 + math.Atan2(math.Sin(theta)*math.Sin(delta)*math.Cos(lat1), math.Cos(delta)-math.Sin(lat1)*math.Sin(lat2))
+	// longitude is normalized to [-180, 180)
+	lon := math.Mod(lon2*180/math.Pi+540, 360) - 180
+	return Location{Lat: lat2 * 180 / math.Pi, Lon: lon}
+}
+
+// Motion returns speed in meters per second and heading in degrees between
+// two newest locations of the history, false if there are less than two
+func Motion(history *ring.Ring) (speed, heading float64, ok bool) {
+	var newest, previous int64
+	var a, b Location
+	found := 0
+	// locations are ordered by time
+	history.Each(func(ts int64, value interface{}) bool {
+		previous, newest = newest, ts
+		a, b = b, value.(Location)
+		found++
+		return true
+	})
+	if found < 2 {
+		return 0, 0, false
+	}
+
+	elapsed := time.Duration(newest - previous).Seconds()
+	return Distance(a, b) / elapsed, Bearing(a, b), true
+}
+
+// updateMotion sets speed and heading from the history,
+// heading is kept while the driver stands still
+func (d *Driver) updateMotion() {
+	speed, heading, ok := Motion(d.history)
+	if !ok {
+		return
+	}
+	d.Speed = speed
+	if speed > 0 {
+		d.Heading = heading
+	}
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage/ring"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBearing(t *testing.T) {
+	origin := Location{Lat: 42, Lon: 74}
+	assert.InDelta(t, 0, Bearing(origin, Location{Lat: 43, Lon: 74}), 1e-9)
+	assert.InDelta(t, 180, Bearing(origin, Location{Lat: 41, Lon: 74}), 1e-9)
+	assert.InDelta(t, 90, Bearing(Location{Lat: 0, Lon: 0}, Location{Lat: 0, Lon: 1}), 1e-9)
+	assert.InDelta(t, 270, Bearing(Location{Lat: 0, Lon: 0}, Location{Lat: 0, Lon: -1}), 1e-9)
+}
+
+func TestDestination(t *testing.T) {
+	origin := Location{Lat: 42, Lon: 74}
+	for _, bearing := range []float64{0, 45, 135, 270} {
+		to := Destination(origin, bearing, 10000)
+		assert.InDelta(t, 10000, Distance(origin, to), 1e-6)
+		assert.InDelta(t, bearing, Bearing(origin, to), 0.1)
+	}
+	assert.InDelta(t, 0, Distance(origin, Destination(origin, 90, 0)), 1e-6)
+
+	// crossing the antimeridian
+	to := Destination(Location{Lat: 0, Lon: 179.9}, 90, 50000)
+	assert.True(t, to.Lon < -179)
+}
+
+func TestMotion(t *testing.T) {
+	history, err := ring.New(10, ring.Options{})
+	assert.NoError(t, err)
+
+	history.Add(0, Location{Lat: 42, Lon: 74})
+	_, _, ok := Motion(history)
+	assert.False(t, ok)
+
+	// one degree to the north in an hour, an older location added last is ignored
+	history.Add(int64(time.Hour), Location{Lat: 43, Lon: 74})
+	history.Add(int64(-time.Hour), Location{Lat: 0, Lon: 0})
+	speed, heading, ok := Motion(history)
+	assert.True(t, ok)
+	assert.InDelta(t, 111195.0/3600, speed, 0.01)
+	assert.InDelta(t, 0, heading, 1e-9)
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidNamespace sign what namespace name is not 1-64 of lowercase letters, digits, '_' and '-'
+	ErrInvalidNamespace = errors.New("Invalid namespace")
+	// ErrNamespacesDisabled sign what storage has no namespaces except the default one
+	ErrNamespacesDisabled = errors.New("Namespaces are disabled")
+	// ErrNamespaceNotFound sign what namespace is not created yet, only writes create namespaces
+	ErrNamespaceNotFound = errors.New("Namespace does not exist")
+	// ErrTooManyNamespaces sign what the namespace can't be created as there are max of them already
+	ErrTooManyNamespaces = errors.New("Too many namespaces")
+)
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+// ValidNamespace returns true if name can be used as a namespace,
+// names are safe to be used in file paths
+func ValidNamespace(name string) bool {
+	return namespacePattern.MatchString(name)
+}
+
+type (
+	// Manager keeps isolated storages of drivers keyed by namespace, e.g. a fleet or a city.
+	// The default namespace has an empty name, others are created on first write.
+	Manager struct {
+		mu      sync.RWMutex
+		def     Storage
+		spaces  map[string]Storage
+		factory func(namespace string) (Storage, error)
+		max     int
+	}
+
+	// ManagerOption configures Manager
+	ManagerOption func(*Manager)
+)
+
+// WithMaxNamespaces limits number of namespaces except the default one, 0 is unlimited
+func WithMaxNamespaces(max int) ManagerOption {
+	return func(m *Manager) {
+		m.max = max
+	}
+}
+
+// NewManager creates Manager with the default storage and factory of other namespaces,
+// nil factory disables namespaces except the default one
+func NewManager(def Storage, factory func(namespace string) (Storage, error), opts ...ManagerOption) *Manager {
+	m := &Manager{
+		def:     def,
+		spaces:  make(map[string]Storage),
+		factory: factory,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Lookup returns storage of the namespace without creating it, queries of unknown namespaces don't create them
+func (m *Manager) Lookup(name string) (Storage, error) {
+	if name == "" {
+		return m.def, nil
+	}
+	if !ValidNamespace(name) {
+		return nil, ErrInvalidNamespace
+	}
+	if m.factory == nil {
+		return nil, ErrNamespacesDisabled
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if s, ok := m.spaces[name]; ok {
+		return s, nil
+	}
+	return nil, ErrNamespaceNotFound
+}
+
+// Namespace returns storage of the namespace creating it if needed
+func (m *Manager) Namespace(name string) (Storage, error) {
+	s, err := m.Lookup(name)
+	if err != ErrNamespaceNotFound {
+		return s, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if s, ok := m.spaces[name]; ok {
+		return s, nil
+	}
+	if m.max > 0 && len(m.spaces) >= m.max {
+		return nil, ErrTooManyNamespaces
+	}
+	s, err = m.factory(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create namespace %s", name)
+	}
+	m.spaces[name] = s
+	return s, nil
+}
+
+// Namespaces returns names of created namespaces except the default one in order
+func (m *Manager) Namespaces() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := make([]string, 0, len(m.spaces))
+	for name := range m.spaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Each calls fn for the default namespace and every created one
+func (m *Manager) Each(fn func(name string, s Storage)) {
+	fn("", m.def)
+	for _, name := range m.Namespaces() {
+		m.mu.RLock()
+		s := m.spaces[name]
+		m.mu.RUnlock()
+		fn(name, s)
+	}
+}
+
+// DeleteExpired removes expired drivers from all namespaces
+func (m *Manager) DeleteExpired() {
+	m.Each(func(_ string, s Storage) {
+		s.DeleteExpired()
+	})
+}
+
+// Ping checks backends of all namespaces implementing Pinger are reachable
+func (m *Manager) Ping(ctx context.Context) error {
+	var err error
+	m.Each(func(name string, s Storage) {
+		if p, ok := s.(Pinger); ok && err == nil {
+			err = p.Ping(ctx)
+		}
+	})
+	return err
+}
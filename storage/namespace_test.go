@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager(t *testing.T) {
+	def := New(10)
+	m := NewManager(def, func(string) (Storage, error) { return New(10), nil })
+
+	s, err := m.Namespace("")
+	assert.NoError(t, err)
+	assert.Equal(t, Storage(def), s)
+
+	bishkek, err := m.Namespace("bishkek")
+	assert.NoError(t, err)
+	almaty, err := m.Namespace("almaty")
+	assert.NoError(t, err)
+	again, err := m.Namespace("bishkek")
+	assert.NoError(t, err)
+	assert.True(t, bishkek == again)
+
+	assert.NoError(t, bishkek.Set(&Driver{ID: 1, LastLocation: Location{Lat: 42.87, Lon: 74.59}}))
+	_, err = almaty.Get(1)
+	assert.Equal(t, ErrDriverDoesNotExist, err)
+	_, err = def.Get(1)
+	assert.Equal(t, ErrDriverDoesNotExist, err)
+
+	assert.Equal(t, []string{"almaty", "bishkek"}, m.Namespaces())
+	_, err = m.Namespace("../etc")
+	assert.Equal(t, ErrInvalidNamespace, err)
+
+	disabled := NewManager(def, nil)
+	_, err = disabled.Namespace("bishkek")
+	assert.Equal(t, ErrNamespacesDisabled, err)
+	_, err = disabled.Lookup("bishkek")
+	assert.Equal(t, ErrNamespacesDisabled, err)
+}
+
+func TestManagerLookup(t *testing.T) {
+	def := New(10)
+	m := NewManager(def, func(string) (Storage, error) { return New(10), nil }, WithMaxNamespaces(1))
+
+	s, err := m.Lookup("")
+	assert.NoError(t, err)
+	assert.Equal(t, Storage(def), s)
+	// lookups don't create namespaces
+	_, err = m.Lookup("bishkek")
+	assert.Equal(t, ErrNamespaceNotFound, err)
+	assert.Empty(t, m.Namespaces())
+	_, err = m.Lookup("../etc")
+	assert.Equal(t, ErrInvalidNamespace, err)
+
+	bishkek, err := m.Namespace("bishkek")
+	assert.NoError(t, err)
+	s, err = m.Lookup("bishkek")
+	assert.NoError(t, err)
+	assert.True(t, bishkek == s)
+
+	_, err = m.Namespace("almaty")
+	assert.Equal(t, ErrTooManyNamespaces, err)
+	_, err = m.Namespace("bishkek")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bishkek"}, m.Namespaces())
+}
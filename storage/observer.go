@@ -0,0 +1,83 @@
+package storage
+
+// Observer is notified about drivers movements and removals.
+// It's called under the storage lock, so it must be fast and must not call the storage.
+// Replays of the WAL and snapshots are not observed.
+type Observer interface {
+	DriverMoved(id int, location Location, ts int64)
+	DriverRemoved(id int)
+}
+
+// LifecycleObserver is an Observer also notified about drivers appearing, expiring and changing status.
+// DriverAppeared is called before DriverMoved of the first location,
+// DriverExpired is called instead of DriverRemoved for expired drivers.
+type LifecycleObserver interface {
+	Observer
+	DriverAppeared(id int, location Location, ts int64)
+	DriverExpired(id int)
+	DriverStatusChanged(id int, status Status)
+}
+
+// StateObserver is an Observer also notified about the whole driver after every set,
+// e.g. to copy it to replicas. The driver must not be kept or modified.
+type StateObserver interface {
+	Observer
+	DriverChanged(d *Driver)
+}
+
+// WithObserver adds an observer of the storage changes
+func WithObserver(o Observer) Option {
+	return func(s *DriverStorage) {
+		s.observers = append(s.observers, o)
+	}
+}
+
+func (s *DriverStorage) notifyAppeared(d *Driver) {
+	for _, o := range s.observers {
+		if lo, ok := o.(LifecycleObserver); ok {
+			lo.DriverAppeared(d.ID, d.LastLocation, d.Timestamp)
+		}
+	}
+}
+
+func (s *DriverStorage) notifyMoved(d *Driver) {
+	for _, o := range s.observers {
+		o.DriverMoved(d.ID, d.LastLocation, d.Timestamp)
+		if so, ok := o.(StateObserver); ok {
+			so.DriverChanged(d)
+		}
+	}
+}
+
+// notifyChanged notifies state observers about changes of drivers not moving them
+func (s *DriverStorage) notifyChanged(d *Driver) {
+	for _, o := range s.observers {
+		if so, ok := o.(StateObserver); ok {
+			so.DriverChanged(d)
+		}
+	}
+}
+
+func (s *DriverStorage) notifyRemoved(id int) {
+	for _, o := range s.observers {
+		o.DriverRemoved(id)
+	}
+}
+
+func (s *DriverStorage) notifyExpired(id int) {
+	for _, o := range s.observers {
+		if lo, ok := o.(LifecycleObserver); ok {
+			lo.DriverExpired(id)
+		} else {
+			o.DriverRemoved(id)
+		}
+	}
+}
+
+func (s *DriverStorage) notifyStatus(id int, status Status) {
+	for _, o := range s.observers {
+		if lo, ok := o.(LifecycleObserver); ok {
+			lo.DriverStatusChanged(id, status)
+		}
+	}
+}
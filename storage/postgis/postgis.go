@@ -0,0 +1,608 @@
+package postgis
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/kdrake/nearestdots/storage/ring"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const schema = `
+CREATE EXTENSION IF NOT EXISTS postgis;
+CREATE TABLE IF NOT EXISTS drivers (
+	id         integer PRIMARY KEY,
+	location   geography(Point, 4326) NOT NULL,
+	expiration bigint NOT NULL DEFAULT 0
+);
+ALTER TABLE drivers ADD COLUMN IF NOT EXISTS attributes jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE drivers ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'available';
+ALTER TABLE drivers ADD COLUMN IF NOT EXISTS ts bigint NOT NULL DEFAULT 0;
+ALTER TABLE drivers ADD COLUMN IF NOT EXISTS reserved_until bigint NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS drivers_location_idx ON drivers USING GIST (location);
+CREATE TABLE IF NOT EXISTS driver_locations (
+	driver_id integer NOT NULL REFERENCES drivers (id) ON DELETE CASCADE,
+	ts        bigint NOT NULL,
+	location  geography(Point, 4326) NOT NULL,
+	PRIMARY KEY (driver_id, ts)
+);`
+
+// Storage keeps drivers in PostgreSQL with PostGIS,
+// so the service itself can run stateless
+type Storage struct {
+	db          *sql.DB
+	historySize int
+	ttl         time.Duration
+	// counters are updated atomically
+	inserted uint64
+	updated  uint64
+	deleted  uint64
+	expired  uint64
+}
+
+var (
+	_ storage.Storage = (*Storage)(nil)
+	_ storage.Pinger  = (*Storage)(nil)
+)
+
+// New connects to the database and creates the schema if it does not exist.
+// historySize limits the location history kept per driver,
+// ttl is expiration of drivers set without one, zero disables it.
+func New(dsn string, historySize int, ttl time.Duration) (*Storage, error) {
+	if historySize <= 0 {
+		return nil, errors.New("Size must be greater than 0")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open database")
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "could not create schema")
+	}
+	return &Storage{db: db, historySize: historySize, ttl: ttl}, nil
+}
+
+// Close closes the database connection
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks the database is reachable
+func (s *Storage) Ping(ctx context.Context) error {
+	return errors.Wrap(s.db.PingContext(ctx), "could not reach database")
+}
+
+// Set an Driver to the storage, replacing any existing item.
+func (s *Storage) Set(driver *storage.Driver) error {
+	return s.apply([]*storage.Driver{driver}, false)
+}
+
+// SetMany sets drivers in a single transaction in order of their timestamps,
+// stale locations are skipped
+func (s *Storage) SetMany(drivers []*storage.Driver) error {
+	return s.apply(storage.ByTimestamp(drivers), true)
+}
+
+func (s *Storage) apply(drivers []*storage.Driver, skipStale bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "could not begin transaction")
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixNano()
+	var inserted, updated uint64
+	for _, driver := range drivers {
+		isNew, err := s.set(tx, driver, now)
+		if err == storage.ErrStaleLocation && skipStale {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if isNew {
+			inserted++
+		} else {
+			updated++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "could not commit transaction")
+	}
+	atomic.AddUint64(&s.inserted, inserted)
+	atomic.AddUint64(&s.updated, updated)
+	return nil
+}
+
+// set saves the driver in the transaction and returns true if it's a new one
+func (s *Storage) set(tx *sql.Tx, driver *storage.Driver, now int64) (bool, error) {
+	if !driver.LastLocation.Valid() {
+		return false, storage.ErrInvalidLocation
+	}
+	if driver.Status != "" && !driver.Status.Valid() {
+		return false, storage.ErrInvalidStatus
+	}
+	if driver.Timestamp == 0 {
+		driver.Timestamp = now
+	}
+	if driver.Expiration == 0 && s.ttl > 0 {
+		driver.Expiration = now + int64(s.ttl)
+	}
+
+	// nil attributes keep the stored ones
+	var attributes []byte
+	if driver.Attributes != nil {
+		var err error
+		if attributes, err = json.Marshal(driver.Attributes); err != nil {
+			return false, errors.Wrap(err, "could not encode attributes")
+		}
+	}
+
+	lat, lon := driver.LastLocation.Lat, driver.LastLocation.Lon
+	// xmax is zero for rows inserted rather than updated,
+	// no row is returned if the stored location is newer
+	var inserted bool
+	err := tx.QueryRow(`
+		INSERT INTO drivers (id, location, expiration, attributes, status, ts)
+		VALUES ($1, ST_SetSRID(ST_MakePoint($3, $2), 4326)::geography, $4, COALESCE($5::jsonb, '{}'),
+			COALESCE(NULLIF($6, ''), 'available'), $7)
+		ON CONFLICT (id) DO UPDATE SET
+			location = EXCLUDED.location,
+			expiration = EXCLUDED.expiration,
+			attributes = COALESCE($5::jsonb, drivers.attributes),
+			status = COALESCE(NULLIF($6, ''), drivers.status),
+			ts = EXCLUDED.ts
+		WHERE drivers.ts <= EXCLUDED.ts
+		RETURNING xmax = 0`,
+		driver.ID, lat, lon, driver.Expiration, attributes, string(driver.Status), driver.Timestamp).Scan(&inserted)
+	if err == sql.ErrNoRows {
+		return false, storage.ErrStaleLocation
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "could not save driver")
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO driver_locations (driver_id, ts, location)
+		VALUES ($1, $2, ST_SetSRID(ST_MakePoint($4, $3), 4326)::geography)
+		ON CONFLICT (driver_id, ts) DO UPDATE SET location = EXCLUDED.location`,
+		driver.ID, driver.Timestamp, lat, lon)
+	if err != nil {
+		return false, errors.Wrap(err, "could not save location")
+	}
+
+	// keep only historySize newest locations like the in-memory history does
+	_, err = tx.Exec(`
+		DELETE FROM driver_locations WHERE driver_id = $1 AND ts < (
+			SELECT ts FROM driver_locations WHERE driver_id = $1
+			ORDER BY ts DESC OFFSET $2 - 1 LIMIT 1
+		)`, driver.ID, s.historySize)
+	return inserted, errors.Wrap(err, "could not trim locations")
+}
+
+// Get gets driver from storage and an error if nothing found
+func (s *Storage) Get(id int) (*storage.Driver, error) {
+	drivers, err := s.queryDrivers(`
+		SELECT `+driverColumns+`
+		FROM drivers WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(drivers) == 0 {
+		return nil, storage.ErrDriverDoesNotExist
+	}
+	d := drivers[0]
+
+	d.Locations, err = s.locations(id)
+	if err != nil {
+		return nil, err
+	}
+	if speed, heading, ok := storage.Motion(d.Locations); ok {
+		d.Speed, d.Heading = speed, heading
+	}
+	return d, nil
+}
+
+func (s *Storage) locations(id int) (*ring.Ring, error) {
+	cache, err := ring.New(s.historySize, ring.Options{})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create location history")
+	}
+	rows, err := s.db.Query(`
+		SELECT ts, ST_Y(location::geometry), ST_X(location::geometry)
+		FROM driver_locations WHERE driver_id = $1 ORDER BY ts`, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get locations")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ts int64
+		var l storage.Location
+		if err := rows.Scan(&ts, &l.Lat, &l.Lon); err != nil {
+			return nil, errors.Wrap(err, "could not scan location")
+		}
+		cache.Add(ts, l)
+	}
+	return cache, errors.Wrap(rows.Err(), "could not get locations")
+}
+
+// List returns up to limit drivers with ID greater than after ordered by ID
+func (s *Storage) List(after, limit int) []*storage.Driver {
+	if limit <= 0 {
+		return nil
+	}
+	drivers, err := s.queryDrivers(`
+		SELECT `+driverColumns+`
+		FROM drivers
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2`,
+		after, limit)
+	if err != nil {
+		zap.L().Error("could not list drivers", zap.Error(err))
+	}
+	return drivers
+}
+
+// History returns persisted locations of the driver between from and to
+// inclusive ordered by time, zero to means no upper bound
+func (s *Storage) History(id int, from, to int64) ([]storage.HistoryPoint, error) {
+	var exists bool
+	if err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM drivers WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return nil, errors.Wrap(err, "could not get driver")
+	}
+	if !exists {
+		return nil, storage.ErrDriverDoesNotExist
+	}
+
+	rows, err := s.db.Query(`
+		SELECT ts, ST_Y(location::geometry), ST_X(location::geometry)
+		FROM driver_locations
+		WHERE driver_id = $1 AND ts >= $2 AND ($3::bigint = 0 OR ts <= $3::bigint)
+		ORDER BY ts`, id, from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get locations")
+	}
+	defer rows.Close()
+
+	points := []storage.HistoryPoint{}
+	for rows.Next() {
+		var p storage.HistoryPoint
+		if err := rows.Scan(&p.Timestamp, &p.Location.Lat, &p.Location.Lon); err != nil {
+			return nil, errors.Wrap(err, "could not scan location")
+		}
+		points = append(points, p)
+	}
+	return points, errors.Wrap(rows.Err(), "could not get locations")
+}
+
+// Delete deletes a driver from storage.
+func (s *Storage) Delete(id int) error {
+	res, err := s.db.Exec(`DELETE FROM drivers WHERE id = $1`, id)
+	if err != nil {
+		return errors.Wrap(err, "could not delete driver")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "could not delete driver")
+	}
+	if n == 0 {
+		return storage.ErrDriverDoesNotExist
+	}
+	atomic.AddUint64(&s.deleted, uint64(n))
+	return nil
+}
+
+// SetStatus changes status of the driver
+func (s *Storage) SetStatus(id int, status storage.Status) error {
+	if !status.Valid() {
+		return storage.ErrInvalidStatus
+	}
+	res, err := s.db.Exec(`UPDATE drivers SET status = $2, reserved_until = 0 WHERE id = $1`, id, string(status))
+	if err != nil {
+		return errors.Wrap(err, "could not set status")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "could not set status")
+	}
+	if n == 0 {
+		return storage.ErrDriverDoesNotExist
+	}
+	return nil
+}
+
+// DeleteMany deletes drivers, skipping missing ones
+func (s *Storage) DeleteMany(ids []int) error {
+	values := make([]int64, len(ids))
+	for i, id := range ids {
+		values[i] = int64(id)
+	}
+	res, err := s.db.Exec(`DELETE FROM drivers WHERE id = ANY($1)`, pq.Array(values))
+	if err != nil {
+		return errors.Wrap(err, "could not delete drivers")
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "could not delete drivers")
+	}
+	atomic.AddUint64(&s.deleted, uint64(n))
+	return nil
+}
+
+// Nearest returns nearest not expired drivers matching all filters by location
+// using KNN index search. Point is Lat, Lon like in the in-memory storage.
+// Filters are applied to pages of nearest drivers until count drivers are found.
+func (s *Storage) Nearest(point rtreego.Point, count int, filters ...storage.Filter) []*storage.Driver {
+	if count <= 0 {
+		return nil
+	}
+	pageSize := count
+	if len(filters) > 0 {
+		pageSize *= nearestPageFactor
+	}
+
+	var drivers []*storage.Driver
+	now := time.Now().UnixNano()
+	for offset := 0; ; offset += pageSize {
+		page, err := s.queryDrivers(`
+			SELECT `+driverColumns+`
+			FROM drivers
+			WHERE expiration = 0 OR expiration > $3
+			ORDER BY location <-> ST_SetSRID(ST_MakePoint($2, $1), 4326)::geography
+			LIMIT $4 OFFSET $5`, point[0], point[1], now, pageSize, offset)
+		if err != nil {
+			zap.L().Error("could not query nearest drivers", zap.Error(err))
+			return nil
+		}
+		for _, d := range page {
+			if matches(d, filters) {
+				drivers = append(drivers, d)
+			}
+			if len(drivers) == count {
+				return drivers
+			}
+		}
+		if len(page) < pageSize {
+			return drivers
+		}
+	}
+}
+
+// nearestPageFactor is how many times more drivers than requested
+// are fetched per page when nearest query is filtered
+const nearestPageFactor = 4
+
+// NearestAndLock reserves up to count nearest available drivers matching all filters.
+// Candidates are locked in a transaction skipping rows locked by concurrent calls,
+// filters are applied to a single page of candidates.
+func (s *Storage) NearestAndLock(point rtreego.Point, count int, ttl time.Duration, filters ...storage.Filter) []*storage.Driver {
+	if count <= 0 {
+		return nil
+	}
+	drivers, err := s.nearestAndLock(point, count, ttl, filters)
+	if err != nil {
+		zap.L().Error("could not reserve nearest drivers", zap.Error(err))
+		return nil
+	}
+	return drivers
+}
+
+func (s *Storage) nearestAndLock(point rtreego.Point, count int, ttl time.Duration, filters []storage.Filter) ([]*storage.Driver, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not begin transaction")
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixNano()
+	candidates, err := queryDrivers(tx, `
+		SELECT `+driverColumns+`
+		FROM drivers
+		WHERE (expiration = 0 OR expiration > $3) AND status = 'available' AND reserved_until <= $3
+		ORDER BY location <-> ST_SetSRID(ST_MakePoint($2, $1), 4326)::geography
+		LIMIT $4
+		FOR UPDATE SKIP LOCKED`, point[0], point[1], now, count*nearestPageFactor)
+	if err != nil {
+		return nil, err
+	}
+
+	until := time.Now().Add(ttl).UnixNano()
+	var drivers []*storage.Driver
+	var ids []int64
+	for _, d := range candidates {
+		if len(drivers) == count {
+			break
+		}
+		if matches(d, filters) {
+			d.ReservedUntil = until
+			drivers = append(drivers, d)
+			ids = append(ids, int64(d.ID))
+		}
+	}
+	if _, err := tx.Exec(`UPDATE drivers SET reserved_until = $2 WHERE id = ANY($1)`, pq.Array(ids), until); err != nil {
+		return nil, errors.Wrap(err, "could not reserve drivers")
+	}
+	return drivers, errors.Wrap(tx.Commit(), "could not commit transaction")
+}
+
+func matches(d *storage.Driver, filters []storage.Filter) bool {
+	for _, f := range filters {
+		if !f(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// InBoundingBox returns all not expired drivers located inside the bounding box,
+// it crosses the antimeridian if min longitude is greater than max one
+func (s *Storage) InBoundingBox(minLat, minLon, maxLat, maxLon float64) ([]*storage.Driver, error) {
+	if !storage.ValidBoundingBox(minLat, minLon, maxLat, maxLon) {
+		return nil, storage.ErrInvalidBoundingBox
+	}
+	var drivers []*storage.Driver
+	// envelopes are made of boxes split at the antimeridian
+	for _, b := range storage.SplitBoundingBox(minLat, minLon, maxLat, maxLon) {
+		found, err := s.queryDrivers(`
+			SELECT `+driverColumns+`
+			FROM drivers
+			WHERE location && ST_MakeEnvelope($2, $1, $4, $3, 4326)::geography
+				AND (expiration = 0 OR expiration > $5)`,
+			b[0], b[1], b[2], b[3], time.Now().UnixNano())
+		if err != nil {
+			return nil, err
+		}
+		drivers = append(drivers, found...)
+	}
+	return drivers, nil
+}
+
+// InPolygon returns all not expired drivers located inside the polygon
+func (s *Storage) InPolygon(polygon storage.Polygon) ([]*storage.Driver, error) {
+	if !polygon.Valid() {
+		return nil, storage.ErrInvalidPolygon
+	}
+	// polygons crossing the antimeridian are unwrapped, western locations are shifted east to match them
+	return s.queryDrivers(`
+		SELECT `+driverColumns+`
+		FROM drivers
+		WHERE (ST_Covers(ST_GeomFromText($1, 4326), location::geometry)
+				OR ST_Covers(ST_GeomFromText($1, 4326), ST_Translate(location::geometry, 360, 0)))
+			AND (expiration = 0 OR expiration > $2)`,
+		polygonWKT(polygon.Unwrapped()), time.Now().UnixNano())
+}
+
+// Heatmap counts not expired drivers in geohash cells of given precision
+func (s *Storage) Heatmap(precision int) ([]storage.HeatmapCell, error) {
+	rows, err := s.db.Query(`
+		SELECT ST_GeoHash(location::geometry, $1), count(*)
+		FROM drivers
+		WHERE expiration = 0 OR expiration > $2
+		GROUP BY 1`,
+		precision, time.Now().UnixNano())
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get heatmap")
+	}
+	defer rows.Close()
+
+	cells := []storage.HeatmapCell{}
+	for rows.Next() {
+		var c storage.HeatmapCell
+		if err := rows.Scan(&c.Geohash, &c.Count); err != nil {
+			return nil, errors.Wrap(err, "could not scan heatmap cell")
+		}
+		cells = append(cells, c)
+	}
+	storage.SortHeatmap(cells)
+	return cells, errors.Wrap(rows.Err(), "could not get heatmap")
+}
+
+// polygonWKT returns polygon in well-known text format with closed rings
+func polygonWKT(polygon storage.Polygon) string {
+	var b strings.Builder
+	b.WriteString("POLYGON(")
+	for i, ring := range polygon {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		if ring[0] != ring[len(ring)-1] {
+			ring = append(ring[:len(ring):len(ring)], ring[0])
+		}
+		b.WriteString("(")
+		for j, l := range ring {
+			if j > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(strconv.FormatFloat(l.Lon, 'f', -1, 64))
+			b.WriteString(" ")
+			b.WriteString(strconv.FormatFloat(l.Lat, 'f', -1, 64))
+		}
+		b.WriteString(")")
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// driverColumns are selected by queries passed to queryDrivers
+const driverColumns = `id, ST_Y(location::geometry), ST_X(location::geometry), expiration, attributes, status, ts, reserved_until`
+
+// queryer is implemented by sql.DB and sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryDrivers runs query selecting driverColumns
+func (s *Storage) queryDrivers(query string, args ...interface{}) ([]*storage.Driver, error) {
+	return queryDrivers(s.db, query, args...)
+}
+
+func queryDrivers(q queryer, query string, args ...interface{}) ([]*storage.Driver, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not query drivers")
+	}
+	defer rows.Close()
+
+	var drivers []*storage.Driver
+	for rows.Next() {
+		d := &storage.Driver{}
+		var attributes []byte
+		if err := rows.Scan(&d.ID, &d.LastLocation.Lat, &d.LastLocation.Lon, &d.Expiration, &attributes, &d.Status, &d.Timestamp, &d.ReservedUntil); err != nil {
+			return nil, errors.Wrap(err, "could not scan driver")
+		}
+		if err := json.Unmarshal(attributes, &d.Attributes); err != nil {
+			return nil, errors.Wrap(err, "could not decode attributes")
+		}
+		if len(d.Attributes) == 0 {
+			d.Attributes = nil
+		}
+		drivers = append(drivers, d)
+	}
+	return drivers, errors.Wrap(rows.Err(), "could not query drivers")
+}
+
+// DeleteExpired removes all expired items from storage
+func (s *Storage) DeleteExpired() {
+	res, err := s.db.Exec(`DELETE FROM drivers WHERE expiration <> 0 AND expiration < $1`, time.Now().UnixNano())
+	if err != nil {
+		zap.L().Error("could not delete expired drivers", zap.Error(err))
+		return
+	}
+	if n, err := res.RowsAffected(); err == nil {
+		atomic.AddUint64(&s.expired, uint64(n))
+	}
+}
+
+// Len returns number of drivers in the table, 0 if it could not be counted
+func (s *Storage) Len() int {
+	var n int
+	if err := s.db.QueryRow(`SELECT count(*) FROM drivers`).Scan(&n); err != nil {
+		zap.L().Error("could not count drivers", zap.Error(err))
+	}
+	return n
+}
+
+// Stats returns storage statistics, index size is a number of rows in the table
+func (s *Storage) Stats() storage.Stats {
+	n := s.Len()
+	return storage.Stats{
+		Drivers:  n,
+		Inserted: atomic.LoadUint64(&s.inserted),
+		Updated:  atomic.LoadUint64(&s.updated),
+		Deleted:  atomic.LoadUint64(&s.deleted),
+		Expired:  atomic.LoadUint64(&s.expired),
+		Index:    storage.IndexStats{Type: "postgis", Size: n},
+	}
+}
@@ -0,0 +1,76 @@
+package postgis
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestStorage connects to the database from POSTGIS_DSN or skips the test
+func newTestStorage(t *testing.T) *Storage {
+	dsn := os.Getenv("POSTGIS_DSN")
+	if dsn == "" {
+		t.Skip("POSTGIS_DSN is not set")
+	}
+	s, err := New(dsn, 2, 0)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if _, err := s.db.Exec(`TRUNCATE drivers CASCADE`); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return s
+}
+
+func TestStorage(t *testing.T) {
+	s := newTestStorage(t)
+	defer s.Close()
+	assert.NoError(t, s.Ping(context.Background()))
+
+	for i := 0; i < 3; i++ {
+		err := s.Set(&storage.Driver{
+			ID: 123,
+			LastLocation: storage.Location{
+				Lat: 42.875799,
+				Lon: 74.588279 + float64(i),
+			},
+		})
+		assert.NoError(t, err)
+	}
+
+	d, err := s.Get(123)
+	assert.NoError(t, err)
+	assert.Equal(t, 123, d.ID)
+	assert.InDelta(t, 76.588279, d.LastLocation.Lon, 1e-9)
+	assert.Equal(t, 2, d.Locations.Len())
+
+	assert.NoError(t, s.Delete(123))
+	_, err = s.Get(123)
+	assert.Equal(t, storage.ErrDriverDoesNotExist, err)
+	assert.Equal(t, storage.ErrDriverDoesNotExist, s.Delete(123))
+}
+
+func TestNearest(t *testing.T) {
+	s := newTestStorage(t)
+	defer s.Close()
+
+	s.Set(&storage.Driver{ID: 123, LastLocation: storage.Location{Lat: 42.875799, Lon: 74.588279}})
+	s.Set(&storage.Driver{ID: 321, LastLocation: storage.Location{Lat: 42.875508, Lon: 74.588107}})
+	s.Set(&storage.Driver{ID: 991, LastLocation: storage.Location{Lat: 42.875744, Lon: 74.584503}})
+
+	drivers := s.Nearest(rtreego.Point{42.876420, 74.588332}, 2)
+	assert.Equal(t, 2, len(drivers))
+	assert.Equal(t, 123, drivers[0].ID)
+	assert.Equal(t, 321, drivers[1].ID)
+}
+
+func TestPolygonWKT(t *testing.T) {
+	polygon := storage.Polygon{
+		{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 10}, {Lat: 10, Lon: 10}},
+	}
+	assert.Equal(t, "POLYGON((0 0,10 0,10 10,0 0))", polygonWKT(polygon))
+}
@@ -0,0 +1,532 @@
+package storage
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/pkg/errors"
+)
+
+// regionLocks is number of locks serializing writes of drivers by id, a driver may move between regions
+const regionLocks = 256
+
+var (
+	// ErrOutOfRegions sign what location is not inside any region
+	ErrOutOfRegions = errors.New("Location is outside of all regions")
+	// ErrInvalidRegion sign what region has no valid name or neither a valid bounding box nor geohashes
+	ErrInvalidRegion = errors.New("Invalid region")
+)
+
+type (
+	// Region is a named bounding box or a set of geohash cells, e.g. a city.
+	// Geohashes are prefixes of geohashes of locations inside the region.
+	Region struct {
+		Name      string   `json:"name"`
+		MinLat    float64  `json:"min_lat,omitempty"`
+		MinLon    float64  `json:"min_lon,omitempty"`
+		MaxLat    float64  `json:"max_lat,omitempty"`
+		MaxLon    float64  `json:"max_lon,omitempty"`
+		Geohashes []string `json:"geohashes,omitempty"`
+	}
+
+	// RegionStorage routes drivers by location to regions, every region is a DriverStorage
+	// with its own lock and index, so cities served by one deployment don't share them.
+	// Regions are matched in order, an earlier region wins where regions overlap.
+	// Queries of a point are served by its region, queries of areas by regions overlapping them.
+	RegionStorage struct {
+		regions  []Region
+		storages []*DriverStorage
+		locks    [regionLocks]sync.Mutex
+	}
+)
+
+var _ Storage = (*RegionStorage)(nil)
+
+// Valid returns true if region has a name like a namespace and either a valid bounding box or valid geohashes
+func (r *Region) Valid() bool {
+	if !namespacePattern.MatchString(r.Name) {
+		return false
+	}
+	if len(r.Geohashes) == 0 {
+		return r.MinLat < r.MaxLat && r.MinLon < r.MaxLon &&
+			Location{Lat: r.MinLat, Lon: r.MinLon}.Valid() && Location{Lat: r.MaxLat, Lon: r.MaxLon}.Valid()
+	}
+	if r.MinLat != 0 || r.MinLon != 0 || r.MaxLat != 0 || r.MaxLon != 0 {
+		return false
+	}
+	for _, hash := range r.Geohashes {
+		if _, _, _, _, ok := geohashBox(hash); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains returns true if the location is inside the region
+func (r *Region) Contains(l Location) bool {
+	if len(r.Geohashes) == 0 {
+		return l.Lat >= r.MinLat && l.Lat <= r.MaxLat && l.Lon >= r.MinLon && l.Lon <= r.MaxLon
+	}
+	for _, hash := range r.Geohashes {
+		if Geohash(l, len(hash)) == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// overlaps returns true if the region may have locations inside the bounding box
+func (r *Region) overlaps(minLat, minLon, maxLat, maxLon float64) bool {
+	if len(r.Geohashes) == 0 {
+		return r.MinLat <= maxLat && r.MaxLat >= minLat && r.MinLon <= maxLon && r.MaxLon >= minLon
+	}
+	for _, hash := range r.Geohashes {
+		bMinLat, bMinLon, bMaxLat, bMaxLon, _ := geohashBox(hash)
+		if bMinLat <= maxLat && bMaxLat >= minLat && bMinLon <= maxLon && bMaxLon >= minLon {
+			return true
+		}
+	}
+	return false
+}
+
+// geohashBox returns the cell of the geohash, false if it's not 1-12 geohash characters
+func geohashBox(hash string) (minLat, minLon, maxLat, maxLon float64, ok bool) {
+	if len(hash) < 1 || len(hash) > 12 {
+		return 0, 0, 0, 0, false
+	}
+	var key uint64
+	for i := 0; i < len(hash); i++ {
+		c := strings.IndexByte(geohashBase32, hash[i])
+		if c < 0 {
+			return 0, 0, 0, 0, false
+		}
+		key = key<<5 | uint64(c)
+	}
+	// bits are interleaved starting with longitude, see geohashGrid.key
+	g := newGeohashGrid(len(hash))
+	bits := g.lonBits + g.latBits
+	var x, y int
+	for i := uint(0); i < bits; i++ {
+		bit := int(key>>(bits-1-i)) & 1
+		if i%2 == 0 {
+			x = x<<1 | bit
+		} else {
+			y = y<<1 | bit
+		}
+	}
+	lat, lon := g.cellSize()
+	minLat, minLon = -90+float64(y)*lat, -180+float64(x)*lon
+	return minLat, minLon, minLat + lat, minLon + lon, true
+}
+
+// ReadRegions returns regions of the JSON file of a region array
+func ReadRegions(path string) ([]Region, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read regions")
+	}
+	var regions []Region
+	if err := json.Unmarshal(b, &regions); err != nil {
+		return nil, errors.Wrapf(err, "could not parse %s", path)
+	}
+	return regions, nil
+}
+
+// NewRegions creates RegionStorage of the regions, options are applied to the storage of every region
+func NewRegions(regions []Region, historySize int, opts ...Option) (*RegionStorage, error) {
+	if len(regions) == 0 {
+		return nil, errors.Wrap(ErrInvalidRegion, "no regions")
+	}
+	s := &RegionStorage{regions: regions, storages: make([]*DriverStorage, len(regions))}
+	names := make(map[string]bool, len(regions))
+	for i, r := range regions {
+		if !r.Valid() {
+			return nil, errors.Wrap(ErrInvalidRegion, r.Name)
+		}
+		if names[r.Name] {
+			return nil, errors.Wrapf(ErrInvalidRegion, "%s is duplicated", r.Name)
+		}
+		names[r.Name] = true
+		s.storages[i] = New(historySize, opts...)
+	}
+	return s, nil
+}
+
+// Regions returns the regions in order of matching
+func (s *RegionStorage) Regions() []Region {
+	return s.regions
+}
+
+// region returns index of the first region containing the location, -1 if there is none
+func (s *RegionStorage) region(l Location) int {
+	for i := range s.regions {
+		if s.regions[i].Contains(l) {
+			return i
+		}
+	}
+	return -1
+}
+
+// owner returns index of the region keeping the driver, -1 if there is none
+func (s *RegionStorage) owner(id int) int {
+	for i, r := range s.storages {
+		r.mu.RLock()
+		_, ok := r.drivers[id]
+		r.mu.RUnlock()
+		if ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// lock locks writes of the driver, unlock it by the returned function
+func (s *RegionStorage) lock(id int) func() {
+	i := id % regionLocks
+	if i < 0 {
+		i += regionLocks
+	}
+	s.locks[i].Lock()
+	return s.locks[i].Unlock
+}
+
+// Set sets the driver to the storage of its region. A driver moving to another region
+// keeps its attributes, status and version, its history and motion start over there.
+func (s *RegionStorage) Set(driver *Driver) error {
+	if !driver.LastLocation.Valid() {
+		return ErrInvalidLocation
+	}
+	if driver.Status != "" && !driver.Status.Valid() {
+		return ErrInvalidStatus
+	}
+	i := s.region(driver.LastLocation)
+	if i < 0 {
+		return ErrOutOfRegions
+	}
+	defer s.lock(driver.ID)()
+
+	prev := s.owner(driver.ID)
+	if prev < 0 || prev == i {
+		return s.storages[i].Set(driver)
+	}
+	old, err := s.storages[prev].Get(driver.ID)
+	if err != nil {
+		return s.storages[i].Set(driver)
+	}
+	if driver.Timestamp != 0 && driver.Timestamp < old.Timestamp {
+		return ErrStaleLocation
+	}
+	if driver.Attributes == nil {
+		driver.Attributes = old.Attributes
+	}
+	if driver.Status == "" {
+		driver.Status = old.Status
+	}
+	driver.Version = old.nextVersion(driver)
+	// observers see the driver removed before it appears, so it's never in two regions
+	if err := s.storages[prev].Delete(driver.ID); err != nil && err != ErrDriverDoesNotExist {
+		return err
+	}
+	return s.storages[i].Set(driver)
+}
+
+// SetMany sets drivers one by one in order of their timestamps. Stale, throttled, impossible locations
+// and locations outside of all regions are skipped, it stops at the first other error.
+func (s *RegionStorage) SetMany(drivers []*Driver) error {
+	for _, d := range ByTimestamp(drivers) {
+		err := s.Set(d)
+		if err != nil && err != ErrStaleLocation && err != ErrThrottled && err != ErrImpossibleSpeed && err != ErrOutOfRegions {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get gets driver from the storage of its region
+func (s *RegionStorage) Get(id int) (*Driver, error) {
+	i := s.owner(id)
+	if i < 0 {
+		return nil, ErrDriverDoesNotExist
+	}
+	return s.storages[i].Get(id)
+}
+
+// History returns locations of the driver in its current region
+func (s *RegionStorage) History(id int, from, to int64) ([]HistoryPoint, error) {
+	i := s.owner(id)
+	if i < 0 {
+		return nil, ErrDriverDoesNotExist
+	}
+	return s.storages[i].History(id, from, to)
+}
+
+// HistoryStats returns stats of the driver history in its current region
+func (s *RegionStorage) HistoryStats(id int) (HistoryStats, error) {
+	i := s.owner(id)
+	if i < 0 {
+		return HistoryStats{}, ErrDriverDoesNotExist
+	}
+	return s.storages[i].HistoryStats(id)
+}
+
+// Delete deletes the driver from the storage of its region
+func (s *RegionStorage) Delete(id int) error {
+	defer s.lock(id)()
+	i := s.owner(id)
+	if i < 0 {
+		return ErrDriverDoesNotExist
+	}
+	return s.storages[i].Delete(id)
+}
+
+// DeleteMany deletes drivers one by one, skipping missing ones
+func (s *RegionStorage) DeleteMany(ids []int) error {
+	for _, id := range ids {
+		if err := s.Delete(id); err != nil && err != ErrDriverDoesNotExist {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetStatus changes status of the driver
+func (s *RegionStorage) SetStatus(id int, status Status) error {
+	if !status.Valid() {
+		return ErrInvalidStatus
+	}
+	defer s.lock(id)()
+	i := s.owner(id)
+	if i < 0 {
+		return ErrDriverDoesNotExist
+	}
+	return s.storages[i].SetStatus(id, status)
+}
+
+// Nearest returns nearest drivers of the region of the point, none if the point is outside of all regions.
+// Point is Lat, Lon.
+func (s *RegionStorage) Nearest(point rtreego.Point, count int, filters ...Filter) []*Driver {
+	i := s.region(Location{Lat: point[0], Lon: point[1]})
+	if i < 0 {
+		return nil
+	}
+	return s.storages[i].Nearest(point, count, filters...)
+}
+
+// NearestAndLock reserves nearest drivers of the region of the point
+func (s *RegionStorage) NearestAndLock(point rtreego.Point, count int, ttl time.Duration, filters ...Filter) []*Driver {
+	i := s.region(Location{Lat: point[0], Lon: point[1]})
+	if i < 0 {
+		return nil
+	}
+	return s.storages[i].NearestAndLock(point, count, ttl, filters...)
+}
+
+// Reserve reserves the driver until unix nanoseconds if it's available
+func (s *RegionStorage) Reserve(id int, until int64) bool {
+	i := s.owner(id)
+	return i >= 0 && s.storages[i].reserve(id, until, nil) != nil
+}
+
+// InBoundingBox returns drivers of regions overlapping the bounding box located inside it
+func (s *RegionStorage) InBoundingBox(minLat, minLon, maxLat, maxLon float64) ([]*Driver, error) {
+	if !ValidBoundingBox(minLat, minLon, maxLat, maxLon) {
+		return nil, ErrInvalidBoundingBox
+	}
+	boxes := SplitBoundingBox(minLat, minLon, maxLat, maxLon)
+	var drivers []*Driver
+	for i := range s.regions {
+		overlaps := false
+		for _, b := range boxes {
+			overlaps = overlaps || s.regions[i].overlaps(b[0], b[1], b[2], b[3])
+		}
+		if !overlaps {
+			continue
+		}
+		found, err := s.storages[i].InBoundingBox(minLat, minLon, maxLat, maxLon)
+		if err != nil {
+			return nil, err
+		}
+		drivers = append(drivers, found...)
+	}
+	return drivers, nil
+}
+
+// InPolygon returns drivers of regions overlapping the polygon located inside it
+func (s *RegionStorage) InPolygon(polygon Polygon) ([]*Driver, error) {
+	if !polygon.Valid() {
+		return nil, ErrInvalidPolygon
+	}
+	boxes := SplitBoundingBox(polygon.BoundingBox())
+	var drivers []*Driver
+	for i := range s.regions {
+		overlaps := false
+		for _, b := range boxes {
+			overlaps = overlaps || s.regions[i].overlaps(b[0], b[1], b[2], b[3])
+		}
+		if !overlaps {
+			continue
+		}
+		found, err := s.storages[i].InPolygon(polygon)
+		if err != nil {
+			return nil, err
+		}
+		drivers = append(drivers, found...)
+	}
+	return drivers, nil
+}
+
+// Heatmap counts drivers of all regions per geohash cell
+func (s *RegionStorage) Heatmap(precision int) ([]HeatmapCell, error) {
+	return heatmap(s.ForEach, precision), nil
+}
+
+// ForEach calls fn for drivers of all regions until it returns false
+func (s *RegionStorage) ForEach(fn func(d *Driver) bool) {
+	stopped := false
+	for _, r := range s.storages {
+		r.ForEach(func(d *Driver) bool {
+			stopped = !fn(d)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// List merges pages of all regions
+func (s *RegionStorage) List(after, limit int) []*Driver {
+	var drivers []*Driver
+	for _, r := range s.storages {
+		drivers = append(drivers, r.List(after, limit)...)
+	}
+	return firstByID(drivers, limit)
+}
+
+// DeleteExpired removes expired drivers of all regions
+func (s *RegionStorage) DeleteExpired() {
+	for _, r := range s.storages {
+		r.DeleteExpired()
+	}
+}
+
+// Len returns number of drivers in all regions
+func (s *RegionStorage) Len() int {
+	n := 0
+	for _, r := range s.storages {
+		n += r.Len()
+	}
+	return n
+}
+
+// Stats returns totals of all regions along with stats of every region by name
+func (s *RegionStorage) Stats() Stats {
+	total := Stats{Regions: make(map[string]Stats, len(s.regions))}
+	for i, r := range s.storages {
+		st := r.Stats()
+		total.Regions[s.regions[i].Name] = st
+		total.add(st)
+	}
+	return total
+}
+
+// Save writes drivers of all regions to a single snapshot file,
+// so it can be restored with other regions.
+func (s *RegionStorage) Save(path string) error {
+	var records []driverRecord
+	compacts := make([]func() error, 0, len(s.storages))
+	for _, r := range s.storages {
+		rs, compact, err := r.capture()
+		if err != nil {
+			return err
+		}
+		records = append(records, rs...)
+		compacts = append(compacts, compact)
+	}
+
+	if err := writeSnapshot(path, &snapshot{Drivers: records}); err != nil {
+		return err
+	}
+	for _, compact := range compacts {
+		if err := compact(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSnapshot writes drivers of all regions to w in the format of Save, the WAL is not compacted
+func (s *RegionStorage) WriteSnapshot(w io.Writer) error {
+	var records []driverRecord
+	for _, r := range s.storages {
+		rs, _, err := r.capture()
+		if err != nil {
+			return err
+		}
+		records = append(records, rs...)
+	}
+	return errors.Wrap(gob.NewEncoder(w).Encode(&snapshot{Drivers: records}), "could not encode snapshot")
+}
+
+// Load replaces the content of the storage with drivers from the snapshot at path.
+func (s *RegionStorage) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "could not open snapshot")
+	}
+	defer f.Close()
+	return s.ReadSnapshot(f)
+}
+
+// ReadSnapshot replaces the content of the storage with drivers from the snapshot read from r,
+// drivers are routed to regions by location and those outside of all regions are dropped.
+// Drivers are not written to the WAL, they are persisted by the next Save.
+func (s *RegionStorage) ReadSnapshot(r io.Reader) error {
+	snap, err := decodeSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	records := make([][]driverRecord, len(s.storages))
+	for _, r := range snap.Drivers {
+		if i := s.region(r.LastLocation); i >= 0 {
+			records[i] = append(records[i], r)
+		}
+	}
+	for i, r := range s.storages {
+		if err := r.restore(records[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenWAL opens a WAL for every region in a subdirectory of dir named by the region.
+// Regions must not change between restarts while the WAL is used.
+func (s *RegionStorage) OpenWAL(dir string) error {
+	for i, r := range s.storages {
+		if err := r.OpenWAL(filepath.Join(dir, s.regions[i].Name)); err != nil {
+			return errors.Wrapf(err, "could not open WAL of region %s", s.regions[i].Name)
+		}
+	}
+	return nil
+}
+
+// Close closes WAL of all regions
+func (s *RegionStorage) Close() error {
+	var err error
+	for _, r := range s.storages {
+		if e := r.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
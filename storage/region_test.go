@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	bishkek = Location{Lat: 42.87, Lon: 74.6}
+	almaty  = Location{Lat: 43.24, Lon: 76.9}
+)
+
+func testRegions() []Region {
+	return []Region{
+		{Name: "bishkek", MinLat: 42.7, MinLon: 74.4, MaxLat: 43, MaxLon: 74.8},
+		{Name: "almaty", Geohashes: []string{Geohash(almaty, 4)}},
+	}
+}
+
+func TestRegionStorage(t *testing.T) {
+	s, err := NewRegions(testRegions(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: bishkek, Attributes: map[string]string{"car": "van"}}))
+	assert.NoError(t, s.SetMany([]*Driver{
+		{ID: 2, LastLocation: Location{Lat: 42.88, Lon: 74.61}},
+		{ID: 3, LastLocation: almaty},
+		{ID: 4, LastLocation: Location{Lat: 0, Lon: 0}},
+	}))
+	assert.Equal(t, ErrOutOfRegions, s.Set(&Driver{ID: 5, LastLocation: Location{Lat: 50, Lon: 50}}))
+	assert.Equal(t, ErrInvalidLocation, s.Set(&Driver{ID: 5, LastLocation: Location{Lat: 100, Lon: 0}}))
+	assert.Equal(t, 3, s.Len())
+	assert.Equal(t, 2, s.storages[0].Len())
+
+	// nearest drivers are of the region of the point only
+	nearest := s.Nearest(rtreego.Point{almaty.Lat, almaty.Lon}, 10)
+	if assert.Len(t, nearest, 1) {
+		assert.Equal(t, 3, nearest[0].ID)
+	}
+	assert.Len(t, s.Nearest(rtreego.Point{bishkek.Lat, bishkek.Lon}, 10), 2)
+	assert.Empty(t, s.Nearest(rtreego.Point{50, 50}, 10))
+	assert.Len(t, s.NearestAndLock(rtreego.Point{bishkek.Lat, bishkek.Lon}, 1, time.Minute), 1)
+
+	drivers, err := s.InBoundingBox(42, 74, 44, 77)
+	assert.NoError(t, err)
+	assert.Len(t, drivers, 3)
+	drivers, err = s.InPolygon(Polygon{{{Lat: 43.2, Lon: 76.8}, {Lat: 43.3, Lon: 76.8}, {Lat: 43.3, Lon: 77}, {Lat: 43.2, Lon: 77}}})
+	assert.NoError(t, err)
+	assert.Len(t, drivers, 1)
+	assert.Len(t, s.List(0, 10), 3)
+
+	// a driver moving to another region keeps attributes and status, stale locations are rejected
+	assert.NoError(t, s.SetStatus(1, StatusBusy))
+	now := time.Now().UnixNano()
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: almaty, Timestamp: now}))
+	assert.Equal(t, ErrStaleLocation, s.Set(&Driver{ID: 1, LastLocation: bishkek, Timestamp: now - 1}))
+	d, err := s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, almaty, d.LastLocation)
+	assert.Equal(t, "van", d.Attributes["car"])
+	assert.Equal(t, StatusBusy, d.Status)
+	assert.Equal(t, 1, s.storages[0].Len())
+	assert.Equal(t, 2, s.storages[1].Len())
+
+	st := s.Stats()
+	assert.Equal(t, 3, st.Drivers)
+	assert.Equal(t, 1, st.Regions["bishkek"].Drivers)
+	assert.Equal(t, 2, st.Regions["almaty"].Drivers)
+	assert.Equal(t, uint64(1), st.Regions["bishkek"].Deleted)
+
+	assert.NoError(t, s.DeleteMany([]int{1, 42}))
+	assert.Equal(t, ErrDriverDoesNotExist, s.Delete(1))
+	_, err = s.Get(1)
+	assert.Equal(t, ErrDriverDoesNotExist, err)
+	_, err = s.History(1, 0, 0)
+	assert.Equal(t, ErrDriverDoesNotExist, err)
+	assert.Equal(t, ErrDriverDoesNotExist, s.SetStatus(1, StatusBusy))
+}
+
+func TestRegionSnapshot(t *testing.T) {
+	s, _ := NewRegions(testRegions(), 10)
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: bishkek}))
+	assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: almaty}))
+	var b bytes.Buffer
+	assert.NoError(t, s.WriteSnapshot(&b))
+
+	// drivers are routed by location, those outside of all regions are dropped
+	other, _ := NewRegions(testRegions()[1:], 10)
+	assert.NoError(t, other.ReadSnapshot(&b))
+	assert.Equal(t, 1, other.Len())
+	_, err := other.Get(2)
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "regions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wal := filepath.Join(dir, "wal")
+	assert.NoError(t, s.OpenWAL(wal))
+	assert.NoError(t, s.Set(&Driver{ID: 3, LastLocation: almaty}))
+	assert.NoError(t, s.Save(filepath.Join(dir, "snapshot")))
+	assert.NoError(t, s.Set(&Driver{ID: 3, LastLocation: bishkek}))
+	assert.NoError(t, s.Close())
+
+	restored, _ := NewRegions(testRegions(), 10)
+	assert.NoError(t, restored.Load(filepath.Join(dir, "snapshot")))
+	assert.NoError(t, restored.OpenWAL(wal))
+	defer restored.Close()
+	assert.Equal(t, 3, restored.Len())
+	d, err := restored.Get(3)
+	assert.NoError(t, err)
+	assert.Equal(t, bishkek, d.LastLocation)
+	assert.Equal(t, 2, restored.storages[0].Len())
+}
+
+func TestRegionValid(t *testing.T) {
+	for _, r := range testRegions() {
+		assert.True(t, r.Valid(), r.Name)
+	}
+	for _, r := range []Region{
+		{Name: "", MinLat: 1, MinLon: 1, MaxLat: 2, MaxLon: 2},
+		{Name: "Bad!", MinLat: 1, MinLon: 1, MaxLat: 2, MaxLon: 2},
+		{Name: "empty"},
+		{Name: "inverted", MinLat: 2, MinLon: 1, MaxLat: 1, MaxLon: 2},
+		{Name: "both", MaxLat: 1, Geohashes: []string{"tx"}},
+		{Name: "geohash", Geohashes: []string{"txa"}},
+	} {
+		assert.False(t, r.Valid(), r.Name)
+	}
+
+	_, err := NewRegions(append(testRegions(), testRegions()[0]), 10)
+	assert.Equal(t, ErrInvalidRegion, errors.Cause(err))
+	_, err = NewRegions(nil, 10)
+	assert.Equal(t, ErrInvalidRegion, errors.Cause(err))
+
+	// a geohash cell contains locations of the geohash
+	minLat, minLon, maxLat, maxLon, ok := geohashBox(Geohash(almaty, 5))
+	assert.True(t, ok)
+	assert.True(t, minLat <= almaty.Lat && almaty.Lat < maxLat && minLon <= almaty.Lon && almaty.Lon < maxLon)
+	assert.True(t, maxLat-minLat < 0.05)
+}
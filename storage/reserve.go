@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+)
+
+// Reserved returns true if the driver is reserved by a dispatcher now
+func (d *Driver) Reserved() bool {
+	return d.ReservedUntil > time.Now().UnixNano()
+}
+
+// Available matches available drivers on shift which are not reserved
+func Available() Filter {
+	return func(d *Driver) bool {
+		return d.Status == StatusAvailable && !d.OffShift && !d.Reserved()
+	}
+}
+
+// NearestAndLock returns up to count nearest available drivers matching all filters
+// and atomically reserves them for ttl, so concurrent calls never return the same driver.
+// Reservations are not written to the WAL and snapshots.
+func (s *DriverStorage) NearestAndLock(point rtreego.Point, count int, ttl time.Duration, filters ...Filter) []*Driver {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	drivers := nearest(s.locations, Location{Lat: point[0], Lon: point[1]}, count, append(filters[:len(filters):len(filters)], Available()))
+	until := time.Now().Add(ttl).UnixNano()
+	for i, d := range drivers {
+		version := *d
+		version.ReservedUntil = until
+		version.reserveIdle()
+		s.publish(&version)
+		drivers[i] = &version
+	}
+	return drivers
+}
+
+// Reserve reserves the driver until the unix nanoseconds if it's still available,
+// it returns false if the driver is missing or not available
+func (s *DriverStorage) Reserve(id int, until int64) bool {
+	return s.reserve(id, until, nil) != nil
+}
+
+// Reserve reserves the driver of its shard if it's still available
+func (s *ShardedStorage) Reserve(id int, until int64) bool {
+	return s.shard(id).reserve(id, until, nil) != nil
+}
+
+// reserve reserves the driver if it's still available and matches all filters,
+// it returns the reserved version of the driver or nil
+func (s *DriverStorage) reserve(id int, until int64, filters []Filter) *Driver {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.drivers[id]
+	if !ok || !Available()(d) {
+		return nil
+	}
+	for _, f := range filters {
+		if !f(d) {
+			return nil
+		}
+	}
+	version := *d
+	version.ReservedUntil = until
+	version.reserveIdle()
+	s.publish(&version)
+	return &version
+}
+
+// NearestAndLock reserves nearest drivers of all shards. Candidates are found
+// without locking and reserved one by one, skipping those reserved meanwhile.
+func (s *ShardedStorage) NearestAndLock(point rtreego.Point, count int, ttl time.Duration, filters ...Filter) []*Driver {
+	candidates := s.Nearest(point, count*candidatesFactor, append(filters[:len(filters):len(filters)], Available())...)
+	until := time.Now().Add(ttl).UnixNano()
+
+	var drivers []*Driver
+	for _, d := range candidates {
+		if len(drivers) == count {
+			break
+		}
+		if reserved := s.shard(d.ID).reserve(d.ID, until, filters); reserved != nil {
+			drivers = append(drivers, reserved)
+		}
+	}
+	return drivers
+}
@@ -0,0 +1,264 @@
+// Package ring keeps trajectories in fixed size ring buffers downsampling their older points
+package ring
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Options of a Ring. Points more than Recent older than the newest one are downsampled,
+	// every Nth of them is kept, so the ring spans a longer trajectory of the same shape.
+	// Every of 1 or less keeps all points. Points more than MaxAge older than the newest one
+	// are dropped if MaxAge is set.
+	Options struct {
+		Recent time.Duration
+		Every  int
+		MaxAge time.Duration
+	}
+
+	// Point is a value at the time in unix nanoseconds
+	Point struct {
+		Timestamp int64
+		Value     interface{}
+	}
+
+	// Ring keeps up to size points ordered by time, the oldest one is dropped when it's full.
+	// It's safe for concurrent use, so trajectories may be read while points are added.
+	Ring struct {
+		mu     sync.RWMutex
+		opts   Options
+		points []point
+		head   int
+		n      int
+		seq    uint64
+		// evicted counts dropped and downsampled points
+		evicted uint64
+	}
+
+	// Entry is a point with the number of its addition, every Nth of downsampled points is kept by it
+	Entry struct {
+		Timestamp int64
+		Seq       uint64
+		Value     interface{}
+	}
+
+	point struct {
+		Point
+		seq uint64
+	}
+)
+
+// New initialized a new Ring with fixed size
+func New(size int, opts Options) (*Ring, error) {
+	if size <= 0 {
+		return nil, errors.New("Size must be greater than 0")
+	}
+	if opts.Recent < 0 || opts.MaxAge < 0 {
+		return nil, errors.New("Recent and max age must not be negative")
+	}
+	if opts.Every < 1 {
+		opts.Every = 1
+	}
+	return &Ring{opts: opts, points: make([]point, size)}, nil
+}
+
+// Add adds a point at the time, a point of the same time is replaced.
+// Return true if a point was dropped or downsampled
+func (r *Ring) Add(ts int64, value interface{}) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// points are added in order of time mostly, late ones are inserted in place
+	i := r.n
+	for i > 0 && r.at(i-1).Timestamp > ts {
+		i--
+	}
+	if i > 0 && r.at(i-1).Timestamp == ts {
+		r.at(i - 1).Value = value
+		return false
+	}
+	dropped := false
+	if r.n == len(r.points) {
+		// a point older than all points of a full ring is dropped right away
+		if i == 0 {
+			r.evicted++
+			return true
+		}
+		r.dropOldest()
+		i--
+		dropped = true
+	}
+	r.seq++
+	r.insert(i, point{Point: Point{Timestamp: ts, Value: value}, seq: r.seq})
+	return r.downsample() || dropped
+}
+
+// at returns the ith point from the oldest, call it under the lock
+func (r *Ring) at(i int) *point {
+	return &r.points[(r.head+i)%len(r.points)]
+}
+
+func (r *Ring) insert(i int, p point) {
+	r.n++
+	for j := r.n - 1; j > i; j-- {
+		*r.at(j) = *r.at(j - 1)
+	}
+	*r.at(i) = p
+}
+
+func (r *Ring) dropOldest() {
+	*r.at(0) = point{}
+	r.head = (r.head + 1) % len(r.points)
+	r.n--
+	r.evicted++
+}
+
+// downsample drops points older than the max age and thins points older than recent,
+// call it under the lock
+func (r *Ring) downsample() bool {
+	if r.n == 0 {
+		return false
+	}
+	newest := r.at(r.n - 1).Timestamp
+	dropped := false
+	if r.opts.MaxAge > 0 {
+		for r.n > 0 && r.at(0).Timestamp < newest-int64(r.opts.MaxAge) {
+			r.dropOldest()
+			dropped = true
+		}
+	}
+	if r.opts.Every == 1 {
+		return dropped
+	}
+
+	recent := newest - int64(r.opts.Recent)
+	kept := 0
+	for i := 0; i < r.n; i++ {
+		p := r.at(i)
+		if p.Timestamp < recent && p.seq%uint64(r.opts.Every) != 0 {
+			continue
+		}
+		if kept != i {
+			*r.at(kept) = *p
+		}
+		kept++
+	}
+	for i := kept; i < r.n; i++ {
+		*r.at(i) = point{}
+	}
+	if kept < r.n {
+		r.evicted += uint64(r.n - kept)
+		r.n = kept
+		dropped = true
+	}
+	return dropped
+}
+
+// Len returns the number of points
+func (r *Ring) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.n
+}
+
+// Cap returns the number of points the ring fits
+func (r *Ring) Cap() int {
+	return len(r.points)
+}
+
+// Evicted returns the number of points dropped or downsampled since the ring is created
+func (r *Ring) Evicted() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.evicted
+}
+
+// Oldest returns the time of the oldest point, false if the ring is empty
+func (r *Ring) Oldest() (int64, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.n == 0 {
+		return 0, false
+	}
+	return r.at(0).Timestamp, true
+}
+
+// Each calls fn for points from the oldest until it returns false.
+// Points can't be added meanwhile, so fn must not change the ring.
+func (r *Ring) Each(fn func(ts int64, value interface{}) bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i := 0; i < r.n; i++ {
+		p := r.at(i)
+		if !fn(p.Timestamp, p.Value) {
+			return
+		}
+	}
+}
+
+// Window returns points between from and to inclusive ordered by time
+func (r *Ring) Window(from, to int64) []Point {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var points []Point
+	for i := 0; i < r.n; i++ {
+		if p := r.at(i); p.Timestamp >= from && p.Timestamp <= to {
+			points = append(points, p.Point)
+		}
+	}
+	return points
+}
+
+// Copy returns a Ring of the same size and options with the same points
+func (r *Ring) Copy() *Ring {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	c := &Ring{opts: r.opts, points: make([]point, len(r.points)), n: r.n, seq: r.seq, evicted: r.evicted}
+	for i := 0; i < r.n; i++ {
+		c.points[i] = *r.at(i)
+	}
+	return c
+}
+
+// Export returns points ordered by time with numbers of their additions and the number of the last addition,
+// so rings restored by Import keep downsampling the same points
+func (r *Ring) Export() (uint64, []Entry) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entries := make([]Entry, 0, r.n)
+	for i := 0; i < r.n; i++ {
+		p := r.at(i)
+		entries = append(entries, Entry{Timestamp: p.Timestamp, Seq: p.seq, Value: p.Value})
+	}
+	return r.seq, entries
+}
+
+// Import replaces points of the ring with exported ones. The ring keeps its size and options,
+// so the oldest points are dropped if there are more of them than it fits.
+func (r *Ring) Import(seq uint64, entries []Entry) error {
+	sorted := make([]Entry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp < sorted[j].Timestamp })
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.points) == 0 {
+		return errors.New("Ring must be initialized with New")
+	}
+	for i := range r.points {
+		r.points[i] = point{}
+	}
+	r.head, r.n, r.seq = 0, 0, seq
+	if len(sorted) > len(r.points) {
+		sorted = sorted[len(sorted)-len(r.points):]
+	}
+	for _, e := range sorted {
+		r.points[r.n] = point{Point: Point{Timestamp: e.Timestamp, Value: e.Value}, seq: e.Seq}
+		r.n++
+	}
+	r.downsample()
+	return nil
+}
@@ -0,0 +1,126 @@
+package ring
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func timestamps(r *Ring) []int64 {
+	var ts []int64
+	r.Each(func(t int64, _ interface{}) bool {
+		ts = append(ts, t)
+		return true
+	})
+	return ts
+}
+
+func TestRing(t *testing.T) {
+	_, err := New(0, Options{})
+	assert.Error(t, err)
+	r, err := New(3, Options{})
+	assert.NoError(t, err)
+
+	assert.False(t, r.Add(1, "a"))
+	assert.False(t, r.Add(3, "c"))
+	// late points are inserted in order, points of the same time are replaced
+	assert.False(t, r.Add(2, "b"))
+	assert.False(t, r.Add(2, "B"))
+	assert.Equal(t, []int64{1, 2, 3}, timestamps(r))
+
+	// the oldest point is dropped when the ring is full
+	assert.True(t, r.Add(4, "d"))
+	assert.Equal(t, []int64{2, 3, 4}, timestamps(r))
+	assert.True(t, r.Add(1, "a"))
+	assert.Equal(t, 3, r.Len())
+	assert.Equal(t, 3, r.Cap())
+	assert.Equal(t, uint64(2), r.Evicted())
+	oldest, ok := r.Oldest()
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), oldest)
+	assert.Equal(t, []Point{{Timestamp: 2, Value: "B"}, {Timestamp: 3, Value: "c"}}, r.Window(0, 3))
+
+	// the copy is independent
+	c := r.Copy()
+	c.Add(5, "e")
+	assert.Equal(t, []int64{3, 4, 5}, timestamps(c))
+	assert.Equal(t, []int64{2, 3, 4}, timestamps(r))
+}
+
+func TestDownsampling(t *testing.T) {
+	minute := int64(time.Minute)
+	r, err := New(10, Options{Recent: 5 * time.Minute, Every: 3})
+	assert.NoError(t, err)
+	for i := int64(1); i <= 8; i++ {
+		r.Add(i*minute, i)
+	}
+	// points older than 5 minutes before the newest one are thinned to every 3rd
+	assert.Equal(t, []int64{3 * minute, 4 * minute, 5 * minute, 6 * minute, 7 * minute, 8 * minute}, timestamps(r))
+	for i := int64(9); i <= 20; i++ {
+		r.Add(i*minute, i)
+	}
+	// downsampled points keep the trajectory before the recent 5 minutes
+	assert.Equal(t, []int64{6 * minute, 9 * minute, 12 * minute, 15 * minute, 16 * minute, 17 * minute, 18 * minute, 19 * minute, 20 * minute}, timestamps(r))
+	assert.Equal(t, uint64(11), r.Evicted())
+
+	r, err = New(10, Options{MaxAge: 2 * time.Minute})
+	assert.NoError(t, err)
+	for i := int64(1); i <= 5; i++ {
+		r.Add(i*minute, i)
+	}
+	assert.Equal(t, []int64{3 * minute, 4 * minute, 5 * minute}, timestamps(r))
+}
+
+func TestExportImport(t *testing.T) {
+	minute := int64(time.Minute)
+	r, _ := New(10, Options{Recent: 5 * time.Minute, Every: 3})
+	for i := int64(1); i <= 12; i++ {
+		r.Add(i*minute, i)
+	}
+	seq, entries := r.Export()
+	assert.Equal(t, uint64(12), seq)
+
+	c, _ := New(10, Options{Recent: 5 * time.Minute, Every: 3})
+	c.Add(time.Hour.Nanoseconds(), int64(0))
+	assert.NoError(t, c.Import(seq, entries))
+	assert.Equal(t, r.Window(0, 20*minute), c.Window(0, 20*minute))
+	// restored points are downsampled as they would be without the restore
+	for i := int64(13); i <= 20; i++ {
+		r.Add(i*minute, i)
+		c.Add(i*minute, i)
+	}
+	assert.Equal(t, timestamps(r), timestamps(c))
+
+	// smaller rings keep the newest points
+	c, _ = New(2, Options{})
+	assert.NoError(t, c.Import(seq, entries))
+	assert.Equal(t, []int64{11 * minute, 12 * minute}, timestamps(c))
+
+	assert.Error(t, new(Ring).Import(seq, entries))
+}
+
+// Test that the ring is read while points are added, run it with -race
+func TestConcurrent(t *testing.T) {
+	r, err := New(16, Options{Recent: time.Second, Every: 2})
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				assert.True(t, len(timestamps(r)) <= 16)
+				r.Copy()
+				r.Window(0, int64(i))
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		r.Add(int64(i)*int64(time.Millisecond), i)
+	}
+	wg.Wait()
+	assert.Equal(t, 16, r.Len())
+}
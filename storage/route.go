@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidRoute sign what route has no points or some of them are invalid
+var ErrInvalidRoute = errors.New("Route must have valid points")
+
+// ErrInvalidPolyline sign what encoded polyline is malformed
+var ErrInvalidPolyline = errors.New("Invalid encoded polyline")
+
+const (
+	// routeRadius is a distance in meters drivers are searched around the route first,
+	// it's doubled until enough drivers are found
+	routeRadius = 500
+	// maxRouteRadius is the farthest distance in meters from the route drivers are searched
+	maxRouteRadius = 64000
+)
+
+// DecodePolyline decodes the encoded polyline algorithm format with precision of 5 decimal places
+func DecodePolyline(s string) ([]Location, error) {
+	var route []Location
+	var lat, lon int64
+	for i := 0; i < len(s); {
+		var deltas [2]int64
+		for k := range deltas {
+			var result int64
+			shift := uint(0)
+			for {
+				if i >= len(s) || shift > 30 {
+					return nil, ErrInvalidPolyline
+				}
+				b := int64(s[i]) - 63
+				i++
+				if b < 0 || b > 63 {
+					return nil, ErrInvalidPolyline
+				}
+				result |= (b & 0x1f) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+			}
+			if result&1 != 0 {
+				deltas[k] = ^(result >> 1)
+			} else {
+				deltas[k] = result >> 1
+			}
+		}
+		lat += deltas[0]
+		lon += deltas[1]
+		route = append(route, Location{Lat: float64(lat) / 1e5, Lon: float64(lon) / 1e5})
+	}
+	return route, nil
+}
+
+// ClosestOnRoute returns the point of the route closest to the location and the distance to it in meters.
+// Segments are projected to a plane around the location, which is precise for distances of a city.
+func ClosestOnRoute(route []Location, l Location) (Location, float64) {
+	closest, distance := l, math.Inf(1)
+	for i := range route {
+		a, b := route[i], route[i]
+		if i+1 < len(route) {
+			b = route[i+1]
+		} else if i > 0 {
+			// the last point is the end of the previous segment
+			continue
+		}
+		p := closestOnSegment(a, b, l)
+		if d := Distance(p, l); d < distance {
+			closest, distance = p, d
+		}
+	}
+	return closest, distance
+}
+
+// closestOnSegment returns the point of the segment closest to the location
+func closestOnSegment(a, b, l Location) Location {
+	scale := math.Cos(l.Lat * math.Pi / 180)
+	ax, ay := (a.Lon-l.Lon)*scale, a.Lat-l.Lat
+	bx, by := (b.Lon-l.Lon)*scale, b.Lat-l.Lat
+	dx, dy := bx-ax, by-ay
+	length := dx*dx + dy*dy
+	if length == 0 {
+		return a
+	}
+	t := math.Max(0, math.Min(1, -(ax*dx+ay*dy)/length))
+	return Location{Lat: a.Lat + t*(b.Lat-a.Lat), Lon: a.Lon + t*(b.Lon-a.Lon)}
+}
+
+// NearestToRoute returns up to count drivers matching all filters nearest to any point of the route,
+// ordered by the distance to it. Drivers farther than maxRouteRadius are not returned.
+func (s *DriverStorage) NearestToRoute(route []Location, count int, filters ...Filter) ([]*Driver, error) {
+	if !validRoute(route) {
+		return nil, ErrInvalidRoute
+	}
+	if s.reads != nil {
+		return nearestToRoute(s.readSnapshot(), route, count, matchAll(filters)), nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return nearestToRoute(s.locations, route, count, matchAll(filters)), nil
+}
+
+// NearestToRoute merges drivers of all shards nearest to the route
+func (s *ShardedStorage) NearestToRoute(route []Location, count int, filters ...Filter) ([]*Driver, error) {
+	var drivers []*Driver
+	for _, shard := range s.shards {
+		found, err := shard.NearestToRoute(route, count, filters...)
+		if err != nil {
+			return nil, err
+		}
+		drivers = append(drivers, found...)
+	}
+	return byRouteDistance(route, drivers, count), nil
+}
+
+// NearestToRoute merges drivers of all regions nearest to the route, it may cross regions
+func (s *RegionStorage) NearestToRoute(route []Location, count int, filters ...Filter) ([]*Driver, error) {
+	var drivers []*Driver
+	for _, r := range s.storages {
+		found, err := r.NearestToRoute(route, count, filters...)
+		if err != nil {
+			return nil, err
+		}
+		drivers = append(drivers, found...)
+	}
+	return byRouteDistance(route, drivers, count), nil
+}
+
+func validRoute(route []Location) bool {
+	if len(route) == 0 {
+		return false
+	}
+	for _, l := range route {
+		if !l.Valid() {
+			return false
+		}
+	}
+	return true
+}
+
+// nearestToRoute searches drivers around every segment of the route within the radius,
+// the radius is doubled until count drivers are found
+func nearestToRoute(locations index, route []Location, count int, filter Filter) []*Driver {
+	for radius := float64(routeRadius); ; radius *= 2 {
+		seen := make(map[int]bool)
+		var drivers []*Driver
+		for i := range route {
+			a, b := route[i], route[i]
+			if i+1 < len(route) {
+				b = route[i+1]
+			} else if i > 0 {
+				continue
+			}
+			minLat, minLon, maxLat, maxLon := segmentBox(a, b, radius)
+			for _, d := range locations.Search(minLat, minLon, maxLat, maxLon) {
+				if seen[d.ID] {
+					continue
+				}
+				seen[d.ID] = true
+				if filter != nil && !filter(d) {
+					continue
+				}
+				if _, distance := ClosestOnRoute(route, d.LastLocation); distance <= radius {
+					drivers = append(drivers, d)
+				}
+			}
+		}
+		if len(drivers) >= count || radius >= maxRouteRadius {
+			return byRouteDistance(route, drivers, count)
+		}
+	}
+}
+
+// segmentBox returns the bounding box of the segment expanded by the radius in meters
+func segmentBox(a, b Location, radius float64) (minLat, minLon, maxLat, maxLon float64) {
+	dLat := radius / metersPerDegree
+	minLat = math.Max(-90, math.Min(a.Lat, b.Lat)-dLat)
+	maxLat = math.Min(90, math.Max(a.Lat, b.Lat)+dLat)
+	// a degree of longitude is the shortest at the latitude farthest from the equator
+	dLon := 180.0
+	if cos := math.Cos(math.Max(math.Abs(minLat), math.Abs(maxLat)) * math.Pi / 180); cos > 0 {
+		dLon = math.Min(180, dLat/cos)
+	}
+	minLon = math.Max(-180, math.Min(a.Lon, b.Lon)-dLon)
+	maxLon = math.Min(180, math.Max(a.Lon, b.Lon)+dLon)
+	return minLat, minLon, maxLat, maxLon
+}
+
+// byRouteDistance orders drivers by distance to the route and returns first count of them
+func byRouteDistance(route []Location, drivers []*Driver, count int) []*Driver {
+	distances := make(map[*Driver]float64, len(drivers))
+	for _, d := range drivers {
+		_, distances[d] = ClosestOnRoute(route, d.LastLocation)
+	}
+	sort.SliceStable(drivers, func(i, j int) bool {
+		return distances[drivers[i]] < distances[drivers[j]]
+	})
+	if len(drivers) > count {
+		drivers = drivers[:count]
+	}
+	return drivers
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodePolyline(t *testing.T) {
+	route, err := DecodePolyline("_p~iF~ps|U_ulLnnqC_mqNvxq`@")
+	assert.NoError(t, err)
+	if assert.Len(t, route, 3) {
+		assert.InDelta(t, 38.5, route[0].Lat, 1e-9)
+		assert.InDelta(t, -120.2, route[0].Lon, 1e-9)
+		assert.InDelta(t, 40.7, route[1].Lat, 1e-9)
+		assert.InDelta(t, -120.95, route[1].Lon, 1e-9)
+		assert.InDelta(t, 43.252, route[2].Lat, 1e-9)
+		assert.InDelta(t, -126.453, route[2].Lon, 1e-9)
+	}
+	_, err = DecodePolyline("_p~iF~ps|U_")
+	assert.Equal(t, ErrInvalidPolyline, err)
+	_, err = DecodePolyline("_p~iF ps|U")
+	assert.Equal(t, ErrInvalidPolyline, err)
+}
+
+func TestNearestToRoute(t *testing.T) {
+	route := []Location{{Lat: 1, Lon: 1}, {Lat: 1, Lon: 1.1}, {Lat: 1.1, Lon: 1.1}}
+	closest, distance := ClosestOnRoute(route, Location{Lat: 1.001, Lon: 1.05})
+	assert.InDelta(t, 1, closest.Lat, 1e-9)
+	assert.InDelta(t, 1.05, closest.Lon, 1e-9)
+	assert.InDelta(t, 111.2, distance, 0.1)
+
+	s := New(10)
+	// near the middle of the first segment, near the corner, far from ends of the route
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1.002, Lon: 1.05}}))
+	assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 1.05, Lon: 1.101}}))
+	assert.NoError(t, s.Set(&Driver{ID: 3, LastLocation: Location{Lat: 1.05, Lon: 1.2}}))
+	assert.NoError(t, s.Set(&Driver{ID: 4, LastLocation: Location{Lat: 1.001, Lon: 1.06}, Status: StatusBusy}))
+
+	drivers, err := s.NearestToRoute(route, 2, Available())
+	assert.NoError(t, err)
+	if assert.Len(t, drivers, 2) {
+		assert.Equal(t, 2, drivers[0].ID)
+		assert.Equal(t, 1, drivers[1].ID)
+	}
+	// the radius grows until enough drivers are found
+	drivers, err = s.NearestToRoute(route, 10)
+	assert.NoError(t, err)
+	assert.Len(t, drivers, 4)
+
+	_, err = s.NearestToRoute(nil, 2)
+	assert.Equal(t, ErrInvalidRoute, err)
+
+	sharded := NewSharded(4, 10)
+	for _, d := range []*Driver{{ID: 1, LastLocation: Location{Lat: 1.002, Lon: 1.05}}, {ID: 2, LastLocation: Location{Lat: 1.05, Lon: 1.101}}} {
+		assert.NoError(t, sharded.Set(d))
+	}
+	drivers, err = sharded.NearestToRoute(route, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, drivers, 1) {
+		assert.Equal(t, 2, drivers[0].ID)
+	}
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"math"
+	"sort"
+
+	"github.com/golang/geo/r1"
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// s2Index keeps drivers in buckets by S2 cell of fixed level.
+// Queries are covered by spherical caps and rects,
+// so they work near the poles and across the antimeridian.
+type s2Index struct {
+	level   int
+	buckets map[s2.CellID]map[int]*Driver
+	cells   map[int]s2.CellID
+}
+
+// newS2Index loads drivers sorted by cell, so every bucket is allocated once with its final size
+func newS2Index(level int, drivers []*Driver) index {
+	if level < 0 {
+		level = 0
+	}
+	if level > s2.MaxLevel {
+		level = s2.MaxLevel
+	}
+	x := &s2Index{
+		level:   level,
+		buckets: make(map[s2.CellID]map[int]*Driver),
+		cells:   make(map[int]s2.CellID, len(drivers)),
+	}
+	cells := make([]s2.CellID, len(drivers))
+	order := make([]int, len(drivers))
+	for i, d := range drivers {
+		cells[i] = x.cell(d.LastLocation)
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return cells[order[i]] < cells[order[j]] })
+	for start := 0; start < len(order); {
+		cell := cells[order[start]]
+		end := start + 1
+		for end < len(order) && cells[order[end]] == cell {
+			end++
+		}
+		bucket := make(map[int]*Driver, end-start)
+		for _, i := range order[start:end] {
+			bucket[drivers[i].ID] = drivers[i]
+			x.cells[drivers[i].ID] = cell
+		}
+		x.buckets[cell] = bucket
+		start = end
+	}
+	return x
+}
+
+func (i *s2Index) cell(l Location) s2.CellID {
+	return s2.CellIDFromLatLng(s2.LatLngFromDegrees(l.Lat, l.Lon)).Parent(i.level)
+}
+
+func (i *s2Index) Insert(d *Driver) {
+	cell := i.cell(d.LastLocation)
+	if old, ok := i.cells[d.ID]; ok && old != cell {
+		i.remove(old, d.ID)
+	}
+
+	bucket, ok := i.buckets[cell]
+	if !ok {
+		bucket = make(map[int]*Driver)
+		i.buckets[cell] = bucket
+	}
+	bucket[d.ID] = d
+	i.cells[d.ID] = cell
+}
+
+func (i *s2Index) Delete(d *Driver) bool {
+	cell, ok := i.cells[d.ID]
+	if !ok {
+		return false
+	}
+	i.remove(cell, d.ID)
+	delete(i.cells, d.ID)
+	return true
+}
+
+func (i *s2Index) remove(cell s2.CellID, id int) {
+	bucket := i.buckets[cell]
+	delete(bucket, id)
+	if len(bucket) == 0 {
+		delete(i.buckets, cell)
+	}
+}
+
+// Nearest covers growing caps around the point until count drivers
+// are found within the cap radius
+func (i *s2Index) Nearest(point Location, count int, filter Filter) []*Driver {
+	if count <= 0 {
+		return nil
+	}
+	if count >= len(i.cells) {
+		return i.all(filter)
+	}
+
+	center := s2.PointFromLatLng(s2.LatLngFromDegrees(point.Lat, point.Lon))
+	// start with a cap about the size of a cell
+	radius := s1.Angle(math.Sqrt(s2.AvgAreaMetric.Value(i.level)))
+	for {
+		capRegion := s2.CapFromCenterAngle(center, radius)
+		if capRegion.IsFull() {
+			return i.all(filter)
+		}
+		drivers, ok := i.cover(capRegion, filter)
+		if !ok {
+			return i.all(filter)
+		}
+		if len(drivers) >= count && kthDistance(point, drivers, count) <= radius.Radians()*earthRadius {
+			return drivers
+		}
+		radius *= 2
+	}
+}
+
+func (i *s2Index) Search(minLat, minLon, maxLat, maxLon float64) []*Driver {
+	// the rect is made of intervals, adding corners to it takes the shorter way around the globe
+	// and turns boxes wider than 180 degrees inside out
+	rect := s2.Rect{
+		Lat: r1.Interval{Lo: minLat * math.Pi / 180, Hi: maxLat * math.Pi / 180},
+		Lng: s1.IntervalFromEndpoints(minLon*math.Pi/180, maxLon*math.Pi/180),
+	}
+	drivers, ok := i.cover(rect, nil)
+	if !ok {
+		return i.all(nil)
+	}
+	return drivers
+}
+
+// cover returns drivers in cells covering the region, it returns false
+// if the covering would have more cells than there are non-empty buckets
+func (i *s2Index) cover(region s2.Region, filter Filter) ([]*Driver, bool) {
+	cells := region.CapBound().Area() / s2.AvgAreaMetric.Value(i.level)
+	if cells > float64(len(i.buckets)) {
+		return nil, false
+	}
+
+	coverer := &s2.RegionCoverer{MinLevel: i.level, MaxLevel: i.level, MaxCells: len(i.buckets)}
+	var drivers []*Driver
+	for _, cell := range coverer.Covering(region) {
+		drivers = appendMatching(drivers, i.buckets[cell], filter)
+	}
+	return drivers, true
+}
+
+func (i *s2Index) all(filter Filter) []*Driver {
+	drivers := make([]*Driver, 0, len(i.cells))
+	for _, bucket := range i.buckets {
+		drivers = appendMatching(drivers, bucket, filter)
+	}
+	return drivers
+}
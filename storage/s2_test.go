@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS2IndexNearest(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	s := New(1, WithS2Index(13))
+	var all []*Driver
+	for i := 0; i < 1000; i++ {
+		d := &Driver{
+			ID: i,
+			LastLocation: Location{
+				Lat: 42.8 + r.Float64()*0.2,
+				Lon: 74.5 + r.Float64()*0.2,
+			},
+		}
+		s.Set(d)
+		all = append(all, d)
+	}
+
+	point := Location{Lat: 42.9, Lon: 74.6}
+	sort.Slice(all, func(i, j int) bool {
+		return Distance(point, all[i].LastLocation) < Distance(point, all[j].LastLocation)
+	})
+
+	drivers := s.Nearest(rtreego.Point{point.Lat, point.Lon}, 10)
+	assert.Equal(t, 10, len(drivers))
+	for i, d := range drivers {
+		assert.Equal(t, all[i].ID, d.ID)
+	}
+}
+
+func TestS2IndexAntimeridianAndPole(t *testing.T) {
+	s := New(1, WithS2Index(13))
+	// Fiji on both sides of the antimeridian
+	s.Set(&Driver{ID: 1, LastLocation: Location{Lat: -16.8, Lon: 179.99}})
+	s.Set(&Driver{ID: 2, LastLocation: Location{Lat: -16.8, Lon: -179.99}})
+	s.Set(&Driver{ID: 3, LastLocation: Location{Lat: -16.8, Lon: 178.5}})
+	// Svalbard
+	s.Set(&Driver{ID: 4, LastLocation: Location{Lat: 89.99, Lon: 0}})
+	s.Set(&Driver{ID: 5, LastLocation: Location{Lat: 89.99, Lon: 180}})
+
+	drivers := s.Nearest(rtreego.Point{-16.8, -179.995}, 2)
+	assert.Equal(t, 2, len(drivers))
+	assert.ElementsMatch(t, []int{1, 2}, []int{drivers[0].ID, drivers[1].ID})
+
+	drivers = s.Nearest(rtreego.Point{89.99, 90}, 2)
+	assert.Equal(t, 2, len(drivers))
+	assert.ElementsMatch(t, []int{4, 5}, []int{drivers[0].ID, drivers[1].ID})
+}
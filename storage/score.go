@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RatingAttribute is the attribute of driver rating used for scoring
+const RatingAttribute = "rating"
+
+// ErrInvalidWeights sign what score weights are not in name:weight format or have unknown names
+var ErrInvalidWeights = errors.New("Weights must be comma separated name:weight of distance, rating, idle and heading")
+
+// Weights of score terms, drivers with a higher score are ranked first.
+// Distance is subtracted per kilometer, rating is added per point of the rating attribute,
+// idle time per minute and heading alignment from -1 heading away to 1 heading to the point.
+type Weights struct {
+	Distance float64
+	Rating   float64
+	Idle     float64
+	Heading  float64
+}
+
+// ParseWeights parses comma separated name:weight pairs, missing terms have zero weight
+func ParseWeights(s string) (Weights, error) {
+	var w Weights
+	if s == "" {
+		return w, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(kv) != 2 {
+			return w, ErrInvalidWeights
+		}
+		v, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			return w, ErrInvalidWeights
+		}
+		switch kv[0] {
+		case "distance":
+			w.Distance = v
+		case "rating":
+			w.Rating = v
+		case "idle":
+			w.Idle = v
+		case "heading":
+			w.Heading = v
+		default:
+			return w, ErrInvalidWeights
+		}
+	}
+	return w, nil
+}
+
+// Zero returns true if all weights are zero, drivers are ranked by distance then
+func (w Weights) Zero() bool {
+	return w == Weights{}
+}
+
+// Score of the driver for the point at the time, heading counts for moving drivers only
+func (w Weights) Score(d *Driver, point Location, now time.Time) float64 {
+	score := -w.Distance * Distance(d.LastLocation, point) / 1000
+	if w.Rating != 0 {
+		// drivers without a valid rating are rated zero
+		rating, err := strconv.ParseFloat(d.Attributes[RatingAttribute], 64)
+		if err == nil && !math.IsNaN(rating) && !math.IsInf(rating, 0) {
+			score += w.Rating * rating
+		}
+	}
+	score += w.Idle * d.IdleFor(now).Minutes()
+	if w.Heading != 0 && d.Speed >= minETASpeed {
+		delta := (Bearing(d.LastLocation, point) - d.Heading) * math.Pi / 180
+		score += w.Heading * math.Cos(delta)
+	}
+	return score
+}
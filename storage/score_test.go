@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWeights(t *testing.T) {
+	w, err := ParseWeights("distance:1, rating:0.5,idle:-2,heading:3")
+	assert.NoError(t, err)
+	assert.Equal(t, Weights{Distance: 1, Rating: 0.5, Idle: -2, Heading: 3}, w)
+
+	w, err = ParseWeights("")
+	assert.NoError(t, err)
+	assert.True(t, w.Zero())
+
+	for _, s := range []string{"distance", "distance:x", "speed:1", "rating:NaN"} {
+		_, err := ParseWeights(s)
+		assert.Equal(t, ErrInvalidWeights, err, s)
+	}
+}
+
+func TestScore(t *testing.T) {
+	now := time.Now()
+	point := Location{Lat: 1, Lon: 1}
+	d := &Driver{
+		LastLocation: Location{Lat: 1.01, Lon: 1},
+		Attributes:   map[string]string{RatingAttribute: "4.5"},
+		Status:       StatusAvailable,
+		IdleSince:    now.Add(-2 * time.Minute).UnixNano(),
+	}
+	assert.InDelta(t, -1.112, Weights{Distance: 1}.Score(d, point, now), 0.001)
+	assert.Equal(t, 9.0, Weights{Rating: 2}.Score(d, point, now))
+	assert.Equal(t, 2.0, Weights{Idle: 1}.Score(d, point, now))
+	// standing drivers have no heading
+	assert.Equal(t, 0.0, Weights{Heading: 1}.Score(d, point, now))
+
+	d.Speed, d.Heading = 10, 180
+	assert.InDelta(t, 1, Weights{Heading: 1}.Score(d, point, now), 1e-6)
+	d.Heading = 0
+	assert.InDelta(t, -1, Weights{Heading: 1}.Score(d, point, now), 1e-6)
+
+	d.Attributes[RatingAttribute] = "bad"
+	assert.Equal(t, 0.0, Weights{Rating: 2}.Score(d, point, now))
+}
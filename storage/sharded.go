@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/pkg/errors"
+)
+
+// ShardedStorage partitions drivers by ID across several DriverStorage shards,
+// each with its own lock and index. Spatial queries fan out to all shards.
+type ShardedStorage struct {
+	shards []*DriverStorage
+}
+
+var _ Storage = (*ShardedStorage)(nil)
+
+// NewSharded creates ShardedStorage with given number of shards,
+// options are applied to every shard
+func NewSharded(shards, historySize int, opts ...Option) *ShardedStorage {
+	if shards < 1 {
+		shards = 1
+	}
+	s := &ShardedStorage{shards: make([]*DriverStorage, shards)}
+	for i := range s.shards {
+		s.shards[i] = New(historySize, opts...)
+	}
+	return s
+}
+
+func (s *ShardedStorage) shard(id int) *DriverStorage {
+	return s.shards[s.shardIndex(id)]
+}
+
+func (s *ShardedStorage) shardIndex(id int) int {
+	i := id % len(s.shards)
+	if i < 0 {
+		i += len(s.shards)
+	}
+	return i
+}
+
+// Set an Driver to the storage, replacing any existing item.
+func (s *ShardedStorage) Set(driver *Driver) error {
+	return s.shard(driver.ID).Set(driver)
+}
+
+// SetMany sets drivers grouped by shard, locking every shard once
+func (s *ShardedStorage) SetMany(drivers []*Driver) error {
+	groups := make([][]*Driver, len(s.shards))
+	for _, d := range drivers {
+		i := s.shardIndex(d.ID)
+		groups[i] = append(groups[i], d)
+	}
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		if err := s.shards[i].SetMany(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get gets driver from storage and an error if nothing found
+func (s *ShardedStorage) Get(id int) (*Driver, error) {
+	return s.shard(id).Get(id)
+}
+
+// Delete deletes a driver from storage.
+func (s *ShardedStorage) Delete(id int) error {
+	return s.shard(id).Delete(id)
+}
+
+// DeleteMany deletes drivers grouped by shard, skipping missing ones
+func (s *ShardedStorage) DeleteMany(ids []int) error {
+	groups := make([][]int, len(s.shards))
+	for _, id := range ids {
+		i := s.shardIndex(id)
+		groups[i] = append(groups[i], id)
+	}
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+		if err := s.shards[i].DeleteMany(group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetStatus changes status of the driver
+func (s *ShardedStorage) SetStatus(id int, status Status) error {
+	return s.shard(id).SetStatus(id, status)
+}
+
+// Nearest queries all shards concurrently and merges results by great-circle distance.
+// Point is Lat, Lon.
+func (s *ShardedStorage) Nearest(point rtreego.Point, count int, filters ...Filter) []*Driver {
+	results := make([][]*Driver, len(s.shards))
+	var wg sync.WaitGroup
+	for i, shard := range s.shards {
+		wg.Add(1)
+		go func(i int, shard *DriverStorage) {
+			defer wg.Done()
+			results[i] = shard.Nearest(point, count, filters...)
+		}(i, shard)
+	}
+	wg.Wait()
+
+	n := 0
+	for _, r := range results {
+		n += len(r)
+	}
+	drivers := make([]*Driver, 0, n)
+	for _, r := range results {
+		drivers = append(drivers, r...)
+	}
+	origin := Location{Lat: point[0], Lon: point[1]}
+	SortByDistance(origin, drivers)
+	if len(drivers) > count {
+		drivers = drivers[:count]
+	}
+	return drivers
+}
+
+// InBoundingBox returns all drivers located inside the bounding box
+func (s *ShardedStorage) InBoundingBox(minLat, minLon, maxLat, maxLon float64) ([]*Driver, error) {
+	var drivers []*Driver
+	for _, shard := range s.shards {
+		found, err := shard.InBoundingBox(minLat, minLon, maxLat, maxLon)
+		if err != nil {
+			return nil, err
+		}
+		drivers = append(drivers, found...)
+	}
+	return drivers, nil
+}
+
+// InPolygon returns all drivers located inside the polygon.
+func (s *ShardedStorage) InPolygon(polygon Polygon) ([]*Driver, error) {
+	var drivers []*Driver
+	for _, shard := range s.shards {
+		found, err := shard.InPolygon(polygon)
+		if err != nil {
+			return nil, err
+		}
+		drivers = append(drivers, found...)
+	}
+	return drivers, nil
+}
+
+// DeleteExpired removes all expired items from storage
+func (s *ShardedStorage) DeleteExpired() {
+	for _, shard := range s.shards {
+		shard.DeleteExpired()
+	}
+}
+
+// Save writes drivers of all shards to a single snapshot file,
+// so it can be restored with any number of shards.
+func (s *ShardedStorage) Save(path string) error {
+	var records []driverRecord
+	compacts := make([]func() error, 0, len(s.shards))
+	for _, shard := range s.shards {
+		r, compact, err := shard.capture()
+		if err != nil {
+			return err
+		}
+		records = append(records, r...)
+		compacts = append(compacts, compact)
+	}
+
+	if err := writeSnapshot(path, &snapshot{Drivers: records}); err != nil {
+		return err
+	}
+	for _, compact := range compacts {
+		if err := compact(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteSnapshot writes drivers of all shards to w in the format of Save, the WAL is not compacted
+func (s *ShardedStorage) WriteSnapshot(w io.Writer) error {
+	var records []driverRecord
+	for _, shard := range s.shards {
+		r, _, err := shard.capture()
+		if err != nil {
+			return err
+		}
+		records = append(records, r...)
+	}
+	return errors.Wrap(gob.NewEncoder(w).Encode(&snapshot{Drivers: records}), "could not encode snapshot")
+}
+
+// Load replaces the content of the storage with drivers from the snapshot at path.
+func (s *ShardedStorage) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "could not open snapshot")
+	}
+	defer f.Close()
+	return s.ReadSnapshot(f)
+}
+
+// ReadSnapshot replaces the content of the storage with drivers from the snapshot read from r.
+// Drivers are not written to the WAL, they are persisted by the next Save.
+func (s *ShardedStorage) ReadSnapshot(r io.Reader) error {
+	snap, err := decodeSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	records := make([][]driverRecord, len(s.shards))
+	for _, r := range snap.Drivers {
+		i := s.shardIndex(r.ID)
+		records[i] = append(records[i], r)
+	}
+	for i, shard := range s.shards {
+		if err := shard.restore(records[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenWAL opens a WAL for every shard in a subdirectory of dir named by shard number.
+// The number of shards must not change between restarts while the WAL is used.
+func (s *ShardedStorage) OpenWAL(dir string) error {
+	for i, shard := range s.shards {
+		if err := shard.OpenWAL(filepath.Join(dir, strconv.Itoa(i))); err != nil {
+			return errors.Wrapf(err, "could not open WAL of shard %d", i)
+		}
+	}
+	return nil
+}
+
+// Close closes WAL of all shards
+func (s *ShardedStorage) Close() error {
+	var err error
+	for _, shard := range s.shards {
+		if e := shard.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
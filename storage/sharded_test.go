@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedStorage(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	s := NewSharded(4, 10)
+	var all []*Driver
+	for i := 0; i < 100; i++ {
+		d := &Driver{
+			ID: i,
+			LastLocation: Location{
+				Lat: 42.8 + r.Float64()*0.2,
+				Lon: 74.5 + r.Float64()*0.2,
+			},
+		}
+		assert.NoError(t, s.Set(d))
+		all = append(all, d)
+	}
+
+	d, err := s.Get(42)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, d.ID)
+
+	point := Location{Lat: 42.9, Lon: 74.6}
+	sort.Slice(all, func(i, j int) bool {
+		return Distance(point, all[i].LastLocation) < Distance(point, all[j].LastLocation)
+	})
+	drivers := s.Nearest(rtreego.Point{point.Lat, point.Lon}, 5)
+	assert.Equal(t, 5, len(drivers))
+	for i, d := range drivers {
+		assert.Equal(t, all[i].ID, d.ID)
+	}
+
+	drivers, err = s.InBoundingBox(42, 74, 43, 75)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, len(drivers))
+
+	assert.NoError(t, s.Delete(42))
+	_, err = s.Get(42)
+	assert.Equal(t, ErrDriverDoesNotExist, err)
+
+	assert.Equal(t, 99, s.Len())
+	st := s.Stats()
+	assert.Equal(t, 99, st.Drivers)
+	assert.Equal(t, uint64(100), st.Inserted)
+	assert.Equal(t, uint64(1), st.Deleted)
+	assert.Equal(t, 99, st.Index.Size)
+	assert.Len(t, st.Shards, 4)
+
+	page := s.List(40, 5)
+	if assert.Len(t, page, 5) {
+		assert.Equal(t, 41, page[0].ID)
+		assert.Equal(t, 43, page[1].ID)
+		assert.Equal(t, 46, page[4].ID)
+	}
+}
+
+func TestShardedSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nearestdots")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "drivers.snapshot")
+
+	s := NewSharded(4, 10)
+	for i := 0; i < 10; i++ {
+		s.Set(&Driver{ID: i})
+	}
+	assert.NoError(t, s.Save(path))
+
+	// snapshot does not depend on the number of shards
+	restored := New(10)
+	assert.NoError(t, restored.Load(path))
+	for i := 0; i < 10; i++ {
+		_, err := restored.Get(i)
+		assert.NoError(t, err)
+	}
+
+	resharded := NewSharded(3, 10)
+	assert.NoError(t, resharded.Load(path))
+	for i := 0; i < 10; i++ {
+		_, err := resharded.Get(i)
+		assert.NoError(t, err)
+	}
+}
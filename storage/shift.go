@@ -0,0 +1,123 @@
+package storage
+
+import "time"
+
+// maxShifts is how many latest shifts of a driver are kept
+const maxShifts = 50
+
+// Shift is a period a driver worked, End is zero while the shift goes on. Both are unix nanoseconds.
+type Shift struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end,omitempty"`
+}
+
+// StartShift puts the driver on shift, so it's available for nearest queries again.
+// Drivers start a shift with their first location, starting the current shift does nothing.
+func (s *DriverStorage) StartShift(id int) error {
+	return s.changeShift(id, false)
+}
+
+// StopShift takes the driver off shift, it keeps its location updates but isn't available until the next shift
+func (s *DriverStorage) StopShift(id int) error {
+	return s.changeShift(id, true)
+}
+
+// Shifts returns the latest shifts of the driver, oldest first
+func (s *DriverStorage) Shifts(id int) ([]Shift, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	d, ok := s.drivers[id]
+	if !ok {
+		return nil, ErrDriverDoesNotExist
+	}
+	return append([]Shift(nil), d.shifts...), nil
+}
+
+// StartShift puts the driver of its shard on shift
+func (s *ShardedStorage) StartShift(id int) error {
+	return s.shard(id).StartShift(id)
+}
+
+// StopShift takes the driver of its shard off shift
+func (s *ShardedStorage) StopShift(id int) error {
+	return s.shard(id).StopShift(id)
+}
+
+// Shifts returns shifts of the driver kept in its shard
+func (s *ShardedStorage) Shifts(id int) ([]Shift, error) {
+	return s.shard(id).Shifts(id)
+}
+
+// StartShift puts the driver of its region on shift
+func (s *RegionStorage) StartShift(id int) error {
+	defer s.lock(id)()
+	i := s.owner(id)
+	if i < 0 {
+		return ErrDriverDoesNotExist
+	}
+	return s.storages[i].StartShift(id)
+}
+
+// StopShift takes the driver of its region off shift
+func (s *RegionStorage) StopShift(id int) error {
+	defer s.lock(id)()
+	i := s.owner(id)
+	if i < 0 {
+		return ErrDriverDoesNotExist
+	}
+	return s.storages[i].StopShift(id)
+}
+
+// Shifts returns shifts of the driver kept in the storage of its region
+func (s *RegionStorage) Shifts(id int) ([]Shift, error) {
+	i := s.owner(id)
+	if i < 0 {
+		return nil, ErrDriverDoesNotExist
+	}
+	return s.storages[i].Shifts(id)
+}
+
+// changeShift records the shift change to the WAL if it's open and applies it
+func (s *DriverStorage) changeShift(id int, off bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.drivers[id]
+	if !ok {
+		return ErrDriverDoesNotExist
+	}
+	if d.OffShift == off {
+		return nil
+	}
+	now := time.Now().UnixNano()
+	if s.wal != nil {
+		if err := s.wal.append(walRecord{Op: walShift, ID: id, OffShift: off, Timestamp: now}); err != nil {
+			return err
+		}
+	}
+	version := *d
+	version.setShift(off, now)
+	s.publish(&version)
+	return nil
+}
+
+// setShift starts or ends the shift of the new version of the driver at now
+func (d *Driver) setShift(off bool, now int64) {
+	shifts := make([]Shift, len(d.shifts), len(d.shifts)+1)
+	copy(shifts, d.shifts)
+	n := len(shifts)
+	switch {
+	case off && n > 0 && shifts[n-1].End == 0:
+		shifts[n-1].End = now
+	case !off:
+		shifts = append(shifts, Shift{Start: now})
+	}
+	if len(shifts) > maxShifts {
+		shifts = shifts[len(shifts)-maxShifts:]
+	}
+	d.OffShift, d.shifts = off, shifts
+	// idle time counts from the start of the shift
+	d.IdleSince, d.idleAt = 0, Location{}
+	d.statusIdle(now)
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShifts(t *testing.T) {
+	for name, s := range map[string]Storage{
+		"single":  New(10),
+		"sharded": NewSharded(3, 10),
+	} {
+		sh := s.(interface {
+			StartShift(id int) error
+			StopShift(id int) error
+			Shifts(id int) ([]Shift, error)
+		})
+		assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Timestamp: 1}), name)
+		assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 2, Lon: 2}, Timestamp: 1}), name)
+		shifts, err := sh.Shifts(1)
+		assert.NoError(t, err, name)
+		assert.Equal(t, []Shift{{Start: 1}}, shifts, name)
+
+		// off shift drivers keep their locations but are not available
+		assert.NoError(t, sh.StopShift(1), name)
+		assert.NoError(t, sh.StopShift(1), name)
+		assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1.1, Lon: 1}, Timestamp: 2}), name)
+		d, err := s.Get(1)
+		assert.NoError(t, err, name)
+		assert.True(t, d.OffShift, name)
+		assert.Equal(t, Location{Lat: 1.1, Lon: 1}, d.LastLocation, name)
+		nearest := s.Nearest(rtreego.Point{1, 1}, 2, Available())
+		if assert.Len(t, nearest, 1, name) {
+			assert.Equal(t, 2, nearest[0].ID, name)
+		}
+		assert.Len(t, s.NearestAndLock(rtreego.Point{1, 1}, 2, 0), 1, name)
+
+		assert.NoError(t, sh.StartShift(1), name)
+		assert.NoError(t, sh.StartShift(1), name)
+		assert.Len(t, s.Nearest(rtreego.Point{1, 1}, 2, Available()), 2, name)
+		shifts, err = sh.Shifts(1)
+		assert.NoError(t, err, name)
+		if assert.Len(t, shifts, 2, name) {
+			assert.NotZero(t, shifts[0].End, name)
+			assert.Zero(t, shifts[1].End, name)
+		}
+
+		assert.Equal(t, ErrDriverDoesNotExist, sh.StopShift(42), name)
+		_, err = sh.Shifts(42)
+		assert.Equal(t, ErrDriverDoesNotExist, err, name)
+	}
+}
@@ -0,0 +1,241 @@
+package storage
+
+import (
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/kdrake/nearestdots/storage/ring"
+	"github.com/pkg/errors"
+)
+
+type (
+	// snapshot is the on-disk representation of DriverStorage
+	snapshot struct {
+		Drivers []driverRecord
+	}
+	// driverRecord holds a driver with its location history,
+	// history is ordered from oldest to newest
+	driverRecord struct {
+		ID           int
+		LastLocation Location
+		Attributes   map[string]string
+		Status       Status
+		Expiration   int64
+		IdleSince    int64
+		OffShift     bool
+		Shifts       []Shift
+		Anomalies    int
+		AnomalyAt    int64
+		Version      uint64
+		History      []historyRecord
+		// HistorySeq is the number of the last location added to the history, so its downsampling survives restores.
+		// Snapshots saved before it have none and their locations are added again.
+		HistorySeq uint64
+	}
+	// historyRecord is a single timestamped location from the driver's history with the number of its addition
+	historyRecord struct {
+		Timestamp int64
+		Seq       uint64
+		Location  Location
+	}
+)
+
+// Save writes all drivers with their location history to the file at path.
+// The file is replaced atomically, so a crash never leaves a partial snapshot.
+// If the WAL is open, segments covered by the snapshot are removed.
+func (s *DriverStorage) Save(path string) error {
+	records, compact, err := s.capture()
+	if err != nil {
+		return err
+	}
+	if err := writeSnapshot(path, &snapshot{Drivers: records}); err != nil {
+		return err
+	}
+	return compact()
+}
+
+// WriteSnapshot writes all drivers with their location history to w in the format of Save,
+// the WAL is not compacted.
+func (s *DriverStorage) WriteSnapshot(w io.Writer) error {
+	records, _, err := s.capture()
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(gob.NewEncoder(w).Encode(&snapshot{Drivers: records}), "could not encode snapshot")
+}
+
+// capture returns records of all drivers and a function removing
+// WAL segments covered by them, call it once the records are saved
+func (s *DriverStorage) capture() ([]driverRecord, func() error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]driverRecord, 0, len(s.drivers))
+	for _, d := range s.drivers {
+		records = append(records, newDriverRecord(d))
+	}
+
+	wal := s.wal
+	if wal == nil {
+		return records, func() error { return nil }, nil
+	}
+	if err := wal.rotate(); err != nil {
+		return nil, nil, err
+	}
+	seq := wal.seq
+	return records, func() error { return wal.compact(seq) }, nil
+}
+
+func writeSnapshot(path string, snap *snapshot) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "could not create snapshot file")
+	}
+	defer os.Remove(f.Name())
+
+	if err := gob.NewEncoder(f).Encode(snap); err != nil {
+		f.Close()
+		return errors.Wrap(err, "could not encode snapshot")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "could not sync snapshot")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "could not close snapshot")
+	}
+	return errors.Wrap(os.Rename(f.Name(), path), "could not replace snapshot")
+}
+
+// Load replaces the content of the storage with drivers from the snapshot at path.
+func (s *DriverStorage) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "could not open snapshot")
+	}
+	defer f.Close()
+	return s.ReadSnapshot(f)
+}
+
+// ReadSnapshot replaces the content of the storage with drivers from the snapshot read from r.
+// Drivers are not written to the WAL, they are persisted by the next Save.
+func (s *DriverStorage) ReadSnapshot(r io.Reader) error {
+	snap, err := decodeSnapshot(r)
+	if err != nil {
+		return err
+	}
+	return s.restore(snap.Drivers)
+}
+
+func decodeSnapshot(r io.Reader) (*snapshot, error) {
+	var snap snapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, errors.Wrap(err, "could not decode snapshot")
+	}
+	return &snap, nil
+}
+
+// restore replaces the content of the storage with drivers from records
+func (s *DriverStorage) restore(records []driverRecord) error {
+	drivers := make(map[int]*Driver, len(records))
+	for _, r := range records {
+		d, err := r.driver(s.newHistory)
+		if err != nil {
+			return err
+		}
+		drivers[d.ID] = d
+	}
+	loaded := make([]*Driver, 0, len(drivers))
+	for _, d := range drivers {
+		loaded = append(loaded, d)
+	}
+	// the index is bulk loaded before taking the lock, readers are served the old content meanwhile
+	locations := s.newIndex(loaded)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drivers = drivers
+	s.locations = locations
+	s.resetReadSnapshot()
+	return nil
+}
+
+func newDriverRecord(d *Driver) driverRecord {
+	r := driverRecord{
+		ID:           d.ID,
+		LastLocation: d.LastLocation,
+		Attributes:   d.Attributes,
+		Status:       d.Status,
+		Expiration:   d.Expiration,
+		IdleSince:    d.IdleSince,
+		OffShift:     d.OffShift,
+		Shifts:       d.shifts,
+		Anomalies:    d.Anomalies,
+		AnomalyAt:    d.AnomalyAt,
+		Version:      d.Version,
+	}
+	if d.history == nil {
+		return r
+	}
+	// the ring is exported at once, so locations added meanwhile don't tear the history
+	seq, entries := d.history.Export()
+	r.HistorySeq = seq
+	r.History = make([]historyRecord, len(entries))
+	for i, e := range entries {
+		r.History[i] = historyRecord{Timestamp: e.Timestamp, Seq: e.Seq, Location: e.Value.(Location)}
+	}
+	return r
+}
+
+func (r driverRecord) driver(newHistory func() (*ring.Ring, error)) (*Driver, error) {
+	cache, err := newHistory()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create location history")
+	}
+	if r.HistorySeq > 0 {
+		entries := make([]ring.Entry, len(r.History))
+		for i, h := range r.History {
+			entries[i] = ring.Entry{Timestamp: h.Timestamp, Seq: h.Seq, Value: h.Location}
+		}
+		if err := cache.Import(r.HistorySeq, entries); err != nil {
+			return nil, errors.Wrapf(err, "could not restore history of driver %d", r.ID)
+		}
+	} else {
+		for _, h := range r.History {
+			cache.Add(h.Timestamp, h.Location)
+		}
+	}
+	var ts int64
+	cache.Each(func(t int64, _ interface{}) bool {
+		ts = t
+		return true
+	})
+	// snapshots saved before versions have none
+	version := r.Version
+	if version == 0 {
+		version = 1
+	}
+	d := &Driver{
+		ID:           r.ID,
+		LastLocation: r.LastLocation,
+		Attributes:   r.Attributes,
+		Status:       r.Status,
+		Expiration:   r.Expiration,
+		Timestamp:    ts,
+		IdleSince:    r.IdleSince,
+		OffShift:     r.OffShift,
+		Anomalies:    r.Anomalies,
+		AnomalyAt:    r.AnomalyAt,
+		Version:      version,
+		history:      cache,
+		idleAt:       r.LastLocation,
+		reported:     r.LastLocation,
+		shifts:       r.Shifts,
+	}
+	d.updateMotion()
+	return d, nil
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nearestdots")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "drivers.snapshot")
+
+	s := New(10)
+	s.Set(&Driver{
+		ID: 123,
+		LastLocation: Location{
+			Lat: 42.875799,
+			Lon: 74.588279,
+		},
+	})
+	s.Set(&Driver{
+		ID: 321,
+		LastLocation: Location{
+			Lat: 42.905508,
+			Lon: 74.588107,
+		},
+	})
+	assert.NoError(t, s.Save(path))
+
+	restored := New(10)
+	assert.NoError(t, restored.Load(path))
+
+	d, err := restored.Get(123)
+	assert.NoError(t, err)
+	assert.Equal(t, Location{Lat: 42.875799, Lon: 74.588279}, d.LastLocation)
+	assert.Equal(t, 1, d.Locations.Len())
+
+	d, err = restored.Get(321)
+	assert.NoError(t, err)
+	assert.Equal(t, Location{Lat: 42.905508, Lon: 74.588107}, d.LastLocation)
+
+	// drivers are further apart than their rects in the index, so the nearest one is unambiguous
+	drivers := restored.Nearest(rtreego.Point{42.905508, 74.588107}, 1)
+	assert.Equal(t, 1, len(drivers))
+	assert.Equal(t, 321, drivers[0].ID)
+}
+
+func TestLoadMissing(t *testing.T) {
+	s := New(10)
+	err := s.Load(filepath.Join(os.TempDir(), "nearestdots-missing.snapshot"))
+	assert.Error(t, err)
+}
+
+func TestWriteReadSnapshot(t *testing.T) {
+	s := New(10)
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}}))
+	var buf bytes.Buffer
+	assert.NoError(t, s.WriteSnapshot(&buf))
+
+	// the snapshot replaces drivers and is read by sharded storages too
+	sharded := NewSharded(4, 10)
+	assert.NoError(t, sharded.Set(&Driver{ID: 2, LastLocation: Location{Lat: 2, Lon: 2}}))
+	assert.NoError(t, sharded.ReadSnapshot(bytes.NewReader(buf.Bytes())))
+	assert.Equal(t, 1, sharded.Len())
+	_, err := sharded.Get(1)
+	assert.NoError(t, err)
+
+	buf.Reset()
+	assert.NoError(t, sharded.WriteSnapshot(&buf))
+	restored := New(10)
+	assert.NoError(t, restored.ReadSnapshot(&buf))
+	assert.Equal(t, 1, restored.Len())
+
+	assert.Error(t, restored.ReadSnapshot(bytes.NewReader([]byte("garbage"))))
+	assert.Equal(t, 1, restored.Len())
+}
+
+func TestSnapshotHistory(t *testing.T) {
+	opt := WithHistoryDownsampling(time.Minute, 2)
+	s := New(4, opt)
+	set := func(s *DriverStorage, i int) {
+		assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: float64(i), Lon: 1}, Timestamp: int64(i) * int64(30*time.Second)}))
+	}
+	for i := 1; i <= 6; i++ {
+		set(s, i)
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, s.WriteSnapshot(&buf))
+	restored := New(4, opt)
+	assert.NoError(t, restored.ReadSnapshot(&buf))
+
+	// histories keep downsampling the same locations after restores
+	for i := 7; i <= 10; i++ {
+		set(s, i)
+		set(restored, i)
+	}
+	expected, err := s.History(1, 0, 0)
+	assert.NoError(t, err)
+	history, err := restored.History(1, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, history)
+
+	// histories of snapshots saved before numbers of additions are restored too
+	buf.Reset()
+	assert.NoError(t, gob.NewEncoder(&buf).Encode(&snapshot{Drivers: []driverRecord{{
+		ID:           2,
+		LastLocation: Location{Lat: 2, Lon: 2},
+		History:      []historyRecord{{Timestamp: 1, Location: Location{Lat: 1, Lon: 1}}, {Timestamp: 2, Location: Location{Lat: 2, Lon: 2}}},
+	}}}))
+	assert.NoError(t, restored.ReadSnapshot(&buf))
+	d, err := restored.Get(2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, d.Locations.Len())
+	assert.Equal(t, int64(2), d.Timestamp)
+}
+
+func TestReadSnapshotBulkLoad(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, newSampleStorage(1000).WriteSnapshot(&buf))
+	for name, opt := range indexes {
+		inserted := newSampleStorage(1000, opt)
+		restored := New(1, opt)
+		assert.NoError(t, restored.ReadSnapshot(bytes.NewReader(buf.Bytes())), name)
+		assert.Equal(t, 1000, restored.Len(), name)
+
+		point := rtreego.Point{42.87, 74.6}
+		assert.Equal(t, ids(inserted.Nearest(point, 20)), ids(restored.Nearest(point, 20)), name)
+		box, err := restored.InBoundingBox(42.85, 74.55, 42.9, 74.65)
+		assert.NoError(t, err)
+		expected, _ := inserted.InBoundingBox(42.85, 74.55, 42.9, 74.65)
+		assert.ElementsMatch(t, ids(expected), ids(box), name)
+
+		// the loaded index is updated like the one built by inserts
+		assert.NoError(t, restored.Set(&Driver{ID: 1, LastLocation: Location{Lat: 42.87, Lon: 74.6}}), name)
+		assert.NoError(t, restored.Delete(2), name)
+		nearest := restored.Nearest(point, 1)
+		if assert.Len(t, nearest, 1, name) {
+			assert.Equal(t, 1, nearest[0].ID, name)
+		}
+		box, _ = restored.InBoundingBox(40, 70, 45, 80)
+		assert.Len(t, box, 999, name)
+	}
+}
+
+func ids(drivers []*Driver) []int {
+	result := make([]int, len(drivers))
+	for i, d := range drivers {
+		result[i] = d.ID
+	}
+	return result
+}
+
+func BenchmarkReadSnapshot(b *testing.B) {
+	var buf bytes.Buffer
+	if err := newSampleStorage(100000).WriteSnapshot(&buf); err != nil {
+		b.Fatal(err)
+	}
+	s := New(1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.ReadSnapshot(bytes.NewReader(buf.Bytes())); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+)
+
+// byDistance sorts drivers by distances computed once per driver
+type byDistance struct {
+	drivers   []*Driver
+	distances []float64
+}
+
+func (b *byDistance) Len() int { return len(b.drivers) }
+
+func (b *byDistance) Less(i, j int) bool { return b.distances[i] < b.distances[j] }
+
+func (b *byDistance) Swap(i, j int) {
+	b.drivers[i], b.drivers[j] = b.drivers[j], b.drivers[i]
+	b.distances[i], b.distances[j] = b.distances[j], b.distances[i]
+}
+
+// sorters keeps buffers of distances between queries
+var sorters = sync.Pool{New: func() interface{} { return new(byDistance) }}
+
+// SortByDistance stable sorts drivers by great-circle distance from the origin
+func SortByDistance(origin Location, drivers []*Driver) {
+	b := sorters.Get().(*byDistance)
+	b.drivers = drivers
+	b.distances = b.distances[:0]
+	for _, d := range drivers {
+		b.distances = append(b.distances, Distance(origin, d.LastLocation))
+	}
+	sort.Stable(b)
+	b.drivers = nil
+	sorters.Put(b)
+}
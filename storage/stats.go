@@ -0,0 +1,134 @@
+package storage
+
+import "time"
+
+type (
+	// Stats describes content of the storage and counts mutations since start
+	Stats struct {
+		Drivers  int    `json:"drivers"`
+		Inserted uint64 `json:"inserted"`
+		Updated  uint64 `json:"updated"`
+		Deleted  uint64 `json:"deleted"`
+		Expired  uint64 `json:"expired"`
+		// Throttled counts updates rejected for coming too often
+		Throttled uint64 `json:"throttled"`
+		// Duplicates counts retried updates of locations already set
+		Duplicates uint64 `json:"duplicates"`
+		// Anomalies counts locations implying impossible speed, rejected or flagged
+		Anomalies uint64       `json:"anomalies"`
+		Index     IndexStats   `json:"index"`
+		History   HistoryStats `json:"history"`
+		// Shards are stats of every shard of ShardedStorage
+		Shards []Stats `json:"shards,omitempty"`
+		// Regions are stats of every region of RegionStorage by name
+		Regions map[string]Stats `json:"regions,omitempty"`
+	}
+	// IndexStats describes the spatial index, Depth is set for the rtree only
+	// and Buckets for cell based indexes only
+	IndexStats struct {
+		Type    string `json:"type"`
+		Size    int    `json:"size"`
+		Depth   int    `json:"depth,omitempty"`
+		Buckets int    `json:"buckets,omitempty"`
+	}
+	// counters are updated under the write lock
+	counters struct {
+		inserted   uint64
+		updated    uint64
+		deleted    uint64
+		expired    uint64
+		throttled  uint64
+		duplicates uint64
+		anomalies  uint64
+		// historyEvicted counts locations dropped or downsampled from histories
+		historyEvicted uint64
+	}
+)
+
+// Len returns number of drivers in the storage
+func (s *DriverStorage) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.drivers)
+}
+
+// Stats returns storage statistics
+func (s *DriverStorage) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	history := HistoryStats{Evictions: s.counters.historyEvicted}
+	now := time.Now().UnixNano()
+	for _, d := range s.drivers {
+		st := historyStats(d.history, now)
+		// evictions are counted by the storage, so ones of removed drivers are kept
+		st.Evictions = 0
+		history.add(st)
+	}
+	return Stats{
+		Drivers:    len(s.drivers),
+		Inserted:   s.counters.inserted,
+		Updated:    s.counters.updated,
+		Deleted:    s.counters.deleted,
+		Expired:    s.counters.expired,
+		Throttled:  s.counters.throttled,
+		Duplicates: s.counters.duplicates,
+		Anomalies:  s.counters.anomalies,
+		Index:      s.locations.Stats(),
+		History:    history,
+	}
+}
+
+// Len returns number of drivers in all shards
+func (s *ShardedStorage) Len() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Stats returns totals of all shards along with stats of every shard
+func (s *ShardedStorage) Stats() Stats {
+	total := Stats{Shards: make([]Stats, len(s.shards))}
+	for i, shard := range s.shards {
+		st := shard.Stats()
+		total.Shards[i] = st
+		total.add(st)
+	}
+	return total
+}
+
+// add adds counters and index sizes of a part of the storage to its totals
+func (s *Stats) add(st Stats) {
+	s.Drivers += st.Drivers
+	s.Inserted += st.Inserted
+	s.Updated += st.Updated
+	s.Deleted += st.Deleted
+	s.Expired += st.Expired
+	s.Throttled += st.Throttled
+	s.Duplicates += st.Duplicates
+	s.Anomalies += st.Anomalies
+	s.Index.Type = st.Index.Type
+	s.Index.Size += st.Index.Size
+	s.Index.Buckets += st.Index.Buckets
+	if st.Index.Depth > s.Index.Depth {
+		s.Index.Depth = st.Index.Depth
+	}
+	s.History.add(st.History)
+}
+
+func (r *rtreeIndex) Stats() IndexStats {
+	depth := r.loaded.Depth()
+	if r.tree.Depth() > depth {
+		depth = r.tree.Depth()
+	}
+	return IndexStats{Type: "rtree", Size: r.loaded.Size() - r.stale + r.tree.Size(), Depth: depth}
+}
+
+func (g *geohashIndex) Stats() IndexStats {
+	return IndexStats{Type: "geohash", Size: len(g.cells), Buckets: len(g.buckets)}
+}
+
+func (i *s2Index) Stats() IndexStats {
+	return IndexStats{Type: "s2", Size: len(i.cells), Buckets: len(i.buckets)}
+}
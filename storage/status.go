@@ -0,0 +1,43 @@
+package storage
+
+import "github.com/pkg/errors"
+
+// Status is driver's availability for new orders
+type Status string
+
+// Driver statuses
+const (
+	StatusAvailable Status = "available"
+	StatusBusy      Status = "busy"
+	StatusOffline   Status = "offline"
+)
+
+// ErrInvalidStatus sign what status is not one of known statuses
+var ErrInvalidStatus = errors.New("Invalid status")
+
+// Valid returns true if status is one of known statuses
+func (s Status) Valid() bool {
+	switch s {
+	case StatusAvailable, StatusBusy, StatusOffline:
+		return true
+	}
+	return false
+}
+
+// StatusFilter matches drivers having one of given statuses
+func StatusFilter(statuses ...Status) Filter {
+	return func(d *Driver) bool {
+		for _, s := range statuses {
+			if d.Status == s {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SetStatus changes status of the driver
+func (s *DriverStorage) SetStatus(id int, status Status) error {
+	_, err := s.SetStatusIf(id, status, 0)
+	return err
+}
@@ -1,11 +1,13 @@
 package storage
 
 import (
+	"context"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/dhconnelly/rtreego"
-	"github.com/kdrake/nearestdots/storage/lru"
+	"github.com/kdrake/nearestdots/storage/ring"
 	"github.com/pkg/errors"
 )
 
@@ -15,15 +17,93 @@ type (
 		Lat float64 `json:"lat"`
 		Lon float64 `json:"lon"`
 	}
-	// Driver model to store driver data
+	// Driver model to store driver data. Drivers returned by the storage are versions
+	// which are never modified, they are replaced by new versions on every change.
 	Driver struct {
-		ID           int      `json:"id"`
-		LastLocation Location `json:"location"`
-		Expiration   int64    `json:"-"`
-		Locations    *lru.LRU `json:"-"`
+		ID            int               `json:"id"`
+		LastLocation  Location          `json:"location"`
+		Attributes    map[string]string `json:"attributes,omitempty"`
+		Status        Status            `json:"status"`
+		Speed         float64           `json:"speed"`                    // meters per second
+		Heading       float64           `json:"heading"`                  // degrees clockwise from north
+		Timestamp     int64             `json:"timestamp"`                // unix nanoseconds of LastLocation
+		ReservedUntil int64             `json:"reserved_until,omitempty"` // unix nanoseconds, set by NearestAndLock
+		IdleSince     int64             `json:"idle_since,omitempty"`     // unix nanoseconds since the driver is available and standing
+		OffShift      bool              `json:"off_shift,omitempty"`      // set by StopShift, off shift drivers are not available
+		Anomalies     int               `json:"anomalies,omitempty"`      // number of locations implying impossible speed
+		AnomalyAt     int64             `json:"anomaly_at,omitempty"`     // unix nanoseconds of the last of them
+		Version       uint64            `json:"version,omitempty"`        // incremented on every change of status or attributes
+		Expiration    int64             `json:"-"`
+		// Locations is a copy of the history set by Get
+		Locations *ring.Ring `json:"-"`
+		// history and filter state are shared by versions of the driver, the history is safe
+		// for concurrent reads, the filter state is used under the storage lock
+		history *ring.Ring
+		kalman  *kalman
+		// idleAt is where the driver got idle
+		idleAt Location
+		// reported is the location of the last update before smoothing, retries are compared with it
+		reported Location
+		// shifts are replaced by a new slice on every change
+		shifts []Shift
 	}
+	// Filter returns true if driver should be included in query results
+	Filter func(d *Driver) bool
 )
 
+// AttributesFilter matches drivers having all given attributes with equal values
+func AttributesFilter(attributes map[string]string) Filter {
+	return func(d *Driver) bool {
+		for k, v := range attributes {
+			if d.Attributes[k] != v {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// ExcludeIDsFilter matches drivers except those with given ids
+func ExcludeIDsFilter(ids []int) Filter {
+	set := idSet(ids)
+	return func(d *Driver) bool {
+		_, ok := set[d.ID]
+		return !ok
+	}
+}
+
+// OnlyIDsFilter matches drivers with given ids only
+func OnlyIDsFilter(ids []int) Filter {
+	set := idSet(ids)
+	return func(d *Driver) bool {
+		_, ok := set[d.ID]
+		return ok
+	}
+}
+
+func idSet(ids []int) map[int]struct{} {
+	set := make(map[int]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return set
+}
+
+// matchAll combines filters into one, nil if there are no filters
+func matchAll(filters []Filter) Filter {
+	if len(filters) == 0 {
+		return nil
+	}
+	return func(d *Driver) bool {
+		for _, f := range filters {
+			if !f(d) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
 // Expired return true if the item has expired
 func (d *Driver) Expired() bool {
 	if d.Expiration == 0 {
@@ -32,61 +112,378 @@ func (d *Driver) Expired() bool {
 	return time.Now().UnixNano() > d.Expiration
 }
 
+// rectTolerance is a half size in meters of the rect around a driver in the rtree,
+// it's about 0.01 degree of latitude
+const rectTolerance = 1100
+
 // Bounds method needs for correct working of rtree
 // Lat - Y, Lon - X on coordinate system
 func (d *Driver) Bounds() *rtreego.Rect {
-	return rtreego.Point{d.LastLocation.Lat, d.LastLocation.Lon}.ToRect(0.01)
+	return toleranceRect(d.LastLocation, rectTolerance)
 }
 
-// ErrDriverDoesNotExist sign what driver does not exist
-var ErrDriverDoesNotExist = errors.New("Driver does not exist")
+var (
+	// ErrDriverDoesNotExist sign what driver does not exist
+	ErrDriverDoesNotExist = errors.New("Driver does not exist")
+	// ErrInvalidBoundingBox sign what bounding box min latitude is not below max one or longitudes are equal
+	ErrInvalidBoundingBox = errors.New("Invalid bounding box")
+	// ErrStaleLocation sign what location is older than the stored one
+	ErrStaleLocation = errors.New("Stale location")
+	// ErrInvalidLocation sign what latitude is not in [-90, 90] or longitude is not in [-180, 180]
+	ErrInvalidLocation = errors.New("Invalid location")
+	// ErrInvalidPolygon sign what polygon has no exterior ring or a ring has less than three vertices
+	ErrInvalidPolygon = errors.New("Invalid polygon")
+)
+
+// Storage is implemented by driver storage backends used by the api
+type Storage interface {
+	Set(driver *Driver) error
+	SetMany(drivers []*Driver) error
+	Get(id int) (*Driver, error)
+	List(after, limit int) []*Driver
+	History(id int, from, to int64) ([]HistoryPoint, error)
+	Delete(id int) error
+	DeleteMany(ids []int) error
+	SetStatus(id int, status Status) error
+	Nearest(point rtreego.Point, count int, filters ...Filter) []*Driver
+	NearestAndLock(point rtreego.Point, count int, ttl time.Duration, filters ...Filter) []*Driver
+	InBoundingBox(minLat, minLon, maxLat, maxLon float64) ([]*Driver, error)
+	InPolygon(polygon Polygon) ([]*Driver, error)
+	Heatmap(precision int) ([]HeatmapCell, error)
+	DeleteExpired()
+	Len() int
+	Stats() Stats
+}
+
+// Pinger is implemented by storages of remote backends which may be unreachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
 
 // DriverStorage is main storage for our project
 type DriverStorage struct {
-	mu        *sync.RWMutex
-	drivers   map[int]*Driver
-	locations *rtreego.Rtree
-	lruSize   int
+	mu          *sync.RWMutex
+	drivers     map[int]*Driver
+	locations   index
+	newIndex    func(drivers []*Driver) index
+	historySize int
+	ttl         time.Duration
+	wal         *WAL
+	counters    counters
+	// kalman filter parameters, smoothing is disabled if noise is zero
+	kalmanNoise    float64
+	kalmanAccuracy float64
+	observers      []Observer
+	// updates of a driver more often than minInterval are rejected, zero disables throttling
+	minInterval time.Duration
+	// locations implying speed above maxSpeed are rejected or flagged, zero disables detection
+	maxSpeed         float64
+	rejectImpossible bool
+	// queries are served by snapshots of the index if it's set
+	reads *readSnapshots
+	// rtree of the index and read snapshots
+	rtree rtreeParams
+	// options of ring buffers of histories
+	historyOpts ring.Options
+}
+
+var _ Storage = (*DriverStorage)(nil)
+
+// Option configures DriverStorage
+type Option func(*DriverStorage)
+
+// WithGeohashIndex indexes drivers in geohash cell buckets of given precision
+// instead of the rtree. Precision is clamped to [1, 12].
+func WithGeohashIndex(precision int) Option {
+	return func(s *DriverStorage) {
+		s.newIndex = func(drivers []*Driver) index { return newGeohashIndex(precision, drivers) }
+	}
+}
+
+// WithRtree sets minimal and maximal number of children of rtree nodes and a half size in meters
+// of the rect around a driver in the rtree, zero keeps the default of 25, 50 and 1100.
+// Maximal number of children is raised to twice the minimal one. Read snapshots use the same rtree.
+func WithRtree(minChildren, maxChildren int, tolerance float64) Option {
+	return func(s *DriverStorage) {
+		if minChildren > 0 {
+			s.rtree.minChildren = minChildren
+		}
+		if maxChildren > 0 {
+			s.rtree.maxChildren = maxChildren
+		}
+		if s.rtree.maxChildren < 2*s.rtree.minChildren {
+			s.rtree.maxChildren = 2 * s.rtree.minChildren
+		}
+		if tolerance > 0 {
+			s.rtree.tolerance = tolerance
+		}
+	}
+}
+
+// WithTTL sets expiration of drivers which are set without one
+func WithTTL(ttl time.Duration) Option {
+	return func(s *DriverStorage) {
+		s.ttl = ttl
+	}
+}
+
+// WithS2Index indexes drivers in S2 cell buckets of given level
+// instead of the rtree. Level is clamped to [0, 30].
+func WithS2Index(level int) Option {
+	return func(s *DriverStorage) {
+		s.newIndex = func(drivers []*Driver) index { return newS2Index(level, drivers) }
+	}
 }
 
 // New creates new instance of DriverStorage
-func New(lruSize int) *DriverStorage {
+func New(historySize int, opts ...Option) *DriverStorage {
 	s := new(DriverStorage)
 	s.drivers = make(map[int]*Driver)
-	s.locations = rtreego.NewTree(2, 25, 50)
+	s.rtree = defaultRtreeParams
+	s.newIndex = func(drivers []*Driver) index { return newRtreeIndex(s.rtree, drivers) }
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.locations = s.newIndex(nil)
 	s.mu = new(sync.RWMutex)
-	s.lruSize = lruSize
+	s.historySize = historySize
 	return s
 }
 
 // Set an Driver to the storage, replacing any existing item.
+// Driver without expiration expires after the storage TTL if it's set.
+// Driver without timestamp is located now, a location older than the stored one
+// is rejected with ErrStaleLocation. A location with the same timestamp and coordinates as the stored one
+// is a retry of its update, it's acknowledged without setting it again. Other locations with the same timestamp
+// are rejected with ErrStaleLocation unless they change status or attributes only.
 func (s *DriverStorage) Set(driver *Driver) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	d, ok := s.drivers[driver.ID]
-	if !ok {
-		d = driver
-		cache, err := lru.New(s.lruSize)
+	return s.setLogged(driver, time.Now().UnixNano())
+}
+
+// SetMany sets drivers under a single lock acquisition in order of their timestamps,
+// so uploads of buffered locations keep history in order. Stale, throttled and impossible locations are skipped.
+// It stops at the first other error, drivers before it remain set.
+func (s *DriverStorage) SetMany(drivers []*Driver) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	for _, driver := range ByTimestamp(drivers) {
+		err := s.setLogged(driver, now)
+		if err != nil && err != ErrStaleLocation && err != ErrThrottled && err != ErrImpossibleSpeed {
+			return err
+		}
+	}
+	return nil
+}
+
+// ByTimestamp returns a copy of drivers stable sorted by timestamp,
+// drivers without timestamp are kept at their places relative to each other at the end
+func ByTimestamp(drivers []*Driver) []*Driver {
+	sorted := make([]*Driver, len(drivers))
+	copy(sorted, drivers)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].Timestamp, sorted[j].Timestamp
+		return a != 0 && (b == 0 || a < b)
+	})
+	return sorted
+}
+
+// setLogged records driver to the WAL if it's open and sets it, the driver of the caller is not modified
+func (s *DriverStorage) setLogged(driver *Driver, now int64) error {
+	version := *driver
+	version.Locations, version.history, version.kalman = nil, nil, nil
+	version.Anomalies, version.AnomalyAt = 0, 0
+	driver = &version
+	if !driver.LastLocation.Valid() {
+		return ErrInvalidLocation
+	}
+	if driver.Status != "" && !driver.Status.Valid() {
+		return ErrInvalidStatus
+	}
+	// locations of batches without timestamps are located at the same time, they are not retries
+	located := driver.Timestamp != 0
+	if !located {
+		driver.Timestamp = now
+	}
+	if d, ok := s.drivers[driver.ID]; ok {
+		if driver.Timestamp < d.Timestamp {
+			return ErrStaleLocation
+		}
+		// retries from flaky networks would smooth, count and notify the location twice,
+		// changes of attributes replicated with the location of the driver are not retries
+		if located && driver.Timestamp == d.Timestamp {
+			if driver.LastLocation != d.reported {
+				return ErrStaleLocation
+			}
+			if !d.changedBy(driver) {
+				s.counters.duplicates++
+				return nil
+			}
+		}
+	}
+	if s.throttled(driver) {
+		s.counters.throttled++
+		return ErrThrottled
+	}
+	if s.impossible(driver) {
+		s.counters.anomalies++
+		if s.rejectImpossible {
+			return ErrImpossibleSpeed
+		}
+		driver.Anomalies, driver.AnomalyAt = 1, driver.Timestamp
+	}
+	if driver.Expiration == 0 && s.ttl > 0 {
+		driver.Expiration = now + int64(s.ttl)
+	}
+	driver.reported = driver.LastLocation
+	// the WAL gets the smoothed location, so replay doesn't need the filter state
+	if s.kalmanNoise > 0 {
+		driver.LastLocation = s.smooth(driver)
+	}
+	if s.wal != nil {
+		err := s.wal.append(walRecord{
+			Op:         walSet,
+			ID:         driver.ID,
+			Location:   driver.LastLocation,
+			Attributes: driver.Attributes,
+			Status:     driver.Status,
+			Expiration: driver.Expiration,
+			Timestamp:  driver.Timestamp,
+			Anomaly:    driver.Anomalies > 0,
+		})
 		if err != nil {
-			return errors.Wrap(err, "could not create LRU")
+			return err
 		}
-		d.Locations = cache
-		s.locations.Insert(d)
 	}
-	d.LastLocation = driver.LastLocation
-	d.Locations.Add(time.Now().UnixNano(), d.LastLocation)
-	d.Expiration = driver.Expiration
+	var status Status
+	prev, exists := s.drivers[driver.ID]
+	if exists {
+		status = prev.Status
+	}
+	if err := s.set(driver); err != nil {
+		return err
+	}
+	d := s.drivers[driver.ID]
+	if exists {
+		s.counters.updated++
+	} else {
+		s.counters.inserted++
+		s.notifyAppeared(d)
+	}
+	s.notifyMoved(d)
+	if exists && d.Status != status {
+		s.notifyStatus(d.ID, d.Status)
+	}
+	return nil
+}
 
-	s.drivers[driver.ID] = driver
+// set stores driver as the new version, the caller must not keep it.
+// History, motion, reservation, idle time, shifts and anomalies are carried over from the previous version,
+// the version number is incremented if status or attributes change.
+func (s *DriverStorage) set(driver *Driver) error {
+	d, ok := s.drivers[driver.ID]
+	if ok {
+		driver.history = d.history
+		if driver.kalman == nil {
+			driver.kalman = d.kalman
+		}
+		driver.Speed, driver.Heading = d.Speed, d.Heading
+		driver.ReservedUntil = d.ReservedUntil
+		driver.OffShift, driver.shifts = d.OffShift, d.shifts
+		driver.Anomalies += d.Anomalies
+		if driver.AnomalyAt == 0 {
+			driver.AnomalyAt = d.AnomalyAt
+		}
+		if driver.Attributes == nil {
+			driver.Attributes = d.Attributes
+		}
+		if driver.Status == "" {
+			driver.Status = d.Status
+		}
+		driver.Version = d.nextVersion(driver)
+	} else {
+		cache, err := s.newHistory()
+		if err != nil {
+			return errors.Wrap(err, "could not create location history")
+		}
+		driver.history = cache
+		// drivers start a shift with their first location
+		driver.OffShift, driver.shifts = false, []Shift{{Start: driver.Timestamp}}
+		if driver.Status == "" {
+			driver.Status = StatusAvailable
+		}
+		// drivers moved from another region keep their version
+		if driver.Version == 0 {
+			driver.Version = 1
+		}
+	}
+	// drivers replayed from the WAL have smoothed locations only
+	if driver.reported == (Location{}) {
+		driver.reported = driver.LastLocation
+	}
+	evicted := driver.history.Evicted()
+	driver.history.Add(driver.Timestamp, driver.LastLocation)
+	s.counters.historyEvicted += driver.history.Evicted() - evicted
+	driver.updateMotion()
+	driver.updateIdle(d)
+	s.publish(driver)
 	return nil
 }
 
+// publish stores the new version of the driver, versions are never modified once they are stored,
+// so drivers returned by queries are read without the lock
+func (s *DriverStorage) publish(d *Driver) {
+	s.drivers[d.ID] = d
+	s.locations.Insert(d)
+}
+
 // Delete deletes a driver from storage. Does nothing if the driver is not in the storage.
 func (s *DriverStorage) Delete(id int) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if _, ok := s.drivers[id]; !ok {
+		return ErrDriverDoesNotExist
+	}
+	return s.deleteLogged(id)
+}
+
+// DeleteMany deletes drivers under a single lock acquisition, skipping missing ones.
+func (s *DriverStorage) DeleteMany(ids []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if _, ok := s.drivers[id]; !ok {
+			continue
+		}
+		if err := s.deleteLogged(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteLogged records deletion to the WAL if it's open and deletes the driver
+func (s *DriverStorage) deleteLogged(id int) error {
+	if s.wal != nil {
+		if err := s.wal.append(walRecord{Op: walDelete, ID: id}); err != nil {
+			return err
+		}
+	}
+	if err := s.delete(id); err != nil {
+		return err
+	}
+	s.counters.deleted++
+	s.notifyRemoved(id)
+	return nil
+}
+
+func (s *DriverStorage) delete(id int) error {
 	driver, ok := s.drivers[id]
 	if !ok {
 		return ErrDriverDoesNotExist
@@ -99,34 +496,94 @@ func (s *DriverStorage) Delete(id int) error {
 	return errors.New("could not remove item")
 }
 
-// Get gets driver from storage and an error if nothing found
+// Get gets driver from storage and an error if nothing found, Locations of the driver is a copy of its history
 func (s *DriverStorage) Get(id int) (*Driver, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	driver, ok := s.drivers[id]
+	d, ok := s.drivers[id]
 	if !ok {
 		return nil, ErrDriverDoesNotExist
 	}
-	return driver, nil
+	driver := *d
+	driver.Locations = d.history.Copy()
+	return &driver, nil
 }
 
-// Nearest returns nearest drivers by location
-func (s *DriverStorage) Nearest(point rtreego.Point, count int) []*Driver {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// Nearest returns nearest drivers matching all filters by location
+// ordered by great-circle distance. Point is Lat, Lon.
+func (s *DriverStorage) Nearest(point rtreego.Point, count int, filters ...Filter) []*Driver {
+	origin := Location{Lat: point[0], Lon: point[1]}
+	if s.reads != nil {
+		return nearest(s.readSnapshot(), origin, count, filters)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return nearest(s.locations, origin, count, filters)
+}
+
+// nearest returns count nearest candidates of the index ordered by great-circle distance
+func nearest(locations index, origin Location, count int, filters []Filter) []*Driver {
+	drivers := locations.Nearest(origin, count, matchAll(filters))
+	SortByDistance(origin, drivers)
+	if len(drivers) > count {
+		drivers = drivers[:count]
+	}
+	return drivers
+}
 
-	results := s.locations.NearestNeighbors(count, point)
+// InBoundingBox returns all drivers located inside the bounding box,
+// it crosses the antimeridian if min longitude is greater than max one
+func (s *DriverStorage) InBoundingBox(minLat, minLon, maxLat, maxLon float64) ([]*Driver, error) {
+	if !ValidBoundingBox(minLat, minLon, maxLat, maxLon) {
+		return nil, ErrInvalidBoundingBox
+	}
+	if s.reads != nil {
+		return search(s.readSnapshot(), minLat, minLon, maxLat, maxLon), nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return search(s.locations, minLat, minLon, maxLat, maxLon), nil
+}
+
+// search returns drivers of the index inside the bounding box, it's split at the antimeridian
+func search(locations index, minLat, minLon, maxLat, maxLon float64) []*Driver {
 	var drivers []*Driver
-	for _, item := range results {
-		if item == nil {
-			continue
+	for _, b := range SplitBoundingBox(minLat, minLon, maxLat, maxLon) {
+		for _, d := range locations.Search(b[0], b[1], b[2], b[3]) {
+			// index returns candidates, so drivers slightly outside are filtered here
+			l := d.LastLocation
+			if l.Lat < b[0] || l.Lat > b[2] || l.Lon < b[1] || l.Lon > b[3] {
+				continue
+			}
+			drivers = append(drivers, d)
 		}
-		drivers = append(drivers, item.(*Driver))
 	}
 	return drivers
 }
 
+// InPolygon returns all drivers located inside the polygon.
+// Drivers are prefiltered by the polygon's bounding box.
+func (s *DriverStorage) InPolygon(polygon Polygon) ([]*Driver, error) {
+	if !polygon.Valid() {
+		return nil, ErrInvalidPolygon
+	}
+	candidates, err := s.InBoundingBox(polygon.BoundingBox())
+	if err != nil {
+		return nil, err
+	}
+
+	var drivers []*Driver
+	for _, d := range candidates {
+		if polygon.Contains(d.LastLocation) {
+			drivers = append(drivers, d)
+		}
+	}
+	return drivers, nil
+}
+
 // DeleteExpired removes all expired items from storage
 func (s *DriverStorage) DeleteExpired() {
 	s.mu.Lock()
@@ -137,6 +594,8 @@ func (s *DriverStorage) DeleteExpired() {
 			deleted := s.locations.Delete(d)
 			if deleted {
 				delete(s.drivers, d.ID)
+				s.counters.expired++
+				s.notifyExpired(d.ID)
 			}
 		}
 	}
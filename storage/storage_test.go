@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"math"
 	"testing"
 	"time"
 
@@ -75,9 +76,79 @@ func TestNearest(t *testing.T) {
 
 	drivers := s.Nearest(rtreego.Point{42.876420, 74.588332}, 3)
 	assert.Equal(t, len(drivers), 3)
-	assert.Equal(t, drivers[0].ID, 123)
-	assert.Equal(t, drivers[1].ID, 321)
-	assert.Equal(t, drivers[2].ID, 666)
+	assert.Equal(t, drivers[0].ID, 666)
+	assert.Equal(t, drivers[1].ID, 123)
+	assert.Equal(t, drivers[2].ID, 321)
+}
+
+func TestInBoundingBox(t *testing.T) {
+	s := New(10)
+	s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 42.875799, Lon: 74.588279}})
+	s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 42.874942, Lon: 74.585908}})
+	s.Set(&Driver{ID: 3, LastLocation: Location{Lat: 42.876106, Lon: 74.598204}})
+
+	drivers, err := s.InBoundingBox(42.87, 74.58, 42.88, 74.59)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(drivers))
+	for _, d := range drivers {
+		assert.NotEqual(t, 3, d.ID)
+	}
+
+	_, err = s.InBoundingBox(42.88, 74.58, 42.87, 74.59)
+	assert.Equal(t, ErrInvalidBoundingBox, err)
+}
+
+func TestInPolygon(t *testing.T) {
+	s := New(10)
+	s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 9, Lon: 1}})
+	s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 1, Lon: 9}})
+	s.Set(&Driver{ID: 3, LastLocation: Location{Lat: 20, Lon: 20}})
+
+	// triangle excludes top left corner of its bounding box
+	triangle := Polygon{{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 10}, {Lat: 10, Lon: 10}}}
+	drivers, err := s.InPolygon(triangle)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(drivers))
+	assert.Equal(t, 2, drivers[0].ID)
+
+	_, err = s.InPolygon(Polygon{})
+	assert.Equal(t, ErrInvalidPolygon, err)
+
+	// polygons crossing the antimeridian don't span the globe
+	s.Set(&Driver{ID: 4, LastLocation: Location{Lat: -16.8, Lon: 179.9}})
+	s.Set(&Driver{ID: 5, LastLocation: Location{Lat: -16.8, Lon: -179.9}})
+	s.Set(&Driver{ID: 6, LastLocation: Location{Lat: -16.8, Lon: 0}})
+	fiji := Polygon{{{Lat: -20, Lon: 176}, {Lat: -20, Lon: -178}, {Lat: -15, Lon: -178}, {Lat: -15, Lon: 176}}}
+	drivers, err = s.InPolygon(fiji)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []int{4, 5}, ids(drivers))
+}
+
+func TestNearestFiltered(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"rtree":   func(*DriverStorage) {},
+		"geohash": WithGeohashIndex(6),
+		"s2":      WithS2Index(13),
+	} {
+		s := New(10, opt)
+		for i := 0; i < 20; i++ {
+			class := "sedan"
+			if i%5 == 0 {
+				class = "minivan"
+			}
+			s.Set(&Driver{
+				ID:           i,
+				LastLocation: Location{Lat: 42.87 + float64(i)*0.001, Lon: 74.58},
+				Attributes:   map[string]string{"vehicle_class": class},
+			})
+		}
+
+		drivers := s.Nearest(rtreego.Point{42.87, 74.58}, 3, AttributesFilter(map[string]string{"vehicle_class": "minivan"}))
+		assert.Equal(t, 3, len(drivers), name)
+		for i, d := range drivers {
+			assert.Equal(t, i*5, d.ID, name)
+		}
+	}
 }
 
 func BenchmarkNearest(b *testing.B) {
@@ -97,6 +168,449 @@ func BenchmarkNearest(b *testing.B) {
 	}
 }
 
+func BenchmarkNearestParallel(b *testing.B) {
+	s := New(100)
+	for i := 0; i < 100; i++ {
+		s.Set(&Driver{
+			ID: i,
+			LastLocation: Location{
+				Lat: float64(i),
+				Lon: float64(i),
+			},
+		})
+	}
+	point := rtreego.Point{123, 123}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.Nearest(point, 10)
+		}
+	})
+}
+
+func TestMove(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"rtree":   func(*DriverStorage) {},
+		"geohash": WithGeohashIndex(6),
+		"s2":      WithS2Index(13),
+	} {
+		s := New(10, opt)
+		assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 42.87, Lon: 74.58}}), name)
+		assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 42.9, Lon: 74.6}}), name)
+		// jitter and a long move
+		assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 42.87001, Lon: 74.58}}), name)
+		assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 10, Lon: 10}}), name)
+
+		drivers := s.Nearest(rtreego.Point{10, 10}, 1)
+		if assert.Len(t, drivers, 1, name) {
+			assert.Equal(t, 1, drivers[0].ID, name)
+		}
+		drivers, err := s.InBoundingBox(9, 9, 11, 11)
+		assert.NoError(t, err, name)
+		assert.Len(t, drivers, 1, name)
+		drivers, err = s.InBoundingBox(42, 74, 43, 75)
+		assert.NoError(t, err, name)
+		assert.Len(t, drivers, 1, name)
+
+		assert.NoError(t, s.Delete(1), name)
+		assert.Equal(t, 1, s.Stats().Index.Size, name)
+	}
+}
+
+func TestSetManyDeleteMany(t *testing.T) {
+	s := New(10)
+	err := s.SetMany([]*Driver{
+		{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}},
+		{ID: 2, LastLocation: Location{Lat: 2, Lon: 2}},
+		{ID: 3, LastLocation: Location{Lat: 3, Lon: 3}},
+	})
+	assert.NoError(t, err)
+	for id := 1; id <= 3; id++ {
+		_, err := s.Get(id)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, s.DeleteMany([]int{1, 3, 42}))
+	_, err = s.Get(1)
+	assert.Equal(t, ErrDriverDoesNotExist, err)
+	_, err = s.Get(2)
+	assert.NoError(t, err)
+	_, err = s.Get(3)
+	assert.Equal(t, ErrDriverDoesNotExist, err)
+}
+
+func TestStatus(t *testing.T) {
+	s := New(10)
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}}))
+	assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 2, Lon: 2}}))
+
+	d, err := s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusAvailable, d.Status)
+
+	assert.NoError(t, s.SetStatus(1, StatusBusy))
+	assert.Equal(t, ErrInvalidStatus, s.SetStatus(1, "sleeping"))
+	assert.Equal(t, ErrDriverDoesNotExist, s.SetStatus(42, StatusBusy))
+
+	// location updates keep the status
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1.5, Lon: 1.5}}))
+	d, err = s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, StatusBusy, d.Status)
+
+	nearest := s.Nearest(rtreego.Point{1, 1}, 2, StatusFilter(StatusAvailable))
+	if assert.Len(t, nearest, 1) {
+		assert.Equal(t, 2, nearest[0].ID)
+	}
+	assert.Len(t, s.Nearest(rtreego.Point{1, 1}, 2), 2)
+}
+
+func TestCopyOnWrite(t *testing.T) {
+	s := New(10)
+	driver := &Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Timestamp: 1}
+	assert.NoError(t, s.Set(driver))
+	assert.Nil(t, driver.Locations)
+	assert.Equal(t, Status(""), driver.Status)
+
+	// changes of the caller's driver don't reach the storage
+	driver.LastLocation = Location{Lat: 2, Lon: 2}
+	d, err := s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, Location{Lat: 1, Lon: 1}, d.LastLocation)
+
+	// returned drivers are not changed by later updates
+	nearest := s.Nearest(rtreego.Point{1, 1}, 1)
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1.001, Lon: 1}, Timestamp: 2}))
+	assert.NoError(t, s.SetStatus(1, StatusBusy))
+	if assert.Len(t, nearest, 1) {
+		assert.Equal(t, Location{Lat: 1, Lon: 1}, nearest[0].LastLocation)
+		assert.Equal(t, StatusAvailable, nearest[0].Status)
+	}
+	assert.Equal(t, 1, d.Locations.Len())
+
+	// the history is kept between updates
+	d, err = s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, d.Locations.Len())
+	assert.Equal(t, StatusBusy, d.Status)
+}
+
+func TestStats(t *testing.T) {
+	s := New(10)
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Expiration: 1}))
+	assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 2, Lon: 2}}))
+	assert.NoError(t, s.Set(&Driver{ID: 3, LastLocation: Location{Lat: 3, Lon: 3}}))
+	assert.NoError(t, s.Set(&Driver{ID: 3, LastLocation: Location{Lat: 3.5, Lon: 3.5}}))
+	assert.NoError(t, s.Delete(2))
+	s.DeleteExpired()
+
+	assert.Equal(t, 1, s.Len())
+	st := s.Stats()
+	assert.Equal(t, 1, st.Drivers)
+	assert.Equal(t, uint64(3), st.Inserted)
+	assert.Equal(t, uint64(1), st.Updated)
+	assert.Equal(t, uint64(1), st.Deleted)
+	assert.Equal(t, uint64(1), st.Expired)
+	assert.Equal(t, "rtree", st.Index.Type)
+	assert.Equal(t, 1, st.Index.Size)
+	assert.Equal(t, 1, st.Index.Depth)
+}
+
+func TestList(t *testing.T) {
+	s := New(10)
+	for id := 10; id > 0; id-- {
+		assert.NoError(t, s.Set(&Driver{ID: id, LastLocation: Location{Lat: 1, Lon: float64(id)}}))
+	}
+
+	var ids []int
+	after := 0
+	for {
+		page := s.List(after, 3)
+		if len(page) == 0 {
+			break
+		}
+		assert.True(t, len(page) <= 3)
+		for _, d := range page {
+			ids = append(ids, d.ID)
+		}
+		after = page[len(page)-1].ID
+	}
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, ids)
+
+	n := 0
+	s.ForEach(func(d *Driver) bool {
+		n++
+		return n < 4
+	})
+	assert.Equal(t, 4, n)
+}
+
+func TestHistory(t *testing.T) {
+	s := New(10)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: float64(i), Lon: 1}}))
+	}
+
+	points, err := s.History(1, 0, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, points, 3) {
+		for i, p := range points {
+			assert.Equal(t, float64(i), p.Location.Lat)
+		}
+		assert.True(t, points[0].Timestamp < points[2].Timestamp)
+
+		points, err = s.History(1, points[1].Timestamp, points[1].Timestamp)
+		assert.NoError(t, err)
+		assert.Len(t, points, 1)
+	}
+
+	_, err = s.History(42, 0, 0)
+	assert.Equal(t, ErrDriverDoesNotExist, err)
+}
+
+func TestHistoryMaxAge(t *testing.T) {
+	s := New(10, WithHistoryMaxAge(time.Minute))
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: float64(i), Lon: 1}, Timestamp: int64(i+1) * int64(30*time.Second)}))
+	}
+
+	// the first location is more than a minute older than the newest one
+	points, err := s.History(1, 0, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, points, 3) {
+		assert.Equal(t, float64(1), points[0].Location.Lat)
+	}
+	d, err := s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, d.Locations.Len())
+}
+
+func TestHistoryDownsampling(t *testing.T) {
+	s := New(4, WithHistoryDownsampling(time.Minute, 2))
+	for i := 1; i <= 6; i++ {
+		assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: float64(i), Lon: 1}, Timestamp: int64(i) * int64(30*time.Second)}))
+	}
+
+	// locations older than a minute are thinned to every 2nd, the ring keeps room for new ones
+	points, err := s.History(1, 0, 0)
+	assert.NoError(t, err)
+	var lats []float64
+	for _, p := range points {
+		lats = append(lats, p.Location.Lat)
+	}
+	assert.Equal(t, []float64{4, 5, 6}, lats)
+}
+
+func TestHistoryStats(t *testing.T) {
+	s := New(2)
+	for i := 1; i <= 3; i++ {
+		assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: float64(i), Lon: 1}, Timestamp: int64(i) * int64(time.Second)}))
+	}
+	assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 1, Lon: 1}}))
+
+	st, err := s.HistoryStats(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, st.Locations)
+	assert.Equal(t, 2, st.Capacity)
+	assert.Equal(t, uint64(1), st.Evictions)
+	// the oldest location is at the second second of the epoch
+	assert.InDelta(t, float64(time.Now().Unix()-2), st.OldestAge, 5)
+	_, err = s.HistoryStats(3)
+	assert.Equal(t, ErrDriverDoesNotExist, err)
+
+	// evictions of removed drivers are counted
+	assert.NoError(t, s.Delete(1))
+	history := s.Stats().History
+	assert.Equal(t, 1, history.Locations)
+	assert.Equal(t, 2, history.Capacity)
+	assert.Equal(t, uint64(1), history.Evictions)
+	assert.True(t, history.OldestAge < 5)
+}
+
+func TestClientTimestamps(t *testing.T) {
+	s := New(10)
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 2, Lon: 1}, Timestamp: 200}))
+	assert.Equal(t, ErrStaleLocation, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Timestamp: 100}))
+
+	// buffered locations are applied in order, the stale one is skipped
+	err := s.SetMany([]*Driver{
+		{ID: 1, LastLocation: Location{Lat: 4, Lon: 1}, Timestamp: 400},
+		{ID: 1, LastLocation: Location{Lat: 0, Lon: 1}, Timestamp: 50},
+		{ID: 1, LastLocation: Location{Lat: 3, Lon: 1}, Timestamp: 300},
+	})
+	assert.NoError(t, err)
+
+	d, err := s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 4.0, d.LastLocation.Lat)
+	assert.Equal(t, int64(400), d.Timestamp)
+
+	points, err := s.History(1, 0, 0)
+	assert.NoError(t, err)
+	var ts []int64
+	for _, p := range points {
+		ts = append(ts, p.Timestamp)
+	}
+	assert.Equal(t, []int64{200, 300, 400}, ts)
+}
+
+func TestDuplicates(t *testing.T) {
+	s := New(10)
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Timestamp: 100}))
+	// the retry is acknowledged, the location is not set again
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Timestamp: 100}))
+	// another location of the same time is not a retry
+	assert.Equal(t, ErrStaleLocation, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 2, Lon: 1}, Timestamp: 100}))
+	d, err := s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, d.LastLocation.Lat)
+	// changes of attributes of the same location are not retries
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Attributes: map[string]string{"class": "comfort"}, Timestamp: 100}))
+	d, err = s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"class": "comfort"}, d.Attributes)
+	assert.Equal(t, uint64(2), d.Version)
+
+	// locations without timestamps of a batch are not retries
+	assert.NoError(t, s.SetMany([]*Driver{
+		{ID: 1, LastLocation: Location{Lat: 3, Lon: 1}},
+		{ID: 1, LastLocation: Location{Lat: 4, Lon: 1}},
+	}))
+	d, err = s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 4.0, d.LastLocation.Lat)
+
+	stats := s.Stats()
+	assert.Equal(t, uint64(1), stats.Duplicates)
+	assert.Equal(t, uint64(3), stats.Updated)
+
+	// retries are compared with reported locations, not smoothed ones
+	s = New(10, WithKalmanFilter(1, 10))
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Timestamp: 1e9}))
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1.001, Lon: 1}, Timestamp: 2e9}))
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1.001, Lon: 1}, Timestamp: 2e9}))
+	assert.Equal(t, uint64(1), s.Stats().Duplicates)
+}
+
+func TestThrottle(t *testing.T) {
+	s := New(10, WithMinUpdateInterval(100))
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Timestamp: 100}))
+	assert.Equal(t, ErrThrottled, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 2, Lon: 1}, Timestamp: 150}))
+	assert.NoError(t, s.Set(&Driver{ID: 2, LastLocation: Location{Lat: 2, Lon: 1}, Timestamp: 150}))
+
+	// buffered locations are downsampled to the interval
+	err := s.SetMany([]*Driver{
+		{ID: 1, LastLocation: Location{Lat: 3, Lon: 1}, Timestamp: 200},
+		{ID: 1, LastLocation: Location{Lat: 4, Lon: 1}, Timestamp: 250},
+		{ID: 1, LastLocation: Location{Lat: 5, Lon: 1}, Timestamp: 300},
+	})
+	assert.NoError(t, err)
+	d, err := s.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 5.0, d.LastLocation.Lat)
+	assert.Equal(t, uint64(2), s.Stats().Throttled)
+}
+
+func TestInvalidLocation(t *testing.T) {
+	s := New(10)
+	for _, l := range []Location{
+		{Lat: 91, Lon: 1},
+		{Lat: 1, Lon: -180.5},
+		{Lat: math.NaN(), Lon: 1},
+		{Lat: 1, Lon: math.Inf(1)},
+	} {
+		assert.Equal(t, ErrInvalidLocation, s.Set(&Driver{ID: 1, LastLocation: l}))
+	}
+	assert.Equal(t, ErrInvalidLocation, s.SetMany([]*Driver{{ID: 1, LastLocation: Location{Lat: -90.1}}}))
+	assert.Equal(t, 0, s.Len())
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: -90, Lon: 180}}))
+}
+
+func TestHeatmap(t *testing.T) {
+	s := New(10)
+	for i, l := range []Location{
+		{Lat: 42.8746, Lon: 74.6122},
+		{Lat: 42.8747, Lon: 74.6123},
+		{Lat: 42.8745, Lon: 74.6121},
+		{Lat: 43.2567, Lon: 76.9286},
+	} {
+		assert.NoError(t, s.Set(&Driver{ID: i, LastLocation: l}))
+	}
+
+	cells, err := s.Heatmap(5)
+	assert.NoError(t, err)
+	if assert.Len(t, cells, 2) {
+		assert.Equal(t, HeatmapCell{Geohash: Geohash(Location{Lat: 42.8746, Lon: 74.6122}, 5), Count: 3}, cells[0])
+		assert.Equal(t, 1, cells[1].Count)
+	}
+}
+
+func TestNearestAndLock(t *testing.T) {
+	for name, s := range map[string]Storage{
+		"single":  New(10),
+		"sharded": NewSharded(3, 10),
+	} {
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, s.Set(&Driver{ID: i, LastLocation: Location{Lat: 1 + float64(i)*0.01, Lon: 1}}), name)
+		}
+		assert.NoError(t, s.SetStatus(0, StatusBusy), name)
+
+		first := s.NearestAndLock(rtreego.Point{1, 1}, 2, time.Minute)
+		if assert.Len(t, first, 2, name) {
+			assert.Equal(t, 1, first[0].ID, name)
+			assert.Equal(t, 2, first[1].ID, name)
+			assert.True(t, first[0].Reserved(), name)
+		}
+		second := s.NearestAndLock(rtreego.Point{1, 1}, 1, time.Minute)
+		if assert.Len(t, second, 1, name) {
+			assert.Equal(t, 3, second[0].ID, name)
+		}
+
+		// releasing by status change makes the driver available again
+		assert.NoError(t, s.SetStatus(1, StatusAvailable), name)
+		drivers := s.Nearest(rtreego.Point{1, 1}, 1, Available())
+		if assert.Len(t, drivers, 1, name) {
+			assert.Equal(t, 1, drivers[0].ID, name)
+		}
+
+		// expired reservations are ignored
+		expired := s.NearestAndLock(rtreego.Point{1, 1}, 5, -time.Second)
+		assert.Len(t, expired, 2, name)
+		assert.Len(t, s.NearestAndLock(rtreego.Point{1, 1}, 5, time.Minute), 2, name)
+
+		r := s.(interface {
+			Reserve(id int, until int64) bool
+		})
+		until := time.Now().Add(time.Minute).UnixNano()
+		assert.False(t, r.Reserve(1, until), name)
+		assert.False(t, r.Reserve(0, until), name)
+		assert.False(t, r.Reserve(99, until), name)
+		assert.NoError(t, s.SetStatus(0, StatusAvailable), name)
+		assert.True(t, r.Reserve(0, until), name)
+		d, _ := s.Get(0)
+		assert.Equal(t, until, d.ReservedUntil, name)
+
+		// filters of the caller are not overwritten by Available
+		filters := make([]Filter, 1, 2)
+		filters[0] = func(*Driver) bool { return true }
+		s.NearestAndLock(rtreego.Point{1, 1}, 1, time.Minute, filters...)
+		assert.Nil(t, filters[:2][1], name)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	s := New(10, WithTTL(time.Minute))
+	s.Set(&Driver{ID: 123})
+	d, err := s.Get(123)
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Now().Add(time.Minute).UnixNano(), d.Expiration, float64(time.Second))
+
+	expiration := time.Now().Add(time.Hour).UnixNano()
+	s.Set(&Driver{ID: 321, Expiration: expiration})
+	d, err = s.Get(321)
+	assert.NoError(t, err)
+	assert.Equal(t, expiration, d.Expiration)
+}
+
 func TestExpire(t *testing.T) {
 	s := New(10)
 	driver := &Driver{
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrThrottled sign what driver location is set too soon after the previous one
+var ErrThrottled = errors.New("Driver updates too often")
+
+// WithMinUpdateInterval rejects locations of a driver closer in time than interval
+// to the stored one, so a misbehaving device can't flood the index
+func WithMinUpdateInterval(interval time.Duration) Option {
+	return func(s *DriverStorage) {
+		s.minInterval = interval
+	}
+}
+
+// throttled returns true if the driver is updated sooner than the interval, it's called under the lock
+func (s *DriverStorage) throttled(driver *Driver) bool {
+	if s.minInterval <= 0 {
+		return false
+	}
+	d, ok := s.drivers[driver.ID]
+	return ok && driver.Timestamp-d.Timestamp < int64(s.minInterval)
+}
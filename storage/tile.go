@@ -0,0 +1,46 @@
+package storage
+
+import "math"
+
+// TileExtent is the size of a vector tile side in tile coordinates
+const TileExtent = 4096
+
+// maxMercatorLat is the latitude of edges of web mercator maps
+const maxMercatorLat = 85.05112878
+
+// ValidTile returns true if x and y are tile indexes of the zoom level
+func ValidTile(z, x, y int) bool {
+	if z < 0 || z > MaxZoom {
+		return false
+	}
+	n := 1 << uint(z)
+	return x >= 0 && x < n && y >= 0 && y < n
+}
+
+// TileBounds returns the bounding box of the web mercator tile expanded by buffer tile coordinates on each side,
+// so points near edges of neighbouring tiles are drawn on both of them
+func TileBounds(z, x, y, buffer int) (minLat, minLon, maxLat, maxLon float64) {
+	n := float64(int(1) << uint(z))
+	pad := float64(buffer) / TileExtent
+	minLon = math.Max(-180, (float64(x)-pad)/n*360-180)
+	maxLon = math.Min(180, (float64(x+1)+pad)/n*360-180)
+	maxLat = tileLat(float64(y)-pad, n)
+	minLat = tileLat(float64(y+1)+pad, n)
+	return minLat, minLon, maxLat, maxLon
+}
+
+// tileLat returns the latitude of the y coordinate of a web mercator map split into n by n tiles
+func tileLat(y, n float64) float64 {
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*y/n))) * 180 / math.Pi
+	return math.Max(-90, math.Min(90, lat))
+}
+
+// TilePoint returns coordinates of the location in the tile, they are out of [0, TileExtent)
+// for locations outside of the tile
+func TilePoint(l Location, z, x, y int) (px, py int) {
+	n := float64(int(1) << uint(z))
+	lat := math.Max(-maxMercatorLat, math.Min(maxMercatorLat, l.Lat)) * math.Pi / 180
+	fx := (l.Lon + 180) / 360 * n
+	fy := (1 - math.Log(math.Tan(lat)+1/math.Cos(lat))/math.Pi) / 2 * n
+	return int(math.Floor((fx - float64(x)) * TileExtent)), int(math.Floor((fy - float64(y)) * TileExtent))
+}
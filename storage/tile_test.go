@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTiles(t *testing.T) {
+	assert.True(t, ValidTile(0, 0, 0))
+	assert.True(t, ValidTile(2, 3, 3))
+	assert.False(t, ValidTile(2, 4, 0))
+	assert.False(t, ValidTile(-1, 0, 0))
+	assert.False(t, ValidTile(MaxZoom+1, 0, 0))
+
+	minLat, minLon, maxLat, maxLon := TileBounds(1, 1, 0, 0)
+	assert.InDelta(t, 0, minLat, 1e-9)
+	assert.InDelta(t, 0, minLon, 1e-9)
+	assert.InDelta(t, maxMercatorLat, maxLat, 1e-6)
+	assert.InDelta(t, 180, maxLon, 1e-9)
+	// the buffer is clamped at the antimeridian
+	minLat, minLon, _, _ = TileBounds(0, 0, 0, 64)
+	assert.InDelta(t, -85.5, minLat, 0.1)
+	assert.Equal(t, float64(-180), minLon)
+
+	x, y := TilePoint(Location{Lat: 0, Lon: 0}, 0, 0, 0)
+	assert.Equal(t, TileExtent/2, x)
+	assert.Equal(t, TileExtent/2, y)
+	x, y = TilePoint(Location{Lat: 0, Lon: 0}, 1, 1, 1)
+	assert.Equal(t, 0, x)
+	assert.Equal(t, 0, y)
+	x, _ = TilePoint(Location{Lat: 0, Lon: -1}, 1, 1, 1)
+	assert.True(t, x < 0)
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrVersionMismatch sign what driver was changed since the version a conditional update expects
+var ErrVersionMismatch = errors.New("Driver was changed by another update")
+
+// SetStatusIf changes status of the driver if it's of the version, 0 matches any version.
+// Returns the version after the change, so dispatchers may chain updates without reading the driver.
+func (s *DriverStorage) SetStatusIf(id int, status Status, version uint64) (uint64, error) {
+	if !status.Valid() {
+		return 0, ErrInvalidStatus
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.drivers[id]
+	if !ok {
+		return 0, ErrDriverDoesNotExist
+	}
+	if version != 0 && d.Version != version {
+		return d.Version, ErrVersionMismatch
+	}
+	if s.wal != nil {
+		if err := s.wal.append(walRecord{Op: walStatus, ID: id, Status: status}); err != nil {
+			return 0, err
+		}
+	}
+	changed := d.Status != status
+	next := *d
+	next.Status = status
+	next.Version = d.nextVersion(&next)
+	// dispatcher has decided what to do with the reserved driver
+	next.ReservedUntil = 0
+	next.statusIdle(time.Now().UnixNano())
+	s.publish(&next)
+	if changed {
+		s.notifyStatus(id, status)
+	}
+	return next.Version, nil
+}
+
+// SetAttributesIf replaces attributes of the driver if it's of the version, 0 matches any version.
+// Returns the version after the change.
+func (s *DriverStorage) SetAttributesIf(id int, attributes map[string]string, version uint64) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.drivers[id]
+	if !ok {
+		return 0, ErrDriverDoesNotExist
+	}
+	if version != 0 && d.Version != version {
+		return d.Version, ErrVersionMismatch
+	}
+	if sameAttributes(d.Attributes, attributes) {
+		return d.Version, nil
+	}
+	if s.wal != nil {
+		if err := s.wal.append(walRecord{Op: walAttributes, ID: id, Attributes: attributes}); err != nil {
+			return 0, err
+		}
+	}
+	next := *d
+	next.Attributes = attributes
+	next.Version = d.nextVersion(&next)
+	s.publish(&next)
+	s.notifyChanged(&next)
+	return next.Version, nil
+}
+
+// SetStatusIf changes status of the driver of its shard if it's of the version
+func (s *ShardedStorage) SetStatusIf(id int, status Status, version uint64) (uint64, error) {
+	return s.shard(id).SetStatusIf(id, status, version)
+}
+
+// SetAttributesIf replaces attributes of the driver of its shard if it's of the version
+func (s *ShardedStorage) SetAttributesIf(id int, attributes map[string]string, version uint64) (uint64, error) {
+	return s.shard(id).SetAttributesIf(id, attributes, version)
+}
+
+// SetStatusIf changes status of the driver of its region if it's of the version
+func (s *RegionStorage) SetStatusIf(id int, status Status, version uint64) (uint64, error) {
+	if !status.Valid() {
+		return 0, ErrInvalidStatus
+	}
+	defer s.lock(id)()
+	i := s.owner(id)
+	if i < 0 {
+		return 0, ErrDriverDoesNotExist
+	}
+	return s.storages[i].SetStatusIf(id, status, version)
+}
+
+// SetAttributesIf replaces attributes of the driver of its region if it's of the version
+func (s *RegionStorage) SetAttributesIf(id int, attributes map[string]string, version uint64) (uint64, error) {
+	defer s.lock(id)()
+	i := s.owner(id)
+	if i < 0 {
+		return 0, ErrDriverDoesNotExist
+	}
+	return s.storages[i].SetAttributesIf(id, attributes, version)
+}
+
+// nextVersion returns the version of the update of the driver, it's incremented if status or attributes change.
+// Locations don't change the version, so they don't fail updates of dispatchers.
+func (d *Driver) nextVersion(update *Driver) uint64 {
+	if d.Status != update.Status || !sameAttributes(d.Attributes, update.Attributes) {
+		return d.Version + 1
+	}
+	return d.Version
+}
+
+// changedBy returns true if the update sets other status or attributes
+func (d *Driver) changedBy(update *Driver) bool {
+	return (update.Status != "" && update.Status != d.Status) ||
+		(update.Attributes != nil && !sameAttributes(d.Attributes, update.Attributes))
+}
+
+func sameAttributes(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersions(t *testing.T) {
+	for name, s := range map[string]Storage{
+		"single":  New(10),
+		"sharded": NewSharded(3, 10),
+	} {
+		v := s.(interface {
+			SetStatusIf(id int, status Status, version uint64) (uint64, error)
+			SetAttributesIf(id int, attributes map[string]string, version uint64) (uint64, error)
+		})
+		version := func() uint64 {
+			d, err := s.Get(1)
+			assert.NoError(t, err, name)
+			return d.Version
+		}
+		assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Timestamp: 1}), name)
+		assert.Equal(t, uint64(1), version(), name)
+		// locations don't change the version
+		assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1.1, Lon: 1}, Timestamp: 2}), name)
+		assert.Equal(t, uint64(1), version(), name)
+
+		n, err := v.SetStatusIf(1, StatusBusy, 1)
+		assert.NoError(t, err, name)
+		assert.Equal(t, uint64(2), n, name)
+		// the update of another dispatcher made on the first version fails
+		n, err = v.SetAttributesIf(1, map[string]string{"class": "comfort"}, 1)
+		assert.Equal(t, ErrVersionMismatch, err, name)
+		assert.Equal(t, uint64(2), n, name)
+		n, err = v.SetAttributesIf(1, map[string]string{"class": "comfort"}, 2)
+		assert.NoError(t, err, name)
+		assert.Equal(t, uint64(3), n, name)
+		n, err = v.SetAttributesIf(1, map[string]string{"class": "comfort"}, 0)
+		assert.NoError(t, err, name)
+		assert.Equal(t, uint64(3), n, name)
+
+		// locations changing status or attributes change the version
+		assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1.2, Lon: 1}, Status: StatusAvailable, Timestamp: 3}), name)
+		assert.Equal(t, uint64(4), version(), name)
+		d, err := s.Get(1)
+		assert.NoError(t, err, name)
+		assert.Equal(t, map[string]string{"class": "comfort"}, d.Attributes, name)
+
+		_, err = v.SetStatusIf(2, StatusBusy, 1)
+		assert.Equal(t, ErrDriverDoesNotExist, err, name)
+		_, err = v.SetStatusIf(1, "unknown", 0)
+		assert.Equal(t, ErrInvalidStatus, err, name)
+	}
+}
+
+func TestVersionsReplay(t *testing.T) {
+	dir := t.TempDir()
+	s := New(10)
+	assert.NoError(t, s.OpenWAL(dir))
+	assert.NoError(t, s.Set(&Driver{ID: 1, LastLocation: Location{Lat: 1, Lon: 1}, Timestamp: 1}))
+	_, err := s.SetStatusIf(1, StatusBusy, 1)
+	assert.NoError(t, err)
+	_, err = s.SetAttributesIf(1, map[string]string{"class": "comfort"}, 2)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Close())
+
+	restored := New(10)
+	assert.NoError(t, restored.OpenWAL(dir))
+	defer restored.Close()
+	d, err := restored.Get(1)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), d.Version)
+	assert.Equal(t, StatusBusy, d.Status)
+	assert.Equal(t, map[string]string{"class": "comfort"}, d.Attributes)
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const walExt = ".wal"
+
+type (
+	// walOp is a kind of mutation recorded in the WAL
+	walOp uint8
+
+	// walRecord is a single mutation recorded in the WAL
+	walRecord struct {
+		Op         walOp
+		ID         int
+		Location   Location
+		Attributes map[string]string
+		Status     Status
+		Expiration int64
+		Timestamp  int64
+		OffShift   bool
+		Anomaly    bool
+	}
+
+	// WAL is an append-only log of storage mutations split into segments.
+	// A new segment is started on every open and after every snapshot,
+	// so segments covered by a snapshot can be removed.
+	WAL struct {
+		dir  string
+		seq  uint64
+		file *os.File
+		enc  *gob.Encoder
+	}
+)
+
+const (
+	walSet walOp = iota + 1
+	walDelete
+	walStatus
+	walShift
+	walAttributes
+)
+
+// OpenWAL replays all WAL segments found in dir into the storage and starts
+// recording every Set and Delete to a new segment.
+// Call it after Load so the log is replayed on top of the snapshot.
+func (s *DriverStorage) OpenWAL(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "could not create WAL directory")
+	}
+	segments, err := walSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var seq uint64
+	for _, seg := range segments {
+		if err := s.replay(filepath.Join(dir, walSegmentName(seg))); err != nil {
+			return err
+		}
+		seq = seg
+	}
+
+	w := &WAL{dir: dir, seq: seq}
+	if err := w.rotate(); err != nil {
+		return err
+	}
+	s.wal = w
+	return nil
+}
+
+// Close stops recording mutations, syncs them to disk and closes the WAL.
+func (s *DriverStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wal == nil {
+		return nil
+	}
+	err := s.wal.file.Sync()
+	if e := s.wal.file.Close(); err == nil {
+		err = e
+	}
+	s.wal = nil
+	return errors.Wrap(err, "could not close WAL")
+}
+
+func (s *DriverStorage) replay(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "could not open WAL segment")
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(f)
+	for {
+		var r walRecord
+		err := dec.Decode(&r)
+		// a torn record at the tail is left by a crash in the middle of a write
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "could not decode WAL segment %s", path)
+		}
+
+		switch r.Op {
+		case walSet:
+			d := &Driver{
+				ID:           r.ID,
+				LastLocation: r.Location,
+				Attributes:   r.Attributes,
+				Status:       r.Status,
+				Expiration:   r.Expiration,
+				Timestamp:    r.Timestamp,
+			}
+			if r.Anomaly {
+				d.Anomalies, d.AnomalyAt = 1, r.Timestamp
+			}
+			err = s.set(d)
+		case walDelete:
+			err = s.delete(r.ID)
+			if err == ErrDriverDoesNotExist {
+				err = nil
+			}
+		case walStatus:
+			if d, ok := s.drivers[r.ID]; ok {
+				version := *d
+				version.Status = r.Status
+				version.Version = d.nextVersion(&version)
+				version.statusIdle(version.Timestamp)
+				s.publish(&version)
+			}
+		case walShift:
+			if d, ok := s.drivers[r.ID]; ok {
+				version := *d
+				version.setShift(r.OffShift, r.Timestamp)
+				s.publish(&version)
+			}
+		case walAttributes:
+			if d, ok := s.drivers[r.ID]; ok {
+				version := *d
+				version.Attributes = r.Attributes
+				version.Version = d.nextVersion(&version)
+				s.publish(&version)
+			}
+		}
+		if err != nil {
+			return errors.Wrap(err, "could not replay WAL")
+		}
+	}
+}
+
+func (w *WAL) append(r walRecord) error {
+	if err := w.enc.Encode(&r); err != nil {
+		return errors.Wrap(err, "could not write WAL record")
+	}
+	return errors.Wrap(w.file.Sync(), "could not sync WAL")
+}
+
+// rotate closes the current segment and starts the next one
+func (w *WAL) rotate() error {
+	f, err := os.OpenFile(filepath.Join(w.dir, walSegmentName(w.seq+1)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "could not create WAL segment")
+	}
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.seq++
+	w.file = f
+	w.enc = gob.NewEncoder(f)
+	return nil
+}
+
+// compact removes all segments older than seq
+func (w *WAL) compact(seq uint64) error {
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, seg := range segments {
+		if seg >= seq {
+			break
+		}
+		if err := os.Remove(filepath.Join(w.dir, walSegmentName(seg))); err != nil {
+			return errors.Wrap(err, "could not remove WAL segment")
+		}
+	}
+	return nil
+}
+
+func walSegmentName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, walExt)
+}
+
+// walSegments returns sequence numbers of segments in dir in ascending order
+func walSegments(dir string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read WAL directory")
+	}
+	var segments []uint64
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, walExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, walExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
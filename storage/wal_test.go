@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWALReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nearestdots")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := New(10)
+	assert.NoError(t, s.OpenWAL(dir))
+	for i := 0; i < 3; i++ {
+		s.Set(&Driver{
+			ID: 123,
+			LastLocation: Location{
+				Lat: 42.875799 + float64(i),
+				Lon: 74.588279,
+			},
+		})
+	}
+	assert.NoError(t, s.StopShift(123))
+	s.Set(&Driver{ID: 321})
+	assert.NoError(t, s.Delete(321))
+	assert.NoError(t, s.Close())
+
+	restored := New(10)
+	assert.NoError(t, restored.OpenWAL(dir))
+	defer restored.Close()
+
+	d, err := restored.Get(123)
+	assert.NoError(t, err)
+	assert.Equal(t, 44.875799, d.LastLocation.Lat)
+	assert.Equal(t, 3, d.Locations.Len())
+	assert.True(t, d.OffShift)
+	shifts, err := restored.Shifts(123)
+	assert.NoError(t, err)
+	if assert.Len(t, shifts, 1) {
+		assert.NotZero(t, shifts[0].End)
+	}
+
+	_, err = restored.Get(321)
+	assert.Equal(t, ErrDriverDoesNotExist, err)
+}
+
+func TestWALCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nearestdots")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "drivers.snapshot")
+	walDir := filepath.Join(dir, "wal")
+
+	s := New(10)
+	assert.NoError(t, s.OpenWAL(walDir))
+	s.Set(&Driver{ID: 123})
+	assert.NoError(t, s.Save(path))
+	s.Set(&Driver{ID: 321})
+	assert.NoError(t, s.Close())
+
+	segments, err := walSegments(walDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{2}, segments)
+
+	restored := New(10)
+	assert.NoError(t, restored.Load(path))
+	assert.NoError(t, restored.OpenWAL(walDir))
+	defer restored.Close()
+
+	_, err = restored.Get(123)
+	assert.NoError(t, err)
+	_, err = restored.Get(321)
+	assert.NoError(t, err)
+}
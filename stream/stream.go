@@ -0,0 +1,181 @@
+package stream
+
+import (
+	"sync"
+
+	"github.com/kdrake/nearestdots/storage"
+)
+
+// Update types
+const (
+	Moved   = "moved"
+	Removed = "removed"
+)
+
+type (
+	// Update is a driver location change or removal pushed to subscribers,
+	// Timestamp is unix nanoseconds of the location
+	Update struct {
+		Type      string            `json:"type"`
+		DriverID  int               `json:"driver_id"`
+		Location  *storage.Location `json:"location,omitempty"`
+		Timestamp int64             `json:"timestamp,omitempty"`
+	}
+
+	// Filter selects updates of a subscription: drivers inside the bounding box
+	// (min lat, min lon, max lat, max lon) or drivers with given ids.
+	// The box crosses the antimeridian if min lon is greater than max lon.
+	// Empty filter selects all drivers.
+	Filter struct {
+		Box *[4]float64
+		IDs []int
+	}
+
+	// Subscription receives updates selected by its filter until it's closed
+	Subscription struct {
+		hub     *Hub
+		filter  Filter
+		boxes   [][4]float64
+		ids     map[int]bool
+		inside  map[int]bool
+		updates chan Update
+	}
+
+	// Hub fans out storage changes to subscriptions.
+	// It observes the storage, so updates are pushed as drivers move.
+	Hub struct {
+		mu     sync.Mutex
+		subs   map[*Subscription]struct{}
+		buffer int
+	}
+)
+
+var _ storage.Observer = (*Hub)(nil)
+
+// NewHub creates Hub, every subscription buffers up to buffer updates
+// and drops newer ones while its buffer is full
+func NewHub(buffer int) *Hub {
+	return &Hub{
+		subs:   make(map[*Subscription]struct{}),
+		buffer: buffer,
+	}
+}
+
+// Subscribe creates subscription to updates selected by the filter
+func (h *Hub) Subscribe(f Filter) *Subscription {
+	s := &Subscription{
+		hub:     h,
+		filter:  f,
+		inside:  make(map[int]bool),
+		updates: make(chan Update, h.buffer),
+	}
+	if f.Box != nil {
+		s.boxes = storage.SplitBoundingBox(f.Box[0], f.Box[1], f.Box[2], f.Box[3])
+	}
+	if len(f.IDs) > 0 {
+		s.ids = make(map[int]bool, len(f.IDs))
+		for _, id := range f.IDs {
+			s.ids[id] = true
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[s] = struct{}{}
+	return s
+}
+
+// Updates returns channel of updates, it's closed when subscription is closed
+func (s *Subscription) Updates() <-chan Update {
+	return s.updates
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	if _, ok := s.hub.subs[s]; !ok {
+		return
+	}
+	delete(s.hub.subs, s)
+	close(s.updates)
+}
+
+// DriverMoved pushes the location to subscriptions of the driver
+func (h *Hub) DriverMoved(id int, location storage.Location, ts int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	u := Update{Type: Moved, DriverID: id, Location: &location, Timestamp: ts}
+	for s := range h.subs {
+		if s.moved(id, location) {
+			s.push(u)
+		}
+	}
+}
+
+// DriverRemoved pushes removal to subscriptions of the driver
+func (h *Hub) DriverRemoved(id int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	u := Update{Type: Removed, DriverID: id}
+	for s := range h.subs {
+		if s.removed(id) {
+			s.push(u)
+		}
+	}
+}
+
+// moved returns true if the subscription needs the location.
+// Drivers leaving the bounding box are pushed once more, so clients may drop them.
+func (s *Subscription) moved(id int, l storage.Location) bool {
+	if s.ids != nil && !s.ids[id] {
+		return false
+	}
+	if s.filter.Box == nil {
+		return true
+	}
+	if s.inBox(l) {
+		s.inside[id] = true
+		return true
+	}
+	if s.inside[id] {
+		delete(s.inside, id)
+		return true
+	}
+	return false
+}
+
+// inBox returns true if the location is inside the bounding box split at the antimeridian
+func (s *Subscription) inBox(l storage.Location) bool {
+	for _, b := range s.boxes {
+		if l.Lat >= b[0] && l.Lon >= b[1] && l.Lat <= b[2] && l.Lon <= b[3] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Subscription) removed(id int) bool {
+	if s.ids != nil && !s.ids[id] {
+		return false
+	}
+	if s.filter.Box == nil {
+		return true
+	}
+	if s.inside[id] {
+		delete(s.inside, id)
+		return true
+	}
+	return false
+}
+
+// push sends update without blocking the storage, it's called under the hub lock
+func (s *Subscription) push(u Update) {
+	select {
+	case s.updates <- u:
+	default:
+	}
+}
@@ -0,0 +1,63 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHub(t *testing.T) {
+	h := NewHub(10)
+	db := storage.New(10, storage.WithObserver(h))
+	all := h.Subscribe(Filter{})
+	box := h.Subscribe(Filter{Box: &[4]float64{0, 0, 1, 1}})
+	ids := h.Subscribe(Filter{IDs: []int{2}})
+
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 0.5, Lon: 0.5}}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 2, LastLocation: storage.Location{Lat: 2, Lon: 2}}))
+	// leaves the box
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 3, Lon: 3}}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 4, Lon: 4}}))
+	assert.NoError(t, db.Delete(2))
+
+	assert.Equal(t, []int{1, 2, 1, 1, 2}, drain(all))
+	assert.Equal(t, []int{1, 1}, drain(box))
+	assert.Equal(t, []int{2, 2}, drain(ids))
+
+	ids.Close()
+	ids.Close()
+	_, ok := <-ids.Updates()
+	assert.False(t, ok)
+
+	pacific := h.Subscribe(Filter{Box: &[4]float64{-20, 170, -10, -170}})
+	assert.NoError(t, db.Set(&storage.Driver{ID: 3, LastLocation: storage.Location{Lat: -15, Lon: 178}}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 4, LastLocation: storage.Location{Lat: -15, Lon: -178}}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 5, LastLocation: storage.Location{Lat: -15, Lon: 0}}))
+	assert.Equal(t, []int{3, 4}, drain(pacific))
+}
+
+func TestHubDrops(t *testing.T) {
+	h := NewHub(1)
+	s := h.Subscribe(Filter{})
+	h.DriverMoved(1, storage.Location{}, 1)
+	h.DriverMoved(1, storage.Location{}, 2)
+	h.DriverRemoved(1)
+
+	u := <-s.Updates()
+	assert.Equal(t, Moved, u.Type)
+	assert.Equal(t, int64(1), u.Timestamp)
+	assert.Empty(t, drain(s))
+}
+
+func drain(s *Subscription) []int {
+	var ids []int
+	for {
+		select {
+		case u := <-s.Updates():
+			ids = append(ids, u.DriverID)
+		default:
+			return ids
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Storage records a span of every operation of the wrapped storage
+// as a child of the span in the context
+type Storage struct {
+	ctx     context.Context
+	tracer  trace.Tracer
+	storage storage.Storage
+}
+
+var _ storage.Storage = (*Storage)(nil)
+
+// Wrap returns the storage tracing operations in ctx, usually of a request
+func Wrap(ctx context.Context, tracer trace.Tracer, s storage.Storage) *Storage {
+	return &Storage{ctx: ctx, tracer: tracer, storage: s}
+}
+
+func (s *Storage) start(op string, attrs ...attribute.KeyValue) trace.Span {
+	_, span := s.tracer.Start(s.ctx, "storage."+op,
+		trace.WithSpanKind(trace.SpanKindInternal), trace.WithAttributes(attrs...))
+	return span
+}
+
+// end records the error and ends the span
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Set implements storage.Storage
+func (s *Storage) Set(driver *storage.Driver) error {
+	span := s.start("Set", attribute.Int("driver.id", driver.ID))
+	err := s.storage.Set(driver)
+	end(span, err)
+	return err
+}
+
+// SetMany implements storage.Storage
+func (s *Storage) SetMany(drivers []*storage.Driver) error {
+	span := s.start("SetMany", attribute.Int("drivers.count", len(drivers)))
+	err := s.storage.SetMany(drivers)
+	end(span, err)
+	return err
+}
+
+// Get implements storage.Storage
+func (s *Storage) Get(id int) (*storage.Driver, error) {
+	span := s.start("Get", attribute.Int("driver.id", id))
+	d, err := s.storage.Get(id)
+	end(span, err)
+	return d, err
+}
+
+// List implements storage.Storage
+func (s *Storage) List(after, limit int) []*storage.Driver {
+	span := s.start("List", attribute.Int("after", after), attribute.Int("limit", limit))
+	drivers := s.storage.List(after, limit)
+	span.SetAttributes(attribute.Int("drivers.count", len(drivers)))
+	end(span, nil)
+	return drivers
+}
+
+// History implements storage.Storage
+func (s *Storage) History(id int, from, to int64) ([]storage.HistoryPoint, error) {
+	span := s.start("History", attribute.Int("driver.id", id))
+	points, err := s.storage.History(id, from, to)
+	span.SetAttributes(attribute.Int("points.count", len(points)))
+	end(span, err)
+	return points, err
+}
+
+// Delete implements storage.Storage
+func (s *Storage) Delete(id int) error {
+	span := s.start("Delete", attribute.Int("driver.id", id))
+	err := s.storage.Delete(id)
+	end(span, err)
+	return err
+}
+
+// DeleteMany implements storage.Storage
+func (s *Storage) DeleteMany(ids []int) error {
+	span := s.start("DeleteMany", attribute.Int("drivers.count", len(ids)))
+	err := s.storage.DeleteMany(ids)
+	end(span, err)
+	return err
+}
+
+// SetStatus implements storage.Storage
+func (s *Storage) SetStatus(id int, status storage.Status) error {
+	span := s.start("SetStatus", attribute.Int("driver.id", id), attribute.String("driver.status", string(status)))
+	err := s.storage.SetStatus(id, status)
+	end(span, err)
+	return err
+}
+
+// Nearest implements storage.Storage
+func (s *Storage) Nearest(point rtreego.Point, count int, filters ...storage.Filter) []*storage.Driver {
+	span := s.start("Nearest", attribute.Int("count", count), attribute.Int("filters.count", len(filters)))
+	drivers := s.storage.Nearest(point, count, filters...)
+	span.SetAttributes(attribute.Int("drivers.count", len(drivers)))
+	end(span, nil)
+	return drivers
+}
+
+// NearestAndLock implements storage.Storage
+func (s *Storage) NearestAndLock(point rtreego.Point, count int, ttl time.Duration, filters ...storage.Filter) []*storage.Driver {
+	span := s.start("NearestAndLock", attribute.Int("count", count), attribute.Int("filters.count", len(filters)))
+	drivers := s.storage.NearestAndLock(point, count, ttl, filters...)
+	span.SetAttributes(attribute.Int("drivers.count", len(drivers)))
+	end(span, nil)
+	return drivers
+}
+
+// InBoundingBox implements storage.Storage
+func (s *Storage) InBoundingBox(minLat, minLon, maxLat, maxLon float64) ([]*storage.Driver, error) {
+	span := s.start("InBoundingBox")
+	drivers, err := s.storage.InBoundingBox(minLat, minLon, maxLat, maxLon)
+	span.SetAttributes(attribute.Int("drivers.count", len(drivers)))
+	end(span, err)
+	return drivers, err
+}
+
+// InPolygon implements storage.Storage
+func (s *Storage) InPolygon(polygon storage.Polygon) ([]*storage.Driver, error) {
+	span := s.start("InPolygon")
+	drivers, err := s.storage.InPolygon(polygon)
+	span.SetAttributes(attribute.Int("drivers.count", len(drivers)))
+	end(span, err)
+	return drivers, err
+}
+
+// Heatmap implements storage.Storage
+func (s *Storage) Heatmap(precision int) ([]storage.HeatmapCell, error) {
+	span := s.start("Heatmap", attribute.Int("precision", precision))
+	cells, err := s.storage.Heatmap(precision)
+	end(span, err)
+	return cells, err
+}
+
+// DeleteExpired implements storage.Storage
+func (s *Storage) DeleteExpired() {
+	span := s.start("DeleteExpired")
+	s.storage.DeleteExpired()
+	end(span, nil)
+}
+
+// Len implements storage.Storage
+func (s *Storage) Len() int {
+	return s.storage.Len()
+}
+
+// Stats implements storage.Storage
+func (s *Storage) Stats() storage.Stats {
+	span := s.start("Stats")
+	stats := s.storage.Stats()
+	end(span, nil)
+	return stats
+}
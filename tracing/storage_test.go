@@ -0,0 +1,37 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStorage(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	ctx, parent := tracer.Start(context.Background(), "request")
+
+	s := Wrap(ctx, tracer, storage.New(10))
+	assert.NoError(t, s.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}}))
+	assert.Len(t, s.Nearest(rtreego.Point{1, 1}, 5), 1)
+	_, err := s.Get(2)
+	assert.Equal(t, storage.ErrDriverDoesNotExist, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 4)
+	for _, span := range spans[:3] {
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+	assert.Equal(t, "storage.Nearest", spans[1].Name())
+	assert.Contains(t, spans[1].Attributes(), attribute.Int("drivers.count", 1))
+	assert.Equal(t, "storage.Get", spans[2].Name())
+	assert.Equal(t, codes.Error, spans[2].Status().Code)
+}
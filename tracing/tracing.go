@@ -0,0 +1,69 @@
+// Package tracing exports OpenTelemetry spans of API requests and storage operations
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.25.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pkg/errors"
+)
+
+// instrumentation names the tracer of the service
+const instrumentation = "github.com/kdrake/nearestdots"
+
+// Config of the OTLP exporter
+type Config struct {
+	// Endpoint is host:port of the OTLP/HTTP collector
+	Endpoint string
+	// Insecure sends spans over plain HTTP
+	Insecure bool
+	// Ratio of sampled traces, traces of sampled parents are always sampled
+	Ratio float64
+	// Service is service.name of spans
+	Service string
+}
+
+// Provider exports spans to the collector
+type Provider struct {
+	provider *sdktrace.TracerProvider
+}
+
+// Start exports spans to the collector of the config in background.
+// Trace context of requests is propagated in W3C traceparent headers.
+func Start(ctx context.Context, config Config) (*Provider, error) {
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Endpoint)}
+	if config.Insecure {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create OTLP exporter")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.Ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(config.Service))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+	return &Provider{provider: provider}, nil
+}
+
+// Tracer returns tracer of the service
+func (p *Provider) Tracer() trace.Tracer {
+	return p.provider.Tracer(instrumentation)
+}
+
+// Shutdown exports buffered spans until ctx is done and stops the exporter
+func (p *Provider) Shutdown(ctx context.Context) error {
+	return errors.Wrap(p.provider.Shutdown(ctx), "could not flush spans")
+}
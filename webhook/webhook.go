@@ -0,0 +1,372 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kdrake/nearestdots/geofence"
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Event types
+const (
+	Appeared     = "driver.appeared"
+	Offline      = "driver.offline"
+	Expired      = "driver.expired"
+	FenceEntered = "geofence.entered"
+)
+
+// Headers of callbacks
+const (
+	EventHeader     = "X-Nearestdots-Event"
+	DeliveryHeader  = "X-Nearestdots-Delivery"
+	SignatureHeader = "X-Nearestdots-Signature"
+)
+
+// deliveryTimeout limits a single delivery attempt
+const deliveryTimeout = 5 * time.Second
+
+// pendingDeliveries is how many deliveries may wait for workers
+const pendingDeliveries = 1024
+
+// workers is number of concurrent deliveries
+const workers = 4
+
+var (
+	// ErrInvalidEndpoint sign what endpoint has no http(s) URL, no secret or unknown event types
+	ErrInvalidEndpoint = errors.New("Invalid webhook endpoint")
+	// ErrEndpointDoesNotExist sign what endpoint is not registered
+	ErrEndpointDoesNotExist = errors.New("Webhook endpoint does not exist")
+)
+
+var eventTypes = map[string]bool{Appeared: true, Offline: true, Expired: true, FenceEntered: true}
+
+type (
+	// Endpoint receives callbacks of the event types, all types if Events is empty.
+	// Bodies are signed with the secret, it's not listed.
+	Endpoint struct {
+		ID     string   `json:"id"`
+		URL    string   `json:"url"`
+		Secret string   `json:"secret,omitempty"`
+		Events []string `json:"events,omitempty"`
+	}
+	// Event is a driver lifecycle change, Timestamp is unix nanoseconds
+	// of the location or of the change if the driver has no new location
+	Event struct {
+		ID        uint64            `json:"id"`
+		Type      string            `json:"type"`
+		DriverID  int               `json:"driver_id"`
+		Location  *storage.Location `json:"location,omitempty"`
+		Fence     string            `json:"fence,omitempty"`
+		Timestamp int64             `json:"timestamp"`
+	}
+	// DeadLetter is an event not delivered to the endpoint after all attempts
+	DeadLetter struct {
+		Seq      uint64 `json:"seq"`
+		Endpoint string `json:"endpoint"`
+		URL      string `json:"url"`
+		Event    Event  `json:"event"`
+		Attempts int    `json:"attempts"`
+		Error    string `json:"error"`
+	}
+
+	// Manager keeps endpoints and posts events to them, retrying failed deliveries with exponential backoff.
+	// It observes the storage and handles geofence events.
+	Manager struct {
+		mu        sync.RWMutex
+		endpoints map[string]*Endpoint
+		dead      []DeadLetter
+		maxDead   int
+		deadSeq   uint64
+		seq       uint64
+		attempts  int
+		backoff   time.Duration
+		client    *http.Client
+		pending   chan *delivery
+		done      chan struct{}
+		wg        sync.WaitGroup
+	}
+
+	delivery struct {
+		endpoint Endpoint
+		event    Event
+		body     []byte
+		attempts int
+	}
+)
+
+var _ storage.LifecycleObserver = (*Manager)(nil)
+
+// New creates Manager making up to attempts deliveries of an event, the first retry is after backoff
+// and every next one waits twice as long. Up to maxDead latest dead letters are kept.
+func New(attempts int, backoff time.Duration, maxDead int) *Manager {
+	m := &Manager{
+		endpoints: make(map[string]*Endpoint),
+		maxDead:   maxDead,
+		attempts:  attempts,
+		backoff:   backoff,
+		client:    &http.Client{Timeout: deliveryTimeout},
+		pending:   make(chan *delivery, pendingDeliveries),
+		done:      make(chan struct{}),
+	}
+	m.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go m.work()
+	}
+	return m
+}
+
+// SetRetry changes attempts and backoff of deliveries, scheduled retries keep their delays
+func (m *Manager) SetRetry(attempts int, backoff time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts = attempts
+	m.backoff = backoff
+}
+
+// Close stops deliveries, pending and scheduled retries are dropped
+func (m *Manager) Close() {
+	close(m.done)
+	m.wg.Wait()
+}
+
+// Register adds the endpoint with a new id
+func (m *Manager) Register(e Endpoint) (Endpoint, error) {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || e.Secret == "" {
+		return e, ErrInvalidEndpoint
+	}
+	for _, t := range e.Events {
+		if !eventTypes[t] {
+			return e, ErrInvalidEndpoint
+		}
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	e.ID = hex.EncodeToString(b)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.endpoints[e.ID] = &e
+	return e, nil
+}
+
+// Unregister removes the endpoint, its scheduled retries are still made
+func (m *Manager) Unregister(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.endpoints[id]; !ok {
+		return ErrEndpointDoesNotExist
+	}
+	delete(m.endpoints, id)
+	return nil
+}
+
+// Endpoints returns all endpoints without secrets
+func (m *Manager) Endpoints() []Endpoint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	endpoints := make([]Endpoint, 0, len(m.endpoints))
+	for _, e := range m.endpoints {
+		e := *e
+		e.Secret = ""
+		endpoints = append(endpoints, e)
+	}
+	return endpoints
+}
+
+// DeadLetters returns up to limit kept dead letters with Seq greater than after
+func (m *Manager) DeadLetters(after uint64, limit int) []DeadLetter {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dead := []DeadLetter{}
+	for _, d := range m.dead {
+		if len(dead) >= limit {
+			break
+		}
+		if d.Seq > after {
+			dead = append(dead, d)
+		}
+	}
+	return dead
+}
+
+// DriverAppeared sends appeared events of new drivers
+func (m *Manager) DriverAppeared(id int, location storage.Location, ts int64) {
+	m.send(Event{Type: Appeared, DriverID: id, Location: &location, Timestamp: ts})
+}
+
+// DriverMoved does nothing, moves are streamed instead
+func (m *Manager) DriverMoved(int, storage.Location, int64) {}
+
+// DriverRemoved does nothing, drivers are removed by clients
+func (m *Manager) DriverRemoved(int) {}
+
+// DriverExpired sends expired events
+func (m *Manager) DriverExpired(id int) {
+	m.send(Event{Type: Expired, DriverID: id, Timestamp: time.Now().UnixNano()})
+}
+
+// DriverStatusChanged sends offline events of drivers going offline
+func (m *Manager) DriverStatusChanged(id int, status storage.Status) {
+	if status == storage.StatusOffline {
+		m.send(Event{Type: Offline, DriverID: id, Timestamp: time.Now().UnixNano()})
+	}
+}
+
+// FenceEvent is a geofence handler sending entered events
+func (m *Manager) FenceEvent(e geofence.Event) {
+	if e.Type == geofence.Enter {
+		location := e.Location
+		m.send(Event{Type: FenceEntered, DriverID: e.DriverID, Location: &location, Fence: e.Fence, Timestamp: e.Timestamp})
+	}
+}
+
+// send queues the event for endpoints of its type, it's called under the storage lock
+func (m *Manager) send(e Event) {
+	m.mu.Lock()
+	m.seq++
+	e.ID = m.seq
+	var endpoints []Endpoint
+	for _, ep := range m.endpoints {
+		if ep.accepts(e.Type) {
+			endpoints = append(endpoints, *ep)
+		}
+	}
+	m.mu.Unlock()
+	if len(endpoints) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		zap.L().Error("could not encode webhook event", zap.Error(err))
+		return
+	}
+	for _, ep := range endpoints {
+		d := &delivery{endpoint: ep, event: e, body: body}
+		if !m.enqueue(d) {
+			m.bury(d, "delivery queue is full")
+		}
+	}
+}
+
+func (e *Endpoint) accepts(typ string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) enqueue(d *delivery) bool {
+	select {
+	case m.pending <- d:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Manager) work() {
+	defer m.wg.Done()
+	for {
+		select {
+		case d := <-m.pending:
+			m.deliver(d)
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// deliver posts the event, failed deliveries are retried after backoff or buried after the last attempt
+func (m *Manager) deliver(d *delivery) {
+	err := m.post(d)
+	if err == nil {
+		return
+	}
+	m.mu.RLock()
+	attempts, backoff := m.attempts, m.backoff
+	m.mu.RUnlock()
+	d.attempts++
+	if d.attempts >= attempts {
+		m.bury(d, err.Error())
+		return
+	}
+	zap.L().Debug("webhook delivery failed", zap.String("endpoint", d.endpoint.ID), zap.Uint64("event", d.event.ID), zap.Error(err))
+	time.AfterFunc(backoff<<(d.attempts-1), func() {
+		select {
+		case <-m.done:
+		default:
+			if !m.enqueue(d) {
+				m.bury(d, "delivery queue is full")
+			}
+		}
+	})
+}
+
+func (m *Manager) post(d *delivery) error {
+	req, err := http.NewRequest(http.MethodPost, d.endpoint.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.event.Type)
+	req.Header.Set(DeliveryHeader, d.endpoint.ID+"-"+strconv.FormatUint(d.event.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(d.endpoint.Secret, d.body))
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.New("endpoint replied " + resp.Status)
+	}
+	return nil
+}
+
+// bury keeps the undelivered event as a dead letter
+func (m *Manager) bury(d *delivery, reason string) {
+	zap.L().Warn("could not deliver webhook event", zap.String("endpoint", d.endpoint.ID), zap.Uint64("event", d.event.ID), zap.String("error", reason))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.deadSeq++
+	m.dead = append(m.dead, DeadLetter{
+		Seq:      m.deadSeq,
+		Endpoint: d.endpoint.ID,
+		URL:      d.endpoint.URL,
+		Event:    d.event,
+		Attempts: d.attempts,
+		Error:    reason,
+	})
+	if len(m.dead) > m.maxDead {
+		m.dead = m.dead[len(m.dead)-m.maxDead:]
+	}
+}
+
+// Sign returns the signature header value of the body: sha256= and hex of its HMAC-SHA256 with the secret.
+// Receivers compute it the same way and compare it with hmac.Equal.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kdrake/nearestdots/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManager(t *testing.T) {
+	received := make(chan Event, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, Sign("secret", body), r.Header.Get(SignatureHeader))
+		var e Event
+		assert.NoError(t, json.Unmarshal(body, &e))
+		assert.Equal(t, e.Type, r.Header.Get(EventHeader))
+		received <- e
+	}))
+	defer server.Close()
+
+	m := New(3, time.Millisecond, 10)
+	defer m.Close()
+	_, err := m.Register(Endpoint{URL: "ftp://example.com", Secret: "secret"})
+	assert.Equal(t, ErrInvalidEndpoint, err)
+	_, err = m.Register(Endpoint{URL: server.URL, Secret: "secret", Events: []string{"driver.moved"}})
+	assert.Equal(t, ErrInvalidEndpoint, err)
+	e, err := m.Register(Endpoint{URL: server.URL, Secret: "secret", Events: []string{Appeared, Offline}})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, e.ID)
+	assert.Equal(t, []Endpoint{{ID: e.ID, URL: server.URL, Events: []string{Appeared, Offline}}}, m.Endpoints())
+
+	db := storage.New(10, storage.WithObserver(m))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 1, Lon: 1}, Timestamp: 1}))
+	assert.NoError(t, db.Set(&storage.Driver{ID: 1, LastLocation: storage.Location{Lat: 2, Lon: 1}, Timestamp: 2}))
+	assert.NoError(t, db.SetStatus(1, storage.StatusOffline))
+
+	// deliveries are concurrent, so events may arrive in any order
+	got := map[string]Event{}
+	for i := 0; i < 2; i++ {
+		e := receive(t, received)
+		got[e.Type] = e
+	}
+	assert.Equal(t, 1, got[Appeared].DriverID)
+	assert.Equal(t, &storage.Location{Lat: 1, Lon: 1}, got[Appeared].Location)
+	assert.Equal(t, 1, got[Offline].DriverID)
+	assert.Len(t, received, 0)
+
+	assert.NoError(t, m.Unregister(e.ID))
+	assert.Equal(t, ErrEndpointDoesNotExist, m.Unregister(e.ID))
+}
+
+func TestDeadLetters(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	m := New(3, time.Millisecond, 10)
+	defer m.Close()
+	e, err := m.Register(Endpoint{URL: server.URL, Secret: "secret"})
+	assert.NoError(t, err)
+	m.DriverExpired(1)
+
+	assert.Eventually(t, func() bool { return len(m.DeadLetters(0, 10)) == 1 }, time.Second, time.Millisecond)
+	dead := m.DeadLetters(0, 10)[0]
+	assert.Equal(t, e.ID, dead.Endpoint)
+	assert.Equal(t, Expired, dead.Event.Type)
+	assert.Equal(t, 3, dead.Attempts)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	assert.Empty(t, m.DeadLetters(dead.Seq, 10))
+
+	m.SetRetry(1, time.Millisecond)
+	m.DriverExpired(2)
+	assert.Eventually(t, func() bool { return len(m.DeadLetters(dead.Seq, 10)) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, 1, m.DeadLetters(dead.Seq, 10)[0].Attempts)
+}
+
+func receive(t *testing.T, events chan Event) Event {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event delivered")
+		return Event{}
+	}
+}